@@ -32,6 +32,7 @@ import (
 	fprobes "github.com/redhat-cip/skydive/flow/probes"
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/packet_injector"
 	"github.com/redhat-cip/skydive/storage/etcd"
 	"github.com/redhat-cip/skydive/topology/graph"
 	tprobes "github.com/redhat-cip/skydive/topology/probes"
@@ -49,6 +50,7 @@ type Agent struct {
 	OnDemandProbeListener *fprobes.OnDemandProbeListener
 	HTTPServer            *shttp.Server
 	EtcdClient            *etcd.EtcdClient
+	Heartbeat             *Heartbeat
 }
 
 func (a *Agent) Start() {
@@ -56,23 +58,41 @@ func (a *Agent) Start() {
 
 	go a.WSServer.ListenAndServe()
 
-	addr, port, err := config.GetAnalyzerClientAddr()
+	analyzers, err := config.GetAnalyzerClientAddrs()
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to parse analyzer client %s", err.Error())
 		os.Exit(1)
 	}
 
+	var addr string
+	var port int
+	if len(analyzers) > 0 {
+		addr, port = analyzers[0].Addr, analyzers[0].Port
+	}
+
 	if addr != "" {
 		authOptions := &shttp.AuthenticationOpts{
 			Username: config.GetConfig().GetString("agent.analyzer_username"),
 			Password: config.GetConfig().GetString("agent.analyzer_password"),
 		}
+		tlsConfig, err := shttp.TLSConfigFromConfig("agent")
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to load the agent TLS configuration: %s", err.Error())
+			os.Exit(1)
+		}
+
 		authClient := shttp.NewAuthenticationClient(addr, port, authOptions)
+		authClient.TLSConfig = tlsConfig
 		a.WSClient, err = shttp.NewWSAsyncClient(addr, port, "/ws", authClient)
 		if err != nil {
 			logging.GetLogger().Errorf("Unable to instantiate analyzer client %s", err.Error())
 			os.Exit(1)
 		}
+		a.WSClient.TLSConfig = tlsConfig
+		a.WSClient.Type = shttp.AgentClient
+		for _, sa := range analyzers[1:] {
+			a.WSClient.AddAlternateServer(sa.Addr, sa.Port)
+		}
 
 		graph.NewForwarder(a.WSClient, a.Graph)
 		a.WSClient.Connect()
@@ -80,6 +100,9 @@ func (a *Agent) Start() {
 		// expose a flow server through the client connection
 		flow.NewServer(a.FlowTableAlloctor, a.WSClient)
 
+		// inject the packets requested by the analyzers
+		packet_injector.NewServer(a.Graph, a.WSClient)
+
 		// send a first reset event to the analyzers
 		a.Graph.DelSubGraph(a.Root)
 	}
@@ -90,6 +113,21 @@ func (a *Agent) Start() {
 	a.FlowProbeBundle = fprobes.NewFlowProbeBundleFromConfig(a.TopologyProbeBundle, a.Graph, a.FlowTableAlloctor)
 	a.FlowProbeBundle.Start()
 
+	if a.WSClient != nil && a.FlowProbeBundle.AnalyzerClient != nil {
+		a.FlowProbeBundle.AnalyzerClient.FollowWSClient(a.WSClient)
+	}
+
+	var probes []string
+	for name := range a.TopologyProbeBundle.Probes {
+		probes = append(probes, name)
+	}
+	for name := range a.FlowProbeBundle.Probes {
+		probes = append(probes, name)
+	}
+	if a.Heartbeat = NewHeartbeatFromConfig(a.Graph, a.Root, probes); a.Heartbeat != nil {
+		a.Heartbeat.Start()
+	}
+
 	if addr != "" {
 		a.EtcdClient, err = etcd.NewEtcdClientFromConfig()
 		if err != nil {
@@ -102,7 +140,7 @@ func (a *Agent) Start() {
 			EtcdKeyAPI:      a.EtcdClient.KeysApi,
 		}
 
-		l, err := fprobes.NewOnDemandProbeListener(a.FlowProbeBundle, a.Graph, captureHandler)
+		l, err := fprobes.NewOnDemandProbeListener(a.FlowProbeBundle, a.Graph, captureHandler, a.WSClient)
 		if err != nil {
 			logging.GetLogger().Errorf("Unable to start on-demand flow probe %s", err.Error())
 			os.Exit(1)
@@ -115,6 +153,9 @@ func (a *Agent) Start() {
 }
 
 func (a *Agent) Stop() {
+	if a.Heartbeat != nil {
+		a.Heartbeat.Stop()
+	}
 	a.FlowProbeBundle.UnregisterAllProbes()
 	a.FlowProbeBundle.Stop()
 	a.TopologyProbeBundle.Stop()
@@ -142,7 +183,7 @@ func NewAgent() *Agent {
 		panic(err)
 	}
 
-	g, err := graph.NewGraph(backend)
+	g, err := graph.NewGraphFromConfig(backend)
 	if err != nil {
 		panic(err)
 	}
@@ -173,11 +214,13 @@ func NewAgent() *Agent {
 	}
 	root := g.NewNode(graph.Identifier(hostname), m)
 
-	api.RegisterTopologyApi("agent", g, hserver)
-
 	gserver := graph.NewServer(g, wsServer)
 
+	api.RegisterTopologyApi("agent", g, gserver, hserver)
+	api.RegisterLoggingApi("agent", hserver)
+
 	fta := flow.NewTableAllocator()
+	fta.Budget = flow.BudgetFromConfig()
 
 	return &Agent{
 		Graph:             g,
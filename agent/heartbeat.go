@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package agent
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/version"
+)
+
+// Heartbeat publishes the status of the agent, its version, uptime,
+// running probes, active captures and packets dropped by the captures, in
+// the Agent metadata of its host node every Interval
+type Heartbeat struct {
+	Graph    *graph.Graph
+	Root     *graph.Node
+	Probes   []string
+	Interval time.Duration
+	started  time.Time
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+func (h *Heartbeat) beat(now time.Time) {
+	h.Graph.Lock()
+	defer h.Graph.Unlock()
+
+	var captures, dropped int64
+	for _, n := range h.Graph.GetNodes() {
+		if n.Metadata()["State.FlowCapture"] != "ON" {
+			continue
+		}
+		captures++
+		if d, ok := n.Metadata()["Capture.PacketsDropped"].(int64); ok {
+			dropped += d
+		}
+	}
+
+	tr := h.Graph.StartMetadataTransaction(h.Root)
+	tr.AddMetadata("Agent.Version", version.Version)
+	tr.AddMetadata("Agent.Uptime", int64(now.Sub(h.started).Seconds()))
+	tr.AddMetadata("Agent.Heartbeat", now.Unix())
+	tr.AddMetadata("Agent.Probes", strings.Join(h.Probes, ","))
+	tr.AddMetadata("Agent.Captures", captures)
+	tr.AddMetadata("Agent.PacketsDropped", dropped)
+	tr.Commit()
+}
+
+func (h *Heartbeat) run() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+
+	h.beat(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			h.beat(now)
+		case <-h.quit:
+			return
+		}
+	}
+}
+
+func (h *Heartbeat) Start() {
+	h.quit = make(chan bool)
+	h.wg.Add(1)
+	go h.run()
+}
+
+func (h *Heartbeat) Stop() {
+	if h.quit == nil {
+		return
+	}
+	close(h.quit)
+	h.wg.Wait()
+	h.quit = nil
+}
+
+func NewHeartbeat(g *graph.Graph, root *graph.Node, probes []string, interval time.Duration) *Heartbeat {
+	sort.Strings(probes)
+
+	return &Heartbeat{
+		Graph:    g,
+		Root:     root,
+		Probes:   probes,
+		Interval: interval,
+		started:  time.Now(),
+	}
+}
+
+func NewHeartbeatFromConfig(g *graph.Graph, root *graph.Node, probes []string) *Heartbeat {
+	interval := time.Duration(config.GetConfig().GetInt("agent.heartbeat_interval")) * time.Second
+	if interval <= 0 {
+		return nil
+	}
+	return NewHeartbeat(g, root, probes, interval)
+}
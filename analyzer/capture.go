@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/redhat-cip/skydive/api"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+type captureQuery struct {
+	capture  *api.Capture
+	sequence *graph.GremlinTraversalSequence
+	nodes    map[graph.Identifier]*graph.Node
+}
+
+// CaptureManager evaluates the Gremlin queries of the captures as the graph
+// changes and asks the agents owning the matching nodes to start or stop
+// the captures over their WebSocket connection. Only the captures affected
+// by a mutation are evaluated again: the queries only filtering the nodes,
+// ex: G.V().Has('Type', 'veth'), are evaluated on the mutated node only,
+// the other ones on the mutations of the edges and of the nodes they are
+// capturing or selected by their first steps, ex: G.V().Has('Name', 'br-int')
+// for G.V().Has('Name', 'br-int').Out().
+type CaptureManager struct {
+	graph.DefaultGraphListener
+	Graph          *graph.Graph
+	WSServer       *shttp.WSServer
+	CaptureHandler api.ApiHandler
+	watcher        api.StoppableWatcher
+	// protected by the graph lock
+	captures map[string]*captureQuery
+}
+
+func (m *CaptureManager) send(t string, n *graph.Node, capture *api.Capture) {
+	if n.Host() == "" {
+		return
+	}
+
+	b, _ := json.Marshal(&api.CaptureRequest{NodeID: string(n.ID), Capture: capture})
+	raw := json.RawMessage(b)
+
+	msg := shttp.WSMessage{
+		Namespace: api.CaptureNamespace,
+		Type:      t,
+		Obj:       &raw,
+	}
+
+	if !m.WSServer.SendWSMessageTo(msg, n.Host()) {
+		logging.GetLogger().Errorf("Unable to send capture request to agent: %s", n.Host())
+	}
+}
+
+func (m *CaptureManager) eval(q *captureQuery) {
+	res, err := q.sequence.Exec()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to evaluate the capture query %s: %s", q.capture.GremlinQuery, err.Error())
+		return
+	}
+
+	nodes := make(map[graph.Identifier]*graph.Node)
+	for _, v := range res.Values() {
+		if n, ok := v.(*graph.Node); ok {
+			nodes[n.ID] = n
+		}
+	}
+
+	for id, n := range nodes {
+		if _, ok := q.nodes[id]; !ok {
+			m.send("CaptureStart", n, q.capture)
+		}
+	}
+
+	for id, n := range q.nodes {
+		if _, ok := nodes[id]; !ok {
+			m.send("CaptureStop", n, q.capture)
+		}
+	}
+	q.nodes = nodes
+}
+
+// evalNode starts or stops the capture of a node filter query on the node
+func (m *CaptureManager) evalNode(q *captureQuery, n *graph.Node) {
+	_, captured := q.nodes[n.ID]
+	matched := q.sequence.MatchNode(n)
+
+	if matched && !captured {
+		q.nodes[n.ID] = n
+		m.send("CaptureStart", n, q.capture)
+	} else if !matched && captured {
+		delete(q.nodes, n.ID)
+		m.send("CaptureStop", n, q.capture)
+	}
+}
+
+// affectedBy returns whether a mutation of the node may change the result
+// of a query not only filtering the nodes
+func (q *captureQuery) affectedBy(n *graph.Node) bool {
+	_, captured := q.nodes[n.ID]
+	return captured || q.sequence.MatchNode(n)
+}
+
+func (m *CaptureManager) onNodeChanged(n *graph.Node) {
+	for _, q := range m.captures {
+		if q.sequence.NodeFilter() {
+			m.evalNode(q, n)
+		} else if q.affectedBy(n) {
+			m.eval(q)
+		}
+	}
+}
+
+func (m *CaptureManager) onEdgeChanged(e *graph.Edge) {
+	for _, q := range m.captures {
+		if !q.sequence.NodeFilter() {
+			m.eval(q)
+		}
+	}
+}
+
+func (m *CaptureManager) OnNodeUpdated(n *graph.Node) {
+	m.onNodeChanged(n)
+}
+
+func (m *CaptureManager) OnNodeAdded(n *graph.Node) {
+	m.onNodeChanged(n)
+}
+
+// OnNodeDeleted forgets the node, its capture being stopped by its agent
+func (m *CaptureManager) OnNodeDeleted(n *graph.Node) {
+	for _, q := range m.captures {
+		affected := !q.sequence.NodeFilter() && q.affectedBy(n)
+		delete(q.nodes, n.ID)
+		if affected {
+			m.eval(q)
+		}
+	}
+}
+
+func (m *CaptureManager) OnEdgeAdded(e *graph.Edge) {
+	m.onEdgeChanged(e)
+}
+
+func (m *CaptureManager) OnEdgeDeleted(e *graph.Edge) {
+	m.onEdgeChanged(e)
+}
+
+func (m *CaptureManager) setCapture(id string, capture *api.Capture) {
+	m.Graph.Lock()
+	defer m.Graph.Unlock()
+
+	m.deleteCapture(id)
+
+	tr := graph.NewGremlinTraversalParser(strings.NewReader(capture.GremlinQuery), m.Graph)
+	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
+
+	seq, err := tr.Parse()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to parse the capture query %s: %s", capture.GremlinQuery, err.Error())
+		return
+	}
+
+	q := &captureQuery{capture: capture, sequence: seq, nodes: make(map[graph.Identifier]*graph.Node)}
+	m.captures[id] = q
+	m.eval(q)
+}
+
+// deleteCapture stops the capture on all its nodes, must be called with
+// the graph lock held
+func (m *CaptureManager) deleteCapture(id string) {
+	if q, ok := m.captures[id]; ok {
+		for _, n := range q.nodes {
+			m.send("CaptureStop", n, q.capture)
+		}
+		delete(m.captures, id)
+	}
+}
+
+func (m *CaptureManager) onApiWatcherEvent(action string, id string, resource api.ApiResource) {
+	switch action {
+	case "init", "create", "set", "update":
+		// the captures of a probe path are watched by the agents
+		if capture := resource.(*api.Capture); capture.GremlinQuery != "" {
+			m.setCapture(id, capture)
+		}
+	case "expire", "delete":
+		m.Graph.Lock()
+		m.deleteCapture(id)
+		m.Graph.Unlock()
+	}
+}
+
+func (m *CaptureManager) Start() {
+	m.watcher = m.CaptureHandler.AsyncWatch(m.onApiWatcherEvent)
+
+	m.Graph.AddEventListenerWithPriority(m, graph.ListenerPriorityFromConfig("capture", graph.DefaultListenerPriority))
+}
+
+func (m *CaptureManager) Stop() {
+	if m.watcher != nil {
+		m.watcher.Stop()
+	}
+
+	m.Graph.RemoveEventListener(m)
+}
+
+func NewCaptureManager(g *graph.Graph, w *shttp.WSServer, ch api.ApiHandler) *CaptureManager {
+	return &CaptureManager{
+		Graph:          g,
+		WSServer:       w,
+		CaptureHandler: ch,
+		captures:       make(map[string]*captureQuery),
+	}
+}
@@ -25,26 +25,45 @@ package analyzer
 import (
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
 )
 
 type Client struct {
+	sync.RWMutex
 	Addr string
 	Port int
 
 	connection net.Conn
 }
 
+// wsClientFollower redirects the flows to the analyzer a websocket client
+// connects to
+type wsClientFollower struct {
+	shttp.DefaultWSClientEventHandler
+	client   *Client
+	wsClient *shttp.WSAsyncClient
+}
+
+func (f *wsClientFollower) OnConnected() {
+	if err := f.client.SetTarget(f.wsClient.Addr, f.wsClient.Port); err != nil {
+		logging.GetLogger().Errorf("Unable to send the flows to %s:%d: %s", f.wsClient.Addr, f.wsClient.Port, err.Error())
+	}
+}
+
 func (c *Client) SendFlow(f *flow.Flow) error {
 	data, err := f.GetData()
 	if err != nil {
 		return err
 	}
 
+	c.RLock()
 	c.connection.Write(data)
+	c.RUnlock()
 
 	return nil
 }
@@ -69,15 +88,46 @@ func (c *Client) AsyncFlowsUpdate(ft *flow.Table, every time.Duration) {
 	}
 }
 
-func NewClient(addr string, port int) (*Client, error) {
-	client := &Client{Addr: addr, Port: port}
-
+func dial(addr string, port int) (net.Conn, error) {
 	srv, err := net.ResolveUDPAddr("udp", addr+":"+strconv.FormatInt(int64(port), 10))
 	if err != nil {
 		return nil, err
 	}
 
-	connection, err := net.DialUDP("udp", nil, srv)
+	return net.DialUDP("udp", nil, srv)
+}
+
+// SetTarget sends the following flows to another analyzer
+func (c *Client) SetTarget(addr string, port int) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if addr == c.Addr && port == c.Port {
+		return nil
+	}
+
+	connection, err := dial(addr, port)
+	if err != nil {
+		return err
+	}
+
+	c.connection.Close()
+	c.connection = connection
+	c.Addr, c.Port = addr, port
+
+	return nil
+}
+
+// FollowWSClient sends the flows to the analyzer the websocket client is
+// connected to, following its failovers
+func (c *Client) FollowWSClient(w *shttp.WSAsyncClient) {
+	w.AddEventHandler(&wsClientFollower{client: c, wsClient: w})
+}
+
+func NewClient(addr string, port int) (*Client, error) {
+	client := &Client{Addr: addr, Port: port}
+
+	connection, err := dial(addr, port)
 	if err != nil {
 		return nil, err
 	}
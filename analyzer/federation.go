@@ -0,0 +1,246 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"sort"
+
+	"github.com/redhat-cip/skydive/client"
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// FederatedSite merges the topology of a remote Skydive deployment in the
+// graph of the analyzer, under a node of type site. The identifiers of the
+// remote elements are prefixed by the name of the site and their Site
+// metadata set to it, the elements a site federates from other ones being
+// ignored so that the sites can federate each other.
+type FederatedSite struct {
+	client.DefaultTopologyEventListener
+	shttp.DefaultWSClientEventHandler
+	Name       string
+	Graph      *graph.Graph
+	Subscriber *client.TopologySubscriber
+}
+
+func isFederated(m graph.Metadata) bool {
+	_, ok := m["Site"]
+	return ok
+}
+
+func (f *FederatedSite) id(i graph.Identifier) graph.Identifier {
+	return graph.Identifier(f.Name + "/" + string(i))
+}
+
+func (f *FederatedSite) metadata(m graph.Metadata) graph.Metadata {
+	fm := graph.Metadata{}
+	for k, v := range m {
+		fm[k] = v
+	}
+	fm["Site"] = f.Name
+	return fm
+}
+
+// root returns the node of the site, the remote hosts being linked to it
+func (f *FederatedSite) root() *graph.Node {
+	id := graph.Identifier("site/" + f.Name)
+	if n := f.Graph.GetNode(id); n != nil {
+		return n
+	}
+	return f.Graph.NewNode(id, graph.Metadata{"Type": "site", "Name": f.Name, "Site": f.Name, "State": "DOWN"})
+}
+
+func (f *FederatedSite) setState(state string) {
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	tr := f.Graph.StartMetadataTransaction(f.root())
+	tr.AddMetadata("State", state)
+	tr.Commit()
+}
+
+func (f *FederatedSite) setNode(n *graph.Node) {
+	if isFederated(n.Metadata()) {
+		return
+	}
+
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	if node := f.Graph.GetNode(f.id(n.ID)); node != nil {
+		f.Graph.SetMetadata(node, f.metadata(n.Metadata()))
+		return
+	}
+
+	node := f.Graph.NewNodeFromHost(f.id(n.ID), f.metadata(n.Metadata()), f.Name+"/"+n.Host())
+	if node != nil && n.Metadata()["Type"] == "host" {
+		f.Graph.Link(f.root(), node, graph.Metadata{"RelationType": "ownership"})
+	}
+}
+
+func (f *FederatedSite) setEdge(e *graph.Edge) {
+	if isFederated(e.Metadata()) {
+		return
+	}
+
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	if edge := f.Graph.GetEdge(f.id(e.ID)); edge != nil {
+		f.Graph.SetMetadata(edge, f.metadata(e.Metadata()))
+		return
+	}
+
+	parent, child := f.Graph.GetNode(f.id(e.Parent())), f.Graph.GetNode(f.id(e.Child()))
+	if parent != nil && child != nil {
+		f.Graph.NewEdgeFromHost(f.id(e.ID), parent, child, f.metadata(e.Metadata()), f.Name+"/"+e.Host())
+	}
+}
+
+func (f *FederatedSite) OnNodeAdded(n *graph.Node) {
+	f.setNode(n)
+}
+
+func (f *FederatedSite) OnNodeUpdated(n *graph.Node) {
+	f.setNode(n)
+}
+
+func (f *FederatedSite) OnNodeDeleted(n *graph.Node) {
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	if node := f.Graph.GetNode(f.id(n.ID)); node != nil {
+		f.Graph.DelNode(node)
+	}
+}
+
+func (f *FederatedSite) OnEdgeAdded(e *graph.Edge) {
+	f.setEdge(e)
+}
+
+func (f *FederatedSite) OnEdgeUpdated(e *graph.Edge) {
+	f.setEdge(e)
+}
+
+func (f *FederatedSite) OnEdgeDeleted(e *graph.Edge) {
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	if edge := f.Graph.GetEdge(f.id(e.ID)); edge != nil {
+		f.Graph.DelEdge(edge)
+	}
+}
+
+// OnSynchronized removes the elements of the site the remote analyzer
+// doesn't have anymore, left by a previous run of the analyzer
+func (f *FederatedSite) OnSynchronized() {
+	f.Subscriber.Graph.RLock()
+	defer f.Subscriber.Graph.RUnlock()
+
+	f.Graph.Lock()
+	defer f.Graph.Unlock()
+
+	root := f.root()
+	for _, e := range f.Graph.LookupEdges(graph.Metadata{"Site": f.Name}) {
+		if f.Subscriber.Graph.GetEdge(e.ID[len(f.Name)+1:]) == nil {
+			f.Graph.DelEdge(e)
+		}
+	}
+	for _, n := range f.Graph.LookupNodes(graph.Metadata{"Site": f.Name}) {
+		if n.ID != root.ID && f.Subscriber.Graph.GetNode(n.ID[len(f.Name)+1:]) == nil {
+			f.Graph.DelNode(n)
+		}
+	}
+
+	tr := f.Graph.StartMetadataTransaction(root)
+	tr.AddMetadata("State", "UP")
+	tr.Commit()
+
+	logging.GetLogger().Infof("Topology of the site %s synchronized", f.Name)
+}
+
+func (f *FederatedSite) OnDisconnected() {
+	f.setState("DOWN")
+}
+
+func (f *FederatedSite) Start() {
+	f.Subscriber.AddEventListener(f)
+	f.Subscriber.Client.AddEventHandler(f)
+	f.Subscriber.Start()
+}
+
+func (f *FederatedSite) Stop() {
+	f.Subscriber.Stop()
+	f.Subscriber.RemoveEventListener(f)
+}
+
+func NewFederatedSite(name string, g *graph.Graph, s *client.TopologySubscriber) *FederatedSite {
+	return &FederatedSite{
+		Name:       name,
+		Graph:      g,
+		Subscriber: s,
+	}
+}
+
+// FederatedSitesFromConfig returns the sites whose topology is merged in the
+// graph, ordered by name
+func FederatedSitesFromConfig(g *graph.Graph) ([]*FederatedSite, error) {
+	sites := config.GetConfig().GetStringMapString("analyzer.federation.sites")
+
+	authOptions := &shttp.AuthenticationOpts{
+		Username: config.GetConfig().GetString("analyzer.federation.username"),
+		Password: config.GetConfig().GetString("analyzer.federation.password"),
+	}
+
+	tlsConfig, err := shttp.TLSConfigFromConfig("analyzer")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var federated []*FederatedSite
+	for _, name := range names {
+		sa, err := config.ParseServiceAddress(sites[name])
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := client.NewTopologySubscriber(sa.Addr, sa.Port, authOptions, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.Client.TLSConfig = tlsConfig
+		s.Client.AuthClient.TLSConfig = tlsConfig
+
+		federated = append(federated, NewFederatedSite(name, g, s))
+	}
+
+	return federated, nil
+}
@@ -23,6 +23,7 @@
 package analyzer
 
 import (
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -31,30 +32,50 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redhat-cip/skydive/api"
 	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/flow"
 	"github.com/redhat-cip/skydive/flow/mappings"
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/packet_injector"
 	"github.com/redhat-cip/skydive/storage"
 	"github.com/redhat-cip/skydive/storage/elasticsearch"
 	"github.com/redhat-cip/skydive/storage/etcd"
+	"github.com/redhat-cip/skydive/storage/influxdb"
 	"github.com/redhat-cip/skydive/topology/alert"
+	"github.com/redhat-cip/skydive/topology/annotation"
 	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
 )
 
 type Server struct {
 	HTTPServer          *shttp.Server
 	WSServer            *shttp.WSServer
+	GRPCApi             *api.GRPCApi
 	GraphServer         *graph.GraphServer
+	GraphBackend        graph.GraphBackend
+	GraphMetrics        prometheus.Collector
 	AlertServer         *alert.AlertServer
+	CaptureManager      *CaptureManager
+	AnnotationManager   *annotation.AnnotationManager
+	CloudEventsSink     *graph.CloudEventsSink
+	ArticulationMarker  *graph.ArticulationMarker
+	GraphMirror         *graph.GremlinMirror
+	TopologyRecorder    *storage.TopologyRecorder
+	RetentionEnforcer   *storage.RetentionEnforcer
+	FlowRollups         *flow.Rollups
 	FlowMappingPipeline *mappings.FlowMappingPipeline
+	TopologyProbeBundle *probes.TopologyProbeBundle
 	Storage             storage.Storage
 	FlowTable           *flow.Table
 	conn                *net.UDPConn
 	EmbeddedEtcd        *etcd.EmbeddedEtcd
 	EtcdClient          *etcd.EtcdClient
+	Replicators         []*graph.Replicator
+	FederatedSites      []*FederatedSite
+	MasterElector       *etcd.MasterElector
 	running             atomic.Value
 	wgServers           sync.WaitGroup
 }
@@ -66,9 +87,49 @@ func (s *Server) flowExpireUpdate(flows []*flow.Flow) {
 	}
 }
 
+// flowTenant returns the Neutron tenant of the port a flow was captured on
+func (s *Server) flowTenant(f *flow.Flow) string {
+	g := s.GraphServer.Graph
+	g.RLock()
+	defer g.RUnlock()
+
+	if n := g.GetNode(graph.Identifier(f.ProbeNodeUUID)); n != nil {
+		if tenant, ok := n.Metadata()["Neutron.TenantID"].(string); ok {
+			return tenant
+		}
+	}
+	return ""
+}
+
+// flowExpired stores the last state of the expired flows, their
+// ExpiredReason telling the storage that they ended
+func (s *Server) flowExpired(flows []*flow.Flow) {
+	s.flowExpireUpdate(flows)
+	logging.GetLogger().Debugf("%d flows expired", len(flows))
+}
+
+// OnElected starts the tasks run by only one of the analyzers, the alerting
+// and the enforcement of the retention of the flows
+func (s *Server) OnElected() {
+	s.AlertServer.AlertManager.Start()
+	if s.RetentionEnforcer != nil {
+		s.RetentionEnforcer.Start()
+	}
+}
+
+func (s *Server) OnDemoted() {
+	s.AlertServer.AlertManager.Stop()
+	if s.RetentionEnforcer != nil {
+		s.RetentionEnforcer.Stop()
+	}
+}
+
 func (s *Server) AnalyzeFlows(flows []*flow.Flow) {
 	s.FlowTable.Update(flows)
 	s.FlowMappingPipeline.Enhance(flows)
+	if s.FlowRollups != nil {
+		s.FlowRollups.Update(flows)
+	}
 
 	logging.GetLogger().Debugf("%d flows received", len(flows))
 }
@@ -107,7 +168,41 @@ func (s *Server) ListenAndServe() {
 		s.Storage.Start()
 	}
 
-	s.AlertServer.AlertManager.Start()
+	if s.TopologyRecorder != nil {
+		s.TopologyRecorder.Start()
+	}
+
+	if s.FlowRollups != nil {
+		s.FlowRollups.Start()
+	}
+
+	if err := prometheus.Register(s.GraphMetrics); err != nil {
+		logging.GetLogger().Errorf("Unable to register the graph metrics: %s", err.Error())
+	}
+
+	s.AnnotationManager.Start()
+	s.MasterElector.Start()
+	s.CaptureManager.Start()
+	s.TopologyProbeBundle.Start()
+
+	for _, r := range s.Replicators {
+		r.Start()
+	}
+
+	for _, f := range s.FederatedSites {
+		f.Start()
+	}
+
+	if s.CloudEventsSink != nil {
+		s.CloudEventsSink.Start()
+	}
+	if s.ArticulationMarker != nil {
+		s.ArticulationMarker.Start()
+	}
+
+	if s.GraphMirror != nil {
+		s.GraphMirror.Start()
+	}
 
 	s.wgServers.Add(3)
 	go func() {
@@ -120,6 +215,12 @@ func (s *Server) ListenAndServe() {
 		s.WSServer.ListenAndServe()
 	}()
 
+	if s.GRPCApi != nil {
+		if err := s.GRPCApi.Start(); err != nil {
+			logging.GetLogger().Errorf("Unable to start the gRPC API: %s", err.Error())
+		}
+	}
+
 	go func() {
 		defer s.wgServers.Done()
 
@@ -143,15 +244,46 @@ func (s *Server) Stop() {
 	s.FlowTable.UnregisterAll()
 	s.WSServer.Stop()
 	s.HTTPServer.Stop()
+	if s.GRPCApi != nil {
+		s.GRPCApi.Stop()
+	}
 	if s.EmbeddedEtcd != nil {
 		s.EmbeddedEtcd.Stop()
 	}
+	if s.TopologyRecorder != nil {
+		s.TopologyRecorder.Stop()
+	}
+	if s.FlowRollups != nil {
+		s.FlowRollups.Stop()
+	}
 	if s.Storage != nil {
 		s.Storage.Stop()
 	}
-	s.AlertServer.AlertManager.Stop()
+	for _, r := range s.Replicators {
+		r.Stop()
+	}
+	for _, f := range s.FederatedSites {
+		f.Stop()
+	}
+	s.MasterElector.Stop()
+	s.CaptureManager.Stop()
+	prometheus.Unregister(s.GraphMetrics)
+	s.TopologyProbeBundle.Stop()
+	s.AnnotationManager.Stop()
+	if s.CloudEventsSink != nil {
+		s.CloudEventsSink.Stop()
+	}
+	if s.ArticulationMarker != nil {
+		s.ArticulationMarker.Stop()
+	}
+	if s.GraphMirror != nil {
+		s.GraphMirror.Stop()
+	}
 	s.EtcdClient.Stop()
 	s.wgServers.Wait()
+	if c, ok := s.GraphBackend.(io.Closer); ok {
+		c.Close()
+	}
 	if tr, ok := http.DefaultTransport.(interface {
 		CloseIdleConnections()
 	}); ok {
@@ -172,6 +304,12 @@ func (s *Server) SetStorageFromConfig() {
 				logging.GetLogger().Fatalf("Can't connect to ElasticSearch server: %v", err)
 			}
 			s.SetStorage(storage)
+		case "influxdb":
+			storage, err := influxdb.New()
+			if err != nil {
+				logging.GetLogger().Fatalf("Can't configure the InfluxDB storage: %v", err)
+			}
+			s.SetStorage(storage)
 		default:
 			logging.GetLogger().Fatalf("Storage type unknown: %s", t)
 			os.Exit(1)
@@ -188,7 +326,7 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
-	g, err := graph.NewGraph(backend)
+	g, err := graph.NewGraphFromConfig(backend)
 	if err != nil {
 		return nil, err
 	}
@@ -200,8 +338,6 @@ func NewServerFromConfig() (*Server, error) {
 
 	wsServer := shttp.NewWSServerFromConfig(httpServer, "/ws")
 
-	api.RegisterTopologyApi("analyzer", g, httpServer)
-
 	var etcdServer *etcd.EmbeddedEtcd
 	if embedEtcd {
 		if etcdServer, err = etcd.NewEmbeddedEtcdFromConfig(); err != nil {
@@ -219,10 +355,10 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
-	captureHandler := &api.BasicApiHandler{
+	captureHandler := api.NewCaptureApiHandler(api.BasicApiHandler{
 		ResourceHandler: &api.CaptureHandler{},
 		EtcdKeyAPI:      etcdClient.KeysApi,
-	}
+	})
 	err = apiServer.RegisterApiHandler(captureHandler)
 	if err != nil {
 		return nil, err
@@ -237,11 +373,39 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
-	alertManager := alert.NewAlertManager(g, alertHandler)
+	annotationHandler := &api.BasicApiHandler{
+		ResourceHandler: &api.AnnotationHandler{},
+		EtcdKeyAPI:      etcdClient.KeysApi,
+	}
+	err = apiServer.RegisterApiHandler(annotationHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	elector, err := etcd.NewMasterElectorFromConfig(etcdClient.KeysApi, "analyzer")
+	if err != nil {
+		return nil, err
+	}
+
+	replicators, err := graph.NewReplicatorsFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror, err := graph.GremlinMirrorFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
+
+	sites, err := FederatedSitesFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
 
-	aserver := alert.NewServer(alertManager, wsServer)
 	gserver := graph.NewServer(g, wsServer)
 
+	api.RegisterTopologyApi("analyzer", g, gserver, httpServer)
+
 	gfe := mappings.NewGraphFlowEnhancer(g)
 	ofe := mappings.NewOvsFlowEnhancer(g)
 
@@ -249,23 +413,81 @@ func NewServerFromConfig() (*Server, error) {
 
 	flowtable := flow.NewTable()
 
+	alertManager := alert.NewAlertManager(g, alertHandler)
+	alertManager.FlowTable = flowtable
+	aserver := alert.NewServer(alertManager, wsServer)
+
 	server := &Server{
 		HTTPServer:          httpServer,
 		WSServer:            wsServer,
 		GraphServer:         gserver,
+		GraphBackend:        backend,
+		GraphMetrics:        graph.NewSizeCollector(g),
 		AlertServer:         aserver,
+		CaptureManager:      NewCaptureManager(g, wsServer, captureHandler),
+		AnnotationManager:   annotation.NewAnnotationManager(g, annotationHandler),
+		CloudEventsSink:     graph.CloudEventsSinkFromConfig(g, "analyzer"),
+		ArticulationMarker:  graph.ArticulationMarkerFromConfig(g),
+		GraphMirror:         mirror,
 		FlowMappingPipeline: pipeline,
+		TopologyProbeBundle: probes.NewAnalyzerTopologyProbeBundleFromConfig(g),
 		FlowTable:           flowtable,
 		EmbeddedEtcd:        etcdServer,
 		EtcdClient:          etcdClient,
+		Replicators:         replicators,
+		FederatedSites:      sites,
+		MasterElector:       elector,
 	}
 	server.SetStorageFromConfig()
+	elector.AddEventListener(server)
+
+	api.RegisterFlowApi("analyzer", g, flowtable, server.Storage, httpServer)
+	api.RegisterGraphQLApi("analyzer", g, flowtable, server.Storage, httpServer)
+	tableClient := flow.NewTableClient(wsServer)
+	api.RegisterPcapApi("analyzer", g, flowtable, tableClient, httpServer)
+	api.RegisterPacketInjectorApi("analyzer", g, packet_injector.NewClient(wsServer), tableClient, httpServer)
+
+	var topologyStorage storage.TopologyStorage
+	if ts, ok := server.Storage.(storage.TopologyStorage); ok && config.GetConfig().GetBool("analyzer.topology_history") {
+		server.TopologyRecorder = storage.NewTopologyRecorder(g, ts)
+		topologyStorage = ts
+	}
+	api.RegisterTopologyHistoryApi("analyzer", topologyStorage, httpServer)
 
-	api.RegisterFlowApi("analyzer", flowtable, server.Storage, httpServer)
+	if rs, ok := server.Storage.(storage.RollupStorage); ok {
+		rollups, err := flow.NewRollupsFromConfig(func(rollups []*flow.Rollup) {
+			if err := rs.StoreRollups(rollups); err != nil {
+				logging.GetLogger().Errorf("Unable to store the flow rollups: %s", err.Error())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rollups != nil {
+			rollups.Tenant = server.flowTenant
+			server.FlowRollups = rollups
+		}
+	}
+
+	if rs, ok := server.Storage.(storage.RetentionStorage); ok {
+		enforcer, err := storage.NewRetentionEnforcerFromConfig(rs)
+		if err != nil {
+			return nil, err
+		}
+		if len(enforcer.Policies) > 0 {
+			server.RetentionEnforcer = enforcer
+		}
+	}
+	api.RegisterStatusApi("analyzer", g, httpServer)
+	api.RegisterLoggingApi("analyzer", httpServer)
+
+	if server.GRPCApi, err = api.NewGRPCApiFromConfig(g, flowtable, server.Storage, captureHandler, httpServer.Auth); err != nil {
+		return nil, err
+	}
 
 	analyzerExpire := config.GetAnalyerExpire()
 	agentExpire := config.GetAgentExpire()
-	flowtable.RegisterExpire(server.flowExpireUpdate, analyzerExpire, agentExpire)
+	flowtable.RegisterExpire(server.flowExpired, analyzerExpire, agentExpire)
 
 	analyzerUpdate := config.GetAnalyerUpdate()
 	agentUpdate := config.GetAgentUpdate()
@@ -58,8 +58,10 @@ func NewAlert() *Alert {
 	}
 }
 
+// New returns an alert with a new identifier, overridden by the one of
+// the decoded alerts
 func (a *AlertHandler) New() ApiResource {
-	return &Alert{}
+	return NewAlert()
 }
 
 func (a *AlertHandler) Name() string {
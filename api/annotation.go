@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// Annotation is the metadata, labels, descriptions or ticket links, the
+// users attach to the node or the edge of ElementID. They are kept under
+// the UserMetadata key of the element, which the probes can't modify, and
+// applied again when the element is added back by an agent.
+type Annotation struct {
+	ElementID string `valid:"nonzero"`
+	Metadata  graph.Metadata
+}
+
+type AnnotationHandler struct {
+}
+
+func (a *AnnotationHandler) New() ApiResource {
+	return &Annotation{}
+}
+
+func (a *AnnotationHandler) Name() string {
+	return "annotation"
+}
+
+func (a *Annotation) ID() string {
+	return a.ElementID
+}
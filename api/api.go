@@ -112,7 +112,7 @@ func (a *ApiServer) RegisterApiHandler(handler ApiHandler) error {
 			title + "Insert",
 			"POST",
 			"/api/" + name,
-			func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+			a.HTTPServer.RequireRole(shttp.AdminRole, func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 				resource := handler.New()
 				data, _ := ioutil.ReadAll(r.Body)
 				if err := json.Unmarshal(data, &resource); err != nil {
@@ -122,6 +122,7 @@ func (a *ApiServer) RegisterApiHandler(handler ApiHandler) error {
 
 				if err := handler.Create(resource); err != nil {
 					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(err.Error()))
 					return
 				}
 
@@ -136,13 +137,13 @@ func (a *ApiServer) RegisterApiHandler(handler ApiHandler) error {
 				if _, err := w.Write(data); err != nil {
 					logging.GetLogger().Criticalf("Failed to create %s: %s", name, err.Error())
 				}
-			},
+			}),
 		},
 		{
 			title + "Delete",
 			"DELETE",
 			shttp.PathPrefix(fmt.Sprintf("/api/%s/", name)),
-			func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+			a.HTTPServer.RequireRole(shttp.AdminRole, func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 				id := r.URL.Path[len(fmt.Sprintf("/api/%s/", name)):]
 				if id == "" {
 					w.WriteHeader(http.StatusBadRequest)
@@ -150,13 +151,17 @@ func (a *ApiServer) RegisterApiHandler(handler ApiHandler) error {
 				}
 
 				if err := handler.Delete(id); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
+					if etcd.IsKeyNotFound(err) {
+						w.WriteHeader(http.StatusNotFound)
+					} else {
+						w.WriteHeader(http.StatusBadRequest)
+					}
 					return
 				}
 
 				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 				w.WriteHeader(http.StatusOK)
-			},
+			}),
 		},
 	}
 
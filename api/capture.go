@@ -22,14 +22,69 @@
 
 package api
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+const (
+	// CaptureNamespace is the namespace of the WebSocket messages used by
+	// the analyzers to start and stop the captures on the agents
+	CaptureNamespace = "Capture"
+)
+
+// Capture is a capture request, started on the node of the probe path or
+// on the nodes matching the Gremlin query, which the analyzers evaluate
+// again as the topology changes. A capture with a duration
+// expires automatically. The agents keep the last RawPacketLimit packets of
+// the capture so that they can be downloaded. The flows of a capture can
+// also be re-exported to an IPFIX or sFlow collector given as
+// ipfix://host[:port] or sflow://host[:port]. The flows expire after
+// IdleTimeout seconds without packet or ActiveTimeout seconds of activity,
+// the agent configuration being used when not set. The captures on Open
+// vSwitch bridges configure their sFlow with SamplingRate and
+// PollingPeriod, in seconds. The pcap and afpacket captures process 1 out
+// of SamplingRate packets, at most PacketRate packets per second and stop
+// processing them once MaxBytes bytes were captured. The erspan captures
+// receive the traffic mirrored by the remote switches over ERSPAN or GRE.
 type Capture struct {
-	ProbePath string `json:",omitempty" valid:"nonzero"`
-	BPFFilter string `json:",omitempty"`
+	UUID           string `json:",omitempty"`
+	ProbePath      string `json:",omitempty"`
+	GremlinQuery   string `json:",omitempty"`
+	BPFFilter      string `json:",omitempty"`
+	Type           string `json:",omitempty"`
+	SnapLen        int    `json:",omitempty"`
+	Duration       int64  `json:",omitempty"`
+	RawPacketLimit int    `json:",omitempty"`
+	Export         string `json:",omitempty"`
+	ActiveTimeout  int    `json:",omitempty"`
+	IdleTimeout    int    `json:",omitempty"`
+	SamplingRate   uint32 `json:",omitempty"`
+	PollingPeriod  uint32 `json:",omitempty"`
+	PacketRate     int    `json:",omitempty"`
+	MaxBytes       int64  `json:",omitempty"`
+}
+
+// CaptureRequest is sent by the analyzers to the agent owning a node
+// matching the query of a capture
+type CaptureRequest struct {
+	NodeID  string
+	Capture *Capture
 }
 
 type CaptureHandler struct {
 }
 
+// CaptureApiHandler stores the captures, the ones using a Gremlin query
+// being identified by a generated UUID
+type CaptureApiHandler struct {
+	BasicApiHandler
+}
+
 func NewCapture(probePath string, bpfFilter string) *Capture {
 	return &Capture{
 		ProbePath: probePath,
@@ -46,5 +101,45 @@ func (c *CaptureHandler) Name() string {
 }
 
 func (c *Capture) ID() string {
+	if c.ProbePath == "" {
+		return c.UUID
+	}
 	return c.ProbePath
 }
+
+func (c *Capture) TTL() time.Duration {
+	return time.Duration(c.Duration) * time.Second
+}
+
+func (c *CaptureApiHandler) Create(resource ApiResource) error {
+	capture := resource.(*Capture)
+
+	if capture.ProbePath == "" && capture.GremlinQuery == "" {
+		return errors.New("A probe path or a Gremlin query is required")
+	}
+
+	if capture.PacketRate < 0 || capture.MaxBytes < 0 {
+		return errors.New("The packet rate and the maximum number of bytes can't be negative")
+	}
+
+	if capture.Export != "" {
+		u, err := url.Parse(capture.Export)
+		if err != nil || (u.Scheme != "ipfix" && u.Scheme != "sflow") || u.Host == "" {
+			return fmt.Errorf("Invalid flow export %s, expected ipfix://host[:port] or sflow://host[:port]", capture.Export)
+		}
+	}
+
+	if capture.GremlinQuery != "" && capture.UUID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		capture.UUID = id.String()
+	}
+
+	return c.BasicApiHandler.Create(resource)
+}
+
+func NewCaptureApiHandler(h BasicApiHandler) *CaptureApiHandler {
+	return &CaptureApiHandler{BasicApiHandler: h}
+}
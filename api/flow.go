@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/abbot/go-http-auth"
@@ -35,12 +36,15 @@ import (
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
 	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology/graph"
 )
 
 type FlowApi struct {
 	Service   string
+	Graph     *graph.Graph
 	FlowTable *flow.Table
 	Storage   storage.Storage
+	Auth      shttp.AuthenticationBackend
 }
 
 func (f *FlowApi) flowSearch(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
@@ -68,6 +72,33 @@ func (f *FlowApi) flowSearch(w http.ResponseWriter, r *auth.AuthenticatedRequest
 	}
 }
 
+// flowCorrelation returns the logical flows of the flow table, the ones
+// observed by at least ?min=N capture points if given
+func (f *FlowApi) flowCorrelation(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	min := 1
+	if v := r.URL.Query().Get("min"); v != "" {
+		var err error
+		if min, err = strconv.Atoi(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	logicals := []*flow.LogicalFlow{}
+	for _, lf := range flow.CorrelateFlows(f.FlowTable.GetFlows()) {
+		if len(lf.Observations) >= min {
+			logicals = append(logicals, lf)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(logicals); err != nil {
+		panic(err)
+	}
+}
+
 func (f *FlowApi) serveDataIndex(w http.ResponseWriter, r *auth.AuthenticatedRequest, message string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
@@ -122,6 +153,8 @@ func (f *FlowApi) conversationLayer(w http.ResponseWriter, r *auth.Authenticated
 		ltype = flow.FlowEndpointType_ETHERNET
 	case "ipv4":
 		ltype = flow.FlowEndpointType_IPV4
+	case "ipv6":
+		ltype = flow.FlowEndpointType_IPV6
 	case "tcp":
 		ltype = flow.FlowEndpointType_TCPPORT
 	case "udp":
@@ -247,6 +280,30 @@ func (f *FlowApi) registerEndpoints(r *shttp.Server) {
 			"/api/flow/search",
 			f.flowSearch,
 		},
+		{
+			"FlowCorrelation",
+			"GET",
+			"/api/flow/correlation",
+			f.flowCorrelation,
+		},
+		{
+			"FlowTraversing",
+			"GET",
+			"/api/flow/traversing",
+			f.flowsTraversing,
+		},
+		{
+			"FlowQuery",
+			"GET",
+			"/api/flow/query",
+			f.flowQuery,
+		},
+		{
+			"FlowQuery",
+			"POST",
+			"/api/flow/query",
+			f.flowQuery,
+		},
 		{
 			"ConversationLayer",
 			"GET",
@@ -264,11 +321,13 @@ func (f *FlowApi) registerEndpoints(r *shttp.Server) {
 	r.RegisterRoutes(routes)
 }
 
-func RegisterFlowApi(s string, f *flow.Table, st storage.Storage, r *shttp.Server) {
+func RegisterFlowApi(s string, g *graph.Graph, f *flow.Table, st storage.Storage, r *shttp.Server) {
 	fa := &FlowApi{
 		Service:   s,
+		Graph:     g,
 		FlowTable: f,
 		Storage:   st,
+		Auth:      r.Auth,
 	}
 
 	fa.registerEndpoints(r)
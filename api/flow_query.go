@@ -0,0 +1,503 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// FlowTraversalExtension adds the Flows step to the Gremlin queries,
+// returning the flows captured on the nodes of the previous step, or the
+// live flows captured on the nodes of the graph after G, the stored flows
+// being only looked up for a selection of nodes, and the Since, Sort, Limit
+// and Sum steps working on them.
+// Has and Dedup apply to the flows as well, ex: the top 10 talkers on br-int
+// during the last 5 minutes:
+// G.V().Has('Name', 'br-int').Flows().Since(300).Sort('Bytes').Limit(10)
+type FlowTraversalExtension struct {
+	FlowTable  *flow.Table
+	Storage    storage.Storage
+	flowsToken graph.Token
+	sinceToken graph.Token
+	sortToken  graph.Token
+	limitToken graph.Token
+	sumToken   graph.Token
+}
+
+// FlowTraversalStep holds the flows of a query
+type FlowTraversalStep struct {
+	flows []*flow.Flow
+}
+
+// FlowSumTraversalStep holds the result of the Sum step
+type FlowSumTraversalStep struct {
+	sum int64
+}
+
+type flowsGremlinTraversalStep struct {
+	extension *FlowTraversalExtension
+}
+
+type flowSinceGremlinTraversalStep struct {
+	seconds int64
+}
+
+type flowSortGremlinTraversalStep struct {
+	key        string
+	descending bool
+}
+
+type flowLimitGremlinTraversalStep struct {
+	limit int64
+}
+
+type flowSumGremlinTraversalStep struct {
+	key string
+}
+
+// flowValue returns the value of a field of the flow, Bytes and Packets
+// being the totals of the statistics and Start and Last their times
+func flowValue(f *flow.Flow, key string) (interface{}, bool) {
+	fs := f.GetStatistics()
+	if fs == nil {
+		fs = &flow.FlowStatistics{}
+	}
+
+	switch key {
+	case "Bytes":
+		_, bytes := fs.GetCounters()
+		return bytes, true
+	case "Packets":
+		packets, _ := fs.GetCounters()
+		return packets, true
+	case "Start":
+		return fs.Start, true
+	case "Last":
+		return fs.Last, true
+	}
+
+	return flowField(f, key)
+}
+
+func checkFlowKey(step, key string) error {
+	if _, ok := flowValue(&flow.Flow{}, key); !ok {
+		return fmt.Errorf("%s: unknown flow field %s", step, key)
+	}
+	return nil
+}
+
+func (s *FlowTraversalStep) Values() []interface{} {
+	values := make([]interface{}, len(s.flows))
+	for i, f := range s.flows {
+		values[i] = f
+	}
+	return values
+}
+
+func (s *FlowTraversalStep) Error() error {
+	return nil
+}
+
+// Has keeps the flows matching the key/value pairs, the values being either
+// plain values or the Within, Without and Ne predicates
+func (s *FlowTraversalStep) Has(params ...interface{}) (graph.GraphTraversalStep, error) {
+	if len(params)%2 != 0 {
+		return nil, errors.New("Has: key/value pairs expected")
+	}
+
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return nil, errors.New("Has: keys have to be strings")
+		}
+		if err := checkFlowKey("Has", key); err != nil {
+			return nil, err
+		}
+	}
+
+	flows := []*flow.Flow{}
+	for _, f := range s.flows {
+		match := true
+		for i := 0; i < len(params) && match; i += 2 {
+			v, _ := flowValue(f, params[i].(string))
+			if matcher, ok := params[i+1].(graph.MetadataMatcher); ok {
+				match = matcher.Match(v)
+			} else {
+				match = common.CrossTypeEqual(v, params[i+1])
+			}
+		}
+		if match {
+			flows = append(flows, f)
+		}
+	}
+
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+// Dedup removes the flows returned twice, by UUID
+func (s *FlowTraversalStep) Dedup() (graph.GraphTraversalStep, error) {
+	flows := []*flow.Flow{}
+	seen := make(map[string]bool)
+	for _, f := range s.flows {
+		if !seen[f.UUID] {
+			seen[f.UUID] = true
+			flows = append(flows, f)
+		}
+	}
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+func (s *FlowSumTraversalStep) Values() []interface{} {
+	return []interface{}{s.sum}
+}
+
+func (s *FlowSumTraversalStep) Error() error {
+	return nil
+}
+
+func NewFlowTraversalExtension(f *flow.Table, st storage.Storage) *FlowTraversalExtension {
+	return &FlowTraversalExtension{
+		FlowTable:  f,
+		Storage:    st,
+		flowsToken: graph.Token(1001),
+		sinceToken: graph.Token(1002),
+		sortToken:  graph.Token(1003),
+		limitToken: graph.Token(1004),
+		sumToken:   graph.Token(1005),
+	}
+}
+
+func (e *FlowTraversalExtension) ScanIdent(s string) (graph.Token, bool) {
+	switch s {
+	case "FLOWS":
+		return e.flowsToken, true
+	case "SINCE":
+		return e.sinceToken, true
+	case "SORT":
+		return e.sortToken, true
+	case "LIMIT":
+		return e.limitToken, true
+	case "SUM":
+		return e.sumToken, true
+	}
+	return graph.IDENT, false
+}
+
+func (e *FlowTraversalExtension) ParseStep(t graph.Token, p graph.GremlinTraversalStepParams) (graph.GremlinTraversalStep, error) {
+	switch t {
+	case e.flowsToken:
+		if len(p) != 0 {
+			return nil, errors.New("Flows accepts no parameter")
+		}
+		return &flowsGremlinTraversalStep{extension: e}, nil
+	case e.sinceToken:
+		if len(p) != 1 {
+			return nil, errors.New("Since expects a number of seconds")
+		}
+		seconds, ok := p[0].(int64)
+		if !ok {
+			return nil, errors.New("Since expects a number of seconds")
+		}
+		return &flowSinceGremlinTraversalStep{seconds: seconds}, nil
+	case e.sortToken:
+		// Sort(key, [ASC|DESC]), descending by default
+		if len(p) == 0 || len(p) > 2 {
+			return nil, errors.New("Sort expects a key and an optional order")
+		}
+		step := &flowSortGremlinTraversalStep{descending: true}
+		for i, param := range p {
+			s, ok := param.(string)
+			if !ok {
+				return nil, errors.New("Sort parameters have to be strings")
+			}
+			if i == 0 {
+				if err := checkFlowKey("Sort", s); err != nil {
+					return nil, err
+				}
+				step.key = s
+				continue
+			}
+			switch strings.ToUpper(s) {
+			case "ASC":
+				step.descending = false
+			case "DESC":
+			default:
+				return nil, fmt.Errorf("Sort: unknown order %s", s)
+			}
+		}
+		return step, nil
+	case e.limitToken:
+		if len(p) != 1 {
+			return nil, errors.New("Limit expects a number of flows")
+		}
+		limit, ok := p[0].(int64)
+		if !ok || limit < 0 {
+			return nil, errors.New("Limit expects a number of flows")
+		}
+		return &flowLimitGremlinTraversalStep{limit: limit}, nil
+	case e.sumToken:
+		key := "Bytes"
+		if len(p) > 1 {
+			return nil, errors.New("Sum accepts only Bytes or Packets")
+		}
+		if len(p) == 1 {
+			key, _ = p[0].(string)
+		}
+		if key != "Bytes" && key != "Packets" {
+			return nil, errors.New("Sum accepts only Bytes or Packets")
+		}
+		return &flowSumGremlinTraversalStep{key: key}, nil
+	}
+
+	return nil, nil
+}
+
+// Exec returns the flows of the flow table and of the storage captured on
+// the nodes, the live flows having precedence. Without nodes, only the live
+// flows captured on a node of the graph, the hidden ones being left out of
+// the graph of the readers, are returned.
+func (s *flowsGremlinTraversalStep) Exec(last graph.GraphTraversalStep) (graph.GraphTraversalStep, error) {
+	var g *graph.Graph
+	var nodes map[string]bool
+
+	switch last.(type) {
+	case *graph.GraphTraversal:
+		g = last.(*graph.GraphTraversal).Graph
+	case *graph.GraphTraversalV:
+		tv := last.(*graph.GraphTraversalV)
+		if err := tv.Error(); err != nil {
+			return nil, err
+		}
+
+		nodes = make(map[string]bool)
+		for _, i := range tv.Values() {
+			for id := range captureNodes(tv.GraphTraversal.Graph, i.(*graph.Node)) {
+				nodes[id] = true
+			}
+		}
+	default:
+		return nil, graph.ExecutionError
+	}
+
+	if s.extension.FlowTable == nil {
+		return nil, errors.New("no flow table available")
+	}
+
+	flows := []*flow.Flow{}
+	seen := make(map[string]bool)
+	add := func(f *flow.Flow) {
+		if seen[f.UUID] {
+			return
+		}
+		if nodes == nil && g.GetNode(graph.Identifier(f.ProbeNodeUUID)) == nil {
+			return
+		}
+		if nodes != nil && !nodes[f.ProbeNodeUUID] && !nodes[f.IfSrcNodeUUID] && !nodes[f.IfDstNodeUUID] {
+			return
+		}
+		seen[f.UUID] = true
+		flows = append(flows, f)
+	}
+
+	for _, f := range s.extension.FlowTable.GetFlows() {
+		add(f)
+	}
+
+	if s.extension.Storage != nil {
+		for id := range nodes {
+			stored, err := s.extension.Storage.SearchFlows(storage.Filters{"ProbeNodeUUID": id})
+			if err != nil {
+				return nil, err
+			}
+			for _, fl := range stored {
+				add(fl)
+			}
+		}
+	}
+
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+// Exec keeps the flows updated during the last seconds
+func (s *flowSinceGremlinTraversalStep) Exec(last graph.GraphTraversalStep) (graph.GraphTraversalStep, error) {
+	fs, ok := last.(*FlowTraversalStep)
+	if !ok {
+		return nil, graph.ExecutionError
+	}
+
+	since := time.Now().Unix() - s.seconds
+
+	flows := []*flow.Flow{}
+	for _, f := range fs.flows {
+		if last, _ := flowValue(f, "Last"); last.(int64) >= since {
+			flows = append(flows, f)
+		}
+	}
+
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+type flowSorter struct {
+	flows      []*flow.Flow
+	key        string
+	descending bool
+}
+
+func (s flowSorter) Len() int {
+	return len(s.flows)
+}
+
+func (s flowSorter) Swap(i, j int) {
+	s.flows[i], s.flows[j] = s.flows[j], s.flows[i]
+}
+
+func (s flowSorter) Less(i, j int) bool {
+	a, _ := flowValue(s.flows[i], s.key)
+	b, _ := flowValue(s.flows[j], s.key)
+
+	if s.descending {
+		a, b = b, a
+	}
+
+	switch a.(type) {
+	case int64:
+		return a.(int64) < b.(int64)
+	case string:
+		return a.(string) < b.(string)
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func (s *flowSortGremlinTraversalStep) Exec(last graph.GraphTraversalStep) (graph.GraphTraversalStep, error) {
+	fs, ok := last.(*FlowTraversalStep)
+	if !ok {
+		return nil, graph.ExecutionError
+	}
+
+	flows := make([]*flow.Flow, len(fs.flows))
+	copy(flows, fs.flows)
+	sort.Stable(flowSorter{flows: flows, key: s.key, descending: s.descending})
+
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+func (s *flowLimitGremlinTraversalStep) Exec(last graph.GraphTraversalStep) (graph.GraphTraversalStep, error) {
+	fs, ok := last.(*FlowTraversalStep)
+	if !ok {
+		return nil, graph.ExecutionError
+	}
+
+	flows := fs.flows
+	if int64(len(flows)) > s.limit {
+		flows = flows[:s.limit]
+	}
+
+	return &FlowTraversalStep{flows: flows}, nil
+}
+
+func (s *flowSumGremlinTraversalStep) Exec(last graph.GraphTraversalStep) (graph.GraphTraversalStep, error) {
+	fs, ok := last.(*FlowTraversalStep)
+	if !ok {
+		return nil, graph.ExecutionError
+	}
+
+	var sum int64
+	for _, f := range fs.flows {
+		v, _ := flowValue(f, s.key)
+		sum += v.(int64)
+	}
+
+	return &FlowSumTraversalStep{sum: sum}, nil
+}
+
+// Query executes a Gremlin query using the flow steps, on the graph without
+// its hidden nodes and edges unless withHidden is set
+func (f *FlowApi) Query(query string, withHidden bool) ([]interface{}, error) {
+	f.Graph.RLock()
+	defer f.Graph.RUnlock()
+
+	tr := graph.NewGremlinTraversalParser(strings.NewReader(query), visibleGraph(f.Graph, withHidden))
+	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
+	tr.AddTraversalExtension(NewFlowTraversalExtension(f.FlowTable, f.Storage))
+
+	ts, err := tr.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ts.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Values(), nil
+}
+
+// flowQuery serves the Gremlin queries given with the gremlin URL parameter
+// or in the body of the request, ex:
+// /api/flow/query?gremlin=G.V().Has('Name','br-int').Flows().Sort('Bytes').Limit(10)
+func (f *FlowApi) flowQuery(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	resource := Topology{GremlinQuery: r.URL.Query().Get("gremlin")}
+	data, _ := ioutil.ReadAll(r.Body)
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &resource); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if resource.GremlinQuery == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Gremlin query expected"))
+		return
+	}
+
+	values, err := f.Query(resource.GremlinQuery, withHidden(f.Auth, r))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(values); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// flowQueryTestStorage records the searches of the stored flows
+type flowQueryTestStorage struct {
+	searches []storage.Filters
+}
+
+func (s *flowQueryTestStorage) Start() {
+}
+
+func (s *flowQueryTestStorage) StoreFlows(flows []*flow.Flow) error {
+	return nil
+}
+
+func (s *flowQueryTestStorage) SearchFlows(filters storage.Filters) ([]*flow.Flow, error) {
+	s.searches = append(s.searches, filters)
+	return nil, nil
+}
+
+func (s *flowQueryTestStorage) Stop() {
+}
+
+func newFlowQueryTestApi(t *testing.T) *FlowApi {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bridge := g.NewNode(graph.Identifier("br"), graph.Metadata{"Type": "bridge", "Name": "br0"})
+	port := g.NewNode(graph.Identifier("port"), graph.Metadata{"Type": "device", "Name": "eth0"})
+	g.NewNode(graph.Identifier("other"), graph.Metadata{"Type": "device", "Name": "eth1"})
+	g.NewNode(graph.Identifier("secret"), graph.Metadata{"Type": "device", "Name": "eth2", "Hidden": true})
+	g.Link(bridge, port, graph.Metadata{"RelationType": "layer2"})
+
+	now := time.Now().Unix()
+	ft := flow.NewTable()
+	for _, fl := range []struct {
+		uuid  string
+		probe string
+		bytes uint64
+		last  int64
+	}{
+		{"flow1", "port", 100, now},
+		{"flow2", "port", 300, now - 10},
+		{"flow3", "port", 200, now - 3600},
+		{"flow4", "other", 1000, now},
+		{"flow5", "secret", 50, now},
+		{"flow6", "gone", 50, now},
+	} {
+		f, _ := ft.GetOrCreateFlow(fl.uuid)
+		f.UUID = fl.uuid
+		f.ProbeNodeUUID = fl.probe
+		f.Statistics = &flow.FlowStatistics{
+			Start: fl.last,
+			Last:  fl.last,
+			Endpoints: []*flow.FlowEndpointsStatistics{
+				{
+					AB: &flow.FlowEndpointStatistics{Packets: 1, Bytes: fl.bytes / 2},
+					BA: &flow.FlowEndpointStatistics{Packets: 1, Bytes: fl.bytes / 2},
+				},
+			},
+		}
+	}
+
+	return &FlowApi{Graph: g, FlowTable: ft}
+}
+
+func flowQueryUUIDs(t *testing.T, fa *FlowApi, query string) []string {
+	values, err := fa.Query(query, false)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err.Error())
+	}
+
+	uuids := []string{}
+	for _, v := range values {
+		uuids = append(uuids, v.(*flow.Flow).UUID)
+	}
+	return uuids
+}
+
+func TestFlowQuery(t *testing.T) {
+	fa := newFlowQueryTestApi(t)
+
+	for query, expected := range map[string]string{
+		`G.Flows().Sort('Bytes')`:                                                                "[flow4 flow2 flow3 flow1]",
+		`G.Flows().Sort('Bytes', 'ASC').Limit(2)`:                                                "[flow1 flow3]",
+		`G.V().Has('Name', 'br0').Flows().Since(300).Sort('Bytes')`:                              "[flow2 flow1]",
+		`G.V().Has('Name', 'br0').Flows().Has('UUID', Ne('flow1')).Dedup().Sort('Bytes', 'ASC')`: "[flow3 flow2]",
+	} {
+		if got := fmt.Sprint(flowQueryUUIDs(t, fa, query)); got != expected {
+			t.Errorf("%s: expected %s, got %s", query, expected, got)
+		}
+	}
+
+	values, err := fa.Query(`G.V().Has('Name', 'br0').Flows().Since(300).Sum('Bytes')`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0].(int64) != 400 {
+		t.Errorf("expected a sum of 400 bytes, got %v", values)
+	}
+
+	for _, query := range []string{
+		`G.Flows().Sort('Unknown')`,
+		`G.Flows().Has('Unknown', 1)`,
+		`G.Flows().Limit('10')`,
+		`G.Flows().Sum('UUID')`,
+		`G.V().Sort('Bytes')`,
+	} {
+		if _, err := fa.Query(query, false); err == nil {
+			t.Errorf("%s: error expected", query)
+		}
+	}
+}
+
+func TestFlowQueryAllFlows(t *testing.T) {
+	fa := newFlowQueryTestApi(t)
+	st := &flowQueryTestStorage{}
+	fa.Storage = st
+
+	// only the live flows captured on the visible nodes are returned
+	if got := fmt.Sprint(flowQueryUUIDs(t, fa, `G.Flows().Sort('Bytes')`)); got != "[flow4 flow2 flow3 flow1]" {
+		t.Errorf("wrong flows: %s", got)
+	}
+	if len(st.searches) != 0 {
+		t.Errorf("stored flows searched without node: %v", st.searches)
+	}
+
+	values, err := fa.Query(`G.Flows().Has('UUID', 'flow5')`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Errorf("flow of a hidden node not returned with the hidden nodes: %v", values)
+	}
+
+	flowQueryUUIDs(t, fa, `G.V().Has('Name', 'eth1').Flows()`)
+	if len(st.searches) != 1 || st.searches[0]["ProbeNodeUUID"] != "other" {
+		t.Errorf("stored flows not searched by node: %v", st.searches)
+	}
+}
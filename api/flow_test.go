@@ -24,11 +24,14 @@ package api
 
 import (
 	"encoding/json"
+	"net/http"
 	"testing"
 
+	"github.com/abbot/go-http-auth"
 	v "github.com/gima/govalid/v1"
 
 	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/topology/graph"
 )
 
 func TestFlowTable_jsonFlowConversationEthernetPath(t *testing.T) {
@@ -108,3 +111,92 @@ func TestFlowTable_jsonFlowDiscovery(t *testing.T) {
 	test_jsonFlowDiscovery(t, packets)
 	t.Log("jsonFlowDiscovery PACKETS : ok")
 }
+
+func TestFlowTraversing(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := g.NewNode(graph.Identifier("host"), graph.Metadata{"Type": "host"})
+	br := g.NewNode(graph.Identifier("br"), graph.Metadata{"Type": "bridge"})
+	tap := g.NewNode(graph.Identifier("tap"), graph.Metadata{"Type": "tun"})
+	eth0 := g.NewNode(graph.Identifier("eth0"), graph.Metadata{"Type": "device"})
+	g.NewEdge(graph.Identifier("e0"), host, br, graph.Metadata{"RelationType": "ownership"})
+	g.NewEdge(graph.Identifier("e1"), host, eth0, graph.Metadata{"RelationType": "ownership"})
+	g.NewEdge(graph.Identifier("e2"), br, tap, graph.Metadata{"RelationType": "layer2"})
+
+	if nodes := captureNodes(g, host); len(nodes) != 4 {
+		t.Errorf("The host and the nodes it owns expected, got %v", nodes)
+	}
+
+	nodes := captureNodes(g, br)
+	if len(nodes) != 2 || !nodes["br"] || !nodes["tap"] {
+		t.Errorf("The bridge and its port expected, got %v", nodes)
+	}
+
+	f := &flow.Flow{ProbeNodeUUID: "tap", Statistics: &flow.FlowStatistics{Start: 100, Last: 200}}
+	if !flowTraverses(f, nodes, 150, 300) {
+		t.Error("The flow captured on the port should traverse the bridge")
+	}
+	if flowTraverses(f, nodes, 250, 300) {
+		t.Error("The flow ended before the time range")
+	}
+
+	f = &flow.Flow{ProbeNodeUUID: "eth0", Statistics: &flow.FlowStatistics{Start: 100, Last: 200}}
+	if flowTraverses(f, nodes, 0, 300) {
+		t.Error("The flow captured on eth0 shouldn't traverse the bridge")
+	}
+}
+
+func TestFlowTraversingPath(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := g.NewNode(graph.Identifier("host"), graph.Metadata{"Type": "host"})
+	tap1 := g.NewNode(graph.Identifier("tap1"), graph.Metadata{"Type": "tun"})
+	tap2 := g.NewNode(graph.Identifier("tap2"), graph.Metadata{"Type": "tun"})
+	br1 := g.NewNode(graph.Identifier("br1"), graph.Metadata{"Type": "bridge"})
+	br2 := g.NewNode(graph.Identifier("br2"), graph.Metadata{"Type": "bridge"})
+	port := g.NewNode(graph.Identifier("port"), graph.Metadata{"Type": "veth"})
+	g.NewEdge(graph.Identifier("e0"), host, tap1, graph.Metadata{"RelationType": "ownership"})
+	g.NewEdge(graph.Identifier("e1"), host, tap2, graph.Metadata{"RelationType": "ownership"})
+	g.NewEdge(graph.Identifier("e2"), br1, tap1, graph.Metadata{"RelationType": "layer2"})
+	g.NewEdge(graph.Identifier("e3"), br1, br2, graph.Metadata{"RelationType": "layer2"})
+	g.NewEdge(graph.Identifier("e4"), br2, tap2, graph.Metadata{"RelationType": "layer2"})
+	g.NewEdge(graph.Identifier("e5"), br2, port, graph.Metadata{"RelationType": "layer2"})
+
+	req, err := http.NewRequest("GET", "/api/flow/traversing?src=tap1&dst=tap2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &FlowApi{Graph: g}
+	nodes, status, msg := f.traversingNodes(&auth.AuthenticatedRequest{Request: *req})
+	if status != http.StatusOK {
+		t.Fatalf("Path not found: %d %s", status, msg)
+	}
+
+	// the path goes through the bridges, not through the owner, the ports
+	// of the bridges capturing the flows too
+	for _, id := range []string{"tap1", "br1", "br2", "tap2", "port"} {
+		if !nodes[id] {
+			t.Errorf("%s expected in the path, got %v", id, nodes)
+		}
+	}
+	if nodes["host"] {
+		t.Errorf("The path shouldn't go through the owner: %v", nodes)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// captureNodes returns the nodes whose flows traverse the node: the node,
+// the ones it owns and the ports of the bridges among them
+func captureNodes(g *graph.Graph, n *graph.Node) map[string]bool {
+	nodes := map[string]bool{string(n.ID): true}
+
+	owned := append([]*graph.Node{n}, g.Reachable(n, graph.DirectionOut, graph.Metadata{"RelationType": "ownership"})...)
+	for _, o := range owned {
+		nodes[string(o.ID)] = true
+
+		switch o.Metadata()["Type"] {
+		case "bridge", "ovsbridge":
+			for _, port := range g.LookupChildren(o, graph.Metadata{}) {
+				nodes[string(port.ID)] = true
+				// the interfaces of the ports of the Open vSwitch bridges
+				if port.Metadata()["Type"] == "ovsport" {
+					for _, intf := range g.LookupChildren(port, graph.Metadata{}) {
+						nodes[string(intf.ID)] = true
+					}
+				}
+			}
+		}
+	}
+
+	return nodes
+}
+
+// flowTraverses returns whether the flow was observed by one of the nodes
+// between the from and to unix times
+func flowTraverses(f *flow.Flow, nodes map[string]bool, from, to int64) bool {
+	if !nodes[f.ProbeNodeUUID] && !nodes[f.IfSrcNodeUUID] && !nodes[f.IfDstNodeUUID] {
+		return false
+	}
+
+	fs := f.GetStatistics()
+	return fs != nil && fs.Start <= to && fs.Last >= from
+}
+
+// traversingNodes returns the capture nodes of the ?node=ID node, or of
+// the nodes of the shortest layer2 path between ?src=ID and ?dst=ID
+func (f *FlowApi) traversingNodes(r *auth.AuthenticatedRequest) (map[string]bool, int, string) {
+	params := r.URL.Query()
+
+	f.Graph.RLock()
+	defer f.Graph.RUnlock()
+
+	if id := params.Get("node"); id != "" {
+		n := f.Graph.GetNode(graph.Identifier(id))
+		if n == nil {
+			return nil, http.StatusNotFound, "Node not found: " + id
+		}
+		return captureNodes(f.Graph, n), http.StatusOK, ""
+	}
+
+	src, dst := params.Get("src"), params.Get("dst")
+	if src == "" || dst == "" {
+		return nil, http.StatusBadRequest, "Either node or src and dst parameters expected"
+	}
+
+	srcNode, dstNode := f.Graph.GetNode(graph.Identifier(src)), f.Graph.GetNode(graph.Identifier(dst))
+	if srcNode == nil || dstNode == nil {
+		return nil, http.StatusNotFound, "Nodes not found: " + src + ", " + dst
+	}
+
+	path := f.Graph.ShortestPath(srcNode, dstNode, graph.DirectionBoth, graph.Metadata{"RelationType": "layer2"})
+	if len(path) == 0 {
+		return nil, http.StatusNotFound, "No path between " + src + " and " + dst
+	}
+
+	nodes := make(map[string]bool)
+	for _, n := range path {
+		for id := range captureNodes(f.Graph, n) {
+			nodes[id] = true
+		}
+	}
+	return nodes, http.StatusOK, ""
+}
+
+// flowsTraversing returns the flows of the flow table and of the storage
+// observed traversing a node or a path between the from and to RFC3339
+// times, the last hour by default, ex: /api/flow/traversing?node=ID or
+// /api/flow/traversing?src=ID&dst=ID&from=...
+func (f *FlowApi) flowsTraversing(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	now := time.Now()
+	from, err := parseTime(r.URL.Query().Get("from"), now.Add(-time.Hour))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	to, err := parseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	nodes, status, msg := f.traversingNodes(r)
+	if nodes == nil {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+
+	flows := []*flow.Flow{}
+	seen := make(map[string]bool)
+	add := func(fl *flow.Flow) {
+		if !seen[fl.UUID] && flowTraverses(fl, nodes, from.Unix(), to.Unix()) {
+			seen[fl.UUID] = true
+			flows = append(flows, fl)
+		}
+	}
+
+	for _, fl := range f.FlowTable.GetFlows() {
+		add(fl)
+	}
+
+	// the stored flows are looked up by capture point, the live ones
+	// having precedence
+	if f.Storage != nil {
+		for id := range nodes {
+			stored, err := f.Storage.SearchFlows(storage.Filters{"ProbeNodeUUID": id})
+			if err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			for _, fl := range stored {
+				add(fl)
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(flows); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,648 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// GraphQLApi answers the GraphQL queries over the topology and the flows.
+//
+//	type Query {
+//	  Nodes(Metadata: Object): [Node]
+//	  Node(ID: String!): Node
+//	  Edges(Metadata: Object): [Edge]
+//	  Edge(ID: String!): Edge
+//	  Flows(Filters: Object): [Flow]
+//	  StoredFlows(Filters: Object): [Flow]
+//	}
+//	type Node {
+//	  ID, Host: String
+//	  Metadata(Key: String): Object
+//	  Parents(Metadata: Object), Children(Metadata: Object): [Node]
+//	  Edges(Metadata: Object): [Edge]
+//	  Flows(Filters: Object): [Flow]
+//	}
+//	type Edge {
+//	  ID, Host: String
+//	  Metadata(Key: String): Object
+//	  Parent, Child: Node
+//	}
+//	type Flow {
+//	  the fields of the flows, UUID, LayersPath, Statistics...
+//	  ProbeNode, IfSrcNode, IfDstNode: Node
+//	  ParentFlow: Flow
+//	}
+//
+// The flow filters match the fields of the flows having the given values.
+type GraphQLApi struct {
+	Service   string
+	Graph     *graph.Graph
+	FlowTable *flow.Table
+	Storage   storage.Storage
+	Auth      shttp.AuthenticationBackend
+}
+
+// GraphQLRequest is a query, resolved without the hidden nodes and edges
+// unless WithHidden is set, only for the admins asking for them
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	WithHidden    bool                   `json:"-"`
+}
+
+type GraphQLError struct {
+	Message string `json:"message"`
+}
+
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// graphqlQuery is the root object of the queries
+type graphqlQuery struct{}
+
+// graphqlObject keeps the fields in the order of the selection
+type graphqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *graphqlObject) set(k string, v interface{}) {
+	if _, found := o.values[k]; !found {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+func (o *graphqlObject) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, k := range o.keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(append(b, kb...), ':'), vb...)
+	}
+	return append(b, '}'), nil
+}
+
+type graphqlExecutor struct {
+	api       *GraphQLApi
+	graph     *graph.Graph
+	variables map[string]interface{}
+	flows     []*flow.Flow
+	flowsByID map[string]*flow.Flow
+}
+
+func (e *graphqlExecutor) resolveValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case graphqlVariable:
+		return e.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.resolveValue(item)
+		}
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{})
+		for k, item := range v {
+			object[k] = e.resolveValue(item)
+		}
+		return object
+	}
+	return v
+}
+
+func checkGraphqlArguments(f *graphqlField, args map[string]interface{}, allowed ...string) error {
+	for name := range args {
+		known := false
+		for _, a := range allowed {
+			known = known || name == a
+		}
+		if !known {
+			return fmt.Errorf("unknown argument %s of field %s", name, f.name)
+		}
+	}
+	return nil
+}
+
+func graphqlStringArgument(f *graphqlField, args map[string]interface{}, name string) (string, error) {
+	s, ok := args[name].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %s of field %s must be a string", name, f.name)
+	}
+	return s, nil
+}
+
+func graphqlObjectArgument(f *graphqlField, args map[string]interface{}, name string) (map[string]interface{}, error) {
+	switch o := args[name].(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return o, nil
+	}
+	return nil, fmt.Errorf("argument %s of field %s must be an object", name, f.name)
+}
+
+func (e *graphqlExecutor) metadataArgument(f *graphqlField, args map[string]interface{}) (graph.Metadata, error) {
+	if err := checkGraphqlArguments(f, args, "Metadata"); err != nil {
+		return nil, err
+	}
+
+	m, err := graphqlObjectArgument(f, args, "Metadata")
+	return graph.Metadata(m), err
+}
+
+func (e *graphqlExecutor) metadata(f *graphqlField, args map[string]interface{}, m graph.Metadata) (interface{}, error) {
+	if err := checkGraphqlArguments(f, args, "Key"); err != nil {
+		return nil, err
+	}
+
+	if _, found := args["Key"]; !found {
+		return m, nil
+	}
+
+	key, err := graphqlStringArgument(f, args, "Key")
+	if err != nil {
+		return nil, err
+	}
+	return m[key], nil
+}
+
+func flowField(f *flow.Flow, name string) (interface{}, bool) {
+	v := reflect.ValueOf(f).Elem().FieldByName(name)
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func filterFlows(flows []*flow.Flow, filters map[string]interface{}) ([]*flow.Flow, error) {
+	for k := range filters {
+		if _, ok := flowField(&flow.Flow{}, k); !ok {
+			return nil, fmt.Errorf("unknown flow field %s", k)
+		}
+	}
+
+	result := []*flow.Flow{}
+	for _, f := range flows {
+		match := true
+		for k, v := range filters {
+			if fv, _ := flowField(f, k); !common.CrossTypeEqual(fv, v) {
+				match = false
+				break
+			}
+		}
+		if match {
+			result = append(result, f)
+		}
+	}
+
+	return result, nil
+}
+
+func (e *graphqlExecutor) tableFlows(f *graphqlField, args map[string]interface{}, filters map[string]interface{}) ([]*flow.Flow, error) {
+	if err := checkGraphqlArguments(f, args, "Filters"); err != nil {
+		return nil, err
+	}
+
+	if e.api.FlowTable == nil {
+		return nil, fmt.Errorf("no flow table available")
+	}
+
+	extra, err := graphqlObjectArgument(f, args, "Filters")
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		filters[k] = v
+	}
+
+	return filterFlows(e.flows, filters)
+}
+
+func (e *graphqlExecutor) storedFlows(f *graphqlField, args map[string]interface{}) ([]*flow.Flow, error) {
+	if err := checkGraphqlArguments(f, args, "Filters"); err != nil {
+		return nil, err
+	}
+
+	if e.api.Storage == nil {
+		return nil, fmt.Errorf("no flow storage available")
+	}
+
+	filters, err := graphqlObjectArgument(f, args, "Filters")
+	if err != nil {
+		return nil, err
+	}
+
+	return e.api.Storage.SearchFlows(storage.Filters(filters))
+}
+
+func (e *graphqlExecutor) resolveQuery(f *graphqlField, args map[string]interface{}) (interface{}, error) {
+	g := e.graph
+
+	switch f.name {
+	case "Nodes":
+		m, err := e.metadataArgument(f, args)
+		if err != nil {
+			return nil, err
+		}
+		return g.LookupNodes(m), nil
+	case "Node", "Edge":
+		if err := checkGraphqlArguments(f, args, "ID"); err != nil {
+			return nil, err
+		}
+		id, err := graphqlStringArgument(f, args, "ID")
+		if err != nil {
+			return nil, err
+		}
+		if f.name == "Node" {
+			return g.GetNode(graph.Identifier(id)), nil
+		}
+		return g.GetEdge(graph.Identifier(id)), nil
+	case "Edges":
+		m, err := e.metadataArgument(f, args)
+		if err != nil {
+			return nil, err
+		}
+		return g.LookupEdges(m), nil
+	case "Flows":
+		return e.tableFlows(f, args, map[string]interface{}{})
+	case "StoredFlows":
+		return e.storedFlows(f, args)
+	}
+
+	return nil, fmt.Errorf("unknown field %s of type Query", f.name)
+}
+
+func (e *graphqlExecutor) resolveNode(n *graph.Node, f *graphqlField, args map[string]interface{}) (interface{}, error) {
+	g := e.graph
+
+	switch f.name {
+	case "Metadata":
+		return e.metadata(f, args, n.Metadata())
+	case "Parents", "Children", "Edges":
+		m, err := e.metadataArgument(f, args)
+		if err != nil {
+			return nil, err
+		}
+		switch f.name {
+		case "Parents":
+			return g.LookupParentNodes(n, m), nil
+		case "Children":
+			return g.LookupChildren(n, m), nil
+		}
+		return g.GetNodeEdges(n, m), nil
+	case "Flows":
+		return e.tableFlows(f, args, map[string]interface{}{"ProbeNodeUUID": string(n.ID)})
+	}
+
+	if err := checkGraphqlArguments(f, args); err != nil {
+		return nil, err
+	}
+
+	switch f.name {
+	case "ID":
+		return string(n.ID), nil
+	case "Host":
+		return n.Host(), nil
+	}
+
+	return nil, fmt.Errorf("unknown field %s of type Node", f.name)
+}
+
+func (e *graphqlExecutor) resolveEdge(edge *graph.Edge, f *graphqlField, args map[string]interface{}) (interface{}, error) {
+	if f.name == "Metadata" {
+		return e.metadata(f, args, edge.Metadata())
+	}
+
+	if err := checkGraphqlArguments(f, args); err != nil {
+		return nil, err
+	}
+
+	switch f.name {
+	case "ID":
+		return string(edge.ID), nil
+	case "Host":
+		return edge.Host(), nil
+	case "Parent":
+		return e.graph.GetNode(edge.Parent()), nil
+	case "Child":
+		return e.graph.GetNode(edge.Child()), nil
+	}
+
+	return nil, fmt.Errorf("unknown field %s of type Edge", f.name)
+}
+
+func (e *graphqlExecutor) resolveFlow(fl *flow.Flow, f *graphqlField, args map[string]interface{}) (interface{}, error) {
+	if err := checkGraphqlArguments(f, args); err != nil {
+		return nil, err
+	}
+
+	switch f.name {
+	case "ProbeNode":
+		return e.graph.GetNode(graph.Identifier(fl.ProbeNodeUUID)), nil
+	case "IfSrcNode":
+		return e.graph.GetNode(graph.Identifier(fl.IfSrcNodeUUID)), nil
+	case "IfDstNode":
+		return e.graph.GetNode(graph.Identifier(fl.IfDstNodeUUID)), nil
+	case "ParentFlow":
+		if e.flowsByID == nil {
+			e.flowsByID = make(map[string]*flow.Flow)
+			for _, f := range e.flows {
+				e.flowsByID[f.UUID] = f
+			}
+		}
+		return e.flowsByID[fl.ParentUUID], nil
+	}
+
+	if v, ok := flowField(fl, f.name); ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unknown field %s of type Flow", f.name)
+}
+
+func (e *graphqlExecutor) resolve(obj interface{}, f *graphqlField) (interface{}, error) {
+	args := e.resolveValue(f.args).(map[string]interface{})
+
+	if f.name == "__typename" {
+		if err := checkGraphqlArguments(f, args); err != nil {
+			return nil, err
+		}
+		switch obj.(type) {
+		case *graph.Node:
+			return "Node", nil
+		case *graph.Edge:
+			return "Edge", nil
+		case *flow.Flow:
+			return "Flow", nil
+		}
+		return "Query", nil
+	}
+
+	switch obj := obj.(type) {
+	case *graph.Node:
+		return e.resolveNode(obj, f, args)
+	case *graph.Edge:
+		return e.resolveEdge(obj, f, args)
+	case *flow.Flow:
+		return e.resolveFlow(obj, f, args)
+	}
+	return e.resolveQuery(f, args)
+}
+
+func (e *graphqlExecutor) completeList(f *graphqlField, items []interface{}) (interface{}, error) {
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		v, err := e.complete(f, item)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+// complete applies the selection of the field to its resolved value
+func (e *graphqlExecutor) complete(f *graphqlField, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case *graph.Node:
+		if v == nil {
+			return nil, nil
+		}
+	case *graph.Edge:
+		if v == nil {
+			return nil, nil
+		}
+	case *flow.Flow:
+		if v == nil {
+			return nil, nil
+		}
+	case []*graph.Node:
+		items := make([]interface{}, len(v))
+		for i, n := range v {
+			items[i] = n
+		}
+		return e.completeList(f, items)
+	case []*graph.Edge:
+		items := make([]interface{}, len(v))
+		for i, edge := range v {
+			items[i] = edge
+		}
+		return e.completeList(f, items)
+	case []*flow.Flow:
+		items := make([]interface{}, len(v))
+		for i, fl := range v {
+			items[i] = fl
+		}
+		return e.completeList(f, items)
+	default:
+		if len(f.selection) != 0 {
+			return nil, fmt.Errorf("field %s can't have a selection", f.name)
+		}
+		return v, nil
+	}
+
+	if len(f.selection) == 0 {
+		return nil, fmt.Errorf("field %s must have a selection", f.name)
+	}
+	return e.execute(v, f.selection)
+}
+
+func (e *graphqlExecutor) execute(obj interface{}, selection []*graphqlField) (*graphqlObject, error) {
+	result := &graphqlObject{values: make(map[string]interface{})}
+
+	for _, f := range selection {
+		v, err := e.resolve(obj, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if v, err = e.complete(f, v); err != nil {
+			return nil, err
+		}
+		result.set(f.key(), v)
+	}
+
+	return result, nil
+}
+
+func selectGraphqlOperation(operations []*graphqlOperation, name string) (*graphqlOperation, error) {
+	if name == "" {
+		if len(operations) > 1 {
+			return nil, fmt.Errorf("an operation name is required to select one of the %d operations", len(operations))
+		}
+		return operations[0], nil
+	}
+
+	for _, op := range operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %s", name)
+}
+
+// Query executes a GraphQL query, the returned data being encoded in JSON
+// in the order of the selections
+func (q *GraphQLApi) Query(request *GraphQLRequest) (interface{}, error) {
+	operations, err := parseGraphqlDocument(request.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := selectGraphqlOperation(operations, request.OperationName)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]interface{})
+	for _, def := range op.variables {
+		v, found := request.Variables[def.name]
+		if !found {
+			v = def.value
+		}
+		if v == nil && def.required {
+			return nil, fmt.Errorf("variable $%s is required", def.name)
+		}
+		variables[def.name] = v
+	}
+
+	e := &graphqlExecutor{api: q, variables: variables}
+
+	// the flows are retrieved before locking the graph so that the flow
+	// table is never locked while holding the graph lock
+	if q.FlowTable != nil {
+		e.flows = q.FlowTable.GetFlows()
+	}
+
+	q.Graph.RLock()
+	defer q.Graph.RUnlock()
+
+	e.graph = visibleGraph(q.Graph, request.WithHidden)
+
+	result, err := e.execute(&graphqlQuery{}, op.selection)
+	if err != nil {
+		return nil, err
+	}
+
+	// encoded while holding the lock as the metadata are shared with
+	// the graph
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(data), nil
+}
+
+func (q *GraphQLApi) query(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var request GraphQLRequest
+	if r.Method == "GET" {
+		params := r.URL.Query()
+		request.Query = params.Get("query")
+		request.OperationName = params.Get("operationName")
+		if v := params.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &request.Variables); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(&GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+				return
+			}
+		}
+	} else {
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+			return
+		}
+	}
+
+	request.WithHidden = withHidden(q.Auth, r)
+
+	data, err := q.Query(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&GraphQLResponse{Data: data}); err != nil {
+		panic(err)
+	}
+}
+
+func (q *GraphQLApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"GraphQLQuery",
+			"GET",
+			"/api/graphql",
+			q.query,
+		},
+		{
+			"GraphQLQueryPost",
+			"POST",
+			"/api/graphql",
+			q.query,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterGraphQLApi(s string, g *graph.Graph, f *flow.Table, st storage.Storage, r *shttp.Server) {
+	q := &GraphQLApi{
+		Service:   s,
+		Graph:     g,
+		FlowTable: f,
+		Storage:   st,
+		Auth:      r.Auth,
+	}
+
+	q.registerEndpoints(r)
+}
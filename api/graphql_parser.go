@@ -0,0 +1,491 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the subset of the GraphQL language supported by the API: query operations
+// with variables, aliases, arguments and nested selections. Fragments,
+// directives, mutations and subscriptions are rejected.
+
+type graphqlTokenKind int
+
+const (
+	graphqlEOF graphqlTokenKind = iota
+	graphqlPunct
+	graphqlName
+	graphqlInt
+	graphqlFloat
+	graphqlString
+)
+
+type graphqlToken struct {
+	kind graphqlTokenKind
+	text string
+	pos  int
+}
+
+// graphqlVariable is a reference to a variable of the operation in a value
+type graphqlVariable string
+
+type graphqlField struct {
+	alias     string
+	name      string
+	args      map[string]interface{}
+	selection []*graphqlField
+}
+
+func (f *graphqlField) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type graphqlVariableDef struct {
+	name     string
+	required bool
+	value    interface{}
+}
+
+type graphqlOperation struct {
+	name      string
+	variables []*graphqlVariableDef
+	selection []*graphqlField
+}
+
+func isGraphqlNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isGraphqlDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func scanGraphqlString(src string, start int) (string, int, error) {
+	var s []rune
+	for i := start + 1; i < len(src); {
+		switch c := src[i]; c {
+		case '"':
+			return string(s), i + 1, nil
+		case '\n', '\r':
+			return "", 0, fmt.Errorf("unterminated string at %d", start)
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, fmt.Errorf("unterminated string at %d", start)
+			}
+			switch e := src[i+1]; e {
+			case '"', '\\', '/':
+				s = append(s, rune(e))
+			case 'b':
+				s = append(s, '\b')
+			case 'f':
+				s = append(s, '\f')
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'u':
+				if i+6 > len(src) {
+					return "", 0, fmt.Errorf("invalid unicode escape at %d", i)
+				}
+				r, err := strconv.ParseUint(src[i+2:i+6], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid unicode escape at %d", i)
+				}
+				s = append(s, rune(r))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence at %d", i)
+			}
+			i += 2
+		default:
+			r, size := utf8.DecodeRuneInString(src[i:])
+			s = append(s, r)
+			i += size
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %d", start)
+}
+
+func scanGraphqlTokens(src string) ([]graphqlToken, error) {
+	var tokens []graphqlToken
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, graphqlToken{graphqlPunct, "...", i})
+			i += 3
+		case strings.IndexByte("{}()[]:=!$@|&", c) != -1:
+			tokens = append(tokens, graphqlToken{graphqlPunct, string(c), i})
+			i++
+		case isGraphqlNameStart(c):
+			j := i + 1
+			for j < len(src) && (isGraphqlNameStart(src[j]) || isGraphqlDigit(src[j])) {
+				j++
+			}
+			tokens = append(tokens, graphqlToken{graphqlName, src[i:j], i})
+			i = j
+		case c == '-' || isGraphqlDigit(c):
+			j, kind := i+1, graphqlInt
+			for j < len(src) {
+				if d := src[j]; isGraphqlDigit(d) {
+					j++
+				} else if d == '.' || d == 'e' || d == 'E' {
+					kind = graphqlFloat
+					j++
+				} else if (d == '+' || d == '-') && (src[j-1] == 'e' || src[j-1] == 'E') {
+					j++
+				} else {
+					break
+				}
+			}
+			tokens = append(tokens, graphqlToken{kind, src[i:j], i})
+			i = j
+		case c == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				return nil, fmt.Errorf("block strings are not supported at %d", i)
+			}
+			s, j, err := scanGraphqlString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, graphqlToken{graphqlString, s, i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+
+	return append(tokens, graphqlToken{graphqlEOF, "", len(src)}), nil
+}
+
+type graphqlParser struct {
+	tokens []graphqlToken
+	pos    int
+}
+
+func (p *graphqlParser) peek() graphqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *graphqlParser) next() graphqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != graphqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *graphqlParser) is(punct string) bool {
+	t := p.peek()
+	return t.kind == graphqlPunct && t.text == punct
+}
+
+func (p *graphqlParser) unexpected(t graphqlToken) error {
+	if t.kind == graphqlEOF {
+		return fmt.Errorf("unexpected end of query")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *graphqlParser) expect(punct string) error {
+	if t := p.next(); t.kind != graphqlPunct || t.text != punct {
+		return p.unexpected(t)
+	}
+	return nil
+}
+
+func (p *graphqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != graphqlName {
+		return "", p.unexpected(t)
+	}
+	return t.text, nil
+}
+
+func (p *graphqlParser) parseValue(constant bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case graphqlInt:
+		return strconv.ParseInt(t.text, 10, 64)
+	case graphqlFloat:
+		return strconv.ParseFloat(t.text, 64)
+	case graphqlString:
+		return t.text, nil
+	case graphqlName:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		// enum values are handled as strings
+		return t.text, nil
+	case graphqlPunct:
+		switch t.text {
+		case "$":
+			if constant {
+				return nil, p.unexpected(t)
+			}
+			name, err := p.name()
+			return graphqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.is("]") {
+				v, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			object := map[string]interface{}{}
+			for !p.is("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if object[name], err = p.parseValue(constant); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+			return object, nil
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+func (p *graphqlParser) parseArguments() (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if !p.is("(") {
+		return args, nil
+	}
+	p.next()
+
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, found := args[name]; found {
+			return nil, fmt.Errorf("duplicate argument %s", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.parseValue(false); err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+
+	return args, nil
+}
+
+func (p *graphqlParser) parseSelection() ([]*graphqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selection []*graphqlField
+	for !p.is("}") {
+		if p.is("...") {
+			return nil, fmt.Errorf("fragments are not supported at %d", p.peek().pos)
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		field := &graphqlField{name: name}
+		if p.is(":") {
+			p.next()
+			field.alias = name
+			if field.name, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+
+		if field.args, err = p.parseArguments(); err != nil {
+			return nil, err
+		}
+
+		if p.is("@") {
+			return nil, fmt.Errorf("directives are not supported at %d", p.peek().pos)
+		}
+
+		if p.is("{") {
+			if field.selection, err = p.parseSelection(); err != nil {
+				return nil, err
+			}
+		}
+
+		selection = append(selection, field)
+	}
+	p.next()
+
+	if len(selection) == 0 {
+		return nil, fmt.Errorf("empty selection")
+	}
+
+	return selection, nil
+}
+
+// parseType skips the type of a variable definition, returning whether the
+// variable is required
+func (p *graphqlParser) parseType() (bool, error) {
+	if p.is("[") {
+		p.next()
+		if _, err := p.parseType(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+
+	if p.is("!") {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *graphqlParser) parseVariables() ([]*graphqlVariableDef, error) {
+	var defs []*graphqlVariableDef
+	if !p.is("(") {
+		return defs, nil
+	}
+	p.next()
+
+	for !p.is(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		def := &graphqlVariableDef{name: name}
+		if def.required, err = p.parseType(); err != nil {
+			return nil, err
+		}
+
+		if p.is("=") {
+			p.next()
+			if def.value, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+
+		defs = append(defs, def)
+	}
+	p.next()
+
+	return defs, nil
+}
+
+func (p *graphqlParser) parseOperation() (*graphqlOperation, error) {
+	op := &graphqlOperation{}
+
+	if t := p.peek(); t.kind == graphqlName {
+		switch t.text {
+		case "query":
+			p.next()
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("%s operations are not supported", t.text)
+		case "fragment":
+			return nil, fmt.Errorf("fragments are not supported at %d", t.pos)
+		default:
+			return nil, p.unexpected(t)
+		}
+
+		if p.peek().kind == graphqlName {
+			op.name = p.next().text
+		}
+
+		var err error
+		if op.variables, err = p.parseVariables(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	op.selection, err = p.parseSelection()
+	return op, err
+}
+
+func parseGraphqlDocument(query string) ([]*graphqlOperation, error) {
+	tokens, err := scanGraphqlTokens(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &graphqlParser{tokens: tokens}
+
+	var operations []*graphqlOperation
+	for p.peek().kind != graphqlEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("no operation found")
+	}
+
+	return operations, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+func newGraphQLTestApi(t *testing.T) *GraphQLApi {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := g.NewNode(graph.Identifier("host"), graph.Metadata{"Type": "host", "Name": "h1"})
+	eth0 := g.NewNode(graph.Identifier("eth0"), graph.Metadata{"Type": "device", "Name": "eth0", "MTU": 1500})
+	eth1 := g.NewNode(graph.Identifier("eth1"), graph.Metadata{"Type": "device", "Name": "eth1", "MTU": 9000})
+	g.NewEdge(graph.Identifier("e0"), host, eth0, graph.Metadata{"RelationType": "ownership"})
+	g.NewEdge(graph.Identifier("e1"), host, eth1, graph.Metadata{"RelationType": "ownership"})
+
+	ft := flow.NewTable()
+	f, _ := ft.GetOrCreateFlow("flow1")
+	f.UUID = "flow1"
+	f.LayersPath = "Ethernet/IPv4/TCP"
+	f.ProbeNodeUUID = "eth0"
+	f, _ = ft.GetOrCreateFlow("flow2")
+	f.UUID = "flow2"
+	f.LayersPath = "Ethernet/IPv4/UDP"
+	f.ProbeNodeUUID = "eth1"
+	f.ParentUUID = "flow1"
+
+	return &GraphQLApi{Graph: g, FlowTable: ft}
+}
+
+func graphQLTestQuery(t *testing.T, q *GraphQLApi, request *GraphQLRequest) string {
+	data, err := q.Query(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(result)
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	q := newGraphQLTestApi(t)
+
+	result := graphQLTestQuery(t, q, &GraphQLRequest{Query: `
+		# devices of the host
+		query Devices($name: String = "h1", $mtu: Int!) {
+			hosts: Nodes(Metadata: {Type: host, Name: $name}) {
+				ID
+				__typename
+				Children(Metadata: {MTU: $mtu}) {
+					name: Metadata(Key: "Name")
+					Flows { UUID ProbeNode { ID } }
+				}
+			}
+		}`,
+		Variables: map[string]interface{}{"mtu": float64(1500)},
+	})
+
+	expected := `{"hosts":[{"ID":"host","__typename":"Node","Children":[{"name":"eth0","Flows":[{"UUID":"flow1","ProbeNode":{"ID":"eth0"}}]}]}]}`
+	if result != expected {
+		t.Errorf("expected %s, got %s", expected, result)
+	}
+
+	result = graphQLTestQuery(t, q, &GraphQLRequest{Query: `{
+		Edge(ID: "e1") { Parent { ID } Child { ID } }
+		Flows(Filters: {LayersPath: "Ethernet/IPv4/UDP"}) { UUID ParentFlow { LayersPath } }
+		Node(ID: "unknown") { ID }
+	}`})
+
+	expected = `{"Edge":{"Parent":{"ID":"host"},"Child":{"ID":"eth1"}},"Flows":[{"UUID":"flow2","ParentFlow":{"LayersPath":"Ethernet/IPv4/TCP"}}],"Node":null}`
+	if result != expected {
+		t.Errorf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	q := newGraphQLTestApi(t)
+
+	for _, request := range []*GraphQLRequest{
+		{Query: `{ Nodes { ID `},
+		{Query: `{ Nodes { ...Fields } }`},
+		{Query: `mutation { Nodes { ID } }`},
+		{Query: `{ Nodes { Unknown } }`},
+		{Query: `{ Nodes(Type: "host") { ID } }`},
+		{Query: `{ Nodes { Children } }`},
+		{Query: `{ Nodes { ID { Name } } }`},
+		{Query: `{ Flows(Filters: {Unknown: 1}) { UUID } }`},
+		{Query: `query($id: String!) { Node(ID: $id) { ID } }`},
+		{Query: `query A { Nodes { ID } } query B { Edges { ID } }`},
+	} {
+		if _, err := q.Query(request); err == nil {
+			t.Errorf("an error was expected for %s", request.Query)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/rpc"
+	"github.com/redhat-cip/skydive/storage"
+	"github.com/redhat-cip/skydive/topology"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// number of events a topology watcher can lag behind before being dropped
+const grpcWatchBuffer = 1000
+
+// GRPCApi serves the topology, flow and capture services over gRPC, the
+// credentials of the users being given by the username and password
+// metadata of the calls
+type GRPCApi struct {
+	Graph          *graph.Graph
+	FlowTable      *flow.Table
+	Storage        storage.Storage
+	CaptureHandler ApiHandler
+	Auth           shttp.AuthenticationBackend
+	addr           string
+	server         *grpc.Server
+	wg             sync.WaitGroup
+}
+
+type grpcTopology struct {
+	*GRPCApi
+}
+
+type grpcFlows struct {
+	*GRPCApi
+}
+
+type grpcCaptures struct {
+	*GRPCApi
+}
+
+// grpcWatcher forwards the events of the graph to a stream, only the ones
+// of the visible elements of the result of its query if any
+type grpcWatcher struct {
+	graph    *graph.Graph
+	sequence *graph.GremlinTraversalSequence
+	members  map[string]bool
+	events   chan *rpc.TopologyEvent
+	overflow chan bool
+}
+
+// authenticate checks the credentials of the call and the role of the user
+func (a *GRPCApi) authenticate(ctx context.Context, role shttp.Role) error {
+	var username, password string
+	if md, ok := metadata.FromContext(ctx); ok {
+		if v := md["username"]; len(v) > 0 {
+			username = v[0]
+		}
+		if v := md["password"]; len(v) > 0 {
+			password = v[0]
+		}
+	}
+
+	if _, err := a.Auth.Authenticate(username, password); err != nil {
+		return grpc.Errorf(codes.Unauthenticated, "%s", err.Error())
+	}
+
+	if !a.Auth.Role(username).Allows(role) {
+		return grpc.Errorf(codes.PermissionDenied, "%s is not allowed to call this method", username)
+	}
+
+	return nil
+}
+
+func rpcNode(n *graph.Node) *rpc.Node {
+	m, _ := json.Marshal(n.Metadata())
+	return &rpc.Node{ID: string(n.ID), Host: n.Host(), Metadata: string(m)}
+}
+
+func rpcEdge(e *graph.Edge) *rpc.Edge {
+	m, _ := json.Marshal(e.Metadata())
+	return &rpc.Edge{ID: string(e.ID), Host: e.Host(), Metadata: string(m), Parent: string(e.Parent()), Child: string(e.Child())}
+}
+
+// parseQuery parses a query on the graph without its hidden nodes and edges,
+// never given over gRPC
+func (a *GRPCApi) parseQuery(query string) (*graph.GremlinTraversalSequence, error) {
+	tr := graph.NewGremlinTraversalParser(strings.NewReader(query), a.Graph.Visible())
+	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
+
+	seq, err := tr.Parse()
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	return seq, nil
+}
+
+// Query returns the whole topology, or the result of the Gremlin query
+func (t *grpcTopology) Query(ctx context.Context, req *rpc.TopologyRequest) (*rpc.TopologyReply, error) {
+	if err := t.authenticate(ctx, shttp.ReaderRole); err != nil {
+		return nil, err
+	}
+
+	reply := &rpc.TopologyReply{}
+
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	if req.GremlinQuery == "" {
+		g := t.Graph.Visible()
+		for _, n := range g.GetNodes() {
+			reply.Nodes = append(reply.Nodes, rpcNode(n))
+		}
+		for _, e := range g.GetEdges() {
+			reply.Edges = append(reply.Edges, rpcEdge(e))
+		}
+		return reply, nil
+	}
+
+	seq, err := t.parseQuery(req.GremlinQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := seq.Exec()
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	for _, v := range res.Values() {
+		switch v := v.(type) {
+		case *graph.Node:
+			reply.Nodes = append(reply.Nodes, rpcNode(v))
+		case *graph.Edge:
+			reply.Edges = append(reply.Edges, rpcEdge(v))
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, grpc.Errorf(codes.Internal, "%s", err.Error())
+			}
+			reply.Values = append(reply.Values, string(b))
+		}
+	}
+
+	return reply, nil
+}
+
+// evalMembers evaluates the query of the watcher, called with the graph lock
+// held
+func (w *grpcWatcher) evalMembers() map[string]bool {
+	members := make(map[string]bool)
+
+	res, err := w.sequence.Exec()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to evaluate the watched query: %s", err.Error())
+		return members
+	}
+
+	for _, v := range res.Values() {
+		switch v := v.(type) {
+		case *graph.Node:
+			members["node:"+string(v.ID)] = true
+		case *graph.Edge:
+			members["edge:"+string(v.ID)] = true
+		}
+	}
+	return members
+}
+
+func (w *grpcWatcher) send(key string, ev *rpc.TopologyEvent) {
+	if w.sequence != nil {
+		members := w.evalMembers()
+		watched := members[key] || w.members[key]
+		w.members = members
+		if !watched {
+			return
+		}
+	}
+
+	select {
+	case w.events <- ev:
+	default:
+		select {
+		case w.overflow <- true:
+		default:
+		}
+	}
+}
+
+func (w *grpcWatcher) OnNodeAdded(n *graph.Node) {
+	if n.Hidden() {
+		return
+	}
+	w.send("node:"+string(n.ID), &rpc.TopologyEvent{Type: "NodeAdded", Node: rpcNode(n)})
+}
+
+func (w *grpcWatcher) OnNodeUpdated(n *graph.Node) {
+	if n.Hidden() {
+		return
+	}
+	w.send("node:"+string(n.ID), &rpc.TopologyEvent{Type: "NodeUpdated", Node: rpcNode(n)})
+}
+
+func (w *grpcWatcher) OnNodeDeleted(n *graph.Node) {
+	if n.Hidden() {
+		return
+	}
+	w.send("node:"+string(n.ID), &rpc.TopologyEvent{Type: "NodeDeleted", Node: rpcNode(n)})
+}
+
+func (w *grpcWatcher) OnEdgeAdded(e *graph.Edge) {
+	if w.graph.IsEdgeHidden(e) {
+		return
+	}
+	w.send("edge:"+string(e.ID), &rpc.TopologyEvent{Type: "EdgeAdded", Edge: rpcEdge(e)})
+}
+
+func (w *grpcWatcher) OnEdgeUpdated(e *graph.Edge) {
+	if w.graph.IsEdgeHidden(e) {
+		return
+	}
+	w.send("edge:"+string(e.ID), &rpc.TopologyEvent{Type: "EdgeUpdated", Edge: rpcEdge(e)})
+}
+
+func (w *grpcWatcher) OnEdgeDeleted(e *graph.Edge) {
+	if w.graph.IsEdgeHidden(e) {
+		return
+	}
+	w.send("edge:"+string(e.ID), &rpc.TopologyEvent{Type: "EdgeDeleted", Edge: rpcEdge(e)})
+}
+
+// Watch streams the graph events until the client cancels the call, a
+// client too slow to receive them being disconnected
+func (t *grpcTopology) Watch(req *rpc.TopologyRequest, stream rpc.Topology_WatchServer) error {
+	if err := t.authenticate(stream.Context(), shttp.ReaderRole); err != nil {
+		return err
+	}
+
+	w := &grpcWatcher{
+		graph:    t.Graph,
+		events:   make(chan *rpc.TopologyEvent, grpcWatchBuffer),
+		overflow: make(chan bool, 1),
+	}
+
+	if req.GremlinQuery != "" {
+		seq, err := t.parseQuery(req.GremlinQuery)
+		if err != nil {
+			return err
+		}
+		w.sequence = seq
+
+		t.Graph.RLock()
+		w.members = w.evalMembers()
+		t.Graph.RUnlock()
+	}
+
+	t.Graph.AddEventListener(w)
+	defer t.Graph.RemoveEventListener(w)
+
+	for {
+		select {
+		case ev := <-w.events:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		case <-w.overflow:
+			return grpc.Errorf(codes.ResourceExhausted, "Too many pending topology events")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Query returns the flows of the flow table, or the ones of the storage,
+// matching the filters
+func (f *grpcFlows) Query(ctx context.Context, req *rpc.FlowRequest) (*rpc.FlowReply, error) {
+	if err := f.authenticate(ctx, shttp.ReaderRole); err != nil {
+		return nil, err
+	}
+
+	filters := make(map[string]interface{})
+	for _, filter := range req.Filters {
+		filters[filter.Key] = filter.Value
+	}
+
+	var flows []*flow.Flow
+	var err error
+	if req.Stored {
+		if f.Storage == nil {
+			return nil, grpc.Errorf(codes.Unavailable, "No flow storage available")
+		}
+		flows, err = f.Storage.SearchFlows(storage.Filters(filters))
+	} else {
+		flows, err = filterFlows(f.FlowTable.GetFlows(), filters)
+	}
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	return &rpc.FlowReply{Flows: flows}, nil
+}
+
+func rpcCapture(c *Capture) *rpc.Capture {
+	return &rpc.Capture{
+		UUID:           c.UUID,
+		ProbePath:      c.ProbePath,
+		GremlinQuery:   c.GremlinQuery,
+		BPFFilter:      c.BPFFilter,
+		Type:           c.Type,
+		SnapLen:        int32(c.SnapLen),
+		Duration:       c.Duration,
+		RawPacketLimit: int32(c.RawPacketLimit),
+		Export:         c.Export,
+		ActiveTimeout:  int32(c.ActiveTimeout),
+		IdleTimeout:    int32(c.IdleTimeout),
+		SamplingRate:   c.SamplingRate,
+		PollingPeriod:  c.PollingPeriod,
+		PacketRate:     int32(c.PacketRate),
+		MaxBytes:       c.MaxBytes,
+	}
+}
+
+func (c *grpcCaptures) Create(ctx context.Context, req *rpc.Capture) (*rpc.Capture, error) {
+	if err := c.authenticate(ctx, shttp.AdminRole); err != nil {
+		return nil, err
+	}
+
+	capture := &Capture{
+		UUID:           req.UUID,
+		ProbePath:      req.ProbePath,
+		GremlinQuery:   req.GremlinQuery,
+		BPFFilter:      req.BPFFilter,
+		Type:           req.Type,
+		SnapLen:        int(req.SnapLen),
+		Duration:       req.Duration,
+		RawPacketLimit: int(req.RawPacketLimit),
+		Export:         req.Export,
+		ActiveTimeout:  int(req.ActiveTimeout),
+		IdleTimeout:    int(req.IdleTimeout),
+		SamplingRate:   req.SamplingRate,
+		PollingPeriod:  req.PollingPeriod,
+		PacketRate:     int(req.PacketRate),
+		MaxBytes:       req.MaxBytes,
+	}
+
+	if err := c.CaptureHandler.Create(capture); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+
+	return rpcCapture(capture), nil
+}
+
+func (c *grpcCaptures) Get(ctx context.Context, req *rpc.CaptureRequest) (*rpc.Capture, error) {
+	if err := c.authenticate(ctx, shttp.ReaderRole); err != nil {
+		return nil, err
+	}
+
+	resource, ok := c.CaptureHandler.Get(req.ID)
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "No capture %s", req.ID)
+	}
+
+	return rpcCapture(resource.(*Capture)), nil
+}
+
+func (c *grpcCaptures) List(ctx context.Context, req *rpc.Empty) (*rpc.CaptureList, error) {
+	if err := c.authenticate(ctx, shttp.ReaderRole); err != nil {
+		return nil, err
+	}
+
+	list := &rpc.CaptureList{}
+	for _, resource := range c.CaptureHandler.Index() {
+		list.Captures = append(list.Captures, rpcCapture(resource.(*Capture)))
+	}
+
+	return list, nil
+}
+
+func (c *grpcCaptures) Delete(ctx context.Context, req *rpc.CaptureRequest) (*rpc.Empty, error) {
+	if err := c.authenticate(ctx, shttp.AdminRole); err != nil {
+		return nil, err
+	}
+
+	if err := c.CaptureHandler.Delete(req.ID); err != nil {
+		return nil, grpc.Errorf(codes.NotFound, "%s", err.Error())
+	}
+
+	return &rpc.Empty{}, nil
+}
+
+func (a *GRPCApi) Start() error {
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.server.Serve(l); err != nil {
+			logging.GetLogger().Debugf("gRPC server stopped: %s", err.Error())
+		}
+	}()
+
+	logging.GetLogger().Infof("gRPC API listening on %s", a.addr)
+
+	return nil
+}
+
+func (a *GRPCApi) Stop() {
+	a.server.Stop()
+	a.wg.Wait()
+}
+
+func NewGRPCApi(addr string, g *graph.Graph, f *flow.Table, st storage.Storage, ch ApiHandler, auth shttp.AuthenticationBackend) *GRPCApi {
+	a := &GRPCApi{
+		Graph:          g,
+		FlowTable:      f,
+		Storage:        st,
+		CaptureHandler: ch,
+		Auth:           auth,
+		addr:           addr,
+		server:         grpc.NewServer(),
+	}
+
+	rpc.RegisterTopologyServer(a.server, &grpcTopology{a})
+	rpc.RegisterFlowsServer(a.server, &grpcFlows{a})
+	rpc.RegisterCapturesServer(a.server, &grpcCaptures{a})
+
+	return a
+}
+
+// NewGRPCApiFromConfig returns the gRPC API listening on the
+// analyzer.grpc_listen address, nil if not set
+func NewGRPCApiFromConfig(g *graph.Graph, f *flow.Table, st storage.Storage, ch ApiHandler, auth shttp.AuthenticationBackend) (*GRPCApi, error) {
+	if config.GetConfig().GetString("analyzer.grpc_listen") == "" {
+		return nil, nil
+	}
+
+	addr, port, err := config.GetHostPortAttributes("analyzer", "grpc_listen")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewGRPCApi(addr+":"+strconv.FormatInt(int64(port), 10), g, f, st, ch, auth), nil
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/rpc"
+)
+
+func TestGRPCApi(t *testing.T) {
+	q := newGraphQLTestApi(t)
+
+	a := NewGRPCApi("127.0.0.1:18083", q.Graph, q.FlowTable, nil, nil, shttp.NewNoAuthenticationBackend())
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	conn, err := grpc.Dial("127.0.0.1:18083", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	topology, err := rpc.NewTopologyClient(conn).Query(context.Background(), &rpc.TopologyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topology.Nodes) != 3 || len(topology.Edges) != 2 {
+		t.Errorf("Expected 3 nodes and 2 edges, got %d nodes and %d edges", len(topology.Nodes), len(topology.Edges))
+	}
+
+	topology, err = rpc.NewTopologyClient(conn).Query(context.Background(), &rpc.TopologyRequest{GremlinQuery: `G.V().Has("MTU", 9000)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topology.Nodes) != 1 || topology.Nodes[0].ID != "eth1" {
+		t.Errorf("Expected the eth1 node, got %v", topology.Nodes)
+	}
+
+	flows, err := rpc.NewFlowsClient(conn).Query(context.Background(), &rpc.FlowRequest{
+		Filters: []*rpc.FlowFilter{{Key: "ProbeNodeUUID", Value: "eth0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows.Flows) != 1 || flows.Flows[0].UUID != "flow1" {
+		t.Errorf("Expected the flow1 flow, got %v", flows.Flows)
+	}
+}
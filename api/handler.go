@@ -24,6 +24,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -34,12 +35,20 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/validator"
 )
 
 type ApiResource interface {
 	ID() string
 }
 
+// ExpiringApiResource is a resource removed once its time to live, if
+// not null, is elapsed
+type ExpiringApiResource interface {
+	ApiResource
+	TTL() time.Duration
+}
+
 type ApiHandler interface {
 	Name() string
 	New() ApiResource
@@ -133,14 +142,29 @@ func (h *BasicApiHandler) Get(id string) (ApiResource, bool) {
 	return resource, true
 }
 
+// Create validates the resource against the constraints of its fields
+// before storing it
 func (h *BasicApiHandler) Create(resource ApiResource) error {
+	if err := validator.Validate(resource); err != nil {
+		return err
+	}
+
+	if resource.ID() == "" {
+		return errors.New("The resource has no identifier")
+	}
+
 	data, err := json.Marshal(&resource)
 	if err != nil {
 		return err
 	}
 
+	var opts *etcd.SetOptions
+	if r, ok := resource.(ExpiringApiResource); ok && r.TTL() > 0 {
+		opts = &etcd.SetOptions{TTL: r.TTL()}
+	}
+
 	etcdPath := fmt.Sprintf("/%s/%s", h.ResourceHandler.Name(), resource.ID())
-	_, err = h.EtcdKeyAPI.Set(context.Background(), etcdPath, string(data), nil)
+	_, err = h.EtcdKeyAPI.Set(context.Background(), etcdPath, string(data), opts)
 	return err
 }
 
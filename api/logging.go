@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+type LoggingApi struct {
+	Service string
+}
+
+func (l *LoggingApi) writeLevels(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(logging.GetLevels()); err != nil {
+		panic(err)
+	}
+}
+
+func (l *LoggingApi) levels(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	l.writeLevels(w)
+}
+
+// setLevels changes the levels of the modules given as a map of the module
+// to its level, ex: {"topology/probes": "DEBUG"}
+func (l *LoggingApi) setLevels(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	var levels map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for module, level := range levels {
+		if err := logging.SetLevel(module, level); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		logging.GetLogger().Infof("Logging level of %s set to %s by %s", module, level, r.Username)
+	}
+
+	l.writeLevels(w)
+}
+
+func (l *LoggingApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"LoggingLevels",
+			"GET",
+			"/api/logging",
+			l.levels,
+		},
+		{
+			"LoggingSetLevels",
+			"PUT",
+			"/api/logging",
+			r.RequireRole(shttp.AdminRole, l.setLevels),
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterLoggingApi(s string, r *shttp.Server) {
+	l := &LoggingApi{
+		Service: s,
+	}
+
+	l.registerEndpoints(r)
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/packet_injector"
+	"github.com/redhat-cip/skydive/topology"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// time given to the agent of the destination to capture the packets
+const injectionVerifyDelay = 5 * time.Second
+
+type PacketInjectorApi struct {
+	Service     string
+	Graph       *graph.Graph
+	Client      *packet_injector.Client
+	TableClient *flow.TableClient
+}
+
+// PacketInjection selects the source and the destination nodes with gremlin
+// queries, the addresses of the nodes being used unless given
+type PacketInjection struct {
+	Src      string
+	Dst      string
+	Type     string
+	SrcIP    string
+	DstIP    string
+	SrcMAC   string
+	DstMAC   string
+	SrcPort  uint16
+	DstPort  uint16
+	Count    int
+	Interval int
+	Payload  string
+}
+
+// InjectionResult tells whether the packets have been received, Verified
+// being false when no capture runs on the destination node
+type InjectionResult struct {
+	Count    int
+	Verified bool
+	Received bool
+	Flows    []*flow.Flow
+}
+
+func (p *PacketInjectorApi) lookupNode(query string) (*graph.Node, error) {
+	tr := graph.NewGremlinTraversalParser(strings.NewReader(query), p.Graph)
+	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
+
+	ts, err := tr.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ts.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range res.Values() {
+		if n, ok := value.(*graph.Node); ok {
+			return n, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No node found for %s", query)
+}
+
+// nodeIPV4 returns the first IPv4 address of the node
+func nodeIPV4(n *graph.Node) string {
+	addrs, _ := n.Metadata()["IPV4"].(string)
+	for _, addr := range strings.Split(addrs, ",") {
+		if ip, _, err := net.ParseCIDR(strings.TrimSpace(addr)); err == nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// packetParams resolves the nodes of the injection, returns the parameters
+// of the packets and the destination node, nil if not given
+func (p *PacketInjectorApi) packetParams(pi *PacketInjection) (*packet_injector.PacketParams, *graph.Node, error) {
+	p.Graph.RLock()
+	defer p.Graph.RUnlock()
+
+	if pi.Src == "" {
+		return nil, nil, errors.New("The source node has to be given")
+	}
+
+	src, err := p.lookupNode(pi.Src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dst *graph.Node
+	if pi.Dst != "" {
+		if dst, err = p.lookupNode(pi.Dst); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pp := &packet_injector.PacketParams{
+		NodeID:   string(src.ID),
+		Type:     pi.Type,
+		SrcMAC:   pi.SrcMAC,
+		DstMAC:   pi.DstMAC,
+		SrcIP:    pi.SrcIP,
+		DstIP:    pi.DstIP,
+		SrcPort:  pi.SrcPort,
+		DstPort:  pi.DstPort,
+		ID:       uint16(rand.Intn(65536)),
+		Count:    pi.Count,
+		Interval: pi.Interval,
+		Payload:  pi.Payload,
+	}
+
+	if pp.Type == "" {
+		pp.Type = "icmp"
+	}
+	if pp.Count == 0 {
+		pp.Count = 1
+	}
+
+	if pp.SrcMAC == "" {
+		pp.SrcMAC, _ = src.Metadata()["MAC"].(string)
+	}
+	if pp.SrcIP == "" {
+		pp.SrcIP = nodeIPV4(src)
+	}
+	if dst != nil {
+		if pp.DstMAC == "" {
+			pp.DstMAC, _ = dst.Metadata()["MAC"].(string)
+		}
+		if pp.DstIP == "" {
+			pp.DstIP = nodeIPV4(dst)
+		}
+	}
+
+	if err := pp.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return pp, dst, nil
+}
+
+// injectedFlow returns whether the flow is the one of the injected packets
+func injectedFlow(pp *packet_injector.PacketParams, f *flow.Flow) bool {
+	var ips, ports bool
+	for _, ep := range f.GetStatistics().GetEndpoints() {
+		if ep.AB == nil || ep.BA == nil {
+			continue
+		}
+
+		switch ep.Type {
+		case flow.FlowEndpointType_IPV4:
+			ips = ep.AB.Value == pp.SrcIP && ep.BA.Value == pp.DstIP
+		case flow.FlowEndpointType_TCPPORT, flow.FlowEndpointType_UDPPORT:
+			if (ep.Type == flow.FlowEndpointType_TCPPORT) != (pp.Type == "tcp") {
+				return false
+			}
+			ports = ep.AB.Value == strconv.Itoa(int(pp.SrcPort)) && ep.BA.Value == strconv.Itoa(int(pp.DstPort))
+		}
+	}
+
+	if pp.Type == "icmp" {
+		return ips && strings.Contains(f.LayersPath, "ICMPv4")
+	}
+	return ips && ports
+}
+
+// verify looks for the flow of the injected packets in the flows captured
+// on the destination node
+func (p *PacketInjectorApi) verify(pp *packet_injector.PacketParams, dst *graph.Node) ([]*flow.Flow, error) {
+	deadline := time.Now().Add(injectionVerifyDelay)
+	for {
+		flows, err := p.TableClient.LookupFlowsByProbeNode(dst)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []*flow.Flow
+		for _, f := range flows {
+			if injectedFlow(pp, f) {
+				found = append(found, f)
+			}
+		}
+
+		if len(found) > 0 || time.Now().After(deadline) {
+			return found, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// injectPacket sends packets from the interface of the source node and,
+// when the destination node is captured, checks that they reach it
+func (p *PacketInjectorApi) injectPacket(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	var pi PacketInjection
+	if err := json.NewDecoder(r.Body).Decode(&pi); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	pp, dst, err := p.packetParams(&pi)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	p.Graph.RLock()
+	src := p.Graph.GetNode(graph.Identifier(pp.NodeID))
+	host := ""
+	if src != nil {
+		host = src.Host()
+	}
+	captured := dst != nil && dst.Metadata()["State.FlowCapture"] == "ON"
+	p.Graph.RUnlock()
+
+	if err := p.Client.InjectPacket(host, pp); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	result := InjectionResult{Count: pp.Count}
+	if captured {
+		flows, err := p.verify(pp, dst)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		result.Verified = true
+		result.Received = len(flows) > 0
+		result.Flows = flows
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logging.GetLogger().Criticalf("Failed to display the injection result: %s", err.Error())
+	}
+}
+
+func (p *PacketInjectorApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"InjectPacket",
+			"POST",
+			"/api/injectpacket",
+			r.RequireRole(shttp.AdminRole, p.injectPacket),
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterPacketInjectorApi(s string, g *graph.Graph, c *packet_injector.Client, tc *flow.TableClient, r *shttp.Server) {
+	p := &PacketInjectorApi{
+		Service:     s,
+		Graph:       g,
+		Client:      c,
+		TableClient: tc,
+	}
+
+	p.registerEndpoints(r)
+}
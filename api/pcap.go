@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const pcapSnapLen = 65535
+
+type PcapApi struct {
+	Service     string
+	Graph       *graph.Graph
+	FlowTable   *flow.Table
+	TableClient *flow.TableClient
+}
+
+// probeNode returns the node on which the packets have been captured,
+// the one of the flow or the node parameter
+func (p *PcapApi) probeNode(flowUUID string, nodeID string) *graph.Node {
+	if flowUUID != "" {
+		f := p.FlowTable.GetFlow(flowUUID)
+		if f == nil {
+			return nil
+		}
+		nodeID = f.ProbeNodeUUID
+	}
+
+	p.Graph.RLock()
+	defer p.Graph.RUnlock()
+
+	return p.Graph.GetNode(graph.Identifier(nodeID))
+}
+
+// pcap returns the packets retained by the agents of a flow or of the
+// capture of a node as a pcap file, ex: /api/pcap?flow=<flow UUID> or
+// /api/pcap?node=<node ID>
+func (p *PcapApi) pcap(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	flowUUID := r.URL.Query().Get("flow")
+	nodeID := r.URL.Query().Get("node")
+	if flowUUID == "" && nodeID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	node := p.probeNode(flowUUID, nodeID)
+	if node == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	packets, err := p.TableClient.LookupFlowPackets(node, flowUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	name := nodeID
+	if flowUUID != "" {
+		name = flowUUID
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pcap", name))
+	w.WriteHeader(http.StatusOK)
+
+	writer := pcapgo.NewWriter(w)
+	if err := writer.WriteFileHeader(pcapSnapLen, layers.LinkTypeEthernet); err != nil {
+		logging.GetLogger().Errorf("Failed to write pcap header: %s", err.Error())
+		return
+	}
+
+	for _, packet := range packets {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, packet.Timestamp),
+			CaptureLength: len(packet.Data),
+			Length:        len(packet.Data),
+		}
+		if err := writer.WritePacket(ci, packet.Data); err != nil {
+			logging.GetLogger().Errorf("Failed to write pcap packet: %s", err.Error())
+			return
+		}
+	}
+}
+
+func (p *PcapApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"Pcap",
+			"GET",
+			"/api/pcap",
+			p.pcap,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterPcapApi(s string, g *graph.Graph, f *flow.Table, tc *flow.TableClient, r *shttp.Server) {
+	p := &PcapApi{
+		Service:     s,
+		Graph:       g,
+		FlowTable:   f,
+		TableClient: tc,
+	}
+
+	p.registerEndpoints(r)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// pythonTypes are the API types exposed by the Python client of
+// contrib/python, generated with "make .python"
+var pythonTypes = []interface{}{
+	&Alert{},
+	&Capture{},
+	&GraphQLRequest{},
+	&InjectionResult{},
+	&PacketInjection{},
+	&Topology{},
+	&TopologyDiff{},
+}
+
+const pythonHeader = `# Code generated by contrib/python/generate.go from the Go API types.
+# DO NOT EDIT.
+
+
+class APIObject(object):
+    """Object of the API, encoded as a dict of its fields not set to None"""
+
+    fields = ()
+
+    def to_json(self):
+        return dict((k, getattr(self, k)) for k in self.fields
+                    if getattr(self, k) is not None)
+
+    @classmethod
+    def from_json(cls, obj):
+        return cls(**dict((k, v) for k, v in obj.items() if k in cls.fields))
+
+    def __repr__(self):
+        return "%s(%s)" % (self.__class__.__name__, ", ".join(
+            "%s=%r" % (k, v) for k, v in sorted(self.to_json().items())))
+`
+
+func pythonTypeName(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "str (RFC3339)"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return pythonTypeName(t.Elem())
+	case reflect.String:
+		return "str"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice:
+		// json.RawMessage is a slice of bytes holding any value
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "object"
+		}
+		return "list of " + pythonTypeName(t.Elem())
+	case reflect.Map, reflect.Struct:
+		return "dict"
+	}
+	return "object"
+}
+
+// pythonFields returns the names and the types of the JSON fields of a
+// structure
+func pythonFields(t reflect.Type) (names []string, types []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		names = append(names, name)
+		types = append(types, pythonTypeName(f.Type))
+	}
+	return
+}
+
+// GeneratePythonBindings writes the Python classes of the API types
+func GeneratePythonBindings(w io.Writer) error {
+	if _, err := io.WriteString(w, pythonHeader); err != nil {
+		return err
+	}
+
+	for _, i := range pythonTypes {
+		t := reflect.TypeOf(i).Elem()
+		names, types := pythonFields(t)
+
+		var quoted, args, doc, assign []string
+		for i, name := range names {
+			quoted = append(quoted, fmt.Sprintf("%q", name))
+			args = append(args, fmt.Sprintf("%s=None", name))
+			doc = append(doc, fmt.Sprintf("        %s: %s", name, types[i]))
+			assign = append(assign, fmt.Sprintf("        self.%s = %s", name, name))
+		}
+
+		_, err := fmt.Fprintf(w, `
+
+class %s(APIObject):
+    """%s of the API
+
+    Fields:
+%s
+    """
+
+    fields = (%s,)
+
+    def __init__(self, %s):
+%s
+`, t.Name(), t.Name(), strings.Join(doc, "\n"), strings.Join(quoted, ", "), strings.Join(args, ", "), strings.Join(assign, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const (
+	AgentConnected = "connected"
+	AgentLagging   = "lagging"
+	AgentStale     = "stale"
+)
+
+// AgentStatus is the status an agent publishes on its host node. An agent
+// is stale once disconnected, lagging when its last heartbeat is too old.
+type AgentStatus struct {
+	Host           string
+	State          string
+	Version        string
+	Uptime         int64
+	Probes         []string
+	Captures       int64
+	PacketsDropped int64
+	LastHeartbeat  time.Time
+}
+
+type Status struct {
+	Connected int
+	Lagging   int
+	Stale     int
+	Agents    map[string]*AgentStatus
+}
+
+type StatusApi struct {
+	Service string
+	Graph   *graph.Graph
+	// agents without heartbeat for this duration are lagging
+	LagThreshold time.Duration
+}
+
+func metadataInt64(m graph.Metadata, k string) int64 {
+	f, err := common.ToFloat64(m[k])
+	if err != nil {
+		return 0
+	}
+	return int64(f)
+}
+
+func (s *StatusApi) agentStatus(n *graph.Node, now time.Time) *AgentStatus {
+	m := n.Metadata()
+
+	status := &AgentStatus{
+		Host:           n.Host(),
+		State:          AgentConnected,
+		Uptime:         metadataInt64(m, "Agent.Uptime"),
+		Captures:       metadataInt64(m, "Agent.Captures"),
+		PacketsDropped: metadataInt64(m, "Agent.PacketsDropped"),
+		LastHeartbeat:  time.Unix(metadataInt64(m, "Agent.Heartbeat"), 0),
+	}
+	status.Version, _ = m["Agent.Version"].(string)
+	if probes, _ := m["Agent.Probes"].(string); probes != "" {
+		status.Probes = strings.Split(probes, ",")
+	}
+
+	if _, ok := m["StaleSince"]; ok {
+		status.State = AgentStale
+	} else if now.Sub(status.LastHeartbeat) > s.LagThreshold {
+		status.State = AgentLagging
+	}
+
+	return status
+}
+
+// Status returns the status of the agents having published a heartbeat
+func (s *StatusApi) Status(now time.Time) *Status {
+	s.Graph.RLock()
+	defer s.Graph.RUnlock()
+
+	status := &Status{Agents: make(map[string]*AgentStatus)}
+	for _, n := range s.Graph.GetNodes() {
+		if _, ok := n.Metadata()["Agent.Heartbeat"]; !ok {
+			continue
+		}
+
+		agent := s.agentStatus(n, now)
+		switch agent.State {
+		case AgentConnected:
+			status.Connected++
+		case AgentLagging:
+			status.Lagging++
+		case AgentStale:
+			status.Stale++
+		}
+		status.Agents[agent.Host] = agent
+	}
+
+	return status
+}
+
+func (s *StatusApi) status(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.Status(time.Now())); err != nil {
+		panic(err)
+	}
+}
+
+func (s *StatusApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"Status",
+			"GET",
+			"/api/status",
+			s.status,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterStatusApi(s string, g *graph.Graph, r *shttp.Server) {
+	st := &StatusApi{
+		Service:      s,
+		Graph:        g,
+		LagThreshold: 3 * time.Duration(config.GetConfig().GetInt("agent.heartbeat_interval")) * time.Second,
+	}
+
+	st.registerEndpoints(r)
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+func TestAgentStatus(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	g.NewNodeFromHost(graph.GenID(), graph.Metadata{"Type": "host", "Agent.Heartbeat": float64(now.Unix()), "Agent.Probes": "netlink,pcap", "Agent.Captures": float64(2)}, "h1")
+	g.NewNodeFromHost(graph.GenID(), graph.Metadata{"Type": "host", "Agent.Heartbeat": now.Add(-time.Hour).Unix()}, "h2")
+	g.NewNodeFromHost(graph.GenID(), graph.Metadata{"Type": "host", "Agent.Heartbeat": now.Unix(), "StaleSince": now.Unix()}, "h3")
+	g.NewNodeFromHost(graph.GenID(), graph.Metadata{"Type": "host"}, "h4")
+
+	s := &StatusApi{Graph: g, LagThreshold: time.Minute}
+	status := s.Status(now)
+
+	if status.Connected != 1 || status.Lagging != 1 || status.Stale != 1 || len(status.Agents) != 3 {
+		t.Fatalf("Wrong agent states: %+v", status)
+	}
+
+	h1 := status.Agents["h1"]
+	if h1.State != AgentConnected || h1.Captures != 2 || len(h1.Probes) != 2 {
+		t.Errorf("Wrong status of h1: %+v", h1)
+	}
+	if status.Agents["h2"].State != AgentLagging || status.Agents["h3"].State != AgentStale {
+		t.Errorf("Wrong states of h2 and h3: %+v", status.Agents)
+	}
+}
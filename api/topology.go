@@ -24,65 +24,488 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+	"github.com/redhat-cip/skydive/config"
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/topology"
 	"github.com/redhat-cip/skydive/topology/graph"
 )
 
+const (
+	maxTopologyForks = 16
+	// forks not used for this duration are dropped
+	topologyForkTTL = 10 * time.Minute
+)
+
 type TopologyApi struct {
-	Service string
-	Graph   *graph.Graph
+	sync.RWMutex
+	Service     string
+	Graph       *graph.Graph
+	GraphServer *graph.GraphServer
+	Auth        shttp.AuthenticationBackend
+	forks       map[string]*topologyFork
+}
+
+// topologyFork is a fork of the topology, only accessible to the user who
+// created it
+type topologyFork struct {
+	graph  *graph.Graph
+	owner  string
+	expire time.Time
 }
 
 type Topology struct {
 	GremlinQuery string `json:"GremlinQuery,omitempty"`
+	// At, RFC3339 time, queries the topology as it was at this time
+	At string `json:"At,omitempty"`
 }
 
-func (t *TopologyApi) topologyIndex(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+// TopologyDiff selects the two graphs to compare, each one being a snapshot,
+// the topology at a RFC3339 time, or the current topology if none is given
+type TopologyDiff struct {
+	From         string           `json:"From,omitempty"`
+	To           string           `json:"To,omitempty"`
+	FromSnapshot *json.RawMessage `json:"FromSnapshot,omitempty"`
+	ToSnapshot   *json.RawMessage `json:"ToSnapshot,omitempty"`
+}
+
+// withHidden returns whether the hidden nodes and edges are given to the
+// user of the request. As for the websocket clients, only the admins get
+// them, with the hidden parameter set, ex: /api/topology?hidden=true
+func withHidden(a shttp.AuthenticationBackend, r *auth.AuthenticatedRequest) bool {
+	return r.URL.Query().Get("hidden") == "true" && a.Role(r.Username).Allows(shttp.AdminRole)
+}
+
+// visibleGraph returns the graph, or a view of it without the hidden nodes
+// and edges if the user doesn't get them
+func visibleGraph(g *graph.Graph, withHidden bool) *graph.Graph {
+	if withHidden {
+		return g
+	}
+	return g.Visible()
+}
 
-	resource := Topology{}
+// resolve returns the graph and the values matching the gremlin query and
+// the time given as URL parameters or in the body of the request, without
+// the hidden elements unless the user gets them. The values are nil if no
+// query was given. It writes the error to the response and returns false
+// on failure.
+func (t *TopologyApi) resolve(w http.ResponseWriter, r *auth.AuthenticatedRequest, g *graph.Graph) (*graph.Graph, []interface{}, bool) {
+	// the query can also be given as URL parameters for the clients not
+	// able to send a body with a GET request
+	params := r.URL.Query()
+	resource := Topology{GremlinQuery: params.Get("gremlin"), At: params.Get("at")}
 
 	data, _ := ioutil.ReadAll(r.Body)
 	if len(data) != 0 {
 		if err := json.Unmarshal(data, &resource); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, nil, false
 		}
 	}
 
-	if resource.GremlinQuery != "" {
-		tr := graph.NewGremlinTraversalParser(strings.NewReader(resource.GremlinQuery), t.Graph)
-		tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
-
-		ts, err := tr.Parse()
+	if resource.At != "" {
+		at, err := time.Parse(time.RFC3339, resource.At)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			return
+			return nil, nil, false
 		}
 
-		res, err := ts.Exec()
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+		if g, err = g.At(at); err != nil {
+			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte(err.Error()))
+			return nil, nil, false
+		}
+	}
+	g = visibleGraph(g, withHidden(t.Auth, r))
+
+	if resource.GremlinQuery == "" {
+		return g, nil, true
+	}
+
+	tr := graph.NewGremlinTraversalParser(strings.NewReader(resource.GremlinQuery), g)
+	tr.AddTraversalExtension(topology.NewTopologyTraversalExtension())
+
+	ts, err := tr.Parse()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, nil, false
+	}
+
+	res, err := ts.Exec()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, nil, false
+	}
+
+	return g, res.Values(), true
+}
+
+func (t *TopologyApi) query(w http.ResponseWriter, r *auth.AuthenticatedRequest, g *graph.Graph) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	g, values, ok := t.resolve(w, r, g)
+	if !ok {
+		return
+	}
+
+	var result interface{} = g
+	if values != nil {
+		result = values
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		panic(err)
+	}
+}
+
+// export renders the whole topology or the result of a query in a format
+// understood by external visualization tools
+func (t *TopologyApi) export(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	var contentType string
+	var exporter func(io.Writer, []*graph.Node, []*graph.Edge) error
+
+	switch mux.Vars(&r.Request)["format"] {
+	case "graphml":
+		contentType, exporter = "application/xml; charset=UTF-8", graph.ExportGraphML
+	case "dot":
+		contentType, exporter = "text/vnd.graphviz; charset=UTF-8", graph.ExportDOT
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	g, values, ok := t.resolve(w, r, t.Graph)
+	if !ok {
+		return
+	}
+	if values == nil {
+		values = []interface{}{g}
+	}
+	nodes, edges := g.SubGraph(values)
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if err := exporter(w, nodes, edges); err != nil {
+		panic(err)
+	}
+}
+
+func (t *TopologyApi) topologyIndex(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	t.query(w, r, t.Graph)
+}
+
+func (t *TopologyApi) indexStats(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	t.Graph.RLock()
+	stats := t.Graph.IndexStats()
+	t.Graph.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		panic(err)
+	}
+}
+
+// nodeSubscribers returns the WebSocket clients receiving the events of a
+// node, with their addresses and parameters, only served to the admins
+func (t *TopologyApi) nodeSubscribers(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	vars := mux.Vars(&r.Request)
+
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	node := visibleGraph(t.Graph, withHidden(t.Auth, r)).GetNode(graph.Identifier(vars["id"]))
+	if node == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(t.GraphServer.Subscribers(node)); err != nil {
+		panic(err)
+	}
+}
+
+// neighborhood returns the subgraph of the nodes at most ?depth=N hops away
+// from a node, 1 by default, following the edges of the given relation
+// types, all of them if none, so that the UIs can expand the topology lazily,
+// ex: /api/topology/neighborhood/ID?depth=2&relationType=ownership&relationType=layer2
+func (t *TopologyApi) neighborhood(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	params := r.URL.Query()
+
+	depth := 1
+	if d := params.Get("depth"); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid depth: " + d))
 			return
 		}
+	}
+
+	if max := config.GetConfig().GetInt("graph.neighborhood_max_depth"); depth > max {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Depth exceeding the maximum of %d", max)))
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(res.Values()); err != nil {
-			panic(err)
+	var edges []graph.Metadata
+	if types := params["relationType"]; len(types) > 0 {
+		within := make([]interface{}, len(types))
+		for i, rt := range types {
+			within[i] = rt
 		}
+		edges = append(edges, graph.Metadata{"RelationType": graph.Within(within...)})
+	}
+
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	g := visibleGraph(t.Graph, withHidden(t.Auth, r))
+
+	node := g.GetNode(graph.Identifier(mux.Vars(&r.Request)["id"]))
+	if node == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	sg := g.Neighborhood([]*graph.Node{node}, depth, edges...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sg); err != nil {
+		panic(err)
+	}
+}
+
+// purgeForks drops the expired forks, must be called with the lock held
+func (t *TopologyApi) purgeForks() {
+	now := time.Now()
+	for id, f := range t.forks {
+		if now.After(f.expire) {
+			delete(t.forks, id)
+		}
+	}
+}
+
+// forks are isolated copies of the topology on which hypothetical changes
+// can be applied and queried without touching the live graph
+func (t *TopologyApi) createFork(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.purgeForks()
+	if len(t.forks) >= maxTopologyForks {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Too many topology forks"))
+		return
+	}
+
+	t.Graph.RLock()
+	fork, err := visibleGraph(t.Graph, withHidden(t.Auth, r)).Fork()
+	t.Graph.RUnlock()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	id := string(graph.GenID())
+	t.forks[id] = &topologyFork{graph: fork, owner: r.Username, expire: time.Now().Add(topologyForkTTL)}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"ID": id}); err != nil {
+		panic(err)
+	}
+}
+
+// getFork returns the fork of the request if owned by the user, extending
+// its lifetime
+func (t *TopologyApi) getFork(r *auth.AuthenticatedRequest) *graph.Graph {
+	t.Lock()
+	defer t.Unlock()
+
+	t.purgeForks()
+	f, ok := t.forks[mux.Vars(&r.Request)["id"]]
+	if !ok || f.owner != r.Username {
+		return nil
+	}
+	f.expire = time.Now().Add(topologyForkTTL)
+
+	return f.graph
+}
+
+func (t *TopologyApi) queryFork(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	fork := t.getFork(r)
+	if fork == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	fork.RLock()
+	defer fork.RUnlock()
+
+	t.query(w, r, fork)
+}
+
+func (t *TopologyApi) deleteFork(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	t.Lock()
+	defer t.Unlock()
+
+	id := mux.Vars(&r.Request)["id"]
+	if f, ok := t.forks[id]; !ok || f.owner != r.Username {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	delete(t.forks, id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteForkElement removes a node, with its edges, or an edge of a fork
+func (t *TopologyApi) deleteForkElement(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	fork := t.getFork(r)
+	if fork == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	fork.Lock()
+	defer fork.Unlock()
+
+	id := graph.Identifier(mux.Vars(&r.Request)["element"])
+	if n := fork.GetNode(id); n != nil {
+		fork.DelNode(n)
+	} else if e := fork.GetEdge(id); e != nil {
+		fork.DelEdge(e)
 	} else {
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(t.Graph); err != nil {
-			panic(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// snapshot downloads the whole topology, to be restored later, the hidden
+// elements being part of it only for the admins with the hidden parameter set
+func (t *TopologyApi) snapshot(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=skydive-%s.json", time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	if err := visibleGraph(t.Graph, withHidden(t.Auth, r)).Snapshot(w); err != nil {
+		panic(err)
+	}
+}
+
+// restore replaces the topology by a snapshot, with replay=true the agents
+// updates are then refused so that the snapshot is kept as is
+func (t *TopologyApi) restore(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	replay, _ := strconv.ParseBool(r.URL.Query().Get("replay"))
+
+	t.Graph.Lock()
+	defer t.Graph.Unlock()
+
+	if err := t.Graph.Restore(r.Body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	t.GraphServer.SetReplay(replay)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// diffGraph returns the graph of a side of a diff, with the HTTP status to
+// return on failure
+func (t *TopologyApi) diffGraph(at string, snapshot *json.RawMessage) (*graph.Graph, int, error) {
+	if snapshot != nil {
+		g, err := graph.LoadSnapshot(strings.NewReader(string(*snapshot)))
+		if err != nil {
+			return nil, http.StatusBadRequest, err
 		}
+		return g, http.StatusOK, nil
+	}
+
+	if at == "" {
+		return t.Graph, http.StatusOK, nil
+	}
+
+	tm, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	g, err := t.Graph.At(tm)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return g, http.StatusOK, nil
+}
+
+// diff returns the elements added, deleted and updated between two times or
+// two snapshots
+func (t *TopologyApi) diff(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	params := r.URL.Query()
+	resource := TopologyDiff{From: params.Get("from"), To: params.Get("to")}
+
+	data, _ := ioutil.ReadAll(r.Body)
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &resource); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	t.Graph.RLock()
+	defer t.Graph.RUnlock()
+
+	from, status, err := t.diffGraph(resource.From, resource.FromSnapshot)
+	if err != nil {
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	to, status, err := t.diffGraph(resource.To, resource.ToSnapshot)
+	if err != nil {
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	hidden := withHidden(t.Auth, r)
+	from, to = visibleGraph(from, hidden), visibleGraph(to, hidden)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(graph.Diff(from, to)); err != nil {
+		panic(err)
 	}
 }
 
@@ -94,15 +517,102 @@ func (t *TopologyApi) registerEndpoints(r *shttp.Server) {
 			"/api/topology",
 			t.topologyIndex,
 		},
+		{
+			"TopologiesQuery",
+			"POST",
+			"/api/topology",
+			t.topologyIndex,
+		},
+		{
+			"TopologyIndexStats",
+			"GET",
+			"/api/topology/indexes",
+			t.indexStats,
+		},
+		{
+			"TopologyExport",
+			"GET",
+			"/api/topology/export/{format}",
+			t.export,
+		},
+		{
+			"TopologyExportQuery",
+			"POST",
+			"/api/topology/export/{format}",
+			t.export,
+		},
+		{
+			"TopologySnapshot",
+			"GET",
+			"/api/topology/snapshot",
+			t.snapshot,
+		},
+		{
+			"TopologyRestore",
+			"POST",
+			"/api/topology/snapshot",
+			r.RequireRole(shttp.AdminRole, t.restore),
+		},
+		{
+			"TopologyDiff",
+			"GET",
+			"/api/topology/diff",
+			t.diff,
+		},
+		{
+			"TopologyDiffSnapshots",
+			"POST",
+			"/api/topology/diff",
+			t.diff,
+		},
+		{
+			"TopologyNodeSubscribers",
+			"GET",
+			"/api/topology/subscribers/{id}",
+			r.RequireRole(shttp.AdminRole, t.nodeSubscribers),
+		},
+		{
+			"TopologyNeighborhood",
+			"GET",
+			"/api/topology/neighborhood/{id}",
+			t.neighborhood,
+		},
+		{
+			"TopologyForkCreate",
+			"POST",
+			"/api/topology/forks",
+			r.RequireRole(shttp.AdminRole, t.createFork),
+		},
+		{
+			"TopologyForkQuery",
+			"POST",
+			"/api/topology/forks/{id}",
+			t.queryFork,
+		},
+		{
+			"TopologyForkDelete",
+			"DELETE",
+			"/api/topology/forks/{id}",
+			r.RequireRole(shttp.AdminRole, t.deleteFork),
+		},
+		{
+			"TopologyForkDeleteElement",
+			"DELETE",
+			"/api/topology/forks/{id}/{element}",
+			r.RequireRole(shttp.AdminRole, t.deleteForkElement),
+		},
 	}
 
 	r.RegisterRoutes(routes)
 }
 
-func RegisterTopologyApi(s string, g *graph.Graph, r *shttp.Server) {
+func RegisterTopologyApi(s string, g *graph.Graph, gs *graph.GraphServer, r *shttp.Server) {
 	t := &TopologyApi{
-		Service: s,
-		Graph:   g,
+		Service:     s,
+		Graph:       g,
+		GraphServer: gs,
+		Auth:        r.Auth,
+		forks:       make(map[string]*topologyFork),
 	}
 
 	t.registerEndpoints(r)
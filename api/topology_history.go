@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/storage"
+)
+
+type TopologyHistoryApi struct {
+	Service string
+	Storage storage.TopologyStorage
+}
+
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// topologyEvents returns the topology events between the from and to
+// RFC3339 times, the last hour by default, the other parameters filtering
+// the events, ex: /api/topology/history?from=...&ID=...&Type=NodeUpdated
+func (t *TopologyHistoryApi) topologyEvents(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if t.Storage == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	params := r.URL.Query()
+
+	from, err := parseTime(params.Get("from"), now.Add(-time.Hour))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	to, err := parseTime(params.Get("to"), now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	filters := make(storage.Filters)
+	for k, v := range params {
+		if k != "from" && k != "to" {
+			filters[k] = v[0]
+		}
+	}
+
+	events, err := t.Storage.SearchTopologyEvents(from, to, filters)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		panic(err)
+	}
+}
+
+func (t *TopologyHistoryApi) registerEndpoints(r *shttp.Server) {
+	routes := []shttp.Route{
+		{
+			"TopologyHistory",
+			"GET",
+			"/api/topology/history",
+			t.topologyEvents,
+		},
+	}
+
+	r.RegisterRoutes(routes)
+}
+
+func RegisterTopologyHistoryApi(s string, st storage.TopologyStorage, r *shttp.Server) {
+	t := &TopologyHistoryApi{
+		Service: s,
+		Storage: st,
+	}
+
+	t.registerEndpoints(r)
+}
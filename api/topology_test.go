@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// the password of both users is "password", admin being the only one
+// listed in the roles
+const topologyTestUsers = `admin:$apr1$/tk0tCNm$fBaXEudF9OTyFUhuqoIwp/
+bob:$apr1$/tk0tCNm$fBaXEudF9OTyFUhuqoIwp/
+`
+
+type topologyTestServer struct {
+	*httptest.Server
+	Graph *graph.Graph
+	users string
+}
+
+func (s *topologyTestServer) Close() {
+	s.Server.Close()
+	os.Remove(s.users)
+}
+
+func newTopologyTestServer(t *testing.T) *topologyTestServer {
+	f, err := ioutil.TempFile("", "skydive-api-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(topologyTestUsers); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	auth, err := shttp.NewBasicAuthenticationBackend(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.GetConfig().Set("auth.roles", map[string]string{"admin": "admin"})
+
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := shttp.NewServer("analyzer", "127.0.0.1", 0, auth)
+	RegisterTopologyApi("analyzer", g, nil, s)
+	RegisterFlowApi("analyzer", g, nil, nil, s)
+	RegisterGraphQLApi("analyzer", g, nil, nil, s)
+
+	return &topologyTestServer{Server: httptest.NewServer(s.Router), Graph: g, users: f.Name()}
+}
+
+func topologyTestRequest(t *testing.T, ts *topologyTestServer, user string, method string, path string, body string) (int, string) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(user, "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(content)
+}
+
+func TestDefaultRole(t *testing.T) {
+	ts := newTopologyTestServer(t)
+	defer ts.Close()
+
+	// the users not listed in the roles are readers
+	if code, _ := topologyTestRequest(t, ts, "bob", "POST", "/api/topology/snapshot", "invalid"); code != http.StatusForbidden {
+		t.Errorf("Admin endpoint not refused to an unconfigured user: %d", code)
+	}
+	if code, _ := topologyTestRequest(t, ts, "bob", "GET", "/api/topology", ""); code != http.StatusOK {
+		t.Errorf("Topology not returned to a reader: %d", code)
+	}
+	if code, _ := topologyTestRequest(t, ts, "admin", "POST", "/api/topology/snapshot", "invalid"); code != http.StatusBadRequest {
+		t.Errorf("Admin endpoint refused to an admin: %d", code)
+	}
+}
+
+func TestHiddenReadPaths(t *testing.T) {
+	ts := newTopologyTestServer(t)
+	defer ts.Close()
+
+	ts.Graph.Lock()
+	public := ts.Graph.NewNode(graph.Identifier("public"), graph.Metadata{"Name": "public"})
+	secret := ts.Graph.NewNode(graph.Identifier("secret"), graph.Metadata{"Name": "secret", "Hidden": true})
+	ts.Graph.NewEdge(graph.Identifier("link"), public, secret, nil)
+	ts.Graph.Unlock()
+
+	var fork map[string]string
+	_, body := topologyTestRequest(t, ts, "admin", "POST", "/api/topology/forks", "")
+	if err := json.Unmarshal([]byte(body), &fork); err != nil {
+		t.Fatal(err)
+	}
+
+	emptySnapshot := `{"FromSnapshot": {"Nodes": [], "Edges": []}}`
+	graphql := "/api/graphql?query=" + url.QueryEscape("{ Nodes { ID } Edges { ID } }")
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/api/topology", ""},
+		{"GET", "/api/topology?gremlin=G.V()", ""},
+		{"POST", "/api/topology", `{"GremlinQuery": "G.V().Has('Name', 'public').OutE()"}`},
+		{"GET", "/api/topology/export/dot", ""},
+		{"GET", "/api/topology/snapshot", ""},
+		{"POST", "/api/topology/diff", emptySnapshot},
+		{"GET", "/api/topology/neighborhood/public", ""},
+		{"GET", graphql, ""},
+		{"GET", "/api/flow/query?gremlin=G.V()", ""},
+	}
+
+	for _, req := range requests {
+		for _, user := range []string{"bob", "admin"} {
+			code, body := topologyTestRequest(t, ts, user, req.method, req.path, req.body)
+			if code != http.StatusOK {
+				t.Errorf("%s %s failed for %s: %d %s", req.method, req.path, user, code, body)
+			}
+			if strings.Contains(body, "secret") || strings.Contains(body, "link") {
+				t.Errorf("%s %s returned a hidden element to %s: %s", req.method, req.path, user, body)
+			}
+		}
+
+		// only the admins asking for the hidden elements get them
+		path := req.path + "?hidden=true"
+		if strings.Contains(req.path, "?") {
+			path = req.path + "&hidden=true"
+		}
+		if _, body := topologyTestRequest(t, ts, "bob", req.method, path, req.body); strings.Contains(body, "secret") {
+			t.Errorf("%s %s returned a hidden element to a reader: %s", req.method, path, body)
+		}
+		if _, body := topologyTestRequest(t, ts, "admin", req.method, path, req.body); !strings.Contains(body, "secret") {
+			t.Errorf("%s %s didn't return the hidden elements to an admin: %s", req.method, path, body)
+		}
+	}
+
+	// the forks are created without the hidden elements
+	if _, body := topologyTestRequest(t, ts, "admin", "POST", "/api/topology/forks/"+fork["ID"], ""); !strings.Contains(body, "public") || strings.Contains(body, "secret") {
+		t.Errorf("Wrong fork content: %s", body)
+	}
+
+	for _, id := range []string{"secret", "link"} {
+		if code, _ := topologyTestRequest(t, ts, "bob", "GET", "/api/topology/neighborhood/"+id, ""); code != http.StatusNotFound {
+			t.Errorf("Neighborhood of the hidden element %s returned: %d", id, code)
+		}
+	}
+	if code, _ := topologyTestRequest(t, ts, "admin", "GET", "/api/topology/subscribers/secret", ""); code != http.StatusNotFound {
+		t.Errorf("Subscribers of a hidden node returned: %d", code)
+	}
+
+	// the subscribers, their addresses and parameters, are only given to
+	// the admins
+	if code, _ := topologyTestRequest(t, ts, "bob", "GET", "/api/topology/subscribers/public", ""); code != http.StatusForbidden {
+		t.Errorf("Subscribers returned to a reader: %d", code)
+	}
+}
+
+func TestForks(t *testing.T) {
+	ts := newTopologyTestServer(t)
+	defer ts.Close()
+
+	ts.Graph.Lock()
+	ts.Graph.NewNode(graph.Identifier("node"), graph.Metadata{"Name": "node"})
+	ts.Graph.Unlock()
+
+	if code, _ := topologyTestRequest(t, ts, "bob", "POST", "/api/topology/forks", ""); code != http.StatusForbidden {
+		t.Errorf("Fork created by a reader: %d", code)
+	}
+
+	var fork map[string]string
+	_, body := topologyTestRequest(t, ts, "admin", "POST", "/api/topology/forks", "")
+	if err := json.Unmarshal([]byte(body), &fork); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/topology/forks/" + fork["ID"]
+
+	// the forks are only accessible to their owner
+	if code, _ := topologyTestRequest(t, ts, "bob", "POST", path, ""); code != http.StatusNotFound {
+		t.Errorf("Fork returned to another user: %d", code)
+	}
+	if code, _ := topologyTestRequest(t, ts, "bob", "DELETE", path+"/node", ""); code != http.StatusForbidden {
+		t.Errorf("Fork modified by a reader: %d", code)
+	}
+
+	if code, _ := topologyTestRequest(t, ts, "admin", "DELETE", path+"/node", ""); code != http.StatusOK {
+		t.Errorf("Fork element not deleted: %d", code)
+	}
+	if _, body := topologyTestRequest(t, ts, "admin", "POST", path, ""); strings.Contains(body, "node") {
+		t.Errorf("Deleted element still in the fork: %s", body)
+	}
+
+	// the live graph is untouched
+	if _, body := topologyTestRequest(t, ts, "admin", "GET", "/api/topology", ""); !strings.Contains(body, "node") {
+		t.Errorf("Element deleted from the live graph: %s", body)
+	}
+
+	if code, _ := topologyTestRequest(t, ts, "admin", "DELETE", path, ""); code != http.StatusOK {
+		t.Errorf("Fork not deleted: %d", code)
+	}
+	if code, _ := topologyTestRequest(t, ts, "admin", "POST", path, ""); code != http.StatusNotFound {
+		t.Errorf("Deleted fork returned: %d", code)
+	}
+}
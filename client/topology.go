@@ -0,0 +1,368 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// TopologyEventListener gets the changes of the topology mirrored by a
+// TopologySubscriber. The graph callbacks are called with the graph of the
+// subscriber locked.
+type TopologyEventListener interface {
+	graph.GraphEventListener
+	// OnSynchronized is called, without the graph locked, once the
+	// topology has been synchronized with the server after each connection
+	OnSynchronized()
+}
+
+// DefaultTopologyEventListener can be embedded by the listeners not
+// implementing all the callbacks
+type DefaultTopologyEventListener struct {
+	graph.DefaultGraphListener
+}
+
+func (d *DefaultTopologyEventListener) OnSynchronized() {
+}
+
+// SubscriptionOptions restricts the elements whose events are sent by the
+// server, the metadata filter applying to the nodes and to the edges whose
+// both nodes match
+type SubscriptionOptions struct {
+	Hidden     bool
+	ChangeRate float64
+	Host       string
+	Namespace  string
+	Filter     map[string]string
+}
+
+func (o *SubscriptionOptions) path() string {
+	params := url.Values{}
+	if o.Hidden {
+		params.Set("hidden", "true")
+	}
+	if o.ChangeRate > 0 {
+		params.Set("changerate", strconv.FormatFloat(o.ChangeRate, 'f', -1, 64))
+	}
+	if o.Host != "" {
+		params.Set("host", o.Host)
+	}
+	if o.Namespace != "" {
+		params.Set("namespace", o.Namespace)
+	}
+	for k, v := range o.Filter {
+		params.Add("filter", k+":"+v)
+	}
+
+	if len(params) == 0 {
+		return "/ws"
+	}
+	return "/ws?" + params.Encode()
+}
+
+// syncChunk is a graph.SyncChunk whose elements are decoded afterwards
+type syncChunk struct {
+	Delta        bool
+	Nodes        []interface{}
+	Edges        []interface{}
+	DeletedNodes []graph.Identifier
+	DeletedEdges []graph.Identifier
+	Last         bool
+	Revisions    *graph.SyncRevisions
+}
+
+// TopologySubscriber mirrors the topology of an analyzer or an agent in a
+// local graph, kept up to date with the events sent by the server. After a
+// reconnection only the changes since the last synchronization are
+// requested when the server still knows them.
+type TopologySubscriber struct {
+	shttp.DefaultWSClientEventHandler
+	Client    *shttp.WSAsyncClient
+	Graph     *graph.Graph
+	lock      sync.RWMutex
+	listeners []TopologyEventListener
+	revisions *graph.SyncRevisions
+	// elements received during a full synchronization
+	synced map[graph.Identifier]bool
+}
+
+func (s *TopologySubscriber) requestSync() {
+	req, _ := json.Marshal(&graph.SyncRequest{Revisions: s.revisions})
+	raw := json.RawMessage(req)
+
+	s.Client.SendWSMessage(shttp.WSMessage{
+		Namespace: graph.Namespace,
+		Type:      "SyncRequest",
+		Obj:       &raw,
+	})
+}
+
+func (s *TopologySubscriber) OnConnected() {
+	s.requestSync()
+}
+
+func metadataEqual(a, b graph.Metadata) bool {
+	return reflect.DeepEqual(map[string]interface{}(a), map[string]interface{}(b))
+}
+
+func (s *TopologySubscriber) setNode(n *graph.Node) {
+	if node := s.Graph.GetNode(n.ID); node != nil {
+		if !metadataEqual(node.Metadata(), n.Metadata()) {
+			s.Graph.SetMetadata(node, n.Metadata())
+		}
+		return
+	}
+	s.Graph.AddNode(n)
+}
+
+func (s *TopologySubscriber) setEdge(e *graph.Edge) {
+	if edge := s.Graph.GetEdge(e.ID); edge != nil {
+		if !metadataEqual(edge.Metadata(), e.Metadata()) {
+			s.Graph.SetMetadata(edge, e.Metadata())
+		}
+		return
+	}
+	s.Graph.AddEdge(e)
+}
+
+// applySyncChunk applies a chunk of a synchronization, returning whether it
+// was the last one. The elements not part of a full synchronization are
+// removed once the last chunk is received.
+func (s *TopologySubscriber) applySyncChunk(chunk *syncChunk) (bool, error) {
+	if !chunk.Delta && s.synced == nil {
+		s.synced = make(map[graph.Identifier]bool)
+	}
+
+	for _, obj := range chunk.Nodes {
+		var n graph.Node
+		if err := n.Decode(obj); err != nil {
+			return false, err
+		}
+		s.setNode(&n)
+		if s.synced != nil {
+			s.synced[n.ID] = true
+		}
+	}
+
+	for _, obj := range chunk.Edges {
+		var e graph.Edge
+		if err := e.Decode(obj); err != nil {
+			return false, err
+		}
+		s.setEdge(&e)
+		if s.synced != nil {
+			s.synced[e.ID] = true
+		}
+	}
+
+	for _, id := range chunk.DeletedEdges {
+		if e := s.Graph.GetEdge(id); e != nil {
+			s.Graph.DelEdge(e)
+		}
+	}
+
+	for _, id := range chunk.DeletedNodes {
+		if n := s.Graph.GetNode(id); n != nil {
+			s.Graph.DelNode(n)
+		}
+	}
+
+	if !chunk.Last {
+		return false, nil
+	}
+
+	if s.synced != nil {
+		for _, e := range s.Graph.GetEdges() {
+			if !s.synced[e.ID] {
+				s.Graph.DelEdge(e)
+			}
+		}
+		for _, n := range s.Graph.GetNodes() {
+			if !s.synced[n.ID] {
+				s.Graph.DelNode(n)
+			}
+		}
+		s.synced = nil
+	}
+	s.revisions = chunk.Revisions
+
+	return true, nil
+}
+
+// applyMessage applies a message of the graph namespace, returning whether
+// the synchronization is complete
+func (s *TopologySubscriber) applyMessage(msg shttp.WSMessage) (bool, error) {
+	switch msg.Type {
+	case "SyncChunk":
+		var chunk syncChunk
+		if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &chunk) != nil {
+			return false, nil
+		}
+		return s.applySyncChunk(&chunk)
+	case "Batch":
+		var msgs []shttp.WSMessage
+		if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &msgs) != nil {
+			return false, nil
+		}
+		for _, m := range msgs {
+			if _, err := s.applyMessage(m); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	msgType, obj, err := graph.UnmarshalWSMessage(msg)
+	if err != nil {
+		return false, err
+	}
+
+	switch msgType {
+	case "NodeAdded", "NodeUpdated":
+		n := obj.(*graph.Node)
+		if msgType == "NodeAdded" || s.Graph.GetNode(n.ID) != nil {
+			s.setNode(n)
+		}
+	case "NodeDeleted":
+		if n := s.Graph.GetNode(obj.(*graph.Node).ID); n != nil {
+			s.Graph.DelNode(n)
+		}
+	case "SubGraphDeleted":
+		if n := s.Graph.GetNode(obj.(*graph.Node).ID); n != nil {
+			s.Graph.DelSubGraph(n)
+		}
+	case "EdgeAdded", "EdgeUpdated":
+		e := obj.(*graph.Edge)
+		if msgType == "EdgeAdded" || s.Graph.GetEdge(e.ID) != nil {
+			s.setEdge(e)
+		}
+	case "EdgeDeleted":
+		if e := s.Graph.GetEdge(obj.(*graph.Edge).ID); e != nil {
+			s.Graph.DelEdge(e)
+		}
+	}
+
+	return false, nil
+}
+
+func (s *TopologySubscriber) OnMessage(msg shttp.WSMessage) {
+	// the messages can't be retransmitted, resync from the last revisions
+	if msg.Namespace == shttp.Namespace {
+		if msg.Type == "Resync" {
+			s.requestSync()
+		}
+		return
+	}
+
+	s.Graph.Lock()
+	synchronized, err := s.applyMessage(msg)
+	s.Graph.Unlock()
+
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to apply the topology message %s: %s", msg.Type, err.Error())
+		return
+	}
+
+	if synchronized {
+		s.lock.RLock()
+		for _, l := range s.listeners {
+			l.OnSynchronized()
+		}
+		s.lock.RUnlock()
+	}
+}
+
+// AddEventListener registers a listener, to be called before Start to get
+// the events of the first synchronization
+func (s *TopologySubscriber) AddEventListener(l TopologyEventListener) {
+	s.lock.Lock()
+	s.listeners = append(s.listeners, l)
+	s.lock.Unlock()
+
+	s.Graph.AddEventListener(l)
+}
+
+func (s *TopologySubscriber) RemoveEventListener(l TopologyEventListener) {
+	s.lock.Lock()
+	for i, el := range s.listeners {
+		if el == l {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	s.Graph.RemoveEventListener(l)
+}
+
+func (s *TopologySubscriber) Start() {
+	s.Client.Connect()
+}
+
+func (s *TopologySubscriber) Stop() {
+	s.Client.Disconnect()
+}
+
+// NewTopologySubscriber returns a subscriber to the topology of the server
+// listening at the given address, the options restricting the elements
+// mirrored
+func NewTopologySubscriber(addr string, port int, authOptions *shttp.AuthenticationOpts, options *SubscriptionOptions) (*TopologySubscriber, error) {
+	if authOptions == nil {
+		authOptions = &shttp.AuthenticationOpts{}
+	}
+	if options == nil {
+		options = &SubscriptionOptions{}
+	}
+
+	authClient := shttp.NewAuthenticationClient(addr, port, authOptions)
+	c, err := shttp.NewWSAsyncClient(addr, port, options.path(), authClient)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := graph.NewGraph(b)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TopologySubscriber{
+		Client: c,
+		Graph:  g,
+	}
+	c.AddEventHandler(s, graph.Namespace, shttp.Namespace)
+
+	return s, nil
+}
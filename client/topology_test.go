@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+type topologyEventCounter struct {
+	DefaultTopologyEventListener
+	events       map[string]int
+	synchronized int
+}
+
+func (c *topologyEventCounter) OnNodeAdded(n *graph.Node) {
+	c.events["NodeAdded"]++
+}
+
+func (c *topologyEventCounter) OnNodeUpdated(n *graph.Node) {
+	c.events["NodeUpdated"]++
+}
+
+func (c *topologyEventCounter) OnNodeDeleted(n *graph.Node) {
+	c.events["NodeDeleted"]++
+}
+
+func (c *topologyEventCounter) OnEdgeAdded(e *graph.Edge) {
+	c.events["EdgeAdded"]++
+}
+
+func (c *topologyEventCounter) OnEdgeDeleted(e *graph.Edge) {
+	c.events["EdgeDeleted"]++
+}
+
+func (c *topologyEventCounter) OnSynchronized() {
+	c.synchronized++
+}
+
+func graphMessage(t *testing.T, msgType string, obj string) shttp.WSMessage {
+	var v interface{}
+	if err := json.Unmarshal([]byte(obj), &v); err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(obj)
+	return shttp.WSMessage{Namespace: graph.Namespace, Type: msgType, Obj: &raw}
+}
+
+func checkTopologyEvents(t *testing.T, c *topologyEventCounter, expected map[string]int) {
+	for k, v := range expected {
+		if c.events[k] != v {
+			t.Errorf("expected %d %s events, got %d: %v", v, k, c.events[k], c.events)
+		}
+	}
+	c.events = make(map[string]int)
+}
+
+func TestTopologySubscriber(t *testing.T) {
+	s, err := NewTopologySubscriber("127.0.0.1", 8082, nil, &SubscriptionOptions{Hidden: true, Filter: map[string]string{"Type": "host"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Client.Path != "/ws?filter=Type%3Ahost&hidden=true" {
+		t.Errorf("unexpected subscription path %s", s.Client.Path)
+	}
+
+	c := &topologyEventCounter{events: make(map[string]int)}
+	s.AddEventListener(c)
+
+	s.OnMessage(graphMessage(t, "SyncChunk", `{"Nodes":[{"ID":"n1","Host":"h","Metadata":{"Name":"a"}},{"ID":"n2","Host":"h"}],"Edges":[]}`))
+	s.OnMessage(graphMessage(t, "SyncChunk", `{"Nodes":[],"Edges":[{"ID":"e1","Host":"h","Parent":"n1","Child":"n2"}],"Last":true,"Revisions":{"Epoch":"1","Hosts":{"h":3}}}`))
+	checkTopologyEvents(t, c, map[string]int{"NodeAdded": 2, "EdgeAdded": 1})
+	if c.synchronized != 1 {
+		t.Errorf("expected a synchronization, got %d", c.synchronized)
+	}
+
+	s.OnMessage(graphMessage(t, "Batch", `[
+		{"Namespace":"Graph","Type":"NodeUpdated","Obj":{"ID":"n1","Host":"h","Metadata":{"Name":"b"}}},
+		{"Namespace":"Graph","Type":"NodeAdded","Obj":{"ID":"n3","Host":"h"}}
+	]`))
+	checkTopologyEvents(t, c, map[string]int{"NodeUpdated": 1, "NodeAdded": 1})
+
+	// full resync, n3 being gone and n1 unchanged
+	s.OnMessage(graphMessage(t, "SyncChunk", `{"Nodes":[{"ID":"n1","Host":"h","Metadata":{"Name":"b"}},{"ID":"n2","Host":"h"}],"Edges":[{"ID":"e1","Host":"h","Parent":"n1","Child":"n2"}],"Last":true}`))
+	checkTopologyEvents(t, c, map[string]int{"NodeDeleted": 1, "NodeUpdated": 0, "NodeAdded": 0, "EdgeAdded": 0})
+
+	s.OnMessage(graphMessage(t, "SyncChunk", `{"Delta":true,"Nodes":[],"Edges":[],"DeletedEdges":["e1"],"Last":true}`))
+	checkTopologyEvents(t, c, map[string]int{"EdgeDeleted": 1, "NodeDeleted": 0})
+
+	if len(s.Graph.GetNodes()) != 2 || len(s.Graph.GetEdges()) != 0 {
+		t.Errorf("unexpected graph %s", s.Graph.String())
+	}
+}
@@ -140,9 +140,9 @@ var AlertDelete = &cobra.Command{
 func addAlertFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&alertName, "name", "", "", "alert name")
 	cmd.Flags().StringVarP(&alertDescription, "description", "", "", "alert description")
-	cmd.Flags().StringVarP(&alertSelect, "select", "", "", "alert select criteria")
-	cmd.Flags().StringVarP(&alertTest, "test", "", "", "alert test")
-	cmd.Flags().StringVarP(&alertAction, "action", "", "", "alert action")
+	cmd.Flags().StringVarP(&alertSelect, "select", "", "", "alert select criteria, nodes or Flow")
+	cmd.Flags().StringVarP(&alertTest, "test", "", "", "alert test, ex: State == \"DOWN\" or Bandwidth > 1000000")
+	cmd.Flags().StringVarP(&alertAction, "action", "", "", "alert action, a reason, a webhook URL or a file:// script")
 }
 
 func init() {
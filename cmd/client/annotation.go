@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"os"
+	"strings"
+
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+
+	"github.com/spf13/cobra"
+)
+
+var AnnotationCmd = &cobra.Command{
+	Use:          "annotation",
+	Short:        "Manage the user metadata of the nodes and edges",
+	Long:         "Manage the user metadata of the nodes and edges",
+	SilenceUsage: false,
+}
+
+var AnnotationSet = &cobra.Command{
+	Use:   "set [element] [key=value]...",
+	Short: "Set the user metadata of a node or an edge",
+	Long:  "Set the user metadata of a node or an edge, replacing the previous ones",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client := api.NewCrudClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+		annotation := &api.Annotation{ElementID: args[0], Metadata: graph.Metadata{}}
+		for _, arg := range args[1:] {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 {
+				logging.GetLogger().Errorf("Invalid metadata %s, expected key=value", arg)
+				os.Exit(1)
+			}
+			annotation.Metadata[kv[0]] = kv[1]
+		}
+		if err := client.Create("annotation", annotation); err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		printJSON(annotation)
+	},
+}
+
+var AnnotationList = &cobra.Command{
+	Use:   "list",
+	Short: "List annotations",
+	Long:  "List annotations",
+	Run: func(cmd *cobra.Command, args []string) {
+		var annotations map[string]api.Annotation
+		client := api.NewCrudClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+		if err := client.List("annotation", &annotations); err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		printJSON(annotations)
+	},
+}
+
+var AnnotationGet = &cobra.Command{
+	Use:   "get [element]",
+	Short: "Display the annotation of a node or an edge",
+	Long:  "Display the annotation of a node or an edge",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var annotation api.Annotation
+		client := api.NewCrudClientFromConfig(&authenticationOpts)
+		if err := client.Get("annotation", args[0], &annotation); err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		printJSON(&annotation)
+	},
+}
+
+var AnnotationDelete = &cobra.Command{
+	Use:   "delete [element]",
+	Short: "Delete the annotation of a node or an edge",
+	Long:  "Delete the annotation of a node or an edge",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client := api.NewCrudClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+		if err := client.Delete("annotation", args[0]); err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	AnnotationCmd.AddCommand(AnnotationList)
+	AnnotationCmd.AddCommand(AnnotationGet)
+	AnnotationCmd.AddCommand(AnnotationSet)
+	AnnotationCmd.AddCommand(AnnotationDelete)
+}
@@ -25,17 +25,29 @@ package client
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/redhat-cip/skydive/api"
 	"github.com/redhat-cip/skydive/logging"
-	"github.com/redhat-cip/skydive/validator"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	probePath string
-	bpfFilter string
+	probePath           string
+	captureGremlinQuery string
+	bpfFilter           string
+	captureType         string
+	snapLen             int
+	rawPacketLimit      int
+	duration            int64
+	export              string
+	activeTimeout       int
+	idleTimeout         int
+	samplingRate        uint32
+	pollingPeriod       uint32
+	packetRate          int
+	maxBytes            int64
 )
 
 var CaptureCmd = &cobra.Command{
@@ -46,17 +58,30 @@ var CaptureCmd = &cobra.Command{
 }
 
 var CaptureCreate = &cobra.Command{
-	Use:   "create",
-	Short: "Create capture",
-	Long:  "Create capture",
+	Use:     "create",
+	Aliases: []string{"start"},
+	Short:   "Create capture",
+	Long:    "Create capture",
 	Run: func(cmd *cobra.Command, args []string) {
 		client := api.NewCrudClientFromConfig(&authenticationOpts)
 		if client == nil {
 			os.Exit(1)
 		}
 		capture := api.NewCapture(probePath, bpfFilter)
-		if errs := validator.Validate(capture); errs != nil {
-			fmt.Println("You need to specify a probe path")
+		capture.GremlinQuery = captureGremlinQuery
+		capture.Type = captureType
+		capture.SnapLen = snapLen
+		capture.Duration = duration
+		capture.RawPacketLimit = rawPacketLimit
+		capture.Export = export
+		capture.ActiveTimeout = activeTimeout
+		capture.IdleTimeout = idleTimeout
+		capture.SamplingRate = samplingRate
+		capture.PollingPeriod = pollingPeriod
+		capture.PacketRate = packetRate
+		capture.MaxBytes = maxBytes
+		if capture.ProbePath == "" && capture.GremlinQuery == "" {
+			fmt.Println("You need to specify a probe path or a Gremlin query")
 			cmd.Usage()
 			os.Exit(1)
 		}
@@ -82,10 +107,28 @@ var CaptureList = &cobra.Command{
 			logging.GetLogger().Errorf(err.Error())
 			os.Exit(1)
 		}
-		printJSON(captures)
+		printOutput(captures, []string{"UUID", "PROBE", "FILTER", "TYPE"}, captureRows(captures))
 	},
 }
 
+func captureRows(captures map[string]api.Capture) (rows [][]string) {
+	var ids []string
+	for id := range captures {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		c := captures[id]
+		probe := c.ProbePath
+		if probe == "" {
+			probe = c.GremlinQuery
+		}
+		rows = append(rows, []string{id, probe, c.BPFFilter, c.Type})
+	}
+	return
+}
+
 var CaptureGet = &cobra.Command{
 	Use:   "get [capture]",
 	Short: "Display capture",
@@ -108,9 +151,10 @@ var CaptureGet = &cobra.Command{
 }
 
 var CaptureDelete = &cobra.Command{
-	Use:   "delete [capture]",
-	Short: "Delete capture",
-	Long:  "Delete capture",
+	Use:     "delete [capture]",
+	Aliases: []string{"stop"},
+	Short:   "Delete capture",
+	Long:    "Delete capture",
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			cmd.Usage()
@@ -131,7 +175,19 @@ var CaptureDelete = &cobra.Command{
 
 func addCaptureFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&probePath, "probepath", "", "", "probe path")
+	cmd.Flags().StringVarP(&captureGremlinQuery, "gremlin", "", "", "Gremlin query selecting the nodes to capture, evaluated again as the topology changes")
 	cmd.Flags().StringVarP(&bpfFilter, "bpf", "", "", "BPF filter")
+	cmd.Flags().StringVarP(&captureType, "type", "", "", "capture type, pcap, afpacket, ebpf or erspan, default pcap")
+	cmd.Flags().IntVarP(&snapLen, "snaplen", "", 0, "snapshot length of the captured packets")
+	cmd.Flags().IntVarP(&rawPacketLimit, "rawpackets", "", 0, "number of packets kept by the agents to be downloaded as pcap")
+	cmd.Flags().Int64VarP(&duration, "duration", "", 0, "duration of the capture in seconds, unlimited by default")
+	cmd.Flags().StringVarP(&export, "export", "", "", "collector to which the flows are re-exported, ipfix://host[:port] or sflow://host[:port]")
+	cmd.Flags().IntVarP(&activeTimeout, "active-timeout", "", 0, "seconds after which the active flows expire, agent configuration by default")
+	cmd.Flags().IntVarP(&idleTimeout, "idle-timeout", "", 0, "seconds after which the idle flows expire, agent configuration by default")
+	cmd.Flags().Uint32VarP(&samplingRate, "sampling", "", 0, "sampling rate of the captures, 1 out of N packets, agent configuration by default for the Open vSwitch bridges")
+	cmd.Flags().Uint32VarP(&pollingPeriod, "polling", "", 0, "sFlow counters polling interval in seconds of the captures on Open vSwitch bridges, agent configuration by default")
+	cmd.Flags().IntVarP(&packetRate, "packet-rate", "", 0, "maximum number of packets per second processed by the pcap and afpacket captures, unlimited by default")
+	cmd.Flags().Int64VarP(&maxBytes, "max-bytes", "", 0, "number of bytes after which the pcap and afpacket captures stop processing the packets, unlimited by default")
 }
 
 func init() {
@@ -26,6 +26,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
@@ -34,6 +36,7 @@ import (
 
 var (
 	authenticationOpts shttp.AuthenticationOpts
+	outputFormat       string
 )
 
 var Client = &cobra.Command{
@@ -52,6 +55,25 @@ func printJSON(obj interface{}) {
 	fmt.Println(string(s))
 }
 
+func printTable(columns []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// printOutput prints the object as JSON or, with the table output format,
+// prints the given rows
+func printOutput(obj interface{}, columns []string, rows [][]string) {
+	if outputFormat == "table" {
+		printTable(columns, rows)
+		return
+	}
+	printJSON(obj)
+}
+
 func setFromFlag(cmd *cobra.Command, flag string, value *string) {
 	if flag := cmd.LocalFlags().Lookup(flag); flag.Changed {
 		*value = flag.Value.String()
@@ -61,8 +83,12 @@ func setFromFlag(cmd *cobra.Command, flag string, value *string) {
 func init() {
 	Client.PersistentFlags().StringVarP(&authenticationOpts.Username, "username", "", os.Getenv("SKYDIVE_USERNAME"), "username auth parameter")
 	Client.PersistentFlags().StringVarP(&authenticationOpts.Password, "password", "", os.Getenv("SKYDIVE_PASSWORD"), "password auth parameter")
+	Client.PersistentFlags().StringVarP(&outputFormat, "format", "", "json", "output format (json, table)")
 
 	Client.AddCommand(AlertCmd)
+	Client.AddCommand(AnnotationCmd)
 	Client.AddCommand(CaptureCmd)
+	Client.AddCommand(FlowCmd)
+	Client.AddCommand(InjectPacketCmd)
 	Client.AddCommand(TopologyCmd)
 }
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+var (
+	flowFilters []string
+)
+
+var FlowCmd = &cobra.Command{
+	Use:          "flow",
+	Short:        "Request on flows",
+	Long:         "Request on flows",
+	SilenceUsage: false,
+}
+
+// SearchFlows returns the flows of the analyzer storage matching the
+// filters, given as key=value
+func SearchFlows(auth *shttp.AuthenticationOpts, filters []string) ([]*flow.Flow, error) {
+	client := shttp.NewRestClientFromConfig(auth)
+	if client == nil {
+		return nil, fmt.Errorf("Unable to create the REST client")
+	}
+
+	params := url.Values{}
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid filter %s, expected key=value", filter)
+		}
+		params.Set(kv[0], kv[1])
+	}
+
+	resp, err := client.Request("GET", "api/flow/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, string(data))
+	}
+
+	var flows []*flow.Flow
+	if err := json.NewDecoder(resp.Body).Decode(&flows); err != nil {
+		return nil, fmt.Errorf("Unable to decode response: %s", err.Error())
+	}
+
+	return flows, nil
+}
+
+func flowRows(flows []*flow.Flow) (rows [][]string) {
+	for _, f := range flows {
+		var start, last string
+		var endpoints []string
+		if s := f.GetStatistics(); s != nil {
+			start = time.Unix(s.Start, 0).Format(time.RFC3339)
+			last = time.Unix(s.Last, 0).Format(time.RFC3339)
+			for _, e := range s.GetEndpoints() {
+				if e.GetAB() != nil && e.GetBA() != nil {
+					endpoints = append(endpoints, fmt.Sprintf("%s %s->%s", e.Type, e.AB.Value, e.BA.Value))
+				}
+			}
+		}
+		rows = append(rows, []string{f.UUID, f.LayersPath, f.ProbeNodeUUID, start, last, strings.Join(endpoints, ", ")})
+	}
+	return
+}
+
+var FlowSearch = &cobra.Command{
+	Use:   "search",
+	Short: "search flows",
+	Long:  "search the flows of the analyzer storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		flows, err := SearchFlows(&authenticationOpts, flowFilters)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		printOutput(flows, []string{"UUID", "LAYERS", "PROBE", "START", "LAST", "ENDPOINTS"}, flowRows(flows))
+	},
+}
+
+func init() {
+	FlowCmd.AddCommand(FlowSearch)
+
+	FlowSearch.Flags().StringSliceVarP(&flowFilters, "filter", "", nil, "filter on a flow field, ex: ProbeNodeUUID=<id>")
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/redhat-cip/skydive/api"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+var packetInjection api.PacketInjection
+
+func InjectPacket(auth *shttp.AuthenticationOpts, pi *api.PacketInjection) (*api.InjectionResult, error) {
+	client := shttp.NewRestClientFromConfig(auth)
+
+	s, err := json.Marshal(pi)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Request("POST", "api/injectpacket", bytes.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, string(data))
+	}
+
+	var result api.InjectionResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Unable to decode response: %s", err.Error())
+	}
+
+	return &result, nil
+}
+
+var InjectPacketCmd = &cobra.Command{
+	Use:   "inject-packet",
+	Short: "inject packets",
+	Long:  "inject packets from the interface of a node and check that they reach the captured destination",
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := InjectPacket(&authenticationOpts, &packetInjection)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		printJSON(result)
+	},
+}
+
+func addInjectPacketFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&packetInjection.Src, "src", "", "", "Gremlin query of the source node")
+	cmd.Flags().StringVarP(&packetInjection.Dst, "dst", "", "", "Gremlin query of the destination node")
+	cmd.Flags().StringVarP(&packetInjection.Type, "type", "", "icmp", "packet type: icmp, tcp or udp")
+	cmd.Flags().StringVarP(&packetInjection.SrcIP, "src-ip", "", "", "source IP, the one of the source node by default")
+	cmd.Flags().StringVarP(&packetInjection.DstIP, "dst-ip", "", "", "destination IP, the one of the destination node by default")
+	cmd.Flags().StringVarP(&packetInjection.SrcMAC, "src-mac", "", "", "source MAC, the one of the source node by default")
+	cmd.Flags().StringVarP(&packetInjection.DstMAC, "dst-mac", "", "", "destination MAC, the one of the destination node by default")
+	cmd.Flags().Uint16VarP(&packetInjection.SrcPort, "src-port", "", 0, "source port for TCP and UDP")
+	cmd.Flags().Uint16VarP(&packetInjection.DstPort, "dst-port", "", 0, "destination port for TCP and UDP")
+	cmd.Flags().IntVarP(&packetInjection.Count, "count", "", 1, "number of packets")
+	cmd.Flags().IntVarP(&packetInjection.Interval, "interval", "", 1000, "interval between the packets in milliseconds")
+	cmd.Flags().StringVarP(&packetInjection.Payload, "payload", "", "", "payload of the packets")
+}
+
+func init() {
+	addInjectPacketFlags(InjectPacketCmd)
+}
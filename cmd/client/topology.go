@@ -26,18 +26,31 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
 )
 
 var (
 	gremlinQuery string
+	eventsHost   string
+	eventsFilter []string
+	snapshotFile string
+	replay       bool
+	diffFrom     string
+	diffTo       string
 )
 
 var TopologyCmd = &cobra.Command{
@@ -58,7 +71,7 @@ func SendGremlinQuery(auth *shttp.AuthenticationOpts, query string) (interface{}
 
 	contentReader := bytes.NewReader(s)
 
-	resp, err := client.Request("GET", "api/topology", contentReader)
+	resp, err := client.Request("POST", "api/topology", contentReader)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +100,259 @@ var TopologyRequest = &cobra.Command{
 			logging.GetLogger().Errorf(err.Error())
 			os.Exit(1)
 		}
-		printJSON(values)
+		printOutput(values, []string{"ID", "HOST", "NAME", "TYPE"}, nodeRows(values))
+	},
+}
+
+// nodeRows returns the rows of the nodes found in the result of a query,
+// the other values not being printable as a table
+func nodeRows(values interface{}) (rows [][]string) {
+	list, ok := values.([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, value := range list {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		metadata, _ := node["Metadata"].(map[string]interface{})
+		rows = append(rows, []string{
+			fmt.Sprint(node["ID"]), fmt.Sprint(node["Host"]),
+			fmt.Sprint(metadata["Name"]), fmt.Sprint(metadata["Type"]),
+		})
+	}
+	return
+}
+
+type eventsPrinter struct {
+	shttp.DefaultWSClientEventHandler
+}
+
+func (p *eventsPrinter) OnMessage(msg shttp.WSMessage) {
+	if outputFormat != "table" {
+		b, _ := json.Marshal(msg)
+		fmt.Println(string(b))
+		return
+	}
+
+	var element struct {
+		ID       string
+		Metadata map[string]interface{}
+	}
+	if msg.Obj != nil {
+		json.Unmarshal([]byte(*msg.Obj), &element)
+	}
+	fmt.Printf("%s\t%s\t%s\t%v\t%v\n", time.Now().Format(time.RFC3339), msg.Type, element.ID, element.Metadata["Name"], element.Metadata["Type"])
+}
+
+// NewEventsClientFromConfig returns a WebSocket client subscribed to the
+// events of the analyzer graph matching the given host and metadata filters
+func NewEventsClientFromConfig(auth *shttp.AuthenticationOpts, host string, filters []string) (*shttp.WSAsyncClient, error) {
+	addr, port, err := config.GetAnalyzerClientAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := shttp.TLSConfigFromConfig("agent")
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if host != "" {
+		params.Set("host", host)
+	}
+	for _, filter := range filters {
+		params.Add("filter", filter)
+	}
+
+	path := "/ws"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	authClient := shttp.NewAuthenticationClient(addr, port, auth)
+	authClient.TLSConfig = tlsConfig
+
+	client, err := shttp.NewWSAsyncClient(addr, port, path, authClient)
+	if err != nil {
+		return nil, err
+	}
+	client.TLSConfig = tlsConfig
+	client.Reliable = true
+
+	return client, nil
+}
+
+var TopologyEvents = &cobra.Command{
+	Use:   "events",
+	Short: "tail topology events",
+	Long:  "tail topology events",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := NewEventsClientFromConfig(&authenticationOpts, eventsHost, eventsFilter)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		client.AddEventHandler(&eventsPrinter{}, graph.Namespace)
+		client.Connect()
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+
+		client.Disconnect()
+	},
+}
+
+var TopologySnapshot = &cobra.Command{
+	Use:   "snapshot",
+	Short: "snapshot topology",
+	Long:  "save the whole topology to a file",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := shttp.NewRestClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("GET", "api/topology/snapshot", nil)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			data, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("%s: %s", resp.Status, string(data))
+			os.Exit(1)
+		}
+
+		out := os.Stdout
+		if snapshotFile != "" && snapshotFile != "-" {
+			if out, err = os.Create(snapshotFile); err != nil {
+				logging.GetLogger().Errorf(err.Error())
+				os.Exit(1)
+			}
+			defer out.Close()
+		}
+
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var TopologyRestore = &cobra.Command{
+	Use:   "restore",
+	Short: "restore topology",
+	Long:  "replace the topology by a snapshot",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := shttp.NewRestClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+
+		in := os.Stdin
+		if snapshotFile != "" && snapshotFile != "-" {
+			var err error
+			if in, err = os.Open(snapshotFile); err != nil {
+				logging.GetLogger().Errorf(err.Error())
+				os.Exit(1)
+			}
+			defer in.Close()
+		}
+
+		resp, err := client.Request("POST", fmt.Sprintf("api/topology/snapshot?replay=%t", replay), in)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			data, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("%s: %s", resp.Status, string(data))
+			os.Exit(1)
+		}
+	},
+}
+
+// diffSide returns the time, or the content of the snapshot file, of a side
+// of a diff
+func diffSide(value string) (string, *json.RawMessage, error) {
+	if value == "" {
+		return "", nil, nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, value); err == nil {
+		return value, nil, nil
+	}
+
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s is neither a RFC3339 time nor a snapshot file: %s", value, err.Error())
+	}
+	raw := json.RawMessage(data)
+	return "", &raw, nil
+}
+
+var TopologyDiff = &cobra.Command{
+	Use:   "diff",
+	Short: "diff topology",
+	Long:  "list the nodes and edges added, deleted and updated between two times or two snapshots",
+	Run: func(cmd *cobra.Command, args []string) {
+		var diff api.TopologyDiff
+		var err error
+		if diff.From, diff.FromSnapshot, err = diffSide(diffFrom); err == nil {
+			diff.To, diff.ToSnapshot, err = diffSide(diffTo)
+		}
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+
+		client := shttp.NewRestClientFromConfig(&authenticationOpts)
+		if client == nil {
+			os.Exit(1)
+		}
+
+		s, err := json.Marshal(diff)
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+
+		resp, err := client.Request("POST", "api/topology/diff", bytes.NewReader(s))
+		if err != nil {
+			logging.GetLogger().Errorf(err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			data, _ := ioutil.ReadAll(resp.Body)
+			logging.GetLogger().Errorf("%s: %s", resp.Status, string(data))
+			os.Exit(1)
+		}
+
+		var result map[string][]map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			logging.GetLogger().Errorf("Unable to decode response: %s", err.Error())
+			os.Exit(1)
+		}
+
+		var rows [][]string
+		for _, change := range []string{"AddedNodes", "DeletedNodes", "UpdatedNodes", "AddedEdges", "DeletedEdges", "UpdatedEdges"} {
+			for _, element := range result[change] {
+				metadata, _ := element["Metadata"].(map[string]interface{})
+				rows = append(rows, []string{change, fmt.Sprint(element["ID"]), fmt.Sprint(metadata["Name"]), fmt.Sprint(metadata["Type"])})
+			}
+		}
+		printOutput(result, []string{"CHANGE", "ID", "NAME", "TYPE"}, rows)
 	},
 }
 
@@ -97,6 +362,20 @@ func addTopologyFlags(cmd *cobra.Command) {
 
 func init() {
 	TopologyCmd.AddCommand(TopologyRequest)
+	TopologyCmd.AddCommand(TopologyEvents)
+	TopologyCmd.AddCommand(TopologySnapshot)
+	TopologyCmd.AddCommand(TopologyRestore)
+	TopologyCmd.AddCommand(TopologyDiff)
 
 	addTopologyFlags(TopologyRequest)
+
+	TopologySnapshot.Flags().StringVarP(&snapshotFile, "output", "o", "", "file to which the snapshot is written, stdout by default")
+	TopologyRestore.Flags().StringVarP(&snapshotFile, "input", "i", "", "snapshot file to restore, stdin by default")
+	TopologyRestore.Flags().BoolVarP(&replay, "replay", "", false, "refuse the updates of the agents to keep the snapshot as is")
+
+	TopologyDiff.Flags().StringVarP(&diffFrom, "from", "", "", "RFC3339 time or snapshot file, the current topology by default")
+	TopologyDiff.Flags().StringVarP(&diffTo, "to", "", "", "RFC3339 time or snapshot file, the current topology by default")
+
+	TopologyEvents.Flags().StringVarP(&eventsHost, "host", "", "", "only the events of the elements created by this host")
+	TopologyEvents.Flags().StringSliceVarP(&eventsFilter, "filter", "", nil, "only the events of the nodes matching the metadata filter, ex: Type:ovsbridge")
 }
@@ -75,6 +75,11 @@ func toFloat64(f interface{}) (float64, error) {
 	return 0, fmt.Errorf("not a float: %v", f)
 }
 
+// ToFloat64 converts a number of any type to a float64
+func ToFloat64(f interface{}) (float64, error) {
+	return toFloat64(f)
+}
+
 func floatEqual(a interface{}, b interface{}) bool {
 	f1, err := toFloat64(a)
 	if err != nil {
@@ -25,6 +25,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -42,24 +43,85 @@ func init() {
 	cfg = viper.New()
 	cfg.SetDefault("agent.analyzers", "127.0.0.1:8082")
 	cfg.SetDefault("agent.listen", "127.0.0.1:8081")
+	cfg.SetDefault("agent.heartbeat_interval", 30)
+	cfg.SetDefault("agent.flow.process_mapping", false)
+	cfg.SetDefault("agent.flow.conntrack", false)
+	cfg.SetDefault("agent.flow.active_timeout", 0)
+	cfg.SetDefault("agent.flow.idle_timeout", 0)
+	cfg.SetDefault("agent.flow.afpacket.fanout", 0)
+	cfg.SetDefault("agent.flow.afpacket.block_size", 1048576)
+	cfg.SetDefault("agent.flow.afpacket.num_blocks", 16)
+	cfg.SetDefault("agent.flow.dpdk.eal_args", []string{"--proc-type=secondary"})
+	cfg.SetDefault("agent.flow.dpdk.sampling", 1)
+	cfg.SetDefault("agent.flow.erspan.listen", "0.0.0.0")
+	cfg.SetDefault("agent.limits.max_flows", 0)
+	cfg.SetDefault("agent.limits.max_captures", 0)
+	cfg.SetDefault("agent.limits.max_packet_memory", 0)
 	cfg.SetDefault("ovs.ovsdb", "unix:///var/run/openvswitch/db.sock")
+	cfg.SetDefault("ovn.northbound", "unix:///var/run/openvswitch/ovnnb_db.sock")
 	cfg.SetDefault("graph.backend", "memory")
 	cfg.SetDefault("graph.gremlin", "ws://127.0.0.1:8182")
+	cfg.SetDefault("graph.bolt.path", "/var/lib/skydive/graph.db")
+	cfg.SetDefault("graph.id_generator", "uuid")
+	cfg.SetDefault("graph.lazy_metadata_threshold", 0)
+	cfg.SetDefault("graph.mirror.gremlin", "")
+	cfg.SetDefault("graph.coalesce_window", 0)
+	cfg.SetDefault("graph.neighborhood_max_depth", 5)
+	cfg.SetDefault("graph.edge_merge_policy", "dedupe")
+	cfg.SetDefault("graph.schema_policy", "log")
+	cfg.SetDefault("graph.history.enabled", false)
+	cfg.SetDefault("graph.history.retention", 86400)
+	cfg.SetDefault("graph.history.max_revisions", 1000000)
+	cfg.SetDefault("graph.sync.chunk_size", 500)
+	cfg.SetDefault("graph.sync.tombstones", 10000)
+	cfg.SetDefault("graph.indexes", []string{"Type", "Name", "TID", "MAC"})
+	cfg.SetDefault("graph.articulation_points.interval", 0)
+	cfg.SetDefault("graph.articulation_points.relation_type", "layer2")
 	cfg.SetDefault("sflow.port_min", 6345)
 	cfg.SetDefault("sflow.port_max", 6355)
+	cfg.SetDefault("sflow.header_size", 256)
+	cfg.SetDefault("sflow.sampling", 1)
+	cfg.SetDefault("sflow.polling", 0)
+	cfg.SetDefault("netflow.listen", "127.0.0.1:2055")
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
+	cfg.SetDefault("analyzer.grpc_listen", "")
 	cfg.SetDefault("analyzer.flowtable_expire", 600)
 	cfg.SetDefault("analyzer.flowtable_update", 60)
 	cfg.SetDefault("analyzer.flowtable_agent_ratio", 0.5)
+	cfg.SetDefault("analyzer.topology_history", true)
+	cfg.SetDefault("analyzer.election_ttl", 10)
+	cfg.SetDefault("analyzer.alert_flow_interval", 10)
+	cfg.SetDefault("analyzer.rollup.period", 60)
+	cfg.SetDefault("analyzer.rollup.dimensions", []string{"application", "hostpair", "tenant", "vlan"})
+	cfg.SetDefault("analyzer.agent_disconnect.policy", "keep")
+	cfg.SetDefault("analyzer.agent_disconnect.grace_period", 300)
 	cfg.SetDefault("storage.elasticsearch", "127.0.0.1:9200")
+	cfg.SetDefault("storage.influxdb.url", "http://127.0.0.1:8086")
+	cfg.SetDefault("storage.influxdb.database", "skydive")
+	cfg.SetDefault("storage.retention.interval", 3600)
 	cfg.SetDefault("ws_pong_timeout", 5)
+	cfg.SetDefault("ws_reconnect_max_delay", 30)
+	cfg.SetDefault("ws_replay_buffer", 1000)
+	cfg.SetDefault("ws_channel_size", 100000)
+	cfg.SetDefault("ws_channel_high_watermark", 75)
+	cfg.SetDefault("ws_max_message_size", 1048576)
+	cfg.SetDefault("ws_compression", false)
 	cfg.SetDefault("docker.url", "unix:///var/run/docker.sock")
 	cfg.SetDefault("netns.run_path", "/var/run/netns")
+	cfg.SetDefault("netns.scan_interval", 30)
+	cfg.SetDefault("netlink.stats_interval", 10)
+	cfg.SetDefault("netlink.stats_delta", 0.1)
+	cfg.SetDefault("libvirt.run_path", "/var/run/libvirt/qemu")
+	cfg.SetDefault("fdb.poll_interval", 10)
+	cfg.SetDefault("fdb.aging", 60)
+	cfg.SetDefault("k8s.url", "http://127.0.0.1:8080")
+	cfg.SetDefault("k8s.token_file", "/var/run/secrets/kubernetes.io/serviceaccount/token")
 	cfg.SetDefault("etcd.data_dir", "/tmp/skydive-etcd")
 	cfg.SetDefault("etcd.embedded", true)
 	cfg.SetDefault("etcd.port", 2379)
 	cfg.SetDefault("etcd.servers", []string{"http://127.0.0.1:2379"})
 	cfg.SetDefault("auth.type", "noauth")
+	cfg.SetDefault("auth.default_role", "reader")
 	cfg.SetDefault("auth.keystone.tenant", "admin")
 }
 
@@ -178,17 +240,53 @@ func GetHostPortAttributes(s string, p string) (string, int, error) {
 	}
 }
 
-func GetAnalyzerClientAddr() (string, int, error) {
-	analyzers := GetConfig().GetStringSlice("agent.analyzers")
-	// TODO(safchain) HA Connection ???
-	if len(analyzers) > 0 {
-		addr := strings.Split(analyzers[0], ":")[0]
-		port, err := strconv.Atoi(strings.Split(analyzers[0], ":")[1])
+type ServiceAddress struct {
+	Addr string
+	Port int
+}
+
+// GetServiceAddresses returns the addresses of a list of addr:port entries
+func GetServiceAddresses(key string) ([]ServiceAddress, error) {
+	var addresses []ServiceAddress
+	for _, a := range GetConfig().GetStringSlice(key) {
+		sa, err := ParseServiceAddress(a)
 		if err != nil {
-			return "", 0, err
+			return nil, fmt.Errorf("%s in %s", err.Error(), key)
 		}
+		addresses = append(addresses, sa)
+	}
+	return addresses, nil
+}
 
-		return addr, port, nil
+// ParseServiceAddress parses an addr:port address, IPv6 addresses being
+// enclosed in brackets, ex: [::1]:8082
+func ParseServiceAddress(a string) (ServiceAddress, error) {
+	addr, p, err := net.SplitHostPort(a)
+	if err != nil {
+		return ServiceAddress{}, fmt.Errorf("Malformed address %s", a)
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return ServiceAddress{}, err
+	}
+	return ServiceAddress{Addr: addr, Port: port}, nil
+}
+
+// GetAnalyzerClientAddrs returns the addresses of the analyzers, the agents
+// failing over to the next one when the connection is lost
+func GetAnalyzerClientAddrs() ([]ServiceAddress, error) {
+	return GetServiceAddresses("agent.analyzers")
+}
+
+func GetAnalyzerClientAddr() (string, int, error) {
+	analyzers, err := GetAnalyzerClientAddrs()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(analyzers) > 0 {
+		return analyzers[0].Addr, analyzers[0].Port, nil
 	}
 	return "", 0, nil
 }
@@ -215,6 +313,21 @@ func GetAgentExpire() time.Duration {
 	return time.Duration(agentExpire) * time.Second
 }
 
+// GetAgentIdleTimeout returns the delay after which the flows without any
+// packet expire, the agent expire by default
+func GetAgentIdleTimeout() time.Duration {
+	if timeout := GetConfig().GetInt("agent.flow.idle_timeout"); timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return GetAgentExpire()
+}
+
+// GetAgentActiveTimeout returns the delay after which the flows still
+// active expire, 0 if disabled
+func GetAgentActiveTimeout() time.Duration {
+	return time.Duration(GetConfig().GetInt("agent.flow.active_timeout")) * time.Second
+}
+
 func GetAgentUpdate() time.Duration {
 	analyzerUpdate := GetConfig().GetInt("analyzer.flowtable_update")
 	agentUpdate := int(float64(analyzerUpdate) * GetAgentRatio())
@@ -0,0 +1,11 @@
+from setuptools import setup
+
+setup(
+    name="skydive-client",
+    version="0.1.0",
+    description="Python client of the Skydive REST and WebSocket API",
+    license="Apache License, Version 2.0",
+    url="https://github.com/redhat-cip/skydive",
+    packages=["skydive"],
+    extras_require={"websocket": ["websocket-client"]},
+)
@@ -0,0 +1,195 @@
+# Code generated by contrib/python/generate.go from the Go API types.
+# DO NOT EDIT.
+
+
+class APIObject(object):
+    """Object of the API, encoded as a dict of its fields not set to None"""
+
+    fields = ()
+
+    def to_json(self):
+        return dict((k, getattr(self, k)) for k in self.fields
+                    if getattr(self, k) is not None)
+
+    @classmethod
+    def from_json(cls, obj):
+        return cls(**dict((k, v) for k, v in obj.items() if k in cls.fields))
+
+    def __repr__(self):
+        return "%s(%s)" % (self.__class__.__name__, ", ".join(
+            "%s=%r" % (k, v) for k, v in sorted(self.to_json().items())))
+
+
+class Alert(APIObject):
+    """Alert of the API
+
+    Fields:
+        UUID: str
+        Name: str
+        Description: str
+        Select: str
+        Test: str
+        Action: str
+        Type: int
+        Count: int
+        CreateTime: str (RFC3339)
+    """
+
+    fields = ("UUID", "Name", "Description", "Select", "Test", "Action", "Type", "Count", "CreateTime",)
+
+    def __init__(self, UUID=None, Name=None, Description=None, Select=None, Test=None, Action=None, Type=None, Count=None, CreateTime=None):
+        self.UUID = UUID
+        self.Name = Name
+        self.Description = Description
+        self.Select = Select
+        self.Test = Test
+        self.Action = Action
+        self.Type = Type
+        self.Count = Count
+        self.CreateTime = CreateTime
+
+
+class Capture(APIObject):
+    """Capture of the API
+
+    Fields:
+        UUID: str
+        ProbePath: str
+        GremlinQuery: str
+        BPFFilter: str
+        Type: str
+        SnapLen: int
+        Duration: int
+        RawPacketLimit: int
+        Export: str
+        ActiveTimeout: int
+        IdleTimeout: int
+        SamplingRate: int
+        PollingPeriod: int
+        PacketRate: int
+        MaxBytes: int
+    """
+
+    fields = ("UUID", "ProbePath", "GremlinQuery", "BPFFilter", "Type", "SnapLen", "Duration", "RawPacketLimit", "Export", "ActiveTimeout", "IdleTimeout", "SamplingRate", "PollingPeriod", "PacketRate", "MaxBytes",)
+
+    def __init__(self, UUID=None, ProbePath=None, GremlinQuery=None, BPFFilter=None, Type=None, SnapLen=None, Duration=None, RawPacketLimit=None, Export=None, ActiveTimeout=None, IdleTimeout=None, SamplingRate=None, PollingPeriod=None, PacketRate=None, MaxBytes=None):
+        self.UUID = UUID
+        self.ProbePath = ProbePath
+        self.GremlinQuery = GremlinQuery
+        self.BPFFilter = BPFFilter
+        self.Type = Type
+        self.SnapLen = SnapLen
+        self.Duration = Duration
+        self.RawPacketLimit = RawPacketLimit
+        self.Export = Export
+        self.ActiveTimeout = ActiveTimeout
+        self.IdleTimeout = IdleTimeout
+        self.SamplingRate = SamplingRate
+        self.PollingPeriod = PollingPeriod
+        self.PacketRate = PacketRate
+        self.MaxBytes = MaxBytes
+
+
+class GraphQLRequest(APIObject):
+    """GraphQLRequest of the API
+
+    Fields:
+        query: str
+        operationName: str
+        variables: dict
+    """
+
+    fields = ("query", "operationName", "variables",)
+
+    def __init__(self, query=None, operationName=None, variables=None):
+        self.query = query
+        self.operationName = operationName
+        self.variables = variables
+
+
+class InjectionResult(APIObject):
+    """InjectionResult of the API
+
+    Fields:
+        Count: int
+        Verified: bool
+        Received: bool
+        Flows: list of dict
+    """
+
+    fields = ("Count", "Verified", "Received", "Flows",)
+
+    def __init__(self, Count=None, Verified=None, Received=None, Flows=None):
+        self.Count = Count
+        self.Verified = Verified
+        self.Received = Received
+        self.Flows = Flows
+
+
+class PacketInjection(APIObject):
+    """PacketInjection of the API
+
+    Fields:
+        Src: str
+        Dst: str
+        Type: str
+        SrcIP: str
+        DstIP: str
+        SrcMAC: str
+        DstMAC: str
+        SrcPort: int
+        DstPort: int
+        Count: int
+        Interval: int
+        Payload: str
+    """
+
+    fields = ("Src", "Dst", "Type", "SrcIP", "DstIP", "SrcMAC", "DstMAC", "SrcPort", "DstPort", "Count", "Interval", "Payload",)
+
+    def __init__(self, Src=None, Dst=None, Type=None, SrcIP=None, DstIP=None, SrcMAC=None, DstMAC=None, SrcPort=None, DstPort=None, Count=None, Interval=None, Payload=None):
+        self.Src = Src
+        self.Dst = Dst
+        self.Type = Type
+        self.SrcIP = SrcIP
+        self.DstIP = DstIP
+        self.SrcMAC = SrcMAC
+        self.DstMAC = DstMAC
+        self.SrcPort = SrcPort
+        self.DstPort = DstPort
+        self.Count = Count
+        self.Interval = Interval
+        self.Payload = Payload
+
+
+class Topology(APIObject):
+    """Topology of the API
+
+    Fields:
+        GremlinQuery: str
+        At: str
+    """
+
+    fields = ("GremlinQuery", "At",)
+
+    def __init__(self, GremlinQuery=None, At=None):
+        self.GremlinQuery = GremlinQuery
+        self.At = At
+
+
+class TopologyDiff(APIObject):
+    """TopologyDiff of the API
+
+    Fields:
+        From: str
+        To: str
+        FromSnapshot: object
+        ToSnapshot: object
+    """
+
+    fields = ("From", "To", "FromSnapshot", "ToSnapshot",)
+
+    def __init__(self, From=None, To=None, FromSnapshot=None, ToSnapshot=None):
+        self.From = From
+        self.To = To
+        self.FromSnapshot = FromSnapshot
+        self.ToSnapshot = ToSnapshot
@@ -0,0 +1,172 @@
+#
+# Copyright (C) 2016 Red Hat, Inc.
+#
+# Licensed to the Apache Software Foundation (ASF) under one
+# or more contributor license agreements.  See the NOTICE file
+# distributed with this work for additional information
+# regarding copyright ownership.  The ASF licenses this file
+# to you under the Apache License, Version 2.0 (the
+# "License"); you may not use this file except in compliance
+# with the License.  You may obtain a copy of the License at
+#
+#  http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing,
+# software distributed under the License is distributed on an
+# "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+# KIND, either express or implied.  See the License for the
+# specific language governing permissions and limitations
+# under the License.
+#
+
+import json
+
+try:
+    from http.cookiejar import CookieJar
+    from urllib.error import HTTPError
+    from urllib.parse import urlencode
+    from urllib.request import HTTPCookieProcessor, Request, build_opener
+except ImportError:
+    from cookielib import CookieJar
+    from urllib import urlencode
+    from urllib2 import HTTPCookieProcessor, HTTPError, Request, build_opener
+
+from skydive.api import (Alert, Capture, GraphQLRequest, InjectionResult,
+                         Topology)
+
+
+class APIError(Exception):
+    """Error returned by the API, with the HTTP status code"""
+
+    def __init__(self, status, message):
+        Exception.__init__(self, "%d: %s" % (status, message))
+        self.status = status
+
+
+class RESTClient(object):
+    """Client of the REST API of an analyzer, or of an agent for the
+    topology queries"""
+
+    def __init__(self, endpoint="localhost:8082", scheme="http",
+                 username="", password=""):
+        self.endpoint = endpoint
+        self.scheme = scheme
+        self.username = username
+        self.password = password
+        self.cookies = CookieJar()
+        self.opener = build_opener(HTTPCookieProcessor(self.cookies))
+
+    def url(self, path):
+        return "%s://%s%s" % (self.scheme, self.endpoint, path)
+
+    def login(self):
+        """Authenticates with the username and the password, the
+        authentication token being sent with the next requests"""
+        data = urlencode({"username": self.username,
+                          "password": self.password}).encode()
+        try:
+            self.opener.open(Request(self.url("/login"), data=data))
+        except HTTPError as e:
+            raise APIError(e.code, "authentication failed")
+
+    def request(self, method, path, data=None):
+        headers = {"Content-Type": "application/json"}
+        if data is not None:
+            data = json.dumps(data).encode()
+
+        req = Request(self.url(path), data=data, headers=headers)
+        req.get_method = lambda: method
+
+        try:
+            resp = self.opener.open(req)
+        except HTTPError as e:
+            message = e.read().decode() or e.reason
+            try:
+                # the GraphQL errors
+                message = "; ".join(
+                    err["message"] for err in json.loads(message)["errors"])
+            except (ValueError, KeyError, TypeError):
+                pass
+            raise APIError(e.code, message)
+
+        body = resp.read().decode()
+        if not body:
+            return None
+        return json.loads(body)
+
+    # topology
+
+    def lookup(self, gremlin, at=None):
+        """Returns the result of a Gremlin query, on the topology as it was
+        at the given RFC3339 time if given"""
+        return self.request("POST", "/api/topology",
+                            Topology(GremlinQuery=gremlin, At=at).to_json())
+
+    def topology(self):
+        return self.request("GET", "/api/topology")
+
+    # captures
+
+    def capture_list(self):
+        captures = self.request("GET", "/api/capture") or {}
+        return dict((k, Capture.from_json(v)) for k, v in captures.items())
+
+    def capture_get(self, id):
+        return Capture.from_json(self.request("GET", "/api/capture/" + id))
+
+    def capture_create(self, capture):
+        return Capture.from_json(
+            self.request("POST", "/api/capture", capture.to_json()))
+
+    def capture_delete(self, id):
+        self.request("DELETE", "/api/capture/" + id)
+
+    # alerts
+
+    def alert_list(self):
+        alerts = self.request("GET", "/api/alert") or {}
+        return dict((k, Alert.from_json(v)) for k, v in alerts.items())
+
+    def alert_create(self, alert):
+        return Alert.from_json(
+            self.request("POST", "/api/alert", alert.to_json()))
+
+    def alert_delete(self, id):
+        self.request("DELETE", "/api/alert/" + id)
+
+    # flows
+
+    def flow_search(self, **filters):
+        """Returns the stored flows having the given field values, ex:
+        flow_search(ProbeNodeUUID="...")"""
+        return self.request("GET",
+                            "/api/flow/search?" + urlencode(filters)) or []
+
+    def flow_correlation(self, min=1):
+        """Returns the flows observed by at least min capture points"""
+        return self.request("GET",
+                            "/api/flow/correlation?min=%d" % min) or []
+
+    def flow_traversing(self, node=None, src=None, dst=None,
+                        start=None, end=None):
+        """Returns the flows observed traversing the node, or the path
+        between the src and dst nodes, between the start and end RFC3339
+        times, the last hour by default"""
+        params = {"node": node, "src": src, "dst": dst,
+                  "from": start, "to": end}
+        params = dict((k, v) for k, v in params.items() if v is not None)
+        return self.request("GET",
+                            "/api/flow/traversing?" + urlencode(params)) or []
+
+    def inject_packet(self, injection):
+        return InjectionResult.from_json(
+            self.request("POST", "/api/injectpacket", injection.to_json()))
+
+    # graphql
+
+    def graphql(self, query, variables=None, operation_name=None):
+        """Returns the data of a GraphQL query, raising an APIError with
+        the messages of the errors"""
+        request = GraphQLRequest(query=query, variables=variables,
+                                 operationName=operation_name)
+        return self.request("POST", "/api/graphql", request.to_json())["data"]
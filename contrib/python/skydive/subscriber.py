@@ -0,0 +1,126 @@
+#
+# Copyright (C) 2016 Red Hat, Inc.
+#
+# Licensed to the Apache Software Foundation (ASF) under one
+# or more contributor license agreements.  See the NOTICE file
+# distributed with this work for additional information
+# regarding copyright ownership.  The ASF licenses this file
+# to you under the Apache License, Version 2.0 (the
+# "License"); you may not use this file except in compliance
+# with the License.  You may obtain a copy of the License at
+#
+#  http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing,
+# software distributed under the License is distributed on an
+# "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+# KIND, either express or implied.  See the License for the
+# specific language governing permissions and limitations
+# under the License.
+#
+
+import json
+
+try:
+    from urllib.parse import urlencode
+except ImportError:
+    from urllib import urlencode
+
+# websocket-client
+import websocket
+
+
+class TopologyHandler(object):
+    """Gets the topology events of a TopologySubscriber, the nodes and the
+    edges being the dicts sent by the server. The nodes and edges of a
+    synchronization are given to on_node_added and on_edge_added."""
+
+    def on_node_added(self, node):
+        pass
+
+    def on_node_updated(self, node):
+        pass
+
+    def on_node_deleted(self, node):
+        pass
+
+    def on_edge_added(self, edge):
+        pass
+
+    def on_edge_updated(self, edge):
+        pass
+
+    def on_edge_deleted(self, edge):
+        pass
+
+    def on_synchronized(self):
+        pass
+
+
+class TopologySubscriber(object):
+    """Subscribes to the topology events of an analyzer or an agent, the
+    keyword arguments being the subscription parameters of the server, ex:
+    hidden=True, host="node1", filter="Type:ovsbridge" """
+
+    def __init__(self, handler, endpoint="localhost:8082", scheme="ws",
+                 rest_client=None, **params):
+        self.handler = handler
+        self.rest_client = rest_client
+
+        path = "/ws"
+        if params:
+            for k, v in params.items():
+                if v is True:
+                    params[k] = "true"
+            path += "?" + urlencode(params)
+        self.url = "%s://%s%s" % (scheme, endpoint, path)
+
+    def dispatch(self, msg):
+        if msg.get("Namespace") != "Graph":
+            return
+
+        t, obj = msg.get("Type"), msg.get("Obj")
+        if t == "Batch":
+            for m in obj:
+                self.dispatch(m)
+        elif t == "SyncChunk":
+            for node in obj.get("Nodes") or []:
+                self.handler.on_node_added(node)
+            for edge in obj.get("Edges") or []:
+                self.handler.on_edge_added(edge)
+            for id in obj.get("DeletedEdges") or []:
+                self.handler.on_edge_deleted({"ID": id})
+            for id in obj.get("DeletedNodes") or []:
+                self.handler.on_node_deleted({"ID": id})
+            if obj.get("Last"):
+                self.handler.on_synchronized()
+        elif t in ("NodeDeleted", "SubGraphDeleted"):
+            self.handler.on_node_deleted(obj)
+        elif t in ("NodeAdded", "NodeUpdated", "EdgeAdded",
+                   "EdgeUpdated", "EdgeDeleted"):
+            method = "on_" + t[:4].lower() + "_" + t[4:].lower()
+            getattr(self.handler, method)(obj)
+
+    def run(self):
+        """Connects to the server and dispatches the events until the
+        connection is closed"""
+        header = []
+        if self.rest_client is not None:
+            cookies = "; ".join("%s=%s" % (c.name, c.value)
+                                for c in self.rest_client.cookies)
+            if cookies:
+                header.append("Cookie: " + cookies)
+
+        ws = websocket.create_connection(self.url, header=header)
+        try:
+            ws.send(json.dumps({"Namespace": "Graph", "Type": "SyncRequest",
+                                "Obj": {}}))
+            while True:
+                data = ws.recv()
+                if not data:
+                    break
+                self.dispatch(json.loads(data))
+        except websocket.WebSocketConnectionClosedException:
+            pass
+        finally:
+            ws.close()
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package erspan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// protocols of the GRE payloads
+const (
+	protoERSPANII  = 0x88be
+	protoERSPANIII = 0x22eb
+	protoTEB       = 0x6558
+)
+
+const (
+	greHeaderSize        = 4
+	erspanIIHeaderSize   = 8
+	erspanIIIHeaderSize  = 12
+	erspanIIISubheadSize = 8
+)
+
+var ErrTruncatedPacket = errors.New("Truncated GRE packet")
+
+// Mirror is a frame mirrored by a switch, with the session it was mirrored
+// by: the ERSPAN session ID, or the GRE key for the Ethernet over GRE
+// mirrors. HasSession is false for the mirrors without either.
+type Mirror struct {
+	Session    uint32
+	HasSession bool
+	Frame      []byte
+}
+
+// Decapsulate returns the Ethernet frame of a GRE packet, without its IP
+// header, carrying an ERSPAN type I, II or III or a transparent Ethernet
+// bridging payload
+func Decapsulate(data []byte) (*Mirror, error) {
+	if len(data) < greHeaderSize {
+		return nil, ErrTruncatedPacket
+	}
+
+	flags := data[0]
+	if version := data[1] & 0x07; version != 0 {
+		return nil, fmt.Errorf("Unsupported GRE version %d", version)
+	}
+	proto := binary.BigEndian.Uint16(data[2:4])
+
+	offset := greHeaderSize
+	if flags&0xc0 != 0 {
+		// checksum or routing present
+		offset += 4
+	}
+
+	mirror := &Mirror{}
+	if flags&0x20 != 0 {
+		if len(data) < offset+4 {
+			return nil, ErrTruncatedPacket
+		}
+		mirror.Session, mirror.HasSession = binary.BigEndian.Uint32(data[offset:offset+4]), true
+		offset += 4
+	}
+
+	seqPresent := flags&0x10 != 0
+	if seqPresent {
+		offset += 4
+	}
+
+	switch proto {
+	case protoERSPANII:
+		// type I mirrors have no sequence number nor ERSPAN header
+		if seqPresent {
+			if len(data) < offset+erspanIIHeaderSize {
+				return nil, ErrTruncatedPacket
+			}
+			mirror.Session, mirror.HasSession = erspanSession(data[offset:]), true
+			offset += erspanIIHeaderSize
+		}
+	case protoERSPANIII:
+		if len(data) < offset+erspanIIIHeaderSize {
+			return nil, ErrTruncatedPacket
+		}
+		mirror.Session, mirror.HasSession = erspanSession(data[offset:]), true
+		if data[offset+erspanIIIHeaderSize-1]&0x01 != 0 {
+			// platform specific subheader present
+			offset += erspanIIISubheadSize
+		}
+		offset += erspanIIIHeaderSize
+	case protoTEB:
+	default:
+		return nil, fmt.Errorf("Unsupported GRE protocol 0x%04x", proto)
+	}
+
+	if len(data) <= offset {
+		return nil, ErrTruncatedPacket
+	}
+	mirror.Frame = data[offset:]
+
+	return mirror, nil
+}
+
+// erspanSession returns the 10 bits session ID of an ERSPAN header
+func erspanSession(header []byte) uint32 {
+	return uint32(binary.BigEndian.Uint16(header[2:4]) & 0x03ff)
+}
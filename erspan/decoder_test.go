@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package erspan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var frame = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0x08, 0x00}
+
+func TestDecapsulateERSPANII(t *testing.T) {
+	data := make([]byte, greHeaderSize+4+erspanIIHeaderSize)
+	data[0] = 0x10
+	binary.BigEndian.PutUint16(data[2:4], protoERSPANII)
+	binary.BigEndian.PutUint16(data[8:10], 0x1000)
+	binary.BigEndian.PutUint16(data[10:12], 0x2000|42)
+	data = append(data, frame...)
+
+	mirror, err := Decapsulate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mirror.HasSession || mirror.Session != 42 {
+		t.Errorf("Wrong session %d", mirror.Session)
+	}
+	if !bytes.Equal(mirror.Frame, frame) {
+		t.Errorf("Wrong frame %v", mirror.Frame)
+	}
+}
+
+func TestDecapsulateERSPANIII(t *testing.T) {
+	data := make([]byte, greHeaderSize+4+erspanIIIHeaderSize+erspanIIISubheadSize)
+	data[0] = 0x10
+	binary.BigEndian.PutUint16(data[2:4], protoERSPANIII)
+	binary.BigEndian.PutUint16(data[10:12], 7)
+	data[greHeaderSize+4+erspanIIIHeaderSize-1] = 0x01
+	data = append(data, frame...)
+
+	mirror, err := Decapsulate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mirror.HasSession || mirror.Session != 7 {
+		t.Errorf("Wrong session %d", mirror.Session)
+	}
+	if !bytes.Equal(mirror.Frame, frame) {
+		t.Errorf("Wrong frame %v", mirror.Frame)
+	}
+}
+
+func TestDecapsulateGRE(t *testing.T) {
+	data := make([]byte, greHeaderSize+4)
+	data[0] = 0x20
+	binary.BigEndian.PutUint16(data[2:4], protoTEB)
+	binary.BigEndian.PutUint32(data[4:8], 1234)
+	data = append(data, frame...)
+
+	mirror, err := Decapsulate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mirror.HasSession || mirror.Session != 1234 || !bytes.Equal(mirror.Frame, frame) {
+		t.Errorf("Wrong mirror %+v", mirror)
+	}
+
+	if _, err := Decapsulate(data[:6]); err != ErrTruncatedPacket {
+		t.Errorf("Truncated packet expected, got %v", err)
+	}
+
+	binary.BigEndian.PutUint16(data[2:4], 0x0800)
+	if _, err := Decapsulate(data); err == nil {
+		t.Error("Unsupported protocol expected")
+	}
+}
@@ -22,11 +22,16 @@
 
 package flow
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 type TableAllocator struct {
 	sync.RWMutex
 	tables map[*Table]bool
+	// Budget is shared by the tables allocated, nil for no limit
+	Budget *Budget
 }
 
 func (a *TableAllocator) Flush() {
@@ -56,6 +61,26 @@ func (a *TableAllocator) aggregateReplies(query *TableQuery, replies []*TableRep
 				Flows: flows,
 			},
 		}
+	case *FlowPacketsQuery:
+		var packets []*RawPacket
+		for _, reply := range replies {
+			if reply.Status == 200 {
+				packets = append(packets, reply.Obj.(*FlowPacketsReply).Packets...)
+			}
+		}
+		sort.Sort(sortByTimestamp(packets))
+
+		status := 200
+		if len(packets) == 0 {
+			status = 404
+		}
+
+		return &TableReply{
+			Status: status,
+			Obj: &FlowPacketsReply{
+				Packets: packets,
+			},
+		}
 	}
 
 	return &TableReply{
@@ -81,6 +106,7 @@ func (a *TableAllocator) Alloc() *Table {
 	defer a.Unlock()
 
 	t := NewTable()
+	t.budget = a.Budget
 	a.tables[t] = true
 
 	return t
@@ -90,6 +116,8 @@ func (a *TableAllocator) Release(t *Table) {
 	a.Lock()
 	delete(a.tables, t)
 	a.Unlock()
+
+	t.releasePackets()
 }
 
 func NewTableAllocator() *TableAllocator {
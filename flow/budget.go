@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/redhat-cip/skydive/config"
+)
+
+// ErrBudgetExceeded is returned when the resources a capture needs are over
+// the budget of the agent
+var ErrBudgetExceeded = errors.New("Resource budget of the agent exceeded")
+
+// Budget bounds the resources shared by the flow tables of an agent: the
+// number of flows and the memory of the packet buffers. The packets over
+// the budget are shed, not creating new flows nor keeping their payloads,
+// so that the agent never destabilizes the host it monitors. A nil budget
+// or a zero limit is unlimited.
+type Budget struct {
+	maxFlows int64
+	maxBytes int64
+	flows    int64
+	bytes    int64
+}
+
+func (b *Budget) reserveFlow() bool {
+	if b == nil {
+		return true
+	}
+	if atomic.AddInt64(&b.flows, 1) > b.maxFlows && b.maxFlows > 0 {
+		atomic.AddInt64(&b.flows, -1)
+		return false
+	}
+	return true
+}
+
+func (b *Budget) releaseFlows(n int64) {
+	if b != nil {
+		atomic.AddInt64(&b.flows, -n)
+	}
+}
+
+// ReserveBytes reserves n bytes of packet buffers, returning false if
+// they are over the budget
+func (b *Budget) ReserveBytes(n int64) bool {
+	if b == nil {
+		return true
+	}
+	if atomic.AddInt64(&b.bytes, n) > b.maxBytes && b.maxBytes > 0 {
+		atomic.AddInt64(&b.bytes, -n)
+		return false
+	}
+	return true
+}
+
+// ReleaseBytes releases n bytes reserved by ReserveBytes
+func (b *Budget) ReleaseBytes(n int64) {
+	if b != nil {
+		atomic.AddInt64(&b.bytes, -n)
+	}
+}
+
+// Flows returns the number of flows of the tables
+func (b *Budget) Flows() int64 {
+	return atomic.LoadInt64(&b.flows)
+}
+
+// Bytes returns the memory used by the packet buffers
+func (b *Budget) Bytes() int64 {
+	return atomic.LoadInt64(&b.bytes)
+}
+
+func NewBudget(maxFlows int64, maxBytes int64) *Budget {
+	return &Budget{
+		maxFlows: maxFlows,
+		maxBytes: maxBytes,
+	}
+}
+
+func BudgetFromConfig() *Budget {
+	cfg := config.GetConfig()
+	return NewBudget(int64(cfg.GetInt("agent.limits.max_flows")), int64(cfg.GetInt("agent.limits.max_packet_memory")))
+}
@@ -43,7 +43,9 @@ type TableClient struct {
 }
 
 func (f *TableClient) OnMessage(c *shttp.WSClient, m shttp.WSMessage) {
-	if m.Namespace != Namespace {
+	// replies are only accepted from the agents, authenticated as admins
+	if c.ClientType() != shttp.AgentClient || !c.Role().Allows(shttp.AdminRole) {
+		logging.GetLogger().Warningf("Flow table reply refused to the client %s", c.RemoteAddr())
 		return
 	}
 
@@ -59,20 +61,19 @@ func (f *TableClient) OnMessage(c *shttp.WSClient, m shttp.WSMessage) {
 	ch <- m.Obj
 }
 
-func (f *TableClient) LookupFlowsByProbeNode(node *graph.Node) ([]*Flow, error) {
+// query sends a table query to the agent of the host and waits for its reply
+func (f *TableClient) query(host string, t string, obj interface{}, reply *TableReply) error {
 	u, _ := uuid.NewV4()
 
 	tq := TableQuery{
-		Obj: FlowSearchQuery{
-			ProbeNodeUUID: string(node.ID),
-		},
+		Obj: obj,
 	}
 	b, _ := json.Marshal(tq)
 	raw := json.RawMessage(b)
 
 	msg := shttp.WSMessage{
 		Namespace: Namespace,
-		Type:      "FlowSearchQuery",
+		Type:      t,
 		UUID:      u.String(),
 		Obj:       &raw,
 	}
@@ -90,40 +91,69 @@ func (f *TableClient) LookupFlowsByProbeNode(node *graph.Node) ([]*Flow, error)
 		f.replyChanMutex.Unlock()
 	}()
 
-	ok := f.WSServer.SendWSMessageTo(msg, node.Host())
+	ok := f.WSServer.SendWSMessageTo(msg, host)
 	if !ok {
-		return nil, fmt.Errorf("Unable to send message to agent: %s", node.Host())
+		return fmt.Errorf("Unable to send message to agent: %s", host)
 	}
 
 	select {
 	case raw := <-ch:
-		reply := TableReply{
-			Obj: &FlowSearchReply{},
-		}
-
-		err := json.Unmarshal([]byte(*raw), &reply)
+		err := json.Unmarshal([]byte(*raw), reply)
 		if err != nil {
-			return nil, fmt.Errorf("Error returned while reading TableReply from: %s", node.Host())
+			return fmt.Errorf("Error returned while reading TableReply from: %s", host)
 		}
 
 		if reply.Status != 200 {
-			return nil, fmt.Errorf("Error %d TableReply from: %s", reply.Status, node.Host())
+			return fmt.Errorf("Error %d TableReply from: %s", reply.Status, host)
 		}
 
-		fr := reply.Obj.(*FlowSearchReply)
-
-		return fr.Flows, nil
+		return nil
 	case <-time.After(time.Second * 10):
-		return nil, fmt.Errorf("Timeout while reading TableReply from: %s", node.Host())
+		return fmt.Errorf("Timeout while reading TableReply from: %s", host)
 	}
 }
 
+func (f *TableClient) LookupFlowsByProbeNode(node *graph.Node) ([]*Flow, error) {
+	reply := TableReply{
+		Obj: &FlowSearchReply{},
+	}
+
+	query := FlowSearchQuery{
+		ProbeNodeUUID: string(node.ID),
+	}
+
+	if err := f.query(node.Host(), "FlowSearchQuery", query, &reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Obj.(*FlowSearchReply).Flows, nil
+}
+
+// LookupFlowPackets returns the packets of the flow, all the packets
+// captured on the probe node if flowUUID is empty
+func (f *TableClient) LookupFlowPackets(node *graph.Node, flowUUID string) ([]*RawPacket, error) {
+	reply := TableReply{
+		Obj: &FlowPacketsReply{},
+	}
+
+	query := FlowPacketsQuery{
+		FlowUUID:      flowUUID,
+		ProbeNodeUUID: string(node.ID),
+	}
+
+	if err := f.query(node.Host(), "FlowPacketsQuery", query, &reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Obj.(*FlowPacketsReply).Packets, nil
+}
+
 func NewTableClient(w *shttp.WSServer) *TableClient {
 	tc := &TableClient{
 		WSServer:  w,
 		replyChan: make(map[string]chan *json.RawMessage),
 	}
-	w.AddEventHandler(tc)
+	w.AddEventHandler(tc, Namespace)
 
 	return tc
 }
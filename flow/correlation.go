@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"sort"
+)
+
+// FlowObservation is a flow as captured by one of the probes
+type FlowObservation struct {
+	UUID          string
+	TrackingID    string
+	ProbeNodeUUID string
+	IfSrcNodeUUID string `json:",omitempty"`
+	IfDstNodeUUID string `json:",omitempty"`
+	LayersPath    string
+	NATOriginal   string `json:",omitempty"`
+	NATTranslated string `json:",omitempty"`
+	Start         int64
+	Last          int64
+	// packets and bytes of the outermost layer, in both directions
+	PacketsAB uint64
+	PacketsBA uint64
+	BytesAB   uint64
+	BytesBA   uint64
+}
+
+// LogicalFlow gathers the observations of a flow captured at several
+// points, the ones of an L3 flow being correlated by their L3TrackingID on
+// both sides of the routers, or their NATTrackingID on both sides of a NAT,
+// the L2 ones by their TrackingID. The
+// observations are ordered by their start, the path of the flow.
+type LogicalFlow struct {
+	ID           string
+	L3TrackingID string `json:",omitempty"`
+	Observations []*FlowObservation
+}
+
+type observationSorter []*FlowObservation
+
+func (s observationSorter) Len() int {
+	return len(s)
+}
+
+func (s observationSorter) Less(i, j int) bool {
+	if s[i].Start != s[j].Start {
+		return s[i].Start < s[j].Start
+	}
+	return s[i].ProbeNodeUUID < s[j].ProbeNodeUUID
+}
+
+func (s observationSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+type logicalFlowSorter []*LogicalFlow
+
+func (s logicalFlowSorter) Len() int {
+	return len(s)
+}
+
+func (s logicalFlowSorter) Less(i, j int) bool {
+	return s[i].Observations[0].Start < s[j].Observations[0].Start ||
+		(s[i].Observations[0].Start == s[j].Observations[0].Start && s[i].ID < s[j].ID)
+}
+
+func (s logicalFlowSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func newFlowObservation(f *Flow) *FlowObservation {
+	o := &FlowObservation{
+		UUID:          f.UUID,
+		TrackingID:    f.TrackingID,
+		ProbeNodeUUID: f.ProbeNodeUUID,
+		IfSrcNodeUUID: f.IfSrcNodeUUID,
+		IfDstNodeUUID: f.IfDstNodeUUID,
+		LayersPath:    f.LayersPath,
+		NATOriginal:   f.NATOriginal,
+		NATTranslated: f.NATTranslated,
+	}
+
+	if fs := f.GetStatistics(); fs != nil {
+		o.Start, o.Last = fs.Start, fs.Last
+		if eps := fs.GetEndpoints(); len(eps) > 0 {
+			if ab := eps[0].GetAB(); ab != nil {
+				o.PacketsAB, o.BytesAB = ab.Packets, ab.Bytes
+			}
+			if ba := eps[0].GetBA(); ba != nil {
+				o.PacketsBA, o.BytesBA = ba.Packets, ba.Bytes
+			}
+		}
+	}
+
+	return o
+}
+
+// correlationKey returns the key of the logical flow of a flow, the flows
+// observed on both sides of a NAT sharing their NATTrackingID
+func correlationKey(f *Flow) string {
+	if f.NATTrackingID != "" {
+		return f.NATTrackingID
+	}
+	if f.L3TrackingID != "" {
+		return f.L3TrackingID
+	}
+	return f.TrackingID
+}
+
+// CorrelateFlows gathers the flows captured at several points into logical
+// flows
+func CorrelateFlows(flows []*Flow) []*LogicalFlow {
+	logicals := make(map[string]*LogicalFlow)
+	for _, f := range flows {
+		key := correlationKey(f)
+
+		lf, ok := logicals[key]
+		if !ok {
+			lf = &LogicalFlow{ID: key, L3TrackingID: f.L3TrackingID}
+			logicals[key] = lf
+		}
+		lf.Observations = append(lf.Observations, newFlowObservation(f))
+	}
+
+	result := make([]*LogicalFlow, 0, len(logicals))
+	for _, lf := range logicals {
+		sort.Sort(observationSorter(lf.Observations))
+		result = append(result, lf)
+	}
+	sort.Sort(logicalFlowSorter(result))
+
+	return result
+}
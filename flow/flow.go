@@ -23,13 +23,16 @@
 package flow
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -85,7 +88,6 @@ func Var8bin(v []byte) []byte {
 }
 
 func (eps *FlowEndpointsStatistics) hash(ab interface{}, ba interface{}) {
-	var vab, vba uint64
 	var binab, binba []byte
 
 	hasher := sha1.New()
@@ -93,36 +95,27 @@ func (eps *FlowEndpointsStatistics) hash(ab interface{}, ba interface{}) {
 	case net.HardwareAddr:
 		binab = ab.(net.HardwareAddr)
 		binba = ba.(net.HardwareAddr)
-		vab = binary.BigEndian.Uint64(Var8bin(binab))
-		vba = binary.BigEndian.Uint64(Var8bin(binba))
 	case net.IP:
 		binab = ab.(net.IP)
 		binba = ba.(net.IP)
-		vab = binary.BigEndian.Uint64(Var8bin(binab))
-		vba = binary.BigEndian.Uint64(Var8bin(binba))
 	case layers.TCPPort:
 		binab = make([]byte, 2)
 		binba = make([]byte, 2)
 		binary.BigEndian.PutUint16(binab, uint16(ab.(layers.TCPPort)))
 		binary.BigEndian.PutUint16(binba, uint16(ba.(layers.TCPPort)))
-		vab = uint64(ab.(layers.TCPPort))
-		vba = uint64(ba.(layers.TCPPort))
 	case layers.UDPPort:
 		binab = make([]byte, 2)
 		binba = make([]byte, 2)
 		binary.BigEndian.PutUint16(binab, uint16(ab.(layers.UDPPort)))
 		binary.BigEndian.PutUint16(binba, uint16(ba.(layers.UDPPort)))
-		vab = uint64(ab.(layers.UDPPort))
-		vba = uint64(ba.(layers.UDPPort))
 	case layers.SCTPPort:
 		binab = make([]byte, 2)
 		binba = make([]byte, 2)
 		binary.BigEndian.PutUint16(binab, uint16(ab.(layers.SCTPPort)))
 		binary.BigEndian.PutUint16(binba, uint16(ba.(layers.SCTPPort)))
-		vab = uint64(ab.(layers.SCTPPort))
-		vba = uint64(ba.(layers.SCTPPort))
 	}
-	if vab < vba {
+	// the bytes are compared as the IPv6 addresses don't fit in an uint64
+	if bytes.Compare(binab, binba) < 0 {
 		hasher.Write(binab)
 		hasher.Write(binba)
 	} else {
@@ -146,16 +139,67 @@ func LayerFlow(l gopacket.Layer) gopacket.Flow {
 
 type FlowKey struct {
 	net, transport uint64
+	// hash of the VLAN tags and MPLS labels, 0 if none
+	encap uint64
+}
+
+// transport part of the key of the NDP flows, so that they are not mixed
+// with the other ICMPv6 messages of the same addresses
+const ndpFlowKey = 0x6e6470
+
+// isNDP returns whether the packet is an ICMPv6 neighbor discovery message,
+// a router or neighbor solicitation or advertisement, or a redirect
+func isNDP(p *gopacket.Packet) bool {
+	icmp, ok := (*p).Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+	if !ok {
+		return false
+	}
+	t := icmp.TypeCode.Type()
+	return t >= layers.ICMPv6TypeRouterSolicitation && t <= layers.ICMPv6TypeRedirect
+}
+
+// encapsulation returns the VLAN identifiers of the 802.1Q and 802.1ad
+// tags and the MPLS labels of a packet, outermost first, the ones of the
+// encapsulated frames being ignored
+func encapsulation(packet *gopacket.Packet) (vlans []uint32, labels []uint32) {
+	for _, layer := range (*packet).Layers() {
+		switch l := layer.(type) {
+		case *layers.Dot1Q:
+			vlans = append(vlans, uint32(l.VLANIdentifier))
+		case *layers.MPLS:
+			labels = append(labels, l.Label)
+		case gopacket.NetworkLayer:
+			return
+		}
+	}
+	return
 }
 
 func NewFlowKeyFromGoPacket(p *gopacket.Packet) *FlowKey {
-	return &FlowKey{
+	key := &FlowKey{
 		net:       LayerFlow((*p).NetworkLayer()).FastHash(),
 		transport: LayerFlow((*p).TransportLayer()).FastHash(),
 	}
+	if isNDP(p) {
+		key.transport = ndpFlowKey
+	}
+
+	// the packets of distinct VLANs or label switched paths are distinct
+	// flows, even with the same addresses
+	if vlans, labels := encapsulation(p); len(vlans) > 0 || len(labels) > 0 {
+		hasher := fnv.New64a()
+		binary.Write(hasher, binary.BigEndian, vlans)
+		hasher.Write([]byte{0})
+		binary.Write(hasher, binary.BigEndian, labels)
+		key.encap = hasher.Sum64()
+	}
+	return key
 }
 
 func (key FlowKey) String() string {
+	if key.encap != 0 {
+		return fmt.Sprintf("%x-%x-%x", key.net, key.transport, key.encap)
+	}
 	return fmt.Sprintf("%x-%x", key.net, key.transport)
 }
 
@@ -180,30 +224,53 @@ func (flow *Flow) fillFromGoPacket(packet *gopacket.Packet) error {
 	fs.Update(packet)
 
 	if newFlow {
-		hasher := sha1.New()
-		path := ""
-		for i, layer := range (*packet).Layers() {
-			if i > 0 {
-				path += "/"
-			}
-			path += layer.LayerType().String()
-		}
-		flow.LayersPath = path
-		hasher.Write([]byte(flow.LayersPath))
+		flow.LayersPath = layersPath(packet)
+		flow.VLANs, flow.MPLSLabels = encapsulation(packet)
+		flow.UpdateUUIDs()
+	}
+	return nil
+}
 
-		/* Generate an flow UUID */
-		for _, ep := range fs.GetEndpoints() {
-			hasher.Write(ep.Hash)
-		}
-		flow.TrackingID = hex.EncodeToString(hasher.Sum(nil))
+// UpdateUUIDs generates the TrackingID and the UUID of the flow from its
+// layers path, its VLANs and MPLS labels, its endpoints, its start time and
+// its probe node, and the L3TrackingID from its network and transport
+// endpoints
+func (flow *Flow) UpdateUUIDs() {
+	var start int64
+	if fs := flow.GetStatistics(); fs != nil {
+		start = fs.Start
+	}
+
+	hasher := sha1.New()
+	hasher.Write([]byte(flow.LayersPath))
+	if len(flow.VLANs) > 0 || len(flow.MPLSLabels) > 0 {
+		binary.Write(hasher, binary.BigEndian, flow.VLANs)
+		binary.Write(hasher, binary.BigEndian, flow.MPLSLabels)
+	}
 
-		bfStart := make([]byte, 8)
-		binary.BigEndian.PutUint64(bfStart, uint64(fs.Start))
-		hasher.Write(bfStart)
-		hasher.Write([]byte(flow.ProbeNodeUUID))
-		flow.UUID = hex.EncodeToString(hasher.Sum(nil))
+	/* Generate an flow UUID */
+	for _, ep := range flow.GetStatistics().GetEndpoints() {
+		hasher.Write(ep.Hash)
 	}
-	return nil
+	flow.TrackingID = hex.EncodeToString(hasher.Sum(nil))
+
+	l3Hasher := sha1.New()
+	l3 := false
+	for _, ep := range flow.GetStatistics().GetEndpoints() {
+		if ep.Type != FlowEndpointType_ETHERNET {
+			l3Hasher.Write(ep.Hash)
+			l3 = true
+		}
+	}
+	if l3 {
+		flow.L3TrackingID = hex.EncodeToString(l3Hasher.Sum(nil))
+	}
+
+	bfStart := make([]byte, 8)
+	binary.BigEndian.PutUint64(bfStart, uint64(start))
+	hasher.Write(bfStart)
+	hasher.Write([]byte(flow.ProbeNodeUUID))
+	flow.UUID = hex.EncodeToString(hasher.Sum(nil))
 }
 
 func FromData(data []byte) (*Flow, error) {
@@ -226,9 +293,28 @@ func (flow *Flow) GetData() ([]byte, error) {
 	return data, nil
 }
 
+// FlowFromGoPacket updates the flow of a packet, and the one of the frame it
+// encapsulates if it's a tunnel packet, the inner flow having the outer one
+// as parent.
 func FlowFromGoPacket(ft *Table, packet *gopacket.Packet, setter FlowProbeNodeSetter) *Flow {
 	key := NewFlowKeyFromGoPacket(packet)
-	flow, _ := ft.GetOrCreateFlow(key.String())
+	flow := flowFromGoPacket(ft, key.String(), packet, setter)
+	if flow == nil {
+		return nil
+	}
+
+	if t := decodeTunnel(packet); t != nil {
+		innerFlowFromTunnel(ft, flow, t, setter)
+	}
+
+	return flow
+}
+
+func flowFromGoPacket(ft *Table, key string, packet *gopacket.Packet, setter FlowProbeNodeSetter) *Flow {
+	flow, _ := ft.GetOrCreateFlow(key)
+	if flow == nil {
+		return nil
+	}
 	if setter != nil {
 		setter.SetProbeNode(flow)
 	}
@@ -238,6 +324,13 @@ func FlowFromGoPacket(ft *Table, packet *gopacket.Packet, setter FlowProbeNodeSe
 		logging.GetLogger().Error(err.Error())
 		return nil
 	}
+	ft.trackTCP(flow, packet)
+
+	if ft.packets != nil {
+		if !ft.packets.add(flow, packet) {
+			atomic.AddInt64(&ft.shedPayloads, 1)
+		}
+	}
 
 	return flow
 }
@@ -61,6 +61,7 @@ const (
 	FlowEndpointType_TCPPORT  FlowEndpointType = 2
 	FlowEndpointType_UDPPORT  FlowEndpointType = 3
 	FlowEndpointType_SCTPPORT FlowEndpointType = 4
+	FlowEndpointType_IPV6     FlowEndpointType = 5
 )
 
 var FlowEndpointType_name = map[int32]string{
@@ -69,6 +70,7 @@ var FlowEndpointType_name = map[int32]string{
 	2: "TCPPORT",
 	3: "UDPPORT",
 	4: "SCTPPORT",
+	5: "IPV6",
 }
 var FlowEndpointType_value = map[string]int32{
 	"ETHERNET": 0,
@@ -76,6 +78,7 @@ var FlowEndpointType_value = map[string]int32{
 	"TCPPORT":  2,
 	"UDPPORT":  3,
 	"SCTPPORT": 4,
+	"IPV6":     5,
 }
 
 func (x FlowEndpointType) String() string {
@@ -87,6 +90,8 @@ type FlowEndpointStatistics struct {
 	Value   string `protobuf:"bytes,2,opt,name=Value" json:"Value,omitempty"`
 	Packets uint64 `protobuf:"varint,5,opt,name=Packets" json:"Packets,omitempty"`
 	Bytes   uint64 `protobuf:"varint,6,opt,name=Bytes" json:"Bytes,omitempty"`
+	// Retransmitted TCP segments
+	Retransmissions uint64 `protobuf:"varint,7,opt,name=Retransmissions" json:"Retransmissions,omitempty"`
 }
 
 func (m *FlowEndpointStatistics) Reset()                    { *m = FlowEndpointStatistics{} }
@@ -124,6 +129,8 @@ type FlowStatistics struct {
 	Start     int64                      `protobuf:"varint,1,opt,name=Start" json:"Start,omitempty"`
 	Last      int64                      `protobuf:"varint,2,opt,name=Last" json:"Last,omitempty"`
 	Endpoints []*FlowEndpointsStatistics `protobuf:"bytes,3,rep,name=Endpoints" json:"Endpoints,omitempty"`
+	// Round trip time of the TCP handshake, in nanoseconds
+	RTT int64 `protobuf:"varint,4,opt,name=RTT" json:"RTT,omitempty"`
 }
 
 func (m *FlowStatistics) Reset()                    { *m = FlowStatistics{} }
@@ -155,10 +162,36 @@ type Flow struct {
 	// the bytes of the first packet of his session.
 	// flow.TrackingID can be used as a Tag.
 	TrackingID string `protobuf:"bytes,5,opt,name=TrackingID" json:"TrackingID,omitempty"`
+	// Tracking IDentifier of the network and transport endpoints only, the
+	// same for the observations of a flow on both sides of a router
+	L3TrackingID string `protobuf:"bytes,27,opt,name=L3TrackingID" json:"L3TrackingID,omitempty"`
 	// Topology info
 	ProbeNodeUUID string `protobuf:"bytes,11,opt,name=ProbeNodeUUID" json:"ProbeNodeUUID,omitempty"`
 	IfSrcNodeUUID string `protobuf:"bytes,14,opt,name=IfSrcNodeUUID" json:"IfSrcNodeUUID,omitempty"`
 	IfDstNodeUUID string `protobuf:"bytes,19,opt,name=IfDstNodeUUID" json:"IfDstNodeUUID,omitempty"`
+	// Flow of the tunnel encapsulating this flow
+	ParentUUID string `protobuf:"bytes,20,opt,name=ParentUUID" json:"ParentUUID,omitempty"`
+	// Ancestors of the probe node, resolved at capture time
+	BridgeNodeUUID string `protobuf:"bytes,21,opt,name=BridgeNodeUUID" json:"BridgeNodeUUID,omitempty"`
+	HostNodeUUID   string `protobuf:"bytes,22,opt,name=HostNodeUUID" json:"HostNodeUUID,omitempty"`
+	// Local process owning the socket of the flow
+	PID         int64  `protobuf:"varint,23,opt,name=PID" json:"PID,omitempty"`
+	ProcessName string `protobuf:"bytes,24,opt,name=ProcessName" json:"ProcessName,omitempty"`
+	ContainerID string `protobuf:"bytes,25,opt,name=ContainerID" json:"ContainerID,omitempty"`
+	// Why the flow expired, idle, active or closed, empty while alive
+	ExpiredReason string `protobuf:"bytes,26,opt,name=ExpiredReason" json:"ExpiredReason,omitempty"`
+	// Tuples of a NATed connection, as tracked by conntrack, before and after
+	// the translation, the NATTrackingID being the same for the flows
+	// observed on both sides of the NAT
+	NATOriginal   string `protobuf:"bytes,28,opt,name=NATOriginal" json:"NATOriginal,omitempty"`
+	NATTranslated string `protobuf:"bytes,29,opt,name=NATTranslated" json:"NATTranslated,omitempty"`
+	NATTrackingID string `protobuf:"bytes,30,opt,name=NATTrackingID" json:"NATTrackingID,omitempty"`
+	// VLAN identifiers of the 802.1Q and 802.1ad tags and MPLS labels of
+	// the packets, outermost first
+	VLANs      []uint32 `protobuf:"varint,31,rep,packed,name=VLANs" json:"VLANs,omitempty"`
+	MPLSLabels []uint32 `protobuf:"varint,32,rep,packed,name=MPLSLabels" json:"MPLSLabels,omitempty"`
+	// Capture the flow was captured by
+	CaptureID string `protobuf:"bytes,33,opt,name=CaptureID" json:"CaptureID,omitempty"`
 }
 
 func (m *Flow) Reset()                    { *m = Flow{} }
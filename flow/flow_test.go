@@ -24,10 +24,15 @@ package flow
 
 import (
 	"encoding/json"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	v "github.com/gima/govalid/v1"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 )
 
 func TestFlowJSON(t *testing.T) {
@@ -106,3 +111,373 @@ func TestFlowJSON(t *testing.T) {
 		t.Fatal("Unmarshalled flow not equal to the original")
 	}
 }
+
+func forgeTunnelPacket(t *testing.T, dstPort layers.UDPPort, header []byte) *gopacket.Packet {
+	inner := forgeTestPacket(t, 1, false, ETH, IPv4, TCP)
+
+	outer := []gopacket.SerializableLayer{
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{
+			Version:  4,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{192, 168, 0, 2},
+			Protocol: layers.IPProtocolUDP,
+		},
+		&layers.UDP{SrcPort: 54321, DstPort: dstPort},
+		gopacket.Payload(append(header, (*inner).Data()...)),
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true}, outer...); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	return &packet
+}
+
+func TestFlowTunnels(t *testing.T) {
+	tunnels := map[string]*gopacket.Packet{
+		"VXLAN":  forgeTunnelPacket(t, 4789, []byte{0x08, 0, 0, 0, 0, 0, 42, 0}),
+		"Geneve": forgeTunnelPacket(t, 6081, []byte{0, 0, 0x65, 0x58, 0, 0, 42, 0}),
+	}
+
+	for name, packet := range tunnels {
+		ft := NewTable()
+		outer := FlowFromGoPacket(ft, packet, &probeNodeSetter{"probe"})
+		if outer == nil {
+			t.Fatalf("No %s outer flow", name)
+		}
+
+		if expected := "Ethernet/IPv4/UDP/" + name; outer.LayersPath != expected {
+			t.Errorf("Wrong %s outer layers path, expected %s, got %s", name, expected, outer.LayersPath)
+		}
+
+		flows := ft.GetFlows()
+		if len(flows) != 2 {
+			t.Fatalf("Outer and inner %s flows expected, got %d flows", name, len(flows))
+		}
+
+		for _, f := range flows {
+			if f == outer {
+				continue
+			}
+			if f.ParentUUID != outer.UUID {
+				t.Errorf("The inner %s flow should have the outer one as parent", name)
+			}
+			if f.LayersPath != "Ethernet/IPv4/TCP/Payload" {
+				t.Errorf("Wrong %s inner layers path: %s", name, f.LayersPath)
+			}
+			if ip := f.GetStatistics().GetEndpointsType(FlowEndpointType_IPV4); ip == nil || !strings.HasPrefix(ip.AB.Value, "127.0.0.") {
+				t.Errorf("Wrong %s inner IPv4 endpoints: %v", name, ip)
+			}
+		}
+	}
+}
+
+func forgeTCPPacket(t *testing.T, ts time.Time, swap bool, tcp *layers.TCP, payload []byte) *gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, SrcIP: net.IP{192, 168, 0, 1}, DstIP: net.IP{192, 168, 0, 2}, Protocol: layers.IPProtocolTCP}
+	tcp.SrcPort, tcp.DstPort = 54321, 80
+	if swap {
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		}, ip, tcp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	return &packet
+}
+
+func TestFlowTCPHandshake(t *testing.T) {
+	ft := NewTable()
+	start := time.Now()
+
+	packets := []*gopacket.Packet{
+		forgeTCPPacket(t, start, false, &layers.TCP{SYN: true, Seq: 100}, nil),
+		forgeTCPPacket(t, start.Add(10*time.Millisecond), true, &layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101}, nil),
+		forgeTCPPacket(t, start.Add(15*time.Millisecond), false, &layers.TCP{ACK: true, Seq: 101, Ack: 501}, nil),
+		forgeTCPPacket(t, start.Add(20*time.Millisecond), false, &layers.TCP{ACK: true, PSH: true, Seq: 101, Ack: 501}, []byte("hello")),
+		forgeTCPPacket(t, start.Add(30*time.Millisecond), false, &layers.TCP{ACK: true, PSH: true, Seq: 101, Ack: 501}, []byte("hello")),
+		forgeTCPPacket(t, start.Add(40*time.Millisecond), false, &layers.TCP{ACK: true, PSH: true, Seq: 106, Ack: 501}, []byte("world")),
+	}
+
+	var f *Flow
+	for _, packet := range packets {
+		f = FlowFromGoPacket(ft, packet, nil)
+	}
+
+	if rtt := time.Duration(f.GetStatistics().RTT); rtt != 15*time.Millisecond {
+		t.Errorf("Wrong handshake RTT, expected 15ms, got %s", rtt)
+	}
+
+	ep := f.GetStatistics().GetEndpointsType(FlowEndpointType_TCPPORT)
+	if ep.AB.Retransmissions != 1 || ep.BA.Retransmissions != 0 {
+		t.Errorf("Wrong retransmissions, expected 1/0, got %d/%d", ep.AB.Retransmissions, ep.BA.Retransmissions)
+	}
+}
+
+func TestCorrelateFlows(t *testing.T) {
+	packet := forgeTCPPacket(t, time.Now(), false, &layers.TCP{SYN: true, Seq: 100}, nil)
+
+	// the same packet routed, with other MAC addresses
+	data := append([]byte{}, (*packet).Data()...)
+	copy(data[0:12], []byte{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x03, 0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x04})
+	routed := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+
+	var flows []*Flow
+	for _, node := range []string{"veth", "bridge"} {
+		flows = append(flows, FlowFromGoPacket(NewTable(), packet, &probeNodeSetter{uuid: node}))
+	}
+	flows = append(flows, FlowFromGoPacket(NewTable(), &routed, &probeNodeSetter{uuid: "nic"}))
+
+	if flows[0].TrackingID != flows[1].TrackingID || flows[0].TrackingID == flows[2].TrackingID {
+		t.Fatalf("Only the flows of the same L2 segment should have the same TrackingID: %v", flows)
+	}
+
+	// another flow, from another IP address
+	data = append([]byte{}, (*packet).Data()...)
+	data[29] = 3
+	other := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	flows = append(flows, FlowFromGoPacket(NewTable(), &other, &probeNodeSetter{uuid: "veth"}))
+
+	logicals := CorrelateFlows(flows)
+	if len(logicals) != 2 {
+		t.Fatalf("Expected 2 logical flows, got: %v", logicals)
+	}
+
+	lf := logicals[0]
+	if lf.ID != flows[0].L3TrackingID {
+		lf = logicals[1]
+	}
+	if lf.ID != flows[0].L3TrackingID || len(lf.Observations) != 3 {
+		t.Fatalf("Expected the 3 observations of the flow, got: %v", lf.Observations)
+	}
+
+	nodes := map[string]bool{}
+	for _, o := range lf.Observations {
+		nodes[o.ProbeNodeUUID] = true
+		if o.PacketsAB != 1 || o.BytesAB == 0 {
+			t.Errorf("Wrong statistics of the observation: %v", o)
+		}
+	}
+	if !nodes["veth"] || !nodes["bridge"] || !nodes["nic"] {
+		t.Errorf("Missing capture points: %v", nodes)
+	}
+}
+
+func TestCorrelateNATFlows(t *testing.T) {
+	packet := forgeTCPPacket(t, time.Now(), false, &layers.TCP{SYN: true, Seq: 100}, nil)
+
+	// the same packet after a SNAT, from another IP address
+	data := append([]byte{}, (*packet).Data()...)
+	data[29] = 3
+	snated := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+
+	before := FlowFromGoPacket(NewTable(), packet, &probeNodeSetter{uuid: "veth"})
+	after := FlowFromGoPacket(NewTable(), &snated, &probeNodeSetter{uuid: "nic"})
+	if before.L3TrackingID == after.L3TrackingID {
+		t.Fatalf("The flows should have different L3TrackingID: %v, %v", before, after)
+	}
+
+	if logicals := CorrelateFlows([]*Flow{before, after}); len(logicals) != 2 {
+		t.Fatalf("Expected 2 logical flows without NAT info, got: %v", logicals)
+	}
+
+	before.NATTrackingID, after.NATTrackingID = "nat", "nat"
+	logicals := CorrelateFlows([]*Flow{before, after})
+	if len(logicals) != 1 || logicals[0].ID != "nat" || len(logicals[0].Observations) != 2 {
+		t.Fatalf("Expected the 2 observations of the NATed flow, got: %v", logicals)
+	}
+}
+
+func forgeIPv6Packet(t *testing.T, swap bool, next layers.IPProtocol, payload []byte) *gopacket.Packet {
+	ip := &layers.IPv6{Version: 6, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"), NextHeader: next, HopLimit: 64}
+	if swap {
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x02},
+			EthernetType: layers.EthernetTypeIPv6,
+		}, ip, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	return &packet
+}
+
+func TestFlowIPv6(t *testing.T) {
+	tcp := func(srcPort, dstPort layers.TCPPort) []byte {
+		buffer := gopacket.NewSerializeBuffer()
+		if err := (&layers.TCP{SrcPort: srcPort, DstPort: dstPort, SYN: true, DataOffset: 5}).SerializeTo(buffer, gopacket.SerializeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+
+	// a destination options extension header, padded to 8 bytes, before
+	// the TCP header
+	dstOpts := []byte{byte(layers.IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0}
+
+	ft := NewTable()
+	f := FlowFromGoPacket(ft, forgeIPv6Packet(t, false, layers.IPProtocolIPv6Destination, append(dstOpts, tcp(54321, 80)...)), nil)
+	if f2 := FlowFromGoPacket(ft, forgeIPv6Packet(t, true, layers.IPProtocolIPv6Destination, append(dstOpts, tcp(80, 54321)...)), nil); f2 != f {
+		t.Fatalf("Both directions should be the same flow: %v, %v", f, f2)
+	}
+
+	if f.LayersPath != "Ethernet/IPv6/IPv6Destination/TCP" {
+		t.Errorf("Wrong layers path: %s", f.LayersPath)
+	}
+
+	ip := f.GetStatistics().GetNetworkEndpoints()
+	if ip == nil || ip.Type != FlowEndpointType_IPV6 || ip.AB.Value != "2001:db8::1" || ip.BA.Value != "2001:db8::2" {
+		t.Fatalf("Wrong IPv6 endpoints: %v", ip)
+	}
+	if ip.AB.Packets != 1 || ip.BA.Packets != 1 || ip.AB.Bytes != 40+8+20 {
+		t.Errorf("Wrong IPv6 statistics: %v", ip)
+	}
+
+	if port := f.GetStatistics().GetEndpointsType(FlowEndpointType_TCPPORT); port == nil || port.AB.Value != "54321" || port.BA.Value != "80" {
+		t.Errorf("Wrong TCP endpoints: %v", port)
+	}
+
+	// the neighbor discovery messages are a flow of their own
+	icmp := func(typ uint8) []byte {
+		return []byte{typ, 0, 0, 0, 0, 0, 0, 0}
+	}
+	echo := FlowFromGoPacket(ft, forgeIPv6Packet(t, false, layers.IPProtocolICMPv6, icmp(layers.ICMPv6TypeEchoRequest)), nil)
+	ndp := FlowFromGoPacket(ft, forgeIPv6Packet(t, false, layers.IPProtocolICMPv6, icmp(layers.ICMPv6TypeNeighborSolicitation)), nil)
+	if echo == ndp || !strings.HasSuffix(ndp.LayersPath, "/NDP") || strings.HasSuffix(echo.LayersPath, "/NDP") {
+		t.Errorf("Wrong NDP flows: %v, %v", echo, ndp)
+	}
+}
+
+func forgeEncapsulatedPacket(t *testing.T, ethType layers.EthernetType, encap ...gopacket.SerializableLayer) *gopacket.Packet {
+	l := []gopacket.SerializableLayer{&layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x02},
+		EthernetType: ethType,
+	}}
+	l = append(l, encap...)
+	l = append(l,
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, SrcIP: net.IP{192, 168, 0, 1}, DstIP: net.IP{192, 168, 0, 2}, Protocol: layers.IPProtocolTCP},
+		&layers.TCP{SrcPort: 54321, DstPort: 80, SYN: true},
+	)
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true}, l...); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	return &packet
+}
+
+func TestFlowVLANsAndMPLS(t *testing.T) {
+	qinq := func(outer, inner uint16) *gopacket.Packet {
+		return forgeEncapsulatedPacket(t, layers.EthernetTypeQinQ,
+			&layers.Dot1Q{VLANIdentifier: outer, Type: layers.EthernetTypeDot1Q},
+			&layers.Dot1Q{VLANIdentifier: inner, Type: layers.EthernetTypeIPv4})
+	}
+
+	ft := NewTable()
+	f1 := FlowFromGoPacket(ft, qinq(100, 10), nil)
+	f2 := FlowFromGoPacket(ft, qinq(100, 20), nil)
+	if f1 == f2 || f1.TrackingID == f2.TrackingID {
+		t.Fatalf("The flows of distinct VLANs should be distinct: %v, %v", f1, f2)
+	}
+	if !reflect.DeepEqual(f1.VLANs, []uint32{100, 10}) {
+		t.Errorf("Wrong VLANs: %v", f1.VLANs)
+	}
+	if ip := f1.GetStatistics().GetNetworkEndpoints(); ip == nil || ip.AB.Value != "192.168.0.1" {
+		t.Errorf("Wrong IPv4 endpoints of a QinQ flow: %v", ip)
+	}
+
+	mpls := forgeEncapsulatedPacket(t, layers.EthernetTypeMPLSUnicast,
+		&layers.MPLS{Label: 16, TTL: 64},
+		&layers.MPLS{Label: 17, TTL: 64, StackBottom: true})
+	f3 := FlowFromGoPacket(ft, mpls, nil)
+	if !reflect.DeepEqual(f3.MPLSLabels, []uint32{16, 17}) || len(f3.VLANs) != 0 {
+		t.Errorf("Wrong MPLS labels: %v", f3.MPLSLabels)
+	}
+	if port := f3.GetStatistics().GetEndpointsType(FlowEndpointType_TCPPORT); port == nil || port.BA.Value != "80" {
+		t.Errorf("Wrong TCP endpoints of an MPLS flow: %v", port)
+	}
+
+	// the untagged flow is distinct from the tagged ones
+	if f4 := FlowFromGoPacket(ft, forgeTCPPacket(t, time.Now(), false, &layers.TCP{SYN: true}, nil), nil); f4 == f1 || f4 == f3 || len(f4.VLANs) != 0 {
+		t.Errorf("The untagged flow should be distinct: %v", f4)
+	}
+}
+
+func rollupFlow(uuid string, sport string, packets uint64, bytes uint64) *Flow {
+	return &Flow{
+		UUID: uuid,
+		Statistics: &FlowStatistics{
+			Endpoints: []*FlowEndpointsStatistics{
+				{
+					Type: FlowEndpointType_IPV4,
+					AB:   &FlowEndpointStatistics{Value: "10.0.0.2", Packets: packets, Bytes: bytes},
+					BA:   &FlowEndpointStatistics{Value: "10.0.0.1"},
+				},
+				{
+					Type: FlowEndpointType_TCPPORT,
+					AB:   &FlowEndpointStatistics{Value: sport},
+					BA:   &FlowEndpointStatistics{Value: "80"},
+				},
+			},
+		},
+	}
+}
+
+func TestRollups(t *testing.T) {
+	r := NewRollups(time.Minute, []string{RollupApplication, RollupHostPair, RollupVLAN}, nil)
+
+	now := time.Unix(600, 0)
+	r.update(nil, now)
+
+	r.update([]*Flow{rollupFlow("a", "40000", 2, 200), rollupFlow("b", "40001", 1, 100)}, now.Add(10*time.Second))
+	// only the traffic since the previous update of a flow is summed
+	r.update([]*Flow{rollupFlow("a", "40000", 5, 500)}, now.Add(20*time.Second))
+
+	rollups := r.update([]*Flow{rollupFlow("a", "40000", 6, 600)}, now.Add(time.Minute))
+	if len(rollups) != 2 {
+		t.Fatalf("Expected the application and host pair rollups, got %d", len(rollups))
+	}
+
+	expected := []Rollup{
+		{Timestamp: 600, Dimension: RollupApplication, Key: "TCP/80", Flows: 2, Packets: 6, Bytes: 600},
+		{Timestamp: 600, Dimension: RollupHostPair, Key: "10.0.0.1-10.0.0.2", Flows: 2, Packets: 6, Bytes: 600},
+	}
+	for i, e := range expected {
+		e.Host = r.host
+		if *rollups[i] != e {
+			t.Errorf("Expected %+v, got %+v", e, *rollups[i])
+		}
+	}
+
+	rollups = r.update(nil, now.Add(2*time.Minute))
+	if len(rollups) != 2 || rollups[0].Flows != 1 || rollups[0].Bytes != 100 {
+		t.Errorf("Wrong rollups of the next period: %+v", rollups)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package mappings
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// minimum delay between two reads of the conntrack table, the flows not
+// found being looked up again only after it
+const conntrackRefreshPeriod = 5 * time.Second
+
+type natEntry struct {
+	original   string
+	translated string
+	trackingID string
+}
+
+// ConntrackFlowEnhancer maps the flows to the NATed connections of the
+// kernel conntrack table, so that the flows observed before and after the
+// translation, SNAT or DNAT, can be linked. Only the connections of the
+// namespace of the agent are known.
+type ConntrackFlowEnhancer struct {
+	sync.Mutex
+	procPath    string
+	entries     map[string]*natEntry
+	lastRefresh time.Time
+}
+
+func tupleKey(proto, src, sport, dst, dport string) string {
+	return proto + ":" + src + ":" + sport + "->" + dst + ":" + dport
+}
+
+// parseConntrackLine returns the original and the reply tuples of a line
+// of /proc/net/nf_conntrack, a TCP or UDP connection, the addresses being
+// formatted as the ones of the flows
+func parseConntrackLine(line string) (proto string, tuples [2]map[string]string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || (fields[0] != "ipv4" && fields[0] != "ipv6") {
+		return
+	}
+
+	proto = fields[2]
+	if proto != "tcp" && proto != "udp" {
+		return
+	}
+
+	i := 0
+	for _, field := range fields[3:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "src" && tuples[i] != nil {
+			if i++; i == len(tuples) {
+				break
+			}
+		}
+		if tuples[i] == nil {
+			tuples[i] = make(map[string]string)
+		}
+		if kv[0] == "src" || kv[0] == "dst" {
+			// the IPv6 addresses are printed uncompressed
+			if ip := net.ParseIP(kv[1]); ip != nil {
+				kv[1] = ip.String()
+			}
+		}
+		tuples[i][kv[0]] = kv[1]
+	}
+
+	ok = tuples[0] != nil && tuples[1] != nil
+	return
+}
+
+// readConntrack returns the NATed connections of the conntrack table, by
+// their tuples before and after the translation, in both directions
+func readConntrack(path string) (map[string]*natEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]*natEntry)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		proto, tuples, ok := parseConntrackLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		orig, reply := tuples[0], tuples[1]
+
+		// the tuple of the original direction after the translation is
+		// the inverse of the reply one
+		original := tupleKey(proto, orig["src"], orig["sport"], orig["dst"], orig["dport"])
+		translated := tupleKey(proto, reply["dst"], reply["dport"], reply["src"], reply["sport"])
+		if original == translated {
+			continue
+		}
+
+		hasher := sha1.New()
+		hasher.Write([]byte(original))
+
+		e := &natEntry{
+			original:   original,
+			translated: translated,
+			trackingID: hex.EncodeToString(hasher.Sum(nil)),
+		}
+
+		entries[original] = e
+		entries[translated] = e
+		entries[tupleKey(proto, orig["dst"], orig["dport"], orig["src"], orig["sport"])] = e
+		entries[tupleKey(proto, reply["src"], reply["sport"], reply["dst"], reply["dport"])] = e
+	}
+
+	return entries, scanner.Err()
+}
+
+func (cfe *ConntrackFlowEnhancer) refresh() {
+	entries, err := readConntrack(filepath.Join(cfe.procPath, "net", "nf_conntrack"))
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read the conntrack table: %s", err.Error())
+	} else {
+		cfe.entries = entries
+	}
+	cfe.lastRefresh = time.Now()
+}
+
+func (cfe *ConntrackFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.NATTrackingID != "" {
+		return
+	}
+
+	ip := f.GetStatistics().GetNetworkEndpoints()
+	if ip == nil || ip.AB == nil || ip.BA == nil {
+		return
+	}
+
+	proto := "tcp"
+	port := f.GetStatistics().GetEndpointsType(flow.FlowEndpointType_TCPPORT)
+	if port == nil {
+		proto = "udp"
+		port = f.GetStatistics().GetEndpointsType(flow.FlowEndpointType_UDPPORT)
+	}
+	if port == nil || port.AB == nil || port.BA == nil {
+		return
+	}
+
+	key := tupleKey(proto, ip.AB.Value, port.AB.Value, ip.BA.Value, port.BA.Value)
+
+	cfe.Lock()
+	defer cfe.Unlock()
+
+	e, ok := cfe.entries[key]
+	if !ok && time.Since(cfe.lastRefresh) > conntrackRefreshPeriod {
+		cfe.refresh()
+		e, ok = cfe.entries[key]
+	}
+
+	if ok {
+		f.NATOriginal = e.original
+		f.NATTranslated = e.translated
+		f.NATTrackingID = e.trackingID
+	}
+}
+
+func NewConntrackFlowEnhancer() *ConntrackFlowEnhancer {
+	return &ConntrackFlowEnhancer{
+		procPath: "/proc",
+		entries:  make(map[string]*natEntry),
+	}
+}
@@ -50,7 +50,58 @@ func (gfe *GraphFlowEnhancer) getNodeUUID(mac string) string {
 	return ""
 }
 
+func isBridge(n *graph.Node) bool {
+	t, _ := n.Metadata()["Type"].(string)
+	return t == "ovsbridge" || t == "bridge"
+}
+
+// lookupBridge returns the bridge of a node, either the node itself, the
+// linux bridge it is enslaved to or the OVS bridge of its port
+func (gfe *GraphFlowEnhancer) lookupBridge(n *graph.Node) *graph.Node {
+	if isBridge(n) {
+		return n
+	}
+
+	for _, parent := range gfe.Graph.LookupParentNodes(n, graph.Metadata{}) {
+		if isBridge(parent) {
+			return parent
+		}
+		if parent.Metadata()["Type"] == "ovsport" {
+			if bridges := gfe.Graph.LookupParentNodes(parent, graph.Metadata{"Type": "ovsbridge"}); len(bridges) > 0 {
+				return bridges[0]
+			}
+		}
+	}
+	return nil
+}
+
+// resolveAncestors binds the flow to the host and the bridge of its probe
+// node, so that the flows can still be filtered by their topology context
+// once the probe node is renamed or removed
+func (gfe *GraphFlowEnhancer) resolveAncestors(f *flow.Flow) {
+	gfe.Graph.Lock()
+	defer gfe.Graph.Unlock()
+
+	n := gfe.Graph.GetNode(graph.Identifier(f.ProbeNodeUUID))
+	if n == nil {
+		return
+	}
+
+	if bridge := gfe.lookupBridge(n); bridge != nil {
+		f.BridgeNodeUUID = string(bridge.ID)
+	}
+
+	path := gfe.Graph.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})
+	if len(path) > 0 {
+		f.HostNodeUUID = string(path[len(path)-1].ID)
+	}
+}
+
 func (gfe *GraphFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.ProbeNodeUUID != "" && f.HostNodeUUID == "" {
+		gfe.resolveAncestors(f)
+	}
+
 	var eth *flow.FlowEndpointsStatistics
 	if f.IfSrcNodeUUID == "" || f.IfDstNodeUUID == "" {
 		eth = f.GetStatistics().GetEndpointsType(flow.FlowEndpointType_ETHERNET)
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package mappings
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// minimum delay between two scans of /proc, the sockets of the flows not
+// found being looked up again only after it
+const socketsRefreshPeriod = 5 * time.Second
+
+var (
+	nativeEndian binary.ByteOrder = binary.LittleEndian
+	containerID                   = regexp.MustCompile("[0-9a-f]{64}")
+)
+
+func init() {
+	i := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+type processInfo struct {
+	pid       int64
+	name      string
+	container string
+}
+
+// ProcessFlowEnhancer maps the flows to the local processes owning their
+// sockets, found in /proc. Only the sockets of the namespace of the agent
+// are known.
+type ProcessFlowEnhancer struct {
+	sync.Mutex
+	procPath    string
+	sockets     map[string]uint64
+	processes   map[uint64]*processInfo
+	lastRefresh time.Time
+}
+
+func socketKey(proto, local, remote string) string {
+	return proto + "/" + local + "/" + remote
+}
+
+// parseSocketAddr decodes an address of /proc/net/{tcp,udp}{,6}, the IP
+// being printed as words in the host byte order
+func parseSocketAddr(s string) (string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || (len(parts[0]) != 8 && len(parts[0]) != 32) {
+		return "", fmt.Errorf("Invalid socket address: %s", s)
+	}
+
+	b, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", err
+	}
+
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		nativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+
+	return fmt.Sprintf("%s:%d", ip.String(), port), nil
+}
+
+// readSockets returns the inodes of the sockets listed in a /proc/net file
+// by local and remote addresses
+func readSockets(path string, proto string, sockets map[string]uint64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		local, err := parseSocketAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		remote, err := parseSocketAddr(fields[2])
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+
+		sockets[socketKey(proto, local, remote)] = inode
+	}
+
+	return scanner.Err()
+}
+
+func (pfe *ProcessFlowEnhancer) readProcess(pid int64) *processInfo {
+	dir := filepath.Join(pfe.procPath, strconv.FormatInt(pid, 10))
+
+	p := &processInfo{pid: pid}
+	if comm, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		p.name = strings.TrimSpace(string(comm))
+	}
+	if cgroup, err := ioutil.ReadFile(filepath.Join(dir, "cgroup")); err == nil {
+		p.container = containerID.FindString(string(cgroup))
+	}
+	return p
+}
+
+// readProcesses returns the processes owning the sockets, by inode
+func (pfe *ProcessFlowEnhancer) readProcesses() map[uint64]*processInfo {
+	processes := make(map[uint64]*processInfo)
+
+	fds, _ := filepath.Glob(filepath.Join(pfe.procPath, "[0-9]*", "fd", "*"))
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+
+		inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		pid, err := strconv.ParseInt(filepath.Base(filepath.Dir(filepath.Dir(fd))), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := processes[inode]; !ok {
+			processes[inode] = pfe.readProcess(pid)
+		}
+	}
+
+	return processes
+}
+
+func (pfe *ProcessFlowEnhancer) refresh() {
+	sockets := make(map[string]uint64)
+	for _, proto := range []string{"tcp", "udp"} {
+		for _, file := range []string{proto, proto + "6"} {
+			if err := readSockets(filepath.Join(pfe.procPath, "net", file), proto, sockets); err != nil {
+				logging.GetLogger().Errorf("Unable to read the %s sockets: %s", file, err.Error())
+			}
+		}
+	}
+
+	pfe.sockets = sockets
+	pfe.processes = pfe.readProcesses()
+	pfe.lastRefresh = time.Now()
+}
+
+// lookupSocket returns the inode of the socket of an endpoint, connected
+// to the peer or bound to any address
+func (pfe *ProcessFlowEnhancer) lookupSocket(proto string, ip, port, peerIP, peerPort string) uint64 {
+	wildcard := "0.0.0.0"
+	if strings.Contains(ip, ":") {
+		wildcard = "::"
+	}
+
+	local, remote := ip+":"+port, peerIP+":"+peerPort
+	for _, key := range []string{
+		socketKey(proto, local, remote),
+		socketKey(proto, local, wildcard+":0"),
+		socketKey(proto, wildcard+":"+port, wildcard+":0"),
+	} {
+		if inode, ok := pfe.sockets[key]; ok {
+			return inode
+		}
+	}
+	return 0
+}
+
+// lookupProcess returns the process owning the socket of one of the
+// endpoints of the flow
+func (pfe *ProcessFlowEnhancer) lookupProcess(proto string, ip, port *flow.FlowEndpointsStatistics) *processInfo {
+	endpoints := [][4]string{
+		{ip.AB.Value, port.AB.Value, ip.BA.Value, port.BA.Value},
+		{ip.BA.Value, port.BA.Value, ip.AB.Value, port.AB.Value},
+	}
+
+	for _, e := range endpoints {
+		if inode := pfe.lookupSocket(proto, e[0], e[1], e[2], e[3]); inode != 0 {
+			if p, ok := pfe.processes[inode]; ok {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func (pfe *ProcessFlowEnhancer) Enhance(f *flow.Flow) {
+	if f.PID != 0 {
+		return
+	}
+
+	ip := f.GetStatistics().GetNetworkEndpoints()
+	if ip == nil || ip.AB == nil || ip.BA == nil {
+		return
+	}
+
+	proto := "tcp"
+	port := f.GetStatistics().GetEndpointsType(flow.FlowEndpointType_TCPPORT)
+	if port == nil {
+		proto = "udp"
+		port = f.GetStatistics().GetEndpointsType(flow.FlowEndpointType_UDPPORT)
+	}
+	if port == nil || port.AB == nil || port.BA == nil {
+		return
+	}
+
+	pfe.Lock()
+	defer pfe.Unlock()
+
+	p := pfe.lookupProcess(proto, ip, port)
+	if p == nil && time.Since(pfe.lastRefresh) > socketsRefreshPeriod {
+		pfe.refresh()
+		p = pfe.lookupProcess(proto, ip, port)
+	}
+
+	if p != nil {
+		f.PID = p.pid
+		f.ProcessName = p.name
+		f.ContainerID = p.container
+	}
+}
+
+func NewProcessFlowEnhancer() *ProcessFlowEnhancer {
+	return &ProcessFlowEnhancer{
+		procPath:  "/proc",
+		sockets:   make(map[string]uint64),
+		processes: make(map[uint64]*processInfo),
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	flowsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "skydive_flow_table_flows",
+		Help: "Number of flows of the flow tables.",
+	})
+
+	// PacketsCaptured counts the packets, or the samples, received by the
+	// probes, labelled by the type of probe
+	PacketsCaptured = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "skydive_probe_packets_captured_total",
+		Help: "Number of packets captured by the probes.",
+	}, []string{"probe"})
+
+	// PacketsDropped counts the packets dropped by the kernel or the
+	// sampling agents before being processed by the probes
+	PacketsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "skydive_probe_packets_dropped_total",
+		Help: "Number of packets dropped before being captured by the probes.",
+	}, []string{"probe"})
+
+	// PacketsSkipped counts the packets captured but not processed because
+	// of the sampling or the limits of the captures
+	PacketsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "skydive_probe_packets_skipped_total",
+		Help: "Number of packets skipped by the sampling or the limits of the captures.",
+	}, []string{"probe", "reason"})
+
+	flowsExpired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "skydive_flow_table_expired_total",
+		Help: "Number of flows expired by the flow tables.",
+	}, []string{"reason"})
+
+	tcpRTT = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "skydive_flow_tcp_rtt_seconds",
+		Help:    "Round trip time of the TCP handshakes captured.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	tcpRetransmissions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "skydive_flow_tcp_retransmissions_total",
+		Help: "Number of TCP segments retransmitted.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(flowsGauge)
+	prometheus.MustRegister(flowsExpired)
+	prometheus.MustRegister(PacketsCaptured)
+	prometheus.MustRegister(PacketsDropped)
+	prometheus.MustRegister(PacketsSkipped)
+	prometheus.MustRegister(tcpRTT)
+	prometheus.MustRegister(tcpRetransmissions)
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"sort"
+
+	"github.com/google/gopacket"
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// RawPacket is a captured packet, Timestamp in nanoseconds
+type RawPacket struct {
+	Timestamp int64
+	Data      []byte
+}
+
+type FlowPacketsQuery struct {
+	FlowUUID      string
+	ProbeNodeUUID string
+}
+
+type FlowPacketsReply struct {
+	Packets []*RawPacket
+}
+
+type sortByTimestamp []*RawPacket
+
+func (s sortByTimestamp) Len() int {
+	return len(s)
+}
+
+func (s sortByTimestamp) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s sortByTimestamp) Less(i, j int) bool {
+	return s[i].Timestamp < s[j].Timestamp
+}
+
+type ringPacket struct {
+	flowUUID      string
+	probeNodeUUID string
+	packet        *RawPacket
+}
+
+// packetRing keeps the last packets of a table, the oldest ones being
+// overwritten, their payloads being accounted in the budget
+type packetRing struct {
+	packets []*ringPacket
+	next    int
+	budget  *Budget
+}
+
+// add keeps a packet, returning false if its payload is over the budget
+func (r *packetRing) add(flow *Flow, packet *gopacket.Packet) bool {
+	data := (*packet).Data()
+	if len(r.packets) == cap(r.packets) && r.packets[r.next] != nil {
+		r.budget.ReleaseBytes(int64(len(r.packets[r.next].packet.Data)))
+		r.packets[r.next] = nil
+	}
+	if !r.budget.ReserveBytes(int64(len(data))) {
+		return false
+	}
+
+	p := &ringPacket{
+		flowUUID:      flow.UUID,
+		probeNodeUUID: flow.ProbeNodeUUID,
+		packet: &RawPacket{
+			Timestamp: (*packet).Metadata().Timestamp.UnixNano(),
+			Data:      append([]byte(nil), data...),
+		},
+	}
+
+	if len(r.packets) < cap(r.packets) {
+		r.packets = append(r.packets, p)
+	} else {
+		r.packets[r.next] = p
+	}
+	r.next = (r.next + 1) % cap(r.packets)
+	return true
+}
+
+// release gives back the memory of the packets to the budget
+func (r *packetRing) release() {
+	for _, p := range r.packets {
+		if p != nil {
+			r.budget.ReleaseBytes(int64(len(p.packet.Data)))
+		}
+	}
+	r.packets, r.next = r.packets[:0], 0
+}
+
+func (r *packetRing) lookup(q *FlowPacketsQuery) []*RawPacket {
+	var packets []*RawPacket
+	for _, p := range r.packets {
+		if p == nil {
+			continue
+		}
+		if q.FlowUUID != "" && p.flowUUID != q.FlowUUID {
+			continue
+		}
+		if q.ProbeNodeUUID != "" && p.probeNodeUUID != q.ProbeNodeUUID {
+			continue
+		}
+		packets = append(packets, p.packet)
+	}
+	sort.Sort(sortByTimestamp(packets))
+
+	return packets
+}
+
+func newPacketRing(size int, budget *Budget) *packetRing {
+	return &packetRing{packets: make([]*ringPacket, 0, size), budget: budget}
+}
+
+// SetRawPacketLimit makes the table keep the payloads of the last limit
+// packets, 0 disabling it. Must be called before the start of the table.
+func (ft *Table) SetRawPacketLimit(limit int) {
+	if limit > 0 {
+		ft.packets = newPacketRing(limit, ft.budget)
+	} else {
+		ft.packets = nil
+	}
+}
+
+// releasePackets gives back the memory of the kept packets to the budget,
+// once the table stopped
+func (ft *Table) releasePackets() {
+	if ft.packets != nil {
+		ft.packets.release()
+	}
+}
+
+func (ft *Table) onFlowPacketsQueryMessage(o interface{}) (*FlowPacketsReply, int) {
+	var fq FlowPacketsQuery
+	err := mapstructure.Decode(o, &fq)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to decode flow packets message %v", o)
+		return nil, 500
+	}
+
+	if ft.packets == nil {
+		return &FlowPacketsReply{}, 404
+	}
+
+	packets := ft.packets.lookup(&fq)
+	if len(packets) == 0 {
+		return &FlowPacketsReply{Packets: packets}, 404
+	}
+
+	return &FlowPacketsReply{Packets: packets}, 200
+}
@@ -0,0 +1,604 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/vishvananda/netns"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
+)
+
+const (
+	packetVersion          = 10
+	packetFanout           = 18
+	packetFanoutHash       = 0
+	packetFanoutFlagDefrag = 0x8000
+	tpacketV3              = 2
+	tpStatusKernel         = 0
+	tpStatusUser           = 1
+	arphrdNone             = 0xfffe
+
+	afpacketFrameSize    = 2048
+	afpacketBlockTimeout = 100 * time.Millisecond
+)
+
+type tpacketReq3 struct {
+	blockSize      uint32
+	blockNr        uint32
+	frameSize      uint32
+	frameNr        uint32
+	retireBlkTov   uint32
+	sizeofPriv     uint32
+	featureReqWord uint32
+}
+
+type tpacketStatsV3 struct {
+	packets      uint32
+	drops        uint32
+	freezeQCount uint32
+}
+
+type packetMreq struct {
+	ifIndex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// fanout group ids, unique in the agent and hopefully among the processes
+var afpacketFanoutID = uint32(os.Getpid())
+
+func setsockopt(fd, level, name int, v unsafe.Pointer, size uintptr) error {
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(v), size, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// afpacketRing is a TPACKET_V3 ring mapped from a socket of the fanout
+// group of a capture, the kernel filling its blocks of packets
+type afpacketRing struct {
+	sock      int
+	ring      []byte
+	blockSize int
+	numBlocks int
+	block     int
+	flowTable *flow.Table
+}
+
+func newAFPacketRing(ifIndex int, blockSize int, numBlocks int, fanoutID uint16, fanout bool, filter []syscall.SockFilter) (*afpacketRing, error) {
+	sock, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPAll)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &afpacketRing{sock: sock, blockSize: blockSize, numBlocks: numBlocks}
+	if err := r.setup(ifIndex, fanoutID, fanout, filter); err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *afpacketRing) setup(ifIndex int, fanoutID uint16, fanout bool, filter []syscall.SockFilter) error {
+	if len(filter) > 0 {
+		if err := syscall.AttachLsf(r.sock, filter); err != nil {
+			return fmt.Errorf("Unable to attach the BPF filter: %s", err.Error())
+		}
+	}
+
+	if err := syscall.SetsockoptInt(r.sock, syscall.SOL_PACKET, packetVersion, tpacketV3); err != nil {
+		return fmt.Errorf("TPACKET_V3 not supported: %s", err.Error())
+	}
+
+	req := tpacketReq3{
+		blockSize:    uint32(r.blockSize),
+		blockNr:      uint32(r.numBlocks),
+		frameSize:    afpacketFrameSize,
+		frameNr:      uint32(r.blockSize / afpacketFrameSize * r.numBlocks),
+		retireBlkTov: uint32(afpacketBlockTimeout / time.Millisecond),
+	}
+	if err := setsockopt(r.sock, syscall.SOL_PACKET, syscall.PACKET_RX_RING, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+		return fmt.Errorf("Unable to set up the ring: %s", err.Error())
+	}
+
+	ring, err := syscall.Mmap(r.sock, 0, r.blockSize*r.numBlocks, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("Unable to map the ring: %s", err.Error())
+	}
+	r.ring = ring
+
+	if err := syscall.Bind(r.sock, &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: ifIndex}); err != nil {
+		return err
+	}
+
+	mreq := packetMreq{ifIndex: int32(ifIndex), mrType: syscall.PACKET_MR_PROMISC}
+	if err := setsockopt(r.sock, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, unsafe.Pointer(&mreq), unsafe.Sizeof(mreq)); err != nil {
+		return fmt.Errorf("Unable to set the promiscuous mode: %s", err.Error())
+	}
+
+	if fanout {
+		// the hash fanout keeps both directions of a flow on the same socket
+		arg := (packetFanoutHash|packetFanoutFlagDefrag)<<16 | int(fanoutID)
+		if err := syscall.SetsockoptInt(r.sock, syscall.SOL_PACKET, packetFanout, arg); err != nil {
+			return fmt.Errorf("Unable to join the fanout group: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// stats returns the packets received and dropped by the kernel since the
+// last call
+func (r *afpacketRing) stats() (tpacketStatsV3, error) {
+	var stats tpacketStatsV3
+	size := uint32(unsafe.Sizeof(stats))
+	if _, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(r.sock), syscall.SOL_PACKET, syscall.PACKET_STATISTICS,
+		uintptr(unsafe.Pointer(&stats)), uintptr(unsafe.Pointer(&size)), 0); errno != 0 {
+		return stats, errno
+	}
+	return stats, nil
+}
+
+// poll waits for the kernel to fill a block, at most timeout
+func (r *afpacketRing) poll(timeout time.Duration) {
+	pfd := pollFd{fd: int32(r.sock), events: 0x1}
+	syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(timeout/time.Millisecond))
+}
+
+// readBlock calls fn for each packet of the next block once filled by
+// the kernel, and gives the block back to it
+func (r *afpacketRing) readBlock(fn func(data []byte, ci gopacket.CaptureInfo)) {
+	block := r.ring[r.block*r.blockSize : (r.block+1)*r.blockSize]
+	status := (*uint32)(unsafe.Pointer(&block[8]))
+	if atomic.LoadUint32(status)&tpStatusUser == 0 {
+		r.poll(afpacketBlockTimeout)
+		if atomic.LoadUint32(status)&tpStatusUser == 0 {
+			return
+		}
+	}
+
+	numPkts := nativeEndian.Uint32(block[12:])
+	offset := nativeEndian.Uint32(block[16:])
+	for i := uint32(0); i < numPkts; i++ {
+		hdr := block[offset:]
+		snaplen := nativeEndian.Uint32(hdr[12:])
+		mac := uint32(nativeEndian.Uint16(hdr[24:]))
+
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(nativeEndian.Uint32(hdr[4:])), int64(nativeEndian.Uint32(hdr[8:]))),
+			CaptureLength: int(snaplen),
+			Length:        int(nativeEndian.Uint32(hdr[16:])),
+		}
+		fn(hdr[mac:mac+snaplen], ci)
+
+		offset += nativeEndian.Uint32(hdr[0:])
+	}
+
+	atomic.StoreUint32(status, tpStatusKernel)
+	r.block = (r.block + 1) % r.numBlocks
+}
+
+func (r *afpacketRing) close() {
+	if r.ring != nil {
+		syscall.Munmap(r.ring)
+	}
+	syscall.Close(r.sock)
+}
+
+// AFPacketProbe captures the packets of an interface with TPACKET_V3 rings
+// mapped from a fanout group of sockets, each one being read by its own
+// goroutine feeding its own flow table
+type AFPacketProbe struct {
+	graph               *graph.Graph
+	probeNodeUUID       string
+	captureID           string
+	decoder             gopacket.Decoder
+	rings               []*afpacketRing
+	analyzerClient      *analyzer.Client
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	exporter            *netflow.Exporter
+	rawPacketLimit      int
+	snaplen             int
+	limiter             *captureLimiter
+	activeTimeout       time.Duration
+	idleTimeout         time.Duration
+	quit                chan bool
+	wg                  sync.WaitGroup
+}
+
+type AFPacketProbesHandler struct {
+	graph               *graph.Graph
+	analyzerClient      *analyzer.Client
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	wg                  sync.WaitGroup
+	probes              map[string]*AFPacketProbe
+	probesLock          sync.RWMutex
+}
+
+func (p *AFPacketProbe) SetProbeNode(flow *flow.Flow) bool {
+	flow.ProbeNodeUUID = p.probeNodeUUID
+	flow.CaptureID = p.captureID
+	return true
+}
+
+func (p *AFPacketProbe) asyncFlowPipeline(flows []*flow.Flow) {
+	if p.flowMappingPipeline != nil {
+		p.flowMappingPipeline.Enhance(flows)
+	}
+	if p.analyzerClient != nil {
+		p.analyzerClient.SendFlows(flows)
+	}
+	if p.exporter != nil {
+		p.exporter.Export(flows)
+	}
+}
+
+// allocFlowTables allocates the flow table of each ring, before their
+// goroutines start so that the probe can be stopped at any time
+func (p *AFPacketProbe) allocFlowTables() {
+	agentUpdate := config.GetAgentUpdate()
+
+	for _, r := range p.rings {
+		r.flowTable = p.flowTableAllocator.Alloc()
+		r.flowTable.SetRawPacketLimit(p.rawPacketLimit)
+
+		registerExpire(r.flowTable, p.asyncFlowPipeline, p.activeTimeout, p.idleTimeout)
+		r.flowTable.RegisterUpdated(p.asyncFlowPipeline, agentUpdate, agentUpdate)
+
+		ring := r
+		handlePacket := func(data []byte, ci gopacket.CaptureInfo) {
+			if !p.limiter.accept(ci.CaptureLength) {
+				return
+			}
+			if p.snaplen > 0 && len(data) > p.snaplen {
+				data = data[:p.snaplen]
+				ci.CaptureLength = p.snaplen
+			}
+
+			packet := gopacket.NewPacket(data, p.decoder, gopacket.Default)
+			*packet.Metadata() = gopacket.PacketMetadata{CaptureInfo: ci}
+
+			flow.FlowFromGoPacket(ring.flowTable, &packet, p)
+			flow.PacketsCaptured.WithLabelValues("afpacket").Inc()
+		}
+		r.flowTable.RegisterDefault(func() {
+			ring.readBlock(handlePacket)
+		})
+	}
+}
+
+func (p *AFPacketProbe) startRing(r *afpacketRing) {
+	defer p.wg.Done()
+	defer r.flowTable.UnregisterAll()
+	defer p.flowTableAllocator.Release(r.flowTable)
+
+	r.flowTable.Start()
+}
+
+// updateStats reports the packets received and dropped by the kernel, and
+// the ones skipped by the limiter, in the metadata of the probe node
+func (p *AFPacketProbe) updateStats() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(captureStatsInterval)
+	defer ticker.Stop()
+
+	var received, dropped int64
+	for {
+		select {
+		case <-ticker.C:
+			for _, r := range p.rings {
+				stats, err := r.stats()
+				if err != nil {
+					continue
+				}
+				received += int64(stats.packets)
+				dropped += int64(stats.drops)
+				flow.PacketsDropped.WithLabelValues("afpacket").Add(float64(stats.drops))
+			}
+
+			tables := make([]*flow.Table, len(p.rings))
+			for i, r := range p.rings {
+				tables[i] = r.flowTable
+			}
+			reportCaptureStats(p.graph, graph.Identifier(p.probeNodeUUID), received, dropped, p.limiter, tables...)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *AFPacketProbe) start() {
+	p.wg.Add(len(p.rings) + 1)
+	for _, r := range p.rings {
+		go p.startRing(r)
+	}
+	go p.updateStats()
+	p.wg.Wait()
+}
+
+func (p *AFPacketProbe) stop() {
+	close(p.quit)
+	for _, r := range p.rings {
+		r.flowTable.Stop()
+	}
+	p.wg.Wait()
+
+	for _, r := range p.rings {
+		r.close()
+	}
+	p.flowTableAllocator.Budget.ReleaseBytes(p.ringsSize())
+	if p.exporter != nil {
+		p.exporter.Close()
+	}
+}
+
+// ringsSize returns the memory mapped by the rings, accounted in the budget
+// of the packet buffers
+func (p *AFPacketProbe) ringsSize() int64 {
+	var size int64
+	for _, r := range p.rings {
+		size += int64(r.blockSize * r.numBlocks)
+	}
+	return size
+}
+
+// compileBPFFilter compiles a BPF filter with libpcap, which needs a handle
+// on the interface for its link type
+func compileBPFFilter(ifName string, snaplen int32, expr string) ([]syscall.SockFilter, error) {
+	handle, err := pcap.OpenLive(ifName, snaplen, false, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	insns, err := handle.CompileBPFFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := make([]syscall.SockFilter, len(insns))
+	for i, insn := range insns {
+		filter[i] = syscall.SockFilter{Code: insn.Code, Jt: insn.Jt, Jf: insn.Jf, K: insn.K}
+	}
+	return filter, nil
+}
+
+// newAFPacketProbe opens the fanout group of the rings capturing the
+// interface, one per CPU unless configured
+func newAFPacketProbe(ifName string, bpfFilter string) (*AFPacketProbe, error) {
+	intf, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter []syscall.SockFilter
+	if bpfFilter != "" {
+		if filter, err = compileBPFFilter(ifName, snaplen, bpfFilter); err != nil {
+			return nil, fmt.Errorf("Error while compiling BPF filter %s on %s: %s", bpfFilter, ifName, err.Error())
+		}
+	}
+
+	fanout := config.GetConfig().GetInt("agent.flow.afpacket.fanout")
+	if fanout <= 0 {
+		fanout = runtime.NumCPU()
+	}
+	blockSize := config.GetConfig().GetInt("agent.flow.afpacket.block_size")
+	numBlocks := config.GetConfig().GetInt("agent.flow.afpacket.num_blocks")
+	if blockSize%os.Getpagesize() != 0 || blockSize%afpacketFrameSize != 0 || numBlocks <= 0 {
+		return nil, fmt.Errorf("Invalid AF_PACKET ring of %d blocks of %d bytes", numBlocks, blockSize)
+	}
+
+	probe := &AFPacketProbe{quit: make(chan bool)}
+
+	fanoutID := uint16(atomic.AddUint32(&afpacketFanoutID, 1))
+	for i := 0; i < fanout; i++ {
+		r, err := newAFPacketRing(intf.Index, blockSize, numBlocks, fanoutID, fanout > 1, filter)
+		if err != nil {
+			for _, r := range probe.rings {
+				r.close()
+			}
+			return nil, fmt.Errorf("Unable to open AF_PACKET socket on %s: %s", ifName, err.Error())
+		}
+		probe.rings = append(probe.rings, r)
+	}
+
+	// the interfaces without link layer, tun ones, capture IP packets
+	probe.decoder = layers.LinkTypeEthernet
+	if sa, err := syscall.Getsockname(probe.rings[0].sock); err == nil {
+		if ll, ok := sa.(*syscall.SockaddrLinklayer); ok && ll.Hatype == arphrdNone {
+			probe.decoder = layers.LinkTypeRaw
+		}
+	}
+
+	return probe, nil
+}
+
+func (p *AFPacketProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	logging.GetLogger().Debugf("Starting AF_PACKET capture on %s", n.Metadata()["Name"])
+
+	if name, ok := n.Metadata()["Name"]; ok && name != "" {
+		ifName := name.(string)
+
+		p.probesLock.RLock()
+		_, ok := p.probes[ifName]
+		p.probesLock.RUnlock()
+		if ok {
+			return fmt.Errorf("An AF_PACKET probe already exists for %s", ifName)
+		}
+
+		nodes := p.graph.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})
+		if len(nodes) == 0 {
+			return fmt.Errorf("Failed to determine probePath for %s", ifName)
+		}
+
+		exporter, err := newCaptureExporter(capture)
+		if err != nil {
+			return fmt.Errorf("Unable to export the flows of %s: %s", ifName, err.Error())
+		}
+		registered := false
+		defer func() {
+			if !registered && exporter != nil {
+				exporter.Close()
+			}
+		}()
+
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		origns, err := netns.Get()
+		if err != nil {
+			return fmt.Errorf("Error while getting current ns: %s", err.Error())
+		}
+		defer origns.Close()
+
+		for _, node := range nodes {
+			if node.Metadata()["Type"] == "netns" {
+				name := node.Metadata()["Name"].(string)
+				path := node.Metadata()["Path"].(string)
+				logging.GetLogger().Debugf("Switching to namespace %s (path: %s)", name, path)
+
+				newns, err := netns.GetFromPath(path)
+				if err != nil {
+					return fmt.Errorf("Error while opening ns %s (path: %s): %s", name, path, err.Error())
+				}
+				defer newns.Close()
+
+				if err := netns.Set(newns); err != nil {
+					return fmt.Errorf("Error while switching from root ns to %s (path: %s): %s", name, path, err.Error())
+				}
+				defer netns.Set(origns)
+			}
+		}
+
+		probe, err := newAFPacketProbe(ifName, capture.BPFFilter)
+		if err != nil {
+			return err
+		}
+		if !p.flowTableAllocator.Budget.ReserveBytes(probe.ringsSize()) {
+			for _, r := range probe.rings {
+				r.close()
+			}
+			return flow.ErrBudgetExceeded
+		}
+		probe.graph = p.graph
+		probe.probeNodeUUID = string(n.ID)
+		probe.captureID = capture.ID()
+		probe.flowMappingPipeline = p.flowMappingPipeline
+		probe.flowTableAllocator = p.flowTableAllocator
+		probe.analyzerClient = p.analyzerClient
+		probe.exporter = exporter
+		probe.rawPacketLimit = capture.RawPacketLimit
+		probe.snaplen = capture.SnapLen
+		probe.limiter = newCaptureLimiter("afpacket", capture)
+		probe.activeTimeout, probe.idleTimeout = captureTimeouts(capture)
+		probe.allocFlowTables()
+		registered = true
+
+		p.probesLock.Lock()
+		p.probes[ifName] = probe
+		p.probesLock.Unlock()
+		p.wg.Add(1)
+
+		go func() {
+			defer p.wg.Done()
+
+			probe.start()
+		}()
+	}
+	return nil
+}
+
+func (p *AFPacketProbesHandler) unregisterProbe(ifName string) error {
+	if probe, ok := p.probes[ifName]; ok {
+		logging.GetLogger().Debugf("Terminating AF_PACKET capture on %s", ifName)
+		probe.stop()
+		delete(p.probes, ifName)
+	}
+
+	return nil
+}
+
+func (p *AFPacketProbesHandler) UnregisterProbe(n *graph.Node) error {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	if name, ok := n.Metadata()["Name"]; ok && name != "" {
+		return p.unregisterProbe(name.(string))
+	}
+	return nil
+}
+
+func (p *AFPacketProbesHandler) Start() {
+}
+
+func (p *AFPacketProbesHandler) Stop() {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	for name := range p.probes {
+		p.unregisterProbe(name)
+	}
+	p.wg.Wait()
+}
+
+func NewAFPacketProbesHandler(tb *probes.TopologyProbeBundle, g *graph.Graph,
+	p *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *AFPacketProbesHandler {
+	return &AFPacketProbesHandler{
+		graph:               g,
+		analyzerClient:      a,
+		flowMappingPipeline: p,
+		flowTableAllocator:  fta,
+		probes:              make(map[string]*AFPacketProbe),
+	}
+}
@@ -0,0 +1,385 @@
+//go:build dpdk
+// +build dpdk
+
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+/*
+#cgo pkg-config: libdpdk
+
+#include <stdlib.h>
+#include <rte_eal.h>
+#include <rte_errno.h>
+#include <rte_lcore.h>
+#include <rte_mbuf.h>
+#include <rte_mempool.h>
+#include <rte_ring.h>
+#include <rte_pdump.h>
+
+static int skydive_dequeue(struct rte_ring *ring, struct rte_mbuf **mbufs, unsigned n) {
+	return rte_ring_dequeue_burst(ring, (void **)mbufs, n, NULL);
+}
+
+static void *skydive_mbuf_data(struct rte_mbuf *m) {
+	return rte_pktmbuf_mtod(m, void *);
+}
+
+static uint32_t skydive_mbuf_data_len(struct rte_mbuf *m) {
+	return rte_pktmbuf_data_len(m);
+}
+
+static uint32_t skydive_mbuf_pkt_len(struct rte_mbuf *m) {
+	return rte_pktmbuf_pkt_len(m);
+}
+
+static void skydive_mbuf_free(struct rte_mbuf *m) {
+	rte_pktmbuf_free(m);
+}
+
+static int skydive_errno() {
+	return rte_errno;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
+)
+
+const (
+	dpdkRingSize    = 16384
+	dpdkPoolSize    = 32767
+	dpdkBurstSize   = 32
+	dpdkIdleSleep   = 10 * time.Millisecond
+	dpdkAllQueues   = 0xffff
+	dpdkFlagRxTx    = 3
+	dpdkPoolBufSize = 2048 + 128
+)
+
+var (
+	dpdkInitOnce sync.Once
+	dpdkInitErr  error
+	dpdkProbeID  uint32
+)
+
+// dpdkInit initializes the DPDK environment abstraction layer as a
+// secondary process of the primary one, Open vSwitch for instance, in a
+// dedicated thread, the EAL pinning its calling thread
+func dpdkInit() error {
+	dpdkInitOnce.Do(func() {
+		args := append([]string{"skydive"}, config.GetConfig().GetStringSlice("agent.flow.dpdk.eal_args")...)
+
+		done := make(chan error)
+		go func() {
+			runtime.LockOSThread()
+
+			argv := make([]*C.char, len(args))
+			for i, arg := range args {
+				argv[i] = C.CString(arg)
+			}
+
+			if C.rte_eal_init(C.int(len(argv)), &argv[0]) < 0 {
+				done <- fmt.Errorf("Unable to initialize the DPDK EAL: %s", C.GoString(C.rte_strerror(C.skydive_errno())))
+				return
+			}
+			done <- nil
+		}()
+		dpdkInitErr = <-done
+	})
+	return dpdkInitErr
+}
+
+// DPDKProbe captures the packets of a DPDK port of the primary process
+// with the packet capture framework, the primary one mirroring them to
+// a ring from which they are sampled
+type DPDKProbe struct {
+	device              string
+	probeNodeUUID       string
+	captureID           string
+	ring                *C.struct_rte_ring
+	pool                *C.struct_rte_mempool
+	sampling            uint32
+	count               uint32
+	analyzerClient      *analyzer.Client
+	flowTable           *flow.Table
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	activeTimeout       time.Duration
+	idleTimeout         time.Duration
+}
+
+type DPDKProbesHandler struct {
+	graph               *graph.Graph
+	analyzerClient      *analyzer.Client
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	wg                  sync.WaitGroup
+	probes              map[string]*DPDKProbe
+	probesLock          sync.RWMutex
+}
+
+func (p *DPDKProbe) SetProbeNode(flow *flow.Flow) bool {
+	flow.ProbeNodeUUID = p.probeNodeUUID
+	flow.CaptureID = p.captureID
+	return true
+}
+
+func (p *DPDKProbe) asyncFlowPipeline(flows []*flow.Flow) {
+	if p.flowMappingPipeline != nil {
+		p.flowMappingPipeline.Enhance(flows)
+	}
+	if p.analyzerClient != nil {
+		p.analyzerClient.SendFlows(flows)
+	}
+}
+
+// dequeue samples a burst of the mirrored packets, all of them being
+// given back to the pool
+func (p *DPDKProbe) dequeue() {
+	var mbufs [dpdkBurstSize]*C.struct_rte_mbuf
+
+	n := int(C.skydive_dequeue(p.ring, &mbufs[0], dpdkBurstSize))
+	if n == 0 {
+		time.Sleep(dpdkIdleSleep)
+		return
+	}
+
+	now := time.Now()
+	for _, m := range mbufs[:n] {
+		p.count++
+		if p.count%p.sampling == 0 {
+			data := C.GoBytes(C.skydive_mbuf_data(m), C.int(C.skydive_mbuf_data_len(m)))
+
+			packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+			*packet.Metadata() = gopacket.PacketMetadata{CaptureInfo: gopacket.CaptureInfo{
+				Timestamp:     now,
+				CaptureLength: len(data),
+				Length:        int(C.skydive_mbuf_pkt_len(m)),
+			}}
+
+			flow.FlowFromGoPacket(p.flowTable, &packet, p)
+			flow.PacketsCaptured.WithLabelValues("dpdk").Inc()
+		}
+		C.skydive_mbuf_free(m)
+	}
+}
+
+func (p *DPDKProbe) start() {
+	p.flowTable = p.flowTableAllocator.Alloc()
+	defer p.flowTable.UnregisterAll()
+	defer p.flowTableAllocator.Release(p.flowTable)
+
+	registerExpire(p.flowTable, p.asyncFlowPipeline, p.activeTimeout, p.idleTimeout)
+
+	agentUpdate := config.GetAgentUpdate()
+	p.flowTable.RegisterUpdated(p.asyncFlowPipeline, agentUpdate, agentUpdate)
+
+	p.flowTable.RegisterDefault(p.dequeue)
+
+	p.flowTable.Start()
+}
+
+func (p *DPDKProbe) stop() {
+	device := C.CString(p.device)
+	defer C.free(unsafe.Pointer(device))
+
+	C.rte_pdump_disable_by_deviceid(device, dpdkAllQueues, dpdkFlagRxTx)
+	p.flowTable.Stop()
+
+	// drain the packets mirrored before the disabling
+	var mbufs [dpdkBurstSize]*C.struct_rte_mbuf
+	for n := C.skydive_dequeue(p.ring, &mbufs[0], dpdkBurstSize); n > 0; n = C.skydive_dequeue(p.ring, &mbufs[0], dpdkBurstSize) {
+		for _, m := range mbufs[:n] {
+			C.skydive_mbuf_free(m)
+		}
+	}
+	C.rte_ring_free(p.ring)
+	C.rte_mempool_free(p.pool)
+}
+
+// newDPDKProbe enables the mirroring of the packets of both directions of
+// all the queues of the device to a ring of the probe
+func newDPDKProbe(device string, sampling uint32) (*DPDKProbe, error) {
+	if err := dpdkInit(); err != nil {
+		return nil, err
+	}
+
+	id := atomic.AddUint32(&dpdkProbeID, 1)
+	ringName := C.CString(fmt.Sprintf("skydive_ring_%d", id))
+	defer C.free(unsafe.Pointer(ringName))
+	poolName := C.CString(fmt.Sprintf("skydive_pool_%d", id))
+	defer C.free(unsafe.Pointer(poolName))
+
+	ring := C.rte_ring_create(ringName, dpdkRingSize, C.int(C.rte_socket_id()), C.RING_F_SC_DEQ)
+	if ring == nil {
+		return nil, fmt.Errorf("Unable to create the DPDK ring: %s", C.GoString(C.rte_strerror(C.skydive_errno())))
+	}
+
+	pool := C.rte_pktmbuf_pool_create(poolName, dpdkPoolSize, 256, 0, dpdkPoolBufSize, C.int(C.rte_socket_id()))
+	if pool == nil {
+		C.rte_ring_free(ring)
+		return nil, fmt.Errorf("Unable to create the DPDK mbuf pool: %s", C.GoString(C.rte_strerror(C.skydive_errno())))
+	}
+
+	cdevice := C.CString(device)
+	defer C.free(unsafe.Pointer(cdevice))
+
+	if C.rte_pdump_enable_by_deviceid(cdevice, dpdkAllQueues, dpdkFlagRxTx, ring, pool, nil) < 0 {
+		C.rte_mempool_free(pool)
+		C.rte_ring_free(ring)
+		return nil, fmt.Errorf("Unable to enable the packet capture of the DPDK device %s: %s", device, C.GoString(C.rte_strerror(C.skydive_errno())))
+	}
+
+	if sampling == 0 {
+		sampling = 1
+	}
+
+	return &DPDKProbe{device: device, ring: ring, pool: pool, sampling: sampling}, nil
+}
+
+// dpdkDevice returns the DPDK device of a port, the devargs of the
+// physical ones, the name of the vhost-user ones
+func dpdkDevice(n *graph.Node) (string, error) {
+	if devargs, ok := n.Metadata()["DPDK.Devargs"].(string); ok && devargs != "" {
+		return devargs, nil
+	}
+	if name, ok := n.Metadata()["Name"].(string); ok && name != "" {
+		return name, nil
+	}
+	return "", errors.New("No DPDK device found")
+}
+
+func (p *DPDKProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	device, err := dpdkDevice(n)
+	if err != nil {
+		return err
+	}
+
+	logging.GetLogger().Debugf("Starting DPDK capture on %s", device)
+
+	p.probesLock.RLock()
+	_, ok := p.probes[device]
+	p.probesLock.RUnlock()
+	if ok {
+		return fmt.Errorf("A DPDK probe already exists for %s", device)
+	}
+
+	if capture.BPFFilter != "" {
+		logging.GetLogger().Warningf("BPF filter %s ignored by the DPDK capture on %s", capture.BPFFilter, device)
+	}
+	if capture.Export != "" {
+		logging.GetLogger().Warningf("Flow export %s ignored by the DPDK capture on %s", capture.Export, device)
+	}
+
+	sampling := capture.SamplingRate
+	if sampling == 0 {
+		sampling = uint32(config.GetConfig().GetInt("agent.flow.dpdk.sampling"))
+	}
+
+	probe, err := newDPDKProbe(device, sampling)
+	if err != nil {
+		return err
+	}
+	probe.probeNodeUUID = string(n.ID)
+	probe.captureID = capture.ID()
+	probe.flowMappingPipeline = p.flowMappingPipeline
+	probe.flowTableAllocator = p.flowTableAllocator
+	probe.analyzerClient = p.analyzerClient
+	probe.activeTimeout, probe.idleTimeout = captureTimeouts(capture)
+
+	p.probesLock.Lock()
+	p.probes[device] = probe
+	p.probesLock.Unlock()
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		probe.start()
+	}()
+
+	return nil
+}
+
+func (p *DPDKProbesHandler) unregisterProbe(device string) error {
+	if probe, ok := p.probes[device]; ok {
+		logging.GetLogger().Debugf("Terminating DPDK capture on %s", device)
+		probe.stop()
+		delete(p.probes, device)
+	}
+
+	return nil
+}
+
+func (p *DPDKProbesHandler) UnregisterProbe(n *graph.Node) error {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	if device, err := dpdkDevice(n); err == nil {
+		return p.unregisterProbe(device)
+	}
+	return nil
+}
+
+func (p *DPDKProbesHandler) Start() {
+}
+
+func (p *DPDKProbesHandler) Stop() {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	for device := range p.probes {
+		p.unregisterProbe(device)
+	}
+	p.wg.Wait()
+}
+
+func NewDPDKProbesHandler(tb *probes.TopologyProbeBundle, g *graph.Graph,
+	p *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *DPDKProbesHandler {
+	return &DPDKProbesHandler{
+		graph:               g,
+		analyzerClient:      a,
+		flowMappingPipeline: p,
+		flowTableAllocator:  fta,
+		probes:              make(map[string]*DPDKProbe),
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !dpdk
+// +build !dpdk
+
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
+)
+
+// DPDKProbesHandler is only available in the agents built with the dpdk
+// tag, requiring the DPDK libraries
+type DPDKProbesHandler struct {
+}
+
+func (p *DPDKProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	return errors.New("DPDK support not built in")
+}
+
+func (p *DPDKProbesHandler) UnregisterProbe(n *graph.Node) error {
+	return nil
+}
+
+func (p *DPDKProbesHandler) Start() {
+}
+
+func (p *DPDKProbesHandler) Stop() {
+}
+
+func NewDPDKProbesHandler(tb *probes.TopologyProbeBundle, g *graph.Graph,
+	p *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *DPDKProbesHandler {
+	logging.GetLogger().Error("The dpdk flow probe requires an agent built with the dpdk tag")
+	return nil
+}
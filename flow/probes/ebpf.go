@@ -0,0 +1,747 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket/layers"
+	"github.com/vishvananda/netns"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
+)
+
+const (
+	// bpf(2) system call number on x86_64
+	sysBPF = 321
+
+	bpfMapCreate  = 0
+	bpfMapLookup  = 1
+	bpfMapDelete  = 3
+	bpfMapNextKey = 4
+	bpfProgLoad   = 5
+
+	bpfMapTypeHash          = 1
+	bpfProgTypeSocketFilter = 1
+	soAttachBPF             = 50
+
+	ebpfKeySize    = 32
+	ebpfValueSize  = 32
+	ebpfMaxEntries = 65536
+	ebpfPollPeriod = time.Second
+	ethPAll        = 0x0003
+)
+
+// eBPF instruction encoding
+const (
+	bpfLD    = 0x00
+	bpfLDX   = 0x01
+	bpfST    = 0x02
+	bpfSTX   = 0x03
+	bpfJMP   = 0x05
+	bpfALU64 = 0x07
+
+	bpfW  = 0x00
+	bpfH  = 0x08
+	bpfB  = 0x10
+	bpfDW = 0x18
+
+	bpfIMM  = 0x00
+	bpfABS  = 0x20
+	bpfIND  = 0x40
+	bpfMEM  = 0x60
+	bpfXADD = 0xc0
+
+	bpfK = 0x00
+	bpfX = 0x08
+
+	bpfADD  = 0x00
+	bpfAND  = 0x50
+	bpfLSH  = 0x60
+	bpfMOV  = 0xb0
+	bpfJA   = 0x00
+	bpfJEQ  = 0x10
+	bpfJNE  = 0x50
+	bpfCALL = 0x80
+	bpfEXIT = 0x90
+
+	bpfPseudoMapFD = 1
+
+	bpfFuncMapLookupElem = 1
+	bpfFuncMapUpdateElem = 2
+	bpfFuncKtimeGetNs    = 5
+)
+
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// bpfAssembler builds an eBPF program, resolving the jumps to labels
+type bpfAssembler struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func (a *bpfAssembler) emit(code uint8, dst uint8, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{code: code, regs: src<<4 | dst, off: off, imm: imm})
+}
+
+func (a *bpfAssembler) jump(code uint8, dst uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(bpfJMP|code|bpfK, dst, 0, 0, imm)
+}
+
+func (a *bpfAssembler) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAssembler) loadMapFD(dst uint8, fd int) {
+	a.emit(bpfLD|bpfDW|bpfIMM, dst, bpfPseudoMapFD, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *bpfAssembler) assemble() []bpfInsn {
+	for i, label := range a.jumps {
+		a.insns[i].off = int16(a.labels[label] - i - 1)
+	}
+	return a.insns
+}
+
+func newBPFAssembler() *bpfAssembler {
+	return &bpfAssembler{labels: make(map[string]int), jumps: make(map[int]string)}
+}
+
+// ebpfFlowProgram returns a socket filter counting the packets and the
+// bytes of the IPv4 flows in the map, the key being the addresses, the
+// ports and the protocol, the value the packets, the bytes and the first
+// and last monotonic times in nanoseconds. No packet is queued to the
+// socket.
+//
+// key:   src ip | dst ip | src port | dst port | proto | pad(3) | dst mac | pad(2) | src mac | pad(2)
+// value: packets | bytes | first | last
+func ebpfFlowProgram(mapFD int) []bpfInsn {
+	const key, value = -32, -64
+	a := newBPFAssembler()
+
+	a.emit(bpfALU64|bpfMOV|bpfX, 6, 1, 0, 0)
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, 0, 12)
+	a.jump(bpfJNE, 0, 0x0800, "out")
+
+	for i := int16(0); i < ebpfKeySize; i += 8 {
+		a.emit(bpfST|bpfMEM|bpfDW, 10, 0, key+i, 0)
+	}
+
+	// addresses, in host order
+	a.emit(bpfLD|bpfABS|bpfW, 0, 0, 0, 26)
+	a.emit(bpfSTX|bpfMEM|bpfW, 10, 0, key, 0)
+	a.emit(bpfLD|bpfABS|bpfW, 0, 0, 0, 30)
+	a.emit(bpfSTX|bpfMEM|bpfW, 10, 0, key+4, 0)
+	a.emit(bpfLD|bpfABS|bpfW, 0, 0, 0, 0)
+	a.emit(bpfSTX|bpfMEM|bpfW, 10, 0, key+16, 0)
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, 0, 4)
+	a.emit(bpfSTX|bpfMEM|bpfH, 10, 0, key+20, 0)
+	a.emit(bpfLD|bpfABS|bpfW, 0, 0, 0, 6)
+	a.emit(bpfSTX|bpfMEM|bpfW, 10, 0, key+24, 0)
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, 0, 10)
+	a.emit(bpfSTX|bpfMEM|bpfH, 10, 0, key+28, 0)
+
+	a.emit(bpfLD|bpfABS|bpfB, 0, 0, 0, 23)
+	a.emit(bpfSTX|bpfMEM|bpfB, 10, 0, key+12, 0)
+	a.emit(bpfALU64|bpfMOV|bpfX, 7, 0, 0, 0)
+
+	// the ports of the first fragment only
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, 0, 20)
+	a.emit(bpfALU64|bpfAND|bpfK, 0, 0, 0, 0x1fff)
+	a.jump(bpfJNE, 0, 0, "lookup")
+	a.jump(bpfJEQ, 7, int32(layers.IPProtocolTCP), "ports")
+	a.jump(bpfJEQ, 7, int32(layers.IPProtocolUDP), "ports")
+	a.jump(bpfJEQ, 7, int32(layers.IPProtocolSCTP), "ports")
+	a.jump(bpfJA, 0, 0, "lookup")
+
+	a.label("ports")
+	a.emit(bpfLD|bpfABS|bpfB, 0, 0, 0, 14)
+	a.emit(bpfALU64|bpfAND|bpfK, 0, 0, 0, 0x0f)
+	a.emit(bpfALU64|bpfLSH|bpfK, 0, 0, 0, 2)
+	a.emit(bpfALU64|bpfMOV|bpfX, 8, 0, 0, 0)
+	a.emit(bpfLD|bpfIND|bpfH, 0, 8, 0, 14)
+	a.emit(bpfSTX|bpfMEM|bpfH, 10, 0, key+8, 0)
+	a.emit(bpfLD|bpfIND|bpfH, 0, 8, 0, 16)
+	a.emit(bpfSTX|bpfMEM|bpfH, 10, 0, key+10, 0)
+
+	a.label("lookup")
+	// skb->len
+	a.emit(bpfLDX|bpfMEM|bpfW, 8, 6, 0, 0)
+	a.loadMapFD(1, mapFD)
+	a.emit(bpfALU64|bpfMOV|bpfX, 2, 10, 0, 0)
+	a.emit(bpfALU64|bpfADD|bpfK, 2, 0, 0, key)
+	a.emit(bpfJMP|bpfCALL, 0, 0, 0, bpfFuncMapLookupElem)
+	a.jump(bpfJEQ, 0, 0, "new")
+
+	a.emit(bpfALU64|bpfMOV|bpfX, 9, 0, 0, 0)
+	a.emit(bpfALU64|bpfMOV|bpfK, 1, 0, 0, 1)
+	a.emit(bpfSTX|bpfXADD|bpfDW, 9, 1, 0, 0)
+	a.emit(bpfSTX|bpfXADD|bpfDW, 9, 8, 8, 0)
+	a.emit(bpfJMP|bpfCALL, 0, 0, 0, bpfFuncKtimeGetNs)
+	a.emit(bpfSTX|bpfMEM|bpfDW, 9, 0, 24, 0)
+	a.jump(bpfJA, 0, 0, "out")
+
+	a.label("new")
+	a.emit(bpfST|bpfMEM|bpfDW, 10, 0, value, 1)
+	a.emit(bpfSTX|bpfMEM|bpfDW, 10, 8, value+8, 0)
+	a.emit(bpfJMP|bpfCALL, 0, 0, 0, bpfFuncKtimeGetNs)
+	a.emit(bpfSTX|bpfMEM|bpfDW, 10, 0, value+16, 0)
+	a.emit(bpfSTX|bpfMEM|bpfDW, 10, 0, value+24, 0)
+	a.loadMapFD(1, mapFD)
+	a.emit(bpfALU64|bpfMOV|bpfX, 2, 10, 0, 0)
+	a.emit(bpfALU64|bpfADD|bpfK, 2, 0, 0, key)
+	a.emit(bpfALU64|bpfMOV|bpfX, 3, 10, 0, 0)
+	a.emit(bpfALU64|bpfADD|bpfK, 3, 0, 0, value)
+	a.emit(bpfALU64|bpfMOV|bpfK, 4, 0, 0, 0)
+	a.emit(bpfJMP|bpfCALL, 0, 0, 0, bpfFuncMapUpdateElem)
+
+	a.label("out")
+	a.emit(bpfALU64|bpfMOV|bpfK, 0, 0, 0, 0)
+	a.emit(bpfJMP|bpfEXIT, 0, 0, 0, 0)
+
+	return a.assemble()
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+func bpfCreateMap(keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+	}{bpfMapTypeHash, keySize, valueSize, maxEntries}
+
+	return bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+type bpfMapElemAttr struct {
+	mapFD uint32
+	pad   uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+func bpfMapElem(cmd int, fd int, key []byte, value []byte) error {
+	attr := bpfMapElemAttr{mapFD: uint32(fd)}
+	if key != nil {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	if value != nil {
+		attr.value = uint64(uintptr(unsafe.Pointer(&value[0])))
+	}
+
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+func bpfLoadProgram(insns []bpfInsn) (int, error) {
+	license := []byte("GPL\x00")
+	log := make([]byte, 65536)
+
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		pad         uint32
+	}{
+		progType: bpfProgTypeSocketFilter,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		if n := bytesIndexZero(log); n > 0 {
+			return -1, fmt.Errorf("%s: %s", err.Error(), string(log[:n]))
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+func bytesIndexZero(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// monotonicNow returns the time base of bpf_ktime_get_ns
+func monotonicNow() time.Duration {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1, uintptr(unsafe.Pointer(&ts)), 0)
+	return time.Duration(ts.Nano())
+}
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	i := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+type ebpfFlowEntry struct {
+	srcMAC, dstMAC net.HardwareAddr
+	srcIP, dstIP   net.IP
+	srcPort        uint16
+	dstPort        uint16
+	protocol       uint8
+	packets        uint64
+	bytes          uint64
+	first, last    time.Duration
+}
+
+func hostOrderIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+func hostOrderMAC(hi uint32, lo uint16) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	binary.BigEndian.PutUint32(mac, hi)
+	binary.BigEndian.PutUint16(mac[4:], lo)
+	return mac
+}
+
+func newEBPFFlowEntry(key []byte, value []byte) *ebpfFlowEntry {
+	return &ebpfFlowEntry{
+		srcIP:    hostOrderIP(nativeEndian.Uint32(key[0:4])),
+		dstIP:    hostOrderIP(nativeEndian.Uint32(key[4:8])),
+		srcPort:  nativeEndian.Uint16(key[8:10]),
+		dstPort:  nativeEndian.Uint16(key[10:12]),
+		protocol: key[12],
+		dstMAC:   hostOrderMAC(nativeEndian.Uint32(key[16:20]), nativeEndian.Uint16(key[20:22])),
+		srcMAC:   hostOrderMAC(nativeEndian.Uint32(key[24:28]), nativeEndian.Uint16(key[28:30])),
+		packets:  nativeEndian.Uint64(value[0:8]),
+		bytes:    nativeEndian.Uint64(value[8:16]),
+		first:    time.Duration(nativeEndian.Uint64(value[16:24])),
+		last:     time.Duration(nativeEndian.Uint64(value[24:32])),
+	}
+}
+
+func (e *ebpfFlowEntry) srcValue(t flow.FlowEndpointType) string {
+	switch t {
+	case flow.FlowEndpointType_ETHERNET:
+		return e.srcMAC.String()
+	case flow.FlowEndpointType_IPV4:
+		return e.srcIP.String()
+	}
+	return strconv.Itoa(int(e.srcPort))
+}
+
+// key returns the same key for both directions of the flow
+func (e *ebpfFlowEntry) key() string {
+	src := fmt.Sprintf("%s-%s:%d", e.srcMAC, e.srcIP, e.srcPort)
+	dst := fmt.Sprintf("%s-%s:%d", e.dstMAC, e.dstIP, e.dstPort)
+	if src > dst {
+		src, dst = dst, src
+	}
+	return fmt.Sprintf("%d-%s-%s", e.protocol, src, dst)
+}
+
+type EBPFProbe struct {
+	sock                int
+	prog                int
+	fmap                int
+	probeNodeUUID       string
+	captureID           string
+	analyzerClient      *analyzer.Client
+	flowTable           *flow.Table
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	exporter            *netflow.Exporter
+	idleTimeout         time.Duration
+}
+
+type EBPFProbesHandler struct {
+	graph               *graph.Graph
+	analyzerClient      *analyzer.Client
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	wg                  sync.WaitGroup
+	probes              map[string]*EBPFProbe
+	probesLock          sync.RWMutex
+}
+
+func (p *EBPFProbe) SetProbeNode(flow *flow.Flow) bool {
+	flow.ProbeNodeUUID = p.probeNodeUUID
+	flow.CaptureID = p.captureID
+	return true
+}
+
+func (p *EBPFProbe) asyncFlowPipeline(flows []*flow.Flow) {
+	if p.flowMappingPipeline != nil {
+		p.flowMappingPipeline.Enhance(flows)
+	}
+	if p.analyzerClient != nil {
+		p.analyzerClient.SendFlows(flows)
+	}
+	if p.exporter != nil {
+		p.exporter.Export(flows)
+	}
+}
+
+func (p *EBPFProbe) updateFlow(e *ebpfFlowEntry, boot time.Time) {
+	f, created := p.flowTable.GetOrCreateFlow(e.key())
+	if f == nil {
+		return
+	}
+	if created {
+		p.SetProbeNode(f)
+
+		fs := &flow.FlowStatistics{Start: boot.Add(e.first).Unix()}
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_ETHERNET, e.srcMAC, e.dstMAC))
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_IPV4, e.srcIP, e.dstIP))
+		f.LayersPath = "Ethernet/IPv4"
+
+		switch layers.IPProtocol(e.protocol) {
+		case layers.IPProtocolTCP:
+			fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_TCPPORT, layers.TCPPort(e.srcPort), layers.TCPPort(e.dstPort)))
+			f.LayersPath += "/TCP"
+		case layers.IPProtocolUDP:
+			fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_UDPPORT, layers.UDPPort(e.srcPort), layers.UDPPort(e.dstPort)))
+			f.LayersPath += "/UDP"
+		case layers.IPProtocolSCTP:
+			fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_SCTPPORT, layers.SCTPPort(e.srcPort), layers.SCTPPort(e.dstPort)))
+			f.LayersPath += "/SCTP"
+		}
+
+		f.Statistics = fs
+		f.UpdateUUIDs()
+	}
+
+	fs := f.GetStatistics()
+	if last := boot.Add(e.last).Unix(); last > fs.Last {
+		fs.Last = last
+	}
+
+	// the kernel counters are the totals of one direction, the AB one if
+	// its endpoints are the ones of the flow creation
+	ab := true
+	for _, ep := range fs.GetEndpoints() {
+		if ep.AB.Value != e.srcValue(ep.Type) {
+			ab = false
+		}
+	}
+
+	for _, ep := range fs.GetEndpoints() {
+		s := ep.BA
+		if ab {
+			s = ep.AB
+		}
+		s.Packets = e.packets
+		s.Bytes = e.bytes
+	}
+}
+
+// poll reads the flow counters of the map, the entries of the flows
+// inactive for longer than the idle timeout being removed.
+func (p *EBPFProbe) poll() {
+	now := monotonicNow()
+	boot := time.Now().Add(-now)
+	expire := p.idleTimeout
+
+	var keys [][]byte
+	var key []byte
+	for {
+		next := make([]byte, ebpfKeySize)
+		if err := bpfMapElem(bpfMapNextKey, p.fmap, key, next); err != nil {
+			break
+		}
+		keys = append(keys, next)
+		key = next
+	}
+
+	value := make([]byte, ebpfValueSize)
+	for _, key := range keys {
+		if err := bpfMapElem(bpfMapLookup, p.fmap, key, value); err != nil {
+			continue
+		}
+
+		e := newEBPFFlowEntry(key, value)
+		p.updateFlow(e, boot)
+
+		if now-e.last > expire {
+			bpfMapElem(bpfMapDelete, p.fmap, key, nil)
+		}
+	}
+}
+
+func (p *EBPFProbe) start() {
+	p.flowTable = p.flowTableAllocator.Alloc()
+	defer p.flowTable.UnregisterAll()
+	defer p.flowTableAllocator.Release(p.flowTable)
+
+	registerExpire(p.flowTable, p.asyncFlowPipeline, 0, p.idleTimeout)
+
+	agentUpdate := config.GetAgentUpdate()
+	p.flowTable.RegisterUpdated(p.asyncFlowPipeline, agentUpdate, agentUpdate)
+
+	ticker := time.NewTicker(ebpfPollPeriod)
+	defer ticker.Stop()
+
+	feedFlowTable := func() {
+		select {
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+	p.flowTable.RegisterDefault(feedFlowTable)
+
+	p.flowTable.Start()
+}
+
+func (p *EBPFProbe) stop() {
+	p.flowTable.Stop()
+	syscall.Close(p.sock)
+	syscall.Close(p.prog)
+	syscall.Close(p.fmap)
+	if p.exporter != nil {
+		p.exporter.Close()
+	}
+}
+
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
+
+// newEBPFProbe attaches the flow program to a socket bound to the interface
+func newEBPFProbe(ifName string) (*EBPFProbe, error) {
+	intf, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	fmap, err := bpfCreateMap(ebpfKeySize, ebpfValueSize, ebpfMaxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create eBPF map: %s", err.Error())
+	}
+
+	prog, err := bpfLoadProgram(ebpfFlowProgram(fmap))
+	if err != nil {
+		syscall.Close(fmap)
+		return nil, fmt.Errorf("Unable to load eBPF program: %s", err.Error())
+	}
+
+	sock, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPAll)))
+	if err == nil {
+		err = syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, soAttachBPF, prog)
+		if err == nil {
+			err = syscall.Bind(sock, &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: intf.Index})
+		}
+		if err != nil {
+			syscall.Close(sock)
+		}
+	}
+	if err != nil {
+		syscall.Close(prog)
+		syscall.Close(fmap)
+		return nil, fmt.Errorf("Unable to attach eBPF program to %s: %s", ifName, err.Error())
+	}
+
+	return &EBPFProbe{sock: sock, prog: prog, fmap: fmap}, nil
+}
+
+func (p *EBPFProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	logging.GetLogger().Debugf("Starting eBPF capture on %s", n.Metadata()["Name"])
+
+	if name, ok := n.Metadata()["Name"]; ok && name != "" {
+		ifName := name.(string)
+
+		p.probesLock.RLock()
+		_, ok := p.probes[ifName]
+		p.probesLock.RUnlock()
+		if ok {
+			return fmt.Errorf("An eBPF probe already exists for %s", ifName)
+		}
+
+		nodes := p.graph.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})
+		if len(nodes) == 0 {
+			return fmt.Errorf("Failed to determine probePath for %s", ifName)
+		}
+
+		exporter, err := newCaptureExporter(capture)
+		if err != nil {
+			return fmt.Errorf("Unable to export the flows of %s: %s", ifName, err.Error())
+		}
+		registered := false
+		defer func() {
+			if !registered && exporter != nil {
+				exporter.Close()
+			}
+		}()
+
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		origns, err := netns.Get()
+		if err != nil {
+			return fmt.Errorf("Error while getting current ns: %s", err.Error())
+		}
+		defer origns.Close()
+
+		for _, node := range nodes {
+			if node.Metadata()["Type"] == "netns" {
+				name := node.Metadata()["Name"].(string)
+				path := node.Metadata()["Path"].(string)
+				logging.GetLogger().Debugf("Switching to namespace %s (path: %s)", name, path)
+
+				newns, err := netns.GetFromPath(path)
+				if err != nil {
+					return fmt.Errorf("Error while opening ns %s (path: %s): %s", name, path, err.Error())
+				}
+				defer newns.Close()
+
+				if err := netns.Set(newns); err != nil {
+					return fmt.Errorf("Error while switching from root ns to %s (path: %s): %s", name, path, err.Error())
+				}
+				defer netns.Set(origns)
+			}
+		}
+
+		if capture.BPFFilter != "" {
+			logging.GetLogger().Warningf("BPF filter %s ignored by the eBPF capture on %s", capture.BPFFilter, ifName)
+		}
+
+		probe, err := newEBPFProbe(ifName)
+		if err != nil {
+			return err
+		}
+		probe.exporter = exporter
+		// the kernel counters of a flow can't be restarted, so the active
+		// timeout doesn't apply
+		activeTimeout, idleTimeout := captureTimeouts(capture)
+		if capture.ActiveTimeout > 0 {
+			logging.GetLogger().Warningf("Active timeout %v ignored by the eBPF capture on %s", activeTimeout, ifName)
+		}
+		probe.idleTimeout = idleTimeout
+		probe.probeNodeUUID = string(n.ID)
+		probe.captureID = capture.ID()
+		probe.flowMappingPipeline = p.flowMappingPipeline
+		probe.flowTableAllocator = p.flowTableAllocator
+		probe.analyzerClient = p.analyzerClient
+		registered = true
+
+		p.probesLock.Lock()
+		p.probes[ifName] = probe
+		p.probesLock.Unlock()
+		p.wg.Add(1)
+
+		go func() {
+			defer p.wg.Done()
+
+			probe.start()
+		}()
+	}
+	return nil
+}
+
+func (p *EBPFProbesHandler) unregisterProbe(ifName string) error {
+	if probe, ok := p.probes[ifName]; ok {
+		logging.GetLogger().Debugf("Terminating eBPF capture on %s", ifName)
+		probe.stop()
+		delete(p.probes, ifName)
+	}
+
+	return nil
+}
+
+func (p *EBPFProbesHandler) UnregisterProbe(n *graph.Node) error {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	if name, ok := n.Metadata()["Name"]; ok && name != "" {
+		return p.unregisterProbe(name.(string))
+	}
+	return nil
+}
+
+func (p *EBPFProbesHandler) Start() {
+}
+
+func (p *EBPFProbesHandler) Stop() {
+	p.probesLock.Lock()
+	defer p.probesLock.Unlock()
+
+	for name := range p.probes {
+		p.unregisterProbe(name)
+	}
+	p.wg.Wait()
+}
+
+func NewEBPFProbesHandler(tb *probes.TopologyProbeBundle, g *graph.Graph,
+	p *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *EBPFProbesHandler {
+	return &EBPFProbesHandler{
+		graph:               g,
+		analyzerClient:      a,
+		flowMappingPipeline: p,
+		flowTableAllocator:  fta,
+		probes:              make(map[string]*EBPFProbe),
+	}
+}
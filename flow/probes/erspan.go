@@ -0,0 +1,308 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/erspan"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// packets of a capture waiting for its flow table
+const erspanQueueSize = 1000
+
+// ERSPANProbe is the capture of the mirrors of a session, attributed to the
+// node of the remote switch port
+type ERSPANProbe struct {
+	probeNodeUUID       string
+	captureID           string
+	session             int64
+	channel             chan gopacket.Packet
+	analyzerClient      *analyzer.Client
+	flowTable           *flow.Table
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	activeTimeout       time.Duration
+	idleTimeout         time.Duration
+	exporter            *netflow.Exporter
+	limiter             *captureLimiter
+}
+
+// ERSPANProbesHandler receives the traffic mirrored by the switches over
+// ERSPAN or GRE. The mirrors of a session go to the capture of the node
+// having this session as ERSPAN.SessionID metadata, set as user metadata
+// for instance, the other ones to the capture of a node without session.
+type ERSPANProbesHandler struct {
+	Addr                string
+	graph               *graph.Graph
+	analyzerClient      *analyzer.Client
+	flowMappingPipeline *mappings.FlowMappingPipeline
+	flowTableAllocator  *flow.TableAllocator
+	conn                *net.IPConn
+	wg                  sync.WaitGroup
+	probes              map[graph.Identifier]*ERSPANProbe
+	probesLock          sync.RWMutex
+}
+
+func (p *ERSPANProbe) SetProbeNode(flow *flow.Flow) bool {
+	flow.ProbeNodeUUID = p.probeNodeUUID
+	flow.CaptureID = p.captureID
+	return true
+}
+
+func (p *ERSPANProbe) asyncFlowPipeline(flows []*flow.Flow) {
+	if p.flowMappingPipeline != nil {
+		p.flowMappingPipeline.Enhance(flows)
+	}
+	if p.analyzerClient != nil {
+		p.analyzerClient.SendFlows(flows)
+	}
+	if p.exporter != nil {
+		p.exporter.Export(flows)
+	}
+}
+
+func (p *ERSPANProbe) start() {
+	defer p.flowTable.UnregisterAll()
+	defer p.flowTableAllocator.Release(p.flowTable)
+
+	registerExpire(p.flowTable, p.asyncFlowPipeline, p.activeTimeout, p.idleTimeout)
+
+	agentUpdate := config.GetAgentUpdate()
+	p.flowTable.RegisterUpdated(p.asyncFlowPipeline, agentUpdate, agentUpdate)
+
+	feedFlowTable := func() {
+		select {
+		case packet := <-p.channel:
+			if p.limiter.accept(packet.Metadata().CaptureLength) {
+				flow.FlowFromGoPacket(p.flowTable, &packet, p)
+				flow.PacketsCaptured.WithLabelValues("erspan").Inc()
+			}
+		case <-time.After(time.Second):
+		}
+	}
+	p.flowTable.RegisterDefault(feedFlowTable)
+
+	p.flowTable.Start()
+}
+
+func (p *ERSPANProbe) stop() {
+	p.flowTable.Stop()
+	if p.exporter != nil {
+		p.exporter.Close()
+	}
+}
+
+// nodeSession returns the ERSPAN session of the node, -1 if not set
+func nodeSession(n *graph.Node) (int64, error) {
+	v, ok := n.Metadata()["ERSPAN.SessionID"]
+	if !ok {
+		if user, isMap := n.Metadata()[graph.UserMetadataKey].(map[string]interface{}); isMap {
+			v, ok = user["ERSPAN.SessionID"]
+		}
+	}
+	if !ok {
+		return -1, nil
+	}
+
+	if s, isString := v.(string); isString {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	f, err := common.ToFloat64(v)
+	return int64(f), err
+}
+
+// probe returns the capture of the session, the one without session
+// otherwise
+func (h *ERSPANProbesHandler) probe(m *erspan.Mirror) *ERSPANProbe {
+	h.probesLock.RLock()
+	defer h.probesLock.RUnlock()
+
+	var any *ERSPANProbe
+	for _, p := range h.probes {
+		if m.HasSession && p.session == int64(m.Session) {
+			return p
+		}
+		if p.session < 0 {
+			any = p
+		}
+	}
+	return any
+}
+
+func (h *ERSPANProbesHandler) dispatch(data []byte, from string) {
+	m, err := erspan.Decapsulate(data)
+	if err != nil {
+		logging.GetLogger().Debugf("Unable to decapsulate the mirror from %s: %s", from, err.Error())
+		return
+	}
+
+	p := h.probe(m)
+	if p == nil {
+		return
+	}
+
+	frame := make([]byte, len(m.Frame))
+	copy(frame, m.Frame)
+	packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().CaptureLength = len(frame)
+	packet.Metadata().Length = len(frame)
+	packet.Metadata().Timestamp = time.Now()
+
+	select {
+	case p.channel <- packet:
+	default:
+		flow.PacketsDropped.WithLabelValues("erspan").Inc()
+	}
+}
+
+func (h *ERSPANProbesHandler) read() {
+	defer h.wg.Done()
+
+	var buf [65535]byte
+	for {
+		n, addr, err := h.conn.ReadFrom(buf[:])
+		if err != nil {
+			logging.GetLogger().Debugf("ERSPAN listener stopped: %s", err.Error())
+			return
+		}
+		h.dispatch(buf[:n], addr.String())
+	}
+}
+
+func (h *ERSPANProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	session, err := nodeSession(n)
+	if err != nil {
+		return fmt.Errorf("Invalid ERSPAN session of %s: %s", n.ID, err.Error())
+	}
+
+	h.probesLock.Lock()
+	defer h.probesLock.Unlock()
+
+	if _, ok := h.probes[n.ID]; ok {
+		return errors.New(fmt.Sprintf("An ERSPAN capture already exists for %s", n.ID))
+	}
+
+	exporter, err := newCaptureExporter(capture)
+	if err != nil {
+		return fmt.Errorf("Unable to export the flows of %s: %s", n.ID, err.Error())
+	}
+
+	logging.GetLogger().Debugf("Starting ERSPAN capture on %s, session %d", n.Metadata()["Name"], session)
+
+	activeTimeout, idleTimeout := captureTimeouts(capture)
+	probe := &ERSPANProbe{
+		probeNodeUUID:       string(n.ID),
+		captureID:           capture.ID(),
+		session:             session,
+		channel:             make(chan gopacket.Packet, erspanQueueSize),
+		analyzerClient:      h.analyzerClient,
+		flowMappingPipeline: h.flowMappingPipeline,
+		flowTableAllocator:  h.flowTableAllocator,
+		activeTimeout:       activeTimeout,
+		idleTimeout:         idleTimeout,
+		exporter:            exporter,
+		limiter:             newCaptureLimiter("erspan", capture),
+	}
+	probe.flowTable = h.flowTableAllocator.Alloc()
+	h.probes[n.ID] = probe
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		probe.start()
+	}()
+
+	return nil
+}
+
+func (h *ERSPANProbesHandler) unregisterProbe(id graph.Identifier) {
+	if probe, ok := h.probes[id]; ok {
+		logging.GetLogger().Debugf("Terminating ERSPAN capture on %s", id)
+		probe.stop()
+		delete(h.probes, id)
+	}
+}
+
+func (h *ERSPANProbesHandler) UnregisterProbe(n *graph.Node) error {
+	h.probesLock.Lock()
+	defer h.probesLock.Unlock()
+
+	h.unregisterProbe(n.ID)
+	return nil
+}
+
+func (h *ERSPANProbesHandler) Start() {
+	conn, err := net.ListenIP("ip4:gre", &net.IPAddr{IP: net.ParseIP(h.Addr)})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to receive the ERSPAN mirrors on %s: %s", h.Addr, err.Error())
+		return
+	}
+	h.conn = conn
+
+	h.wg.Add(1)
+	go h.read()
+}
+
+func (h *ERSPANProbesHandler) Stop() {
+	if h.conn != nil {
+		h.conn.Close()
+	}
+
+	h.probesLock.Lock()
+	for id := range h.probes {
+		h.unregisterProbe(id)
+	}
+	h.probesLock.Unlock()
+
+	h.wg.Wait()
+}
+
+func NewERSPANProbesHandler(g *graph.Graph, p *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *ERSPANProbesHandler {
+	return &ERSPANProbesHandler{
+		Addr:                config.GetConfig().GetString("agent.flow.erspan.listen"),
+		graph:               g,
+		analyzerClient:      a,
+		flowMappingPipeline: p,
+		flowTableAllocator:  fta,
+		probes:              make(map[graph.Identifier]*ERSPANProbe),
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// interval at which the counters of the captures are reported in the
+// metadata of their nodes
+const captureStatsInterval = 5 * time.Second
+
+// captureLimiter applies the sampling, the packet rate and the size limit
+// of a capture to its packets, possibly read by several goroutines
+type captureLimiter struct {
+	sampling   uint64
+	rate       int64
+	maxBytes   int64
+	seen       uint64
+	second     int64
+	inSecond   int64
+	bytes      int64
+	skipped    int64
+	limited    int64
+	sampledOut prometheus.Counter
+	overLimit  prometheus.Counter
+}
+
+// accept returns whether a packet of length bytes has to be processed
+func (l *captureLimiter) accept(length int) bool {
+	if l.sampling > 1 && atomic.AddUint64(&l.seen, 1)%l.sampling != 0 {
+		atomic.AddInt64(&l.skipped, 1)
+		l.sampledOut.Inc()
+		return false
+	}
+
+	if l.rate > 0 {
+		now := time.Now().Unix()
+		if second := atomic.LoadInt64(&l.second); second != now && atomic.CompareAndSwapInt64(&l.second, second, now) {
+			atomic.StoreInt64(&l.inSecond, 0)
+		}
+		if atomic.AddInt64(&l.inSecond, 1) > l.rate {
+			atomic.AddInt64(&l.limited, 1)
+			l.overLimit.Inc()
+			return false
+		}
+	}
+
+	if l.maxBytes > 0 && atomic.AddInt64(&l.bytes, int64(length)) > l.maxBytes {
+		atomic.AddInt64(&l.limited, 1)
+		l.overLimit.Inc()
+		return false
+	}
+
+	return true
+}
+
+// stats returns the packets skipped by the sampling and the ones over the
+// limits
+func (l *captureLimiter) stats() (int64, int64) {
+	return atomic.LoadInt64(&l.skipped), atomic.LoadInt64(&l.limited)
+}
+
+func newCaptureLimiter(probe string, capture *api.Capture) *captureLimiter {
+	return &captureLimiter{
+		sampling:   uint64(capture.SamplingRate),
+		rate:       int64(capture.PacketRate),
+		maxBytes:   capture.MaxBytes,
+		sampledOut: flow.PacketsSkipped.WithLabelValues(probe, "sampling"),
+		overLimit:  flow.PacketsSkipped.WithLabelValues(probe, "limit"),
+	}
+}
+
+// degradedReason returns why a capture sheds packets because of the budget
+// of the agent, empty if it doesn't
+func degradedReason(shedFlows, shedPayloads int64) string {
+	var reasons []string
+	if shedFlows > 0 {
+		reasons = append(reasons, "flows")
+	}
+	if shedPayloads > 0 {
+		reasons = append(reasons, "memory")
+	}
+	return strings.Join(reasons, ",")
+}
+
+// reportCaptureStats sets the counters of a capture in the metadata of its
+// node, with the packets shed by its tables. The captures being stopped
+// with the graph lock held, the reporting goroutines don't wait for it.
+func reportCaptureStats(g *graph.Graph, id graph.Identifier, received, dropped int64, l *captureLimiter, tables ...*flow.Table) {
+	skipped, limited := l.stats()
+
+	var shedFlows, shedPayloads int64
+	for _, t := range tables {
+		f, p := t.Shed()
+		shedFlows += f
+		shedPayloads += p
+	}
+
+	go func() {
+		g.Lock()
+		defer g.Unlock()
+
+		if node := g.GetNode(id); node != nil {
+			tr := g.StartMetadataTransaction(node)
+			tr.AddMetadata("Capture.PacketsReceived", received)
+			tr.AddMetadata("Capture.PacketsDropped", dropped)
+			tr.AddMetadata("Capture.PacketsSkipped", skipped)
+			tr.AddMetadata("Capture.PacketsLimited", limited)
+			tr.AddMetadata("Capture.PacketsShed", shedFlows+shedPayloads)
+			if reason := degradedReason(shedFlows, shedPayloads); reason != "" {
+				tr.AddMetadata("Capture.Degraded", reason)
+			}
+			tr.Commit()
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// NetFlowProbesHandler collects the flows of the NetFlow/IPFIX exporters,
+// the captures can't be registered on demand.
+type NetFlowProbesHandler struct {
+	collector *netflow.Collector
+}
+
+func (n *NetFlowProbesHandler) RegisterProbe(node *graph.Node, capture *api.Capture) error {
+	return errors.New("NetFlow probe doesn't support on demand capture")
+}
+
+func (n *NetFlowProbesHandler) UnregisterProbe(node *graph.Node) error {
+	return nil
+}
+
+func (n *NetFlowProbesHandler) Start() {
+	n.collector.Start()
+}
+
+func (n *NetFlowProbesHandler) Stop() {
+	n.collector.Stop()
+}
+
+func NewNetFlowProbesHandler(g *graph.Graph, m *mappings.FlowMappingPipeline, a *analyzer.Client, fta *flow.TableAllocator) *NetFlowProbesHandler {
+	collector, err := netflow.NewCollectorFromConfig(g, a, m, fta)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to start the NetFlow collector: %s", err.Error())
+		return nil
+	}
+
+	return &NetFlowProbesHandler{collector: collector}
+}
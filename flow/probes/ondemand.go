@@ -23,22 +23,35 @@
 package probes
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 
 	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
 	"github.com/redhat-cip/skydive/topology"
 	"github.com/redhat-cip/skydive/topology/graph"
 )
 
+// OnDemandProbeListener starts the captures of the probe paths of the host,
+// and the ones using a Gremlin query on the nodes the analyzers, evaluating
+// the queries as the topology changes, request them for.
 type OnDemandProbeListener struct {
 	graph.DefaultGraphListener
+	shttp.DefaultWSClientEventHandler
 	Graph          *graph.Graph
 	Probes         *FlowProbeBundle
 	CaptureHandler api.ApiHandler
+	WSClient       *shttp.WSAsyncClient
 	watcher        api.StoppableWatcher
 	host           string
+	// probes used by the captures, protected by the graph lock
+	registered map[graph.Identifier]FlowProbe
+	// captures over this number are refused, 0 for no limit
+	maxCaptures int
 }
 
 type FlowProbe interface {
@@ -46,14 +59,29 @@ type FlowProbe interface {
 	UnregisterProbe(n *graph.Node) error
 }
 
-func (o *OnDemandProbeListener) probeFromType(n *graph.Node) FlowProbe {
+func (o *OnDemandProbeListener) probeFromType(n *graph.Node, captureType string) FlowProbe {
 	var probeName string
 
 	switch n.Metadata()["Type"] {
 	case "ovsbridge":
 		probeName = "ovssflow"
+	case "switchport":
+		// only reachable through the mirrors of the switch
+		probeName = "erspan"
+	case "dpdk", "dpdkvhostuser", "dpdkvhostuserclient":
+		probeName = "dpdk"
 	default:
 		probeName = "pcap"
+		if captureType == "ebpf" || captureType == "afpacket" {
+			probeName = captureType
+		}
+		if o.Probes.GetProbe("ovsmirror") != nil && NeedsMirror(o.Graph, n) {
+			probeName = "ovsmirror"
+		}
+	}
+
+	if captureType == "erspan" {
+		probeName = "erspan"
 	}
 
 	probe := o.Probes.GetProbe(probeName)
@@ -70,24 +98,62 @@ func (o *OnDemandProbeListener) registerProbe(n *graph.Node, capture *api.Captur
 		return
 	}
 
-	fprobe := o.probeFromType(n)
+	fprobe := o.probeFromType(n, capture.Type)
 	if fprobe == nil {
 		logging.GetLogger().Errorf("Failed to register flow probe, unknown type %v", n)
 		return
 	}
 
+	_, registered := o.registered[n.ID]
+	if !registered && o.maxCaptures > 0 && len(o.registered) >= o.maxCaptures {
+		logging.GetLogger().Errorf("Capture refused on %s, %d captures already running", n.ID, len(o.registered))
+		o.setDegraded(n, "captures")
+		return
+	}
+
 	if err := fprobe.RegisterProbe(n, capture); err != nil {
 		logging.GetLogger().Debugf("Failed to register flow probe: %s", err.Error())
+		if err == flow.ErrBudgetExceeded {
+			o.setDegraded(n, "memory")
+			return
+		}
 	}
+	o.registered[n.ID] = fprobe
 
+	if !registered {
+		o.setDegraded(n, "")
+	}
 	o.Graph.AddMetadata(n, "State.FlowCapture", "ON")
 }
 
+// setDegraded flags the node of a capture refused because of the budget of
+// the agent, an empty reason removing the flag
+func (o *OnDemandProbeListener) setDegraded(n *graph.Node, reason string) {
+	if reason != "" {
+		tr := o.Graph.StartMetadataTransaction(n)
+		tr.AddMetadata("Capture.Degraded", reason)
+		tr.Commit()
+		return
+	}
+
+	if _, ok := n.Metadata()["Capture.Degraded"]; !ok {
+		return
+	}
+	m := make(graph.Metadata)
+	for k, v := range n.Metadata() {
+		if k != "Capture.Degraded" {
+			m[k] = v
+		}
+	}
+	o.Graph.SetMetadata(n, m)
+}
+
 func (o *OnDemandProbeListener) unregisterProbe(n *graph.Node) {
-	fprobe := o.probeFromType(n)
-	if fprobe == nil {
+	fprobe, ok := o.registered[n.ID]
+	if !ok {
 		return
 	}
+	delete(o.registered, n.ID)
 
 	if err := fprobe.UnregisterProbe(n); err != nil {
 		logging.GetLogger().Debugf("Failed to unregister flow probe: %s", err.Error())
@@ -160,6 +226,31 @@ func (o *OnDemandProbeListener) onCaptureDeleted(probePath string) {
 	}
 }
 
+// OnMessage handles the capture requests of the analyzers for the nodes
+// matching the Gremlin queries of the captures
+func (o *OnDemandProbeListener) OnMessage(msg shttp.WSMessage) {
+	var request api.CaptureRequest
+	if err := json.Unmarshal([]byte(*msg.Obj), &request); err != nil || request.Capture == nil {
+		logging.GetLogger().Errorf("Unable to decode capture message %v", msg)
+		return
+	}
+
+	o.Graph.Lock()
+	defer o.Graph.Unlock()
+
+	node := o.Graph.GetNode(graph.Identifier(request.NodeID))
+	if node == nil {
+		return
+	}
+
+	switch msg.Type {
+	case "CaptureStart":
+		o.registerProbe(node, request.Capture)
+	case "CaptureStop":
+		o.unregisterProbe(node)
+	}
+}
+
 func (o *OnDemandProbeListener) probePathFromID(id string) string {
 	return strings.Replace(id, "*", o.host+"[Type=host]", 1)
 }
@@ -167,6 +258,11 @@ func (o *OnDemandProbeListener) probePathFromID(id string) string {
 func (o *OnDemandProbeListener) onApiWatcherEvent(action string, id string, resource api.ApiResource) {
 	logging.GetLogger().Debugf("New watcher event %s for %s", action, id)
 	capture := resource.(*api.Capture)
+	// the captures using a query are requested by the analyzers
+	if capture.GremlinQuery != "" {
+		return
+	}
+
 	switch action {
 	case "init", "create", "set", "update":
 		o.onCaptureAdded(o.probePathFromID(id), capture)
@@ -178,7 +274,11 @@ func (o *OnDemandProbeListener) onApiWatcherEvent(action string, id string, reso
 func (o *OnDemandProbeListener) Start() error {
 	o.watcher = o.CaptureHandler.AsyncWatch(o.onApiWatcherEvent)
 
-	o.Graph.AddEventListener(o)
+	o.Graph.AddEventListenerWithPriority(o, graph.ListenerPriorityFromConfig("capture", graph.DefaultListenerPriority))
+
+	if o.WSClient != nil {
+		o.WSClient.AddEventHandler(o, api.CaptureNamespace)
+	}
 
 	return nil
 }
@@ -187,7 +287,7 @@ func (o *OnDemandProbeListener) Stop() {
 	o.watcher.Stop()
 }
 
-func NewOnDemandProbeListener(fb *FlowProbeBundle, g *graph.Graph, ch api.ApiHandler) (*OnDemandProbeListener, error) {
+func NewOnDemandProbeListener(fb *FlowProbeBundle, g *graph.Graph, ch api.ApiHandler, w *shttp.WSAsyncClient) (*OnDemandProbeListener, error) {
 	h, err := os.Hostname()
 	if err != nil {
 		return nil, err
@@ -197,6 +297,9 @@ func NewOnDemandProbeListener(fb *FlowProbeBundle, g *graph.Graph, ch api.ApiHan
 		Graph:          g,
 		Probes:         fb,
 		CaptureHandler: ch,
+		WSClient:       w,
 		host:           h,
+		registered:     make(map[graph.Identifier]FlowProbe),
+		maxCaptures:    config.GetConfig().GetInt("agent.limits.max_captures"),
 	}, nil
 }
@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	"github.com/socketplane/libovsdb"
+	"github.com/vishvananda/netlink"
+
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/ovs"
+	"github.com/redhat-cip/skydive/topology/graph"
+	"github.com/redhat-cip/skydive/topology/probes"
+)
+
+// time given to the kernel to create the interface of a mirror
+const mirrorLinkTimeout = 5 * time.Second
+
+// ovsMirror is the mirror of an Open vSwitch port to an internal port
+// created for the capture
+type ovsMirror struct {
+	name       string
+	bridgeUUID string
+	portUUID   string
+	mirrorUUID string
+}
+
+// OvsMirrorProbesHandler captures the packets of the Open vSwitch ports
+// not visible from the host, patch ports or ports of unknown namespaces,
+// by mirroring them to a temporary internal port captured with pcap
+type OvsMirrorProbesHandler struct {
+	Graph       *graph.Graph
+	ovsClient   *ovsdb.OvsClient
+	pcap        *PcapProbesHandler
+	mirrors     map[graph.Identifier]*ovsMirror
+	mirrorsLock sync.Mutex
+}
+
+func mirrorName(n *graph.Node) string {
+	return fmt.Sprintf("skym%08x", crc32.ChecksumIEEE([]byte(n.ID)))
+}
+
+// NeedsMirror returns whether the traffic of an Open vSwitch interface can
+// only be captured with a mirror, no namespace path leading to it
+func NeedsMirror(g *graph.Graph, n *graph.Node) bool {
+	if _, ok := n.Metadata()["UUID"]; !ok {
+		return false
+	}
+	if len(g.LookupParentNodes(n, graph.Metadata{"Type": "ovsport"})) == 0 {
+		return false
+	}
+	return len(g.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})) == 0
+}
+
+// ovsUUIDs returns the UUIDs of the port and of the bridge of an interface
+func (o *OvsMirrorProbesHandler) ovsUUIDs(n *graph.Node) (string, string, error) {
+	ports := o.Graph.LookupParentNodes(n, graph.Metadata{"Type": "ovsport"})
+	if len(ports) == 0 {
+		return "", "", fmt.Errorf("No Open vSwitch port found for %s", n.ID)
+	}
+	bridges := o.Graph.LookupParentNodes(ports[0], graph.Metadata{"Type": "ovsbridge"})
+	if len(bridges) == 0 {
+		return "", "", fmt.Errorf("No Open vSwitch bridge found for %s", n.ID)
+	}
+
+	portUUID, _ := ports[0].Metadata()["UUID"].(string)
+	bridgeUUID, _ := bridges[0].Metadata()["UUID"].(string)
+	if portUUID == "" || bridgeUUID == "" {
+		return "", "", fmt.Errorf("Unknown Open vSwitch port of %s", n.ID)
+	}
+	return portUUID, bridgeUUID, nil
+}
+
+// createMirror adds an internal port to the bridge and a mirror of the
+// port to it, in a single transaction
+func (o *OvsMirrorProbesHandler) createMirror(name string, portUUID string, bridgeUUID string) (*ovsMirror, error) {
+	intfOp := libovsdb.Operation{
+		Op:       "insert",
+		Table:    "Interface",
+		Row:      map[string]interface{}{"name": name, "type": "internal"},
+		UUIDName: "skydive_mirror_intf",
+	}
+
+	portOp := libovsdb.Operation{
+		Op:    "insert",
+		Table: "Port",
+		Row: map[string]interface{}{
+			"name":       name,
+			"interfaces": libovsdb.UUID{GoUUID: "skydive_mirror_intf"},
+		},
+		UUIDName: "skydive_mirror_port",
+	}
+
+	mirrorOp := libovsdb.Operation{
+		Op:    "insert",
+		Table: "Mirror",
+		Row: map[string]interface{}{
+			"name":            name,
+			"select_src_port": libovsdb.UUID{GoUUID: portUUID},
+			"select_dst_port": libovsdb.UUID{GoUUID: portUUID},
+			"output_port":     libovsdb.UUID{GoUUID: "skydive_mirror_port"},
+		},
+		UUIDName: "skydive_mirror",
+	}
+
+	condition := libovsdb.NewCondition("_uuid", "==", libovsdb.UUID{GoUUID: bridgeUUID})
+	bridgeOp := libovsdb.Operation{
+		Op:    "mutate",
+		Table: "Bridge",
+		Mutations: []interface{}{
+			libovsdb.NewMutation("ports", "insert", libovsdb.OvsSet{GoSet: []interface{}{libovsdb.UUID{GoUUID: "skydive_mirror_port"}}}),
+			libovsdb.NewMutation("mirrors", "insert", libovsdb.OvsSet{GoSet: []interface{}{libovsdb.UUID{GoUUID: "skydive_mirror"}}}),
+		},
+		Where: []interface{}{condition},
+	}
+
+	result, err := o.ovsClient.Exec(intfOp, portOp, mirrorOp, bridgeOp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ovsMirror{
+		name:       name,
+		bridgeUUID: bridgeUUID,
+		portUUID:   result[1].UUID.GoUUID,
+		mirrorUUID: result[2].UUID.GoUUID,
+	}, nil
+}
+
+// deleteMirror removes the mirror and its port from the bridge, the
+// unreferenced rows being garbage collected by OVSDB
+func (o *OvsMirrorProbesHandler) deleteMirror(m *ovsMirror) error {
+	condition := libovsdb.NewCondition("_uuid", "==", libovsdb.UUID{GoUUID: m.bridgeUUID})
+	bridgeOp := libovsdb.Operation{
+		Op:    "mutate",
+		Table: "Bridge",
+		Mutations: []interface{}{
+			libovsdb.NewMutation("mirrors", "delete", libovsdb.OvsSet{GoSet: []interface{}{libovsdb.UUID{GoUUID: m.mirrorUUID}}}),
+			libovsdb.NewMutation("ports", "delete", libovsdb.OvsSet{GoSet: []interface{}{libovsdb.UUID{GoUUID: m.portUUID}}}),
+		},
+		Where: []interface{}{condition},
+	}
+
+	_, err := o.ovsClient.Exec(bridgeOp)
+	return err
+}
+
+// setLinkUp waits for the interface of the mirror to be created and brings
+// it up, the packets sent to a down internal port being dropped
+func setLinkUp(name string) error {
+	deadline := time.Now().Add(mirrorLinkTimeout)
+	for {
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			return netlink.LinkSetUp(link)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Interface %s of the mirror not created: %s", name, err.Error())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (o *OvsMirrorProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	o.mirrorsLock.Lock()
+	defer o.mirrorsLock.Unlock()
+
+	if _, ok := o.mirrors[n.ID]; ok {
+		return errors.New(fmt.Sprintf("A mirror already exists for %s", n.ID))
+	}
+
+	portUUID, bridgeUUID, err := o.ovsUUIDs(n)
+	if err != nil {
+		return err
+	}
+
+	name := mirrorName(n)
+	logging.GetLogger().Infof("Mirroring %s to %s for its capture", n.Metadata()["Name"], name)
+
+	mirror, err := o.createMirror(name, portUUID, bridgeUUID)
+	if err != nil {
+		return err
+	}
+
+	if err = setLinkUp(name); err == nil {
+		err = o.pcap.registerProbeOnInterface(name, string(n.ID), nil, capture)
+	}
+	if err != nil {
+		if err := o.deleteMirror(mirror); err != nil {
+			logging.GetLogger().Errorf("Unable to delete the mirror %s: %s", name, err.Error())
+		}
+		return err
+	}
+
+	o.mirrors[n.ID] = mirror
+	return nil
+}
+
+func (o *OvsMirrorProbesHandler) unregisterProbe(id graph.Identifier) error {
+	mirror, ok := o.mirrors[id]
+	if !ok {
+		return nil
+	}
+	delete(o.mirrors, id)
+
+	logging.GetLogger().Infof("Deleting the mirror %s", mirror.name)
+
+	o.pcap.probesLock.Lock()
+	o.pcap.unregisterProbe(mirror.name)
+	o.pcap.probesLock.Unlock()
+
+	return o.deleteMirror(mirror)
+}
+
+func (o *OvsMirrorProbesHandler) UnregisterProbe(n *graph.Node) error {
+	o.mirrorsLock.Lock()
+	defer o.mirrorsLock.Unlock()
+
+	return o.unregisterProbe(n.ID)
+}
+
+func (o *OvsMirrorProbesHandler) Start() {
+}
+
+func (o *OvsMirrorProbesHandler) Stop() {
+	o.mirrorsLock.Lock()
+	defer o.mirrorsLock.Unlock()
+
+	for id := range o.mirrors {
+		if err := o.unregisterProbe(id); err != nil {
+			logging.GetLogger().Errorf("Unable to delete the mirror of %s: %s", id, err.Error())
+		}
+	}
+}
+
+func NewOvsMirrorProbesHandler(tb *probes.TopologyProbeBundle, g *graph.Graph, pcap *PcapProbesHandler) *OvsMirrorProbesHandler {
+	probe := tb.GetProbe("ovsdb")
+	if probe == nil {
+		return nil
+	}
+	p := probe.(*probes.OvsdbProbe)
+
+	return &OvsMirrorProbesHandler{
+		Graph:     g,
+		ovsClient: p.OvsMon.OvsClient,
+		pcap:      pcap,
+		mirrors:   make(map[graph.Identifier]*ovsMirror),
+	}
+}
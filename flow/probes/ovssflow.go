@@ -30,6 +30,7 @@ import (
 
 	"github.com/redhat-cip/skydive/analyzer"
 	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/flow"
 	"github.com/redhat-cip/skydive/flow/mappings"
 	"github.com/redhat-cip/skydive/logging"
@@ -47,6 +48,7 @@ type OvsSFlowProbe struct {
 	Sampling      uint32
 	Polling       uint32
 	ProbeNodeUUID string
+	CaptureID     string
 }
 
 type OvsSFlowProbesHandler struct {
@@ -62,16 +64,23 @@ func probeID(i string) string {
 
 func (p *OvsSFlowProbe) SetProbeNode(flow *flow.Flow) bool {
 	flow.ProbeNodeUUID = p.ProbeNodeUUID
+	flow.CaptureID = p.CaptureID
 	return true
 }
 
+// sFlowRow returns the sFlow table row of the probe settings
+func sFlowRow(probe OvsSFlowProbe) map[string]interface{} {
+	row := make(map[string]interface{})
+	row["agent"] = probe.Interface
+	row["targets"] = probe.Target
+	row["header"] = probe.HeaderSize
+	row["sampling"] = probe.Sampling
+	row["polling"] = probe.Polling
+	return row
+}
+
 func newInsertSFlowProbeOP(probe OvsSFlowProbe) (*libovsdb.Operation, error) {
-	sFlowRow := make(map[string]interface{})
-	sFlowRow["agent"] = probe.Interface
-	sFlowRow["targets"] = probe.Target
-	sFlowRow["header"] = probe.HeaderSize
-	sFlowRow["sampling"] = probe.Sampling
-	sFlowRow["polling"] = probe.Polling
+	sFlowRow := sFlowRow(probe)
 
 	extIds := make(map[string]string)
 	extIds["probe-id"] = probe.ID
@@ -91,6 +100,18 @@ func newInsertSFlowProbeOP(probe OvsSFlowProbe) (*libovsdb.Operation, error) {
 	return &insertOp, nil
 }
 
+// newUpdateSFlowProbeOP updates the settings of an already registered
+// probe, left by a previous capture or agent with another target
+func newUpdateSFlowProbeOP(probe OvsSFlowProbe, probeUUID string) *libovsdb.Operation {
+	condition := libovsdb.NewCondition("_uuid", "==", libovsdb.UUID{GoUUID: probeUUID})
+	return &libovsdb.Operation{
+		Op:    "update",
+		Table: "sFlow",
+		Row:   sFlowRow(probe),
+		Where: []interface{}{condition},
+	}
+}
+
 func compareProbeID(row *map[string]interface{}, id string) (bool, error) {
 	extIds := (*row)["external_ids"]
 	switch extIds.(type) {
@@ -162,6 +183,8 @@ func (o *OvsSFlowProbesHandler) registerSFlowProbeOnBridge(probe OvsSFlowProbe,
 		uuid = libovsdb.UUID{GoUUID: probeUUID}
 
 		logging.GetLogger().Infof("Using already registered OVS SFlow probe \"%s(%s)\"", probe.ID, uuid)
+
+		operations = append(operations, *newUpdateSFlowProbeOP(probe, probeUUID))
 	} else {
 		insertOp, err := newInsertSFlowProbeOP(probe)
 		if err != nil {
@@ -222,25 +245,43 @@ func (o *OvsSFlowProbesHandler) UnregisterSFlowProbeFromBridge(bridgeUUID string
 	return nil
 }
 
-func (o *OvsSFlowProbesHandler) RegisterProbeOnBridge(bridgeUUID string, uuid string) error {
+// RegisterProbeOnBridge configures the sFlow of the bridge to send its
+// samples to an agent allocated for it, the header size, the sampling rate
+// and the polling interval being the ones of the capture if set, the ones
+// of the configuration otherwise
+func (o *OvsSFlowProbesHandler) RegisterProbeOnBridge(bridgeUUID string, uuid string, capture *api.Capture) error {
 	probe := OvsSFlowProbe{
 		ID:            probeID(bridgeUUID),
 		Interface:     "lo",
-		HeaderSize:    256,
-		Sampling:      1,
-		Polling:       0,
+		HeaderSize:    uint32(config.GetConfig().GetInt("sflow.header_size")),
+		Sampling:      uint32(config.GetConfig().GetInt("sflow.sampling")),
+		Polling:       uint32(config.GetConfig().GetInt("sflow.polling")),
 		ProbeNodeUUID: uuid,
+		CaptureID:     capture.ID(),
+	}
+	if capture.SnapLen > 0 {
+		probe.HeaderSize = uint32(capture.SnapLen)
+	}
+	if capture.SamplingRate > 0 {
+		probe.Sampling = capture.SamplingRate
+	}
+	if capture.PollingPeriod > 0 {
+		probe.Polling = capture.PollingPeriod
 	}
 
 	agent, err := o.allocator.Alloc(bridgeUUID, &probe)
 	if err != nil && err != sflow.AgentAlreadyAllocated {
 		return err
 	}
+	allocated := err == nil
 
 	probe.Target = agent.GetTarget()
 
 	err = o.registerSFlowProbeOnBridge(probe, bridgeUUID)
 	if err != nil {
+		if allocated {
+			o.allocator.Release(bridgeUUID)
+		}
 		return err
 	}
 	return nil
@@ -251,8 +292,12 @@ func isOvsBridge(n *graph.Node) bool {
 }
 
 func (o *OvsSFlowProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
+	if capture.Export != "" {
+		logging.GetLogger().Warningf("Flow export %s ignored by the sFlow capture on %s", capture.Export, n.Metadata()["Name"])
+	}
+
 	if isOvsBridge(n) {
-		err := o.RegisterProbeOnBridge(n.Metadata()["UUID"].(string), string(n.ID))
+		err := o.RegisterProbeOnBridge(n.Metadata()["UUID"].(string), string(n.ID), capture)
 		if err != nil {
 			return err
 		}
@@ -260,7 +305,11 @@ func (o *OvsSFlowProbesHandler) RegisterProbe(n *graph.Node, capture *api.Captur
 	return nil
 }
 
+// unregisterProbe removes the sFlow of the bridge, the unreferenced row
+// being garbage collected by OVSDB, and releases its agent
 func (o *OvsSFlowProbesHandler) unregisterProbe(bridgeUUID string) error {
+	defer o.allocator.Release(bridgeUUID)
+
 	err := o.UnregisterSFlowProbeFromBridge(bridgeUUID)
 	if err != nil {
 		return err
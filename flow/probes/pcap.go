@@ -37,19 +37,32 @@ import (
 	"github.com/redhat-cip/skydive/flow"
 	"github.com/redhat-cip/skydive/flow/mappings"
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
 	"github.com/redhat-cip/skydive/topology/graph"
 	"github.com/redhat-cip/skydive/topology/probes"
 	"github.com/vishvananda/netns"
 )
 
 type PcapProbe struct {
+	graph               *graph.Graph
 	handle              *pcap.Handle
 	channel             chan gopacket.Packet
 	probeNodeUUID       string
+	captureID           string
 	analyzerClient      *analyzer.Client
 	flowTable           *flow.Table
 	flowMappingPipeline *mappings.FlowMappingPipeline
 	flowTableAllocator  *flow.TableAllocator
+	rawPacketLimit      int
+	activeTimeout       time.Duration
+	idleTimeout         time.Duration
+	exporter            *netflow.Exporter
+	limiter             *captureLimiter
+	// packets dropped by the kernel, read every pcapStatsInterval and
+	// reported in the node metadata every captureStatsInterval
+	dropped   int
+	lastStats time.Time
+	reported  time.Time
 }
 
 type PcapProbesHandler struct {
@@ -63,11 +76,13 @@ type PcapProbesHandler struct {
 }
 
 const (
-	snaplen int32 = 256
+	snaplen           int32 = 256
+	pcapStatsInterval       = time.Second
 )
 
 func (p *PcapProbe) SetProbeNode(flow *flow.Flow) bool {
 	flow.ProbeNodeUUID = p.probeNodeUUID
+	flow.CaptureID = p.captureID
 	return true
 }
 
@@ -78,6 +93,30 @@ func (p *PcapProbe) asyncFlowPipeline(flows []*flow.Flow) {
 	if p.analyzerClient != nil {
 		p.analyzerClient.SendFlows(flows)
 	}
+	if p.exporter != nil {
+		p.exporter.Export(flows)
+	}
+}
+
+func (p *PcapProbe) updateDropped() {
+	if time.Since(p.lastStats) < pcapStatsInterval {
+		return
+	}
+	p.lastStats = time.Now()
+
+	stats, err := p.handle.Stats()
+	if err != nil {
+		return
+	}
+	if stats.PacketsDropped > p.dropped {
+		flow.PacketsDropped.WithLabelValues("pcap").Add(float64(stats.PacketsDropped - p.dropped))
+	}
+	p.dropped = stats.PacketsDropped
+
+	if p.lastStats.Sub(p.reported) >= captureStatsInterval {
+		p.reported = p.lastStats
+		reportCaptureStats(p.graph, graph.Identifier(p.probeNodeUUID), int64(stats.PacketsReceived), int64(stats.PacketsDropped), p.limiter, p.flowTable)
+	}
 }
 
 func (p *PcapProbe) start() {
@@ -85,8 +124,9 @@ func (p *PcapProbe) start() {
 	defer p.flowTable.UnregisterAll()
 	defer p.flowTableAllocator.Release(p.flowTable)
 
-	agentExpire := config.GetAgentExpire()
-	p.flowTable.RegisterExpire(p.asyncFlowPipeline, agentExpire, agentExpire)
+	p.flowTable.SetRawPacketLimit(p.rawPacketLimit)
+
+	registerExpire(p.flowTable, p.asyncFlowPipeline, p.activeTimeout, p.idleTimeout)
 
 	agentUpdate := config.GetAgentUpdate()
 	p.flowTable.RegisterUpdated(p.asyncFlowPipeline, agentUpdate, agentUpdate)
@@ -95,7 +135,11 @@ func (p *PcapProbe) start() {
 		select {
 		case packet, ok := <-p.channel:
 			if ok {
-				flow.FlowFromGoPacket(p.flowTable, &packet, p)
+				if p.limiter.accept(packet.Metadata().CaptureLength) {
+					flow.FlowFromGoPacket(p.flowTable, &packet, p)
+					flow.PacketsCaptured.WithLabelValues("pcap").Inc()
+				}
+				p.updateDropped()
 			}
 		}
 	}
@@ -107,6 +151,9 @@ func (p *PcapProbe) start() {
 func (p *PcapProbe) stop() {
 	p.handle.Close()
 	p.flowTable.Stop()
+	if p.exporter != nil {
+		p.exporter.Close()
+	}
 }
 
 func (p *PcapProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) error {
@@ -115,70 +162,109 @@ func (p *PcapProbesHandler) RegisterProbe(n *graph.Node, capture *api.Capture) e
 	if name, ok := n.Metadata()["Name"]; ok && name != "" {
 		ifName := name.(string)
 
-		if _, ok := p.probes[ifName]; ok {
-			return errors.New(fmt.Sprintf("A pcap probe already exists for %s", ifName))
-		}
-
 		nodes := p.graph.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})
 		if len(nodes) == 0 {
 			return errors.New(fmt.Sprintf("Failed to determine probePath for %s", ifName))
 		}
 
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
+		return p.registerProbeOnInterface(ifName, string(n.ID), nodes, capture)
+	}
+	return nil
+}
 
-		origns, err := netns.Get()
-		if err != nil {
-			return fmt.Errorf("Error while getting current ns: %s", err.Error())
+// registerProbeOnInterface captures the packets of the interface, in the
+// namespaces of the path, as the ones of the node probeNodeUUID
+func (p *PcapProbesHandler) registerProbeOnInterface(ifName string, probeNodeUUID string, nodes []*graph.Node, capture *api.Capture) error {
+	if _, ok := p.probes[ifName]; ok {
+		return errors.New(fmt.Sprintf("A pcap probe already exists for %s", ifName))
+	}
+
+	exporter, err := newCaptureExporter(capture)
+	if err != nil {
+		return fmt.Errorf("Unable to export the flows of %s: %s", ifName, err.Error())
+	}
+	registered := false
+	defer func() {
+		if !registered && exporter != nil {
+			exporter.Close()
 		}
-		defer origns.Close()
+	}()
 
-		for _, node := range nodes {
-			if node.Metadata()["Type"] == "netns" {
-				name := node.Metadata()["Name"].(string)
-				path := node.Metadata()["Path"].(string)
-				logging.GetLogger().Debugf("Switching to namespace %s (path: %s)", name, path)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 
-				newns, err := netns.GetFromPath(path)
-				if err != nil {
-					return fmt.Errorf("Error while opening ns %s (path: %s): %s", name, path, err.Error())
-				}
-				defer newns.Close()
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("Error while getting current ns: %s", err.Error())
+	}
+	defer origns.Close()
 
-				if err := netns.Set(newns); err != nil {
-					return fmt.Errorf("Error while switching from root ns to %s (path: %s): %s", name, path, err.Error())
-				}
-				defer netns.Set(origns)
+	for _, node := range nodes {
+		if node.Metadata()["Type"] == "netns" {
+			name := node.Metadata()["Name"].(string)
+			path := node.Metadata()["Path"].(string)
+			logging.GetLogger().Debugf("Switching to namespace %s (path: %s)", name, path)
+
+			newns, err := netns.GetFromPath(path)
+			if err != nil {
+				return fmt.Errorf("Error while opening ns %s (path: %s): %s", name, path, err.Error())
 			}
-		}
+			defer newns.Close()
 
-		handle, err := pcap.OpenLive(ifName, snaplen, true, time.Second)
-		if err != nil {
-			return fmt.Errorf("Error while opening device %s: %s", ifName, err.Error())
+			if err := netns.Set(newns); err != nil {
+				return fmt.Errorf("Error while switching from root ns to %s (path: %s): %s", name, path, err.Error())
+			}
+			defer netns.Set(origns)
 		}
+	}
 
-		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-		packetChannel := packetSource.Packets()
+	captureSnaplen := snaplen
+	if capture.SnapLen > 0 {
+		captureSnaplen = int32(capture.SnapLen)
+	}
 
-		probe := &PcapProbe{
-			handle:              handle,
-			channel:             packetChannel,
-			probeNodeUUID:       string(n.ID),
-			flowMappingPipeline: p.flowMappingPipeline,
-			flowTableAllocator:  p.flowTableAllocator,
-			analyzerClient:      p.analyzerClient,
-		}
-		p.probesLock.Lock()
-		p.probes[ifName] = probe
-		p.probesLock.Unlock()
-		p.wg.Add(1)
+	handle, err := pcap.OpenLive(ifName, captureSnaplen, true, time.Second)
+	if err != nil {
+		return fmt.Errorf("Error while opening device %s: %s", ifName, err.Error())
+	}
 
-		go func() {
-			defer p.wg.Done()
+	if capture.BPFFilter != "" {
+		if err := handle.SetBPFFilter(capture.BPFFilter); err != nil {
+			handle.Close()
+			return fmt.Errorf("Error while setting BPF filter %s on %s: %s", capture.BPFFilter, ifName, err.Error())
+		}
+	}
 
-			probe.start()
-		}()
+	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	packetChannel := packetSource.Packets()
+
+	activeTimeout, idleTimeout := captureTimeouts(capture)
+	probe := &PcapProbe{
+		graph:               p.graph,
+		handle:              handle,
+		channel:             packetChannel,
+		probeNodeUUID:       probeNodeUUID,
+		captureID:           capture.ID(),
+		flowMappingPipeline: p.flowMappingPipeline,
+		flowTableAllocator:  p.flowTableAllocator,
+		analyzerClient:      p.analyzerClient,
+		rawPacketLimit:      capture.RawPacketLimit,
+		activeTimeout:       activeTimeout,
+		idleTimeout:         idleTimeout,
+		exporter:            exporter,
+		limiter:             newCaptureLimiter("pcap", capture),
 	}
+	registered = true
+	p.probesLock.Lock()
+	p.probes[ifName] = probe
+	p.probesLock.Unlock()
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		probe.start()
+	}()
 	return nil
 }
 
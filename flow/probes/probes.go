@@ -23,11 +23,15 @@
 package probes
 
 import (
+	"time"
+
 	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/api"
 	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/flow"
 	"github.com/redhat-cip/skydive/flow/mappings"
 	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/netflow"
 	"github.com/redhat-cip/skydive/probe"
 	"github.com/redhat-cip/skydive/topology/graph"
 	"github.com/redhat-cip/skydive/topology/probes"
@@ -35,7 +39,8 @@ import (
 
 type FlowProbeBundle struct {
 	probe.ProbeBundle
-	Graph *graph.Graph
+	Graph          *graph.Graph
+	AnalyzerClient *analyzer.Client
 }
 
 func (fpb *FlowProbeBundle) UnregisterAllProbes() {
@@ -57,6 +62,15 @@ func NewFlowProbeBundleFromConfig(tb *probes.TopologyProbeBundle, g *graph.Graph
 
 	gfe := mappings.NewGraphFlowEnhancer(g)
 
+	// enhancers of the flows captured locally
+	local := []mappings.FlowEnhancer{gfe}
+	if config.GetConfig().GetBool("agent.flow.process_mapping") {
+		local = append(local, mappings.NewProcessFlowEnhancer())
+	}
+	if config.GetConfig().GetBool("agent.flow.conntrack") {
+		local = append(local, mappings.NewConntrackFlowEnhancer())
+	}
+
 	var aclient *analyzer.Client
 
 	addr, port, err := config.GetAnalyzerClientAddr()
@@ -82,35 +96,110 @@ func NewFlowProbeBundleFromConfig(tb *probes.TopologyProbeBundle, g *graph.Graph
 		switch t {
 		case "ovssflow":
 			ofe := mappings.NewOvsFlowEnhancer(g)
-			pipeline := mappings.NewFlowMappingPipeline(gfe, ofe)
+			pipeline := mappings.NewFlowMappingPipeline(append(local, ofe)...)
 
 			o := NewOvsSFlowProbesHandler(tb, g, pipeline, aclient, fta)
 			if o != nil {
 				probes[t] = o
 			}
 		case "pcap":
-			pipeline := mappings.NewFlowMappingPipeline(gfe)
+			pipeline := mappings.NewFlowMappingPipeline(local...)
 
 			o := NewPcapProbesHandler(tb, g, pipeline, aclient, fta)
 			if o != nil {
 				probes[t] = o
 			}
+		case "ebpf":
+			pipeline := mappings.NewFlowMappingPipeline(local...)
+
+			o := NewEBPFProbesHandler(tb, g, pipeline, aclient, fta)
+			if o != nil {
+				probes[t] = o
+			}
+		case "afpacket":
+			pipeline := mappings.NewFlowMappingPipeline(local...)
+
+			o := NewAFPacketProbesHandler(tb, g, pipeline, aclient, fta)
+			if o != nil {
+				probes[t] = o
+			}
+		case "dpdk":
+			pipeline := mappings.NewFlowMappingPipeline(gfe)
+
+			o := NewDPDKProbesHandler(tb, g, pipeline, aclient, fta)
+			if o != nil {
+				probes[t] = o
+			}
+		case "erspan":
+			pipeline := mappings.NewFlowMappingPipeline(gfe)
+
+			probes[t] = NewERSPANProbesHandler(g, pipeline, aclient, fta)
+		case "netflow":
+			pipeline := mappings.NewFlowMappingPipeline(gfe)
+
+			o := NewNetFlowProbesHandler(g, pipeline, aclient, fta)
+			if o != nil {
+				probes[t] = o
+			}
 		default:
 			logging.GetLogger().Errorf("unknown probe type %s", t)
 		}
 	}
 
+	// captures the ports of Open vSwitch not visible from the host through
+	// mirrors, when pcap and the ovsdb topology probe are available
+	if pcap, ok := probes["pcap"].(*PcapProbesHandler); ok {
+		if o := NewOvsMirrorProbesHandler(tb, g, pcap); o != nil {
+			probes["ovsmirror"] = o
+		}
+	}
+
 	p := probe.NewProbeBundle(probes)
 
 	return &FlowProbeBundle{
-		ProbeBundle: *p,
-		Graph:       g,
+		ProbeBundle:    *p,
+		Graph:          g,
+		AnalyzerClient: aclient,
+	}
+}
+
+// newCaptureExporter returns the exporter of the flows of the capture, nil
+// if not requested. Must be called from the root namespace.
+func newCaptureExporter(capture *api.Capture) (*netflow.Exporter, error) {
+	if capture.Export == "" {
+		return nil, nil
+	}
+	return netflow.NewExporter(capture.Export)
+}
+
+// captureTimeouts returns the active and idle timeouts of the flows of a
+// capture, the ones of the configuration when not set
+func captureTimeouts(capture *api.Capture) (active, idle time.Duration) {
+	active, idle = config.GetAgentActiveTimeout(), config.GetAgentIdleTimeout()
+	if capture.ActiveTimeout > 0 {
+		active = time.Duration(capture.ActiveTimeout) * time.Second
+	}
+	if capture.IdleTimeout > 0 {
+		idle = time.Duration(capture.IdleTimeout) * time.Second
+	}
+	return
+}
+
+// registerExpire makes the flows of the table expire with the timeouts,
+// checked as often as the shortest of them
+func registerExpire(ft *flow.Table, fn flow.ExpireUpdateFunc, active, idle time.Duration) {
+	ft.SetActiveTimeout(active)
+
+	every := idle
+	if active > 0 && active < every {
+		every = active
 	}
+	ft.RegisterExpire(fn, every, idle)
 }
 
 func IsCaptureAllowed(n *graph.Node) bool {
 	switch n.Metadata()["Type"] {
-	case "device", "ovsbridge", "internal", "veth", "tun", "bridge":
+	case "device", "ovsbridge", "internal", "patch", "switchport", "veth", "tun", "bridge", "dpdk", "dpdkvhostuser", "dpdkvhostuserclient":
 		return true
 	}
 	return false
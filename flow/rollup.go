@@ -0,0 +1,313 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// dimensions the flows are rolled up by
+const (
+	RollupApplication = "application"
+	RollupHostPair    = "hostpair"
+	RollupTenant      = "tenant"
+	RollupVLAN        = "vlan"
+)
+
+// counters of the flows not updated for this delay are forgotten
+const rollupCountersTTL = time.Hour
+
+// Rollup sums the traffic reported during a period by the flows sharing the
+// same key of a dimension, the TCP/80 application, the 10.0.0.1-10.0.0.2
+// host pair, ... Timestamp is the start of the period in seconds.
+type Rollup struct {
+	Timestamp int64
+	Host      string
+	Dimension string
+	Key       string
+	Flows     int64
+	Packets   int64
+	Bytes     int64
+}
+
+// RollupFlushFunc is called with the rollups of each ended period
+type RollupFlushFunc func(rollups []*Rollup)
+
+type rollupKey struct {
+	dimension string
+	key       string
+}
+
+// last counters of a flow, the rollups being fed with their increase
+type rollupCounters struct {
+	packets int64
+	bytes   int64
+	period  int64
+	seen    time.Time
+}
+
+// Rollups computes the rollups of the flows as they are updated, the
+// Tenant function returning the tenant of a flow
+type Rollups struct {
+	sync.Mutex
+	Dimensions []string
+	Tenant     func(f *Flow) string
+	host       string
+	period     time.Duration
+	flush      RollupFlushFunc
+	start      int64
+	counters   map[string]*rollupCounters
+	current    map[rollupKey]*Rollup
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// applicationKey returns the transport protocol and the lowest port of
+// the flow, most likely the one of the service
+func applicationKey(f *Flow) string {
+	for _, t := range []FlowEndpointType{FlowEndpointType_TCPPORT, FlowEndpointType_UDPPORT, FlowEndpointType_SCTPPORT} {
+		ep := f.GetStatistics().GetEndpointsType(t)
+		if ep == nil || ep.AB == nil || ep.BA == nil {
+			continue
+		}
+
+		a, _ := strconv.Atoi(ep.AB.Value)
+		b, _ := strconv.Atoi(ep.BA.Value)
+		if b < a {
+			a = b
+		}
+		return fmt.Sprintf("%s/%d", strings.TrimSuffix(t.String(), "PORT"), a)
+	}
+	return ""
+}
+
+func hostPairKey(f *Flow) string {
+	ep := f.GetStatistics().GetNetworkEndpoints()
+	if ep == nil || ep.AB == nil || ep.BA == nil {
+		return ""
+	}
+
+	if ep.BA.Value < ep.AB.Value {
+		return ep.BA.Value + "-" + ep.AB.Value
+	}
+	return ep.AB.Value + "-" + ep.BA.Value
+}
+
+func (r *Rollups) key(dimension string, f *Flow) string {
+	switch dimension {
+	case RollupApplication:
+		return applicationKey(f)
+	case RollupHostPair:
+		return hostPairKey(f)
+	case RollupTenant:
+		if r.Tenant != nil {
+			return r.Tenant(f)
+		}
+	case RollupVLAN:
+		if len(f.VLANs) > 0 {
+			return strconv.FormatUint(uint64(f.VLANs[0]), 10)
+		}
+	}
+	return ""
+}
+
+// rotate returns the rollups of the period ended before now, if any
+func (r *Rollups) rotate(now time.Time) []*Rollup {
+	start := now.Truncate(r.period).Unix()
+	if start == r.start {
+		return nil
+	}
+
+	var rollups []*Rollup
+	for _, ru := range r.current {
+		rollups = append(rollups, ru)
+	}
+	sort.Sort(rollupsByKey(rollups))
+
+	r.current = make(map[rollupKey]*Rollup)
+	r.start = start
+
+	for uuid, c := range r.counters {
+		if now.Sub(c.seen) > rollupCountersTTL {
+			delete(r.counters, uuid)
+		}
+	}
+
+	return rollups
+}
+
+func (r *Rollups) update(flows []*Flow, now time.Time) []*Rollup {
+	r.Lock()
+	defer r.Unlock()
+
+	rollups := r.rotate(now)
+
+	for _, f := range flows {
+		if f.GetStatistics() == nil {
+			continue
+		}
+		packets, bytes := f.Statistics.GetCounters()
+
+		c, ok := r.counters[f.UUID]
+		if !ok {
+			c = &rollupCounters{period: -1}
+			r.counters[f.UUID] = c
+		}
+
+		// the counters of a flow restarted by an agent start from zero
+		dp, db := packets-c.packets, bytes-c.bytes
+		if dp < 0 || db < 0 {
+			dp, db = packets, bytes
+		}
+		c.packets, c.bytes, c.seen = packets, bytes, now
+
+		if f.ExpiredReason != "" {
+			delete(r.counters, f.UUID)
+		}
+
+		if dp == 0 && db == 0 {
+			continue
+		}
+
+		counted := c.period == r.start
+		c.period = r.start
+
+		for _, d := range r.Dimensions {
+			key := r.key(d, f)
+			if key == "" {
+				continue
+			}
+
+			ru, ok := r.current[rollupKey{d, key}]
+			if !ok {
+				ru = &Rollup{Timestamp: r.start, Host: r.host, Dimension: d, Key: key}
+				r.current[rollupKey{d, key}] = ru
+			}
+
+			if !counted {
+				ru.Flows++
+			}
+			ru.Packets += dp
+			ru.Bytes += db
+		}
+	}
+
+	return rollups
+}
+
+// Update feeds the rollups of the current period with the traffic of the
+// flows since their previous update
+func (r *Rollups) Update(flows []*Flow) {
+	if rollups := r.update(flows, time.Now()); len(rollups) > 0 {
+		r.flush(rollups)
+	}
+}
+
+func (r *Rollups) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if rollups := r.update(nil, now); len(rollups) > 0 {
+				r.flush(rollups)
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+func (r *Rollups) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+func (r *Rollups) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+type rollupsByKey []*Rollup
+
+func (s rollupsByKey) Len() int      { return len(s) }
+func (s rollupsByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rollupsByKey) Less(i, j int) bool {
+	if s[i].Dimension != s[j].Dimension {
+		return s[i].Dimension < s[j].Dimension
+	}
+	return s[i].Key < s[j].Key
+}
+
+func NewRollups(period time.Duration, dimensions []string, fn RollupFlushFunc) *Rollups {
+	host, _ := os.Hostname()
+
+	r := &Rollups{
+		Dimensions: dimensions,
+		host:       host,
+		period:     period,
+		flush:      fn,
+		counters:   make(map[string]*rollupCounters),
+		current:    make(map[rollupKey]*Rollup),
+		quit:       make(chan bool),
+	}
+	r.start = time.Now().Truncate(period).Unix()
+
+	return r
+}
+
+// NewRollupsFromConfig returns the rollups of the analyzer.rollup section,
+// nil if disabled
+func NewRollupsFromConfig(fn RollupFlushFunc) (*Rollups, error) {
+	cfg := config.GetConfig()
+
+	period := time.Duration(cfg.GetInt("analyzer.rollup.period")) * time.Second
+	if period <= 0 {
+		return nil, nil
+	}
+
+	dimensions := cfg.GetStringSlice("analyzer.rollup.dimensions")
+	for _, d := range dimensions {
+		switch d {
+		case RollupApplication, RollupHostPair, RollupTenant, RollupVLAN:
+		default:
+			return nil, fmt.Errorf("Unknown rollup dimension: %s", d)
+		}
+	}
+
+	logging.GetLogger().Infof("Flows rolled up every %v by %s", period, strings.Join(dimensions, ", "))
+
+	return NewRollups(period, dimensions, fn), nil
+}
@@ -40,10 +40,6 @@ type TableServer struct {
 }
 
 func (s *TableServer) OnMessage(msg shttp.WSMessage) {
-	if msg.Namespace != Namespace {
-		return
-	}
-
 	var query TableQuery
 
 	// decode query obj depending on the msg type
@@ -56,6 +52,14 @@ func (s *TableServer) OnMessage(msg shttp.WSMessage) {
 			logging.GetLogger().Errorf("Unable to decode search flow message %v", msg)
 			return
 		}
+	case "FlowPacketsQuery":
+		query.Obj = &FlowPacketsQuery{}
+
+		err := json.Unmarshal([]byte(*msg.Obj), &query)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to decode flow packets message %v", msg)
+			return
+		}
 	}
 
 	b, _ := json.Marshal(s.TableAllocator.QueryTable(&query))
@@ -76,7 +80,7 @@ func NewServer(allocator *TableAllocator, client *shttp.WSAsyncClient) *TableSer
 		TableAllocator: allocator,
 		WSAsyncClient:  client,
 	}
-	client.AddEventHandler(s)
+	client.AddEventHandler(s, Namespace)
 
 	return s
 }
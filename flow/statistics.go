@@ -26,6 +26,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 
 	"github.com/google/gopacket"
@@ -54,6 +55,30 @@ func NewFlowStatistics(packet *gopacket.Packet) *FlowStatistics {
 	return fs
 }
 
+// NewFlowEndpointsStatistics returns the statistics of the endpoints ab and
+// ba, either net.HardwareAddr, net.IP or layers.{TCP,UDP,SCTP}Port values
+func NewFlowEndpointsStatistics(t FlowEndpointType, ab interface{}, ba interface{}) *FlowEndpointsStatistics {
+	ep := &FlowEndpointsStatistics{
+		Type: t,
+		AB:   &FlowEndpointStatistics{Value: endpointValue(ab)},
+		BA:   &FlowEndpointStatistics{Value: endpointValue(ba)},
+	}
+	ep.hash(ab, ba)
+	return ep
+}
+
+func endpointValue(v interface{}) string {
+	switch v.(type) {
+	case layers.TCPPort:
+		return strconv.Itoa(int(v.(layers.TCPPort)))
+	case layers.UDPPort:
+		return strconv.Itoa(int(v.(layers.UDPPort)))
+	case layers.SCTPPort:
+		return strconv.Itoa(int(v.(layers.SCTPPort)))
+	}
+	return fmt.Sprintf("%s", v)
+}
+
 func (fs *FlowStatistics) Update(packet *gopacket.Packet) {
 	err := fs.updateLinkLayerStatistics(packet)
 	if err != nil {
@@ -135,42 +160,82 @@ func (fs *FlowStatistics) updateLinkLayerStatistics(packet *gopacket.Packet) err
 	return nil
 }
 
+// GetNetworkEndpoints returns the IPv4 or the IPv6 endpoints of the flow
+func (fs *FlowStatistics) GetNetworkEndpoints() *FlowEndpointsStatistics {
+	if ep := fs.GetEndpointsType(FlowEndpointType_IPV4); ep != nil {
+		return ep
+	}
+	return fs.GetEndpointsType(FlowEndpointType_IPV6)
+}
+
+// GetCounters returns the packets and bytes of the outer layer of the flow,
+// both directions
+func (fs *FlowStatistics) GetCounters() (packets int64, bytes int64) {
+	for _, ep := range fs.GetEndpoints() {
+		ab, ba := ep.GetAB(), ep.GetBA()
+		if ab == nil || ba == nil {
+			continue
+		}
+		return int64(ab.Packets + ba.Packets), int64(ab.Bytes + ba.Bytes)
+	}
+	return 0, 0
+}
+
+// networkLayer returns the type, the addresses and the length of the IPv4
+// or IPv6 layer of a packet, the IPv6 extension headers being part of the
+// payload
+func networkLayer(packet *gopacket.Packet) (FlowEndpointType, net.IP, net.IP, uint64, error) {
+	switch l := (*packet).NetworkLayer().(type) {
+	case *layers.IPv4:
+		return FlowEndpointType_IPV4, l.SrcIP, l.DstIP, uint64(l.Length), nil
+	case *layers.IPv6:
+		length := uint64(l.Length)
+		if length == 0 { // jumbogram
+			length = uint64(len(l.Payload))
+		}
+		return FlowEndpointType_IPV6, l.SrcIP, l.DstIP, uint64(len(l.Contents)) + length, nil
+	}
+	return 0, nil, nil, 0, errors.New("Unable to decode the network layer")
+}
+
 func (fs *FlowStatistics) newNetworkLayerEndpointStatistics(packet *gopacket.Packet) error {
 	ep := &FlowEndpointsStatistics{}
 	ep.AB = &FlowEndpointStatistics{}
 	ep.BA = &FlowEndpointStatistics{}
 
-	ipv4Layer := (*packet).Layer(layers.LayerTypeIPv4)
-	ipv4Packet, ok := ipv4Layer.(*layers.IPv4)
-	if !ok {
-		return errors.New("Unable to decode the ipv4 layer")
+	t, srcIP, dstIP, _, err := networkLayer(packet)
+	if err != nil {
+		return err
 	}
 
-	ep.Type = FlowEndpointType_IPV4
-	ep.AB.Value = ipv4Packet.SrcIP.String()
-	ep.BA.Value = ipv4Packet.DstIP.String()
-	ep.hash(ipv4Packet.SrcIP, ipv4Packet.DstIP)
+	ep.Type = t
+	ep.AB.Value = srcIP.String()
+	ep.BA.Value = dstIP.String()
+	ep.hash(srcIP, dstIP)
 	fs.Endpoints = append(fs.Endpoints, ep)
 	return nil
 }
 
 func (fs *FlowStatistics) updateNetworkLayerStatistics(packet *gopacket.Packet) error {
-	ipv4Layer := (*packet).Layer(layers.LayerTypeIPv4)
-	ipv4Packet, ok := ipv4Layer.(*layers.IPv4)
-	if !ok {
-		return errors.New("Unable to decode the ipv4 layer")
+	if len(fs.Endpoints) <= int(FlowEndpointLayer_NETWORK) {
+		return errors.New("Unable to decode the network layer")
+	}
+
+	_, srcIP, _, length, err := networkLayer(packet)
+	if err != nil {
+		return err
 	}
 
 	ep := fs.Endpoints[FlowEndpointLayer_NETWORK]
 
 	var e *FlowEndpointStatistics
-	if ep.AB.Value == ipv4Packet.SrcIP.String() {
+	if ep.AB.Value == srcIP.String() {
 		e = ep.AB
 	} else {
 		e = ep.BA
 	}
 	e.Packets += uint64(1)
-	e.Bytes += uint64(ipv4Packet.Length)
+	e.Bytes += length
 	return nil
 }
 
@@ -52,32 +52,66 @@ type FlowSearchReply struct {
 
 type sortByLast []*Flow
 
+// reasons of the expiry of the flows
+const (
+	// ExpiredIdle flows didn't see any packet during the expire window
+	ExpiredIdle = "idle"
+	// ExpiredActive flows lasted more than the active timeout, a new flow
+	// being then started by their next packet
+	ExpiredActive = "active"
+	// ExpiredClosed flows are TCP connections closed by a FIN or a RST
+	ExpiredClosed = "closed"
+)
+
 type FlowQueryFilter struct {
 	// TODO add more filter elements
 	ProbeNodeUUID string
 }
 
+// number of shards of a table, the flows being spread by the hash of their
+// key so that the lookups of different flows don't contend on the same lock
+const tableShards = 32
+
+type tableShard struct {
+	sync.RWMutex
+	table map[string]*Flow
+}
+
 type Table struct {
+	// protects the manager and the default func
 	lock        sync.RWMutex
-	table       map[string]*Flow
+	shards      [tableShards]*tableShard
 	manager     tableManager
 	defaultFunc func()
-	flush       chan bool
-	flushDone   chan bool
-	query       chan *TableQuery
-	reply       chan *TableReply
-	running     atomic.Value
-	wg          sync.WaitGroup
+	// resources shared with the other tables of the allocator, and the
+	// packets shed because of it
+	budget       *Budget
+	shedFlows    int64
+	shedPayloads int64
+	// accessed only by the table goroutine
+	packets       *packetRing
+	tcpStates     map[*Flow]*tcpState
+	activeTimeout int64
+	flush         chan bool
+	flushDone     chan bool
+	query         chan *TableQuery
+	reply         chan *TableReply
+	running       atomic.Value
+	wg            sync.WaitGroup
 }
 
 func NewTable() *Table {
-	return &Table{
-		table:     make(map[string]*Flow),
+	ft := &Table{
 		flush:     make(chan bool),
 		flushDone: make(chan bool),
 		query:     make(chan *TableQuery),
 		reply:     make(chan *TableReply),
+		tcpStates: make(map[*Flow]*tcpState),
 	}
+	for i := range ft.shards {
+		ft.shards[i] = &tableShard{table: make(map[string]*Flow)}
+	}
+	return ft
 }
 
 func NewTableFromFlows(flows []*Flow) *Table {
@@ -86,22 +120,61 @@ func NewTableFromFlows(flows []*Flow) *Table {
 	return nft
 }
 
+// shard returns the shard of a flow key, FNV-1a hashed inline to not
+// allocate for each packet
+func (ft *Table) shard(key string) *tableShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return ft.shards[h%tableShards]
+}
+
+// Shed returns the packets shed because of the budget, the ones of new flows
+// and the ones whose payload wasn't kept
+func (ft *Table) Shed() (int64, int64) {
+	return atomic.LoadInt64(&ft.shedFlows), atomic.LoadInt64(&ft.shedPayloads)
+}
+
+func (ft *Table) Len() int {
+	n := 0
+	for _, s := range ft.shards {
+		s.RLock()
+		n += len(s.table)
+		s.RUnlock()
+	}
+	return n
+}
+
 func (ft *Table) String() string {
-	ft.lock.RLock()
-	defer ft.lock.RUnlock()
-	return fmt.Sprintf("%d flows", len(ft.table))
+	return fmt.Sprintf("%d flows", ft.Len())
 }
 
 func (ft *Table) Update(flows []*Flow) {
-	ft.lock.Lock()
 	for _, f := range flows {
-		if _, ok := ft.table[f.UUID]; !ok {
-			ft.table[f.UUID] = f
+		s := ft.shard(f.UUID)
+		s.Lock()
+		if _, ok := s.table[f.UUID]; !ok {
+			s.table[f.UUID] = f
+			flowsGauge.Inc()
 		} else {
-			ft.table[f.UUID].Statistics = f.Statistics
+			s.table[f.UUID].Statistics = f.Statistics
+			s.table[f.UUID].ExpiredReason = f.ExpiredReason
 		}
+		s.Unlock()
+	}
+}
+
+// forEach calls fn for each flow, holding the read lock of its shard
+func (ft *Table) forEach(fn func(key string, f *Flow)) {
+	for _, s := range ft.shards {
+		s.RLock()
+		for key, f := range s.table {
+			fn(key, f)
+		}
+		s.RUnlock()
 	}
-	ft.lock.Unlock()
 }
 
 func matchQueryFilter(f *Flow, filter *FlowQueryFilter) bool {
@@ -113,37 +186,52 @@ func matchQueryFilter(f *Flow, filter *FlowQueryFilter) bool {
 }
 
 func (ft *Table) GetFlows(filters ...FlowQueryFilter) []*Flow {
-	ft.lock.RLock()
-	defer ft.lock.RUnlock()
-
-	flows := make([]*Flow, 0, len(ft.table))
-	for _, f := range ft.table {
+	flows := []*Flow{}
+	ft.forEach(func(key string, f *Flow) {
 		if len(filters) == 0 || matchQueryFilter(f, &filters[0]) {
 			flows = append(flows, &*f)
 		}
-	}
+	})
 	return flows
 }
 
 func (ft *Table) GetFlow(key string) *Flow {
-	ft.lock.RLock()
-	defer ft.lock.RUnlock()
-	if flow, found := ft.table[key]; found {
+	s := ft.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	if flow, found := s.table[key]; found {
 		return flow
 	}
 
 	return nil
 }
 
+// GetOrCreateFlow returns the flow of a key, creating it if needed, nil if
+// the flows are over the budget of the table
 func (ft *Table) GetOrCreateFlow(key string) (*Flow, bool) {
-	ft.lock.Lock()
-	defer ft.lock.Unlock()
-	if flow, found := ft.table[key]; found {
+	s := ft.shard(key)
+
+	s.RLock()
+	flow, found := s.table[key]
+	s.RUnlock()
+	if found {
 		return flow, false
 	}
 
+	s.Lock()
+	defer s.Unlock()
+	if flow, found := s.table[key]; found {
+		return flow, false
+	}
+
+	if !ft.budget.reserveFlow() {
+		atomic.AddInt64(&ft.shedFlows, 1)
+		return nil, false
+	}
+
 	new := &Flow{}
-	ft.table[key] = new
+	s.table[key] = new
+	flowsGauge.Inc()
 
 	return new, true
 }
@@ -152,29 +240,25 @@ func (ft *Table) GetOrCreateFlow(key string) (*Flow, bool) {
 func (ft *Table) FilterLast(last time.Duration) []*Flow {
 	var flows []*Flow
 	selected := time.Now().Unix() - int64((last).Seconds())
-	ft.lock.RLock()
-	for _, f := range ft.table {
+	ft.forEach(func(key string, f *Flow) {
 		fs := f.GetStatistics()
 		if fs.Last >= selected {
 			flows = append(flows, f)
 		}
-	}
-	ft.lock.RUnlock()
+	})
 	return flows
 }
 
 func (ft *Table) SelectLayer(endpointType FlowEndpointType, list []string) []*Flow {
 	meth := make(map[string][]*Flow)
-	ft.lock.RLock()
-	for _, f := range ft.table {
+	ft.forEach(func(key string, f *Flow) {
 		layerFlow := f.GetStatistics().GetEndpointsType(endpointType)
 		if layerFlow == nil || layerFlow.AB.Value == "ff:ff:ff:ff:ff:ff" || layerFlow.BA.Value == "ff:ff:ff:ff:ff:ff" {
-			continue
+			return
 		}
 		meth[layerFlow.AB.Value] = append(meth[layerFlow.AB.Value], f)
 		meth[layerFlow.BA.Value] = append(meth[layerFlow.BA.Value], f)
-	}
-	ft.lock.RUnlock()
+	})
 
 	mflows := make(map[*Flow]struct{})
 	var flows []*Flow
@@ -196,48 +280,103 @@ func (ft *Table) SelectLayer(endpointType FlowEndpointType, list []string) []*Fl
  */
 func (ft *Table) Expire(now time.Time) {
 	timepoint := now.Unix() - int64((ft.manager.expire.duration).Seconds())
-	ft.lock.Lock()
+	ft.lock.RLock()
 	ft.expire(ft.manager.expire.callback, timepoint)
-	ft.lock.Unlock()
+	ft.lock.RUnlock()
+}
+
+// SetActiveTimeout makes the flows lasting more than timeout expire, 0
+// disabling it. Must be called before the start of the table.
+func (ft *Table) SetActiveTimeout(timeout time.Duration) {
+	ft.activeTimeout = int64(timeout.Seconds())
+}
+
+// endedReason returns why a flow ended, either closed or expired by the
+// agent it comes from, empty if still alive
+func (ft *Table) endedReason(f *Flow) string {
+	if f.ExpiredReason != "" {
+		return f.ExpiredReason
+	}
+	if state, ok := ft.tcpStates[f]; ok && state.closed {
+		return ExpiredClosed
+	}
+	return ""
 }
 
-/* Internal call only, Must be called under ft.lock.Lock() */
 func (ft *Table) expire(fn ExpireUpdateFunc, expireBefore int64) {
-	var expiredFlows []*Flow
-	flowTableSzBefore := len(ft.table)
-	for _, f := range ft.table {
+	ft.expireFlows(fn, func(f *Flow) string {
+		if reason := ft.endedReason(f); reason != "" {
+			return reason
+		}
+
 		fs := f.GetStatistics()
 		if fs.Last < expireBefore {
-			duration := time.Duration(fs.Last - fs.Start)
-			logging.GetLogger().Debugf("Expire flow %s Duration %v", f.UUID, duration)
+			return ExpiredIdle
+		}
+		if ft.activeTimeout > 0 && fs.Last-fs.Start >= ft.activeTimeout {
+			return ExpiredActive
+		}
+		return ""
+	})
+}
+
+// expireFlows removes the flows for which reason returns a reason of
+// expiry and calls fn with them
+func (ft *Table) expireFlows(fn ExpireUpdateFunc, reason func(f *Flow) string) {
+	var expiredFlows []*Flow
+	var expiredKeys []string
+	ft.forEach(func(key string, f *Flow) {
+		if r := reason(f); r != "" {
+			fs := f.GetStatistics()
+			duration := time.Duration(fs.Last-fs.Start) * time.Second
+			logging.GetLogger().Debugf("Expire flow %s Duration %v Reason %s", f.UUID, duration, r)
+			f.ExpiredReason = r
 			expiredFlows = append(expiredFlows, f)
+			expiredKeys = append(expiredKeys, key)
 		}
+	})
+	if len(expiredFlows) == 0 {
+		return
 	}
+
 	/* Advise Clients */
 	fn(expiredFlows)
 	for _, f := range expiredFlows {
-		delete(ft.table, f.UUID)
+		delete(ft.tcpStates, f)
+		flowsExpired.WithLabelValues(f.ExpiredReason).Inc()
+	}
+	for _, key := range expiredKeys {
+		s := ft.shard(key)
+		s.Lock()
+		if _, ok := s.table[key]; ok {
+			delete(s.table, key)
+			flowsGauge.Dec()
+			ft.budget.releaseFlows(1)
+		}
+		s.Unlock()
 	}
-	flowTableSz := len(ft.table)
-	logging.GetLogger().Debugf("Expire Flow : removed %v ; new size %v", flowTableSzBefore-flowTableSz, flowTableSz)
+	logging.GetLogger().Debugf("Expire Flow : removed %v ; new size %v", len(expiredKeys), ft.Len())
 }
 
 func (ft *Table) Updated(now time.Time) {
 	timepoint := now.Unix() - int64((ft.manager.updated.duration).Seconds())
 	ft.lock.RLock()
+	// the ended flows are flushed with the updates, not waiting to be idle
+	if ft.manager.expire.running {
+		ft.expireFlows(ft.manager.expire.callback, ft.endedReason)
+	}
 	ft.updated(ft.manager.updated.callback, timepoint)
 	ft.lock.RUnlock()
 }
 
-/* Internal call only, Must be called under ft.lock.RLock() */
 func (ft *Table) updated(fn ExpireUpdateFunc, updateFrom int64) {
 	var updatedFlows []*Flow
-	for _, f := range ft.table {
+	ft.forEach(func(key string, f *Flow) {
 		fs := f.GetStatistics()
 		if fs.Last > updateFrom {
 			updatedFlows = append(updatedFlows, f)
 		}
-	}
+	})
 	/* Advise Clients */
 	fn(updatedFlows)
 	logging.GetLogger().Debugf("Send updated Flow %d", len(updatedFlows))
@@ -245,9 +384,9 @@ func (ft *Table) updated(fn ExpireUpdateFunc, updateFrom int64) {
 
 func (ft *Table) expireNow() {
 	const Now = int64(^uint64(0) >> 1)
-	ft.lock.Lock()
+	ft.lock.RLock()
 	ft.expire(ft.manager.expire.callback, Now)
-	ft.lock.Unlock()
+	ft.lock.RUnlock()
 }
 
 /* Asynchrnously Register an expire callback fn with last updated flow 'since', each 'since' tick  */
@@ -333,6 +472,8 @@ func (ft *Table) onQuery(q *TableQuery) *TableReply {
 	switch q.Obj.(type) {
 	case *FlowSearchQuery:
 		obj, status = ft.onFlowSearchQueryMessage(q.Obj)
+	case *FlowPacketsQuery:
+		obj, status = ft.onFlowPacketsQueryMessage(q.Obj)
 	}
 
 	return &TableReply{
@@ -24,11 +24,15 @@ package flow
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"crypto/sha1"
 	"encoding/hex"
+
+	"github.com/google/gopacket/layers"
 )
 
 func TestNewTable(t *testing.T) {
@@ -51,8 +55,8 @@ func TestTable_Update(t *testing.T) {
 	ft := NewTestFlowTableSimple(t)
 	/* simulate a collision */
 	f := &Flow{}
-	ft.table["789"] = f
-	ft.table["789"].UUID = "78910"
+	ft.shard("789").table["789"] = f
+	f.UUID = "78910"
 	f = &Flow{}
 	f.UUID = "789"
 	ft.Update([]*Flow{f})
@@ -117,6 +121,62 @@ func TestTable_updated(t *testing.T) {
 	}
 }
 
+func TestTable_expireReasons(t *testing.T) {
+	ft := NewTable()
+	ft.SetActiveTimeout(time.Minute)
+	ft.Update([]*Flow{
+		{UUID: "active", Statistics: &FlowStatistics{Start: 0, Last: 100}},
+		{UUID: "alive", Statistics: &FlowStatistics{Start: 90, Last: 100}},
+		{UUID: "idle", Statistics: &FlowStatistics{Start: 10, Last: 20}},
+		{UUID: "ended", Statistics: &FlowStatistics{Start: 90, Last: 100}, ExpiredReason: ExpiredClosed},
+	})
+
+	reasons := make(map[string]string)
+	ft.expire(func(flows []*Flow) {
+		for _, f := range flows {
+			reasons[f.UUID] = f.ExpiredReason
+		}
+	}, 50)
+
+	expected := map[string]string{"active": ExpiredActive, "idle": ExpiredIdle, "ended": ExpiredClosed}
+	if len(reasons) != len(expected) {
+		t.Fatalf("Expected %d expired flows, got %v", len(expected), reasons)
+	}
+	for uuid, reason := range expected {
+		if reasons[uuid] != reason {
+			t.Errorf("Flow %s should have expired as %s, got %s", uuid, reason, reasons[uuid])
+		}
+	}
+	if ft.Len() != 1 || ft.GetFlow("alive") == nil {
+		t.Errorf("Only the alive flow should be kept, got %s", ft.String())
+	}
+}
+
+func TestTable_expireClosed(t *testing.T) {
+	ft := NewTable()
+	now := time.Now()
+
+	FlowFromGoPacket(ft, forgeTCPPacket(t, now, false, &layers.TCP{SYN: true, Seq: 100}, nil), nil)
+	FlowFromGoPacket(ft, forgeTCPPacket(t, now, false, &layers.TCP{FIN: true, ACK: true, Seq: 101}, nil), nil)
+
+	var expired []*Flow
+	fn := func(flows []*Flow) { expired = append(expired, flows...) }
+
+	ft.expireFlows(fn, ft.endedReason)
+	if len(expired) != 0 {
+		t.Fatal("A half closed flow shouldn't be flushed")
+	}
+
+	FlowFromGoPacket(ft, forgeTCPPacket(t, now, true, &layers.TCP{FIN: true, ACK: true, Seq: 500}, nil), nil)
+	ft.expireFlows(fn, ft.endedReason)
+	if len(expired) != 1 || expired[0].ExpiredReason != ExpiredClosed {
+		t.Fatalf("The closed flow should be flushed, got %v", expired)
+	}
+	if ft.Len() != 0 || len(ft.tcpStates) != 0 {
+		t.Errorf("The closed flow should be removed, %d flows left", ft.Len())
+	}
+}
+
 func TestTable_AsyncExpire(t *testing.T) {
 	t.Skip()
 }
@@ -142,6 +202,79 @@ func TestTable_LookupFlowByProbePath(t *testing.T) {
 	}
 }
 
+func TestTable_RawPackets(t *testing.T) {
+	ft := NewTable()
+	ft.SetRawPacketLimit(15)
+	GenerateTestFlows(t, ft, 1, "probe1")
+	flows := GenerateTestFlows(t, ft, 2, "probe2")
+
+	reply, status := ft.onFlowPacketsQueryMessage(&FlowPacketsQuery{ProbeNodeUUID: "probe1"})
+	if status != 200 || len(reply.Packets) != 5 {
+		t.Errorf("Only the last 5 packets of probe1 should be kept, got %d", len(reply.Packets))
+	}
+
+	reply, status = ft.onFlowPacketsQueryMessage(&FlowPacketsQuery{FlowUUID: flows[0].UUID})
+	if status != 200 || len(reply.Packets) != 1 {
+		t.Errorf("One packet expected for the flow %s, got %d", flows[0].UUID, len(reply.Packets))
+	}
+}
+
+func TestTable_Budget(t *testing.T) {
+	const MaxInt64 = int64(^uint64(0) >> 1)
+	a := NewTableAllocator()
+	a.Budget = NewBudget(5, 0)
+	ft := a.Alloc()
+
+	for i := 0; i < 7; i++ {
+		f, _ := ft.GetOrCreateFlow(fmt.Sprintf("flow%d", i))
+		if (f == nil) != (i >= 5) {
+			t.Errorf("Only 5 flows should be created, got flow %d: %v", i, f)
+		}
+		if f != nil {
+			f.Statistics = &FlowStatistics{}
+		}
+	}
+	if shed, _ := ft.Shed(); shed != 2 || a.Budget.Flows() != 5 {
+		t.Errorf("2 packets should be shed, got %d, with 5 flows, got %d", shed, a.Budget.Flows())
+	}
+
+	fc := MyTestFlowCounter{}
+	ft.expire(fc.countFlowsCallback, MaxInt64)
+	if a.Budget.Flows() != 0 {
+		t.Errorf("The expired flows should be released, got %d", a.Budget.Flows())
+	}
+	if f, _ := ft.GetOrCreateFlow("flow5"); f == nil {
+		t.Error("A flow should be created once the others expired")
+	}
+}
+
+func TestTable_PacketBudget(t *testing.T) {
+	a := NewTableAllocator()
+	a.Budget = NewBudget(0, 1)
+	ft := a.Alloc()
+	ft.SetRawPacketLimit(15)
+	GenerateTestFlows(t, ft, 1, "probe1")
+
+	if _, status := ft.onFlowPacketsQueryMessage(&FlowPacketsQuery{ProbeNodeUUID: "probe1"}); status != 404 {
+		t.Errorf("No packet should be kept, got status %d", status)
+	}
+	if _, shed := ft.Shed(); shed != 10 {
+		t.Errorf("The 10 payloads should be shed, got %d", shed)
+	}
+
+	a.Budget = NewBudget(0, 0)
+	ft = a.Alloc()
+	ft.SetRawPacketLimit(15)
+	GenerateTestFlows(t, ft, 1, "probe1")
+	if a.Budget.Bytes() == 0 {
+		t.Error("The payloads kept should be accounted")
+	}
+	a.Release(ft)
+	if a.Budget.Bytes() != 0 {
+		t.Errorf("The payloads should be released with the table, got %d bytes", a.Budget.Bytes())
+	}
+}
+
 func TestTable_GetFlow(t *testing.T) {
 	ft := NewTestFlowTableSimple(t)
 	flow := &Flow{}
@@ -178,23 +311,52 @@ func TestTable_GetOrCreateFlow(t *testing.T) {
 	}
 }
 
+func TestTable_ConcurrentGetOrCreateFlow(t *testing.T) {
+	ft := NewTable()
+
+	var wg sync.WaitGroup
+	var created int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 1000; k++ {
+				if f, new := ft.GetOrCreateFlow(fmt.Sprintf("key%d", k)); new {
+					f.Statistics = &FlowStatistics{}
+					atomic.AddInt64(&created, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1000 || ft.Len() != 1000 {
+		t.Errorf("Each flow should be created once, got %d creations for %d flows", created, ft.Len())
+	}
+
+	ft.expire(func(flows []*Flow) {}, int64(^uint64(0)>>1))
+	if ft.Len() != 0 {
+		t.Errorf("All the flows should be expired, %d left", ft.Len())
+	}
+}
+
 func TestTable_NewTableFromFlows(t *testing.T) {
 	ft := NewTestFlowTableComplex(t)
 	var flows []*Flow
-	for _, f := range ft.table {
+	for _, f := range ft.GetFlows() {
 		flow := *f
 		flows = append(flows, &flow)
 	}
 	ft2 := NewTableFromFlows(flows)
-	if len(ft.table) != len(ft2.table) {
+	if ft.Len() != ft2.Len() {
 		t.Error("NewFlowTable(copy) are not the same size")
 	}
 	flows = flows[:0]
-	for _, f := range ft.table {
+	for _, f := range ft.GetFlows() {
 		flows = append(flows, f)
 	}
 	ft3 := NewTableFromFlows(flows)
-	if len(ft.table) != len(ft3.table) {
+	if ft.Len() != ft3.Len() {
 		t.Error("NewFlowTable(ref) are not the same size")
 	}
 }
@@ -202,7 +364,7 @@ func TestTable_NewTableFromFlows(t *testing.T) {
 func TestTable_FilterLast(t *testing.T) {
 	ft := NewTestFlowTableComplex(t)
 	/* hack to put the FlowTable 1 second older */
-	for _, f := range ft.table {
+	for _, f := range ft.GetFlows() {
 		fs := f.GetStatistics()
 		fs.Start -= int64(1)
 		fs.Last -= int64(1)
@@ -222,16 +384,16 @@ func TestTable_SelectLayer(t *testing.T) {
 
 	var macs []string
 	flows := ft.SelectLayer(FlowEndpointType_ETHERNET, macs)
-	if len(ft.table) <= len(flows) && len(flows) != 0 {
-		t.Errorf("SelectLayer should select none flows %d %d", len(ft.table), len(flows))
+	if ft.Len() <= len(flows) && len(flows) != 0 {
+		t.Errorf("SelectLayer should select none flows %d %d", ft.Len(), len(flows))
 	}
 
 	for mac := 0; mac < 0xff; mac++ {
 		macs = append(macs, fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", 0x00, 0x0F, 0xAA, 0xFA, 0xAA, mac))
 	}
 	flows = ft.SelectLayer(FlowEndpointType_ETHERNET, macs)
-	if len(ft.table) != len(flows) {
-		t.Errorf("SelectLayer should select all flows %d %d", len(ft.table), len(flows))
+	if ft.Len() != len(flows) {
+		t.Errorf("SelectLayer should select all flows %d %d", ft.Len(), len(flows))
 	}
 }
 
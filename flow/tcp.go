@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tcpDirection is the next sequence number expected from one endpoint
+type tcpDirection struct {
+	started bool
+	nextSeq uint32
+	fin     bool
+}
+
+// tcpState tracks the handshake and the sequence numbers of a TCP flow to
+// compute its round trip time and count its retransmissions, and its
+// teardown, the closed flows being flushed without waiting to be idle
+type tcpState struct {
+	synTime    time.Time
+	synAckSeen bool
+	ab, ba     tcpDirection
+	closed     bool
+}
+
+func packetTime(packet *gopacket.Packet) time.Time {
+	if md := (*packet).Metadata(); md != nil && !md.Timestamp.IsZero() {
+		return md.Timestamp
+	}
+	return time.Now()
+}
+
+// seqAfter compares sequence numbers, wrapping around
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// update returns whether the segment is a retransmission
+func (d *tcpDirection) update(tcp *layers.TCP) bool {
+	length := uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		length++
+	}
+	if length == 0 {
+		return false
+	}
+
+	end := tcp.Seq + length
+	if d.started && !seqAfter(end, d.nextSeq) {
+		return true
+	}
+
+	d.started, d.nextSeq = true, end
+	return false
+}
+
+// trackTCP updates the round trip time and the retransmissions of the flow
+// of a TCP packet. The round trip time is the delay between the SYN and
+// the ACK of the handshake, as seen by the probe.
+func (ft *Table) trackTCP(flow *Flow, packet *gopacket.Packet) {
+	tcp, ok := (*packet).Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		return
+	}
+
+	ep := flow.GetStatistics().GetEndpointsType(FlowEndpointType_TCPPORT)
+	if ep == nil {
+		return
+	}
+
+	state, ok := ft.tcpStates[flow]
+	if !ok {
+		state = &tcpState{}
+		ft.tcpStates[flow] = state
+	}
+
+	direction, e := &state.ab, ep.AB
+	if ep.AB.Value != strconv.Itoa(int(tcp.SrcPort)) {
+		direction, e = &state.ba, ep.BA
+	}
+
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		if state.synTime.IsZero() {
+			state.synTime = packetTime(packet)
+		}
+	case tcp.SYN && tcp.ACK:
+		state.synAckSeen = !state.synTime.IsZero()
+	case tcp.ACK && state.synAckSeen && flow.Statistics.RTT == 0:
+		rtt := packetTime(packet).Sub(state.synTime)
+		if rtt > 0 {
+			flow.Statistics.RTT = int64(rtt)
+			tcpRTT.Observe(rtt.Seconds())
+		}
+	}
+
+	if direction.update(tcp) {
+		e.Retransmissions++
+		tcpRetransmissions.Inc()
+	}
+
+	direction.fin = direction.fin || tcp.FIN
+	if tcp.RST || (state.ab.fin && state.ba.fin) {
+		state.closed = true
+	}
+}
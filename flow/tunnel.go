@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const genevePort = 6081
+
+// tunnel is the encapsulation of a frame in a VXLAN, GRE or Geneve packet
+type tunnel struct {
+	Type    string
+	ID      uint32
+	Payload []byte
+}
+
+// geneveTunnel decodes the Geneve header of an UDP payload, gopacket not
+// supporting this protocol
+func geneveTunnel(udp *layers.UDP) *tunnel {
+	if udp.DstPort != genevePort || len(udp.Payload) < 8 {
+		return nil
+	}
+
+	data := udp.Payload
+	length := 8 + int(data[0]&0x3f)*4
+	if len(data) < length || layers.EthernetType(binary.BigEndian.Uint16(data[2:4])) != layers.EthernetTypeTransparentEthernetBridging {
+		return nil
+	}
+
+	return &tunnel{
+		Type:    "Geneve",
+		ID:      uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6]),
+		Payload: data[length:],
+	}
+}
+
+// decodeTunnel returns the tunnel of a packet, only the tunnels carrying
+// Ethernet frames being supported
+func decodeTunnel(packet *gopacket.Packet) *tunnel {
+	for _, layer := range (*packet).Layers() {
+		switch l := layer.(type) {
+		case *layers.VXLAN:
+			return &tunnel{Type: "VXLAN", ID: l.VNI, Payload: l.Payload}
+		case *layers.GRE:
+			if l.Protocol != layers.EthernetTypeTransparentEthernetBridging {
+				return nil
+			}
+			return &tunnel{Type: "GRE", ID: l.Key, Payload: l.Payload}
+		case *layers.UDP:
+			if t := geneveTunnel(l); t != nil {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// layersPath returns the layers of a packet up to its tunnel layer, the
+// encapsulated layers being the ones of the inner flow. The neighbor
+// discovery messages are flagged as NDP.
+func layersPath(packet *gopacket.Packet) string {
+	path := ""
+	for i, layer := range (*packet).Layers() {
+		if i > 0 {
+			path += "/"
+		}
+		path += layer.LayerType().String()
+
+		switch l := layer.(type) {
+		case *layers.VXLAN, *layers.GRE:
+			return path
+		case *layers.UDP:
+			if geneveTunnel(l) != nil {
+				return path + "/Geneve"
+			}
+		case *layers.ICMPv6:
+			if isNDP(packet) {
+				return path + "/NDP"
+			}
+		}
+	}
+	return path
+}
+
+// innerFlowFromTunnel updates the flow of the frame encapsulated in a tunnel
+// packet, its key being scoped by the tunnel identifier
+func innerFlowFromTunnel(ft *Table, parent *Flow, t *tunnel, setter FlowProbeNodeSetter) *Flow {
+	inner := gopacket.NewPacket(t.Payload, layers.LayerTypeEthernet, gopacket.NoCopy)
+	if inner.NetworkLayer() == nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s-%d-%s", t.Type, t.ID, NewFlowKeyFromGoPacket(&inner).String())
+	flow := flowFromGoPacket(ft, key, &inner, setter)
+	if flow != nil {
+		flow.ParentUUID = parent.UUID
+	}
+	return flow
+}
@@ -23,13 +23,17 @@
 package http
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/abbot/go-http-auth"
+	"github.com/gorilla/context"
 	"github.com/redhat-cip/skydive/config"
 )
 
@@ -37,6 +41,30 @@ var (
 	WrongCredentials error = errors.New("Wrong credentials")
 )
 
+// Role of an authenticated user, the readers are only allowed to query the
+// topology and the flows while the admins can also modify the topology and
+// create captures or alerts
+type Role string
+
+const (
+	AdminRole  Role = "admin"
+	ReaderRole Role = "reader"
+)
+
+// Allows returns whether the role grants the permissions of the given one
+func (r Role) Allows(required Role) bool {
+	return r == AdminRole || r == required
+}
+
+// roleFromConfig returns the role of a user set in the auth.roles section,
+// or the default one
+func roleFromConfig(username string) Role {
+	if role, ok := config.GetConfig().GetStringMapString("auth.roles")[username]; ok {
+		return Role(role)
+	}
+	return Role(config.GetConfig().GetString("auth.default_role"))
+}
+
 type AuthenticationOpts struct {
 	Username string
 	Password string
@@ -48,10 +76,23 @@ type AuthenticationClient struct {
 	Addr          string
 	Port          int
 	AuthToken     string
+	// TLS configuration used to reach the server over HTTPS
+	TLSConfig *tls.Config
 }
 
 func (c *AuthenticationClient) getPrefix() string {
-	return fmt.Sprintf("http://%s:%d", c.Addr, c.Port)
+	host := net.JoinHostPort(c.Addr, strconv.Itoa(c.Port))
+	if c.TLSConfig != nil {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+func (c *AuthenticationClient) transport() http.RoundTripper {
+	if c.TLSConfig != nil {
+		return &http.Transport{TLSClientConfig: c.TLSConfig}
+	}
+	return http.DefaultTransport
 }
 
 func (c *AuthenticationClient) Authenticated() bool {
@@ -77,7 +118,7 @@ func (c *AuthenticationClient) Authenticate() error {
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := http.DefaultTransport.RoundTrip(req)
+	resp, err := c.transport().RoundTrip(req)
 	if err != nil {
 		return fmt.Errorf("Authentication failed: %s", err.Error())
 	}
@@ -109,6 +150,19 @@ func NewAuthenticationClient(addr string, port int, authOptions *AuthenticationO
 type AuthenticationBackend interface {
 	Authenticate(username string, password string) (string, error)
 	Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc
+	Role(username string) Role
+}
+
+// serveAuthenticated calls the handler with a copy of the request carrying
+// the context of the original one, so that the route variables are kept
+func serveAuthenticated(w http.ResponseWriter, r *http.Request, username string, wrapped auth.AuthenticatedHandlerFunc) {
+	ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
+	for k, v := range context.GetAll(r) {
+		context.Set(&ar.Request, k, v)
+	}
+	defer context.Clear(&ar.Request)
+
+	wrapped(w, ar)
 }
 
 func NewAuthenticationBackendFromConfig() (AuthenticationBackend, error) {
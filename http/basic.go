@@ -61,12 +61,15 @@ func (b *BasicAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc)
 		if username := b.CheckAuth(r); username == "" {
 			unauthorized(w, r)
 		} else {
-			ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
-			wrapped(w, ar)
+			serveAuthenticated(w, r, username, wrapped)
 		}
 	}
 }
 
+func (b *BasicAuthenticationBackend) Role(username string) Role {
+	return roleFromConfig(username)
+}
+
 func NewBasicAuthenticationBackend(file string) (*BasicAuthenticationBackend, error) {
 	if _, err := os.Stat(file); err != nil {
 		return nil, err
@@ -60,7 +60,18 @@ func NewRestClientFromConfig(authOptions *AuthenticationOpts) *RestClient {
 		return nil
 	}
 
-	return NewRestClient(addr, port, authOptions)
+	tlsConfig, err := TLSConfigFromConfig("agent")
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to load the TLS configuration %s", err.Error())
+		return nil
+	}
+
+	c := NewRestClient(addr, port, authOptions)
+	if tlsConfig != nil {
+		c.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		c.authClient.TLSConfig = tlsConfig
+	}
+	return c
 }
 
 func (c *RestClient) Request(method, path string, body io.Reader) (*http.Response, error) {
@@ -25,6 +25,7 @@ package http
 import (
 	"net/http"
 	"strings"
+	"sync"
 
 	auth "github.com/abbot/go-http-auth"
 	"github.com/rackspace/gophercloud"
@@ -35,8 +36,11 @@ import (
 )
 
 type KeystoneAuthenticationBackend struct {
+	sync.RWMutex
 	AuthURL string
 	Tenant  string
+	// roles of the users, updated at each token check
+	roles map[string]Role
 }
 
 func (b *KeystoneAuthenticationBackend) CheckUser(r *http.Request) (string, error) {
@@ -73,21 +77,32 @@ func (b *KeystoneAuthenticationBackend) CheckUser(r *http.Request) (string, erro
 		return "", WrongCredentials
 	}
 
-	isAdmin := false
+	// the keystone admins are skydive admins, the other users readers
+	userRole := ReaderRole
 	for _, role := range user.Roles {
 		if role.Name == "admin" {
-			isAdmin = true
+			userRole = AdminRole
 			break
 		}
 	}
 
-	if !isAdmin {
-		return "", WrongCredentials
-	}
+	b.Lock()
+	b.roles[user.UserName] = userRole
+	b.Unlock()
 
 	return user.UserName, nil
 }
 
+func (b *KeystoneAuthenticationBackend) Role(username string) Role {
+	b.RLock()
+	defer b.RUnlock()
+
+	if r, ok := b.roles[username]; ok {
+		return r
+	}
+	return ReaderRole
+}
+
 func (b *KeystoneAuthenticationBackend) Authenticate(username string, password string) (string, error) {
 	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: b.AuthURL,
@@ -116,8 +131,7 @@ func (b *KeystoneAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFu
 			}
 			unauthorized(w, r)
 		} else {
-			ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
-			wrapped(w, ar)
+			serveAuthenticated(w, r, username, wrapped)
 		}
 	}
 }
@@ -130,6 +144,7 @@ func NewKeystoneBackend(authURL string, tenant string) *KeystoneAuthenticationBa
 	return &KeystoneAuthenticationBackend{
 		AuthURL: authURL,
 		Tenant:  tenant,
+		roles:   make(map[string]Role),
 	}
 }
 
@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	wsClientsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "skydive_websocket_clients",
+		Help: "Number of WebSocket clients connected.",
+	}, []string{"service"})
+
+	wsDisconnectedClientsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "skydive_websocket_disconnected_clients_total",
+		Help: "Number of WebSocket clients disconnected for not reading their messages fast enough.",
+	}, []string{"service"})
+)
+
+// serveMetrics exposes the metrics of the process in the Prometheus format
+func serveMetrics(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	prometheus.Handler().ServeHTTP(w, &r.Request)
+}
+
+func init() {
+	prometheus.MustRegister(wsClientsGauge)
+	prometheus.MustRegister(wsDisconnectedClientsCounter)
+}
@@ -23,6 +23,7 @@
 package http
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"html/template"
@@ -57,9 +58,11 @@ type Server struct {
 	Addr    string
 	Port    int
 	Auth    AuthenticationBackend
-	lock    sync.Mutex
-	sl      *stoppableListener.StoppableListener
-	wg      sync.WaitGroup
+	// TLS configuration, the server only accepts plain HTTP when nil
+	TLSConfig *tls.Config
+	lock      sync.Mutex
+	sl        *stoppableListener.StoppableListener
+	wg        sync.WaitGroup
 }
 
 func (s *Server) RegisterRoutes(routes []Route) {
@@ -94,6 +97,10 @@ func (s *Server) ListenAndServe() {
 	}
 	s.lock.Unlock()
 
+	if s.TLSConfig != nil {
+		http.Serve(tls.NewListener(s.sl, s.TLSConfig), s.Router)
+		return
+	}
 	http.Serve(s.sl, s.Router)
 }
 
@@ -195,6 +202,19 @@ func unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("401 Unauthorized\n"))
 }
 
+// RequireRole returns a handler only serving the users having the role,
+// the others getting a 403 error
+func (s *Server) RequireRole(role Role, f auth.AuthenticatedHandlerFunc) auth.AuthenticatedHandlerFunc {
+	return func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if !s.Auth.Role(r.Username).Allows(role) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 Forbidden\n"))
+			return
+		}
+		f(w, r)
+	}
+}
+
 func (s *Server) HandleFunc(path string, f auth.AuthenticatedHandlerFunc) {
 	s.Router.HandleFunc(path, s.Auth.Wrap(f))
 }
@@ -214,6 +234,7 @@ func NewServer(s string, a string, p int, auth AuthenticationBackend) *Server {
 
 	router.HandleFunc("/login", server.serveLogin)
 	router.HandleFunc("/", auth.Wrap(server.serveIndex))
+	router.HandleFunc("/metrics", auth.Wrap(serveMetrics))
 
 	return server
 }
@@ -229,5 +250,13 @@ func NewServerFromConfig(s string) (*Server, error) {
 		return nil, errors.New("Configuration error: " + err.Error())
 	}
 
-	return NewServer(s, addr, port, auth), nil
+	tlsConfig, err := TLSConfigFromConfig(s)
+	if err != nil {
+		return nil, errors.New("TLS configuration error: " + err.Error())
+	}
+
+	server := NewServer(s, addr, port, auth)
+	server.TLSConfig = tlsConfig
+
+	return server, nil
 }
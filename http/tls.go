@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/redhat-cip/skydive/config"
+)
+
+// TLSConfigFromConfig returns the TLS configuration of a service read from
+// its tls section, nil if TLS is not enabled. The certificate and the key
+// identify the service while the certificate authority is used to verify
+// its peers, the clients presenting a certificate to the server and the
+// server to the clients.
+func TLSConfigFromConfig(service string) (*tls.Config, error) {
+	cfg := config.GetConfig()
+	certificate := cfg.GetString(service + ".tls.cert")
+	privateKey := cfg.GetString(service + ".tls.key")
+	caCert := cfg.GetString(service + ".tls.ca")
+
+	if certificate == "" && privateKey == "" && caCert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certificate != "" || privateKey != "" {
+		cert, err := tls.LoadX509KeyPair(certificate, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("No certificate found in " + caCert)
+		}
+		config.RootCAs = pool
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// certified returns whether the client of the request presented a
+// certificate signed by the authority of the server
+func certified(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
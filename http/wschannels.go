@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"strings"
+	"sync"
+)
+
+type wsQueuedMessage struct {
+	key  string
+	data []byte
+}
+
+// wsChannels queues the messages to be sent to a client in a channel per
+// namespace, sent in turn, so that a burst of messages of a namespace
+// doesn't delay the ones of the others and fills only its own channel.
+// Above the high watermark of a channel, the updates of an object still
+// queued are coalesced, the client getting only the last state of the object.
+type wsChannels struct {
+	sync.Mutex
+	size          int
+	highWatermark int
+	queues        map[string][]*wsQueuedMessage
+	updates       map[string]*wsQueuedMessage
+	order         []string
+	next          int
+	closing       bool
+	wake          chan struct{}
+}
+
+func (w *wsChannels) append(namespace string, m *wsQueuedMessage) bool {
+	queue, ok := w.queues[namespace]
+	if !ok {
+		w.order = append(w.order, namespace)
+	}
+	if len(queue) >= w.size {
+		return false
+	}
+	w.queues[namespace] = append(queue, m)
+	return true
+}
+
+func (w *wsChannels) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a message in the channel of the namespace, false if full
+func (w *wsChannels) push(namespace string, data []byte) bool {
+	w.Lock()
+	if w.closing {
+		w.Unlock()
+		return true
+	}
+	ok := w.append(namespace, &wsQueuedMessage{data: data})
+	w.Unlock()
+
+	if ok {
+		w.notify()
+	}
+	return ok
+}
+
+// pushBroadcast queues a broadcasted message, coalescing it with a queued
+// update of the same object when the channel is above its high watermark.
+// It returns false if the channel is full.
+func (w *wsChannels) pushBroadcast(msg WSMessage, data []byte) bool {
+	w.Lock()
+	if w.closing {
+		w.Unlock()
+		return true
+	}
+
+	var key string
+	if len(w.queues[msg.Namespace]) >= w.highWatermark {
+		if key = objectKey(msg); key != "" {
+			if !strings.HasSuffix(msg.Type, "Updated") {
+				// do not coalesce an update with one queued before this event
+				delete(w.updates, key)
+				key = ""
+			} else if m, ok := w.updates[key]; ok {
+				m.data = data
+				w.Unlock()
+				return true
+			}
+		}
+	}
+
+	m := &wsQueuedMessage{key: key, data: data}
+	ok := w.append(msg.Namespace, m)
+	if ok && key != "" {
+		w.updates[key] = m
+	}
+	w.Unlock()
+
+	if ok {
+		w.notify()
+	}
+	return ok
+}
+
+// pop returns the next message to send, taken from the channels in turn
+func (w *wsChannels) pop() ([]byte, bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	for i := 0; i < len(w.order); i++ {
+		namespace := w.order[(w.next+i)%len(w.order)]
+		if queue := w.queues[namespace]; len(queue) > 0 {
+			m := queue[0]
+			w.queues[namespace] = queue[1:]
+			w.next = (w.next + i + 1) % len(w.order)
+			if m.key != "" && w.updates[m.key] == m {
+				delete(w.updates, m.key)
+			}
+			return m.data, true
+		}
+	}
+
+	return nil, false
+}
+
+// close drops the queued messages, keeping only the last message to send
+// before closing the connection, the following ones being discarded
+func (w *wsChannels) close(last []byte) {
+	w.Lock()
+	w.queues = map[string][]*wsQueuedMessage{Namespace: {{data: last}}}
+	w.updates = make(map[string]*wsQueuedMessage)
+	w.order = []string{Namespace}
+	w.next = 0
+	w.closing = true
+	w.Unlock()
+
+	w.notify()
+}
+
+// closed returns whether the connection has to be closed, the last message
+// having been sent
+func (w *wsChannels) closed() bool {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.closing && len(w.queues[Namespace]) == 0
+}
+
+func newWSChannels(size int, highWatermark int) *wsChannels {
+	return &wsChannels{
+		size:          size,
+		highWatermark: highWatermark,
+		queues:        make(map[string][]*wsQueuedMessage),
+		updates:       make(map[string]*wsQueuedMessage),
+		wake:          make(chan struct{}, 1),
+	}
+}
@@ -23,6 +23,7 @@
 package http
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"net"
@@ -30,15 +31,22 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/logging"
 )
 
+const (
+	wsReconnectMinDelay = time.Second
+	wsAckPeriod         = time.Second
+)
+
 type WSClientEventHandler interface {
 	OnMessage(m WSMessage)
 	OnConnected()
@@ -48,20 +56,63 @@ type WSClientEventHandler interface {
 type DefaultWSClientEventHandler struct {
 }
 
+// wsClientHandler is an event handler registered for some namespaces, all
+// of them if none
+type wsClientHandler struct {
+	handler    WSClientEventHandler
+	namespaces map[string]bool
+}
+
+// accepts returns whether the handler gets the message, the handlers
+// registered for some namespaces getting the Resync messages only if
+// registered for the WSServer one
+func (h *wsClientHandler) accepts(msg WSMessage) bool {
+	return h.namespaces == nil || h.namespaces[msg.Namespace]
+}
+
+type wsServerAddr struct {
+	addr string
+	port int
+}
+
 type WSAsyncClient struct {
-	Addr          string
-	Port          int
-	Path          string
-	AuthClient    *AuthenticationClient
-	host          string
-	messages      chan string
-	read          chan []byte
-	quit          chan bool
-	wg            sync.WaitGroup
-	wsConn        *websocket.Conn
-	eventHandlers []WSClientEventHandler
-	connected     atomic.Value
-	running       atomic.Value
+	Addr       string
+	Port       int
+	Path       string
+	AuthClient *AuthenticationClient
+	// TLS configuration used to reach the server, plain WebSocket when nil
+	TLSConfig *tls.Config
+	// Format of the messages requested to the server, JSON by default
+	Format string
+	// Compression of the messages requested to the server, none when
+	// empty, see DeflateCompression
+	Compression string
+	// Maximum size of the received messages, once decoded, the connection
+	// being closed on a bigger one
+	MaxMessageSize int64
+	// Reliable asks the server to number the broadcasted messages, the
+	// missing ones being retransmitted. The handlers get a Resync message
+	// when they can't be.
+	Reliable bool
+	// Type announced to the server, UI when empty
+	Type ClientType
+	// maximum delay between the reconnection attempts
+	MaxReconnectDelay time.Duration
+	host              string
+	servers           []wsServerAddr
+	current           int
+	messages          chan string
+	read              chan []byte
+	quit              chan bool
+	disconnected      chan bool
+	wg                sync.WaitGroup
+	wsConn            *websocket.Conn
+	eventHandlers     []wsClientHandler
+	connected         atomic.Value
+	running           atomic.Value
+	sequence          uint64
+	acked             uint64
+	retransmitting    bool
 }
 
 func (d *DefaultWSClientEventHandler) OnMessage(m WSMessage) {
@@ -81,8 +132,20 @@ func (c *WSAsyncClient) sendMessage(m string) {
 	c.messages <- m
 }
 
+// encode returns the message in the format and with the compression
+// requested to the server
+func (c *WSAsyncClient) encode(m WSMessage) ([]byte, error) {
+	data, err := encodeWSMessage(m, c.Format, c.Compression == DeflateCompression)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode the %s %s message: %s", m.Namespace, m.Type, err.Error())
+	}
+	return data, err
+}
+
 func (c *WSAsyncClient) SendWSMessage(m WSMessage) {
-	c.sendMessage(m.String())
+	if data, err := c.encode(m); err == nil {
+		c.sendMessage(string(data))
+	}
 }
 
 func (c *WSAsyncClient) IsConnected() bool {
@@ -90,7 +153,7 @@ func (c *WSAsyncClient) IsConnected() bool {
 }
 
 func (c *WSAsyncClient) send(msg string) error {
-	w, err := c.wsConn.NextWriter(websocket.TextMessage)
+	w, err := c.wsConn.NextWriter(wsMessageType(c.Format, c.Compression == DeflateCompression))
 	if err != nil {
 		return err
 	}
@@ -113,19 +176,118 @@ func (c *WSAsyncClient) sendHello() {
 		Obj:       &raw,
 	}
 
-	c.sendMessage(m.String())
+	c.SendWSMessage(m)
 }
 
-func (c *WSAsyncClient) connect() {
-	host := c.Addr + ":" + strconv.FormatInt(int64(c.Port), 10)
+func (c *WSAsyncClient) sendSequence(t string, sequence uint64) {
+	b, _ := json.Marshal(sequence)
+	raw := json.RawMessage(b)
+
+	m := WSMessage{
+		Namespace: Namespace,
+		Type:      t,
+		Obj:       &raw,
+	}
 
-	conn, err := net.Dial("tcp", host)
+	data, err := c.encode(m)
+	if err != nil {
+		return
+	}
+	if err := c.send(string(data)); err != nil {
+		logging.GetLogger().Errorf("Error while writing to the WebSocket: %s", err.Error())
+	}
+}
+
+// inSequence returns whether the message has to be delivered to the event
+// handlers, the out of order messages being dropped while the missing ones
+// are retransmitted
+func (c *WSAsyncClient) inSequence(msg WSMessage) bool {
+	if msg.Namespace == Namespace && msg.Type == "Resync" {
+		if msg.Obj != nil {
+			json.Unmarshal([]byte(*msg.Obj), &c.sequence)
+		}
+		c.retransmitting = false
+		return true
+	}
+
+	if !c.Reliable || msg.Sequence == 0 {
+		return true
+	}
+
+	if msg.Sequence <= c.sequence {
+		return false
+	}
+
+	if msg.Sequence > c.sequence+1 {
+		if !c.retransmitting {
+			logging.GetLogger().Warningf("Messages %d to %d lost, retransmission requested", c.sequence+1, msg.Sequence-1)
+			c.retransmitting = true
+			c.sendSequence("Retransmit", c.sequence)
+		}
+		return false
+	}
+
+	c.sequence = msg.Sequence
+	c.retransmitting = false
+	return true
+}
+
+// acknowledge acknowledges the messages received since the last call, the
+// retransmission being requested again if still waiting for it
+func (c *WSAsyncClient) acknowledge() {
+	if c.sequence > c.acked {
+		c.sendSequence("Ack", c.sequence)
+		c.acked = c.sequence
+	}
+
+	if c.retransmitting {
+		c.sendSequence("Retransmit", c.sequence)
+	}
+}
+
+// endpoint returns the URL of the server with the parameters negotiating
+// the options of the connection
+func (c *WSAsyncClient) endpoint(scheme string, host string) string {
+	var params []string
+	if c.Reliable {
+		params = append(params, "reliable=true")
+	}
+	if c.Format == MsgpackFormat {
+		params = append(params, "format="+MsgpackFormat)
+	}
+	if c.Compression == DeflateCompression {
+		params = append(params, "compression="+DeflateCompression)
+	}
+
+	endpoint := scheme + host + c.Path
+	if len(params) == 0 {
+		return endpoint
+	}
+
+	if strings.Contains(c.Path, "?") {
+		return endpoint + "&" + strings.Join(params, "&")
+	}
+	return endpoint + "?" + strings.Join(params, "&")
+}
+
+func (c *WSAsyncClient) connect() {
+	host := net.JoinHostPort(c.Addr, strconv.FormatInt(int64(c.Port), 10))
+
+	scheme := "ws://"
+	var conn net.Conn
+	var err error
+	if c.TLSConfig != nil {
+		scheme = "wss://"
+		conn, err = tls.Dial("tcp", host, c.TLSConfig)
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
 	if err != nil {
 		logging.GetLogger().Errorf("Connection to the WebSocket server failed: %s", err.Error())
 		return
 	}
 
-	endpoint := "ws://" + host + c.Path
+	endpoint := c.endpoint(scheme, host)
 	u, err := url.Parse(endpoint)
 	if err != nil {
 		logging.GetLogger().Errorf("Unable to parse the WebSocket Endpoint %s: %s", endpoint, err.Error())
@@ -134,6 +296,9 @@ func (c *WSAsyncClient) connect() {
 	}
 
 	headers := http.Header{"Origin": {endpoint}}
+	if c.Type != "" {
+		headers.Set(ClientTypeHeader, string(c.Type))
+	}
 	if c.AuthClient != nil {
 		if err := c.AuthClient.Authenticate(); err != nil {
 			logging.GetLogger().Errorf("Unable to create a WebSocket connection %s : %s", endpoint, err.Error())
@@ -151,6 +316,10 @@ func (c *WSAsyncClient) connect() {
 	}
 	defer c.wsConn.Close()
 	c.wsConn.SetPingHandler(nil)
+	c.wsConn.SetReadLimit(c.MaxMessageSize)
+
+	// the messages are numbered per connection
+	c.sequence, c.acked, c.retransmitting = 0, 0, false
 
 	c.connected.Store(true)
 	logging.GetLogger().Infof("Connected to %s", endpoint)
@@ -162,13 +331,16 @@ func (c *WSAsyncClient) connect() {
 
 	// notify connected
 	for _, l := range c.eventHandlers {
-		l.OnConnected()
+		l.handler.OnConnected()
 	}
 
 	go func() {
 		for c.running.Load() == true {
 			_, m, err := c.wsConn.ReadMessage()
 			if err != nil {
+				if err == websocket.ErrReadLimit {
+					logging.GetLogger().Warningf("Message exceeding %d bytes, connection to %s closed", c.MaxMessageSize, endpoint)
+				}
 				break
 			}
 
@@ -177,6 +349,9 @@ func (c *WSAsyncClient) connect() {
 		c.quit <- true
 	}()
 
+	ackTicker := time.NewTicker(wsAckPeriod)
+	defer ackTicker.Stop()
+
 	for c.running.Load() == true {
 		select {
 		case msg := <-c.messages:
@@ -185,22 +360,51 @@ func (c *WSAsyncClient) connect() {
 				logging.GetLogger().Errorf("Error while writing to the WebSocket: %s", err.Error())
 			}
 		case m := <-c.read:
-			msg, err := UnmarshalWSMessage(m)
+			msg, err := decodeWSMessage(m, c.Format, c.Compression == DeflateCompression, c.MaxMessageSize)
 			if err != nil {
 				logging.GetLogger().Errorf("Error while decoding WSMessage %s", err.Error())
-			} else {
+			} else if c.inSequence(msg) {
 				for _, e := range c.eventHandlers {
-					e.OnMessage(msg)
+					if e.accepts(msg) {
+						e.handler.OnMessage(msg)
+					}
 				}
 			}
+		case <-ackTicker.C:
+			if c.Reliable {
+				c.acknowledge()
+			}
 		case <-c.quit:
 			return
 		}
 	}
 }
 
+// AddAlternateServer adds a server to fail over to, the servers being
+// tried in turn when the connection to the current one fails or is lost
+func (c *WSAsyncClient) AddAlternateServer(addr string, port int) {
+	c.servers = append(c.servers, wsServerAddr{addr: addr, port: port})
+}
+
+func (c *WSAsyncClient) failover() {
+	if len(c.servers) < 2 {
+		return
+	}
+
+	c.current = (c.current + 1) % len(c.servers)
+	c.Addr, c.Port = c.servers[c.current].addr, c.servers[c.current].port
+	if c.AuthClient != nil {
+		c.AuthClient.Addr, c.AuthClient.Port = c.Addr, c.Port
+	}
+	logging.GetLogger().Infof("Failing over to %s:%d", c.Addr, c.Port)
+}
+
+// Connect connects to the server, reconnecting with an exponential backoff
+// when the connection fails or is lost, the event handlers being notified
+// of each new connection to resync their state.
 func (c *WSAsyncClient) Connect() {
 	go func() {
+		delay := wsReconnectMinDelay
 		for c.running.Load() == true {
 			c.connect()
 
@@ -209,23 +413,54 @@ func (c *WSAsyncClient) Connect() {
 
 			if wasConnected == true {
 				for _, l := range c.eventHandlers {
-					l.OnDisconnected()
+					l.handler.OnDisconnected()
 				}
+				delay = wsReconnectMinDelay
 			}
 
 			if c.running.Load() == true {
-				time.Sleep(1 * time.Second)
+				c.failover()
+			}
+
+			if c.running.Load() == true {
+				logging.GetLogger().Debugf("Reconnecting to %s:%d in %s", c.Addr, c.Port, delay)
+
+				select {
+				case <-time.After(delay):
+				case <-c.disconnected:
+					return
+				}
+
+				if delay *= 2; delay > c.MaxReconnectDelay {
+					delay = c.MaxReconnectDelay
+				}
+				if delay < wsReconnectMinDelay {
+					delay = wsReconnectMinDelay
+				}
 			}
 		}
 	}()
 }
 
-func (c *WSAsyncClient) AddEventHandler(h WSClientEventHandler) {
-	c.eventHandlers = append(c.eventHandlers, h)
+// AddEventHandler registers a handler getting the messages of the given
+// namespaces, of all of them if none is given
+func (c *WSAsyncClient) AddEventHandler(h WSClientEventHandler, namespaces ...string) {
+	e := wsClientHandler{handler: h}
+	if len(namespaces) > 0 {
+		e.namespaces = make(map[string]bool)
+		for _, ns := range namespaces {
+			e.namespaces[ns] = true
+		}
+	}
+	c.eventHandlers = append(c.eventHandlers, e)
 }
 
 func (c *WSAsyncClient) Disconnect() {
+	if c.running.Load() == false {
+		return
+	}
 	c.running.Store(false)
+	close(c.disconnected)
 	if c.connected.Load() == true {
 		c.quit <- true
 		c.wg.Wait()
@@ -244,9 +479,18 @@ func NewWSAsyncClient(addr string, port int, path string, authClient *Authentica
 		Path:       path,
 		AuthClient: authClient,
 		host:       host,
+		servers:    []wsServerAddr{{addr: addr, port: port}},
 		messages:   make(chan string, 500),
 		read:       make(chan []byte, 500),
 		quit:       make(chan bool),
+
+		MaxReconnectDelay: time.Duration(config.GetConfig().GetInt("ws_reconnect_max_delay")) * time.Second,
+		disconnected:      make(chan bool),
+
+		MaxMessageSize: int64(config.GetConfig().GetInt("ws_max_message_size")),
+	}
+	if config.GetConfig().GetBool("ws_compression") {
+		c.Compression = DeflateCompression
 	}
 	c.connected.Store(false)
 	c.running.Store(true)
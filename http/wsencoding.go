@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	JSONFormat    = "json"
+	MsgpackFormat = "msgpack"
+)
+
+// DeflateCompression is a skydive specific encoding of the messages, requested
+// by the clients with the compression=deflate parameter. It is not the
+// permessage-deflate extension (RFC 7692), not implemented by the WebSocket
+// library: each message is deflated on its own and sent as a binary one, the
+// clients having to inflate it themselves.
+// TODO: the revision of gorilla/websocket in Godeps (844dd6d) predates the
+// EnableCompression option of the Upgrader and the Dialer. Once bumped, the
+// extension is to be negotiated instead, the browsers supporting it natively.
+const DeflateCompression = "deflate"
+
+// ErrMessageTooBig is returned for the received messages exceeding the
+// maximum size, once decoded
+var ErrMessageTooBig = errors.New("Message exceeding the maximum size")
+
+var msgpackHandle = newMsgpackHandle()
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// wsMsgpackMessage is the msgpack form of a WSMessage, the object being
+// encoded as a msgpack map instead of a JSON string
+type wsMsgpackMessage struct {
+	Namespace string
+	Type      string
+	UUID      string `codec:",omitempty"`
+	Obj       interface{}
+	Sequence  uint64 `codec:",omitempty"`
+}
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+// decodeJSON decodes a JSON object, its numbers being integers when written
+// as such and floats otherwise, so that they keep their type once encoded
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return jsonNumbers(v), nil
+}
+
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+	return v
+}
+
+// wsMessageType returns the type of the WebSocket messages carrying the
+// messages encoded in the format, deflated or not
+func wsMessageType(format string, deflate bool) int {
+	if format == MsgpackFormat || deflate {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// compress deflates an encoded message
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(&b)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decompress inflates a message, failing if larger than limit once
+// inflated
+func decompress(data []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrMessageTooBig
+	}
+	return b, nil
+}
+
+// encodeWSMessage returns the message encoded in the format and deflated.
+// The maximum size only applies to the received messages, the peers having
+// to be able to receive the replies they request, a whole graph for instance.
+func encodeWSMessage(msg WSMessage, format string, deflate bool) ([]byte, error) {
+	data, err := msg.Encode(format)
+	if err != nil {
+		return nil, err
+	}
+	if deflate {
+		return compress(data)
+	}
+	return data, nil
+}
+
+// decodeWSMessage decodes a message encoded in the format, deflated or not
+func decodeWSMessage(data []byte, format string, deflate bool, limit int64) (WSMessage, error) {
+	if deflate {
+		var err error
+		if data, err = decompress(data, limit); err != nil {
+			return WSMessage{}, err
+		}
+	}
+	return DecodeWSMessage(data, format)
+}
+
+// Encode returns the message in the given format, JSON being used for the
+// unknown formats. The object of the message is encoded from its value if
+// any, and decoded from its JSON form otherwise.
+func (g WSMessage) Encode(format string) ([]byte, error) {
+	if format != MsgpackFormat {
+		return json.Marshal(g)
+	}
+
+	m := wsMsgpackMessage{
+		Namespace: g.Namespace,
+		Type:      g.Type,
+		UUID:      g.UUID,
+		Sequence:  g.Sequence,
+	}
+	if g.Value != nil {
+		m.Obj = g.Value
+	} else if g.Obj != nil {
+		obj, err := decodeJSON([]byte(*g.Obj))
+		if err != nil {
+			return nil, err
+		}
+		m.Obj = obj
+	}
+
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(&m)
+	return b, err
+}
+
+// DecodeWSMessage decodes a message encoded in the given format
+func DecodeWSMessage(b []byte, format string) (WSMessage, error) {
+	if format != MsgpackFormat {
+		return UnmarshalWSMessage(b)
+	}
+
+	var m wsMsgpackMessage
+	if err := codec.NewDecoderBytes(b, msgpackHandle).Decode(&m); err != nil {
+		return WSMessage{}, err
+	}
+
+	msg := WSMessage{
+		Namespace: m.Namespace,
+		Type:      m.Type,
+		UUID:      m.UUID,
+		Sequence:  m.Sequence,
+	}
+	if m.Obj != nil {
+		j, err := json.Marshal(m.Obj)
+		if err != nil {
+			return msg, err
+		}
+		raw := json.RawMessage(j)
+		msg.Obj = &raw
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+type wsSequencedMessage struct {
+	sequence uint64
+	data     []byte
+}
+
+// wsReplayBuffer numbers the messages broadcasted to a reliable client and
+// keeps them until acknowledged, so that the client can ask for the ones it
+// missed. The client has to resync its state when they are no more
+// available.
+type wsReplayBuffer struct {
+	sync.Mutex
+	client   *WSClient
+	size     int
+	sequence uint64
+	messages []*wsSequencedMessage
+}
+
+// push numbers the message and returns it encoded for the client, nil if
+// it can't be encoded
+func (r *wsReplayBuffer) push(msg WSMessage) []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	msg.Sequence = r.sequence + 1
+	data, err := r.client.encode(msg)
+	if err != nil {
+		return nil
+	}
+	r.sequence++
+
+	if len(r.messages) > 0 && len(r.messages) >= r.size {
+		r.messages = r.messages[1:]
+	}
+	r.messages = append(r.messages, &wsSequencedMessage{sequence: r.sequence, data: data})
+
+	return data
+}
+
+// ack releases the messages received by the client
+func (r *wsReplayBuffer) ack(sequence uint64) {
+	r.Lock()
+	defer r.Unlock()
+
+	i := 0
+	for i < len(r.messages) && r.messages[i].sequence <= sequence {
+		i++
+	}
+	r.messages = r.messages[i:]
+}
+
+// retransmit sends again the messages following the last one received in
+// order by the client, or asks the client to resync if some of them have
+// been dropped from the buffer
+func (r *wsReplayBuffer) retransmit(sequence uint64) {
+	r.Lock()
+	defer r.Unlock()
+
+	if sequence >= r.sequence {
+		return
+	}
+
+	if len(r.messages) == 0 || r.messages[0].sequence > sequence+1 {
+		logging.GetLogger().Warningf("Messages lost for WSClient %s, resync requested", r.client.host)
+
+		b, _ := json.Marshal(r.sequence)
+		raw := json.RawMessage(b)
+
+		if data, err := r.client.encode(WSMessage{Namespace: Namespace, Type: "Resync", Obj: &raw}); err == nil {
+			r.send(data)
+		}
+		return
+	}
+
+	for _, m := range r.messages {
+		if m.sequence > sequence && !r.send(m.data) {
+			return
+		}
+	}
+}
+
+// send queues a numbered message, all of them sharing the same channel to
+// be received in order
+func (r *wsReplayBuffer) send(data []byte) bool {
+	return r.client.queue(Namespace, data)
+}
+
+func newWSReplayBuffer(c *WSClient, size int) *wsReplayBuffer {
+	return &wsReplayBuffer{
+		client: c,
+		size:   size,
+	}
+}
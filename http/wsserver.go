@@ -24,7 +24,10 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,17 +40,48 @@ import (
 )
 
 const (
-	Namespace      = "WSServer"
-	writeWait      = 10 * time.Second
-	maxMessageSize = 1024 * 1024
+	Namespace = "WSServer"
+	writeWait = 10 * time.Second
 )
 
+// ClientTypeHeader is the header of the WebSocket handshake request in which
+// the clients announce their type, the ones not sending it being UIs
+const ClientTypeHeader = "X-Client-Type"
+
+// ClientType of a WebSocket client, only the agents and the peer analyzers
+// are allowed to modify the graph
+type ClientType string
+
+const (
+	AgentClient    ClientType = "agent"
+	AnalyzerClient ClientType = "analyzer"
+	UIClient       ClientType = "ui"
+)
+
+func clientTypeFromHeader(h http.Header) (ClientType, error) {
+	switch t := ClientType(h.Get(ClientTypeHeader)); t {
+	case "":
+		return UIClient, nil
+	case AgentClient, AnalyzerClient, UIClient:
+		return t, nil
+	default:
+		return "", fmt.Errorf("Unknown client type: %s", t)
+	}
+}
+
 type WSClient struct {
-	conn   *websocket.Conn
-	read   chan []byte
-	send   chan []byte
-	server *WSServer
-	host   string
+	conn     *websocket.Conn
+	read     chan []byte
+	channels *wsChannels
+	server   *WSServer
+	host     string
+	params   url.Values
+	role     Role
+	kind     ClientType
+	throttle *wsThrottle
+	replay   *wsReplayBuffer
+	format   string
+	deflate  bool
 }
 
 type WSMessage struct {
@@ -55,6 +89,12 @@ type WSMessage struct {
 	Type      string
 	UUID      string `json:",omitempty"`
 	Obj       *json.RawMessage
+	// sequence number of the messages broadcasted to the reliable clients
+	Sequence uint64 `json:",omitempty"`
+	// value Obj has been marshalled from, if any, encoded directly in the
+	// formats other than JSON. It must not be modified once the message
+	// created, the message being encoded asynchronously.
+	Value interface{} `json:"-"`
 }
 
 type WSServerEventHandler interface {
@@ -66,19 +106,47 @@ type WSServerEventHandler interface {
 type DefaultWSServerEventHandler struct {
 }
 
+// wsServerHandler is an event handler registered for some namespaces, all
+// of them if none
+type wsServerHandler struct {
+	handler    WSServerEventHandler
+	namespaces map[string]bool
+}
+
+// WSClientFilter is used to select the clients a broadcasted message will
+// be sent to
+type WSClientFilter func(c *WSClient) bool
+
+// WSMessageMapper returns the message to be sent to a client, nil if
+// nothing has to be sent
+type WSMessageMapper func(c *WSClient) *WSMessage
+
+type wsBroadcast struct {
+	message WSMessage
+	filter  WSClientFilter
+	mapper  WSMessageMapper
+}
+
 type WSServer struct {
 	DefaultWSServerEventHandler
 	Server        *Server
-	eventHandlers []WSServerEventHandler
+	eventHandlers []wsServerHandler
 	clients       map[*WSClient]bool
-	broadcast     chan string
+	clientsLock   sync.RWMutex
+	broadcast     chan wsBroadcast
 	quit          chan bool
 	register      chan *WSClient
 	unregister    chan *WSClient
 	pongWait      time.Duration
 	pingPeriod    time.Duration
+	replaySize    int
+	channelSize   int
+	highWatermark int
 	wg            sync.WaitGroup
 	listening     atomic.Value
+
+	// maximum size of the received messages, once decoded
+	maxMessageSize int64
 }
 
 func (g WSMessage) Marshal() []byte {
@@ -108,12 +176,59 @@ func (d *DefaultWSServerEventHandler) OnRegisterClient(c *WSClient) {
 func (d *DefaultWSServerEventHandler) OnUnregisterClient(c *WSClient) {
 }
 
+// encode returns the message in the format and with the compression
+// requested by the client
+func (c *WSClient) encode(msg WSMessage) ([]byte, error) {
+	data, err := encodeWSMessage(msg, c.format, c.deflate)
+	if err != nil {
+		logging.GetLogger().Errorf("WSServer: Unable to encode the %s %s message for %s: %s", msg.Namespace, msg.Type, c.host, err.Error())
+	}
+	return data, err
+}
+
+// queue queues the data of a message of the namespace, false if its channel
+// is full
+func (c *WSClient) queue(namespace string, data []byte) bool {
+	return c.channels.push(namespace, data)
+}
+
 func (c *WSClient) SendWSMessage(msg WSMessage) {
-	c.send <- []byte(msg.String())
+	if data, err := c.encode(msg); err == nil && !c.queue(msg.Namespace, data) {
+		logging.GetLogger().Warningf("%s channel full for WSClient %s, message dropped", msg.Namespace, c.host)
+	}
+}
+
+func (c *WSClient) Host() string {
+	return c.host
+}
+
+func (c *WSClient) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// Role returns the role of the user authenticated by the client
+func (c *WSClient) Role() Role {
+	return c.role
+}
+
+// ClientType returns the type announced by the client during the handshake
+func (c *WSClient) ClientType() ClientType {
+	return c.kind
+}
+
+// Params returns the parameters of the connection request
+func (c *WSClient) Params() url.Values {
+	return c.params
+}
+
+// Param returns the value of a parameter passed in the query string of the
+// WebSocket handshake request
+func (c *WSClient) Param(k string) string {
+	return c.params.Get(k)
 }
 
 func (c *WSClient) processMessage(m []byte) {
-	msg, err := UnmarshalWSMessage(m)
+	msg, err := decodeWSMessage(m, c.format, c.deflate, c.server.maxMessageSize)
 	if err != nil {
 		logging.GetLogger().Errorf("WSServer: Unable to parse the event %s: %s", msg, err.Error())
 		return
@@ -131,10 +246,28 @@ func (c *WSClient) processMessage(m []byte) {
 			c.host = host
 
 			logging.GetLogger().Infof("Hello received from WSClient: %s", c.host)
+		case "Ack", "Retransmit":
+			if c.replay == nil {
+				return
+			}
+
+			var sequence uint64
+			if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &sequence) != nil {
+				logging.GetLogger().Errorf("WSServer: Unable to parse the event %s", msg)
+				return
+			}
+
+			if msg.Type == "Ack" {
+				c.replay.ack(sequence)
+			} else {
+				c.replay.retransmit(sequence)
+			}
 		}
 	} else {
 		for _, e := range c.server.eventHandlers {
-			e.OnMessage(c, msg)
+			if e.namespaces == nil || e.namespaces[msg.Namespace] {
+				e.handler.OnMessage(c, msg)
+			}
 		}
 	}
 }
@@ -161,7 +294,7 @@ func (c *WSClient) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadLimit(c.server.maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(c.server.pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(c.server.pongWait))
@@ -171,6 +304,9 @@ func (c *WSClient) readPump() {
 	for {
 		_, m, err := c.conn.ReadMessage()
 		if err != nil {
+			if err == websocket.ErrReadLimit {
+				logging.GetLogger().Warningf("WSServer: Message of %s exceeding %d bytes, connection closed", c.host, c.server.maxMessageSize)
+			}
 			break
 		}
 
@@ -188,16 +324,22 @@ func (c *WSClient) writePump(wg *sync.WaitGroup, quit chan struct{}) {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				c.write(websocket.CloseMessage, []byte{})
-				wg.Done()
-				return
+		case <-c.channels.wake:
+			for {
+				message, ok := c.channels.pop()
+				if !ok {
+					break
+				}
+				if err := c.write(wsMessageType(c.format, c.deflate), message); err != nil {
+					logging.GetLogger().Warningf("Error while writing to the websocket: %s", err.Error())
+					wg.Done()
+					return
+				}
 			}
-			if err := c.write(websocket.TextMessage, message); err != nil {
-				logging.GetLogger().Warningf("Error while writing to the websocket: %s", err.Error())
-				wg.Done()
-				return
+
+			if c.channels.closed() {
+				c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "resync"))
+				c.conn.Close()
 			}
 		case <-ticker.C:
 			if err := c.write(websocket.PingMessage, []byte{}); err != nil {
@@ -216,9 +358,13 @@ func (c *WSClient) write(mt int, message []byte) error {
 	return c.conn.WriteMessage(mt, message)
 }
 
+// SendWSMessageTo sends the message to the agent running on the host
 func (s *WSServer) SendWSMessageTo(msg WSMessage, host string) bool {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+
 	for c := range s.clients {
-		if c.host == host {
+		if c.host == host && c.kind == AgentClient {
 			c.SendWSMessage(msg)
 			return true
 		}
@@ -227,6 +373,18 @@ func (s *WSServer) SendWSMessageTo(msg WSMessage, host string) bool {
 	return false
 }
 
+// GetClients returns the clients currently connected
+func (s *WSServer) GetClients() []*WSClient {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+
+	clients := make([]*WSClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
 func (s *WSServer) listenAndServe() {
 	quit := false
 
@@ -244,78 +402,209 @@ func (s *WSServer) listenAndServe() {
 
 			quit = true
 		case c := <-s.register:
+			s.clientsLock.Lock()
 			s.clients[c] = true
+			s.updateClientsGauge()
+			s.clientsLock.Unlock()
 			for _, e := range s.eventHandlers {
-				e.OnRegisterClient(c)
+				e.handler.OnRegisterClient(c)
 			}
 		case c := <-s.unregister:
 			for _, e := range s.eventHandlers {
-				e.OnUnregisterClient(c)
+				e.handler.OnUnregisterClient(c)
 			}
+			s.clientsLock.Lock()
 			delete(s.clients, c)
+			s.updateClientsGauge()
+			s.clientsLock.Unlock()
 
 			// if quit has been requested and there is no more clients then leave
 			if quit && len(s.clients) == 0 {
 				return
 			}
-		case m := <-s.broadcast:
-			s.broadcastMessage(m)
+		case b := <-s.broadcast:
+			s.broadcastMessage(b)
 		}
 	}
 }
 
-func (s *WSServer) broadcastMessage(m string) {
+func (s *WSServer) updateClientsGauge() {
+	wsClientsGauge.WithLabelValues(s.Server.Service).Set(float64(len(s.clients)))
+}
+
+func (s *WSServer) broadcastMessage(b wsBroadcast) {
+	// the message is encoded once per format and compression, unless
+	// mapped for each client
+	encoded := make(map[string][]byte)
+
 	for c := range s.clients {
-		select {
-		case c.send <- []byte(m):
-		default:
-			delete(s.clients, c)
+		if b.filter != nil && !b.filter(c) {
+			continue
+		}
+
+		msg := b.message
+		if b.mapper != nil {
+			m := b.mapper(c)
+			if m == nil {
+				continue
+			}
+			msg = *m
+		}
+
+		// the messages not sent to a reliable client are retransmitted
+		// on its request
+		if c.replay != nil {
+			if data := c.replay.push(msg); data != nil && !c.replay.send(data) {
+				logging.GetLogger().Warningf("Send buffer full for WSClient %s, message kept for retransmission", c.host)
+			}
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%t", c.format, c.deflate)
+		data, ok := encoded[key]
+		if !ok || b.mapper != nil {
+			var err error
+			if data, err = c.encode(msg); err != nil {
+				continue
+			}
+			if b.mapper == nil {
+				encoded[key] = data
+			}
+		}
+
+		if c.throttle != nil {
+			c.throttle.push(msg, data)
+			continue
+		}
+
+		if !c.channels.pushBroadcast(msg, data) {
+			logging.GetLogger().Warningf("%s channel full for WSClient %s, client disconnected", msg.Namespace, c.host)
+			s.disconnect(c)
 		}
 	}
 }
 
+// disconnect closes the connection of a client not reading its messages fast
+// enough, instead of delaying the broadcasts to the others. The queued
+// messages are dropped and the client is asked to resync once reconnected.
+func (s *WSServer) disconnect(c *WSClient) {
+	if data, err := c.encode(WSMessage{Namespace: Namespace, Type: "Resync"}); err == nil {
+		c.channels.close(data)
+	} else {
+		c.conn.Close()
+	}
+
+	wsDisconnectedClientsCounter.WithLabelValues(s.Server.Service).Inc()
+
+	s.clientsLock.Lock()
+	delete(s.clients, c)
+	s.updateClientsGauge()
+	s.clientsLock.Unlock()
+}
+
 func (s *WSServer) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 	var upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
 
+	kind, err := clientTypeFromHeader(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, &r.Request, nil)
 	if err != nil {
 		return
 	}
 
 	c := &WSClient{
-		read:   make(chan []byte, maxMessageSize),
-		send:   make(chan []byte, maxMessageSize),
-		conn:   conn,
-		server: s,
+		read:     make(chan []byte, 500),
+		channels: newWSChannels(s.channelSize, s.highWatermark),
+		conn:     conn,
+		server:   s,
+		params:   r.URL.Query(),
+		role:     s.Server.Auth.Role(r.Username),
+		kind:     kind,
+		format:   JSONFormat,
+	}
+
+	// the clients can negotiate a binary encoding of the messages, the
+	// ones not asking for it getting JSON, ex: /ws?format=msgpack
+	if c.Param("format") == MsgpackFormat {
+		c.format = MsgpackFormat
+	}
+
+	// and the skydive specific compression of the messages, see
+	// DeflateCompression, ex: /ws?compression=deflate
+	if c.Param("compression") == DeflateCompression {
+		c.deflate = true
+	}
+
+	// when the server verifies the client certificates, only the enrolled
+	// clients, the ones presenting a certificate, can modify the graph
+	if tc := s.Server.TLSConfig; tc != nil && tc.ClientCAs != nil && !certified(&r.Request) {
+		c.role = ReaderRole
+	}
+	logging.GetLogger().Infof("New WebSocket Connection from %s (%s) : URI path %s", conn.RemoteAddr().String(), c.kind, r.URL.Path)
+
+	// a client can request a maximum number of broadcasted messages per
+	// second, ex: /ws?maxrate=10
+	if rate, err := strconv.Atoi(c.Param("maxrate")); err == nil && rate > 0 {
+		c.throttle = newWSThrottle(c, rate)
+	}
+
+	// the broadcasted messages are numbered for the reliable clients which
+	// acknowledge them and request the retransmission of the missing ones,
+	// ex: /ws?reliable=true. Their messages are never throttled.
+	if c.Param("reliable") == "true" {
+		c.replay = newWSReplayBuffer(c, s.replaySize)
+		c.throttle = nil
 	}
-	logging.GetLogger().Infof("New WebSocket Connection from %s : URI path %s", conn.RemoteAddr().String(), r.URL.Path)
 
 	s.register <- c
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	quit := make(chan struct{})
+	// buffered so that the routines having already left don't block
+	quit := make(chan struct{}, 3)
 
 	go c.writePump(&wg, quit)
 	go c.processMessages(&wg, quit)
+	if c.throttle != nil {
+		wg.Add(1)
+		go c.throttle.run(&wg, quit)
+	}
 
 	c.readPump()
 
 	quit <- struct{}{}
 	quit <- struct{}{}
+	if c.throttle != nil {
+		quit <- struct{}{}
+	}
 
 	close(c.read)
-	close(c.send)
 
 	wg.Wait()
 }
 
 func (s *WSServer) BroadcastWSMessage(msg WSMessage) {
-	s.broadcast <- msg.String()
+	s.broadcast <- wsBroadcast{message: msg}
+}
+
+// BroadcastFilteredWSMessage sends the message only to the clients accepted
+// by the given filter
+func (s *WSServer) BroadcastFilteredWSMessage(msg WSMessage, f WSClientFilter) {
+	s.broadcast <- wsBroadcast{message: msg, filter: f}
+}
+
+// BroadcastMappedWSMessage sends to each client the message returned by the
+// mapper, messages can then be tailored for each client
+func (s *WSServer) BroadcastMappedWSMessage(m WSMessageMapper) {
+	s.broadcast <- wsBroadcast{mapper: m}
 }
 
 func (s *WSServer) ListenAndServe() {
@@ -334,20 +623,43 @@ func (s *WSServer) Stop() {
 	s.listening.Store(false)
 }
 
-func (s *WSServer) AddEventHandler(h WSServerEventHandler) {
-	s.eventHandlers = append(s.eventHandlers, h)
+// AddEventHandler registers a handler getting the messages of the given
+// namespaces, of all of them if none is given. All the handlers are notified
+// of the clients registrations.
+func (s *WSServer) AddEventHandler(h WSServerEventHandler, namespaces ...string) {
+	e := wsServerHandler{handler: h}
+	if len(namespaces) > 0 {
+		e.namespaces = make(map[string]bool)
+		for _, ns := range namespaces {
+			e.namespaces[ns] = true
+		}
+	}
+	s.eventHandlers = append(s.eventHandlers, e)
 }
 
 func NewWSServer(server *Server, pongWait time.Duration, endpoint string) *WSServer {
 	s := &WSServer{
 		Server:     server,
-		broadcast:  make(chan string, 500),
+		broadcast:  make(chan wsBroadcast, 500),
 		quit:       make(chan bool, 1),
 		register:   make(chan *WSClient),
 		unregister: make(chan *WSClient),
 		clients:    make(map[*WSClient]bool),
 		pongWait:   pongWait,
 		pingPeriod: (pongWait * 8) / 10,
+		replaySize: config.GetConfig().GetInt("ws_replay_buffer"),
+
+		maxMessageSize: int64(config.GetConfig().GetInt("ws_max_message_size")),
+	}
+
+	if s.channelSize = config.GetConfig().GetInt("ws_channel_size"); s.channelSize <= 0 {
+		s.channelSize = 1
+	}
+
+	// percentage of the channels above which the updates are coalesced
+	s.highWatermark = s.channelSize
+	if pct := config.GetConfig().GetInt("ws_channel_high_watermark"); pct > 0 && pct < 100 {
+		s.highWatermark = s.channelSize * pct / 100
 	}
 
 	server.HandleFunc(endpoint, s.serveMessages)
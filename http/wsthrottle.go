@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+const (
+	maxThrottledMessages = 10000
+	// maximum rate a client can request, keeping a period of 1ms at least
+	maxThrottleRate = 1000
+)
+
+type wsThrottledMessage struct {
+	key       string
+	namespace string
+	data      []byte
+}
+
+// wsThrottle limits the number of broadcasted messages sent per second to
+// a client. Updates of the same object are coalesced while waiting to be
+// sent and are the first to be dropped when the queue is full, structural
+// events (add/delete) are kept.
+type wsThrottle struct {
+	sync.Mutex
+	client  *WSClient
+	period  time.Duration
+	queue   []*wsThrottledMessage
+	updates map[string]*wsThrottledMessage
+}
+
+func objectKey(msg WSMessage) string {
+	if msg.Obj == nil {
+		return ""
+	}
+
+	var obj struct {
+		ID string
+	}
+	if err := json.Unmarshal([]byte(*msg.Obj), &obj); err != nil || obj.ID == "" {
+		return ""
+	}
+
+	return msg.Namespace + ":" + obj.ID
+}
+
+func (t *wsThrottle) shed() bool {
+	for i, m := range t.queue {
+		if m.key != "" {
+			t.queue = append(t.queue[:i], t.queue[i+1:]...)
+			delete(t.updates, m.key)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *wsThrottle) push(msg WSMessage, data []byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	key := objectKey(msg)
+	if !strings.HasSuffix(msg.Type, "Updated") {
+		// do not coalesce an update with one queued before this event
+		if key != "" {
+			delete(t.updates, key)
+		}
+		key = ""
+	} else if m, ok := t.updates[key]; ok {
+		m.data = data
+		return
+	}
+
+	if len(t.queue) >= maxThrottledMessages && !t.shed() {
+		logging.GetLogger().Warningf("Too many messages queued for WSClient %s, message dropped", t.client.host)
+		return
+	}
+
+	m := &wsThrottledMessage{key: key, namespace: msg.Namespace, data: data}
+	t.queue = append(t.queue, m)
+	if key != "" {
+		t.updates[key] = m
+	}
+}
+
+func (t *wsThrottle) pop() *wsThrottledMessage {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.queue) == 0 {
+		return nil
+	}
+
+	m := t.queue[0]
+	t.queue = t.queue[1:]
+	if m.key != "" {
+		delete(t.updates, m.key)
+	}
+
+	return m
+}
+
+func (t *wsThrottle) run(wg *sync.WaitGroup, quit chan struct{}) {
+	ticker := time.NewTicker(t.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if m := t.pop(); m != nil && !t.client.queue(m.namespace, m.data) {
+				logging.GetLogger().Warningf("%s channel full for WSClient %s, message dropped", m.namespace, t.client.host)
+			}
+		case <-quit:
+			wg.Done()
+			return
+		}
+	}
+}
+
+func newWSThrottle(c *WSClient, rate int) *wsThrottle {
+	if rate > maxThrottleRate {
+		rate = maxThrottleRate
+	}
+
+	return &wsThrottle{
+		client:  c,
+		period:  time.Second / time.Duration(rate),
+		updates: make(map[string]*wsThrottledMessage),
+	}
+}
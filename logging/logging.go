@@ -23,14 +23,19 @@
 package logging
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/op/go-logging"
+	"github.com/spf13/viper"
 
 	"github.com/redhat-cip/skydive/config"
 )
@@ -55,19 +60,51 @@ var skydiveLoggerLock sync.Mutex
 var skydiveLogger SkydiveLogger
 var skydiveLoggingID = "skydive"
 
+const skydivePackage = "github.com/redhat-cip/skydive/"
+
 func SetLoggingID(ID string) {
 	skydiveLoggingID = ID
 }
 
 type SkydiveLogger struct {
 	loggers     map[string]*logging.Logger
+	levels      map[string]string
 	id          string
 	format      string
 	formatDebug string
 	backend     logging.Backend
+	json        bool
+}
+
+// jsonFormatter formats the records as JSON objects, one per line
+type jsonFormatter struct {
+	id string
 }
 
-func initSkydiveLogger() {
+func (f *jsonFormatter) Format(calldepth int, r *logging.Record, w io.Writer) error {
+	entry := map[string]interface{}{
+		"Time":    r.Time.Format(time.RFC3339Nano),
+		"ID":      f.id,
+		"Module":  r.Module,
+		"Level":   r.Level.String(),
+		"Message": r.Message(),
+	}
+	if pc, file, line, ok := runtime.Caller(calldepth + 1); ok {
+		entry["File"] = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			entry["Function"] = fn.Name()
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func initSkydiveLogger(cfg *viper.Viper) error {
 	id, err := os.Hostname()
 	if err != nil {
 		panic(err)
@@ -76,23 +113,62 @@ func initSkydiveLogger() {
 	skydiveLogger = SkydiveLogger{
 		id:          id,
 		loggers:     make(map[string]*logging.Logger),
+		levels:      make(map[string]string),
 		format:      "%{color}%{time} " + id + " %{shortfile} %{shortpkg} %{longfunc} > %{level:.4s} %{id:03x}%{color:reset} %{message}",
 		formatDebug: "%{color}%{time} " + id + " %{shortfile} %{shortpkg} %{callpath:5} %{longfunc} > %{level:.4s} %{id:03x}%{color:reset} %{message}",
+		backend:     logging.NewLogBackend(os.Stderr, "", 0),
 	}
-	newLogger("default", "INFO")
+
+	switch backend := cfg.GetString("logging.backend"); backend {
+	case "", "stderr":
+	case "syslog":
+		// syslog timestamps the messages itself and doesn't render colors
+		skydiveLogger.format = "%{shortfile} %{shortpkg} %{longfunc} > %{level:.4s} %{id:03x} %{message}"
+		skydiveLogger.formatDebug = "%{shortfile} %{shortpkg} %{callpath:5} %{longfunc} > %{level:.4s} %{id:03x} %{message}"
+		if skydiveLogger.backend, err = logging.NewSyslogBackend(skydiveLoggingID); err != nil {
+			return fmt.Errorf("Can't connect to syslog: %s", err.Error())
+		}
+	default:
+		return fmt.Errorf("Unknown logging backend: %s", backend)
+	}
+
+	switch format := cfg.GetString("logging.format"); format {
+	case "", "text":
+	case "json":
+		skydiveLogger.json = true
+	default:
+		return fmt.Errorf("Unknown logging format: %s", format)
+	}
+
+	return newLogger("default", "INFO")
+}
+
+func loggerName(module string) string {
+	if module == "default" {
+		return module
+	}
+	return skydivePackage + module
 }
 
-func newLogger(pkg string, loglevel string) error {
+func newLogger(module string, loglevel string) error {
 	level, err := logging.LogLevel(loglevel)
 	if err != nil {
 		return err
 	}
-	backend := logging.NewLogBackend(os.Stderr, "", 0)
-	format := skydiveLogger.format
-	if level == logging.DEBUG {
-		format = skydiveLogger.formatDebug
+
+	var formatter logging.Formatter
+	if skydiveLogger.json {
+		formatter = &jsonFormatter{id: skydiveLogger.id}
+	} else {
+		format := skydiveLogger.format
+		if level == logging.DEBUG {
+			format = skydiveLogger.formatDebug
+		}
+		formatter = logging.MustStringFormatter(format)
 	}
-	backendFormat := logging.NewBackendFormatter(backend, logging.MustStringFormatter(format))
+
+	pkg := loggerName(module)
+	backendFormat := logging.NewBackendFormatter(skydiveLogger.backend, formatter)
 	backendLevel := logging.AddModuleLevel(backendFormat)
 	backendLevel.SetLevel(level, pkg)
 
@@ -102,6 +178,7 @@ func newLogger(pkg string, loglevel string) error {
 	}
 	logger.SetBackend(backendLevel)
 	skydiveLogger.loggers[pkg] = logger
+	skydiveLogger.levels[module] = level.String()
 
 	skydiveLogger.loggers["default"].Debug("New Log Registered : " + pkg + " " + loglevel)
 	return nil
@@ -114,43 +191,84 @@ func InitLogger() error {
 }
 
 func initLogger() (err error) {
-	initSkydiveLogger()
 	cfg := config.GetConfig()
+	if err = initSkydiveLogger(cfg); err != nil {
+		return err
+	}
 
 	for cfgPkg, cfgLvl := range cfg.GetStringMapString("logging") {
 		pkg := strings.TrimSpace(cfgPkg)
 		lvl := strings.TrimSpace(cfgLvl)
-		if pkg == "default" {
-			err = newLogger("default", lvl)
-		} else {
-			err = newLogger("github.com/redhat-cip/skydive/"+pkg, lvl)
+		if pkg == "backend" || pkg == "format" {
+			continue
 		}
-		if err != nil {
+		if err = newLogger(pkg, lvl); err != nil {
 			return errors.New("Can't parse logging line : \"" + pkg + " " + lvl + "\" " + err.Error())
 		}
 	}
 	return
 }
 
+// SetLevel changes at runtime the level of a module, either "default" or a
+// package path relative to the skydive one, ex: topology/probes
+func SetLevel(module string, level string) error {
+	skydiveLoggerLock.Lock()
+	defer skydiveLoggerLock.Unlock()
+
+	if skydiveLogger.loggers == nil {
+		if err := initLogger(); err != nil {
+			return err
+		}
+	}
+
+	module = strings.Trim(strings.TrimSpace(module), "/")
+	if module == "" {
+		return errors.New("Empty logging module")
+	}
+	return newLogger(module, strings.TrimSpace(level))
+}
+
+// GetLevels returns the level of the modules
+func GetLevels() map[string]string {
+	skydiveLoggerLock.Lock()
+	defer skydiveLoggerLock.Unlock()
+
+	levels := make(map[string]string)
+	for module, level := range skydiveLogger.levels {
+		levels[module] = level
+	}
+	return levels
+}
+
+// lookupLogger returns the logger of the function, else of its package,
+// else of the closest parent package having one
+func lookupLogger(pkg string, f string) (*logging.Logger, bool) {
+	if log, found := skydiveLogger.loggers[pkg+"."+f]; found {
+		return log, true
+	}
+	for strings.HasPrefix(pkg, skydivePackage) {
+		if log, found := skydiveLogger.loggers[pkg]; found {
+			return log, true
+		}
+		pkg = pkg[:strings.LastIndex(pkg, "/")]
+	}
+	log, found := skydiveLogger.loggers["default"]
+	return log, found
+}
+
 func GetLogger() (log *logging.Logger) {
 	skydiveLoggerLock.Lock()
 	defer skydiveLoggerLock.Unlock()
 
 	pkg, f := getPackageFunction()
-	log, found := skydiveLogger.loggers[pkg+"."+f]
+	log, found := lookupLogger(pkg, f)
 	if !found {
-		log, found = skydiveLogger.loggers[pkg]
-		if !found {
-			log, found = skydiveLogger.loggers["default"]
-			if !found {
-				err := initLogger()
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "%v\n", err)
-					os.Exit(1)
-				}
-				log, _ = skydiveLogger.loggers["default"]
-			}
+		err := initLogger()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
 		}
+		log, _ = skydiveLogger.loggers["default"]
 	}
 	return log
 }
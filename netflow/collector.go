@@ -0,0 +1,270 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/analyzer"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/flow/mappings"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const (
+	maxDgramSize = 65535
+)
+
+// Collector receives the flows exported by NetFlow v5, v9 or IPFIX
+// exporters, like physical routers or switches, and feeds them into the flow
+// pipeline. The exporter is looked up in the graph by its management
+// address, the local host being used otherwise, the input and output
+// interfaces of the flows being the exporter interfaces with matching
+// IfIndex.
+type Collector struct {
+	Addr                string
+	Port                int
+	Graph               *graph.Graph
+	AnalyzerClient      *analyzer.Client
+	FlowMappingPipeline *mappings.FlowMappingPipeline
+	FlowTableAllocator  *flow.TableAllocator
+	flowTable           *flow.Table
+	decoder             *Decoder
+}
+
+func (c *Collector) GetTarget() string {
+	return fmt.Sprintf("%s:%d", c.Addr, c.Port)
+}
+
+// exporterNode returns the node of the exporter, must be called with the
+// graph lock held
+func (c *Collector) exporterNode(exporter string) *graph.Node {
+	if n := c.Graph.LookupFirstNode(graph.Metadata{"LLDP.MgmtAddress": exporter}); n != nil {
+		return n
+	}
+	return c.Graph.LookupFirstNode(graph.Metadata{"Type": "host"})
+}
+
+func (c *Collector) interfaceNodeUUID(exporter *graph.Node, index uint32) string {
+	if exporter == nil || index == 0 {
+		return ""
+	}
+
+	if n := c.Graph.LookupFirstChild(exporter, graph.Metadata{"IfIndex": int64(index)}); n != nil {
+		return string(n.ID)
+	}
+	return ""
+}
+
+func flowKey(r *Record) string {
+	src := fmt.Sprintf("%s:%d", r.SrcAddr, r.SrcPort)
+	dst := fmt.Sprintf("%s:%d", r.DstAddr, r.DstPort)
+	// both directions are the same flow
+	if src > dst {
+		src, dst = dst, src
+	}
+	return fmt.Sprintf("%s-%d-%s-%s", r.Exporter, r.Protocol, src, dst)
+}
+
+func newFlowStatistics(r *Record) (*flow.FlowStatistics, string) {
+	fs := &flow.FlowStatistics{Start: r.Start.Unix()}
+	path, ipType := "IPv4", flow.FlowEndpointType_IPV4
+	if r.SrcAddr.To4() == nil {
+		path, ipType = "IPv6", flow.FlowEndpointType_IPV6
+	}
+
+	if r.SrcMAC != nil && r.DstMAC != nil {
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_ETHERNET, r.SrcMAC, r.DstMAC))
+		path = "Ethernet/" + path
+	}
+	fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(ipType, r.SrcAddr, r.DstAddr))
+
+	switch layers.IPProtocol(r.Protocol) {
+	case layers.IPProtocolTCP:
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_TCPPORT, layers.TCPPort(r.SrcPort), layers.TCPPort(r.DstPort)))
+		path += "/TCP"
+	case layers.IPProtocolUDP:
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_UDPPORT, layers.UDPPort(r.SrcPort), layers.UDPPort(r.DstPort)))
+		path += "/UDP"
+	case layers.IPProtocolSCTP:
+		fs.Endpoints = append(fs.Endpoints, flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_SCTPPORT, layers.SCTPPort(r.SrcPort), layers.SCTPPort(r.DstPort)))
+		path += "/SCTP"
+	}
+
+	return fs, path
+}
+
+func (c *Collector) updateFlow(r *Record) {
+	if r.SrcAddr == nil || r.DstAddr == nil {
+		return
+	}
+
+	// both addresses of a flow are of the same family
+	if src, dst := r.SrcAddr.To4(), r.DstAddr.To4(); src != nil && dst != nil {
+		r.SrcAddr, r.DstAddr = src, dst
+	} else if src != nil || dst != nil {
+		return
+	}
+
+	f, created := c.flowTable.GetOrCreateFlow(flowKey(r))
+	if f == nil {
+		return
+	}
+	if created {
+		c.Graph.Lock()
+		exporter := c.exporterNode(r.Exporter)
+		if exporter != nil {
+			f.ProbeNodeUUID = string(exporter.ID)
+		}
+		f.IfSrcNodeUUID = c.interfaceNodeUUID(exporter, r.InputIf)
+		f.IfDstNodeUUID = c.interfaceNodeUUID(exporter, r.OutputIf)
+		c.Graph.Unlock()
+
+		f.Statistics, f.LayersPath = newFlowStatistics(r)
+		f.UpdateUUIDs()
+	}
+
+	fs := f.GetStatistics()
+	if last := r.Last.Unix(); last > fs.Last {
+		fs.Last = last
+	}
+
+	for _, ep := range fs.GetEndpoints() {
+		e := ep.BA
+		switch ep.Type {
+		case flow.FlowEndpointType_ETHERNET:
+			if ep.AB.Value == r.SrcMAC.String() {
+				e = ep.AB
+			}
+		case flow.FlowEndpointType_IPV4, flow.FlowEndpointType_IPV6:
+			if ep.AB.Value == r.SrcAddr.String() {
+				e = ep.AB
+			}
+		default:
+			if ep.AB.Value == fmt.Sprintf("%d", r.SrcPort) {
+				e = ep.AB
+			}
+		}
+		e.Packets += r.Packets
+		e.Bytes += r.Bytes
+	}
+}
+
+func (c *Collector) feedFlowTable(conn *net.UDPConn) {
+	var buf [maxDgramSize]byte
+	n, addr, err := conn.ReadFromUDP(buf[:])
+	if err != nil {
+		conn.SetDeadline(time.Now().Add(1 * time.Second))
+		return
+	}
+
+	records, err := c.decoder.Decode(addr.IP.String(), buf[:n])
+	if err != nil {
+		logging.GetLogger().Debugf("Unable to decode NetFlow packet from %s: %s", addr.IP.String(), err.Error())
+	}
+
+	for _, r := range records {
+		c.updateFlow(r)
+	}
+	flow.PacketsCaptured.WithLabelValues("netflow").Add(float64(len(records)))
+	logging.GetLogger().Debugf("%d NetFlow records received", len(records))
+}
+
+func (c *Collector) asyncFlowPipeline(flows []*flow.Flow) {
+	if c.FlowMappingPipeline != nil {
+		c.FlowMappingPipeline.Enhance(flows)
+	}
+	if c.AnalyzerClient != nil {
+		c.AnalyzerClient.SendFlows(flows)
+	}
+}
+
+func (c *Collector) start() error {
+	addr := net.UDPAddr{
+		Port: c.Port,
+		IP:   net.ParseIP(c.Addr),
+	}
+	conn, err := net.ListenUDP("udp", &addr)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to listen on port %d: %s", c.Port, err.Error())
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(1 * time.Second))
+
+	defer c.flowTable.UnregisterAll()
+	defer c.FlowTableAllocator.Release(c.flowTable)
+
+	agentExpire := config.GetAgentExpire()
+	c.flowTable.RegisterExpire(c.asyncFlowPipeline, agentExpire, agentExpire)
+
+	agentUpdate := config.GetAgentUpdate()
+	c.flowTable.RegisterUpdated(c.asyncFlowPipeline, agentUpdate, agentUpdate)
+
+	feedFlowTable := func() {
+		c.feedFlowTable(conn)
+	}
+	c.flowTable.RegisterDefault(feedFlowTable)
+
+	c.flowTable.Start()
+
+	return nil
+}
+
+func (c *Collector) Start() {
+	c.flowTable = c.FlowTableAllocator.Alloc()
+	go c.start()
+}
+
+func (c *Collector) Stop() {
+	c.flowTable.Stop()
+}
+
+func NewCollector(a string, p int, g *graph.Graph, ac *analyzer.Client,
+	m *mappings.FlowMappingPipeline, fta *flow.TableAllocator) *Collector {
+	return &Collector{
+		Addr:                a,
+		Port:                p,
+		Graph:               g,
+		AnalyzerClient:      ac,
+		FlowMappingPipeline: m,
+		FlowTableAllocator:  fta,
+		decoder:             NewDecoder(),
+	}
+}
+
+func NewCollectorFromConfig(g *graph.Graph, a *analyzer.Client,
+	m *mappings.FlowMappingPipeline, fta *flow.TableAllocator) (*Collector, error) {
+	addr, port, err := config.GetHostPortAttributes("netflow", "listen")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCollector(addr, port, g, a, m, fta), nil
+}
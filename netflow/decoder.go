@@ -0,0 +1,360 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	netflowV5HeaderSize = 24
+	netflowV5RecordSize = 48
+	netflowV9HeaderSize = 20
+	ipfixHeaderSize     = 16
+	ipfixVariableLength = 65535
+)
+
+// Information elements, the NetFlow v9 field types being a subset of the
+// IPFIX ones
+const (
+	fieldInBytes          = 1
+	fieldInPkts           = 2
+	fieldProtocol         = 4
+	fieldL4SrcPort        = 7
+	fieldIPv4SrcAddr      = 8
+	fieldInputSNMP        = 10
+	fieldL4DstPort        = 11
+	fieldIPv4DstAddr      = 12
+	fieldOutputSNMP       = 14
+	fieldLastSwitched     = 21
+	fieldFirstSwitched    = 22
+	fieldIPv6SrcAddr      = 27
+	fieldIPv6DstAddr      = 28
+	fieldSrcMAC           = 56
+	fieldDstMAC           = 80
+	fieldFlowStartSeconds = 150
+	fieldFlowEndSeconds   = 151
+	fieldFlowStartMillis  = 152
+	fieldFlowEndMillis    = 153
+)
+
+var ErrTruncatedPacket = errors.New("Truncated NetFlow packet")
+
+// Record is a flow record exported by a NetFlow v5, v9 or IPFIX exporter,
+// in the direction from the source to the destination
+type Record struct {
+	Exporter string
+	Start    time.Time
+	Last     time.Time
+	SrcMAC   net.HardwareAddr
+	DstMAC   net.HardwareAddr
+	SrcAddr  net.IP
+	DstAddr  net.IP
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+	InputIf  uint32
+	OutputIf uint32
+	Packets  uint64
+	Bytes    uint64
+}
+
+type templateField struct {
+	id     uint16
+	length uint16
+}
+
+// Decoder decodes the NetFlow/IPFIX packets, keeping the templates
+// announced by the exporters to decode their data records.
+type Decoder struct {
+	sync.RWMutex
+	templates map[string][]templateField
+}
+
+func templateKey(exporter string, domain uint32, id uint16) string {
+	return fmt.Sprintf("%s/%d/%d", exporter, domain, id)
+}
+
+func uintValue(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// copyBytes copies a value out of the packet buffer
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+func decodeV5(exporter string, data []byte) ([]*Record, error) {
+	if len(data) < netflowV5HeaderSize {
+		return nil, ErrTruncatedPacket
+	}
+
+	count := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < netflowV5HeaderSize+count*netflowV5RecordSize {
+		return nil, ErrTruncatedPacket
+	}
+
+	uptime := binary.BigEndian.Uint32(data[4:8])
+	export := time.Unix(int64(binary.BigEndian.Uint32(data[8:12])), int64(binary.BigEndian.Uint32(data[12:16])))
+	boot := export.Add(-time.Duration(uptime) * time.Millisecond)
+
+	records := make([]*Record, 0, count)
+	for i := 0; i < count; i++ {
+		r := data[netflowV5HeaderSize+i*netflowV5RecordSize:]
+		records = append(records, &Record{
+			Exporter: exporter,
+			SrcAddr:  net.IP(copyBytes(r[0:4])),
+			DstAddr:  net.IP(copyBytes(r[4:8])),
+			InputIf:  uint32(binary.BigEndian.Uint16(r[12:14])),
+			OutputIf: uint32(binary.BigEndian.Uint16(r[14:16])),
+			Packets:  uint64(binary.BigEndian.Uint32(r[16:20])),
+			Bytes:    uint64(binary.BigEndian.Uint32(r[20:24])),
+			Start:    boot.Add(time.Duration(binary.BigEndian.Uint32(r[24:28])) * time.Millisecond),
+			Last:     boot.Add(time.Duration(binary.BigEndian.Uint32(r[28:32])) * time.Millisecond),
+			SrcPort:  binary.BigEndian.Uint16(r[32:34]),
+			DstPort:  binary.BigEndian.Uint16(r[34:36]),
+			Protocol: r[38],
+		})
+	}
+
+	return records, nil
+}
+
+// parseTemplates registers the templates of a template set, ipfix telling
+// whether the fields may carry an enterprise number
+func (d *Decoder) parseTemplates(exporter string, domain uint32, set []byte, ipfix bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	for len(set) >= 4 {
+		id := binary.BigEndian.Uint16(set[0:2])
+		count := int(binary.BigEndian.Uint16(set[2:4]))
+		set = set[4:]
+
+		fields := make([]templateField, 0, count)
+		for i := 0; i < count; i++ {
+			if len(set) < 4 {
+				return ErrTruncatedPacket
+			}
+			f := templateField{id: binary.BigEndian.Uint16(set[0:2]), length: binary.BigEndian.Uint16(set[2:4])}
+			set = set[4:]
+
+			if ipfix && f.id&0x8000 != 0 {
+				if len(set) < 4 {
+					return ErrTruncatedPacket
+				}
+				// enterprise specific fields are skipped
+				f.id = 0
+				set = set[4:]
+			}
+			fields = append(fields, f)
+		}
+
+		key := templateKey(exporter, domain, id)
+		if count == 0 {
+			// template withdrawal
+			delete(d.templates, key)
+		} else {
+			d.templates[key] = fields
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeDataSet(exporter string, domain uint32, id uint16, set []byte, boot time.Time, export time.Time) ([]*Record, error) {
+	d.RLock()
+	fields, ok := d.templates[templateKey(exporter, domain, id)]
+	d.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown NetFlow template %d from %s", id, exporter)
+	}
+
+	// the end of the set may be padded
+	minSize := 0
+	for _, f := range fields {
+		if f.length == ipfixVariableLength {
+			minSize++
+		} else {
+			minSize += int(f.length)
+		}
+	}
+
+	var records []*Record
+	for len(set) >= minSize && len(set) > 0 {
+		r := &Record{Exporter: exporter, Start: export, Last: export}
+
+		for _, f := range fields {
+			length := int(f.length)
+			if f.length == ipfixVariableLength {
+				if len(set) < 1 {
+					return records, ErrTruncatedPacket
+				}
+				length, set = int(set[0]), set[1:]
+				if length == 255 {
+					if len(set) < 2 {
+						return records, ErrTruncatedPacket
+					}
+					length, set = int(binary.BigEndian.Uint16(set[0:2])), set[2:]
+				}
+			}
+			if len(set) < length {
+				return records, ErrTruncatedPacket
+			}
+			value := set[:length]
+			set = set[length:]
+
+			switch f.id {
+			case fieldInBytes:
+				r.Bytes = uintValue(value)
+			case fieldInPkts:
+				r.Packets = uintValue(value)
+			case fieldProtocol:
+				r.Protocol = uint8(uintValue(value))
+			case fieldL4SrcPort:
+				r.SrcPort = uint16(uintValue(value))
+			case fieldL4DstPort:
+				r.DstPort = uint16(uintValue(value))
+			case fieldIPv4SrcAddr, fieldIPv6SrcAddr:
+				r.SrcAddr = net.IP(copyBytes(value))
+			case fieldIPv4DstAddr, fieldIPv6DstAddr:
+				r.DstAddr = net.IP(copyBytes(value))
+			case fieldInputSNMP:
+				r.InputIf = uint32(uintValue(value))
+			case fieldOutputSNMP:
+				r.OutputIf = uint32(uintValue(value))
+			case fieldSrcMAC:
+				r.SrcMAC = net.HardwareAddr(copyBytes(value))
+			case fieldDstMAC:
+				r.DstMAC = net.HardwareAddr(copyBytes(value))
+			case fieldFirstSwitched:
+				r.Start = boot.Add(time.Duration(uintValue(value)) * time.Millisecond)
+			case fieldLastSwitched:
+				r.Last = boot.Add(time.Duration(uintValue(value)) * time.Millisecond)
+			case fieldFlowStartSeconds:
+				r.Start = time.Unix(int64(uintValue(value)), 0)
+			case fieldFlowEndSeconds:
+				r.Last = time.Unix(int64(uintValue(value)), 0)
+			case fieldFlowStartMillis:
+				r.Start = time.Unix(0, int64(uintValue(value))*int64(time.Millisecond))
+			case fieldFlowEndMillis:
+				r.Last = time.Unix(0, int64(uintValue(value))*int64(time.Millisecond))
+			}
+		}
+
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// decodeSets decodes the template and data sets of a NetFlow v9 or IPFIX
+// packet, the ones of a v9 packet having the ids 0 and 1 for the
+// (options) templates, 2 and 3 for IPFIX.
+func (d *Decoder) decodeSets(exporter string, domain uint32, sets []byte, ipfix bool, boot time.Time, export time.Time) ([]*Record, error) {
+	templateSet, optionsSet := uint16(0), uint16(1)
+	if ipfix {
+		templateSet, optionsSet = 2, 3
+	}
+
+	var records []*Record
+	for len(sets) >= 4 {
+		id := binary.BigEndian.Uint16(sets[0:2])
+		length := int(binary.BigEndian.Uint16(sets[2:4]))
+		if length < 4 || length > len(sets) {
+			return records, ErrTruncatedPacket
+		}
+		set := sets[4:length]
+		sets = sets[length:]
+
+		switch {
+		case id == templateSet:
+			if err := d.parseTemplates(exporter, domain, set, ipfix); err != nil {
+				return records, err
+			}
+		case id == optionsSet:
+			// options are not used
+		case id >= 256:
+			r, err := d.decodeDataSet(exporter, domain, id, set, boot, export)
+			if err != nil {
+				return records, err
+			}
+			records = append(records, r...)
+		}
+	}
+
+	return records, nil
+}
+
+// Decode returns the flow records of a packet sent by the exporter
+func (d *Decoder) Decode(exporter string, data []byte) ([]*Record, error) {
+	if len(data) < 2 {
+		return nil, ErrTruncatedPacket
+	}
+
+	switch version := binary.BigEndian.Uint16(data[0:2]); version {
+	case 5:
+		return decodeV5(exporter, data)
+	case 9:
+		if len(data) < netflowV9HeaderSize {
+			return nil, ErrTruncatedPacket
+		}
+		uptime := binary.BigEndian.Uint32(data[4:8])
+		export := time.Unix(int64(binary.BigEndian.Uint32(data[8:12])), 0)
+		boot := export.Add(-time.Duration(uptime) * time.Millisecond)
+		domain := binary.BigEndian.Uint32(data[16:20])
+
+		return d.decodeSets(exporter, domain, data[netflowV9HeaderSize:], false, boot, export)
+	case 10:
+		if len(data) < ipfixHeaderSize {
+			return nil, ErrTruncatedPacket
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length > len(data) {
+			return nil, ErrTruncatedPacket
+		}
+		export := time.Unix(int64(binary.BigEndian.Uint32(data[4:8])), 0)
+		domain := binary.BigEndian.Uint32(data[12:16])
+
+		// no system uptime in IPFIX, the relative times can't be used
+		return d.decodeSets(exporter, domain, data[ipfixHeaderSize:length], true, export, export)
+	default:
+		return nil, fmt.Errorf("Unsupported NetFlow version %d", version)
+	}
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		templates: make(map[string][]templateField),
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestDecodeV5(t *testing.T) {
+	data := make([]byte, netflowV5HeaderSize+netflowV5RecordSize)
+	binary.BigEndian.PutUint16(data[0:2], 5)
+	binary.BigEndian.PutUint16(data[2:4], 1)
+	binary.BigEndian.PutUint32(data[4:8], 10000)
+	binary.BigEndian.PutUint32(data[8:12], 1000)
+
+	r := data[netflowV5HeaderSize:]
+	copy(r[0:4], net.ParseIP("192.168.0.1").To4())
+	copy(r[4:8], net.ParseIP("192.168.0.2").To4())
+	binary.BigEndian.PutUint16(r[12:14], 3)
+	binary.BigEndian.PutUint16(r[14:16], 4)
+	binary.BigEndian.PutUint32(r[16:20], 10)
+	binary.BigEndian.PutUint32(r[20:24], 1500)
+	binary.BigEndian.PutUint32(r[24:28], 5000)
+	binary.BigEndian.PutUint32(r[28:32], 8000)
+	binary.BigEndian.PutUint16(r[32:34], 34567)
+	binary.BigEndian.PutUint16(r[34:36], 80)
+	r[38] = 6
+
+	records, err := NewDecoder().Decode("10.0.0.1", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("One record expected, got %d", len(records))
+	}
+
+	rec := records[0]
+	if rec.SrcAddr.String() != "192.168.0.1" || rec.DstAddr.String() != "192.168.0.2" ||
+		rec.SrcPort != 34567 || rec.DstPort != 80 || rec.Protocol != 6 {
+		t.Errorf("Wrong endpoints decoded: %+v", rec)
+	}
+
+	if rec.InputIf != 3 || rec.OutputIf != 4 || rec.Packets != 10 || rec.Bytes != 1500 {
+		t.Errorf("Wrong counters decoded: %+v", rec)
+	}
+
+	if rec.Start.Unix() != 995 || rec.Last.Unix() != 998 {
+		t.Errorf("Wrong times decoded: %v %v", rec.Start, rec.Last)
+	}
+}
+
+func TestDecodeV9Template(t *testing.T) {
+	fields := [][2]uint16{
+		{fieldIPv4SrcAddr, 4},
+		{fieldIPv4DstAddr, 4},
+		{fieldInBytes, 4},
+		{fieldInPkts, 4},
+		{fieldInputSNMP, 2},
+	}
+
+	header := make([]byte, netflowV9HeaderSize)
+	binary.BigEndian.PutUint16(header[0:2], 9)
+	binary.BigEndian.PutUint32(header[8:12], 1000)
+
+	template := make([]byte, 8+4*len(fields))
+	binary.BigEndian.PutUint16(template[0:2], 0)
+	binary.BigEndian.PutUint16(template[2:4], uint16(len(template)))
+	binary.BigEndian.PutUint16(template[4:6], 256)
+	binary.BigEndian.PutUint16(template[6:8], uint16(len(fields)))
+	for i, f := range fields {
+		binary.BigEndian.PutUint16(template[8+4*i:], f[0])
+		binary.BigEndian.PutUint16(template[10+4*i:], f[1])
+	}
+
+	// one record of 18 bytes padded to 24
+	set := make([]byte, 24)
+	binary.BigEndian.PutUint16(set[0:2], 256)
+	binary.BigEndian.PutUint16(set[2:4], uint16(len(set)))
+	copy(set[4:8], net.ParseIP("10.0.0.2").To4())
+	copy(set[8:12], net.ParseIP("10.0.0.3").To4())
+	binary.BigEndian.PutUint32(set[12:16], 420)
+	binary.BigEndian.PutUint32(set[16:20], 5)
+	binary.BigEndian.PutUint16(set[20:22], 7)
+
+	d := NewDecoder()
+	if _, err := d.Decode("10.0.0.1", append(header, set...)); err == nil {
+		t.Error("Data set decoded without template")
+	}
+
+	records, err := d.Decode("10.0.0.1", append(append(header, template...), set...))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("One record expected, got %d", len(records))
+	}
+
+	rec := records[0]
+	if rec.SrcAddr.String() != "10.0.0.2" || rec.DstAddr.String() != "10.0.0.3" ||
+		rec.Bytes != 420 || rec.Packets != 5 || rec.InputIf != 7 {
+		t.Errorf("Wrong record decoded: %+v", rec)
+	}
+
+	// the template is kept for the next packets
+	if records, err = d.Decode("10.0.0.1", append(header, set...)); err != nil || len(records) != 1 {
+		t.Errorf("Template not kept: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	ipfixTemplateID = 256
+	ipfixSetHeader  = 4
+	ipfixMaxRecords = 24
+
+	sflowVersion        = 5
+	sflowFlowSample     = 1
+	sflowRawPacketFlow  = 1
+	sflowHeaderEthernet = 1
+	sflowMaxSamples     = 10
+)
+
+// ipfixTemplate lists the information elements of the exported records
+var ipfixTemplate = []templateField{
+	{fieldSrcMAC, 6},
+	{fieldDstMAC, 6},
+	{fieldIPv4SrcAddr, 4},
+	{fieldIPv4DstAddr, 4},
+	{fieldProtocol, 1},
+	{fieldL4SrcPort, 2},
+	{fieldL4DstPort, 2},
+	{fieldInBytes, 8},
+	{fieldInPkts, 8},
+	{fieldFlowStartSeconds, 4},
+	{fieldFlowEndSeconds, 4},
+}
+
+func ipfixRecordSize() int {
+	size := 0
+	for _, f := range ipfixTemplate {
+		size += int(f.length)
+	}
+	return size
+}
+
+func putMAC(b []byte, mac net.HardwareAddr) {
+	if len(mac) == 6 {
+		copy(b, mac)
+	}
+}
+
+func putIPv4(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(b, ip4)
+	}
+}
+
+// encodeIPFIX returns an IPFIX message carrying the records, preceded by
+// the template set if asked. The sequence is the number of records sent
+// before this message.
+func encodeIPFIX(records []*Record, domain uint32, sequence uint32, export time.Time, withTemplate bool) []byte {
+	msg := make([]byte, ipfixHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], 10)
+	binary.BigEndian.PutUint32(msg[4:8], uint32(export.Unix()))
+	binary.BigEndian.PutUint32(msg[8:12], sequence)
+	binary.BigEndian.PutUint32(msg[12:16], domain)
+
+	if withTemplate {
+		set := make([]byte, ipfixSetHeader+4+4*len(ipfixTemplate))
+		binary.BigEndian.PutUint16(set[0:2], 2)
+		binary.BigEndian.PutUint16(set[2:4], uint16(len(set)))
+		binary.BigEndian.PutUint16(set[4:6], ipfixTemplateID)
+		binary.BigEndian.PutUint16(set[6:8], uint16(len(ipfixTemplate)))
+		for i, f := range ipfixTemplate {
+			binary.BigEndian.PutUint16(set[8+4*i:], f.id)
+			binary.BigEndian.PutUint16(set[10+4*i:], f.length)
+		}
+		msg = append(msg, set...)
+	}
+
+	if len(records) > 0 {
+		size := ipfixRecordSize()
+		set := make([]byte, ipfixSetHeader+size*len(records))
+		binary.BigEndian.PutUint16(set[0:2], ipfixTemplateID)
+		binary.BigEndian.PutUint16(set[2:4], uint16(len(set)))
+
+		for i, r := range records {
+			b := set[ipfixSetHeader+i*size:]
+			putMAC(b[0:6], r.SrcMAC)
+			putMAC(b[6:12], r.DstMAC)
+			putIPv4(b[12:16], r.SrcAddr)
+			putIPv4(b[16:20], r.DstAddr)
+			b[20] = r.Protocol
+			binary.BigEndian.PutUint16(b[21:23], r.SrcPort)
+			binary.BigEndian.PutUint16(b[23:25], r.DstPort)
+			binary.BigEndian.PutUint64(b[25:33], r.Bytes)
+			binary.BigEndian.PutUint64(b[33:41], r.Packets)
+			binary.BigEndian.PutUint32(b[41:45], uint32(r.Start.Unix()))
+			binary.BigEndian.PutUint32(b[45:49], uint32(r.Last.Unix()))
+		}
+		msg = append(msg, set...)
+	}
+
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	return msg
+}
+
+// sampledHeader returns the headers of a packet of the record, up to the
+// transport layer
+func sampledHeader(r *Record) []byte {
+	src, dst := r.SrcMAC, r.DstMAC
+	if len(src) != 6 || len(dst) != 6 {
+		src, dst = make(net.HardwareAddr, 6), make(net.HardwareAddr, 6)
+	}
+
+	eth := &layers.Ethernet{SrcMAC: src, DstMAC: dst, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocol(r.Protocol),
+		SrcIP:    r.SrcAddr.To4(),
+		DstIP:    r.DstAddr.To4(),
+	}
+
+	l := []gopacket.SerializableLayer{eth, ip}
+	switch ip.Protocol {
+	case layers.IPProtocolTCP:
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(r.SrcPort), DstPort: layers.TCPPort(r.DstPort), DataOffset: 5}
+		tcp.SetNetworkLayerForChecksum(ip)
+		l = append(l, tcp)
+	case layers.IPProtocolUDP:
+		udp := &layers.UDP{SrcPort: layers.UDPPort(r.SrcPort), DstPort: layers.UDPPort(r.DstPort)}
+		udp.SetNetworkLayerForChecksum(ip)
+		l = append(l, udp)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, l...); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// encodeSFlow returns a sFlow v5 datagram carrying a flow sample per
+// record, the sampled packet headers being rebuilt from the record. The
+// sampling rate of a sample is the number of packets of the record, the
+// pool is the total number of packets exported so far.
+func encodeSFlow(records []*Record, agent net.IP, sequence uint32, samples uint32, pool uint32, uptime time.Duration) []byte {
+	put := func(b []byte, v ...uint32) []byte {
+		for _, i := range v {
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], i)
+		}
+		return b
+	}
+
+	msg := put(nil, sflowVersion, 1)
+	addr := make([]byte, 4)
+	putIPv4(addr, agent)
+	msg = append(msg, addr...)
+	msg = put(msg, 0, sequence, uint32(uptime/time.Millisecond), uint32(len(records)))
+
+	for _, r := range records {
+		header := sampledHeader(r)
+		padded := append(header, make([]byte, (4-len(header)%4)%4)...)
+
+		frameLength := uint32(0)
+		if r.Packets > 0 {
+			frameLength = uint32(r.Bytes / r.Packets)
+		}
+
+		record := put(nil, sflowRawPacketFlow, uint32(16+len(padded)), sflowHeaderEthernet, frameLength, 0, uint32(len(header)))
+		record = append(record, padded...)
+
+		samples++
+		pool += uint32(r.Packets)
+
+		sample := put(nil, samples, 0, uint32(r.Packets), pool, 0, r.InputIf, r.OutputIf, 1)
+		sample = append(sample, record...)
+
+		msg = put(msg, sflowFlowSample, uint32(len(sample)))
+		msg = append(msg, sample...)
+	}
+
+	return msg
+}
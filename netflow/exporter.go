@@ -0,0 +1,253 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+const (
+	ipfixDefaultPort = "4739"
+	sflowDefaultPort = "6343"
+
+	ipfixTemplateInterval = 30 * time.Second
+	exportedFlowTTL       = 10 * time.Minute
+)
+
+type exportedCounters struct {
+	packets uint64
+	bytes   uint64
+	last    time.Time
+}
+
+// Exporter re-exports the flows computed by Skydive as IPFIX or sFlow
+// records toward a third party collector. A flow is exported as a record
+// per direction carrying the packets and the bytes since its previous
+// export.
+type Exporter struct {
+	sync.Mutex
+	Target       string
+	protocol     string
+	conn         *net.UDPConn
+	counters     map[string]*exportedCounters
+	sequence     uint32
+	samples      uint32
+	pool         uint32
+	start        time.Time
+	lastTemplate time.Time
+}
+
+func parsePort(v string) uint16 {
+	port, _ := strconv.ParseUint(v, 10, 16)
+	return uint16(port)
+}
+
+// flowRecords returns the A to B and B to A records of an IPv4 flow
+func flowRecords(f *flow.Flow) []*Record {
+	fs := f.GetStatistics()
+	if fs == nil {
+		return nil
+	}
+
+	ab := &Record{Start: time.Unix(fs.Start, 0), Last: time.Unix(fs.Last, 0)}
+	ba := &Record{Start: ab.Start, Last: ab.Last}
+
+	ipv4 := false
+	for _, ep := range fs.GetEndpoints() {
+		if ep.AB == nil || ep.BA == nil {
+			continue
+		}
+
+		switch ep.Type {
+		case flow.FlowEndpointType_ETHERNET:
+			ab.SrcMAC, _ = net.ParseMAC(ep.AB.Value)
+			ab.DstMAC, _ = net.ParseMAC(ep.BA.Value)
+			ba.SrcMAC, ba.DstMAC = ab.DstMAC, ab.SrcMAC
+		case flow.FlowEndpointType_IPV4:
+			ab.SrcAddr, ab.DstAddr = net.ParseIP(ep.AB.Value), net.ParseIP(ep.BA.Value)
+			ba.SrcAddr, ba.DstAddr = ab.DstAddr, ab.SrcAddr
+			ab.Packets, ab.Bytes = ep.AB.Packets, ep.AB.Bytes
+			ba.Packets, ba.Bytes = ep.BA.Packets, ep.BA.Bytes
+			ipv4 = ab.SrcAddr.To4() != nil && ab.DstAddr.To4() != nil
+		case flow.FlowEndpointType_TCPPORT, flow.FlowEndpointType_UDPPORT, flow.FlowEndpointType_SCTPPORT:
+			switch ep.Type {
+			case flow.FlowEndpointType_TCPPORT:
+				ab.Protocol = uint8(layers.IPProtocolTCP)
+			case flow.FlowEndpointType_UDPPORT:
+				ab.Protocol = uint8(layers.IPProtocolUDP)
+			default:
+				ab.Protocol = uint8(layers.IPProtocolSCTP)
+			}
+			ba.Protocol = ab.Protocol
+			ab.SrcPort, ab.DstPort = parsePort(ep.AB.Value), parsePort(ep.BA.Value)
+			ba.SrcPort, ba.DstPort = ab.DstPort, ab.SrcPort
+		}
+	}
+
+	if !ipv4 {
+		return nil
+	}
+	return []*Record{ab, ba}
+}
+
+// deltaRecords returns the records of the flows with the counters since
+// their previous export, the records without new packets being dropped
+func (e *Exporter) deltaRecords(flows []*flow.Flow, now time.Time) []*Record {
+	var records []*Record
+	for _, f := range flows {
+		for i, r := range flowRecords(f) {
+			key := fmt.Sprintf("%s/%d", f.UUID, i)
+
+			c, ok := e.counters[key]
+			if !ok {
+				c = &exportedCounters{}
+				e.counters[key] = c
+			}
+			c.last = now
+
+			packets, bytes := r.Packets, r.Bytes
+			if packets >= c.packets && bytes >= c.bytes {
+				r.Packets, r.Bytes = packets-c.packets, bytes-c.bytes
+			}
+			c.packets, c.bytes = packets, bytes
+
+			if r.Packets > 0 {
+				records = append(records, r)
+			}
+		}
+	}
+
+	for key, c := range e.counters {
+		if now.Sub(c.last) > exportedFlowTTL {
+			delete(e.counters, key)
+		}
+	}
+
+	return records
+}
+
+func (e *Exporter) send(msg []byte) {
+	if _, err := e.conn.Write(msg); err != nil {
+		logging.GetLogger().Errorf("Unable to export flows to %s: %s", e.Target, err.Error())
+	}
+}
+
+// Export sends the records of the flows to the collector
+func (e *Exporter) Export(flows []*flow.Flow) {
+	e.Lock()
+	defer e.Unlock()
+
+	now := time.Now()
+	records := e.deltaRecords(flows, now)
+
+	switch e.protocol {
+	case "ipfix":
+		withTemplate := now.Sub(e.lastTemplate) > ipfixTemplateInterval
+		if withTemplate {
+			e.lastTemplate = now
+		}
+
+		for len(records) > 0 || withTemplate {
+			n := len(records)
+			if n > ipfixMaxRecords {
+				n = ipfixMaxRecords
+			}
+
+			e.send(encodeIPFIX(records[:n], 0, e.sequence, now, withTemplate))
+			e.sequence += uint32(n)
+			records, withTemplate = records[n:], false
+		}
+	case "sflow":
+		agent := e.conn.LocalAddr().(*net.UDPAddr).IP
+		for len(records) > 0 {
+			n := len(records)
+			if n > sflowMaxSamples {
+				n = sflowMaxSamples
+			}
+
+			e.sequence++
+			e.send(encodeSFlow(records[:n], agent, e.sequence, e.samples, e.pool, now.Sub(e.start)))
+			for _, r := range records[:n] {
+				e.samples++
+				e.pool += uint32(r.Packets)
+			}
+			records = records[n:]
+		}
+	}
+}
+
+func (e *Exporter) Close() {
+	e.conn.Close()
+}
+
+// NewExporter returns an exporter sending to the collector given as
+// ipfix://host[:port] or sflow://host[:port]
+func NewExporter(target string) (*Exporter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var port string
+	switch u.Scheme {
+	case "ipfix":
+		port = ipfixDefaultPort
+	case "sflow":
+		port = sflowDefaultPort
+	default:
+		return nil, fmt.Errorf("Unsupported flow export protocol %s, expected ipfix or sflow", u.Scheme)
+	}
+
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, port)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		Target:   target,
+		protocol: u.Scheme,
+		conn:     conn,
+		counters: make(map[string]*exportedCounters),
+		start:    time.Now(),
+	}, nil
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/flow"
+)
+
+func newExportedFlow() *flow.Flow {
+	fs := &flow.FlowStatistics{Start: 1000, Last: 1010}
+	fs.Endpoints = []*flow.FlowEndpointsStatistics{
+		flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_ETHERNET, "00:11:22:33:44:55", "00:11:22:33:44:66"),
+		flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_IPV4, "192.168.0.1", "192.168.0.2"),
+		flow.NewFlowEndpointsStatistics(flow.FlowEndpointType_TCPPORT, layers.TCPPort(34567), layers.TCPPort(80)),
+	}
+	for _, ep := range fs.Endpoints {
+		ep.AB.Packets, ep.AB.Bytes = 10, 1500
+		ep.BA.Packets, ep.BA.Bytes = 5, 500
+	}
+
+	return &flow.Flow{UUID: "flow1", Statistics: fs}
+}
+
+func newTestCollector(t *testing.T, protocol string) (*net.UDPConn, *Exporter) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exporter, err := NewExporter(fmt.Sprintf("%s://%s", protocol, conn.LocalAddr().String()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return conn, exporter
+}
+
+func receive(t *testing.T, conn *net.UDPConn) []byte {
+	var buf [maxDgramSize]byte
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return buf[:n]
+}
+
+func TestExportIPFIX(t *testing.T) {
+	conn, exporter := newTestCollector(t, "ipfix")
+	defer conn.Close()
+	defer exporter.Close()
+
+	f := newExportedFlow()
+	exporter.Export([]*flow.Flow{f})
+
+	d := NewDecoder()
+	records, err := d.Decode("127.0.0.1", receive(t, conn))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Two records expected, got %d", len(records))
+	}
+
+	ab, ba := records[0], records[1]
+	if ab.SrcAddr.String() != "192.168.0.1" || ab.DstPort != 80 || ab.Protocol != 6 || ab.SrcMAC.String() != "00:11:22:33:44:55" {
+		t.Errorf("Wrong A to B record: %+v", ab)
+	}
+	if ba.SrcAddr.String() != "192.168.0.2" || ba.SrcPort != 80 || ba.Packets != 5 || ba.Bytes != 500 {
+		t.Errorf("Wrong B to A record: %+v", ba)
+	}
+	if ab.Start.Unix() != 1000 || ab.Last.Unix() != 1010 {
+		t.Errorf("Wrong times exported: %v %v", ab.Start, ab.Last)
+	}
+
+	// only the new packets are exported, the template not being sent again
+	ipv4 := f.Statistics.Endpoints[1]
+	ipv4.AB.Packets, ipv4.AB.Bytes = 12, 1800
+	exporter.Export([]*flow.Flow{f})
+
+	records, err = d.Decode("127.0.0.1", receive(t, conn))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(records) != 1 || records[0].Packets != 2 || records[0].Bytes != 300 {
+		t.Errorf("Only the delta of A to B expected, got: %+v", records)
+	}
+}
+
+func TestExportSFlow(t *testing.T) {
+	conn, exporter := newTestCollector(t, "sflow")
+	defer conn.Close()
+	defer exporter.Close()
+
+	exporter.Export([]*flow.Flow{newExportedFlow()})
+
+	var sflow layers.SFlowDatagram
+	if err := sflow.DecodeFromBytes(receive(t, conn), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(sflow.FlowSamples) != 2 {
+		t.Fatalf("Two samples expected, got %d", len(sflow.FlowSamples))
+	}
+
+	sample := sflow.FlowSamples[0]
+	if sample.SamplingRate != 10 || len(sample.Records) != 1 {
+		t.Fatalf("Wrong sample: %+v", sample)
+	}
+
+	record := sample.Records[0].(layers.SFlowRawPacketFlowRecord)
+	if record.FrameLength != 150 {
+		t.Errorf("Wrong frame length: %d", record.FrameLength)
+	}
+
+	ip, ok := record.Header.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok || ip.SrcIP.String() != "192.168.0.1" || ip.DstIP.String() != "192.168.0.2" {
+		t.Errorf("Wrong sampled header: %s", record.Header)
+	}
+
+	tcp, ok := record.Header.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || tcp.DstPort != 80 {
+		t.Errorf("Wrong sampled header: %s", record.Header)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovsdb
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/socketplane/libovsdb"
+)
+
+// DatabaseMonitor monitors all the columns of some tables of any OVSDB
+// database, the OVN ones for instance, the updates being passed as is to
+// the handler.
+type DatabaseMonitor struct {
+	Protocol  string
+	Target    string
+	TLSConfig *tls.Config
+	Database  string
+	Tables    []string
+	Handler   func(updates *libovsdb.TableUpdates)
+	client    *libovsdb.OvsdbClient
+	tunnel    *tlsTunnel
+}
+
+type databaseNotifier struct {
+	Notifier
+	db *DatabaseMonitor
+}
+
+func (n databaseNotifier) Update(context interface{}, tableUpdates libovsdb.TableUpdates) {
+	n.db.Handler(&tableUpdates)
+}
+
+func (m *DatabaseMonitor) Start() error {
+	client, tunnel, err := dial(m.Protocol, m.Target, m.TLSConfig)
+	if err != nil {
+		return err
+	}
+	m.client, m.tunnel = client, tunnel
+
+	schema, ok := client.Schema[m.Database]
+	if !ok {
+		m.Stop()
+		return errors.New("Unknown database: " + m.Database)
+	}
+
+	requests := make(map[string]libovsdb.MonitorRequest)
+	for _, table := range m.Tables {
+		var columns []string
+		for column := range schema.Tables[table].Columns {
+			columns = append(columns, column)
+		}
+
+		requests[table] = libovsdb.MonitorRequest{
+			Columns: columns,
+			Select: libovsdb.MonitorSelect{
+				Initial: true,
+				Insert:  true,
+				Delete:  true,
+				Modify:  true,
+			},
+		}
+	}
+
+	client.Register(databaseNotifier{db: m})
+
+	updates, err := client.Monitor(m.Database, "", requests)
+	if err != nil {
+		m.Stop()
+		return err
+	}
+	m.Handler(updates)
+
+	return nil
+}
+
+func (m *DatabaseMonitor) Stop() {
+	if m.client != nil {
+		m.client.Disconnect()
+		m.client = nil
+	}
+	if m.tunnel != nil {
+		m.tunnel.Close()
+		m.tunnel = nil
+	}
+}
+
+func NewDatabaseMonitor(protocol string, target string, database string, tables []string, handler func(updates *libovsdb.TableUpdates)) *DatabaseMonitor {
+	return &DatabaseMonitor{
+		Protocol: protocol,
+		Target:   target,
+		Database: database,
+		Tables:   tables,
+		Handler:  handler,
+	}
+}
@@ -23,6 +23,7 @@
 package ovsdb
 
 import (
+	"crypto/tls"
 	"errors"
 	"reflect"
 	"sync"
@@ -50,8 +51,11 @@ type OvsMonitorHandler interface {
 
 type OvsMonitor struct {
 	sync.RWMutex
+	// tcp, unix or ssl, TLSConfig being then used
 	Protocol        string
 	Target          string
+	TLSConfig       *tls.Config
+	tunnel          *tlsTunnel
 	OvsClient       *OvsClient
 	MonitorHandlers []OvsMonitorHandler
 	bridgeCache     map[string]string
@@ -302,12 +306,36 @@ func (o *OvsMonitor) AddMonitorHandler(handler OvsMonitorHandler) {
 	o.MonitorHandlers = append(o.MonitorHandlers, handler)
 }
 
+// dial connects to an OVSDB server, the ssl connections going through a
+// local TLS tunnel
+func dial(protocol string, target string, tlsConfig *tls.Config) (*libovsdb.OvsdbClient, *tlsTunnel, error) {
+	var tunnel *tlsTunnel
+	if protocol == "ssl" {
+		var err error
+		if tunnel, err = newTLSTunnel(target, tlsConfig); err != nil {
+			return nil, nil, err
+		}
+		protocol, target = "unix", tunnel.path()
+	}
+
+	ovsdb, err := libovsdb.ConnectUsingProtocol(protocol, target)
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, nil, err
+	}
+
+	return ovsdb, tunnel, nil
+}
+
 func (o *OvsMonitor) StartMonitoring() error {
-	ovsdb, err := libovsdb.ConnectUsingProtocol(o.Protocol, o.Target)
+	ovsdb, tunnel, err := dial(o.Protocol, o.Target, o.TLSConfig)
 	if err != nil {
 		return err
 	}
 	o.OvsClient = &OvsClient{ovsdb: ovsdb}
+	o.tunnel = tunnel
 
 	notifier := Notifier{monitor: o}
 	ovsdb.Register(notifier)
@@ -342,6 +370,10 @@ func (o *OvsMonitor) StopMonitoring() {
 	if o.OvsClient != nil {
 		o.OvsClient.ovsdb.Disconnect()
 	}
+	if o.tunnel != nil {
+		o.tunnel.Close()
+		o.tunnel = nil
+	}
 }
 
 func NewOvsMonitor(protcol string, target string) *OvsMonitor {
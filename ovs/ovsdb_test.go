@@ -23,6 +23,12 @@
 package ovsdb
 
 import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/socketplane/libovsdb"
@@ -128,3 +134,28 @@ func TestBridgeDeleted(t *testing.T) {
 }
 
 /* TODO(safchain) Add UT for interface adding */
+
+func TestTLSTunnel(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("through the tunnel"))
+	}))
+	defer server.Close()
+
+	tunnel, err := newTLSTunnel(strings.TrimPrefix(server.URL, "https://"), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer tunnel.Close()
+
+	conn, err := net.Dial("unix", tunnel.path())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	data, _ := ioutil.ReadAll(conn)
+	if !strings.Contains(string(data), "through the tunnel") {
+		t.Fatalf("Unexpected response through the tunnel: %s", string(data))
+	}
+}
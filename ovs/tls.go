@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ovsdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// tlsTunnel forwards the connections accepted on a local unix socket to a
+// remote OVSDB server over SSL, the OVSDB client library being only able to
+// dial plain sockets.
+type tlsTunnel struct {
+	listener net.Listener
+	dir      string
+	target   string
+	config   *tls.Config
+}
+
+func (t *tlsTunnel) path() string {
+	return filepath.Join(t.dir, "ovsdb.sock")
+}
+
+func (t *tlsTunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := tls.Dial("tcp", t.target, t.config)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to connect to the OVSDB server %s: %s", t.target, err.Error())
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- true
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- true
+	}()
+	<-done
+}
+
+func (t *tlsTunnel) run() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *tlsTunnel) Close() {
+	t.listener.Close()
+	os.RemoveAll(t.dir)
+}
+
+func newTLSTunnel(target string, config *tls.Config) (*tlsTunnel, error) {
+	dir, err := ioutil.TempDir("", "skydive-ovsdb")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tlsTunnel{dir: dir, target: target, config: config}
+	if t.listener, err = net.Listen("unix", t.path()); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	go t.run()
+
+	return t, nil
+}
+
+// NewTLSConfig returns the SSL configuration used to connect to an OVSDB
+// server with the private key, the certificate and the certificate of the
+// authority of the server, PEM encoded files.
+func NewTLSConfig(privateKey string, certificate string, caCert string) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if privateKey != "" || certificate != "" {
+		cert, err := tls.LoadX509KeyPair(certificate, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("No certificate found in " + caCert)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
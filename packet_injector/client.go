@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package packet_injector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// Client asks the agents to inject packets
+type Client struct {
+	shttp.DefaultWSServerEventHandler
+	WSServer       *shttp.WSServer
+	replyChanMutex sync.RWMutex
+	replyChan      map[string]chan *json.RawMessage
+}
+
+func (c *Client) OnMessage(client *shttp.WSClient, m shttp.WSMessage) {
+	// replies are only accepted from the agents, authenticated as admins
+	if client.ClientType() != shttp.AgentClient || !client.Role().Allows(shttp.AdminRole) {
+		logging.GetLogger().Warningf("Packet injection reply refused to the client %s", client.RemoteAddr())
+		return
+	}
+
+	c.replyChanMutex.RLock()
+	defer c.replyChanMutex.RUnlock()
+
+	ch, ok := c.replyChan[m.UUID]
+	if !ok {
+		logging.GetLogger().Errorf("Unable to send reply, chan not found for %s", m.UUID)
+		return
+	}
+
+	ch <- m.Obj
+}
+
+// InjectPacket asks the agent of the host to inject the packets and waits
+// for them to be sent
+func (c *Client) InjectPacket(host string, pp *PacketParams) error {
+	u, _ := uuid.NewV4()
+
+	b, _ := json.Marshal(pp)
+	raw := json.RawMessage(b)
+
+	msg := shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      "InjectPacket",
+		UUID:      u.String(),
+		Obj:       &raw,
+	}
+
+	ch := make(chan *json.RawMessage)
+	defer close(ch)
+
+	c.replyChanMutex.Lock()
+	c.replyChan[u.String()] = ch
+	c.replyChanMutex.Unlock()
+
+	defer func() {
+		c.replyChanMutex.Lock()
+		delete(c.replyChan, u.String())
+		c.replyChanMutex.Unlock()
+	}()
+
+	if !c.WSServer.SendWSMessageTo(msg, host) {
+		return fmt.Errorf("Unable to send message to agent: %s", host)
+	}
+
+	timeout := 10*time.Second + time.Duration(pp.Count*pp.Interval)*time.Millisecond
+
+	select {
+	case raw := <-ch:
+		var reply InjectionReply
+		if err := json.Unmarshal([]byte(*raw), &reply); err != nil {
+			return fmt.Errorf("Error returned while reading InjectionReply from: %s", host)
+		}
+
+		if reply.Status != http.StatusOK {
+			return errors.New(reply.Error)
+		}
+
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("Timeout while reading InjectionReply from: %s", host)
+	}
+}
+
+func NewClient(w *shttp.WSServer) *Client {
+	c := &Client{
+		WSServer:  w,
+		replyChan: make(map[string]chan *json.RawMessage),
+	}
+	w.AddEventHandler(c, Namespace)
+
+	return c
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package packet_injector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/vishvananda/netns"
+
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// PacketParams describes the packets injected from the interface of a node
+type PacketParams struct {
+	NodeID   string
+	Type     string
+	SrcMAC   string
+	DstMAC   string
+	SrcIP    string
+	DstIP    string
+	SrcPort  uint16
+	DstPort  uint16
+	ID       uint16
+	Count    int
+	Interval int
+	Payload  string
+}
+
+// Validate checks the parameters, Interval being in milliseconds
+func (pp *PacketParams) Validate() error {
+	switch pp.Type {
+	case "icmp", "tcp", "udp":
+	default:
+		return fmt.Errorf("Unsupported packet type: %s", pp.Type)
+	}
+
+	if _, err := net.ParseMAC(pp.SrcMAC); err != nil {
+		return fmt.Errorf("Invalid source MAC address: %s", pp.SrcMAC)
+	}
+	if _, err := net.ParseMAC(pp.DstMAC); err != nil {
+		return fmt.Errorf("Invalid destination MAC address: %s", pp.DstMAC)
+	}
+	if ip := net.ParseIP(pp.SrcIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("Invalid source IPv4 address: %s", pp.SrcIP)
+	}
+	if ip := net.ParseIP(pp.DstIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("Invalid destination IPv4 address: %s", pp.DstIP)
+	}
+
+	if pp.Count <= 0 {
+		return errors.New("The number of packets has to be positive")
+	}
+	if pp.Interval < 0 {
+		return errors.New("The interval between the packets can't be negative")
+	}
+
+	return nil
+}
+
+// forgePacket returns the bytes of the seq-th packet to inject
+func forgePacket(pp *PacketParams, seq int) ([]byte, error) {
+	srcMAC, err := net.ParseMAC(pp.SrcMAC)
+	if err != nil {
+		return nil, err
+	}
+	dstMAC, err := net.ParseMAC(pp.DstMAC)
+	if err != nil {
+		return nil, err
+	}
+
+	ethLayer := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ipLayer := &layers.IPv4{
+		Version: 4,
+		TTL:     64,
+		SrcIP:   net.ParseIP(pp.SrcIP).To4(),
+		DstIP:   net.ParseIP(pp.DstIP).To4(),
+	}
+	payload := gopacket.Payload([]byte(pp.Payload))
+
+	var transportLayer gopacket.SerializableLayer
+	switch pp.Type {
+	case "icmp":
+		ipLayer.Protocol = layers.IPProtocolICMPv4
+		transportLayer = &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id:       pp.ID,
+			Seq:      uint16(seq),
+		}
+	case "tcp":
+		ipLayer.Protocol = layers.IPProtocolTCP
+		tcpLayer := &layers.TCP{
+			SrcPort: layers.TCPPort(pp.SrcPort),
+			DstPort: layers.TCPPort(pp.DstPort),
+			Seq:     uint32(seq),
+			SYN:     true,
+			Window:  14600,
+		}
+		tcpLayer.SetNetworkLayerForChecksum(ipLayer)
+		transportLayer = tcpLayer
+	case "udp":
+		ipLayer.Protocol = layers.IPProtocolUDP
+		udpLayer := &layers.UDP{
+			SrcPort: layers.UDPPort(pp.SrcPort),
+			DstPort: layers.UDPPort(pp.DstPort),
+		}
+		udpLayer.SetNetworkLayerForChecksum(ipLayer)
+		transportLayer = udpLayer
+	default:
+		return nil, fmt.Errorf("Unsupported packet type: %s", pp.Type)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	if err := gopacket.SerializeLayers(buffer, options, ethLayer, ipLayer, transportLayer, payload); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// InjectPacket sends the packets from the interface of the node, entering
+// the namespace of the interface if needed
+func InjectPacket(pp *PacketParams, g *graph.Graph) error {
+	if err := pp.Validate(); err != nil {
+		return err
+	}
+
+	g.RLock()
+	n := g.GetNode(graph.Identifier(pp.NodeID))
+	if n == nil {
+		g.RUnlock()
+		return fmt.Errorf("Unable to find the node %s", pp.NodeID)
+	}
+
+	name, ok := n.Metadata()["Name"].(string)
+	if !ok || name == "" {
+		g.RUnlock()
+		return fmt.Errorf("The node %s has no interface name", pp.NodeID)
+	}
+
+	nodes := g.LookupShortestPath(n, graph.Metadata{"Type": "host"}, graph.Metadata{"RelationType": "ownership"})
+	g.RUnlock()
+
+	if len(nodes) == 0 {
+		return fmt.Errorf("Failed to determine the path of %s", name)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("Error while getting current ns: %s", err.Error())
+	}
+	defer origns.Close()
+
+	for _, node := range nodes {
+		if node.Metadata()["Type"] == "netns" {
+			nsName := node.Metadata()["Name"].(string)
+			path := node.Metadata()["Path"].(string)
+			logging.GetLogger().Debugf("Switching to namespace %s (path: %s)", nsName, path)
+
+			newns, err := netns.GetFromPath(path)
+			if err != nil {
+				return fmt.Errorf("Error while opening ns %s (path: %s): %s", nsName, path, err.Error())
+			}
+			defer newns.Close()
+
+			if err := netns.Set(newns); err != nil {
+				return fmt.Errorf("Error while switching from root ns to %s (path: %s): %s", nsName, path, err.Error())
+			}
+			defer netns.Set(origns)
+		}
+	}
+
+	handle, err := pcap.OpenLive(name, 1024, false, time.Second)
+	if err != nil {
+		return fmt.Errorf("Error while opening device %s: %s", name, err.Error())
+	}
+	defer handle.Close()
+
+	for i := 0; i < pp.Count; i++ {
+		if i > 0 && pp.Interval > 0 {
+			time.Sleep(time.Duration(pp.Interval) * time.Millisecond)
+		}
+
+		packet, err := forgePacket(pp, i)
+		if err != nil {
+			return err
+		}
+
+		logging.GetLogger().Debugf("Injecting %s packet %d on %s", pp.Type, i, name)
+		if err := handle.WritePacketData(packet); err != nil {
+			return fmt.Errorf("Error while injecting a packet on %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package packet_injector
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func newPacketParams(t string) *PacketParams {
+	return &PacketParams{
+		Type:    t,
+		SrcMAC:  "00:11:22:33:44:55",
+		DstMAC:  "66:77:88:99:aa:bb",
+		SrcIP:   "192.168.0.1",
+		DstIP:   "192.168.0.2",
+		SrcPort: 1234,
+		DstPort: 80,
+		ID:      42,
+		Count:   1,
+		Payload: "skydive",
+	}
+}
+
+func decodeForged(t *testing.T, pp *PacketParams, seq int) gopacket.Packet {
+	data, err := forgePacket(pp, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	if errLayer := packet.ErrorLayer(); errLayer != nil {
+		t.Fatalf("Forged packet not decodable: %s", errLayer.Error())
+	}
+
+	eth := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if eth.SrcMAC.String() != pp.SrcMAC || eth.DstMAC.String() != pp.DstMAC {
+		t.Errorf("Wrong MAC addresses: %s -> %s", eth.SrcMAC, eth.DstMAC)
+	}
+
+	ip := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if ip.SrcIP.String() != pp.SrcIP || ip.DstIP.String() != pp.DstIP {
+		t.Errorf("Wrong IP addresses: %s -> %s", ip.SrcIP, ip.DstIP)
+	}
+
+	if app := packet.ApplicationLayer(); app == nil || string(app.Payload()) != pp.Payload {
+		t.Errorf("Wrong payload: %v", app)
+	}
+
+	return packet
+}
+
+func TestForgeICMP(t *testing.T) {
+	pp := newPacketParams("icmp")
+	packet := decodeForged(t, pp, 3)
+
+	icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok {
+		t.Fatal("ICMPv4 layer not found")
+	}
+	if icmp.TypeCode.Type() != layers.ICMPv4TypeEchoRequest || icmp.Id != 42 || icmp.Seq != 3 {
+		t.Errorf("Wrong ICMP echo request: %+v", icmp)
+	}
+}
+
+func TestForgeTCP(t *testing.T) {
+	pp := newPacketParams("tcp")
+	packet := decodeForged(t, pp, 0)
+
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		t.Fatal("TCP layer not found")
+	}
+	if tcp.SrcPort != 1234 || tcp.DstPort != 80 || !tcp.SYN {
+		t.Errorf("Wrong TCP segment: %+v", tcp)
+	}
+}
+
+func TestForgeUDP(t *testing.T) {
+	pp := newPacketParams("udp")
+	packet := decodeForged(t, pp, 0)
+
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		t.Fatal("UDP layer not found")
+	}
+	if udp.SrcPort != 1234 || udp.DstPort != 80 {
+		t.Errorf("Wrong UDP datagram: %+v", udp)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := newPacketParams("icmp").Validate(); err != nil {
+		t.Error(err)
+	}
+
+	pp := newPacketParams("arp")
+	if err := pp.Validate(); err == nil {
+		t.Error("Unsupported packet type should be refused")
+	}
+
+	pp = newPacketParams("udp")
+	pp.DstIP = "fe80::1"
+	if err := pp.Validate(); err == nil {
+		t.Error("IPv6 destination should be refused")
+	}
+
+	pp = newPacketParams("tcp")
+	pp.Count = 0
+	if err := pp.Validate(); err == nil {
+		t.Error("Null packet count should be refused")
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package packet_injector
+
+import (
+	"encoding/json"
+	"net/http"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const (
+	Namespace = "PacketInjector"
+)
+
+type InjectionReply struct {
+	Status int
+	Error  string
+}
+
+// Server injects the packets requested by the analyzers on the interfaces
+// of the agent
+type Server struct {
+	shttp.DefaultWSClientEventHandler
+	WSAsyncClient *shttp.WSAsyncClient
+	Graph         *graph.Graph
+}
+
+func (s *Server) inject(msg shttp.WSMessage) {
+	reply := InjectionReply{Status: http.StatusOK}
+
+	var pp PacketParams
+	if err := json.Unmarshal([]byte(*msg.Obj), &pp); err != nil {
+		logging.GetLogger().Errorf("Unable to decode packet injection message %v", msg)
+		reply.Status, reply.Error = http.StatusBadRequest, err.Error()
+	} else if err := InjectPacket(&pp, s.Graph); err != nil {
+		logging.GetLogger().Errorf("Failed to inject packets: %s", err.Error())
+		reply.Status, reply.Error = http.StatusInternalServerError, err.Error()
+	}
+
+	b, _ := json.Marshal(reply)
+	raw := json.RawMessage(b)
+
+	s.WSAsyncClient.SendWSMessage(shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      "InjectionReply",
+		UUID:      msg.UUID,
+		Obj:       &raw,
+	})
+}
+
+func (s *Server) OnMessage(msg shttp.WSMessage) {
+	if msg.Type != "InjectPacket" {
+		return
+	}
+
+	// the packets are sent by interval, don't block the other messages
+	go s.inject(msg)
+}
+
+func NewServer(g *graph.Graph, client *shttp.WSAsyncClient) *Server {
+	s := &Server{
+		Graph:         g,
+		WSAsyncClient: client,
+	}
+	client.AddEventHandler(s, Namespace)
+
+	return s
+}
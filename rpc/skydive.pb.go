@@ -0,0 +1,560 @@
+// Code generated by protoc-gen-go.
+// source: rpc/skydive.proto
+// DO NOT EDIT!
+
+/*
+Package rpc is a generated protocol buffer package.
+
+It is generated from these files:
+
+	rpc/skydive.proto
+
+It has these top-level messages:
+
+	Node
+	Edge
+	TopologyRequest
+	TopologyReply
+	TopologyEvent
+	FlowFilter
+	FlowRequest
+	FlowReply
+	Capture
+	CaptureRequest
+	CaptureList
+	Empty
+*/
+package rpc
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import flow "github.com/redhat-cip/skydive/flow"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+const _ = proto.ProtoPackageIsVersion1
+
+// The metadata are JSON encoded, their values being of any type
+type Node struct {
+	ID       string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+	Host     string `protobuf:"bytes,2,opt,name=Host" json:"Host,omitempty"`
+	Metadata string `protobuf:"bytes,3,opt,name=Metadata" json:"Metadata,omitempty"`
+}
+
+func (m *Node) Reset()         { *m = Node{} }
+func (m *Node) String() string { return proto.CompactTextString(m) }
+func (*Node) ProtoMessage()    {}
+
+type Edge struct {
+	ID       string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+	Host     string `protobuf:"bytes,2,opt,name=Host" json:"Host,omitempty"`
+	Metadata string `protobuf:"bytes,3,opt,name=Metadata" json:"Metadata,omitempty"`
+	Parent   string `protobuf:"bytes,4,opt,name=Parent" json:"Parent,omitempty"`
+	Child    string `protobuf:"bytes,5,opt,name=Child" json:"Child,omitempty"`
+}
+
+func (m *Edge) Reset()         { *m = Edge{} }
+func (m *Edge) String() string { return proto.CompactTextString(m) }
+func (*Edge) ProtoMessage()    {}
+
+type TopologyRequest struct {
+	GremlinQuery string `protobuf:"bytes,1,opt,name=GremlinQuery" json:"GremlinQuery,omitempty"`
+}
+
+func (m *TopologyRequest) Reset()         { *m = TopologyRequest{} }
+func (m *TopologyRequest) String() string { return proto.CompactTextString(m) }
+func (*TopologyRequest) ProtoMessage()    {}
+
+// Nodes and edges of the result of a query, its other values being JSON
+// encoded
+type TopologyReply struct {
+	Nodes  []*Node  `protobuf:"bytes,1,rep,name=Nodes" json:"Nodes,omitempty"`
+	Edges  []*Edge  `protobuf:"bytes,2,rep,name=Edges" json:"Edges,omitempty"`
+	Values []string `protobuf:"bytes,3,rep,name=Values" json:"Values,omitempty"`
+}
+
+func (m *TopologyReply) Reset()         { *m = TopologyReply{} }
+func (m *TopologyReply) String() string { return proto.CompactTextString(m) }
+func (*TopologyReply) ProtoMessage()    {}
+
+func (m *TopologyReply) GetNodes() []*Node {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *TopologyReply) GetEdges() []*Edge {
+	if m != nil {
+		return m.Edges
+	}
+	return nil
+}
+
+// NodeAdded, NodeUpdated, NodeDeleted, EdgeAdded, EdgeUpdated or
+// EdgeDeleted
+type TopologyEvent struct {
+	Type string `protobuf:"bytes,1,opt,name=Type" json:"Type,omitempty"`
+	Node *Node  `protobuf:"bytes,2,opt,name=Node" json:"Node,omitempty"`
+	Edge *Edge  `protobuf:"bytes,3,opt,name=Edge" json:"Edge,omitempty"`
+}
+
+func (m *TopologyEvent) Reset()         { *m = TopologyEvent{} }
+func (m *TopologyEvent) String() string { return proto.CompactTextString(m) }
+func (*TopologyEvent) ProtoMessage()    {}
+
+func (m *TopologyEvent) GetNode() *Node {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
+func (m *TopologyEvent) GetEdge() *Edge {
+	if m != nil {
+		return m.Edge
+	}
+	return nil
+}
+
+type FlowFilter struct {
+	Key   string `protobuf:"bytes,1,opt,name=Key" json:"Key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=Value" json:"Value,omitempty"`
+}
+
+func (m *FlowFilter) Reset()         { *m = FlowFilter{} }
+func (m *FlowFilter) String() string { return proto.CompactTextString(m) }
+func (*FlowFilter) ProtoMessage()    {}
+
+// Filters on the string fields of the flows, searched in the flow table of
+// the analyzer or in the storage when Stored is set
+type FlowRequest struct {
+	Filters []*FlowFilter `protobuf:"bytes,1,rep,name=Filters" json:"Filters,omitempty"`
+	Stored  bool          `protobuf:"varint,2,opt,name=Stored" json:"Stored,omitempty"`
+}
+
+func (m *FlowRequest) Reset()         { *m = FlowRequest{} }
+func (m *FlowRequest) String() string { return proto.CompactTextString(m) }
+func (*FlowRequest) ProtoMessage()    {}
+
+func (m *FlowRequest) GetFilters() []*FlowFilter {
+	if m != nil {
+		return m.Filters
+	}
+	return nil
+}
+
+type FlowReply struct {
+	Flows []*flow.Flow `protobuf:"bytes,1,rep,name=Flows" json:"Flows,omitempty"`
+}
+
+func (m *FlowReply) Reset()         { *m = FlowReply{} }
+func (m *FlowReply) String() string { return proto.CompactTextString(m) }
+func (*FlowReply) ProtoMessage()    {}
+
+func (m *FlowReply) GetFlows() []*flow.Flow {
+	if m != nil {
+		return m.Flows
+	}
+	return nil
+}
+
+type Capture struct {
+	UUID           string `protobuf:"bytes,1,opt,name=UUID" json:"UUID,omitempty"`
+	ProbePath      string `protobuf:"bytes,2,opt,name=ProbePath" json:"ProbePath,omitempty"`
+	GremlinQuery   string `protobuf:"bytes,3,opt,name=GremlinQuery" json:"GremlinQuery,omitempty"`
+	BPFFilter      string `protobuf:"bytes,4,opt,name=BPFFilter" json:"BPFFilter,omitempty"`
+	Type           string `protobuf:"bytes,5,opt,name=Type" json:"Type,omitempty"`
+	SnapLen        int32  `protobuf:"varint,6,opt,name=SnapLen" json:"SnapLen,omitempty"`
+	Duration       int64  `protobuf:"varint,7,opt,name=Duration" json:"Duration,omitempty"`
+	RawPacketLimit int32  `protobuf:"varint,8,opt,name=RawPacketLimit" json:"RawPacketLimit,omitempty"`
+	Export         string `protobuf:"bytes,9,opt,name=Export" json:"Export,omitempty"`
+	ActiveTimeout  int32  `protobuf:"varint,10,opt,name=ActiveTimeout" json:"ActiveTimeout,omitempty"`
+	IdleTimeout    int32  `protobuf:"varint,11,opt,name=IdleTimeout" json:"IdleTimeout,omitempty"`
+	SamplingRate   uint32 `protobuf:"varint,12,opt,name=SamplingRate" json:"SamplingRate,omitempty"`
+	PollingPeriod  uint32 `protobuf:"varint,13,opt,name=PollingPeriod" json:"PollingPeriod,omitempty"`
+	PacketRate     int32  `protobuf:"varint,14,opt,name=PacketRate" json:"PacketRate,omitempty"`
+	MaxBytes       int64  `protobuf:"varint,15,opt,name=MaxBytes" json:"MaxBytes,omitempty"`
+}
+
+func (m *Capture) Reset()         { *m = Capture{} }
+func (m *Capture) String() string { return proto.CompactTextString(m) }
+func (*Capture) ProtoMessage()    {}
+
+// The ID of a capture is its probe path or its UUID
+type CaptureRequest struct {
+	ID string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+}
+
+func (m *CaptureRequest) Reset()         { *m = CaptureRequest{} }
+func (m *CaptureRequest) String() string { return proto.CompactTextString(m) }
+func (*CaptureRequest) ProtoMessage()    {}
+
+type CaptureList struct {
+	Captures []*Capture `protobuf:"bytes,1,rep,name=Captures" json:"Captures,omitempty"`
+}
+
+func (m *CaptureList) Reset()         { *m = CaptureList{} }
+func (m *CaptureList) String() string { return proto.CompactTextString(m) }
+func (*CaptureList) ProtoMessage()    {}
+
+func (m *CaptureList) GetCaptures() []*Capture {
+	if m != nil {
+		return m.Captures
+	}
+	return nil
+}
+
+type Empty struct {
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*Node)(nil), "rpc.Node")
+	proto.RegisterType((*Edge)(nil), "rpc.Edge")
+	proto.RegisterType((*TopologyRequest)(nil), "rpc.TopologyRequest")
+	proto.RegisterType((*TopologyReply)(nil), "rpc.TopologyReply")
+	proto.RegisterType((*TopologyEvent)(nil), "rpc.TopologyEvent")
+	proto.RegisterType((*FlowFilter)(nil), "rpc.FlowFilter")
+	proto.RegisterType((*FlowRequest)(nil), "rpc.FlowRequest")
+	proto.RegisterType((*FlowReply)(nil), "rpc.FlowReply")
+	proto.RegisterType((*Capture)(nil), "rpc.Capture")
+	proto.RegisterType((*CaptureRequest)(nil), "rpc.CaptureRequest")
+	proto.RegisterType((*CaptureList)(nil), "rpc.CaptureList")
+	proto.RegisterType((*Empty)(nil), "rpc.Empty")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for Topology service
+
+type TopologyClient interface {
+	Query(ctx context.Context, in *TopologyRequest, opts ...grpc.CallOption) (*TopologyReply, error)
+	// Streams the events of the elements of the result of the query, all
+	// the events without query
+	Watch(ctx context.Context, in *TopologyRequest, opts ...grpc.CallOption) (Topology_WatchClient, error)
+}
+
+type topologyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewTopologyClient(cc *grpc.ClientConn) TopologyClient {
+	return &topologyClient{cc}
+}
+
+func (c *topologyClient) Query(ctx context.Context, in *TopologyRequest, opts ...grpc.CallOption) (*TopologyReply, error) {
+	out := new(TopologyReply)
+	err := grpc.Invoke(ctx, "/rpc.Topology/Query", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *topologyClient) Watch(ctx context.Context, in *TopologyRequest, opts ...grpc.CallOption) (Topology_WatchClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Topology_serviceDesc.Streams[0], c.cc, "/rpc.Topology/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &topologyWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Topology_WatchClient interface {
+	Recv() (*TopologyEvent, error)
+	grpc.ClientStream
+}
+
+type topologyWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *topologyWatchClient) Recv() (*TopologyEvent, error) {
+	m := new(TopologyEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for Topology service
+
+type TopologyServer interface {
+	Query(context.Context, *TopologyRequest) (*TopologyReply, error)
+	// Streams the events of the elements of the result of the query, all
+	// the events without query
+	Watch(*TopologyRequest, Topology_WatchServer) error
+}
+
+func RegisterTopologyServer(s *grpc.Server, srv TopologyServer) {
+	s.RegisterService(&_Topology_serviceDesc, srv)
+}
+
+func _Topology_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TopologyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TopologyServer).Query(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Topology_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TopologyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TopologyServer).Watch(m, &topologyWatchServer{stream})
+}
+
+type Topology_WatchServer interface {
+	Send(*TopologyEvent) error
+	grpc.ServerStream
+}
+
+type topologyWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *topologyWatchServer) Send(m *TopologyEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Topology_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.Topology",
+	HandlerType: (*TopologyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _Topology_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Topology_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// Client API for Flows service
+
+type FlowsClient interface {
+	Query(ctx context.Context, in *FlowRequest, opts ...grpc.CallOption) (*FlowReply, error)
+}
+
+type flowsClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewFlowsClient(cc *grpc.ClientConn) FlowsClient {
+	return &flowsClient{cc}
+}
+
+func (c *flowsClient) Query(ctx context.Context, in *FlowRequest, opts ...grpc.CallOption) (*FlowReply, error) {
+	out := new(FlowReply)
+	err := grpc.Invoke(ctx, "/rpc.Flows/Query", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Flows service
+
+type FlowsServer interface {
+	Query(context.Context, *FlowRequest) (*FlowReply, error)
+}
+
+func RegisterFlowsServer(s *grpc.Server, srv FlowsServer) {
+	s.RegisterService(&_Flows_serviceDesc, srv)
+}
+
+func _Flows_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(FlowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(FlowsServer).Query(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+var _Flows_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.Flows",
+	HandlerType: (*FlowsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _Flows_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// Client API for Captures service
+
+type CapturesClient interface {
+	Create(ctx context.Context, in *Capture, opts ...grpc.CallOption) (*Capture, error)
+	Get(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*Capture, error)
+	List(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CaptureList, error)
+	Delete(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type capturesClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCapturesClient(cc *grpc.ClientConn) CapturesClient {
+	return &capturesClient{cc}
+}
+
+func (c *capturesClient) Create(ctx context.Context, in *Capture, opts ...grpc.CallOption) (*Capture, error) {
+	out := new(Capture)
+	err := grpc.Invoke(ctx, "/rpc.Captures/Create", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *capturesClient) Get(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*Capture, error) {
+	out := new(Capture)
+	err := grpc.Invoke(ctx, "/rpc.Captures/Get", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *capturesClient) List(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CaptureList, error) {
+	out := new(CaptureList)
+	err := grpc.Invoke(ctx, "/rpc.Captures/List", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *capturesClient) Delete(ctx context.Context, in *CaptureRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/rpc.Captures/Delete", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Captures service
+
+type CapturesServer interface {
+	Create(context.Context, *Capture) (*Capture, error)
+	Get(context.Context, *CaptureRequest) (*Capture, error)
+	List(context.Context, *Empty) (*CaptureList, error)
+	Delete(context.Context, *CaptureRequest) (*Empty, error)
+}
+
+func RegisterCapturesServer(s *grpc.Server, srv CapturesServer) {
+	s.RegisterService(&_Captures_serviceDesc, srv)
+}
+
+func _Captures_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Capture)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(CapturesServer).Create(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Captures_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(CaptureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(CapturesServer).Get(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Captures_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(CapturesServer).List(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Captures_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(CaptureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(CapturesServer).Delete(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+var _Captures_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.Captures",
+	HandlerType: (*CapturesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler:    _Captures_Create_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Captures_Get_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Captures_List_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Captures_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
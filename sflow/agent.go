@@ -57,6 +57,8 @@ type SFlowAgent struct {
 	FlowMappingPipeline *mappings.FlowMappingPipeline
 	FlowProbeNodeSetter flow.FlowProbeNodeSetter
 	FlowTableAllocator  *flow.TableAllocator
+	// packets dropped by the sFlow agent, as reported by the last sample
+	dropped uint32
 }
 
 type SFlowAgentAllocator struct {
@@ -95,6 +97,12 @@ func (sfa *SFlowAgent) feedFlowTable(conn *net.UDPConn) {
 		for _, sample := range sflowPacket.FlowSamples {
 			flows := flow.FlowsFromSFlowSample(sfa.flowTable, &sample, sfa.FlowProbeNodeSetter)
 			logging.GetLogger().Debugf("%d flows captured", len(flows))
+
+			flow.PacketsCaptured.WithLabelValues("sflow").Inc()
+			if sample.Dropped > sfa.dropped {
+				flow.PacketsDropped.WithLabelValues("sflow").Add(float64(sample.Dropped - sfa.dropped))
+			}
+			sfa.dropped = sample.Dropped
 		}
 	}
 }
@@ -23,6 +23,7 @@
 package elasticsearch
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,6 +33,7 @@ import (
 	"time"
 
 	elastigo "github.com/mattbaird/elastigo/lib"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/flow"
@@ -39,7 +41,13 @@ import (
 	"github.com/redhat-cip/skydive/storage"
 )
 
-const indexVersion = 2
+const indexVersion = 3
+
+// maximum number of topology events returned by a search
+const maxTopologyEvents = 10000
+
+// maximum number of flows purged per retention policy at once, the oldest
+const maxPurgedFlows = 10000
 
 const mapping = `
 {"mappings":{"flow":{"dynamic_templates":[
@@ -47,9 +55,22 @@ const mapping = `
 	{"notanalyzed_layers":{"match":"LayersPath","mapping":{"type":"string","index":"not_analyzed"}}},
 	{"start_epoch":{"match":"Start","mapping":{"type":"date", "format": "epoch_second"}}},
 	{"last_epoch":{"match":"Last","mapping":{"type":"date", "format": "epoch_second"}}}
-]}}}
+]},
+"topology":{"properties":{
+	"Timestamp":{"type":"date", "format": "epoch_millis"},
+	"Type":{"type":"string","index":"not_analyzed"},
+	"ID":{"type":"string","index":"not_analyzed"},
+	"Host":{"type":"string","index":"not_analyzed"},
+	"Parent":{"type":"string","index":"not_analyzed"},
+	"Child":{"type":"string","index":"not_analyzed"}
+}}}}
 `
 
+var indexDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name: "skydive_storage_elasticsearch_bulk_duration_seconds",
+	Help: "Latency of the bulk indexing requests sent to Elasticsearch.",
+})
+
 type ElasticSearchStorage struct {
 	connection *elastigo.Conn
 	indexer    *elastigo.BulkIndexer
@@ -128,6 +149,147 @@ func (c *ElasticSearchStorage) SearchFlows(filters storage.Filters) ([]*flow.Flo
 	return flows, nil
 }
 
+func (c *ElasticSearchStorage) StoreRollups(rollups []*flow.Rollup) error {
+	if c.started.Load() != true {
+		return errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	for _, r := range rollups {
+		if err := c.indexer.Index("skydive", "flow_rollup", "", "", "", nil, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ElasticSearchStorage) StoreTopologyEvent(ev *storage.TopologyEvent) error {
+	if c.started.Load() != true {
+		return errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	return c.indexer.Index("skydive", "topology", "", "", "", nil, ev)
+}
+
+// SearchTopologyEvents returns the topology events which occurred between
+// from and to, oldest first, matching the filters on the event fields
+func (c *ElasticSearchStorage) SearchTopologyEvents(from time.Time, to time.Time, filters storage.Filters) ([]*storage.TopologyEvent, error) {
+	if c.started.Load() != true {
+		return nil, errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	must := []interface{}{
+		map[string]interface{}{
+			"range": map[string]interface{}{
+				"Timestamp": map[string]int64{
+					"gte": from.UnixNano() / int64(time.Millisecond),
+					"lte": to.UnixNano() / int64(time.Millisecond),
+				},
+			},
+		},
+	}
+	for k, v := range filters {
+		must = append(must, map[string]interface{}{
+			"term": map[string]interface{}{k: v},
+		})
+	}
+
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"sort": map[string]interface{}{
+			"Timestamp": map[string]string{
+				"order": "asc",
+			},
+		},
+		"from": 0,
+		"size": maxTopologyEvents,
+	}
+
+	q, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := c.connection.Search("skydive", "topology", nil, string(q))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*storage.TopologyEvent{}
+	for _, d := range out.Hits.Hits {
+		ev := new(storage.TopologyEvent)
+		if err := json.Unmarshal([]byte(*d.Source), ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// PurgeFlows deletes the flows matching the retention policy, once their
+// daily aggregates indexed as flow_aggregate documents
+func (c *ElasticSearchStorage) PurgeFlows(policy *storage.RetentionPolicy, now time.Time) (int, error) {
+	if c.started.Load() != true {
+		return 0, errors.New("ElasticSearchStorage is not yet started")
+	}
+
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"Statistics.Last": map[string]int64{
+					"lt": policy.Expiry(now).Unix(),
+				},
+			},
+		},
+		"sort": map[string]interface{}{
+			"Statistics.Last": map[string]string{
+				"order": "asc",
+			},
+		},
+		"from": 0,
+		"size": maxPurgedFlows,
+	}
+
+	q, err := json.Marshal(query)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := c.connection.Search("skydive", "flow", nil, string(q))
+	if err != nil {
+		return 0, err
+	}
+
+	var flows []*flow.Flow
+	for _, d := range out.Hits.Hits {
+		f := new(flow.Flow)
+		if err := json.Unmarshal([]byte(*d.Source), f); err != nil {
+			return 0, err
+		}
+
+		if policy.Purged(f, now) {
+			flows = append(flows, f)
+		}
+	}
+
+	for _, a := range storage.AggregateFlows(flows) {
+		if err := c.indexer.Index("skydive", "flow_aggregate", "", "", "", nil, a); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, f := range flows {
+		c.indexer.Delete("skydive", "flow", f.UUID)
+	}
+
+	return len(flows), nil
+}
+
 func (c *ElasticSearchStorage) request(method string, path string, query string, body string) (int, []byte, error) {
 	req, err := c.connection.NewRequest(method, path, query)
 	if err != nil {
@@ -201,11 +363,20 @@ func (c *ElasticSearchStorage) start() {
 	}
 
 	c.indexer = c.connection.NewBulkIndexerErrors(10, 60)
+	c.indexer.Sender = c.timedSend
 	c.indexer.Start()
 
 	c.started.Store(true)
 }
 
+// timedSend sends a bulk request, observing its latency
+func (c *ElasticSearchStorage) timedSend(buf *bytes.Buffer) error {
+	start := time.Now()
+	err := c.indexer.Send(buf)
+	indexDuration.Observe(time.Since(start).Seconds())
+	return err
+}
+
 func (c *ElasticSearchStorage) Start() {
 	go c.start()
 }
@@ -232,3 +403,7 @@ func New() (*ElasticSearchStorage, error) {
 
 	return storage, nil
 }
+
+func init() {
+	prometheus.MustRegister(indexDuration)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package etcd
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+type MasterElectionListener interface {
+	OnElected()
+	OnDemoted()
+}
+
+// MasterElector elects a master among the instances sharing the etcd
+// servers, the master holding a key with a TTL that it keeps refreshing.
+// The key expires when the master dies, another instance taking it.
+type MasterElector struct {
+	EtcdKeyAPI etcd.KeysAPI
+	Key        string
+	Host       string
+	TTL        time.Duration
+	master     atomic.Value
+	listeners  []MasterElectionListener
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+func (le *MasterElector) IsMaster() bool {
+	return le.master.Load() == true
+}
+
+func (le *MasterElector) AddEventListener(l MasterElectionListener) {
+	le.listeners = append(le.listeners, l)
+}
+
+func (le *MasterElector) setMaster(master bool) {
+	if le.IsMaster() == master {
+		return
+	}
+	le.master.Store(master)
+
+	if master {
+		logging.GetLogger().Infof("Elected as master of %s", le.Key)
+		for _, l := range le.listeners {
+			l.OnElected()
+		}
+	} else {
+		logging.GetLogger().Infof("No longer master of %s", le.Key)
+		for _, l := range le.listeners {
+			l.OnDemoted()
+		}
+	}
+}
+
+func (le *MasterElector) elect() {
+	options := &etcd.SetOptions{TTL: le.TTL, PrevExist: etcd.PrevNoExist}
+	if le.IsMaster() {
+		options = &etcd.SetOptions{TTL: le.TTL, PrevValue: le.Host}
+	}
+
+	_, err := le.EtcdKeyAPI.Set(context.Background(), le.Key, le.Host, options)
+	if err == nil {
+		le.setMaster(true)
+		return
+	}
+
+	if cerr, ok := err.(etcd.Error); !ok || (cerr.Code != etcd.ErrorCodeNodeExist && cerr.Code != etcd.ErrorCodeTestFailed) {
+		logging.GetLogger().Errorf("Error while electing the master of %s: %s", le.Key, err.Error())
+	}
+	le.setMaster(false)
+}
+
+func (le *MasterElector) Start() {
+	le.quit = make(chan bool)
+	le.wg.Add(1)
+
+	go func() {
+		defer le.wg.Done()
+
+		ticker := time.NewTicker(le.TTL / 2)
+		defer ticker.Stop()
+
+		le.elect()
+		for {
+			select {
+			case <-ticker.C:
+				le.elect()
+			case <-le.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop gives up the mastership so that another instance takes it at once
+func (le *MasterElector) Stop() {
+	close(le.quit)
+	le.wg.Wait()
+
+	if le.IsMaster() {
+		le.EtcdKeyAPI.Delete(context.Background(), le.Key, &etcd.DeleteOptions{PrevValue: le.Host})
+		le.setMaster(false)
+	}
+}
+
+func NewMasterElector(kapi etcd.KeysAPI, key string, host string, ttl time.Duration) *MasterElector {
+	le := &MasterElector{
+		EtcdKeyAPI: kapi,
+		Key:        "/master-election/" + key,
+		Host:       host,
+		TTL:        ttl,
+	}
+	le.master.Store(false)
+
+	return le
+}
+
+func NewMasterElectorFromConfig(kapi etcd.KeysAPI, key string) (*MasterElector, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(config.GetConfig().GetInt("analyzer.election_ttl")) * time.Second
+	return NewMasterElector(kapi, key, host, ttl), nil
+}
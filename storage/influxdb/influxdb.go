@@ -0,0 +1,587 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/storage"
+)
+
+const (
+	measurement = "flow"
+	// measurement of the daily aggregates of the purged flows
+	aggregateMeasurement = "flow_aggregate"
+	// measurement of the rollups of the flows
+	rollupMeasurement = "flow_rollup"
+	// number of series dropped per request by a purge
+	maxDroppedSeries = 100
+	// maximum number of flows returned by a search, the most recent ones
+	maxSearchedFlows = 5
+	// number of pending write requests above which the flows are dropped
+	maxPendingWrites = 100
+)
+
+// tags are the flow fields stored as InfluxDB tags, the ones the flows can
+// be searched by
+var tags = []string{"UUID", "TrackingID", "L3TrackingID", "LayersPath", "ProbeNodeUUID", "IfSrcNodeUUID", "IfDstNodeUUID", "ParentUUID", "BridgeNodeUUID", "HostNodeUUID", "ProcessName", "ContainerID", "ExpiredReason", "NATOriginal", "NATTranslated", "NATTrackingID", "VLANs", "MPLSLabels", "CaptureID"}
+
+var writeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name: "skydive_storage_influxdb_write_duration_seconds",
+	Help: "Latency of the write requests sent to InfluxDB.",
+})
+
+// InfluxDBStorage stores the flows as time series points, a point per
+// update of a flow with its counters as fields, so that the bandwidth of
+// the flows can be graphed, with Grafana for instance, using the derivative
+// of the Bytes fields grouped by UUID.
+type InfluxDBStorage struct {
+	url      string
+	database string
+	username string
+	password string
+	client   *http.Client
+	writes   chan []byte
+	quit     chan bool
+	wg       sync.WaitGroup
+	started  atomic.Value
+}
+
+type queryResponse struct {
+	Results []struct {
+		Series []struct {
+			Tags    map[string]string `json:"tags"`
+			Columns []string          `json:"columns"`
+			Values  [][]interface{}   `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+var (
+	tagEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// flowPoint returns the line protocol point of a flow, timestamped by the
+// last update of the flow
+func flowPoint(f *flow.Flow) string {
+	values := map[string]string{
+		"UUID":           f.UUID,
+		"TrackingID":     f.TrackingID,
+		"L3TrackingID":   f.L3TrackingID,
+		"LayersPath":     f.LayersPath,
+		"ProbeNodeUUID":  f.ProbeNodeUUID,
+		"IfSrcNodeUUID":  f.IfSrcNodeUUID,
+		"IfDstNodeUUID":  f.IfDstNodeUUID,
+		"ParentUUID":     f.ParentUUID,
+		"BridgeNodeUUID": f.BridgeNodeUUID,
+		"HostNodeUUID":   f.HostNodeUUID,
+		"ProcessName":    f.ProcessName,
+		"ContainerID":    f.ContainerID,
+		"ExpiredReason":  f.ExpiredReason,
+		"NATOriginal":    f.NATOriginal,
+		"NATTranslated":  f.NATTranslated,
+		"NATTrackingID":  f.NATTrackingID,
+		"VLANs":          joinUint32s(f.VLANs),
+		"MPLSLabels":     joinUint32s(f.MPLSLabels),
+		"CaptureID":      f.CaptureID,
+	}
+
+	line := measurement
+	for _, t := range tags {
+		if v := values[t]; v != "" {
+			line += "," + t + "=" + tagEscaper.Replace(v)
+		}
+	}
+
+	fs := f.GetStatistics()
+	fields := []string{
+		fmt.Sprintf("Start=%di", fs.Start),
+		fmt.Sprintf("Last=%di", fs.Last),
+	}
+	if f.PID != 0 {
+		fields = append(fields, fmt.Sprintf("PID=%di", f.PID))
+	}
+	if fs.RTT != 0 {
+		fields = append(fields, fmt.Sprintf("RTT=%di", fs.RTT))
+	}
+
+	// the counters of the outer layer are the ones of the flow, the ones
+	// of each layer are stored prefixed by the layer
+	for i, ep := range fs.GetEndpoints() {
+		ab, ba := ep.GetAB(), ep.GetBA()
+		if ab == nil || ba == nil {
+			continue
+		}
+
+		counters := fmt.Sprintf("PacketsAB=%di,PacketsBA=%di,BytesAB=%di,BytesBA=%di", ab.Packets, ba.Packets, ab.Bytes, ba.Bytes)
+		if i == 0 {
+			fields = append(fields, counters,
+				fmt.Sprintf("Packets=%di,Bytes=%di", ab.Packets+ba.Packets, ab.Bytes+ba.Bytes))
+		}
+
+		prefix := ep.Type.String() + "."
+		fields = append(fields,
+			fmt.Sprintf(`%sA="%s"`, prefix, stringEscaper.Replace(ab.Value)),
+			fmt.Sprintf(`%sB="%s"`, prefix, stringEscaper.Replace(ba.Value)),
+			prefix+strings.Replace(counters, ",", ","+prefix, -1))
+
+		if ep.Type == flow.FlowEndpointType_TCPPORT {
+			fields = append(fields, fmt.Sprintf("%sRetransmissionsAB=%di,%sRetransmissionsBA=%di", prefix, ab.Retransmissions, prefix, ba.Retransmissions))
+		}
+	}
+
+	return fmt.Sprintf("%s %s %d", line, strings.Join(fields, ","), fs.Last)
+}
+
+func intValue(v interface{}) uint64 {
+	if n, ok := v.(json.Number); ok {
+		i, _ := strconv.ParseUint(n.String(), 10, 64)
+		return i
+	}
+	return 0
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// joinUint32s returns the values as a comma separated list
+func joinUint32s(values []uint32) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(s, ",")
+}
+
+// uint32sValue parses a comma separated list of values
+func uint32sValue(v interface{}) []uint32 {
+	var values []uint32
+	for _, s := range strings.Split(stringValue(v), ",") {
+		if i, err := strconv.ParseUint(s, 10, 32); err == nil {
+			values = append(values, uint32(i))
+		}
+	}
+	return values
+}
+
+// pointFlow rebuilds a flow from the columns of a point
+func pointFlow(columns []string, values []interface{}) *flow.Flow {
+	row := make(map[string]interface{})
+	for i, c := range columns {
+		if i < len(values) {
+			row[c] = values[i]
+		}
+	}
+
+	f := &flow.Flow{
+		UUID:           stringValue(row["UUID"]),
+		TrackingID:     stringValue(row["TrackingID"]),
+		L3TrackingID:   stringValue(row["L3TrackingID"]),
+		LayersPath:     stringValue(row["LayersPath"]),
+		ProbeNodeUUID:  stringValue(row["ProbeNodeUUID"]),
+		IfSrcNodeUUID:  stringValue(row["IfSrcNodeUUID"]),
+		IfDstNodeUUID:  stringValue(row["IfDstNodeUUID"]),
+		ParentUUID:     stringValue(row["ParentUUID"]),
+		BridgeNodeUUID: stringValue(row["BridgeNodeUUID"]),
+		HostNodeUUID:   stringValue(row["HostNodeUUID"]),
+		PID:            int64(intValue(row["PID"])),
+		ProcessName:    stringValue(row["ProcessName"]),
+		ContainerID:    stringValue(row["ContainerID"]),
+		ExpiredReason:  stringValue(row["ExpiredReason"]),
+		NATOriginal:    stringValue(row["NATOriginal"]),
+		NATTranslated:  stringValue(row["NATTranslated"]),
+		NATTrackingID:  stringValue(row["NATTrackingID"]),
+		VLANs:          uint32sValue(row["VLANs"]),
+		MPLSLabels:     uint32sValue(row["MPLSLabels"]),
+		CaptureID:      stringValue(row["CaptureID"]),
+		Statistics: &flow.FlowStatistics{
+			Start: int64(intValue(row["Start"])),
+			Last:  int64(intValue(row["Last"])),
+			RTT:   int64(intValue(row["RTT"])),
+		},
+	}
+
+	for t := int32(0); ; t++ {
+		name, ok := flow.FlowEndpointType_name[t]
+		if !ok {
+			break
+		}
+
+		prefix := name + "."
+		if _, ok := row[prefix+"A"].(string); !ok {
+			continue
+		}
+
+		f.Statistics.Endpoints = append(f.Statistics.Endpoints, &flow.FlowEndpointsStatistics{
+			Type: flow.FlowEndpointType(t),
+			AB: &flow.FlowEndpointStatistics{
+				Value:           stringValue(row[prefix+"A"]),
+				Packets:         intValue(row[prefix+"PacketsAB"]),
+				Bytes:           intValue(row[prefix+"BytesAB"]),
+				Retransmissions: intValue(row[prefix+"RetransmissionsAB"]),
+			},
+			BA: &flow.FlowEndpointStatistics{
+				Value:           stringValue(row[prefix+"B"]),
+				Packets:         intValue(row[prefix+"PacketsBA"]),
+				Bytes:           intValue(row[prefix+"BytesBA"]),
+				Retransmissions: intValue(row[prefix+"RetransmissionsBA"]),
+			},
+		})
+	}
+
+	return f
+}
+
+func (c *InfluxDBStorage) request(method string, path string, params url.Values, body []byte) ([]byte, error) {
+	if c.username != "" {
+		params.Set("u", c.username)
+		params.Set("p", c.password)
+	}
+
+	req, err := http.NewRequest(method, c.url+path+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("InfluxDB request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
+
+func (c *InfluxDBStorage) query(q string) (*queryResponse, error) {
+	params := url.Values{"db": {c.database}, "q": {q}, "epoch": {"s"}}
+
+	data, err := c.request("POST", "/query", params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp queryResponse
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	for _, r := range resp.Results {
+		if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+	}
+
+	return &resp, nil
+}
+
+func (c *InfluxDBStorage) StoreFlows(flows []*flow.Flow) error {
+	if c.started.Load() != true {
+		return errors.New("InfluxDBStorage is not yet started")
+	}
+
+	var buf bytes.Buffer
+	for _, f := range flows {
+		if f.GetStatistics() == nil {
+			continue
+		}
+		buf.WriteString(flowPoint(f))
+		buf.WriteByte('\n')
+	}
+
+	select {
+	case c.writes <- buf.Bytes():
+	default:
+		return errors.New("Too many pending InfluxDB writes, flows dropped")
+	}
+
+	return nil
+}
+
+// StoreRollups writes the rollups as points of the flow_rollup measurement,
+// tagged by dimension, key and analyzer host
+func (c *InfluxDBStorage) StoreRollups(rollups []*flow.Rollup) error {
+	if c.started.Load() != true {
+		return errors.New("InfluxDBStorage is not yet started")
+	}
+
+	var buf bytes.Buffer
+	for _, r := range rollups {
+		line := rollupMeasurement + ",Dimension=" + tagEscaper.Replace(r.Dimension) + ",Key=" + tagEscaper.Replace(r.Key)
+		if r.Host != "" {
+			line += ",Host=" + tagEscaper.Replace(r.Host)
+		}
+		fmt.Fprintf(&buf, "%s Flows=%di,Packets=%di,Bytes=%di %d\n", line, r.Flows, r.Packets, r.Bytes, r.Timestamp)
+	}
+
+	select {
+	case c.writes <- buf.Bytes():
+	default:
+		return errors.New("Too many pending InfluxDB writes, rollups dropped")
+	}
+
+	return nil
+}
+
+type flowsByLast []*flow.Flow
+
+func (s flowsByLast) Len() int           { return len(s) }
+func (s flowsByLast) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s flowsByLast) Less(i, j int) bool { return s[i].Statistics.Last > s[j].Statistics.Last }
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func quoteString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// lastPoints returns the last point of the flows matching the filters on
+// their tags
+func (c *InfluxDBStorage) lastPoints(filters storage.Filters) ([]*flow.Flow, error) {
+	var where []string
+	for k, v := range filters {
+		where = append(where, fmt.Sprintf("%s = %s", quoteIdent(k), quoteString(fmt.Sprintf("%v", v))))
+	}
+	sort.Strings(where)
+
+	q := "SELECT * FROM " + quoteIdent(measurement)
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += ` GROUP BY "UUID" ORDER BY time DESC LIMIT 1`
+
+	resp, err := c.query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	flows := []*flow.Flow{}
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			for _, values := range s.Values {
+				f := pointFlow(s.Columns, values)
+				if f.UUID == "" {
+					f.UUID = s.Tags["UUID"]
+				}
+				flows = append(flows, f)
+			}
+		}
+	}
+
+	return flows, nil
+}
+
+// SearchFlows returns the last point of the most recent flows matching the
+// filters on the tags of the flows
+func (c *InfluxDBStorage) SearchFlows(filters storage.Filters) ([]*flow.Flow, error) {
+	if c.started.Load() != true {
+		return nil, errors.New("InfluxDBStorage is not yet started")
+	}
+
+	flows, err := c.lastPoints(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(flowsByLast(flows))
+	if len(flows) > maxSearchedFlows {
+		flows = flows[:maxSearchedFlows]
+	}
+
+	return flows, nil
+}
+
+// PurgeFlows drops the series of the flows whose last point matches the
+// retention policy, once their daily aggregates written to the
+// flow_aggregate measurement
+func (c *InfluxDBStorage) PurgeFlows(policy *storage.RetentionPolicy, now time.Time) (int, error) {
+	if c.started.Load() != true {
+		return 0, errors.New("InfluxDBStorage is not yet started")
+	}
+
+	filters := storage.Filters{}
+	if policy.Capture != "" {
+		filters["CaptureID"] = policy.Capture
+	}
+
+	points, err := c.lastPoints(filters)
+	if err != nil {
+		return 0, err
+	}
+
+	var flows []*flow.Flow
+	for _, f := range points {
+		if policy.Purged(f, now) {
+			flows = append(flows, f)
+		}
+	}
+	if len(flows) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	for _, a := range storage.AggregateFlows(flows) {
+		line := aggregateMeasurement + ",LayersPath=" + tagEscaper.Replace(a.LayersPath)
+		if a.ProbeNodeUUID != "" {
+			line += ",ProbeNodeUUID=" + tagEscaper.Replace(a.ProbeNodeUUID)
+		}
+		if a.CaptureID != "" {
+			line += ",CaptureID=" + tagEscaper.Replace(a.CaptureID)
+		}
+		fmt.Fprintf(&buf, "%s Flows=%di,Packets=%di,Bytes=%di %d\n", line, a.Flows, a.Packets, a.Bytes, a.Timestamp)
+	}
+
+	if _, err := c.request("POST", "/write", url.Values{"db": {c.database}, "precision": {"s"}}, buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(flows); i += maxDroppedSeries {
+		var drops []string
+		for _, f := range flows[i:] {
+			if len(drops) == maxDroppedSeries {
+				break
+			}
+			drops = append(drops, "DROP SERIES FROM "+quoteIdent(measurement)+" WHERE \"UUID\" = "+quoteString(f.UUID))
+		}
+
+		if _, err := c.query(strings.Join(drops, "; ")); err != nil {
+			return i, err
+		}
+	}
+
+	return len(flows), nil
+}
+
+func (c *InfluxDBStorage) write(data []byte) {
+	start := time.Now()
+	_, err := c.request("POST", "/write", url.Values{"db": {c.database}, "precision": {"s"}}, data)
+	writeDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while writing flows to InfluxDB: %s", err.Error())
+	}
+}
+
+func (c *InfluxDBStorage) start() {
+	defer c.wg.Done()
+
+	q := "CREATE DATABASE " + quoteIdent(c.database)
+	for {
+		_, err := c.query(q)
+		if err == nil {
+			break
+		}
+		logging.GetLogger().Errorf("Unable to get connected to InfluxDB: %s", err.Error())
+
+		select {
+		case <-c.quit:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	logging.GetLogger().Infof("InfluxDBStorage started")
+	c.started.Store(true)
+
+	for {
+		select {
+		case data := <-c.writes:
+			c.write(data)
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+func (c *InfluxDBStorage) Start() {
+	c.wg.Add(1)
+	go c.start()
+}
+
+func (c *InfluxDBStorage) Stop() {
+	close(c.quit)
+	c.wg.Wait()
+}
+
+func New() (*InfluxDBStorage, error) {
+	cfg := config.GetConfig()
+
+	u, err := url.Parse(cfg.GetString("storage.influxdb.url"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid InfluxDB URL %s", cfg.GetString("storage.influxdb.url"))
+	}
+
+	storage := &InfluxDBStorage{
+		url:      strings.TrimRight(u.String(), "/"),
+		database: cfg.GetString("storage.influxdb.database"),
+		username: cfg.GetString("storage.influxdb.username"),
+		password: cfg.GetString("storage.influxdb.password"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		writes:   make(chan []byte, maxPendingWrites),
+		quit:     make(chan bool),
+	}
+	storage.started.Store(false)
+
+	return storage, nil
+}
+
+func init() {
+	prometheus.MustRegister(writeDuration)
+}
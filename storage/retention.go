@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// RetentionPolicy purges the flows not updated for Days days, only the
+// ones of the capture Capture when set and only the ones of less than
+// MinBytes bytes when set
+type RetentionPolicy struct {
+	Name     string
+	Capture  string
+	MinBytes int64
+	Days     int
+}
+
+// FlowAggregate sums the flows of a day, seen on a probe node with the
+// same layers path, kept once the flows purged. Timestamp is the start of
+// the day in seconds.
+type FlowAggregate struct {
+	Timestamp     int64
+	LayersPath    string
+	ProbeNodeUUID string
+	CaptureID     string `json:",omitempty"`
+	Flows         int64
+	Packets       int64
+	Bytes         int64
+}
+
+// RetentionStorage is implemented by the storages able to purge the flows
+// matching a retention policy, storing their aggregates first
+type RetentionStorage interface {
+	PurgeFlows(policy *RetentionPolicy, now time.Time) (int, error)
+}
+
+// RetentionEnforcer purges periodically the flows of a storage according
+// to the retention policies
+type RetentionEnforcer struct {
+	Storage  RetentionStorage
+	Policies []*RetentionPolicy
+	interval time.Duration
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+// Expiry returns the time before which the flows are purged
+func (p *RetentionPolicy) Expiry(now time.Time) time.Time {
+	return now.Add(-time.Duration(p.Days) * 24 * time.Hour)
+}
+
+// Purged returns whether the flow is to be purged by the policy
+func (p *RetentionPolicy) Purged(f *flow.Flow, now time.Time) bool {
+	if p.Capture != "" && f.CaptureID != p.Capture {
+		return false
+	}
+
+	fs := f.GetStatistics()
+	if fs == nil || fs.Last >= p.Expiry(now).Unix() {
+		return false
+	}
+
+	if p.MinBytes > 0 {
+		_, bytes := fs.GetCounters()
+		return bytes < p.MinBytes
+	}
+	return true
+}
+
+func (p *RetentionPolicy) String() string {
+	return fmt.Sprintf("%s (capture: %q, min bytes: %d, days: %d)", p.Name, p.Capture, p.MinBytes, p.Days)
+}
+
+// AggregateFlows sums the flows per day of their last update, probe node,
+// capture and layers path
+func AggregateFlows(flows []*flow.Flow) []*FlowAggregate {
+	aggregates := make(map[FlowAggregate]*FlowAggregate)
+	for _, f := range flows {
+		fs := f.GetStatistics()
+		if fs == nil {
+			continue
+		}
+
+		key := FlowAggregate{
+			Timestamp:     fs.Last - fs.Last%86400,
+			LayersPath:    f.LayersPath,
+			ProbeNodeUUID: f.ProbeNodeUUID,
+			CaptureID:     f.CaptureID,
+		}
+
+		a, ok := aggregates[key]
+		if !ok {
+			a = &FlowAggregate{}
+			*a = key
+			aggregates[key] = a
+		}
+
+		packets, bytes := fs.GetCounters()
+		a.Flows++
+		a.Packets += packets
+		a.Bytes += bytes
+	}
+
+	list := make([]*FlowAggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		list = append(list, a)
+	}
+	sort.Sort(aggregatesByKey(list))
+
+	return list
+}
+
+type aggregatesByKey []*FlowAggregate
+
+func (s aggregatesByKey) Len() int      { return len(s) }
+func (s aggregatesByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s aggregatesByKey) Less(i, j int) bool {
+	if s[i].Timestamp != s[j].Timestamp {
+		return s[i].Timestamp < s[j].Timestamp
+	}
+	return fmt.Sprintf("%s/%s/%s", s[i].ProbeNodeUUID, s[i].CaptureID, s[i].LayersPath) <
+		fmt.Sprintf("%s/%s/%s", s[j].ProbeNodeUUID, s[j].CaptureID, s[j].LayersPath)
+}
+
+// Enforce purges the flows matching the policies
+func (r *RetentionEnforcer) Enforce() {
+	now := time.Now()
+	for _, p := range r.Policies {
+		purged, err := r.Storage.PurgeFlows(p, now)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to enforce the retention policy %s: %s", p, err.Error())
+			continue
+		}
+		if purged > 0 {
+			logging.GetLogger().Infof("%d flows purged by the retention policy %s", purged, p)
+		}
+	}
+}
+
+func (r *RetentionEnforcer) run(quit chan bool) {
+	defer r.wg.Done()
+
+	r.Enforce()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Enforce()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (r *RetentionEnforcer) Start() {
+	r.quit = make(chan bool)
+	r.wg.Add(1)
+	go r.run(r.quit)
+}
+
+func (r *RetentionEnforcer) Stop() {
+	if r.quit != nil {
+		close(r.quit)
+		r.wg.Wait()
+		r.quit = nil
+	}
+}
+
+func NewRetentionEnforcer(s RetentionStorage, policies []*RetentionPolicy, interval time.Duration) *RetentionEnforcer {
+	return &RetentionEnforcer{
+		Storage:  s,
+		Policies: policies,
+		interval: interval,
+	}
+}
+
+// NewRetentionEnforcerFromConfig reads the policies of the
+// storage.retention.policies section, indexed by name
+func NewRetentionEnforcerFromConfig(s RetentionStorage) (*RetentionEnforcer, error) {
+	cfg := config.GetConfig()
+
+	var policies []*RetentionPolicy
+	for name := range cfg.GetStringMap("storage.retention.policies") {
+		key := "storage.retention.policies." + name
+		p := &RetentionPolicy{
+			Name:     name,
+			Capture:  cfg.GetString(key + ".capture"),
+			MinBytes: int64(cfg.GetInt(key + ".min_bytes")),
+			Days:     cfg.GetInt(key + ".days"),
+		}
+		if p.Days <= 0 {
+			return nil, fmt.Errorf("Invalid number of days of the retention policy %s: %d", name, p.Days)
+		}
+		policies = append(policies, p)
+	}
+
+	interval := time.Duration(cfg.GetInt("storage.retention.interval")) * time.Second
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid retention interval: %v", interval)
+	}
+
+	return NewRetentionEnforcer(s, policies, interval), nil
+}
@@ -34,3 +34,9 @@ type Storage interface {
 	SearchFlows(filters Filters) ([]*flow.Flow, error)
 	Stop()
 }
+
+// RollupStorage is implemented by the storages able to keep the rollups of
+// the flows apart from the flows
+type RollupStorage interface {
+	StoreRollups(rollups []*flow.Rollup) error
+}
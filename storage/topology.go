@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package storage
+
+import (
+	"time"
+
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// TopologyEvent is a mutation of a node or an edge of the topology, with the
+// state of the element after the mutation. Timestamp is in milliseconds.
+type TopologyEvent struct {
+	Timestamp int64
+	Type      string
+	ID        string
+	Host      string
+	Metadata  graph.Metadata `json:",omitempty"`
+	Parent    string         `json:",omitempty"`
+	Child     string         `json:",omitempty"`
+}
+
+// TopologyStorage is implemented by the storages able to keep the history
+// of the topology
+type TopologyStorage interface {
+	StoreTopologyEvent(ev *TopologyEvent) error
+	SearchTopologyEvents(from time.Time, to time.Time, filters Filters) ([]*TopologyEvent, error)
+}
+
+// TopologyRecorder is a graph listener writing the graph events to a
+// topology storage
+type TopologyRecorder struct {
+	Graph   *graph.Graph
+	Storage TopologyStorage
+}
+
+func (r *TopologyRecorder) RecordHistory() {
+}
+
+func (r *TopologyRecorder) store(t string, id graph.Identifier, host string, m graph.Metadata, parent graph.Identifier, child graph.Identifier) {
+	ev := &TopologyEvent{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Type:      t,
+		ID:        string(id),
+		Host:      host,
+		Metadata:  m,
+		Parent:    string(parent),
+		Child:     string(child),
+	}
+
+	if err := r.Storage.StoreTopologyEvent(ev); err != nil {
+		logging.GetLogger().Errorf("Unable to store the topology event %s of %s: %s", t, id, err.Error())
+	}
+}
+
+func (r *TopologyRecorder) storeNode(t string, n *graph.Node) {
+	r.store(t, n.ID, n.Host(), n.Metadata(), "", "")
+}
+
+func (r *TopologyRecorder) storeEdge(t string, e *graph.Edge) {
+	r.store(t, e.ID, e.Host(), e.Metadata(), e.Parent(), e.Child())
+}
+
+func (r *TopologyRecorder) OnNodeUpdated(n *graph.Node) {
+	r.storeNode("NodeUpdated", n)
+}
+
+func (r *TopologyRecorder) OnNodeAdded(n *graph.Node) {
+	r.storeNode("NodeAdded", n)
+}
+
+func (r *TopologyRecorder) OnNodeDeleted(n *graph.Node) {
+	r.storeNode("NodeDeleted", n)
+}
+
+func (r *TopologyRecorder) OnEdgeUpdated(e *graph.Edge) {
+	r.storeEdge("EdgeUpdated", e)
+}
+
+func (r *TopologyRecorder) OnEdgeAdded(e *graph.Edge) {
+	r.storeEdge("EdgeAdded", e)
+}
+
+func (r *TopologyRecorder) OnEdgeDeleted(e *graph.Edge) {
+	r.storeEdge("EdgeDeleted", e)
+}
+
+func (r *TopologyRecorder) Start() {
+	r.Graph.AddEventListenerWithPriority(r, graph.ListenerPriorityFromConfig("topology_history", graph.ExportListenerPriority))
+}
+
+func (r *TopologyRecorder) Stop() {
+	r.Graph.RemoveEventListener(r)
+}
+
+func NewTopologyRecorder(g *graph.Graph, s TopologyStorage) *TopologyRecorder {
+	return &TopologyRecorder{Graph: g, Storage: s}
+}
@@ -48,6 +48,8 @@ auth:
   type: {{.AuthType}}
   basic:
     file: {{.PasswordFile}}
+  roles:
+    admin: admin
 
 analyzer:
   listen: {{.AnalyzerPort}}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"time"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+const (
+	actionTimeout = 10 * time.Second
+)
+
+var actionClient = &http.Client{Timeout: actionTimeout}
+
+// runAction runs the action of a triggered alert. The alert message, JSON
+// encoded, is
+// posted to the webhook when the action is an HTTP URL and given on the
+// standard input of the script when it is a file URL, ex:
+// file:///usr/local/bin/notify.sh. Any other action is only a reason sent
+// to the WebSocket clients.
+func runAction(action string, id string, msg []byte) {
+	u, err := url.Parse(action)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := actionClient.Post(action, "application/json", bytes.NewReader(msg))
+		if err != nil {
+			logging.GetLogger().Errorf("Alert %s webhook %s failed: %s", id, action, err.Error())
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			logging.GetLogger().Errorf("Alert %s webhook %s returned code %d", id, action, resp.StatusCode)
+		}
+	case "file":
+		cmd := exec.Command(u.Path)
+		cmd.Stdin = bytes.NewReader(msg)
+		if err := cmd.Start(); err != nil {
+			logging.GetLogger().Errorf("Alert %s script %s failed: %s", id, u.Path, err.Error())
+			return
+		}
+
+		timer := time.AfterFunc(actionTimeout, func() { cmd.Process.Kill() })
+		err := cmd.Wait()
+		timer.Stop()
+		if err != nil {
+			logging.GetLogger().Errorf("Alert %s script %s failed: %s", id, u.Path, err.Error())
+		}
+	}
+}
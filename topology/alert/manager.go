@@ -32,6 +32,8 @@ import (
 	eval "github.com/sbinet/go-eval"
 
 	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/flow"
 	"github.com/redhat-cip/skydive/logging"
 	"github.com/redhat-cip/skydive/topology/graph"
 )
@@ -41,14 +43,27 @@ const (
 	THRESHOLD
 )
 
+// FlowSelect is the select criteria of the alerts evaluated against the
+// flows instead of the nodes of the graph
+const FlowSelect = "Flow"
+
 type AlertManager struct {
 	graph.DefaultGraphListener
-	Graph          *graph.Graph
-	AlertHandler   api.ApiHandler
-	watcher        api.StoppableWatcher
-	alerts         map[string]*api.Alert
-	alertsLock     sync.RWMutex
-	eventListeners map[AlertEventListener]AlertEventListener
+	Graph        *graph.Graph
+	AlertHandler api.ApiHandler
+	// flows the flow alerts are evaluated against, every FlowEvalInterval
+	FlowTable        *flow.Table
+	FlowEvalInterval time.Duration
+	watcher          api.StoppableWatcher
+	alerts           map[string]*api.Alert
+	alertsLock       sync.RWMutex
+	eventListeners   map[AlertEventListener]AlertEventListener
+	// elements, nodes or flows, currently verifying the test of the alerts
+	firing       map[string]map[string]bool
+	flowBytes    map[string]uint64
+	lastFlowEval time.Time
+	quit         chan bool
+	wg           sync.WaitGroup
 }
 
 type AlertMessage struct {
@@ -87,52 +102,176 @@ func (a *AlertManager) DelEventListener(l AlertEventListener) {
 	delete(a.eventListeners, l)
 }
 
+// evalTest returns whether the test of an alert is verified by the given
+// variables, node metadata or flow metrics
+func evalTest(test string, vars map[string]interface{}) (bool, error) {
+	w := eval.NewWorld()
+	for k, v := range vars {
+		if t, val := toTypeValue(v); t != nil {
+			w.DefineConst(k, t, val)
+		}
+	}
+
+	fs := token.NewFileSet()
+	toEval := "(" + test + ") == true"
+	expr, err := w.Compile(fs, toEval)
+	if err != nil {
+		return false, fmt.Errorf("Can't compile expression : %s", toEval)
+	}
+	ret, err := expr.Run()
+	if err != nil {
+		return false, fmt.Errorf("Can't evaluate expression : %s", toEval)
+	}
+
+	return ret.String() == "true", nil
+}
+
+// check evaluates an alert against an element of the graph or a flow, the
+// alert being triggered only when its test becomes verified, ex: when an
+// interface goes down
+func (a *AlertManager) check(al *api.Alert, id string, vars map[string]interface{}, reasonData interface{}) {
+	ok, err := evalTest(al.Test, vars)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		return
+	}
+
+	firing := a.firing[al.UUID]
+	if firing == nil {
+		firing = make(map[string]bool)
+		a.firing[al.UUID] = firing
+	}
+
+	if !ok {
+		delete(firing, id)
+		return
+	}
+	if firing[id] {
+		return
+	}
+	firing[id] = true
+
+	al.Count++
+
+	msg := AlertMessage{
+		UUID:       al.UUID,
+		Type:       FIXED,
+		Timestamp:  time.Now(),
+		Count:      al.Count,
+		Reason:     al.Action,
+		ReasonData: reasonData,
+	}
+
+	logging.GetLogger().Debugf("AlertMessage to WS : " + al.UUID + " " + msg.String())
+	for _, l := range a.eventListeners {
+		l.OnAlert(&msg)
+	}
+	go runAction(al.Action, al.UUID, msg.Marshal())
+}
+
 func (a *AlertManager) EvalNodes() {
-	a.alertsLock.RLock()
-	defer a.alertsLock.RUnlock()
+	a.alertsLock.Lock()
+	defer a.alertsLock.Unlock()
 
 	for _, al := range a.alerts {
+		if al.Select == FlowSelect {
+			continue
+		}
+
 		nodes := a.Graph.LookupNodesFromKey(al.Select)
 		for _, n := range nodes {
-			w := eval.NewWorld()
-			defConst := func(name string, val interface{}) {
-				t, v := toTypeValue(val)
-				w.DefineConst(name, t, v)
-			}
-			for k, v := range n.Metadata() {
-				defConst(k, v)
-			}
-			fs := token.NewFileSet()
-			toEval := "(" + al.Test + ") == true"
-			expr, err := w.Compile(fs, toEval)
-			if err != nil {
-				logging.GetLogger().Error("Can't compile expression : " + toEval)
-				continue
-			}
-			ret, err := expr.Run()
-			if err != nil {
-				logging.GetLogger().Error("Can't evaluate expression : " + toEval)
-				continue
-			}
+			a.check(al, string(n.ID), n.Metadata(), n)
+		}
+	}
+}
+
+// EvalFlows evaluates the flow alerts against the metrics of the flows of
+// the flow table, the bandwidth being computed since the last evaluation
+func (a *AlertManager) EvalFlows() {
+	if a.FlowTable == nil {
+		return
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(a.lastFlowEval).Seconds()
+	a.lastFlowEval = now
+
+	bytes := make(map[string]uint64)
+	metrics := make(map[string]map[string]interface{})
+	flows := a.FlowTable.GetFlows()
+	for _, f := range flows {
+		m := flowMetrics(f)
+		total := m["Bytes"].(uint64)
+		if last, ok := a.flowBytes[f.UUID]; ok && elapsed > 0 && total >= last {
+			m["Bandwidth"] = float64(total-last) / elapsed
+		} else {
+			m["Bandwidth"] = float64(0)
+		}
+		bytes[f.UUID] = total
+		metrics[f.UUID] = m
+	}
+	a.flowBytes = bytes
+
+	a.alertsLock.Lock()
+	defer a.alertsLock.Unlock()
 
-			if ret.String() == "true" {
-				al.Count++
-
-				msg := AlertMessage{
-					UUID:       al.UUID,
-					Type:       FIXED,
-					Timestamp:  time.Now(),
-					Count:      al.Count,
-					Reason:     al.Action,
-					ReasonData: n,
-				}
-
-				logging.GetLogger().Debugf("AlertMessage to WS : " + al.UUID + " " + msg.String())
-				for _, l := range a.eventListeners {
-					l.OnAlert(&msg)
-				}
+	for _, al := range a.alerts {
+		if al.Select != FlowSelect {
+			continue
+		}
+
+		// forget the expired flows
+		for id := range a.firing[al.UUID] {
+			if _, ok := metrics[id]; !ok {
+				delete(a.firing[al.UUID], id)
 			}
 		}
+
+		for _, f := range flows {
+			a.check(al, f.UUID, metrics[f.UUID], f)
+		}
+	}
+}
+
+// flowMetrics returns the variables a flow alert test can use
+func flowMetrics(f *flow.Flow) map[string]interface{} {
+	var bytesAB, bytesBA, packetsAB, packetsBA uint64
+	if eps := f.GetStatistics().GetEndpoints(); len(eps) > 0 {
+		if ab := eps[0].GetAB(); ab != nil {
+			bytesAB, packetsAB = ab.Bytes, ab.Packets
+		}
+		if ba := eps[0].GetBA(); ba != nil {
+			bytesBA, packetsBA = ba.Bytes, ba.Packets
+		}
+	}
+
+	return map[string]interface{}{
+		"UUID":          f.UUID,
+		"TrackingID":    f.TrackingID,
+		"LayersPath":    f.LayersPath,
+		"ProbeNodeUUID": f.ProbeNodeUUID,
+		"BytesAB":       bytesAB,
+		"BytesBA":       bytesBA,
+		"Bytes":         bytesAB + bytesBA,
+		"PacketsAB":     packetsAB,
+		"PacketsBA":     packetsBA,
+		"Packets":       packetsAB + packetsBA,
+	}
+}
+
+func (a *AlertManager) evalFlowsPeriodically() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.FlowEvalInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.EvalFlows()
+		case <-a.quit:
+			return
+		}
 	}
 }
 
@@ -144,6 +283,15 @@ func (a *AlertManager) OnNodeAdded(n *graph.Node) {
 	a.EvalNodes()
 }
 
+func (a *AlertManager) OnNodeDeleted(n *graph.Node) {
+	a.alertsLock.Lock()
+	defer a.alertsLock.Unlock()
+
+	for _, firing := range a.firing {
+		delete(firing, string(n.ID))
+	}
+}
+
 func (a *AlertManager) SetAlert(at *api.Alert) {
 	logging.GetLogger().Debugf("New alert added: %v", at)
 
@@ -160,6 +308,7 @@ func (a *AlertManager) DeleteAlert(id string) {
 	defer a.alertsLock.Unlock()
 
 	delete(a.alerts, id)
+	delete(a.firing, id)
 }
 
 func (a *AlertManager) onApiWatcherEvent(action string, id string, resource api.ApiResource) {
@@ -174,19 +323,43 @@ func (a *AlertManager) onApiWatcherEvent(action string, id string, resource api.
 func (a *AlertManager) Start() {
 	a.watcher = a.AlertHandler.AsyncWatch(a.onApiWatcherEvent)
 
-	a.Graph.AddEventListener(a)
+	a.Graph.AddEventListenerWithPriority(a, graph.ListenerPriorityFromConfig("alert", graph.DefaultListenerPriority))
+
+	a.quit = make(chan bool)
+	a.lastFlowEval = time.Now()
+	a.wg.Add(1)
+	go a.evalFlowsPeriodically()
 }
 
 func (a *AlertManager) Stop() {
+	if a.watcher == nil {
+		return
+	}
+
+	a.Graph.RemoveEventListener(a)
 
+	a.watcher.Stop()
+	a.watcher = nil
+
+	close(a.quit)
+	a.wg.Wait()
+
+	a.alertsLock.Lock()
+	a.alerts = make(map[string]*api.Alert)
+	a.firing = make(map[string]map[string]bool)
+	a.flowBytes = make(map[string]uint64)
+	a.alertsLock.Unlock()
 }
 
 func NewAlertManager(g *graph.Graph, ah api.ApiHandler) *AlertManager {
 	return &AlertManager{
-		Graph:          g,
-		AlertHandler:   ah,
-		alerts:         make(map[string]*api.Alert),
-		eventListeners: make(map[AlertEventListener]AlertEventListener),
+		Graph:            g,
+		AlertHandler:     ah,
+		FlowEvalInterval: time.Duration(config.GetConfig().GetInt("analyzer.alert_flow_interval")) * time.Second,
+		alerts:           make(map[string]*api.Alert),
+		eventListeners:   make(map[AlertEventListener]AlertEventListener),
+		firing:           make(map[string]map[string]bool),
+		flowBytes:        make(map[string]uint64),
 	}
 }
 
@@ -322,6 +495,9 @@ func toTypeValue(val interface{}) (eval.Type, eval.Value) {
 	case uint32:
 		r := uint32V(val)
 		return eval.Uint32Type, &r
+	case uint64:
+		r := uint64V(val)
+		return eval.Uint64Type, &r
 	case uint:
 		r := uintV(val)
 		return eval.Uint64Type, &r
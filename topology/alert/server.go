@@ -82,7 +82,7 @@ func NewServer(a *AlertManager, server *shttp.WSServer) *AlertServer {
 		WSServer:     server,
 		clients:      make(map[*shttp.WSClient]*alertClient),
 	}
-	server.AddEventHandler(s)
+	server.AddEventHandler(s, Namespace)
 
 	return s
 }
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package annotation
+
+import (
+	"reflect"
+
+	"github.com/redhat-cip/skydive/api"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// AnnotationManager sets the user metadata of the annotations on the
+// elements of the graph, as the annotations change and as the elements are
+// added or updated
+type AnnotationManager struct {
+	graph.DefaultGraphListener
+	Graph             *graph.Graph
+	AnnotationHandler api.ApiHandler
+	watcher           api.StoppableWatcher
+	// annotations by element, protected by the lock of the graph
+	annotations map[graph.Identifier]graph.Metadata
+}
+
+func (a *AnnotationManager) element(id graph.Identifier) interface{} {
+	if n := a.Graph.GetNode(id); n != nil {
+		return n
+	}
+	if e := a.Graph.GetEdge(id); e != nil {
+		return e
+	}
+	return nil
+}
+
+// apply sets the user metadata of the element if they differ from its
+// annotation, called with the graph lock held
+func (a *AnnotationManager) apply(e interface{}, id graph.Identifier, m graph.Metadata) {
+	var current interface{}
+	switch e := e.(type) {
+	case *graph.Node:
+		current = e.Metadata()[graph.UserMetadataKey]
+	case *graph.Edge:
+		current = e.Metadata()[graph.UserMetadataKey]
+	}
+
+	var expected interface{}
+	if len(m) > 0 {
+		expected = map[string]interface{}(m)
+	}
+	if reflect.DeepEqual(current, expected) {
+		return
+	}
+
+	logging.GetLogger().Debugf("Setting the user metadata of %s", id)
+	a.Graph.SetUserMetadata(e, m)
+}
+
+func (a *AnnotationManager) SetAnnotation(annotation *api.Annotation) {
+	a.Graph.Lock()
+	defer a.Graph.Unlock()
+
+	id := graph.Identifier(annotation.ElementID)
+	a.annotations[id] = annotation.Metadata
+
+	if e := a.element(id); e != nil {
+		a.apply(e, id, annotation.Metadata)
+	}
+}
+
+func (a *AnnotationManager) DeleteAnnotation(elementID string) {
+	a.Graph.Lock()
+	defer a.Graph.Unlock()
+
+	id := graph.Identifier(elementID)
+	delete(a.annotations, id)
+
+	if e := a.element(id); e != nil {
+		a.apply(e, id, nil)
+	}
+}
+
+func (a *AnnotationManager) onApiWatcherEvent(action string, id string, resource api.ApiResource) {
+	switch action {
+	case "init", "create", "set", "update":
+		a.SetAnnotation(resource.(*api.Annotation))
+	case "expire", "delete":
+		a.DeleteAnnotation(id)
+	}
+}
+
+func (a *AnnotationManager) onElementChanged(e interface{}, id graph.Identifier) {
+	if m, ok := a.annotations[id]; ok {
+		a.apply(e, id, m)
+	}
+}
+
+func (a *AnnotationManager) OnNodeAdded(n *graph.Node) {
+	a.onElementChanged(n, n.ID)
+}
+
+func (a *AnnotationManager) OnNodeUpdated(n *graph.Node) {
+	a.onElementChanged(n, n.ID)
+}
+
+func (a *AnnotationManager) OnEdgeAdded(e *graph.Edge) {
+	a.onElementChanged(e, e.ID)
+}
+
+func (a *AnnotationManager) OnEdgeUpdated(e *graph.Edge) {
+	a.onElementChanged(e, e.ID)
+}
+
+func (a *AnnotationManager) Start() {
+	a.watcher = a.AnnotationHandler.AsyncWatch(a.onApiWatcherEvent)
+
+	a.Graph.AddEventListenerWithPriority(a, graph.ListenerPriorityFromConfig("annotation", graph.DerivationListenerPriority))
+}
+
+func (a *AnnotationManager) Stop() {
+	if a.watcher == nil {
+		return
+	}
+
+	a.Graph.RemoveEventListener(a)
+
+	a.watcher.Stop()
+	a.watcher = nil
+}
+
+func NewAnnotationManager(g *graph.Graph, ah api.ApiHandler) *AnnotationManager {
+	return &AnnotationManager{
+		Graph:             g,
+		AnnotationHandler: ah,
+		annotations:       make(map[graph.Identifier]graph.Metadata),
+	}
+}
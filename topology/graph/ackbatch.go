@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	shttp "github.com/redhat-cip/skydive/http"
+)
+
+const (
+	maxAckBatchSize = 100
+	ackBatchDelay   = 100 * time.Millisecond
+)
+
+// AckBatch acknowledges all the requests received from a client between
+// the First and the Last ones included, in the order they were received.
+// The requests of the range that failed are listed in Failed.
+type AckBatch struct {
+	First  string
+	Last   string
+	Count  int
+	Failed []string
+}
+
+type pendingAckBatch struct {
+	AckBatch
+	timer *time.Timer
+}
+
+// ackBatcher accumulates the acknowledgements of the clients that asked for
+// batched acks and sends them when the batch is full or after a short delay.
+type ackBatcher struct {
+	sync.Mutex
+	batches map[*shttp.WSClient]*pendingAckBatch
+}
+
+func (a *ackBatcher) flush(c *shttp.WSClient) {
+	a.Lock()
+	b, ok := a.batches[c]
+	if ok {
+		delete(a.batches, c)
+		b.timer.Stop()
+	}
+	a.Unlock()
+
+	if !ok {
+		return
+	}
+
+	data, _ := json.Marshal(&b.AckBatch)
+	raw := json.RawMessage(data)
+
+	c.SendWSMessage(shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      "AckBatch",
+		Obj:       &raw,
+	})
+}
+
+func (a *ackBatcher) add(c *shttp.WSClient, uuid string, ack bool) {
+	a.Lock()
+
+	b, ok := a.batches[c]
+	if !ok {
+		b = &pendingAckBatch{AckBatch: AckBatch{First: uuid, Failed: []string{}}}
+		b.timer = time.AfterFunc(ackBatchDelay, func() { a.flush(c) })
+		a.batches[c] = b
+	}
+
+	b.Last = uuid
+	b.Count++
+	if !ack {
+		b.Failed = append(b.Failed, uuid)
+	}
+	full := b.Count >= maxAckBatchSize
+
+	a.Unlock()
+
+	if full {
+		a.flush(c)
+	}
+}
+
+func (a *ackBatcher) drop(c *shttp.WSClient) {
+	a.Lock()
+	defer a.Unlock()
+
+	if b, ok := a.batches[c]; ok {
+		b.timer.Stop()
+		delete(a.batches, c)
+	}
+}
+
+func newAckBatcher() *ackBatcher {
+	return &ackBatcher{
+		batches: make(map[*shttp.WSClient]*pendingAckBatch),
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+)
+
+type neighbor struct {
+	edge Identifier
+	node Identifier
+}
+
+// adjacency is a lightweight copy of the undirected structure of a part of
+// the graph, algorithms can then run on it without holding the graph lock.
+type adjacency map[Identifier][]neighbor
+
+// snapshotAdjacency returns the adjacency of the subgraph induced by the
+// given nodes, only edges matching the em metadata are followed.
+func (g *Graph) snapshotAdjacency(nodes []*Node, em Metadata) adjacency {
+	adj := make(adjacency)
+	for _, n := range nodes {
+		adj[n.ID] = []neighbor{}
+	}
+
+	for _, n := range nodes {
+		for _, e := range g.backend.GetNodeEdges(n) {
+			if em != nil && !e.matchMetadata(em) {
+				continue
+			}
+
+			peer := e.parent
+			if peer == n.ID {
+				peer = e.child
+			}
+
+			if _, ok := adj[peer]; !ok || peer == n.ID {
+				continue
+			}
+
+			adj[n.ID] = append(adj[n.ID], neighbor{edge: e.ID, node: peer})
+		}
+	}
+
+	return adj
+}
+
+// cutSet returns the articulation points and the bridges of the adjacency
+// using an iterative version of the Tarjan algorithm so that large graphs
+// don't exhaust the stack.
+func (adj adjacency) cutSet() (map[Identifier]bool, map[Identifier]bool) {
+	type frame struct {
+		node     Identifier
+		edge     Identifier
+		next     int
+		children int
+	}
+
+	points := make(map[Identifier]bool)
+	bridges := make(map[Identifier]bool)
+	disc := make(map[Identifier]int)
+	low := make(map[Identifier]int)
+	index := 0
+
+	for root := range adj {
+		if _, ok := disc[root]; ok {
+			continue
+		}
+
+		index++
+		disc[root], low[root] = index, index
+		stack := []*frame{{node: root}}
+
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+
+			if f.next < len(adj[f.node]) {
+				nb := adj[f.node][f.next]
+				f.next++
+
+				// do not go back through the edge used to reach this node,
+				// parallel edges are then correctly handled.
+				if nb.edge == f.edge {
+					continue
+				}
+
+				if d, ok := disc[nb.node]; ok {
+					if d < low[f.node] {
+						low[f.node] = d
+					}
+					continue
+				}
+
+				index++
+				disc[nb.node], low[nb.node] = index, index
+				f.children++
+				stack = append(stack, &frame{node: nb.node, edge: nb.edge})
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				if f.children > 1 {
+					points[f.node] = true
+				}
+				break
+			}
+
+			p := stack[len(stack)-1]
+			if low[f.node] < low[p.node] {
+				low[p.node] = low[f.node]
+			}
+			if low[f.node] > disc[p.node] {
+				bridges[f.edge] = true
+			}
+			// the root is handled when popped
+			if len(stack) > 1 && low[f.node] >= disc[p.node] {
+				points[p.node] = true
+			}
+		}
+	}
+
+	return points, bridges
+}
+
+// LookupArticulationPoints returns the nodes and the edges whose removal
+// disconnects the subgraph induced by the given nodes. Only the edges
+// matching the optional em metadata are taken into account. Must be called
+// with the graph lock held, for the whole computation, the periodic marking
+// of the ArticulationMarker being computed without it.
+func (g *Graph) LookupArticulationPoints(nodes []*Node, em ...Metadata) ([]*Node, []*Edge) {
+	var m Metadata
+	if len(em) > 0 {
+		m = em[0]
+	}
+
+	points, bridges := g.snapshotAdjacency(nodes, m).cutSet()
+
+	cutNodes := []*Node{}
+	cutEdges := []*Edge{}
+	for _, n := range nodes {
+		if points[n.ID] {
+			cutNodes = append(cutNodes, n)
+		}
+	}
+	for id := range bridges {
+		if e := g.GetEdge(id); e != nil {
+			cutEdges = append(cutEdges, e)
+		}
+	}
+
+	return cutNodes, cutEdges
+}
+
+// MarkArticulationPoints computes the articulation points and the bridges of
+// the whole graph and writes the result as "ArticulationPoint" and "Bridge"
+// metadata. The graph lock is only held to take a snapshot of the structure
+// of the graph and to write the result, not while computing.
+func (g *Graph) MarkArticulationPoints(em ...Metadata) {
+	var m Metadata
+	if len(em) > 0 {
+		m = em[0]
+	}
+
+	g.RLock()
+	adj := g.snapshotAdjacency(g.GetNodes(), m)
+	g.RUnlock()
+
+	points, bridges := adj.cutSet()
+
+	g.Lock()
+	defer g.Unlock()
+
+	for _, n := range g.GetNodes() {
+		g.markElement(n, "ArticulationPoint", points[n.ID])
+	}
+
+	for _, e := range g.GetEdges() {
+		g.markElement(e, "Bridge", bridges[e.ID])
+	}
+}
+
+func (g *Graph) markElement(i interface{}, k string, v bool) {
+	var e graphElement
+
+	switch i.(type) {
+	case *Node:
+		e = i.(*Node).graphElement
+	case *Edge:
+		e = i.(*Edge).graphElement
+	}
+
+	if _, ok := e.metadata[k]; ok && !v {
+		m := make(Metadata)
+		for mk, mv := range e.metadata {
+			if mk != k {
+				m[mk] = mv
+			}
+		}
+		g.SetMetadata(i, m)
+	} else if v {
+		g.AddMetadata(i, k, true)
+	}
+}
+
+// ArticulationMarker marks periodically the articulation points and the
+// bridges of the graph, see MarkArticulationPoints
+type ArticulationMarker struct {
+	graph    *Graph
+	interval time.Duration
+	em       Metadata
+	quit     chan bool
+	wg       sync.WaitGroup
+}
+
+func (a *ArticulationMarker) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.graph.MarkArticulationPoints(a.em)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+func (a *ArticulationMarker) Start() {
+	a.wg.Add(1)
+	go a.run()
+}
+
+func (a *ArticulationMarker) Stop() {
+	a.quit <- true
+	a.wg.Wait()
+}
+
+func NewArticulationMarker(g *Graph, interval time.Duration, em Metadata) *ArticulationMarker {
+	return &ArticulationMarker{
+		graph:    g,
+		interval: interval,
+		em:       em,
+		quit:     make(chan bool, 1),
+	}
+}
+
+// ArticulationMarkerFromConfig returns a marker if an interval is configured,
+// nil otherwise
+func ArticulationMarkerFromConfig(g *Graph) *ArticulationMarker {
+	interval := config.GetConfig().GetInt("graph.articulation_points.interval")
+	if interval <= 0 {
+		return nil
+	}
+
+	var em Metadata
+	if rt := config.GetConfig().GetString("graph.articulation_points.relation_type"); rt != "" {
+		em = Metadata{"RelationType": rt}
+	}
+
+	return NewArticulationMarker(g, time.Duration(interval)*time.Second, em)
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/redhat-cip/skydive/logging"
+)
+
+const (
+	boltFlushPeriod = time.Second
+	boltOpenTimeout = 5 * time.Second
+)
+
+var (
+	boltNodesBucket = []byte("nodes")
+	boltEdgesBucket = []byte("edges")
+)
+
+// BoltBackend is a memory backend persisted in a BoltDB file. Reads are
+// served from memory, writes are queued and committed to the file
+// periodically so that the graph is reloaded on restart, at most the last
+// second of updates is lost on crash.
+type BoltBackend struct {
+	*MemoryBackend
+	sync.Mutex
+	db      *bolt.DB
+	pending map[string]map[string][]byte
+	quit    chan bool
+	wg      sync.WaitGroup
+}
+
+// queue records the last state of an element, nil data meaning deleted
+func (b *BoltBackend) queue(bucket []byte, i Identifier, data []byte) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.pending[string(bucket)][string(i)] = data
+}
+
+func (b *BoltBackend) queueNode(n *Node) {
+	data, _ := n.MarshalJSON()
+	b.queue(boltNodesBucket, n.ID, data)
+}
+
+func (b *BoltBackend) queueEdge(e *Edge) {
+	data, _ := e.MarshalJSON()
+	b.queue(boltEdgesBucket, e.ID, data)
+}
+
+func (b *BoltBackend) flush() error {
+	b.Lock()
+	pending := b.pending
+	b.pending = map[string]map[string][]byte{
+		string(boltNodesBucket): make(map[string][]byte),
+		string(boltEdgesBucket): make(map[string][]byte),
+	}
+	b.Unlock()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for bucket, elements := range pending {
+			bk := tx.Bucket([]byte(bucket))
+			for id, data := range elements {
+				var err error
+				if data == nil {
+					err = bk.Delete([]byte(id))
+				} else {
+					err = bk.Put([]byte(id), data)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltBackend) run() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(boltFlushPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.flush(); err != nil {
+				logging.GetLogger().Errorf("Unable to write the graph to %s: %s", b.db.Path(), err.Error())
+			}
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+func (b *BoltBackend) AddNode(n *Node) bool {
+	if !b.MemoryBackend.AddNode(n) {
+		return false
+	}
+	b.queueNode(n)
+	return true
+}
+
+func (b *BoltBackend) DelNode(n *Node) bool {
+	if !b.MemoryBackend.DelNode(n) {
+		return false
+	}
+	b.queue(boltNodesBucket, n.ID, nil)
+	return true
+}
+
+func (b *BoltBackend) AddEdge(e *Edge) bool {
+	if !b.MemoryBackend.AddEdge(e) {
+		return false
+	}
+	b.queueEdge(e)
+	return true
+}
+
+func (b *BoltBackend) DelEdge(e *Edge) bool {
+	if !b.MemoryBackend.DelEdge(e) {
+		return false
+	}
+	b.queue(boltEdgesBucket, e.ID, nil)
+	return true
+}
+
+func (b *BoltBackend) queueElement(i interface{}) {
+	switch i.(type) {
+	case *Node:
+		b.queueNode(i.(*Node))
+	case *Edge:
+		b.queueEdge(i.(*Edge))
+	}
+}
+
+func (b *BoltBackend) AddMetadata(i interface{}, k string, v interface{}) bool {
+	if !b.MemoryBackend.AddMetadata(i, k, v) {
+		return false
+	}
+	b.queueElement(i)
+	return true
+}
+
+func (b *BoltBackend) SetMetadata(i interface{}, m Metadata) bool {
+	if !b.MemoryBackend.SetMetadata(i, m) {
+		return false
+	}
+	b.queueElement(i)
+	return true
+}
+
+// Close writes the pending updates and closes the file
+func (b *BoltBackend) Close() error {
+	b.quit <- true
+	b.wg.Wait()
+
+	if err := b.flush(); err != nil {
+		b.db.Close()
+		return err
+	}
+	return b.db.Close()
+}
+
+func (b *BoltBackend) load() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltNodesBucket).ForEach(func(k, v []byte) error {
+			var obj interface{}
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return err
+			}
+
+			var n Node
+			if err := n.Decode(obj); err != nil {
+				return err
+			}
+			b.MemoryBackend.AddNode(&n)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltEdgesBucket).ForEach(func(k, v []byte) error {
+			var obj interface{}
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return err
+			}
+
+			var e Edge
+			if err := e.Decode(obj); err != nil {
+				return err
+			}
+
+			if !b.MemoryBackend.AddEdge(&e) {
+				logging.GetLogger().Warningf("Unable to load the edge %s, its nodes are missing", e.ID)
+			}
+
+			return nil
+		})
+	})
+}
+
+// NewBoltBackend opens, creating it if needed, the BoltDB file and loads
+// the graph it contains.
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltNodesBucket, boltEdgesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m, _ := NewMemoryBackend()
+
+	b := &BoltBackend{
+		MemoryBackend: m,
+		db:            db,
+		pending: map[string]map[string][]byte{
+			string(boltNodesBucket): make(map[string][]byte),
+			string(boltEdgesBucket): make(map[string][]byte),
+		},
+		quit: make(chan bool),
+	}
+
+	if err := b.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b.wg.Add(1)
+	go b.run()
+
+	return b, nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	changeRatePeriod = 10 * time.Second
+)
+
+type changeRate struct {
+	rate float64
+	last time.Time
+}
+
+// ChangeRateTracker keeps track of the number of updates per second of the
+// graph elements. The rate is exponentially decayed over a period of 10
+// seconds so that a burst of updates is quickly noticed and forgotten.
+type ChangeRateTracker struct {
+	sync.RWMutex
+	rates map[Identifier]*changeRate
+}
+
+func decay(r *changeRate, now time.Time) float64 {
+	return r.rate * math.Exp(-now.Sub(r.last).Seconds()/changeRatePeriod.Seconds())
+}
+
+// Update records an update of the element at the given time and returns
+// the new rate.
+func (c *ChangeRateTracker) Update(i Identifier, now time.Time) float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.rates[i]
+	if !ok {
+		r = &changeRate{}
+		c.rates[i] = r
+	} else {
+		r.rate = decay(r, now)
+	}
+	r.rate += 1 / changeRatePeriod.Seconds()
+	r.last = now
+
+	return r.rate
+}
+
+// Rate returns the current number of updates per second of the element.
+func (c *ChangeRateTracker) Rate(i Identifier, now time.Time) float64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	if r, ok := c.rates[i]; ok {
+		return decay(r, now)
+	}
+	return 0
+}
+
+func (c *ChangeRateTracker) Delete(i Identifier) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.rates, i)
+}
+
+func NewChangeRateTracker() *ChangeRateTracker {
+	return &ChangeRateTracker{
+		rates: make(map[Identifier]*changeRate),
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+const (
+	cloudEventsTypePrefix   = "skydive.graph."
+	cloudEventsQueueSize    = 10000
+	cloudEventsBatchSize    = 100
+	cloudEventsFlushPeriod  = time.Second
+	cloudEventsPostTimeout  = 10 * time.Second
+	cloudEventsBatchContent = "application/cloudevents-batch+json"
+)
+
+// CloudEvent is the envelope of a graph event following the CloudEvents 1.0
+// specification, JSON format.
+type CloudEvent struct {
+	SpecVersion     string           `json:"specversion"`
+	ID              string           `json:"id"`
+	Source          string           `json:"source"`
+	Type            string           `json:"type"`
+	Subject         string           `json:"subject"`
+	Time            string           `json:"time"`
+	DataContentType string           `json:"datacontenttype"`
+	Data            *json.RawMessage `json:"data"`
+}
+
+// CloudEventsSink is a graph listener sending the graph events as
+// CloudEvents, in batch mode, to an HTTP endpoint. Events are queued and
+// sent asynchronously, they are dropped if the endpoint is too slow.
+type CloudEventsSink struct {
+	URL    string
+	Source string
+	graph  *Graph
+	client *http.Client
+	events chan *CloudEvent
+	quit   chan bool
+	wg     sync.WaitGroup
+}
+
+// the data are encoded when the event occurs, with the graph lock held
+func (s *CloudEventsSink) push(t string, subject Identifier, data *json.RawMessage) {
+	ev := &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              string(GenID()),
+		Source:          s.Source,
+		Type:            cloudEventsTypePrefix + t,
+		Subject:         string(subject),
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	select {
+	case s.events <- ev:
+	default:
+		logging.GetLogger().Warningf("CloudEvents queue full, event %s of %s dropped", ev.Type, ev.Subject)
+	}
+}
+
+func (s *CloudEventsSink) OnNodeUpdated(n *Node) {
+	s.push("node.updated", n.ID, n.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) OnNodeAdded(n *Node) {
+	s.push("node.added", n.ID, n.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) OnNodeDeleted(n *Node) {
+	s.push("node.deleted", n.ID, n.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) OnEdgeUpdated(e *Edge) {
+	s.push("edge.updated", e.ID, e.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) OnEdgeAdded(e *Edge) {
+	s.push("edge.added", e.ID, e.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) OnEdgeDeleted(e *Edge) {
+	s.push("edge.deleted", e.ID, e.JsonRawMessage())
+}
+
+func (s *CloudEventsSink) post(batch []*CloudEvent) {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode CloudEvents: %s", err.Error())
+		return
+	}
+
+	resp, err := s.client.Post(s.URL, cloudEventsBatchContent, bytes.NewReader(data))
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to send %d CloudEvents to %s: %s", len(batch), s.URL, err.Error())
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logging.GetLogger().Errorf("Unable to send %d CloudEvents to %s: %s", len(batch), s.URL, resp.Status)
+	}
+}
+
+func (s *CloudEventsSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(cloudEventsFlushPeriod)
+	defer ticker.Stop()
+
+	batch := []*CloudEvent{}
+	for {
+		select {
+		case ev := <-s.events:
+			batch = append(batch, ev)
+			if len(batch) < cloudEventsBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.quit:
+			// flush the events queued before stopping
+			for len(s.events) > 0 {
+				batch = append(batch, <-s.events)
+			}
+			if len(batch) > 0 {
+				s.post(batch)
+			}
+			return
+		}
+
+		if len(batch) > 0 {
+			s.post(batch)
+			batch = []*CloudEvent{}
+		}
+	}
+}
+
+func (s *CloudEventsSink) Start() {
+	s.graph.AddEventListenerWithPriority(s, ListenerPriorityFromConfig("cloudevents", ExportListenerPriority))
+
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *CloudEventsSink) Stop() {
+	s.graph.RemoveEventListener(s)
+
+	s.quit <- true
+	s.wg.Wait()
+}
+
+func NewCloudEventsSink(g *Graph, url string, source string) *CloudEventsSink {
+	return &CloudEventsSink{
+		URL:    url,
+		Source: source,
+		graph:  g,
+		client: &http.Client{Timeout: cloudEventsPostTimeout},
+		events: make(chan *CloudEvent, cloudEventsQueueSize),
+		quit:   make(chan bool, 1),
+	}
+}
+
+// CloudEventsSinkFromConfig returns a sink if an endpoint is configured, nil
+// otherwise. The source of the events is the service and the host.
+func CloudEventsSinkFromConfig(g *Graph, service string) *CloudEventsSink {
+	url := config.GetConfig().GetString("graph.cloudevents.url")
+	if url == "" {
+		return nil
+	}
+
+	return NewCloudEventsSink(g, url, fmt.Sprintf("skydive://%s/%s", g.host, service))
+}
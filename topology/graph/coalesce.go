@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"time"
+)
+
+// updateCoalescer delays the NodeUpdated and EdgeUpdated events during a
+// window, the successive updates of an element being merged into a single
+// event carrying its latest state. Must be used with the graph lock held.
+type updateCoalescer struct {
+	window  time.Duration
+	pending map[Identifier]bool
+	order   []Identifier
+	timer   *time.Timer
+}
+
+// add records an update of the element, flush being called once the window
+// started by the first pending update has elapsed.
+func (u *updateCoalescer) add(id Identifier, flush func()) {
+	if u.pending[id] {
+		coalescedUpdates.Inc()
+		return
+	}
+
+	u.pending[id] = true
+	u.order = append(u.order, id)
+
+	if u.timer == nil {
+		u.timer = time.AfterFunc(u.window, flush)
+	}
+}
+
+// drop discards the pending update of a deleted element
+func (u *updateCoalescer) drop(id Identifier) {
+	delete(u.pending, id)
+}
+
+// take returns the elements having a pending update, in the order of their
+// first update, and starts a new window.
+func (u *updateCoalescer) take() []Identifier {
+	ids := []Identifier{}
+	for _, id := range u.order {
+		if u.pending[id] {
+			ids = append(ids, id)
+			delete(u.pending, id)
+		}
+	}
+
+	u.order = nil
+	u.timer = nil
+
+	return ids
+}
+
+func newUpdateCoalescer(window time.Duration) *updateCoalescer {
+	return &updateCoalescer{
+		window:  window,
+		pending: make(map[Identifier]bool),
+	}
+}
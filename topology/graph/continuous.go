@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// ContinuousQuery is a traversal registered by a client. Its result set is
+// evaluated once and then maintained after the graph mutations, only the
+// elements added to or removed from the result set are sent to the client.
+// The queries only filtering the nodes, ex: G.V().Has('Type', 'netns'), are
+// maintained by evaluating their filters on the touched nodes only, the
+// other ones are evaluated again. The hidden elements are part of the result
+// set only for the clients getting them.
+type ContinuousQuery struct {
+	GremlinQuery string
+	client       *shttp.WSClient
+	uuid         string
+	sequence     *GremlinTraversalSequence
+	members      map[string]interface{}
+}
+
+// ContinuousQueryDelta is the message sent to the client when the result
+// set of a continuous query changes, the first one contains the whole
+// result set.
+type ContinuousQueryDelta struct {
+	Added   []interface{}
+	Removed []interface{}
+}
+
+// continuousQueries maintains the result sets of the queries outside of the
+// graph event listeners, the touched elements being recorded by the
+// listeners and evaluated later, while holding the graph read lock.
+type continuousQueries struct {
+	sync.RWMutex
+	graph   *Graph
+	queries map[*shttp.WSClient]map[string]*ContinuousQuery
+	touched map[Identifier]bool
+	dirty   bool
+	pending bool
+}
+
+func memberKey(v interface{}) string {
+	switch v := v.(type) {
+	case *Node:
+		return "node:" + string(v.ID)
+	case *Edge:
+		return "edge:" + string(v.ID)
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func newContinuousQueryDelta() *ContinuousQueryDelta {
+	return &ContinuousQueryDelta{Added: []interface{}{}, Removed: []interface{}{}}
+}
+
+func (q *ContinuousQuery) eval() (*ContinuousQueryDelta, error) {
+	res, err := q.sequence.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	delta := newContinuousQueryDelta()
+
+	members := make(map[string]interface{})
+	for _, v := range res.Values() {
+		k := memberKey(v)
+		if _, ok := q.members[k]; !ok {
+			delta.Added = append(delta.Added, v)
+		}
+		members[k] = v
+	}
+
+	for k, v := range q.members {
+		if _, ok := members[k]; !ok {
+			delta.Removed = append(delta.Removed, v)
+		}
+	}
+	q.members = members
+
+	return delta, nil
+}
+
+// evalNodes updates the result set of a node filter query with the touched
+// nodes, the graph of the query, without the hidden nodes for the clients
+// not getting them, giving the current state of the nodes
+func (q *ContinuousQuery) evalNodes(touched map[Identifier]bool) *ContinuousQueryDelta {
+	delta := newContinuousQueryDelta()
+
+	for id := range touched {
+		k := "node:" + string(id)
+		old, member := q.members[k]
+
+		n := q.sequence.GraphTraversal.Graph.GetNode(id)
+		matched := n != nil && q.sequence.MatchNode(n)
+
+		if matched && !member {
+			delta.Added = append(delta.Added, n)
+			q.members[k] = n
+		} else if !matched && member {
+			delta.Removed = append(delta.Removed, old)
+			delete(q.members, k)
+		}
+	}
+
+	return delta
+}
+
+func (q *ContinuousQuery) send(t string, obj interface{}) {
+	b, _ := json.Marshal(obj)
+	raw := json.RawMessage(b)
+
+	q.client.SendWSMessage(shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      t,
+		UUID:      q.uuid,
+		Obj:       &raw,
+	})
+}
+
+func (q *ContinuousQuery) update(touched map[Identifier]bool, dirty bool) {
+	var delta *ContinuousQueryDelta
+	if q.sequence.NodeFilter() {
+		if len(touched) == 0 {
+			return
+		}
+		delta = q.evalNodes(touched)
+	} else {
+		if !dirty {
+			return
+		}
+
+		var err error
+		if delta, err = q.eval(); err != nil {
+			logging.GetLogger().Errorf("Unable to evaluate the continuous query %s: %s", q.GremlinQuery, err.Error())
+			return
+		}
+	}
+
+	if len(delta.Added) > 0 || len(delta.Removed) > 0 {
+		q.send("ContinuousQueryDelta", delta)
+	}
+}
+
+// register parses and evaluates the query, the whole result set is sent
+// back to the client. Must be called with the graph lock held.
+func (c *continuousQueries) register(client *shttp.WSClient, uuid string, query string) error {
+	g := c.graph
+	if !withHidden(client) {
+		g = g.Visible()
+	}
+
+	seq, err := NewGremlinTraversalParser(strings.NewReader(query), g).Parse()
+	if err != nil {
+		return err
+	}
+
+	q := &ContinuousQuery{
+		GremlinQuery: query,
+		client:       client,
+		uuid:         uuid,
+		sequence:     seq,
+		members:      make(map[string]interface{}),
+	}
+
+	delta, err := q.eval()
+	if err != nil {
+		return err
+	}
+	q.send("ContinuousQueryDelta", delta)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.queries[client]; !ok {
+		c.queries[client] = make(map[string]*ContinuousQuery)
+	}
+	c.queries[client][uuid] = q
+
+	return nil
+}
+
+func (c *continuousQueries) unregister(client *shttp.WSClient, uuid string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if uuid == "" {
+		delete(c.queries, client)
+		return
+	}
+
+	if queries, ok := c.queries[client]; ok {
+		delete(queries, uuid)
+		if len(queries) == 0 {
+			delete(c.queries, client)
+		}
+	}
+}
+
+// touch records a mutation of an element, called on graph events so with
+// the graph lock held. The queries are evaluated by a goroutine once the
+// lock released.
+func (c *continuousQueries) touch(id Identifier, node bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.queries) == 0 {
+		return
+	}
+
+	if node {
+		c.touched[id] = true
+	}
+	c.dirty = true
+
+	if !c.pending {
+		c.pending = true
+		go c.update()
+	}
+}
+
+// update evaluates the queries with the elements touched since the last
+// evaluation
+func (c *continuousQueries) update() {
+	c.graph.RLock()
+	defer c.graph.RUnlock()
+
+	c.Lock()
+	defer c.Unlock()
+
+	touched, dirty := c.touched, c.dirty
+	c.touched, c.dirty, c.pending = make(map[Identifier]bool), false, false
+
+	for _, queries := range c.queries {
+		for _, q := range queries {
+			q.update(touched, dirty)
+		}
+	}
+}
+
+// containing returns the UUIDs of the queries of the client whose result
+// set contains the element.
+func (c *continuousQueries) containing(client *shttp.WSClient, i interface{}) []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	uuids := []string{}
+	for uuid, q := range c.queries[client] {
+		if _, ok := q.members[memberKey(i)]; ok {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func newContinuousQueries(g *Graph) *continuousQueries {
+	return &continuousQueries{
+		graph:   g,
+		queries: make(map[*shttp.WSClient]map[string]*ContinuousQuery),
+		touched: make(map[Identifier]bool),
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// GraphDiff lists the elements added, deleted or whose metadata were
+// updated between two graphs, the updated ones as in the second graph
+type GraphDiff struct {
+	AddedNodes   []*Node
+	DeletedNodes []*Node
+	UpdatedNodes []*Node
+	AddedEdges   []*Edge
+	DeletedEdges []*Edge
+	UpdatedEdges []*Edge
+}
+
+// sameMetadata compares the metadata by their JSON encoding, the numbers
+// of a snapshot or of a graph received from the agents being float64
+func sameMetadata(m1, m2 Metadata) bool {
+	j1, err1 := json.Marshal(m1)
+	j2, err2 := json.Marshal(m2)
+	return err1 == nil && err2 == nil && string(j1) == string(j2)
+}
+
+// Diff returns the differences between the graph from and the graph to,
+// both locked by the caller
+func Diff(from, to *Graph) *GraphDiff {
+	diff := &GraphDiff{
+		AddedNodes:   []*Node{},
+		DeletedNodes: []*Node{},
+		UpdatedNodes: []*Node{},
+		AddedEdges:   []*Edge{},
+		DeletedEdges: []*Edge{},
+		UpdatedEdges: []*Edge{},
+	}
+
+	for _, n := range to.GetNodes() {
+		if old := from.GetNode(n.ID); old == nil {
+			diff.AddedNodes = append(diff.AddedNodes, n)
+		} else if !sameMetadata(old.metadata, n.metadata) {
+			diff.UpdatedNodes = append(diff.UpdatedNodes, n)
+		}
+	}
+	for _, n := range from.GetNodes() {
+		if to.GetNode(n.ID) == nil {
+			diff.DeletedNodes = append(diff.DeletedNodes, n)
+		}
+	}
+
+	for _, e := range to.GetEdges() {
+		if old := from.GetEdge(e.ID); old == nil {
+			diff.AddedEdges = append(diff.AddedEdges, e)
+		} else if !sameMetadata(old.metadata, e.metadata) {
+			diff.UpdatedEdges = append(diff.UpdatedEdges, e)
+		}
+	}
+	for _, e := range from.GetEdges() {
+		if to.GetEdge(e.ID) == nil {
+			diff.DeletedEdges = append(diff.DeletedEdges, e)
+		}
+	}
+
+	for _, nodes := range [][]*Node{diff.AddedNodes, diff.DeletedNodes, diff.UpdatedNodes} {
+		sort.Sort(nodesByID(nodes))
+	}
+	for _, edges := range [][]*Edge{diff.AddedEdges, diff.DeletedEdges, diff.UpdatedEdges} {
+		sort.Sort(edgesByID(edges))
+	}
+
+	return diff
+}
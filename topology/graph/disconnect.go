@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// policies applied to the elements of an agent when it disconnects
+const (
+	DisconnectKeep   = "keep"
+	DisconnectStale  = "stale"
+	DisconnectDelete = "delete"
+)
+
+// disconnectTracker applies the disconnect policy to the elements created
+// by the agents, identified by their host. The elements of a disconnected
+// agent are flagged with the StaleSince metadata, the time of the
+// disconnection, and deleted after the grace period with the delete
+// policy unless the agent comes back.
+type disconnectTracker struct {
+	sync.Mutex
+	graph  *Graph
+	policy string
+	grace  time.Duration
+	hosts  map[string]*time.Timer
+}
+
+func (d *disconnectTracker) hostElements(host string) ([]*Node, []*Edge) {
+	var nodes []*Node
+	for _, n := range d.graph.GetNodes() {
+		if n.host == host {
+			nodes = append(nodes, n)
+		}
+	}
+
+	var edges []*Edge
+	for _, e := range d.graph.GetEdges() {
+		if e.host == host {
+			edges = append(edges, e)
+		}
+	}
+
+	return nodes, edges
+}
+
+// disconnected applies the policy to the elements of the host, must be
+// called with the graph lock held
+func (d *disconnectTracker) disconnected(host string) {
+	if d.policy == DisconnectKeep {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.hosts[host]; ok {
+		return
+	}
+
+	logging.GetLogger().Infof("Agent %s disconnected, flagging its elements as stale", host)
+
+	now := time.Now().Unix()
+	nodes, edges := d.hostElements(host)
+	for _, n := range nodes {
+		d.graph.AddMetadata(n, "StaleSince", now)
+	}
+	for _, e := range edges {
+		d.graph.AddMetadata(e, "StaleSince", now)
+	}
+
+	var timer *time.Timer
+	if d.policy == DisconnectDelete {
+		timer = time.AfterFunc(d.grace, func() { d.expire(host) })
+	}
+	d.hosts[host] = timer
+}
+
+// reconnected cancels the deletion of the elements of the host and clears
+// their stale flag, must be called with the graph lock held
+func (d *disconnectTracker) reconnected(host string) {
+	d.Lock()
+	defer d.Unlock()
+
+	timer, ok := d.hosts[host]
+	if !ok {
+		return
+	}
+	delete(d.hosts, host)
+
+	if timer != nil {
+		timer.Stop()
+	}
+
+	logging.GetLogger().Infof("Agent %s reconnected", host)
+
+	nodes, edges := d.hostElements(host)
+	for _, n := range nodes {
+		d.clearStale(n, n.metadata)
+	}
+	for _, e := range edges {
+		d.clearStale(e, e.metadata)
+	}
+}
+
+func (d *disconnectTracker) clearStale(e interface{}, m Metadata) {
+	if _, ok := m["StaleSince"]; !ok {
+		return
+	}
+
+	cleared := Metadata{}
+	for k, v := range m {
+		if k != "StaleSince" {
+			cleared[k] = v
+		}
+	}
+	d.graph.SetMetadata(e, cleared)
+}
+
+// expire deletes the elements of the host once the grace period elapsed
+func (d *disconnectTracker) expire(host string) {
+	d.graph.Lock()
+	defer d.graph.Unlock()
+
+	d.Lock()
+	defer d.Unlock()
+
+	// the host came back in the meantime
+	if _, ok := d.hosts[host]; !ok {
+		return
+	}
+	delete(d.hosts, host)
+
+	logging.GetLogger().Infof("Agent %s still disconnected after %s, deleting its elements", host, d.grace)
+
+	nodes, edges := d.hostElements(host)
+	for _, e := range edges {
+		if d.graph.GetEdge(e.ID) != nil {
+			d.graph.DelEdge(e)
+		}
+	}
+	for _, n := range nodes {
+		if d.graph.GetNode(n.ID) != nil {
+			d.graph.DelNode(n)
+		}
+	}
+}
+
+// isAgent returns whether the client is an agent pushing its elements, the
+// replication peers pushing the ones of other hosts
+func isAgent(c *shttp.WSClient) bool {
+	return c.ClientType() == shttp.AgentClient && c.Host() != "" && c.Role().Allows(shttp.AdminRole)
+}
+
+func newDisconnectTracker(g *Graph, policy string, grace time.Duration) (*disconnectTracker, error) {
+	switch policy {
+	case DisconnectKeep, DisconnectStale, DisconnectDelete:
+	default:
+		return nil, fmt.Errorf("Unknown agent disconnect policy: %s", policy)
+	}
+
+	return &disconnectTracker{
+		graph:  g,
+		policy: policy,
+		grace:  grace,
+		hosts:  make(map[string]*time.Timer),
+	}, nil
+}
+
+func disconnectTrackerFromConfig(g *Graph) *disconnectTracker {
+	policy := config.GetConfig().GetString("analyzer.agent_disconnect.policy")
+	grace := time.Duration(config.GetConfig().GetInt("analyzer.agent_disconnect.grace_period")) * time.Second
+
+	d, err := newDisconnectTracker(g, policy, grace)
+	if err != nil {
+		logging.GetLogger().Errorf("%s, keeping the elements of the disconnected agents", err.Error())
+		d, _ = newDisconnectTracker(g, DisconnectKeep, grace)
+	}
+
+	return d
+}
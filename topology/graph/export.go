@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// exportValue returns the string representation of a metadata value, the
+// values which are not scalars being JSON encoded
+func exportValue(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return v.(string)
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func sortedKeys(m Metadata) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type nodesByID []*Node
+
+func (s nodesByID) Len() int           { return len(s) }
+func (s nodesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s nodesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+type edgesByID []*Edge
+
+func (s edgesByID) Len() int           { return len(s) }
+func (s edgesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s edgesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+// ExportGraphML writes the nodes and the edges in the GraphML format, the
+// host and the metadata being exported as string attributes
+func ExportGraphML(w io.Writer, nodes []*Node, edges []*Edge) error {
+	sort.Sort(nodesByID(nodes))
+	sort.Sort(edgesByID(edges))
+
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{ID: "skydive", EdgeDefault: "directed"},
+	}
+
+	keys := make(map[string]string)
+	keyID := func(kind string, name string) string {
+		if id, ok := keys[kind+"/"+name]; ok {
+			return id
+		}
+		id := fmt.Sprintf("%s%d", kind[:1], len(keys))
+		keys[kind+"/"+name] = id
+		doc.Keys = append(doc.Keys, graphMLKey{ID: id, For: kind, AttrName: name, AttrType: "string"})
+		return id
+	}
+
+	data := func(kind string, e *graphElement) []graphMLData {
+		d := []graphMLData{{Key: keyID(kind, "Host"), Value: e.host}}
+		for _, k := range sortedKeys(e.metadata) {
+			d = append(d, graphMLData{Key: keyID(kind, k), Value: exportValue(e.metadata[k])})
+		}
+		return d
+	}
+
+	for _, n := range nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: string(n.ID), Data: data("node", &n.graphElement)})
+	}
+
+	for _, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     string(e.ID),
+			Source: string(e.parent),
+			Target: string(e.child),
+			Data:   data("edge", &e.graphElement),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+func dotAttributes(label string, e *graphElement) string {
+	attrs := []string{"label=" + dotQuote(label), "Host=" + dotQuote(e.host)}
+	for _, k := range sortedKeys(e.metadata) {
+		attrs = append(attrs, dotQuote(k)+"="+dotQuote(exportValue(e.metadata[k])))
+	}
+	return strings.Join(attrs, ", ")
+}
+
+// ExportDOT writes the nodes and the edges as a Graphviz directed graph,
+// the nodes being labelled by their name and type
+func ExportDOT(w io.Writer, nodes []*Node, edges []*Edge) error {
+	sort.Sort(nodesByID(nodes))
+	sort.Sort(edgesByID(edges))
+
+	lines := []string{"digraph skydive {"}
+	for _, n := range nodes {
+		label := string(n.ID)
+		if name, ok := n.metadata["Name"]; ok {
+			label = exportValue(name)
+		}
+		if t, ok := n.metadata["Type"]; ok {
+			label += "\n" + exportValue(t)
+		}
+		lines = append(lines, fmt.Sprintf("  %s [%s];", dotQuote(string(n.ID)), dotAttributes(label, &n.graphElement)))
+	}
+
+	for _, e := range edges {
+		label := ""
+		if t, ok := e.metadata["RelationType"]; ok {
+			label = exportValue(t)
+		}
+		lines = append(lines, fmt.Sprintf("  %s -> %s [%s];", dotQuote(string(e.parent)), dotQuote(string(e.child)), dotAttributes(label, &e.graphElement)))
+	}
+	lines = append(lines, "}\n")
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// SubGraph returns the nodes and the edges of the values of a traversal,
+// completed by the edges linking the returned nodes. Must be called with
+// the graph lock held.
+func (g *Graph) SubGraph(values []interface{}) ([]*Node, []*Edge) {
+	nodes := make(map[Identifier]*Node)
+	edges := make(map[Identifier]*Edge)
+
+	for _, v := range values {
+		switch v.(type) {
+		case *Graph:
+			for _, n := range v.(*Graph).GetNodes() {
+				nodes[n.ID] = n
+			}
+		case *Node:
+			nodes[v.(*Node).ID] = v.(*Node)
+		case []*Node:
+			for _, n := range v.([]*Node) {
+				nodes[n.ID] = n
+			}
+		case *SubGraph:
+			for _, n := range v.(*SubGraph).Nodes {
+				nodes[n.ID] = n
+			}
+			for _, e := range v.(*SubGraph).Edges {
+				edges[e.ID] = e
+			}
+		case *Edge:
+			e := v.(*Edge)
+			edges[e.ID] = e
+			if parent, child := g.GetEdgeNodes(e); parent != nil && child != nil {
+				nodes[parent.ID] = parent
+				nodes[child.ID] = child
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		for _, e := range g.backend.GetNodeEdges(n) {
+			if _, ok := nodes[e.parent]; !ok {
+				continue
+			}
+			if _, ok := nodes[e.child]; ok {
+				edges[e.ID] = e
+			}
+		}
+	}
+
+	ns := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		ns = append(ns, n)
+	}
+	es := make([]*Edge, 0, len(edges))
+	for _, e := range edges {
+		es = append(es, e)
+	}
+
+	return ns, es
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+// Fork returns an isolated copy of the graph, in memory, for what-if
+// analysis. The mutations of the fork are not propagated to the graph and
+// vice versa, the fork has no event listener. The metadata are shared
+// between the graph and the fork, the memory backend replacing them on
+// update instead of modifying them, but the nodes and the edges are copied,
+// the fork costing a walk of the whole graph. Must be called with the graph
+// lock held.
+func (g *Graph) Fork() (*Graph, error) {
+	b, err := NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range g.GetNodes() {
+		b.AddNode(&Node{graphElement: graphElement{ID: n.ID, metadata: n.metadata, host: n.host}})
+	}
+
+	for _, e := range g.GetEdges() {
+		b.AddEdge(&Edge{graphElement: graphElement{ID: e.ID, metadata: e.metadata, host: e.host}, parent: e.parent, child: e.child})
+	}
+
+	return &Graph{
+		backend:         b,
+		host:            g.host,
+		idGenerator:     g.idGenerator,
+		edgeMergePolicy: g.edgeMergePolicy,
+		index:           g.forkIndex(b.GetNodes()),
+	}, nil
+}
+
+// forkIndex returns an index of the nodes on the fields indexed by the graph
+func (g *Graph) forkIndex(nodes []*Node) *MetadataIndex {
+	if g.index == nil {
+		return nil
+	}
+	return NewMetadataIndex(g.index.Fields(), nodes)
+}
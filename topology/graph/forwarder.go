@@ -23,6 +23,7 @@
 package graph
 
 import (
+	"encoding/json"
 	"os"
 
 	shttp "github.com/redhat-cip/skydive/http"
@@ -79,6 +80,24 @@ func (c *Forwarder) triggerResync() {
 	}
 }
 
+// silent mutations are forwarded as silent so that the analyzer doesn't
+// record them either
+func silentRawMessage(g *Graph, raw *json.RawMessage) *json.RawMessage {
+	if !g.IsSilent() {
+		return raw
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(*raw), &obj); err != nil {
+		return raw
+	}
+	obj["Silent"] = true
+
+	b, _ := json.Marshal(obj)
+	r := json.RawMessage(b)
+	return &r
+}
+
 func (c *Forwarder) OnConnected() {
 	c.triggerResync()
 }
@@ -87,7 +106,7 @@ func (c *Forwarder) OnNodeUpdated(n *Node) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "NodeUpdated",
-		Obj:       n.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, n.JsonRawMessage()),
 	})
 }
 
@@ -95,7 +114,7 @@ func (c *Forwarder) OnNodeAdded(n *Node) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "NodeAdded",
-		Obj:       n.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, n.JsonRawMessage()),
 	})
 }
 
@@ -103,7 +122,7 @@ func (c *Forwarder) OnNodeDeleted(n *Node) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "NodeDeleted",
-		Obj:       n.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, n.JsonRawMessage()),
 	})
 }
 
@@ -111,7 +130,7 @@ func (c *Forwarder) OnEdgeUpdated(e *Edge) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "EdgeUpdated",
-		Obj:       e.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, e.JsonRawMessage()),
 	})
 }
 
@@ -119,7 +138,7 @@ func (c *Forwarder) OnEdgeAdded(e *Edge) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "EdgeAdded",
-		Obj:       e.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, e.JsonRawMessage()),
 	})
 }
 
@@ -127,7 +146,7 @@ func (c *Forwarder) OnEdgeDeleted(e *Edge) {
 	c.Client.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
 		Type:      "EdgeDeleted",
-		Obj:       e.JsonRawMessage(),
+		Obj:       silentRawMessage(c.Graph, e.JsonRawMessage()),
 	})
 }
 
@@ -137,7 +156,7 @@ func NewForwarder(c *shttp.WSAsyncClient, g *Graph) *Forwarder {
 		Graph:  g,
 	}
 
-	g.AddEventListener(f)
+	g.AddEventListenerWithPriority(f, ListenerPriorityFromConfig("forwarder", DefaultListenerPriority))
 	c.AddEventHandler(f)
 
 	return f
@@ -28,11 +28,14 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/nu7hatch/gouuid"
 
 	"github.com/redhat-cip/skydive/common"
 	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
 )
 
 type Identifier string
@@ -46,8 +49,50 @@ type GraphEventListener interface {
 	OnEdgeDeleted(e *Edge)
 }
 
+// ListenerPriority defines the order in which the listeners are notified of
+// an event, lower values first. Listeners deriving metadata from the events
+// should then be notified before the ones broadcasting or exporting them.
+type ListenerPriority int
+
+const (
+	DerivationListenerPriority ListenerPriority = 100
+	DefaultListenerPriority    ListenerPriority = 500
+	ExportListenerPriority     ListenerPriority = 900
+)
+
+type pendingEventKind int
+
+const (
+	nodeUpdatedEvent pendingEventKind = iota
+	nodeAddedEvent
+	nodeDeletedEvent
+	edgeUpdatedEvent
+	edgeAddedEvent
+	edgeDeletedEvent
+)
+
+// pendingEvent is an event queued while notifying the listeners of a previous
+// one, with the listeners to notify at the time it was raised
+type pendingEvent struct {
+	kind      pendingEventKind
+	element   interface{}
+	listeners []GraphEventListener
+}
+
+// HistoryRecorder is implemented by the listeners writing the revisions of
+// the graph to an history backend, they are not notified of the silent
+// mutations.
+type HistoryRecorder interface {
+	GraphEventListener
+	RecordHistory()
+}
+
 type Metadata map[string]interface{}
 
+// UserMetadataKey is the key of the metadata set by the users, which only
+// SetUserMetadata modifies so that the probes can't overwrite them
+const UserMetadataKey = "UserMetadata"
+
 type MetadataTransaction struct {
 	graph        *Graph
 	graphElement interface{}
@@ -88,11 +133,35 @@ type GraphBackend interface {
 	GetEdges() []*Edge
 }
 
+// IndexStats reports the number of entries of an index and whether it is
+// consistent with the elements of the graph.
+type IndexStats struct {
+	Name       string
+	Entries    int
+	Consistent bool
+	Errors     []string `json:",omitempty"`
+}
+
+// IndexedBackend is implemented by the backends maintaining indexes
+type IndexedBackend interface {
+	IndexStats() []IndexStats
+}
+
 type Graph struct {
 	sync.RWMutex
-	backend        GraphBackend
-	host           string
-	eventListeners []GraphEventListener
+	backend            GraphBackend
+	host               string
+	idGenerator        IDGenerator
+	edgeMergePolicy    EdgeMergePolicy
+	schemaPolicy       SchemaPolicy
+	eventListeners     []GraphEventListener
+	listenerPriorities []ListenerPriority
+	dispatching        bool
+	pendingEvents      []pendingEvent
+	silent             bool
+	replicating        bool
+	history            *History
+	index              *MetadataIndex
 }
 
 type MetadataMatcher interface {
@@ -128,6 +197,24 @@ func GenID() Identifier {
 	return Identifier(u.String())
 }
 
+// GenID returns a free identifier for a new element having the given
+// metadata using the identifier generator of the graph.
+func (g *Graph) GenID(m Metadata) Identifier {
+	for attempt := 0; attempt < maxIDGenerationAttempts; attempt++ {
+		id := g.idGenerator.GenID(m, attempt)
+		if g.backend.GetNode(id) == nil && g.backend.GetEdge(id) == nil {
+			return id
+		}
+	}
+
+	logging.GetLogger().Warningf("Unable to generate a free identifier for %v, fallback to UUID", m)
+	return GenID()
+}
+
+func (g *Graph) SetIDGenerator(i IDGenerator) {
+	g.idGenerator = i
+}
+
 func (m *Metadata) String() string {
 	j, _ := json.Marshal(m)
 	return string(j)
@@ -141,6 +228,23 @@ func (e *graphElement) Metadata() Metadata {
 	return e.metadata
 }
 
+func (e *Edge) Parent() Identifier {
+	return e.parent
+}
+
+func (e *Edge) Child() Identifier {
+	return e.child
+}
+
+// Hidden returns whether the element is flagged as hidden. Hidden elements
+// are part of the graph but are not broadcasted to the clients.
+func (e *graphElement) Hidden() bool {
+	if h, ok := e.metadata["Hidden"].(bool); ok {
+		return h
+	}
+	return false
+}
+
 func (e *graphElement) matchMetadata(f Metadata) bool {
 	for k, v := range f {
 		switch v.(type) {
@@ -174,16 +278,39 @@ func (e *graphElement) String() string {
 	return string(j)
 }
 
+// nodeValue is the form of the nodes encoded in JSON and in the other
+// formats of the WebSocket messages
+type nodeValue struct {
+	ID       Identifier
+	Metadata Metadata `json:",omitempty"`
+	Host     string
+}
+
+// edgeValue is the encoded form of the edges
+type edgeValue struct {
+	ID       Identifier
+	Metadata Metadata `json:",omitempty"`
+	Parent   Identifier
+	Child    Identifier
+	Host     string
+}
+
+func (n *Node) value() *nodeValue {
+	return &nodeValue{ID: n.ID, Metadata: n.metadata, Host: n.host}
+}
+
 func (n *Node) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&struct {
-		ID       Identifier
-		Metadata Metadata `json:",omitempty"`
-		Host     string
-	}{
-		ID:       n.ID,
-		Metadata: n.metadata,
-		Host:     n.host,
-	})
+	return json.Marshal(n.value())
+}
+
+// wsMessage returns a message of the graph namespace carrying the node, its
+// value being a snapshot of the node as the metadata are replaced, not
+// modified, on update
+func (n *Node) wsMessage(t string) shttp.WSMessage {
+	v := n.value()
+	b, _ := json.Marshal(v)
+	raw := json.RawMessage(b)
+	return shttp.WSMessage{Namespace: Namespace, Type: t, Obj: &raw, Value: v}
 }
 
 func (n *Node) JsonRawMessage() *json.RawMessage {
@@ -208,20 +335,20 @@ func (n *Node) Decode(i interface{}) error {
 	return nil
 }
 
+func (e *Edge) value() *edgeValue {
+	return &edgeValue{ID: e.ID, Metadata: e.metadata, Parent: e.parent, Child: e.child, Host: e.host}
+}
+
 func (e *Edge) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&struct {
-		ID       Identifier
-		Metadata Metadata `json:",omitempty"`
-		Parent   Identifier
-		Child    Identifier
-		Host     string
-	}{
-		ID:       e.ID,
-		Metadata: e.metadata,
-		Parent:   e.parent,
-		Child:    e.child,
-		Host:     e.host,
-	})
+	return json.Marshal(e.value())
+}
+
+// wsMessage returns a message of the graph namespace carrying the edge
+func (e *Edge) wsMessage(t string) shttp.WSMessage {
+	v := e.value()
+	b, _ := json.Marshal(v)
+	raw := json.RawMessage(b)
+	return shttp.WSMessage{Namespace: Namespace, Type: t, Obj: &raw, Value: v}
 }
 
 func (e *Edge) JsonRawMessage() *json.RawMessage {
@@ -251,21 +378,74 @@ func (e *Edge) Decode(i interface{}) error {
 func (g *Graph) notifyMetadataUpdated(e interface{}) {
 	switch e.(type) {
 	case *Node:
+		if g.index != nil {
+			g.index.update(e.(*Node))
+		}
 		g.NotifyNodeUpdated(e.(*Node))
 	case *Edge:
 		g.NotifyEdgeUpdated(e.(*Edge))
 	}
 }
 
+func elementMetadata(e interface{}) Metadata {
+	switch e.(type) {
+	case *Node:
+		return e.(*Node).metadata
+	case *Edge:
+		return e.(*Edge).metadata
+	}
+	return nil
+}
+
+// SetMetadata replaces the metadata of the element, keeping its user
+// metadata
 func (g *Graph) SetMetadata(e interface{}, m Metadata) {
-	if !g.backend.SetMetadata(e, m) {
+	user, hasUser := elementMetadata(e)[UserMetadataKey]
+	if _, ok := m[UserMetadataKey]; ok || hasUser {
+		kept := Metadata{}
+		for k, v := range m {
+			if k != UserMetadataKey {
+				kept[k] = v
+			}
+		}
+		if hasUser {
+			kept[UserMetadataKey] = user
+		}
+		m = kept
+	}
+
+	if !g.validateMetadata(e, m) || !g.backend.SetMetadata(e, m) {
 		return
 	}
 	g.notifyMetadataUpdated(e)
 }
 
 func (g *Graph) AddMetadata(e interface{}, k string, v interface{}) {
-	if !g.backend.AddMetadata(e, k, v) {
+	if k == UserMetadataKey {
+		logging.GetLogger().Warningf("The %s metadata can only be set with SetUserMetadata", UserMetadataKey)
+		return
+	}
+
+	if !g.validateMetadata(e, Metadata{k: v}) || !g.backend.AddMetadata(e, k, v) {
+		return
+	}
+	g.notifyMetadataUpdated(e)
+}
+
+// SetUserMetadata replaces the user metadata of the element, removing them
+// when empty
+func (g *Graph) SetUserMetadata(e interface{}, m Metadata) {
+	updated := Metadata{}
+	for k, v := range elementMetadata(e) {
+		if k != UserMetadataKey {
+			updated[k] = v
+		}
+	}
+	if len(m) > 0 {
+		updated[UserMetadataKey] = map[string]interface{}(m)
+	}
+
+	if !g.backend.SetMetadata(e, updated) {
 		return
 	}
 	g.notifyMetadataUpdated(e)
@@ -285,18 +465,25 @@ func (t *MetadataTransaction) Commit() {
 		e = t.graphElement.(*Edge).graphElement
 	}
 
-	updated := false
+	changed := make(Metadata)
 	for k, v := range t.metadata {
+		if k == UserMetadataKey {
+			continue
+		}
 		if e.metadata[k] != v {
-			if !t.graph.backend.AddMetadata(t.graphElement, k, v) {
-				return
-			}
-			updated = true
+			changed[k] = v
 		}
 	}
-	if updated {
-		t.graph.notifyMetadataUpdated(t.graphElement)
+	if len(changed) == 0 || !t.graph.validateMetadata(t.graphElement, changed) {
+		return
+	}
+
+	for k, v := range changed {
+		if !t.graph.backend.AddMetadata(t.graphElement, k, v) {
+			return
+		}
 	}
+	t.graph.notifyMetadataUpdated(t.graphElement)
 }
 
 func (g *Graph) StartMetadataTransaction(i interface{}) *MetadataTransaction {
@@ -426,11 +613,31 @@ func (g *Graph) AreLinked(n1 *Node, n2 *Node) bool {
 	return false
 }
 
+// GetFirstLink returns the first edge between the two nodes, in any
+// direction, matching the metadata
+func (g *Graph) GetFirstLink(n1 *Node, n2 *Node, m Metadata) *Edge {
+	for _, e := range g.backend.GetNodeEdges(n1) {
+		if (e.child == n2.ID || e.parent == n2.ID) && e.matchMetadata(m) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (g *Graph) genEdgeID(p *Node, c *Node, m Metadata) Identifier {
+	im := Metadata{"Parent": p.ID, "Child": c.ID}
+	for k, v := range m {
+		im[k] = v
+	}
+	return g.GenID(im)
+}
+
 func (g *Graph) Link(n1 *Node, n2 *Node, m ...Metadata) {
 	if len(m) > 0 {
-		g.NewEdge(GenID(), n1, n2, m[0])
+		g.NewEdge(g.genEdgeID(n1, n2, m[0]), n1, n2, m[0])
 	} else {
-		g.NewEdge(GenID(), n1, n2, nil)
+		g.NewEdge(g.genEdgeID(n1, n2, nil), n1, n2, nil)
 	}
 }
 
@@ -463,7 +670,7 @@ func (g *Graph) Replace(o *Node, n *Node) *Node {
 		}
 	}
 	n.metadata = o.metadata
-	g.NotifyNodeUpdated(n)
+	g.notifyMetadataUpdated(n)
 
 	g.DelNode(o)
 
@@ -482,7 +689,12 @@ func (g *Graph) LookupFirstNode(m Metadata) *Node {
 func (g *Graph) LookupNodes(m Metadata) []*Node {
 	nodes := []*Node{}
 
-	for _, n := range g.backend.GetNodes() {
+	candidates, ok := g.index.lookup(m)
+	if !ok {
+		candidates = g.backend.GetNodes()
+	}
+
+	for _, n := range candidates {
 		if n.matchMetadata(m) {
 			nodes = append(nodes, n)
 		}
@@ -504,6 +716,32 @@ func (g *Graph) LookupNodesFromKey(key string) []*Node {
 	return nodes
 }
 
+// LookupEdges returns the edges matching the given metadata
+func (g *Graph) LookupEdges(m Metadata) []*Edge {
+	edges := []*Edge{}
+
+	for _, e := range g.backend.GetEdges() {
+		if e.matchMetadata(m) {
+			edges = append(edges, e)
+		}
+	}
+
+	return edges
+}
+
+// GetNodeEdges returns the edges of the node matching the given metadata
+func (g *Graph) GetNodeEdges(n *Node, m Metadata) []*Edge {
+	edges := []*Edge{}
+
+	for _, e := range g.backend.GetNodeEdges(n) {
+		if e.matchMetadata(m) {
+			edges = append(edges, e)
+		}
+	}
+
+	return edges
+}
+
 func (g *Graph) AddEdge(e *Edge) bool {
 	if !g.backend.AddEdge(e) {
 		return false
@@ -521,6 +759,9 @@ func (g *Graph) AddNode(n *Node) bool {
 	if !g.backend.AddNode(n) {
 		return false
 	}
+	if g.index != nil {
+		g.index.add(n)
+	}
 	g.NotifyNodeAdded(n)
 
 	return true
@@ -531,10 +772,16 @@ func (g *Graph) GetNode(i Identifier) *Node {
 }
 
 func (g *Graph) NewNode(i Identifier, m Metadata) *Node {
+	return g.NewNodeFromHost(i, m, g.host)
+}
+
+// NewNodeFromHost creates a node owned by the given host instead of the one
+// of the graph
+func (g *Graph) NewNodeFromHost(i Identifier, m Metadata, h string) *Node {
 	n := &Node{
 		graphElement: graphElement{
 			ID:   i,
-			host: g.host,
+			host: h,
 		},
 	}
 
@@ -552,12 +799,18 @@ func (g *Graph) NewNode(i Identifier, m Metadata) *Node {
 }
 
 func (g *Graph) NewEdge(i Identifier, p *Node, c *Node, m Metadata) *Edge {
+	return g.NewEdgeFromHost(i, p, c, m, g.host)
+}
+
+// NewEdgeFromHost creates an edge owned by the given host instead of the one
+// of the graph
+func (g *Graph) NewEdgeFromHost(i Identifier, p *Node, c *Node, m Metadata, h string) *Edge {
 	e := &Edge{
 		parent: p.ID,
 		child:  c.ID,
 		graphElement: graphElement{
 			ID:   i,
-			host: g.host,
+			host: h,
 		},
 	}
 
@@ -586,6 +839,9 @@ func (g *Graph) DelNode(n *Node) {
 	}
 
 	if g.backend.DelNode(n) {
+		if g.index != nil {
+			g.index.del(n)
+		}
 		g.NotifyNodeDeleted(n)
 	}
 }
@@ -620,10 +876,51 @@ func (g *Graph) GetEdges() []*Edge {
 	return g.backend.GetEdges()
 }
 
+// IndexStats returns the statistics of the indexes maintained by the
+// backend. The whole graph is scanned to check the consistency.
+func (g *Graph) IndexStats() []IndexStats {
+	stats := []IndexStats{}
+	if b, ok := g.backend.(IndexedBackend); ok {
+		stats = b.IndexStats()
+	}
+	if g.index != nil {
+		stats = append(stats, g.index.IndexStats(g.backend.GetNodes()))
+	}
+	return stats
+}
+
+// SetIndexedFields indexes the nodes by the given metadata fields, the
+// lookups filtering on one of them using the index. No index is maintained
+// when fields is empty.
+func (g *Graph) SetIndexedFields(fields []string) {
+	if len(fields) == 0 {
+		g.index = nil
+		return
+	}
+	g.index = NewMetadataIndex(fields, g.backend.GetNodes())
+}
+
 func (g *Graph) GetEdgeNodes(e *Edge) (*Node, *Node) {
 	return g.backend.GetEdgeNodes(e)
 }
 
+// IsEdgeHidden returns true if the edge or one of its nodes is hidden
+func (g *Graph) IsEdgeHidden(e *Edge) bool {
+	if e.Hidden() {
+		return true
+	}
+
+	if parent := g.backend.GetNode(e.parent); parent != nil && parent.Hidden() {
+		return true
+	}
+
+	if child := g.backend.GetNode(e.child); child != nil && child.Hidden() {
+		return true
+	}
+
+	return false
+}
+
 func (g *Graph) String() string {
 	j, _ := json.Marshal(g)
 	return string(j)
@@ -639,47 +936,136 @@ func (g *Graph) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func (g *Graph) NotifyNodeUpdated(n *Node) {
+// SetSilent flags the following mutations as silent, they are applied and
+// notified as usual except to the history recorders. Must be called with the
+// graph lock held.
+func (g *Graph) SetSilent(silent bool) {
+	g.silent = silent
+}
+
+func (g *Graph) IsSilent() bool {
+	return g.silent
+}
+
+// SetReplicating flags the following mutations as replicated from a peer,
+// they are not replicated again. Must be called with the graph lock held.
+func (g *Graph) SetReplicating(replicating bool) {
+	g.replicating = replicating
+}
+
+func (g *Graph) IsReplicating() bool {
+	return g.replicating
+}
+
+func (g *Graph) listeners() []GraphEventListener {
+	if !g.silent {
+		return g.eventListeners
+	}
+
+	listeners := []GraphEventListener{}
 	for _, l := range g.eventListeners {
-		l.OnNodeUpdated(n)
+		if _, ok := l.(HistoryRecorder); !ok && l != g.history {
+			listeners = append(listeners, l)
+		}
 	}
+	return listeners
 }
 
-func (g *Graph) NotifyNodeDeleted(n *Node) {
-	for _, l := range g.eventListeners {
-		l.OnNodeDeleted(n)
+// notify delivers an event to the listeners. The events raised by a listener
+// while being notified are queued and delivered once the current event has
+// been delivered to every listener, so that all of them get the events in the
+// same order.
+func (g *Graph) notify(kind pendingEventKind, e interface{}) {
+	ev := pendingEvent{kind: kind, element: e, listeners: g.listeners()}
+	if g.dispatching {
+		g.pendingEvents = append(g.pendingEvents, ev)
+		return
+	}
+
+	g.dispatching = true
+	defer func() {
+		g.dispatching = false
+		g.pendingEvents = nil
+	}()
+
+	ev.dispatch()
+	for len(g.pendingEvents) > 0 {
+		ev, g.pendingEvents = g.pendingEvents[0], g.pendingEvents[1:]
+		ev.dispatch()
 	}
 }
 
-func (g *Graph) NotifyNodeAdded(n *Node) {
-	for _, l := range g.eventListeners {
-		l.OnNodeAdded(n)
+func (ev pendingEvent) dispatch() {
+	for _, l := range ev.listeners {
+		switch ev.kind {
+		case nodeUpdatedEvent:
+			l.OnNodeUpdated(ev.element.(*Node))
+		case nodeAddedEvent:
+			l.OnNodeAdded(ev.element.(*Node))
+		case nodeDeletedEvent:
+			l.OnNodeDeleted(ev.element.(*Node))
+		case edgeUpdatedEvent:
+			l.OnEdgeUpdated(ev.element.(*Edge))
+		case edgeAddedEvent:
+			l.OnEdgeAdded(ev.element.(*Edge))
+		case edgeDeletedEvent:
+			l.OnEdgeDeleted(ev.element.(*Edge))
+		}
 	}
 }
 
+func (g *Graph) NotifyNodeUpdated(n *Node) {
+	g.notify(nodeUpdatedEvent, n)
+}
+
+func (g *Graph) NotifyNodeDeleted(n *Node) {
+	g.notify(nodeDeletedEvent, n)
+}
+
+func (g *Graph) NotifyNodeAdded(n *Node) {
+	g.notify(nodeAddedEvent, n)
+}
+
 func (g *Graph) NotifyEdgeUpdated(e *Edge) {
-	for _, l := range g.eventListeners {
-		l.OnEdgeUpdated(e)
-	}
+	g.notify(edgeUpdatedEvent, e)
 }
 
 func (g *Graph) NotifyEdgeDeleted(e *Edge) {
-	for _, l := range g.eventListeners {
-		l.OnEdgeDeleted(e)
-	}
+	g.notify(edgeDeletedEvent, e)
 }
 
 func (g *Graph) NotifyEdgeAdded(e *Edge) {
-	for _, l := range g.eventListeners {
-		l.OnEdgeAdded(e)
-	}
+	g.notify(edgeAddedEvent, e)
 }
 
+// AddEventListener registers a listener with the default priority.
 func (g *Graph) AddEventListener(l GraphEventListener) {
+	g.AddEventListenerWithPriority(l, DefaultListenerPriority)
+}
+
+// AddEventListenerWithPriority registers a listener notified before the
+// listeners having a higher priority value and after the ones of the same
+// priority registered before it. A listener mutating the graph while being
+// notified, typically a derivation one, doesn't interrupt the delivery of
+// the current event: the resulting events are delivered to every listener
+// after it, so that none of them gets an update of a node before its
+// addition.
+func (g *Graph) AddEventListenerWithPriority(l GraphEventListener, p ListenerPriority) {
 	g.Lock()
 	defer g.Unlock()
 
-	g.eventListeners = append(g.eventListeners, l)
+	i := len(g.listenerPriorities)
+	for i > 0 && g.listenerPriorities[i-1] > p {
+		i--
+	}
+
+	g.eventListeners = append(g.eventListeners, nil)
+	copy(g.eventListeners[i+1:], g.eventListeners[i:])
+	g.eventListeners[i] = l
+
+	g.listenerPriorities = append(g.listenerPriorities, 0)
+	copy(g.listenerPriorities[i+1:], g.listenerPriorities[i:])
+	g.listenerPriorities[i] = p
 }
 
 func (g *Graph) RemoveEventListener(l GraphEventListener) {
@@ -689,11 +1075,22 @@ func (g *Graph) RemoveEventListener(l GraphEventListener) {
 	for i, el := range g.eventListeners {
 		if l == el {
 			g.eventListeners = append(g.eventListeners[:i], g.eventListeners[i+1:]...)
+			g.listenerPriorities = append(g.listenerPriorities[:i], g.listenerPriorities[i+1:]...)
 			break
 		}
 	}
 }
 
+// ListenerPriorityFromConfig returns the priority of a listener configured
+// in the graph.listener_priorities section, or the given default one.
+func ListenerPriorityFromConfig(name string, p ListenerPriority) ListenerPriority {
+	key := "graph.listener_priorities." + name
+	if config.GetConfig().IsSet(key) {
+		return ListenerPriority(config.GetConfig().GetInt(key))
+	}
+	return p
+}
+
 func NewGraph(b GraphBackend) (*Graph, error) {
 	h, err := os.Hostname()
 	if err != nil {
@@ -701,11 +1098,46 @@ func NewGraph(b GraphBackend) (*Graph, error) {
 	}
 
 	return &Graph{
-		backend: b,
-		host:    h,
+		backend:     b,
+		host:        h,
+		idGenerator: &UUIDGenerator{},
 	}, nil
 }
 
+func NewGraphFromConfig(b GraphBackend) (*Graph, error) {
+	g, err := NewGraph(b)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := IDGeneratorFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	g.SetIDGenerator(i)
+
+	p, err := EdgeMergePolicyFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	g.SetEdgeMergePolicy(p)
+
+	sp, err := SchemaPolicyFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	g.SetSchemaPolicy(sp)
+
+	g.SetIndexedFields(config.GetConfig().GetStringSlice("graph.indexes"))
+
+	if config.GetConfig().GetBool("graph.history.enabled") {
+		retention := time.Duration(config.GetConfig().GetInt("graph.history.retention")) * time.Second
+		g.EnableHistory(retention, config.GetConfig().GetInt("graph.history.max_revisions"))
+	}
+
+	return g, nil
+}
+
 func BackendFromConfig() (GraphBackend, error) {
 	backend := config.GetConfig().GetString("graph.backend")
 	if len(backend) == 0 {
@@ -721,6 +1153,8 @@ func BackendFromConfig() (GraphBackend, error) {
 	case "titangraph":
 		endpoint := config.GetConfig().GetString("graph.gremlin")
 		return NewTitangraphBackend(endpoint)
+	case "bolt":
+		return NewBoltBackend(config.GetConfig().GetString("graph.bolt.path"))
 	default:
 		return nil, errors.New("Config file is misconfigured, graph backend unknown: " + backend)
 	}
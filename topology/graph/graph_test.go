@@ -23,12 +23,24 @@
 package graph
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/ugorji/go/codec"
 )
 
-func newGraph(t *testing.T) *Graph {
+func newGraph(t testing.TB) *Graph {
 	b, err := NewMemoryBackend()
 	if err != nil {
 		t.Error(err.Error())
@@ -270,6 +282,29 @@ func TestMetadata(t *testing.T) {
 	}
 }
 
+func TestUserMetadata(t *testing.T) {
+	g := newGraph(t)
+
+	n := g.NewNode(GenID(), Metadata{"Type": "intf"})
+
+	g.SetUserMetadata(n, Metadata{"Ticket": "BZ-42"})
+	g.SetMetadata(n, Metadata{"Type": "intf", "MTU": 1500})
+	g.AddMetadata(n, UserMetadataKey, "overwritten")
+
+	user, ok := n.Metadata()[UserMetadataKey].(map[string]interface{})
+	if !ok || user["Ticket"] != "BZ-42" {
+		t.Errorf("User metadata not kept: %v", n.Metadata())
+	}
+	if n.Metadata()["MTU"] != 1500 {
+		t.Error("Metadata not updated")
+	}
+
+	g.SetUserMetadata(n, nil)
+	if _, ok := n.Metadata()[UserMetadataKey]; ok || n.Metadata()["MTU"] != 1500 {
+		t.Errorf("User metadata not removed: %v", n.Metadata())
+	}
+}
+
 type FakeListener struct {
 	lastNodeUpdated *Node
 	lastNodeAdded   *Node
@@ -339,3 +374,1100 @@ func TestEvents(t *testing.T) {
 		t.Error("Didn't get the notification")
 	}
 }
+
+func TestHiddenElements(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1, "Type": "intf"})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2, "Type": "probe", "Hidden": true})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3, "Type": "intf"})
+
+	e1 := g.NewEdge(GenID(), n1, n2, nil)
+	e2 := g.NewEdge(GenID(), n1, n3, nil)
+	e3 := g.NewEdge(GenID(), n3, n1, Metadata{"Hidden": true})
+
+	if n1.Hidden() || !n2.Hidden() {
+		t.Error("Wrong hidden flag returned")
+	}
+
+	if !g.IsEdgeHidden(e1) {
+		t.Error("Edge linked to a hidden node should be hidden")
+	}
+
+	if g.IsEdgeHidden(e2) {
+		t.Error("Edge shouldn't be hidden")
+	}
+
+	if !g.IsEdgeHidden(e3) {
+		t.Error("Edge should be hidden")
+	}
+
+	// hidden nodes are still visible from the lookups and traversals
+	if len(g.LookupChildren(n1, Metadata{"Type": "probe"})) != 1 {
+		t.Error("Hidden node should be returned by lookups")
+	}
+
+	// but not from the visible view of the graph
+	v := g.Visible()
+	if len(v.GetNodes()) != 2 || len(v.GetEdges()) != 1 || v.GetNode(n2.ID) != nil || v.GetEdge(e1.ID) != nil {
+		t.Errorf("Hidden elements returned by the visible view: %s", v.String())
+	}
+
+	if len(v.LookupChildren(n1, Metadata{})) != 1 || len(v.GetNodeEdges(n1, nil)) != 1 {
+		t.Error("Hidden elements returned by the lookups of the visible view")
+	}
+
+	if v.AddNode(&Node{graphElement: graphElement{ID: GenID()}}) {
+		t.Error("Visible view shouldn't be modifiable")
+	}
+}
+
+func TestHiddenTransitions(t *testing.T) {
+	g := newGraph(t)
+
+	// the broadcasted messages are kept in the batch
+	s := &GraphServer{
+		Graph:       g,
+		ChangeRates: NewChangeRateTracker(),
+		queries:     newContinuousQueries(g),
+		revisions:   newRevisionTracker(),
+		hidden:      make(map[Identifier]bool),
+		batch:       []batchedMessage{},
+	}
+	g.AddEventListener(s)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	e := g.NewEdge(GenID(), n1, n2, Metadata{"Name": "e"})
+
+	received := func(withHidden bool) string {
+		var msgs []string
+		for _, m := range s.batch {
+			if !m.event.visibleTo(withHidden) {
+				continue
+			}
+
+			var obj struct{ Metadata Metadata }
+			if err := json.Unmarshal([]byte(*m.message.Obj), &obj); err != nil {
+				t.Fatal(err)
+			}
+			msgs = append(msgs, m.message.Type+" "+obj.Metadata["Name"].(string))
+		}
+		return strings.Join(msgs, ",")
+	}
+
+	check := func(visible, hidden string) {
+		if msgs := received(false); msgs != visible {
+			t.Errorf("Wrong messages sent to the clients not getting the hidden elements: %s", msgs)
+		}
+		if msgs := received(true); msgs != hidden {
+			t.Errorf("Wrong messages sent to the clients getting the hidden elements: %s", msgs)
+		}
+		s.batch = []batchedMessage{}
+	}
+
+	check("NodeAdded n1,NodeAdded n2,EdgeAdded e", "NodeAdded n1,NodeAdded n2,EdgeAdded e")
+
+	g.AddMetadata(n2, "Hidden", true)
+	check("EdgeDeleted e,NodeDeleted n2", "NodeUpdated n2")
+
+	g.AddMetadata(n2, "Value", 1)
+	check("", "NodeUpdated n2")
+
+	g.AddMetadata(n2, "Hidden", false)
+	check("NodeAdded n2,EdgeAdded e,NodeUpdated n2", "NodeUpdated n2")
+
+	g.AddMetadata(e, "Hidden", true)
+	check("EdgeDeleted e", "EdgeUpdated e")
+
+	g.AddMetadata(e, "Hidden", false)
+	check("EdgeAdded e,EdgeUpdated e", "EdgeUpdated e")
+}
+
+func TestChangeRate(t *testing.T) {
+	c := NewChangeRateTracker()
+
+	now := time.Now()
+	for i := 0; i != 100; i++ {
+		c.Update("node1", now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	c.Update("node2", now)
+
+	now = now.Add(time.Second)
+	if r := c.Rate("node1", now); r < 5 {
+		t.Errorf("Rate of a node updated 100 times in a second should be high: %f", r)
+	}
+
+	if r := c.Rate("node2", now); r > 1 {
+		t.Errorf("Rate of a node updated once should be low: %f", r)
+	}
+
+	if r := c.Rate("node1", now.Add(time.Minute)); r > 1 {
+		t.Errorf("Rate should have decayed: %f", r)
+	}
+
+	c.Delete("node1")
+	if r := c.Rate("node1", now); r != 0 {
+		t.Errorf("Rate of a deleted node should be 0: %f", r)
+	}
+}
+
+func TestLazyMetadata(t *testing.T) {
+	g := newGraph(t)
+
+	large := strings.Repeat("x", 1024)
+	n := g.NewNode(GenID(), Metadata{"Name": "small", "Large": large})
+
+	l := lazyNode(n, 100)
+	if l.metadata["Name"] != "small" {
+		t.Errorf("Small value should be kept: %v", l.metadata)
+	}
+
+	ref, ok := l.metadata["Large"].(map[string]interface{})["MetadataValueRef"].(*MetadataValueRef)
+	if !ok || ref.ID != n.ID || ref.Key != "Large" {
+		t.Fatalf("Large value should be replaced by a reference: %v", l.metadata)
+	}
+
+	if n.metadata["Large"] != large {
+		t.Error("Node metadata should not be modified")
+	}
+
+	if v, ok := g.lookupMetadataValue(ref.ID, ref.Key); !ok || v != large {
+		t.Error("Large value should be fetchable from the reference")
+	}
+
+	if lazyNode(n, 0) != n || lazyNode(n, 2048) != n {
+		t.Error("Node should not be copied when no value is replaced")
+	}
+}
+
+type FakeHistoryRecorder struct {
+	FakeListener
+}
+
+func (c *FakeHistoryRecorder) RecordHistory() {
+}
+
+func TestSilentMutation(t *testing.T) {
+	g := newGraph(t)
+
+	l := &FakeListener{}
+	g.AddEventListener(l)
+
+	r := &FakeHistoryRecorder{}
+	g.AddEventListener(r)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	if r.lastNodeAdded == nil || r.lastNodeAdded.ID != n1.ID {
+		t.Error("History recorder should be notified")
+	}
+
+	g.SetSilent(true)
+	g.AddMetadata(n1, "Position", 1)
+	g.SetSilent(false)
+
+	if l.lastNodeUpdated == nil || l.lastNodeUpdated.ID != n1.ID {
+		t.Error("Listener should be notified of a silent mutation")
+	}
+	if r.lastNodeUpdated != nil {
+		t.Error("History recorder should not be notified of a silent mutation")
+	}
+
+	g.AddMetadata(n1, "Position", 2)
+	if r.lastNodeUpdated == nil || r.lastNodeUpdated.ID != n1.ID {
+		t.Error("History recorder should be notified")
+	}
+}
+
+func TestMergeNodes(t *testing.T) {
+	for _, policy := range []EdgeMergePolicy{DedupeEdgesByRelation, KeepBothEdges, MergeEdgesMetadata} {
+		g := newGraph(t)
+		g.SetEdgeMergePolicy(policy)
+
+		n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+		n2 := g.NewNode(GenID(), Metadata{"Name": "n2", "MAC": "aa:bb"})
+		peer := g.NewNode(GenID(), Metadata{"Name": "peer"})
+
+		g.NewEdge(GenID(), n1, peer, Metadata{"RelationType": "layer2", "MTU": 1500})
+		g.NewEdge(GenID(), n2, peer, Metadata{"RelationType": "layer2", "Speed": 1000})
+		g.NewEdge(GenID(), n2, peer, Metadata{"RelationType": "ownership"})
+		g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+
+		g.MergeNodes(n1, n2)
+
+		if g.GetNode(n2.ID) != nil {
+			t.Fatal("Merged node should be removed")
+		}
+
+		if n1.metadata["Name"] != "n1" || n1.metadata["MAC"] != "aa:bb" {
+			t.Errorf("Metadata not merged: %v", n1.metadata)
+		}
+
+		edges := g.GetEdges()
+		layer2 := 0
+		for _, e := range edges {
+			if e.parent != n1.ID || e.child != peer.ID {
+				t.Errorf("Edge not reattached: %v", e)
+			}
+			if e.metadata["RelationType"] == "layer2" {
+				layer2++
+			}
+		}
+
+		switch policy {
+		case KeepBothEdges:
+			if layer2 != 2 || len(edges) != 3 {
+				t.Errorf("Parallel edges should be kept: %v", edges)
+			}
+		case DedupeEdgesByRelation:
+			if layer2 != 1 || len(edges) != 2 {
+				t.Errorf("Parallel edges should be removed: %v", edges)
+			}
+		case MergeEdgesMetadata:
+			if layer2 != 1 || len(edges) != 2 {
+				t.Fatalf("Parallel edges should be removed: %v", edges)
+			}
+			for _, e := range edges {
+				if e.metadata["RelationType"] == "layer2" && (e.metadata["MTU"] != 1500 || e.metadata["Speed"] != 1000) {
+					t.Errorf("Edge metadata not merged: %v", e.metadata)
+				}
+			}
+		}
+	}
+}
+
+func TestCloudEventsSink(t *testing.T) {
+	received := make(chan []CloudEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []CloudEvent
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			t.Error(err.Error())
+		}
+		received <- events
+	}))
+	defer server.Close()
+
+	g := newGraph(t)
+
+	sink := NewCloudEventsSink(g, server.URL, "skydive://test/analyzer")
+	sink.Start()
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	e := g.NewEdge(GenID(), n1, n2, nil)
+	g.DelEdge(e)
+
+	sink.Stop()
+
+	events := []CloudEvent{}
+	for len(received) > 0 {
+		events = append(events, <-received...)
+	}
+
+	expected := []struct {
+		t       string
+		subject Identifier
+	}{
+		{"skydive.graph.node.added", n1.ID},
+		{"skydive.graph.node.added", n2.ID},
+		{"skydive.graph.edge.added", e.ID},
+		{"skydive.graph.edge.deleted", e.ID},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Should receive %d events, got: %v", len(expected), events)
+	}
+
+	for i, ev := range events {
+		if ev.SpecVersion != "1.0" || ev.Source != "skydive://test/analyzer" || ev.Data == nil {
+			t.Errorf("Wrong event envelope: %v", ev)
+		}
+		if ev.Type != expected[i].t || ev.Subject != string(expected[i].subject) {
+			t.Errorf("Expected %s event of %s, got: %v", expected[i].t, expected[i].subject, ev)
+		}
+	}
+}
+
+func TestFork(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "switch"})
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	g.Link(n1, n2)
+	g.Link(n2, n3)
+
+	fork, err := g.Fork()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fork.DelNode(fork.GetNode(n2.ID))
+	fork.AddMetadata(fork.GetNode(n1.ID), "State", "DOWN")
+
+	if len(g.GetNodes()) != 3 || len(g.GetEdges()) != 2 {
+		t.Error("Graph should not be modified by the fork")
+	}
+	if _, ok := n1.Metadata()["State"]; ok {
+		t.Error("Graph metadata should not be modified by the fork")
+	}
+
+	if len(fork.GetNodes()) != 2 || len(fork.GetEdges()) != 0 {
+		t.Error("Switch should be removed from the fork")
+	}
+	if len(fork.LookupShortestPath(fork.GetNode(n1.ID), Metadata{"Name": "n3"})) != 0 {
+		t.Error("n3 should not be reachable in the fork")
+	}
+
+	g.AddMetadata(n3, "State", "UP")
+	if _, ok := fork.GetNode(n3.ID).Metadata()["State"]; ok {
+		t.Error("Fork metadata should not be modified by the graph")
+	}
+}
+
+type orderedListener struct {
+	DefaultGraphListener
+	name  string
+	order *[]string
+}
+
+func (l *orderedListener) OnNodeAdded(n *Node) {
+	*l.order = append(*l.order, l.name)
+}
+
+func TestListenerPriority(t *testing.T) {
+	g := newGraph(t)
+
+	order := []string{}
+	export := &orderedListener{name: "export", order: &order}
+	broadcast1 := &orderedListener{name: "broadcast1", order: &order}
+	broadcast2 := &orderedListener{name: "broadcast2", order: &order}
+	derivation := &orderedListener{name: "derivation", order: &order}
+
+	g.AddEventListenerWithPriority(export, ExportListenerPriority)
+	g.AddEventListener(broadcast1)
+	g.AddEventListenerWithPriority(derivation, DerivationListenerPriority)
+	g.AddEventListener(broadcast2)
+
+	g.NewNode(GenID(), Metadata{"Name": "n1"})
+
+	if strings.Join(order, ",") != "derivation,broadcast1,broadcast2,export" {
+		t.Errorf("Wrong notification order: %v", order)
+	}
+
+	g.RemoveEventListener(broadcast1)
+	order = order[:0]
+	g.NewNode(GenID(), Metadata{"Name": "n2"})
+
+	if strings.Join(order, ",") != "derivation,broadcast2,export" {
+		t.Errorf("Wrong notification order: %v", order)
+	}
+}
+
+type eventListener struct {
+	DefaultGraphListener
+	events *[]string
+}
+
+func (l *eventListener) OnNodeAdded(n *Node) {
+	*l.events = append(*l.events, "NodeAdded "+n.Metadata()["Name"].(string))
+}
+
+func (l *eventListener) OnNodeUpdated(n *Node) {
+	*l.events = append(*l.events, "NodeUpdated "+n.Metadata()["Name"].(string))
+}
+
+type annotatingListener struct {
+	DefaultGraphListener
+	graph *Graph
+}
+
+func (l *annotatingListener) OnNodeAdded(n *Node) {
+	l.graph.SetUserMetadata(n, Metadata{"Owner": "derivation"})
+}
+
+func TestDerivationListenerEvents(t *testing.T) {
+	g := newGraph(t)
+
+	events := []string{}
+	g.AddEventListener(&eventListener{events: &events})
+	g.AddEventListenerWithPriority(&annotatingListener{graph: g}, DerivationListenerPriority)
+
+	g.NewNode(GenID(), Metadata{"Name": "n1"})
+
+	if strings.Join(events, ",") != "NodeAdded n1,NodeUpdated n1" {
+		t.Errorf("Wrong events order: %v", events)
+	}
+}
+
+func TestStatsDownsampler(t *testing.T) {
+	d := NewStatsDownsampler(time.Minute, map[string]time.Duration{"rxbytes": 10 * time.Second})
+
+	start := time.Unix(1000020, 0)
+	for i, v := range []float64{10, 30, 20} {
+		if s := d.Add("e1", "RxBytes", v, start.Add(time.Duration(i)*time.Second)); s != nil {
+			t.Fatalf("Bucket should not be closed: %v", s)
+		}
+		if s := d.Add("e1", "TxBytes", v, start.Add(time.Duration(i)*time.Second)); s != nil {
+			t.Fatalf("Bucket should not be closed: %v", s)
+		}
+	}
+
+	s := d.Add("e1", "RxBytes", 5, start.Add(10*time.Second))
+	if s == nil {
+		t.Fatal("Bucket should be closed")
+	}
+	if s.Start != 1000020 || s.End != 1000030 || s.Min != 10 || s.Max != 30 || s.Avg != 20 || s.Count != 3 {
+		t.Errorf("Wrong summary: %+v", s)
+	}
+
+	// default bucket size of one minute
+	if s := d.Add("e1", "TxBytes", 5, start.Add(10*time.Second)); s != nil {
+		t.Errorf("Bucket should not be closed: %v", s)
+	}
+
+	d.Delete("e1")
+	if s := d.Add("e1", "RxBytes", 5, start.Add(time.Minute)); s != nil {
+		t.Errorf("Buckets should be deleted: %v", s)
+	}
+}
+
+func TestHistoryAt(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	g.EnableHistory(0, 0)
+
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	g.Link(n1, n2)
+
+	time.Sleep(10 * time.Millisecond)
+	t1 := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	g.AddMetadata(n1, "State", "DOWN")
+	g.DelNode(n2)
+
+	past, err := g.At(t1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(past.GetNodes()) != 2 || len(past.GetEdges()) != 1 {
+		t.Fatalf("Wrong graph at %s: %v %v", t1, past.GetNodes(), past.GetEdges())
+	}
+
+	if _, ok := past.GetNode(n1.ID).metadata["State"]; ok {
+		t.Error("Metadata added after the time shouldn't be there")
+	}
+
+	if past.NewNode(GenID(), nil) != nil || len(past.GetNodes()) != 2 {
+		t.Error("Graph from history should be read-only")
+	}
+
+	now, _ := g.At(time.Now())
+	if len(now.GetNodes()) != 1 || now.GetNode(n1.ID).metadata["State"] != "DOWN" {
+		t.Fatalf("Wrong current graph: %v", now.GetNodes())
+	}
+
+	if _, err := g.At(t1.Add(-time.Hour)); err != ErrHistoryUnavailable {
+		t.Error("History shouldn't be available before it was enabled")
+	}
+}
+
+func TestHistoryMaxRevisions(t *testing.T) {
+	g := newGraph(t)
+	g.EnableHistory(0, 2)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	time.Sleep(10 * time.Millisecond)
+	t1 := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		g.AddMetadata(n1, "Value", i)
+	}
+
+	// silent mutations are not recorded
+	g.SetSilent(true)
+	g.AddMetadata(n1, "Position", 1)
+	g.SetSilent(false)
+
+	if len(g.history.revisions) != 2 {
+		t.Errorf("Only 2 revisions should be kept: %d", len(g.history.revisions))
+	}
+
+	if _, err := g.At(t1); err != ErrHistoryUnavailable {
+		t.Error("History shouldn't be available before the revisions kept")
+	}
+
+	now, _ := g.At(time.Now())
+	if m := now.GetNode(n1.ID).metadata; m["Value"] != 2 || m["Position"] != nil {
+		t.Errorf("Wrong current metadata: %v", m)
+	}
+}
+
+func TestMetadataIndex(t *testing.T) {
+	g := newGraph(t)
+	g.SetIndexedFields([]string{"Type", "MAC"})
+
+	n1 := g.NewNode(GenID(), Metadata{"Type": "veth", "MAC": "00:00:00:00:00:01", "MTU": 1500})
+	n2 := g.NewNode(GenID(), Metadata{"Type": "veth", "MTU": int64(1500)})
+	g.NewNode(GenID(), Metadata{"Type": "bridge"})
+
+	if r := g.LookupNodes(Metadata{"Type": "veth"}); len(r) != 2 {
+		t.Errorf("Wrong number of nodes returned: %v", r)
+	}
+
+	if r := g.LookupNodes(Metadata{"Type": "veth", "MTU": 1500}); len(r) != 2 {
+		t.Errorf("Wrong number of nodes returned: %v", r)
+	}
+
+	if n := g.LookupFirstNode(Metadata{"MAC": "00:00:00:00:00:01"}); n == nil || n.ID != n1.ID {
+		t.Errorf("Wrong node returned: %v", n)
+	}
+
+	// metadata modified in place before being set
+	m := n1.Metadata()
+	m["MAC"] = "00:00:00:00:00:02"
+	g.SetMetadata(n1, m)
+
+	if n := g.LookupFirstNode(Metadata{"MAC": "00:00:00:00:00:01"}); n != nil {
+		t.Errorf("Node shouldn't be found by its previous MAC: %v", n)
+	}
+
+	g.AddMetadata(n2, "MAC", "00:00:00:00:00:03")
+	if n := g.LookupFirstNode(Metadata{"MAC": "00:00:00:00:00:03"}); n == nil || n.ID != n2.ID {
+		t.Errorf("Wrong node returned: %v", n)
+	}
+
+	g.DelNode(n2)
+	if r := g.LookupNodes(Metadata{"Type": "veth"}); len(r) != 1 || r[0].ID != n1.ID {
+		t.Errorf("Wrong nodes returned: %v", r)
+	}
+
+	for _, stats := range g.IndexStats() {
+		if !stats.Consistent {
+			t.Errorf("Index %s not consistent: %v", stats.Name, stats.Errors)
+		}
+	}
+
+	fork, err := g.Fork()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if n := fork.LookupFirstNode(Metadata{"MAC": "00:00:00:00:00:02"}); n == nil || n.ID != n1.ID {
+		t.Errorf("Wrong node returned by the fork: %v", n)
+	}
+}
+
+type revisionListener struct {
+	DefaultGraphListener
+	r *revisionTracker
+}
+
+func (l *revisionListener) OnNodeAdded(n *Node)   { l.r.update(n.ID, n.host) }
+func (l *revisionListener) OnNodeUpdated(n *Node) { l.r.update(n.ID, n.host) }
+func (l *revisionListener) OnNodeDeleted(n *Node) { l.r.delete(n.ID, n.host, false) }
+func (l *revisionListener) OnEdgeAdded(e *Edge)   { l.r.update(e.ID, e.host) }
+func (l *revisionListener) OnEdgeDeleted(e *Edge) { l.r.delete(e.ID, e.host, true) }
+
+func TestSyncChunks(t *testing.T) {
+	g := newGraph(t)
+
+	r := newRevisionTracker()
+	r.maxTombstones = 2
+	g.AddEventListener(&revisionListener{r: r})
+
+	acceptAll := func(interface{}) bool { return true }
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	g.Link(n1, n2)
+
+	chunks := r.sync(g, nil, 2, acceptAll, 0)
+	if len(chunks) != 2 || chunks[0].Delta || !chunks[1].Last || chunks[1].Revisions == nil {
+		t.Fatalf("Wrong full sync chunks: %+v", chunks)
+	}
+	if len(chunks[0].Nodes) != 2 || len(chunks[1].Nodes) != 1 || len(chunks[1].Edges) != 1 {
+		t.Errorf("Wrong elements in the chunks: %+v %+v", chunks[0], chunks[1])
+	}
+	revisions := chunks[1].Revisions
+
+	g.AddMetadata(n1, "State", "UP")
+	g.DelNode(n3)
+
+	chunks = r.sync(g, revisions, 0, acceptAll, 0)
+	if len(chunks) != 1 || !chunks[0].Delta {
+		t.Fatalf("Wrong delta sync chunks: %+v", chunks)
+	}
+	if len(chunks[0].Nodes) != 1 || chunks[0].Nodes[0].ID != n1.ID || len(chunks[0].Edges) != 0 {
+		t.Errorf("Only the updated node expected: %+v", chunks[0])
+	}
+	if len(chunks[0].DeletedNodes) != 1 || chunks[0].DeletedNodes[0] != n3.ID {
+		t.Errorf("Deleted node expected: %+v", chunks[0])
+	}
+
+	// the elements leaving the view of the client are deleted
+	since := chunks[0].Revisions
+	g.AddMetadata(n1, "Hidden", true)
+	acceptVisible := func(i interface{}) bool {
+		n, ok := i.(*Node)
+		return !ok || !n.Hidden()
+	}
+	chunks = r.sync(g, since, 0, acceptVisible, 0)
+	if len(chunks[0].Nodes) != 0 || len(chunks[0].DeletedNodes) != 1 || chunks[0].DeletedNodes[0] != n1.ID {
+		t.Errorf("Hidden node deletion expected: %+v", chunks[0])
+	}
+
+	// tombstones dropped, a full sync is required
+	g.DelNode(n2)
+	if chunks = r.sync(g, revisions, 0, acceptAll, 0); chunks[0].Delta {
+		t.Errorf("Delta sync not expected: %+v", chunks)
+	}
+
+	if chunks = r.sync(g, &SyncRevisions{Epoch: "other"}, 0, acceptAll, 0); chunks[0].Delta {
+		t.Errorf("Delta sync not expected from another epoch: %+v", chunks)
+	}
+}
+
+func TestPeerSync(t *testing.T) {
+	local := newGraph(t)
+
+	r := &Replicator{Client: &shttp.WSAsyncClient{}, Graph: local, revisions: newRevisionTracker()}
+	r.cond = sync.NewCond(&r.lock)
+	local.AddEventListener(r)
+
+	n1 := local.NewNodeFromHost(GenID(), Metadata{"Name": "n1", "State": "UP"}, "h1")
+	n2 := local.NewNodeFromHost(GenID(), Metadata{"Name": "n2"}, "h1")
+	e := local.NewEdgeFromHost(GenID(), n1, n2, nil, "h1")
+
+	// replicated from the peer, not sent back
+	local.SetReplicating(true)
+	local.NewNodeFromHost(GenID(), Metadata{"Name": "n3"}, "h2")
+	local.SetReplicating(false)
+
+	peer := newGraph(t)
+	s := &GraphServer{
+		Graph:       peer,
+		ChangeRates: NewChangeRateTracker(),
+		queries:     newContinuousQueries(peer),
+		revisions:   newRevisionTracker(),
+		hidden:      make(map[Identifier]bool),
+		peerSyncs:   make(map[*shttp.WSClient]map[Identifier]bool),
+		batch:       []batchedMessage{},
+	}
+	peer.AddEventListener(s)
+
+	// state of the peer before a partition
+	peer.NewNodeFromHost(n1.ID, Metadata{"Name": "n1", "State": "DOWN"}, "h1")
+	stale := peer.NewNodeFromHost(GenID(), Metadata{"Name": "n4"}, "h1")
+	other := peer.NewNodeFromHost(GenID(), Metadata{"Name": "n5"}, "h2")
+
+	r.messages = nil
+	r.OnConnected()
+	for _, msg := range r.messages {
+		if msg.Type != "SyncChunk" || !s.applyPeerSyncChunk(nil, msg) {
+			t.Fatalf("Wrong sync message: %+v", msg)
+		}
+	}
+
+	if n := peer.GetNode(n1.ID); n == nil || n.Metadata()["State"] != "UP" {
+		t.Errorf("Node not updated: %v", n)
+	}
+	if peer.GetNode(n2.ID) == nil || peer.GetEdge(e.ID) == nil {
+		t.Error("Missing elements not added")
+	}
+	if peer.GetNode(stale.ID) != nil {
+		t.Error("Element deleted during the partition not deleted")
+	}
+	if peer.GetNode(other.ID) == nil {
+		t.Error("Element of another host deleted")
+	}
+	if len(peer.GetNodes()) != 3 {
+		t.Errorf("Replicated elements sent back: %v", peer.GetNodes())
+	}
+	if len(s.peerSyncs) != 0 {
+		t.Error("Sync state not released")
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(Identifier("N1"), Metadata{"Name": "br-int", "Type": "ovsbridge"})
+	n2 := g.NewNode(Identifier("N2"), Metadata{"Name": "eth\"0", "Type": "device", "MTU": 1500})
+	n3 := g.NewNode(Identifier("N3"), Metadata{"Name": "lo", "Type": "device"})
+	g.NewEdge(Identifier("E1"), n1, n2, Metadata{"RelationType": "ownership"})
+	g.NewEdge(Identifier("E2"), n1, n3, nil)
+
+	nodes, edges := g.SubGraph([]interface{}{n1, n2})
+	if len(nodes) != 2 || len(edges) != 1 || edges[0].ID != "E1" {
+		t.Fatalf("Wrong sub graph returned: %v, %v", nodes, edges)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportGraphML(&buf, nodes, edges); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid GraphML: %s", err)
+	}
+	if len(doc.Graph.Nodes) != 2 || doc.Graph.Nodes[1].ID != "N2" || len(doc.Graph.Edges) != 1 || doc.Graph.Edges[0].Target != "N2" {
+		t.Errorf("Wrong GraphML document: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := ExportDOT(&buf, nodes, edges); err != nil {
+		t.Fatal(err)
+	}
+
+	dot := buf.String()
+	for _, s := range []string{`"N2" [label="eth\"0\ndevice"`, `"MTU"="1500"`, `"N1" -> "N2" [label="ownership"`} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT output doesn't contain %s: %s", s, dot)
+		}
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	n1.host, n2.host = "agent1", "agent1"
+	e := g.NewEdge(GenID(), n1, n2, nil)
+	e.host = "agent1"
+	g.Link(n2, n3)
+
+	d, err := newDisconnectTracker(g, DisconnectDelete, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Lock()
+	d.disconnected("agent1")
+	g.Unlock()
+
+	if _, ok := n1.Metadata()["StaleSince"]; !ok {
+		t.Error("The nodes of the disconnected agent should be flagged as stale")
+	}
+	if _, ok := n3.Metadata()["StaleSince"]; ok {
+		t.Error("The nodes of the other hosts shouldn't be flagged as stale")
+	}
+
+	g.Lock()
+	d.reconnected("agent1")
+	g.Unlock()
+
+	if _, ok := n1.Metadata()["StaleSince"]; ok {
+		t.Error("The stale flag should be cleared when the agent is back")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if g.GetNode(n1.ID) == nil {
+		t.Fatal("The nodes of a reconnected agent shouldn't be deleted")
+	}
+
+	g.Lock()
+	d.disconnected("agent1")
+	g.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	g.RLock()
+	defer g.RUnlock()
+
+	if g.GetNode(n1.ID) != nil || g.GetNode(n2.ID) != nil {
+		t.Error("The nodes of the agent should be deleted after the grace period")
+	}
+	if g.GetNode(n3.ID) == nil {
+		t.Error("The nodes of the other hosts shouldn't be deleted")
+	}
+
+	if _, err := newDisconnectTracker(g, "drop", time.Second); err == nil {
+		t.Error("Unknown policies should be refused")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1", "MTU": 1500})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	e := g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+
+	var buffer bytes.Buffer
+	if err := g.Snapshot(&buffer); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	g.DelNode(n2)
+	g.NewNode(GenID(), Metadata{"Name": "n3"})
+
+	if err := g.Restore(&buffer); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(g.GetNodes()) != 2 || len(g.GetEdges()) != 1 {
+		t.Fatalf("Snapshot not restored: %s", g.String())
+	}
+	if n := g.GetNode(n1.ID); n == nil || n.Metadata()["MTU"] != float64(1500) {
+		t.Errorf("Node not restored with its metadata: %v", n)
+	}
+	if r := g.GetEdge(e.ID); r == nil || r.Parent() != n1.ID || r.Child() != n2.ID {
+		t.Errorf("Edge not restored: %v", r)
+	}
+	if len(g.LookupNodes(Metadata{"Name": "n3"})) != 0 {
+		t.Error("Nodes not part of the snapshot should be removed")
+	}
+
+	if err := g.Restore(strings.NewReader(`{"Nodes": [{"Metadata": {}}]}`)); err == nil {
+		t.Error("Invalid snapshot should be refused")
+	}
+	if len(g.GetNodes()) != 2 {
+		t.Error("Graph should not be modified by an invalid snapshot")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1", "MTU": 1500})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	e1 := g.NewEdge(GenID(), n1, n2, nil)
+
+	var buffer bytes.Buffer
+	if err := g.Snapshot(&buffer); err != nil {
+		t.Fatal(err.Error())
+	}
+	from, err := LoadSnapshot(&buffer)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if diff := Diff(from, g); len(diff.UpdatedNodes) != 0 || len(diff.AddedNodes) != 0 || len(diff.DeletedEdges) != 0 {
+		t.Errorf("No difference expected with the snapshot, got %+v", diff)
+	}
+
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	e2 := g.NewEdge(GenID(), n2, n3, nil)
+	g.AddMetadata(n1, "State", "DOWN")
+	g.DelEdge(e1)
+
+	diff := Diff(from, g)
+	if len(diff.AddedNodes) != 1 || diff.AddedNodes[0].ID != n3.ID {
+		t.Errorf("n3 should be added: %+v", diff.AddedNodes)
+	}
+	if len(diff.UpdatedNodes) != 1 || diff.UpdatedNodes[0].ID != n1.ID {
+		t.Errorf("n1 should be updated: %+v", diff.UpdatedNodes)
+	}
+	if len(diff.DeletedNodes) != 0 {
+		t.Errorf("No node should be deleted: %+v", diff.DeletedNodes)
+	}
+	if len(diff.AddedEdges) != 1 || diff.AddedEdges[0].ID != e2.ID {
+		t.Errorf("e2 should be added: %+v", diff.AddedEdges)
+	}
+	if len(diff.DeletedEdges) != 1 || diff.DeletedEdges[0].ID != e1.ID {
+		t.Errorf("e1 should be deleted: %+v", diff.DeletedEdges)
+	}
+}
+
+func TestUpdateCoalescer(t *testing.T) {
+	flushed := make(chan bool, 1)
+	u := newUpdateCoalescer(10 * time.Millisecond)
+
+	for _, id := range []Identifier{"a", "b", "a", "c", "b", "a"} {
+		u.add(id, func() { flushed <- true })
+	}
+	u.drop("c")
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("Pending updates not flushed")
+	}
+
+	ids := u.take()
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Fatalf("Expected the updates of a and b, got: %v", ids)
+	}
+
+	if ids := u.take(); len(ids) != 0 {
+		t.Fatalf("Expected no pending update, got: %v", ids)
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	g := newGraph(t)
+
+	RegisterNodeSchema("schematest", MetadataSchema{"MTU": NumberMetadata, "Name": StringMetadata})
+
+	n := g.NewNode(GenID(), Metadata{"Type": "schematest", "Name": "n"})
+
+	g.SetSchemaPolicy(RejectSchemaViolations)
+
+	g.AddMetadata(n, "MTU", "1500")
+	if _, ok := n.Metadata()["MTU"]; ok {
+		t.Error("Metadata not matching the schema should be rejected")
+	}
+
+	g.AddMetadata(n, "MTU", 1500)
+	if n.Metadata()["MTU"] != 1500 {
+		t.Errorf("Metadata matching the schema should be applied: %v", n.Metadata())
+	}
+
+	tr := g.StartMetadataTransaction(n)
+	tr.AddMetadata("Name", 1)
+	tr.AddMetadata("Free", true)
+	tr.Commit()
+	if n.Metadata()["Name"] != "n" || n.Metadata()["Free"] != nil {
+		t.Errorf("Transaction not matching the schema should be rejected: %v", n.Metadata())
+	}
+
+	g.SetSchemaPolicy(LogSchemaViolations)
+
+	g.SetMetadata(n, Metadata{"Type": "schematest", "Name": "n", "MTU": "9000"})
+	if n.Metadata()["MTU"] != "9000" {
+		t.Errorf("Inconsistent metadata should only be logged: %v", n.Metadata())
+	}
+}
+
+func TestGremlinMirrorQueries(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(Identifier("n1"), Metadata{"Name": "it's", "MTU": 1500, "Neutron": map[string]interface{}{"PortID": "p1"}, "Up": true})
+	n2 := g.NewNode(Identifier("n2"), Metadata{"Name": "n2"})
+	e := g.NewEdge(Identifier("e"), n1, n2, Metadata{"RelationType": "ownership"})
+
+	expected := `graph.addVertex('_ID', 'n1', '_host', '` + g.host + `', 'MTU', 1500, 'Name', 'it\'s', 'Neutron.PortID', 'p1', 'Up', true)`
+	if q := gremlinAddNode(n1); q != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+
+	expected = `g.V().has('_ID', 'n1').next().addEdge('ownership', g.V().has('_ID', 'n2').next(), '_ID', 'e', '_host', '` + g.host + `', 'RelationType', 'ownership')`
+	if q := gremlinAddEdge(e); q != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+
+	expected = `g.V().has('_ID', 'n2').properties().filter{!it.get().key().startsWith('_')}.drop().iterate(); g.V().has('_ID', 'n2').property('Name', 'n2').iterate()`
+	if q := gremlinUpdate("V", &n2.graphElement); q != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+}
+
+func TestSizeCollector(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	g.NewEdge(GenID(), n1, n2, nil)
+
+	// the forks don't change the size of the graph
+	fork, err := g.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork.NewNode(GenID(), Metadata{"Name": "n3"})
+
+	ch := make(chan prometheus.Metric, 2)
+	NewSizeCollector(g).Collect(ch)
+	close(ch)
+
+	var values []float64
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, metric.GetGauge().GetValue())
+	}
+
+	if len(values) != 2 || values[0] != 2 || values[1] != 1 {
+		t.Errorf("Expected 2 nodes and 1 edge, got: %v", values)
+	}
+}
+
+func TestMsgpackMessage(t *testing.T) {
+	g := newGraph(t)
+	n := g.NewNode(GenID(), Metadata{"Name": "eth0", "MTU": 1500, "Ratio": 1.0})
+
+	b, err := n.wsMessage("NodeAdded").Encode(shttp.MsgpackFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m struct {
+		Type string
+		Obj  struct {
+			ID       string
+			Metadata map[string]interface{}
+		}
+	}
+	if err := codec.NewDecoderBytes(b, &codec.MsgpackHandle{RawToString: true}).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Type != "NodeAdded" || m.Obj.ID != string(n.ID) || m.Obj.Metadata["Name"] != "eth0" {
+		t.Fatalf("wrong message: %+v", m)
+	}
+	if _, ok := m.Obj.Metadata["Ratio"].(float64); !ok {
+		t.Errorf("float metadata not kept as a float: %T", m.Obj.Metadata["Ratio"])
+	}
+	if _, ok := m.Obj.Metadata["MTU"].(float64); ok {
+		t.Errorf("integer metadata encoded as a float")
+	}
+
+	msg, err := shttp.DecodeWSMessage(b, shttp.MsgpackFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal([]byte(*msg.Obj), &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	var node Node
+	if err := node.Decode(obj); err != nil {
+		t.Fatal(err)
+	}
+	if node.ID != n.ID || node.metadata["Name"] != "eth0" {
+		t.Errorf("wrong decoded node: %+v", node)
+	}
+}
+
+func benchmarkNodeMessage(b *testing.B, format string, value bool) {
+	g := newGraph(b)
+	m := Metadata{"Name": "eth0", "Type": "veth", "MTU": 1500, "MAC": "fa:16:3e:29:4d:b6", "IfIndex": 42}
+	for i := 0; i < 20; i++ {
+		m["Key"+strconv.Itoa(i)] = float64(i) / 3
+	}
+	msg := g.NewNode(GenID(), m).wsMessage("NodeUpdated")
+	if !value {
+		msg.Value = nil
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.Encode(format); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNodeMessageJSON(b *testing.B) {
+	benchmarkNodeMessage(b, shttp.JSONFormat, true)
+}
+
+func BenchmarkNodeMessageMsgpack(b *testing.B) {
+	benchmarkNodeMessage(b, shttp.MsgpackFormat, true)
+}
+
+func BenchmarkNodeMessageMsgpackFromJSON(b *testing.B) {
+	benchmarkNodeMessage(b, shttp.MsgpackFormat, false)
+}
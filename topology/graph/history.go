@@ -0,0 +1,266 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrHistoryUnavailable = errors.New("Graph history not available at this time")
+
+// revision is the state of an element after a mutation, metadata is nil
+// when the element has been deleted
+type revision struct {
+	time     time.Time
+	id       Identifier
+	edge     bool
+	host     string
+	metadata Metadata
+	parent   Identifier
+	child    Identifier
+}
+
+// History records, in memory, the revisions of the elements of a graph so
+// that the graph can be rebuilt as it was at a given time. The revisions
+// older than the retention period, and the oldest ones beyond MaxRevisions,
+// are compacted into a single revision per existing element. Each revision
+// holds a copy of the metadata of the element, so without retention nor
+// maximum the memory used grows with every mutation.
+type History struct {
+	DefaultGraphListener
+	sync.RWMutex
+	Retention    time.Duration
+	MaxRevisions int
+	since        time.Time
+	base         map[Identifier]*revision
+	revisions    []*revision
+}
+
+func (h *History) record(r *revision) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.revisions = append(h.revisions, r)
+	h.compact(r.time)
+}
+
+// compact moves the revisions older than the retention period and the
+// ones exceeding the maximum number of revisions to the base state, the
+// history being then available from the time of the last one moved
+func (h *History) compact(now time.Time) {
+	i, since := 0, h.since
+	if h.Retention != 0 {
+		t := now.Add(-h.Retention)
+		for ; i < len(h.revisions) && h.revisions[i].time.Before(t); i++ {
+		}
+		if since.Before(t) {
+			since = t
+		}
+	}
+
+	if h.MaxRevisions > 0 && len(h.revisions)-i > h.MaxRevisions {
+		i = len(h.revisions) - h.MaxRevisions
+		if t := h.revisions[i-1].time; since.Before(t) {
+			since = t
+		}
+	}
+
+	for _, r := range h.revisions[:i] {
+		if r.metadata == nil {
+			delete(h.base, r.id)
+		} else {
+			h.base[r.id] = r
+		}
+	}
+	h.revisions = h.revisions[i:]
+	h.since = since
+}
+
+func copyMetadata(m Metadata) Metadata {
+	c := make(Metadata, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (h *History) recordNode(n *Node, deleted bool) {
+	r := &revision{time: time.Now(), id: n.ID, host: n.host}
+	if !deleted {
+		r.metadata = copyMetadata(n.metadata)
+	}
+	h.record(r)
+}
+
+func (h *History) recordEdge(e *Edge, deleted bool) {
+	r := &revision{time: time.Now(), id: e.ID, edge: true, host: e.host, parent: e.parent, child: e.child}
+	if !deleted {
+		r.metadata = copyMetadata(e.metadata)
+	}
+	h.record(r)
+}
+
+func (h *History) OnNodeUpdated(n *Node) {
+	h.recordNode(n, false)
+}
+
+func (h *History) OnNodeAdded(n *Node) {
+	h.recordNode(n, false)
+}
+
+func (h *History) OnNodeDeleted(n *Node) {
+	h.recordNode(n, true)
+}
+
+func (h *History) OnEdgeUpdated(e *Edge) {
+	h.recordEdge(e, false)
+}
+
+func (h *History) OnEdgeAdded(e *Edge) {
+	h.recordEdge(e, false)
+}
+
+func (h *History) OnEdgeDeleted(e *Edge) {
+	h.recordEdge(e, true)
+}
+
+// state returns the revisions of the elements existing at the time t
+func (h *History) state(t time.Time) (map[Identifier]*revision, error) {
+	h.RLock()
+	defer h.RUnlock()
+
+	if t.Before(h.since) {
+		return nil, ErrHistoryUnavailable
+	}
+
+	state := make(map[Identifier]*revision, len(h.base))
+	for id, r := range h.base {
+		state[id] = r
+	}
+
+	for _, r := range h.revisions {
+		if r.time.After(t) {
+			break
+		}
+
+		if r.metadata == nil {
+			delete(state, r.id)
+		} else {
+			state[r.id] = r
+		}
+	}
+
+	return state, nil
+}
+
+func NewHistory(retention time.Duration, maxRevisions int) *History {
+	return &History{
+		Retention:    retention,
+		MaxRevisions: maxRevisions,
+		since:        time.Now(),
+		base:         make(map[Identifier]*revision),
+	}
+}
+
+// readOnlyBackend refuses the mutations of the graphs rebuilt from history
+type readOnlyBackend struct {
+	*MemoryBackend
+}
+
+func (b readOnlyBackend) AddNode(n *Node) bool {
+	return false
+}
+
+func (b readOnlyBackend) DelNode(n *Node) bool {
+	return false
+}
+
+func (b readOnlyBackend) AddEdge(e *Edge) bool {
+	return false
+}
+
+func (b readOnlyBackend) DelEdge(e *Edge) bool {
+	return false
+}
+
+func (b readOnlyBackend) AddMetadata(i interface{}, k string, v interface{}) bool {
+	return false
+}
+
+func (b readOnlyBackend) SetMetadata(i interface{}, m Metadata) bool {
+	return false
+}
+
+// EnableHistory starts recording the mutations of the graph, the silent
+// ones excepted, keeping them for the retention period and up to
+// maxRevisions of them, 0 meaning no limit. Must be called with the graph
+// lock held.
+func (g *Graph) EnableHistory(retention time.Duration, maxRevisions int) {
+	g.history = NewHistory(retention, maxRevisions)
+	for _, n := range g.GetNodes() {
+		g.history.base[n.ID] = &revision{time: g.history.since, id: n.ID, host: n.host, metadata: copyMetadata(n.metadata)}
+	}
+	for _, e := range g.GetEdges() {
+		g.history.base[e.ID] = &revision{time: g.history.since, id: e.ID, edge: true, host: e.host, metadata: copyMetadata(e.metadata), parent: e.parent, child: e.child}
+	}
+	g.AddEventListenerWithPriority(g.history, ListenerPriorityFromConfig("history", ExportListenerPriority))
+}
+
+// At returns a read-only view of the graph as it was at the time t.
+// The view is not updated afterwards.
+func (g *Graph) At(t time.Time) (*Graph, error) {
+	if g.history == nil {
+		return nil, ErrHistoryUnavailable
+	}
+
+	state, err := g.history.state(t)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range state {
+		if !r.edge {
+			m.AddNode(&Node{graphElement: graphElement{ID: r.id, metadata: r.metadata, host: r.host}})
+		}
+	}
+
+	for _, r := range state {
+		if r.edge {
+			m.AddEdge(&Edge{graphElement: graphElement{ID: r.id, metadata: r.metadata, host: r.host}, parent: r.parent, child: r.child})
+		}
+	}
+
+	return &Graph{
+		backend:     readOnlyBackend{MemoryBackend: m},
+		host:        g.host,
+		idGenerator: g.idGenerator,
+		index:       g.forkIndex(m.GetNodes()),
+	}, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/redhat-cip/skydive/config"
+)
+
+const (
+	maxIDGenerationAttempts = 100
+)
+
+// IDGenerator generates the identifiers of the nodes and edges created
+// locally. Generated identifiers may collide with existing ones, the graph
+// checks it and calls GenID again with an incremented attempt number until a
+// free identifier is returned. A generator that keeps returning used
+// identifiers makes the graph fallback on a random UUID.
+type IDGenerator interface {
+	GenID(m Metadata, attempt int) Identifier
+}
+
+// UUIDGenerator returns random UUIDs, collisions are not expected.
+type UUIDGenerator struct {
+}
+
+// HashIDGenerator returns identifiers computed from the host and the
+// metadata so that an element re-created with the same metadata, after a
+// restart for instance, gets the same identifier. Two elements created with
+// the same metadata collide, the attempt number is then part of the hash.
+type HashIDGenerator struct {
+	namespace *uuid.UUID
+}
+
+// SequentialIDGenerator returns identifiers made of the host and a counter.
+// The counter is reset on restart so the identifiers are only unique during
+// the life time of the process.
+type SequentialIDGenerator struct {
+	host    string
+	counter uint64
+}
+
+func (u *UUIDGenerator) GenID(m Metadata, attempt int) Identifier {
+	return GenID()
+}
+
+func (h *HashIDGenerator) GenID(m Metadata, attempt int) Identifier {
+	// map keys are sorted by the json encoder
+	data, err := json.Marshal(m)
+	if err != nil {
+		return GenID()
+	}
+
+	if attempt > 0 {
+		data = append(data, []byte(fmt.Sprintf("#%d", attempt))...)
+	}
+
+	u, err := uuid.NewV5(h.namespace, data)
+	if err != nil {
+		return GenID()
+	}
+
+	return Identifier(u.String())
+}
+
+func (s *SequentialIDGenerator) GenID(m Metadata, attempt int) Identifier {
+	return Identifier(fmt.Sprintf("%s-%d", s.host, atomic.AddUint64(&s.counter, 1)))
+}
+
+func NewHashIDGenerator(host string) (*HashIDGenerator, error) {
+	ns, err := uuid.NewV5(uuid.NamespaceURL, []byte("skydive://"+host))
+	if err != nil {
+		return nil, err
+	}
+
+	return &HashIDGenerator{namespace: ns}, nil
+}
+
+func NewSequentialIDGenerator(host string) *SequentialIDGenerator {
+	return &SequentialIDGenerator{host: host}
+}
+
+func IDGeneratorFromConfig() (IDGenerator, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	generator := config.GetConfig().GetString("graph.id_generator")
+	switch generator {
+	case "", "uuid":
+		return &UUIDGenerator{}, nil
+	case "hash":
+		return NewHashIDGenerator(host)
+	case "sequential":
+		return NewSequentialIDGenerator(host), nil
+	default:
+		return nil, errors.New("Config file is misconfigured, graph id generator unknown: " + generator)
+	}
+}
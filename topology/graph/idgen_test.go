@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"testing"
+)
+
+type constantIDGenerator struct {
+}
+
+func (c *constantIDGenerator) GenID(m Metadata, attempt int) Identifier {
+	return Identifier("constant")
+}
+
+func TestHashIDGenerator(t *testing.T) {
+	g := newGraph(t)
+
+	h, err := NewHashIDGenerator("host1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	g.SetIDGenerator(h)
+
+	m := Metadata{"Name": "eth0", "Type": "veth"}
+
+	id1 := g.GenID(m)
+	if id1 != g.GenID(m) {
+		t.Error("Same identifier expected for the same metadata")
+	}
+
+	n1 := g.NewNode(id1, m)
+
+	// same metadata, the identifier is already used
+	id2 := g.GenID(m)
+	if id2 == id1 {
+		t.Error("Collision should have been detected")
+	}
+	n2 := g.NewNode(id2, m)
+
+	// same graph re-created after a restart
+	r := newGraph(t)
+	r.SetIDGenerator(h)
+	if r.GenID(m) != n1.ID {
+		t.Error("Same identifier expected after re-creation")
+	}
+	r.NewNode(n1.ID, m)
+	if r.GenID(m) != n2.ID {
+		t.Error("Same identifier expected after re-creation")
+	}
+
+	// different host, different identifiers
+	h2, _ := NewHashIDGenerator("host2")
+	if h2.GenID(m, 0) == h.GenID(m, 0) {
+		t.Error("Identifiers of different hosts shouldn't collide")
+	}
+}
+
+func TestSequentialIDGenerator(t *testing.T) {
+	g := newGraph(t)
+	g.SetIDGenerator(NewSequentialIDGenerator("host1"))
+
+	if id := g.GenID(nil); id != "host1-1" {
+		t.Errorf("Wrong identifier returned: %s", id)
+	}
+	if id := g.GenID(nil); id != "host1-2" {
+		t.Errorf("Wrong identifier returned: %s", id)
+	}
+}
+
+func TestIDGeneratorFallback(t *testing.T) {
+	g := newGraph(t)
+	g.SetIDGenerator(&constantIDGenerator{})
+
+	n1 := g.NewNode(g.GenID(nil), nil)
+	if n1.ID != "constant" {
+		t.Errorf("Wrong identifier returned: %s", n1.ID)
+	}
+
+	// always colliding generator, a random identifier has to be returned
+	if id := g.GenID(nil); id == n1.ID || id == "" {
+		t.Errorf("Wrong identifier returned: %s", id)
+	}
+}
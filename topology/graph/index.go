@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/redhat-cip/skydive/common"
+)
+
+// MetadataIndex indexes the nodes by the values of some metadata fields so
+// that the lookups filtering on one of them don't scan the whole graph.
+// The indexed values of each node are kept as the metadata may be modified
+// in place before being set.
+type MetadataIndex struct {
+	fields  []string
+	entries map[string]map[interface{}]map[Identifier]*Node
+	values  map[Identifier]map[string]interface{}
+}
+
+// indexKey returns the key of a metadata value in the index, the numbers
+// being compared whatever their type, see common.CrossTypeEqual
+func indexKey(v interface{}) (interface{}, bool) {
+	switch v.(type) {
+	case nil, MetadataMatcher:
+		return nil, false
+	case int, uint, int32, uint32, int64, uint64, float32, float64:
+		f, err := common.ToFloat64(v)
+		return f, err == nil
+	case string, bool:
+		return v, true
+	}
+	return v, reflect.TypeOf(v).Comparable()
+}
+
+func (i *MetadataIndex) add(n *Node) {
+	values := make(map[string]interface{})
+	for _, field := range i.fields {
+		key, ok := indexKey(n.metadata[field])
+		if !ok {
+			continue
+		}
+
+		nodes, ok := i.entries[field][key]
+		if !ok {
+			nodes = make(map[Identifier]*Node)
+			i.entries[field][key] = nodes
+		}
+		nodes[n.ID] = n
+		values[field] = key
+	}
+	i.values[n.ID] = values
+}
+
+func (i *MetadataIndex) del(n *Node) {
+	for field, key := range i.values[n.ID] {
+		nodes := i.entries[field][key]
+		delete(nodes, n.ID)
+		if len(nodes) == 0 {
+			delete(i.entries[field], key)
+		}
+	}
+	delete(i.values, n.ID)
+}
+
+// update reindexes a node whose metadata have been modified
+func (i *MetadataIndex) update(n *Node) {
+	i.del(n)
+	i.add(n)
+}
+
+// lookup returns the candidate nodes matching one of the indexed fields of
+// the filter, false if the filter doesn't use any of them
+func (i *MetadataIndex) lookup(m Metadata) ([]*Node, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	for _, field := range i.fields {
+		key, ok := indexKey(m[field])
+		if !ok {
+			continue
+		}
+
+		nodes := make([]*Node, 0, len(i.entries[field][key]))
+		for _, n := range i.entries[field][key] {
+			nodes = append(nodes, n)
+		}
+		return nodes, true
+	}
+	return nil, false
+}
+
+// Fields returns the indexed metadata fields
+func (i *MetadataIndex) Fields() []string {
+	if i == nil {
+		return nil
+	}
+	return i.fields
+}
+
+// IndexStats checks the index against the metadata of the nodes
+func (i *MetadataIndex) IndexStats(nodes []*Node) IndexStats {
+	stats := IndexStats{Name: "Metadata"}
+
+	present := make(map[Identifier]bool)
+	for _, n := range nodes {
+		present[n.ID] = true
+
+		for _, field := range i.fields {
+			key, ok := indexKey(n.metadata[field])
+			if !ok {
+				continue
+			}
+
+			if _, found := i.entries[field][key][n.ID]; !found {
+				stats.Errors = append(stats.Errors, fmt.Sprintf("node %s not indexed by %s", n.ID, field))
+			}
+		}
+	}
+
+	for field, values := range i.entries {
+		for key, nodes := range values {
+			for id, n := range nodes {
+				stats.Entries++
+
+				if !present[id] {
+					stats.Errors = append(stats.Errors, fmt.Sprintf("node %s indexed but not found", id))
+				} else if current, ok := indexKey(n.metadata[field]); !ok || current != key {
+					stats.Errors = append(stats.Errors, fmt.Sprintf("node %s indexed by a stale %s", id, field))
+				}
+			}
+		}
+	}
+	stats.Consistent = len(stats.Errors) == 0
+
+	return stats
+}
+
+// NewMetadataIndex returns an index of the nodes on the given fields
+func NewMetadataIndex(fields []string, nodes []*Node) *MetadataIndex {
+	i := &MetadataIndex{
+		fields:  fields,
+		entries: make(map[string]map[interface{}]map[Identifier]*Node),
+		values:  make(map[Identifier]map[string]interface{}),
+	}
+	for _, field := range fields {
+		i.entries[field] = make(map[interface{}]map[Identifier]*Node)
+	}
+	for _, n := range nodes {
+		i.add(n)
+	}
+
+	return i
+}
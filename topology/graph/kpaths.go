@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"container/heap"
+	"strings"
+
+	"github.com/redhat-cip/skydive/common"
+)
+
+const (
+	maxShortestPaths         = 100
+	maxShortestPathsSearches = 1000
+)
+
+type costPath struct {
+	nodes []Identifier
+	cost  float64
+}
+
+type distItem struct {
+	node Identifier
+	dist float64
+}
+
+type distHeap []distItem
+
+func (h distHeap) Len() int            { return len(h) }
+func (h distHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h distHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *distHeap) Push(x interface{}) { *h = append(*h, x.(distItem)) }
+func (h *distHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// weightedAdjacency is an undirected snapshot of the graph where parallel
+// edges are merged keeping the lowest weight.
+type weightedAdjacency map[Identifier]map[Identifier]float64
+
+type nodePair [2]Identifier
+
+func newNodePair(a, b Identifier) nodePair {
+	if a < b {
+		return nodePair{a, b}
+	}
+	return nodePair{b, a}
+}
+
+func (p *costPath) key() string {
+	s := make([]string, len(p.nodes))
+	for i, n := range p.nodes {
+		s[i] = string(n)
+	}
+	return strings.Join(s, "/")
+}
+
+// edgeWeight returns the weight of the edge read from the weight metadata,
+// edges without a valid weight count as 1.
+func edgeWeight(e *Edge, weight string) float64 {
+	if weight == "" {
+		return 1
+	}
+
+	if v, ok := e.metadata[weight]; ok {
+		if w, err := common.ToFloat64(v); err == nil && w >= 0 {
+			return w
+		}
+	}
+	return 1
+}
+
+func (g *Graph) snapshotWeightedAdjacency(weight string, em Metadata) weightedAdjacency {
+	adj := make(weightedAdjacency)
+	for _, n := range g.GetNodes() {
+		adj[n.ID] = make(map[Identifier]float64)
+	}
+
+	for _, e := range g.GetEdges() {
+		if em != nil && !e.matchMetadata(em) {
+			continue
+		}
+
+		if _, ok := adj[e.parent]; !ok || e.parent == e.child {
+			continue
+		}
+		if _, ok := adj[e.child]; !ok {
+			continue
+		}
+
+		w := edgeWeight(e, weight)
+		if cw, ok := adj[e.parent][e.child]; !ok || w < cw {
+			adj[e.parent][e.child] = w
+			adj[e.child][e.parent] = w
+		}
+	}
+
+	return adj
+}
+
+// dijkstra returns the lowest cost path from src to the nearest target
+// without going through the removed nodes and edges.
+func (adj weightedAdjacency) dijkstra(src Identifier, targets map[Identifier]bool, removedNodes map[Identifier]bool, removedEdges map[nodePair]bool) *costPath {
+	dist := map[Identifier]float64{src: 0}
+	prev := make(map[Identifier]Identifier)
+	done := make(map[Identifier]bool)
+
+	h := &distHeap{{node: src}}
+	for h.Len() > 0 {
+		it := heap.Pop(h).(distItem)
+		if done[it.node] {
+			continue
+		}
+		done[it.node] = true
+
+		if targets[it.node] {
+			nodes := []Identifier{it.node}
+			for n := it.node; n != src; {
+				n = prev[n]
+				nodes = append([]Identifier{n}, nodes...)
+			}
+			return &costPath{nodes: nodes, cost: it.dist}
+		}
+
+		for nb, w := range adj[it.node] {
+			if done[nb] || removedNodes[nb] || removedEdges[newNodePair(it.node, nb)] {
+				continue
+			}
+
+			d := it.dist + w
+			if od, ok := dist[nb]; !ok || d < od {
+				dist[nb] = d
+				prev[nb] = it.node
+				heap.Push(h, distItem{node: nb, dist: d})
+			}
+		}
+	}
+
+	return nil
+}
+
+func samePrefix(a, b []Identifier, n int) bool {
+	if len(a) < n || len(b) < n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// kShortestPaths implements the Yen algorithm. The number of shortest path
+// searches is bounded, fewer than k paths may then be returned.
+func (adj weightedAdjacency) kShortestPaths(src Identifier, targets map[Identifier]bool, k int) []*costPath {
+	first := adj.dijkstra(src, targets, nil, nil)
+	if first == nil {
+		return nil
+	}
+
+	paths := []*costPath{first}
+	known := map[string]bool{first.key(): true}
+	candidates := []*costPath{}
+	searches := 1
+
+	for len(paths) < k {
+		last := paths[len(paths)-1]
+
+		for i := 0; i < len(last.nodes)-1 && searches < maxShortestPathsSearches; i++ {
+			spur := last.nodes[i]
+			root := last.nodes[:i+1]
+
+			removedEdges := make(map[nodePair]bool)
+			for _, p := range paths {
+				if len(p.nodes) > i+1 && samePrefix(p.nodes, root, i+1) {
+					removedEdges[newNodePair(p.nodes[i], p.nodes[i+1])] = true
+				}
+			}
+
+			removedNodes := make(map[Identifier]bool)
+			rootCost := 0.0
+			for j, n := range root[:i] {
+				removedNodes[n] = true
+				rootCost += adj[n][root[j+1]]
+			}
+
+			searches++
+			sp := adj.dijkstra(spur, targets, removedNodes, removedEdges)
+			if sp == nil {
+				continue
+			}
+
+			nodes := make([]Identifier, 0, i+len(sp.nodes))
+			nodes = append(nodes, root[:i]...)
+			nodes = append(nodes, sp.nodes...)
+
+			candidate := &costPath{nodes: nodes, cost: rootCost + sp.cost}
+			if key := candidate.key(); !known[key] {
+				known[key] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		best := 0
+		for i, c := range candidates {
+			if c.cost < candidates[best].cost || (c.cost == candidates[best].cost && len(c.nodes) < len(candidates[best].nodes)) {
+				best = i
+			}
+		}
+		paths = append(paths, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+
+	return paths
+}
+
+// LookupKShortestPaths returns up to k distinct paths, ordered by cost, from
+// the node n to nodes matching the metadata m. The cost of an edge is the
+// value of its weight metadata, 1 if not set. Only the edges matching the
+// optional em metadata are followed. k is bounded to 100.
+func (g *Graph) LookupKShortestPaths(n *Node, m Metadata, k int, weight string, em ...Metadata) [][]*Node {
+	if k <= 0 {
+		return [][]*Node{}
+	}
+	if k > maxShortestPaths {
+		k = maxShortestPaths
+	}
+
+	var e Metadata
+	if len(em) > 0 {
+		e = em[0]
+	}
+
+	targets := make(map[Identifier]bool)
+	for _, t := range g.GetNodes() {
+		if t.matchMetadata(m) {
+			targets[t.ID] = true
+		}
+	}
+
+	result := [][]*Node{}
+	for _, p := range g.snapshotWeightedAdjacency(weight, e).kShortestPaths(n.ID, targets, k) {
+		path := make([]*Node, len(p.nodes))
+		for i, id := range p.nodes {
+			path[i] = g.GetNode(id)
+		}
+		result = append(result, path)
+	}
+
+	return result
+}
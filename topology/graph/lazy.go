@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+)
+
+// MetadataValueRef replaces, in the messages sent to the clients, a metadata
+// value larger than the configured threshold. The value can then be fetched
+// with a GetMetadataValue request carrying the ID and the Key.
+type MetadataValueRef struct {
+	ID   Identifier
+	Key  string
+	Size int
+}
+
+// MetadataValue is the request and the reply of GetMetadataValue.
+type MetadataValue struct {
+	ID    Identifier
+	Key   string
+	Value interface{} `json:",omitempty"`
+}
+
+// lazyMetadata returns the metadata with the values larger than threshold
+// bytes, once json encoded, replaced by a reference. The metadata are
+// copied only if at least one value is replaced.
+func lazyMetadata(e *graphElement, threshold int) Metadata {
+	var m Metadata
+	for k, v := range e.metadata {
+		b, err := json.Marshal(v)
+		if err != nil || len(b) <= threshold {
+			continue
+		}
+
+		if m == nil {
+			m = make(Metadata)
+			for mk, mv := range e.metadata {
+				m[mk] = mv
+			}
+		}
+		m[k] = map[string]interface{}{
+			"MetadataValueRef": &MetadataValueRef{ID: e.ID, Key: k, Size: len(b)},
+		}
+	}
+
+	return m
+}
+
+func lazyNode(n *Node, threshold int) *Node {
+	if threshold <= 0 {
+		return n
+	}
+
+	if m := lazyMetadata(&n.graphElement, threshold); m != nil {
+		return &Node{graphElement: graphElement{ID: n.ID, metadata: m, host: n.host}}
+	}
+	return n
+}
+
+func lazyEdge(e *Edge, threshold int) *Edge {
+	if threshold <= 0 {
+		return e
+	}
+
+	if m := lazyMetadata(&e.graphElement, threshold); m != nil {
+		return &Edge{graphElement: graphElement{ID: e.ID, metadata: m, host: e.host}, parent: e.parent, child: e.child}
+	}
+	return e
+}
+
+// lookupMetadataValue returns the value of a metadata of a node or an edge.
+func (g *Graph) lookupMetadataValue(i Identifier, k string) (interface{}, bool) {
+	var m Metadata
+	if n := g.GetNode(i); n != nil {
+		m = n.metadata
+	} else if e := g.GetEdge(i); e != nil {
+		m = e.metadata
+	}
+
+	v, ok := m[k]
+	return v, ok
+}
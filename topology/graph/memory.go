@@ -22,6 +22,10 @@
 
 package graph
 
+import (
+	"fmt"
+)
+
 type MemoryBackendNode struct {
 	*Node
 	edges map[Identifier]*MemoryBackendEdge
@@ -47,20 +51,28 @@ func (m MemoryBackend) SetMetadata(i interface{}, meta Metadata) bool {
 	return true
 }
 
+// AddMetadata doesn't modify the metadata in place but replaces them by an
+// updated copy so that metadata can be shared between graphs, see Fork.
 func (m MemoryBackend) AddMetadata(i interface{}, k string, v interface{}) bool {
-	var e graphElement
+	var e *graphElement
 
 	switch i.(type) {
 	case *Node:
-		e = i.(*Node).graphElement
+		e = &i.(*Node).graphElement
 	case *Edge:
-		e = i.(*Edge).graphElement
+		e = &i.(*Edge).graphElement
 	}
 
 	if o, ok := e.metadata[k]; ok && o == v {
 		return false
 	}
-	e.metadata[k] = v
+
+	metadata := make(Metadata, len(e.metadata)+1)
+	for mk, mv := range e.metadata {
+		metadata[mk] = mv
+	}
+	metadata[k] = v
+	e.metadata = metadata
 
 	return true
 }
@@ -188,6 +200,37 @@ func (m MemoryBackend) GetEdges() []*Edge {
 	return edges
 }
 
+// IndexStats checks the per node edges index against the edges
+func (m MemoryBackend) IndexStats() []IndexStats {
+	stats := IndexStats{Name: "NodeEdges", Consistent: true}
+
+	for id, n := range m.nodes {
+		for eid, e := range n.edges {
+			stats.Entries++
+
+			if _, ok := m.edges[eid]; !ok {
+				stats.Errors = append(stats.Errors, fmt.Sprintf("edge %s of node %s not found", eid, id))
+			} else if e.parent != id && e.child != id {
+				stats.Errors = append(stats.Errors, fmt.Sprintf("edge %s indexed for node %s not linked to it", eid, id))
+			}
+		}
+	}
+
+	for id, e := range m.edges {
+		for _, nid := range []Identifier{e.parent, e.child} {
+			n, ok := m.nodes[nid]
+			if !ok {
+				stats.Errors = append(stats.Errors, fmt.Sprintf("node %s of edge %s not found", nid, id))
+			} else if _, ok := n.edges[id]; !ok {
+				stats.Errors = append(stats.Errors, fmt.Sprintf("edge %s not indexed for node %s", id, nid))
+			}
+		}
+	}
+	stats.Consistent = len(stats.Errors) == 0
+
+	return []IndexStats{stats}
+}
+
 func NewMemoryBackend() (*MemoryBackend, error) {
 	return &MemoryBackend{
 		nodes: make(map[Identifier]*MemoryBackendNode),
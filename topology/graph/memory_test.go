@@ -23,6 +23,9 @@
 package graph
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -45,3 +48,79 @@ func TestAddEdgeMissingNode(t *testing.T) {
 		t.Error("Edge inserted with missing nodes")
 	}
 }
+
+func TestIndexStats(t *testing.T) {
+	b, err := NewMemoryBackend()
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	g, err := NewGraph(b)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	e := g.NewEdge(GenID(), n1, n2, nil)
+
+	stats := g.IndexStats()
+	if len(stats) != 1 || stats[0].Entries != 2 || !stats[0].Consistent {
+		t.Fatalf("Wrong index stats returned: %v", stats)
+	}
+
+	// corrupt the index
+	delete(b.nodes[n2.ID].edges, e.ID)
+
+	stats = g.IndexStats()
+	if stats[0].Consistent || len(stats[0].Errors) != 1 {
+		t.Fatalf("Index should be inconsistent: %v", stats)
+	}
+}
+
+func TestBoltBackendReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-bolt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "graph.db")
+
+	b, err := NewBoltBackend(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	g, _ := NewGraph(b)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	e := g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+	g.AddMetadata(n1, "Value", 1)
+	g.DelNode(n3)
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	b, err = NewBoltBackend(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	if len(b.GetNodes()) != 2 || len(b.GetEdges()) != 1 {
+		t.Fatalf("Graph not reloaded: %v %v", b.GetNodes(), b.GetEdges())
+	}
+
+	n := b.GetNode(n1.ID)
+	if n == nil || n.metadata["Value"] != float64(1) {
+		t.Fatalf("Node metadata not reloaded: %v", n)
+	}
+
+	if le := b.GetEdge(e.ID); le == nil || le.parent != n1.ID || le.child != n2.ID {
+		t.Fatalf("Edge not reloaded: %v", le)
+	}
+}
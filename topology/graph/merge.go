@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"errors"
+
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/config"
+)
+
+// EdgeMergePolicy defines how the edges reattached while merging nodes are
+// handled when the merged node already has an edge of the same relation
+// type to the same neighbor in the same direction.
+type EdgeMergePolicy int
+
+const (
+	// DedupeEdgesByRelation keeps the existing edge, the other one is removed
+	DedupeEdgesByRelation EdgeMergePolicy = iota
+	// KeepBothEdges keeps parallel edges
+	KeepBothEdges
+	// MergeEdgesMetadata keeps the existing edge and adds to it the metadata
+	// of the other one that it doesn't have
+	MergeEdgesMetadata
+)
+
+func EdgeMergePolicyFromConfig() (EdgeMergePolicy, error) {
+	policy := config.GetConfig().GetString("graph.edge_merge_policy")
+	switch policy {
+	case "", "dedupe":
+		return DedupeEdgesByRelation, nil
+	case "keep":
+		return KeepBothEdges, nil
+	case "merge":
+		return MergeEdgesMetadata, nil
+	default:
+		return DedupeEdgesByRelation, errors.New("Config file is misconfigured, edge merge policy unknown: " + policy)
+	}
+}
+
+func (g *Graph) SetEdgeMergePolicy(p EdgeMergePolicy) {
+	g.edgeMergePolicy = p
+}
+
+func (g *Graph) lookupParallelEdge(n *Node, parent Identifier, child Identifier, m Metadata) *Edge {
+	for _, e := range g.backend.GetNodeEdges(n) {
+		if e.parent == parent && e.child == child && common.CrossTypeEqual(e.metadata["RelationType"], m["RelationType"]) {
+			return e
+		}
+	}
+	return nil
+}
+
+// mergeMetadata returns the metadata of e completed with the keys of m it
+// doesn't have, nil if nothing has to be added.
+func mergeMetadata(e *graphElement, m Metadata) Metadata {
+	var merged Metadata
+	for k, v := range m {
+		if _, ok := e.metadata[k]; ok {
+			continue
+		}
+
+		if merged == nil {
+			merged = make(Metadata)
+			for mk, mv := range e.metadata {
+				merged[mk] = mv
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// MergeNodes merges the nodes into the node n. The edges of the merged nodes
+// are reattached to n keeping their identifiers, the edges between merged
+// nodes are removed and the parallel edges created are handled according to
+// the edge merge policy of the graph. The metadata of the merged nodes that n
+// doesn't have are added to n.
+func (g *Graph) MergeNodes(n *Node, nodes ...*Node) {
+	merged := map[Identifier]bool{n.ID: true}
+	for _, o := range nodes {
+		merged[o.ID] = true
+	}
+
+	metadata := n.metadata
+	for _, o := range nodes {
+		if o.ID == n.ID {
+			continue
+		}
+
+		for _, e := range g.backend.GetNodeEdges(o) {
+			g.DelEdge(e)
+
+			parent, child := e.parent, e.child
+			if merged[parent] {
+				parent = n.ID
+			}
+			if merged[child] {
+				child = n.ID
+			}
+
+			if parent == child {
+				continue
+			}
+
+			if g.edgeMergePolicy != KeepBothEdges {
+				if pe := g.lookupParallelEdge(n, parent, child, e.metadata); pe != nil {
+					if g.edgeMergePolicy == MergeEdgesMetadata {
+						if m := mergeMetadata(&pe.graphElement, e.metadata); m != nil {
+							g.SetMetadata(pe, m)
+						}
+					}
+					continue
+				}
+			}
+
+			p, c := g.GetNode(parent), g.GetNode(child)
+			if p == nil || c == nil {
+				continue
+			}
+			g.NewEdge(e.ID, p, c, e.metadata)
+		}
+
+		if m := mergeMetadata(&graphElement{metadata: metadata}, o.metadata); m != nil {
+			metadata = m
+		}
+
+		g.DelNode(o)
+	}
+
+	if len(metadata) != len(n.metadata) {
+		g.SetMetadata(n, metadata)
+	}
+}
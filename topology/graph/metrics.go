@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	coalescedUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "skydive_graph_coalesced_updates_total",
+		Help: "Number of node and edge updates merged with a pending one instead of being broadcasted.",
+	})
+	schemaViolations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "skydive_graph_schema_violations_total",
+		Help: "Number of metadata updates not matching the schema of the element type.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(coalescedUpdates)
+	prometheus.MustRegister(schemaViolations)
+}
+
+// sizeCollector reports the number of nodes and edges of a graph, counted
+// when the metrics are scraped
+type sizeCollector struct {
+	graph *Graph
+	nodes *prometheus.Desc
+	edges *prometheus.Desc
+}
+
+func (c *sizeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nodes
+	ch <- c.edges
+}
+
+func (c *sizeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.graph.RLock()
+	nodes, edges := len(c.graph.GetNodes()), len(c.graph.GetEdges())
+	c.graph.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(c.nodes, prometheus.GaugeValue, float64(nodes))
+	ch <- prometheus.MustNewConstMetric(c.edges, prometheus.GaugeValue, float64(edges))
+}
+
+// NewSizeCollector returns a collector of the number of nodes and edges of
+// the graph, to be registered for the live graph only, not for its forks
+// or its views
+func NewSizeCollector(g *Graph) prometheus.Collector {
+	return &sizeCollector{
+		graph: g,
+		nodes: prometheus.NewDesc("skydive_graph_nodes", "Number of nodes of the graph.", nil, nil),
+		edges: prometheus.NewDesc("skydive_graph_edges", "Number of edges of the graph.", nil, nil),
+	}
+}
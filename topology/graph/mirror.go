@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph/gremlin"
+)
+
+const (
+	gremlinMirrorQueueSize   = 10000
+	gremlinMirrorRetryPeriod = 5 * time.Second
+)
+
+// GremlinMirror is a graph listener mirroring the graph into a database
+// speaking Gremlin, TinkerPop 3, like JanusGraph or Neo4j through a Gremlin
+// Server. The graph remains the reference: the database is emptied of the
+// mirrored elements and filled again with the whole graph on start, after
+// an error or when the queue of the changes overflows. The elements are
+// identified by the _ID property, their nested metadata being flattened,
+// ex: Neutron.PortID, and the edges labelled by their RelationType.
+type GremlinMirror struct {
+	Endpoint  string
+	graph     *Graph
+	client    *gremlin.GremlinClient
+	connected bool
+	queries   chan string
+	resync    int32
+	quit      chan bool
+	wg        sync.WaitGroup
+}
+
+func gremlinString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+func gremlinValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return gremlinString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v)
+	case float32:
+		return gremlinValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return gremlinString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	b, _ := json.Marshal(v)
+	return gremlinString(string(b))
+}
+
+func flattenMetadata(prefix string, m map[string]interface{}, flat map[string]interface{}) {
+	for k, v := range m {
+		switch v := v.(type) {
+		case Metadata:
+			flattenMetadata(prefix+k+".", v, flat)
+		case map[string]interface{}:
+			flattenMetadata(prefix+k+".", v, flat)
+		default:
+			flat[prefix+k] = v
+		}
+	}
+}
+
+// gremlinProperties returns the quoted keys and the values of the
+// metadata, sorted by key, the keys starting with _ being reserved
+func gremlinProperties(m Metadata) (keys []string, values []string) {
+	flat := make(map[string]interface{})
+	flattenMetadata("", m, flat)
+
+	for k := range flat {
+		if !strings.HasPrefix(k, "_") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		keys[i] = gremlinString(k)
+		values = append(values, gremlinValue(flat[k]))
+	}
+	return
+}
+
+func gremlinLookup(step string, id Identifier) string {
+	return "g." + step + "().has('_ID', " + gremlinString(string(id)) + ")"
+}
+
+func gremlinElementProperties(e *graphElement) string {
+	props := "'_ID', " + gremlinString(string(e.ID)) + ", '_host', " + gremlinString(e.host)
+
+	keys, values := gremlinProperties(e.metadata)
+	for i := range keys {
+		props += ", " + keys[i] + ", " + values[i]
+	}
+	return props
+}
+
+// gremlinUpdate replaces the metadata properties of an element
+func gremlinUpdate(step string, e *graphElement) string {
+	query := gremlinLookup(step, e.ID) + ".properties().filter{!it.get().key().startsWith('_')}.drop().iterate()"
+
+	keys, values := gremlinProperties(e.metadata)
+	if len(keys) == 0 {
+		return query
+	}
+
+	query += "; " + gremlinLookup(step, e.ID)
+	for i := range keys {
+		query += ".property(" + keys[i] + ", " + values[i] + ")"
+	}
+	return query + ".iterate()"
+}
+
+func gremlinAddNode(n *Node) string {
+	return "graph.addVertex(" + gremlinElementProperties(&n.graphElement) + ")"
+}
+
+func gremlinAddEdge(e *Edge) string {
+	label := "linked"
+	if rt, ok := e.metadata["RelationType"].(string); ok && rt != "" {
+		label = rt
+	}
+
+	return gremlinLookup("V", e.parent) + ".next().addEdge(" + gremlinString(label) + ", " +
+		gremlinLookup("V", e.child) + ".next(), " + gremlinElementProperties(&e.graphElement) + ")"
+}
+
+// the queries are built when the event occurs, with the graph lock held
+func (m *GremlinMirror) push(query string) {
+	select {
+	case m.queries <- query:
+	default:
+		if atomic.CompareAndSwapInt32(&m.resync, 0, 1) {
+			logging.GetLogger().Warningf("Gremlin mirror queue full, %s will be resynchronized", m.Endpoint)
+		}
+	}
+}
+
+func (m *GremlinMirror) OnNodeUpdated(n *Node) {
+	m.push(gremlinUpdate("V", &n.graphElement))
+}
+
+func (m *GremlinMirror) OnNodeAdded(n *Node) {
+	m.push(gremlinAddNode(n))
+}
+
+func (m *GremlinMirror) OnNodeDeleted(n *Node) {
+	m.push(gremlinLookup("V", n.ID) + ".drop().iterate()")
+}
+
+func (m *GremlinMirror) OnEdgeUpdated(e *Edge) {
+	m.push(gremlinUpdate("E", &e.graphElement))
+}
+
+func (m *GremlinMirror) OnEdgeAdded(e *Edge) {
+	m.push(gremlinAddEdge(e))
+}
+
+func (m *GremlinMirror) OnEdgeDeleted(e *Edge) {
+	m.push(gremlinLookup("E", e.ID) + ".drop().iterate()")
+}
+
+// sync replaces the mirrored elements by the ones of the graph
+func (m *GremlinMirror) sync() error {
+	if m.connected {
+		m.client.Close()
+	}
+	if m.connected = m.client.Connect() == nil; !m.connected {
+		return fmt.Errorf("unable to connect to %s", m.Endpoint)
+	}
+
+	// the changes queued before are part of the snapshot, the events
+	// being notified with the graph lock held
+	m.graph.RLock()
+	atomic.StoreInt32(&m.resync, 0)
+	for len(m.queries) > 0 {
+		<-m.queries
+	}
+
+	queries := []string{"g.V().has('_ID').drop().iterate()"}
+	for _, n := range m.graph.GetNodes() {
+		queries = append(queries, gremlinAddNode(n))
+	}
+	for _, e := range m.graph.GetEdges() {
+		queries = append(queries, gremlinAddEdge(e))
+	}
+	m.graph.RUnlock()
+
+	for _, q := range queries {
+		if _, err := m.client.Query(q); err != nil {
+			return err
+		}
+	}
+
+	logging.GetLogger().Infof("Graph mirrored to %s, %d elements", m.Endpoint, len(queries)-1)
+
+	return nil
+}
+
+func (m *GremlinMirror) run() {
+	defer m.wg.Done()
+
+	for {
+		if atomic.LoadInt32(&m.resync) == 1 {
+			if err := m.sync(); err != nil {
+				logging.GetLogger().Errorf("Unable to mirror the graph to %s: %s", m.Endpoint, err.Error())
+				atomic.StoreInt32(&m.resync, 1)
+
+				select {
+				case <-time.After(gremlinMirrorRetryPeriod):
+					continue
+				case <-m.quit:
+					return
+				}
+			}
+		}
+
+		select {
+		case q := <-m.queries:
+			if _, err := m.client.Query(q); err != nil {
+				logging.GetLogger().Errorf("Unable to mirror a change to %s, resynchronizing: %s", m.Endpoint, err.Error())
+				atomic.StoreInt32(&m.resync, 1)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+func (m *GremlinMirror) Start() {
+	m.graph.AddEventListenerWithPriority(m, ListenerPriorityFromConfig("mirror", ExportListenerPriority))
+
+	m.wg.Add(1)
+	go m.run()
+}
+
+func (m *GremlinMirror) Stop() {
+	m.graph.RemoveEventListener(m)
+
+	m.quit <- true
+	m.wg.Wait()
+
+	if m.connected {
+		m.client.Close()
+	}
+}
+
+func NewGremlinMirror(g *Graph, endpoint string) (*GremlinMirror, error) {
+	c, err := gremlin.NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GremlinMirror{
+		Endpoint: endpoint,
+		graph:    g,
+		client:   c,
+		queries:  make(chan string, gremlinMirrorQueueSize),
+		resync:   1,
+		quit:     make(chan bool, 1),
+	}, nil
+}
+
+// GremlinMirrorFromConfig returns a mirror if an endpoint is configured, nil
+// otherwise
+func GremlinMirrorFromConfig(g *Graph) (*GremlinMirror, error) {
+	endpoint := config.GetConfig().GetString("graph.mirror.gremlin")
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	return NewGremlinMirror(g, endpoint)
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReachabilityEdges are the edges followed by default to compute the
+// reachability between nodes, the layer2 links. Ownership or membership
+// relations don't imply any connectivity.
+var ReachabilityEdges = Metadata{"RelationType": "layer2"}
+
+// Direction of the edges followed by the path and reachability lookups,
+// DirectionOut follows the edges from the parent to the child.
+type Direction int
+
+const (
+	DirectionBoth Direction = iota
+	DirectionOut
+	DirectionIn
+)
+
+// ParseDirection returns the direction named both, out or in
+func ParseDirection(s string) (Direction, error) {
+	switch strings.ToLower(s) {
+	case "both":
+		return DirectionBoth, nil
+	case "out":
+		return DirectionOut, nil
+	case "in":
+		return DirectionIn, nil
+	}
+	return DirectionBoth, fmt.Errorf("Unknown direction %s, expected both, out or in", s)
+}
+
+// peer returns the other end of the edge if it can be followed from n
+func (d Direction) peer(e *Edge, n Identifier) (Identifier, bool) {
+	if e.parent == n && d != DirectionIn {
+		return e.child, true
+	}
+	if e.child == n && d != DirectionOut {
+		return e.parent, true
+	}
+	return "", false
+}
+
+func reachabilityEdges(em []Metadata) Metadata {
+	if len(em) > 0 {
+		return em[0]
+	}
+	return ReachabilityEdges
+}
+
+// walk visits in breadth first order the nodes reachable from the given
+// nodes, following the edges matching m in the direction d. It stops at the
+// first node for which found returns true. The visited nodes are mapped to
+// their predecessor.
+func (g *Graph) walk(from []*Node, d Direction, m Metadata, found func(n *Node) bool) (map[Identifier]Identifier, []*Node, *Node) {
+	prev := make(map[Identifier]Identifier)
+	visited := []*Node{}
+	for _, n := range from {
+		if _, ok := prev[n.ID]; !ok {
+			prev[n.ID] = ""
+			visited = append(visited, n)
+		}
+	}
+
+	for i := 0; i < len(visited); i++ {
+		n := visited[i]
+		if found != nil && found(n) {
+			return prev, visited, n
+		}
+
+		for _, e := range g.backend.GetNodeEdges(n) {
+			if !e.matchMetadata(m) {
+				continue
+			}
+
+			peer, ok := d.peer(e, n.ID)
+			if !ok {
+				continue
+			}
+
+			if _, ok := prev[peer]; ok {
+				continue
+			}
+
+			if p := g.backend.GetNode(peer); p != nil {
+				prev[peer] = n.ID
+				visited = append(visited, p)
+			}
+		}
+	}
+
+	return prev, visited, nil
+}
+
+// LookupReachableNodes returns the identifiers of the nodes reachable from
+// the given nodes, the given nodes included. Edges are followed in both
+// directions, only the ones matching the em metadata, ReachabilityEdges if
+// not specified.
+func (g *Graph) LookupReachableNodes(from []*Node, em ...Metadata) map[Identifier]bool {
+	_, visited, _ := g.walk(from, DirectionBoth, reachabilityEdges(em), nil)
+
+	reachable := make(map[Identifier]bool)
+	for _, n := range visited {
+		reachable[n.ID] = true
+	}
+
+	return reachable
+}
+
+// Reachable returns the nodes reachable from the node, nearest first,
+// following the edges matching the em metadata, ReachabilityEdges if not
+// specified, in the direction d.
+func (g *Graph) Reachable(from *Node, d Direction, em ...Metadata) []*Node {
+	_, visited, _ := g.walk([]*Node{from}, d, reachabilityEdges(em), nil)
+	return visited[1:]
+}
+
+func (g *Graph) shortestPath(from *Node, to func(n *Node) bool, d Direction, em []Metadata) []*Node {
+	prev, _, last := g.walk([]*Node{from}, d, reachabilityEdges(em), to)
+	if last == nil {
+		return []*Node{}
+	}
+
+	path := []*Node{last}
+	for id := prev[last.ID]; id != ""; id = prev[id] {
+		path = append([]*Node{g.backend.GetNode(id)}, path...)
+	}
+	return path
+}
+
+// ShortestPath returns the path with the fewest hops from one node to
+// another, following the edges matching the em metadata, ReachabilityEdges
+// if not specified, in the direction d. The path is empty if to is not
+// reachable.
+func (g *Graph) ShortestPath(from *Node, to *Node, d Direction, em ...Metadata) []*Node {
+	return g.shortestPath(from, func(n *Node) bool { return n.ID == to.ID }, d, em)
+}
+
+// ShortestPathToMatch returns the shortest path from the node to the
+// nearest node matching m, see ShortestPath.
+func (g *Graph) ShortestPathToMatch(from *Node, m Metadata, d Direction, em ...Metadata) []*Node {
+	return g.shortestPath(from, func(n *Node) bool { return n.matchMetadata(m) }, d, em)
+}
+
+// SubGraph is a set of nodes and of the edges between them
+type SubGraph struct {
+	Nodes []*Node
+	Edges []*Edge
+}
+
+// Neighborhood returns the subgraph of the nodes at most depth hops away
+// from the given nodes, following in both directions the edges matching the
+// em metadata, all of them if not specified. The subgraph holds all the
+// edges matching em between its nodes.
+func (g *Graph) Neighborhood(from []*Node, depth int, em ...Metadata) *SubGraph {
+	m := Metadata{}
+	if len(em) > 0 {
+		m = em[0]
+	}
+
+	sg := &SubGraph{Nodes: []*Node{}, Edges: []*Edge{}}
+	hops := make(map[Identifier]int)
+	for _, n := range from {
+		if _, ok := hops[n.ID]; !ok {
+			hops[n.ID] = 0
+			sg.Nodes = append(sg.Nodes, n)
+		}
+	}
+
+	for i := 0; i < len(sg.Nodes); i++ {
+		n := sg.Nodes[i]
+		if hops[n.ID] >= depth {
+			continue
+		}
+
+		for _, e := range g.backend.GetNodeEdges(n) {
+			if !e.matchMetadata(m) {
+				continue
+			}
+
+			peer, _ := DirectionBoth.peer(e, n.ID)
+			if _, ok := hops[peer]; ok {
+				continue
+			}
+
+			if p := g.backend.GetNode(peer); p != nil {
+				hops[peer] = hops[n.ID] + 1
+				sg.Nodes = append(sg.Nodes, p)
+			}
+		}
+	}
+
+	seen := make(map[Identifier]bool)
+	for _, n := range sg.Nodes {
+		for _, e := range g.backend.GetNodeEdges(n) {
+			if seen[e.ID] || !e.matchMetadata(m) {
+				continue
+			}
+
+			_, parent := hops[e.parent]
+			_, child := hops[e.child]
+			if parent && child {
+				seen[e.ID] = true
+				sg.Edges = append(sg.Edges, e)
+			}
+		}
+	}
+
+	return sg
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// the analyzers connect to their peers announcing themselves as analyzers
+func isReplicationPeer(c *shttp.WSClient) bool {
+	return c.ClientType() == shttp.AnalyzerClient
+}
+
+// Replicator sends the graph mutations to a peer analyzer, except the ones
+// replicated from the peers so that they don't loop between the analyzers.
+// The messages are queued and sent by a dedicated goroutine so that the
+// graph lock is never held while waiting for the connection.
+type Replicator struct {
+	shttp.DefaultWSClientEventHandler
+	Client *shttp.WSAsyncClient
+	Graph  *Graph
+	// revisions of the elements created locally, the ones the peer gets
+	// from this analyzer
+	revisions *revisionTracker
+	lock      sync.Mutex
+	cond      *sync.Cond
+	messages  []shttp.WSMessage
+	running   bool
+}
+
+func (r *Replicator) enqueue(msgs ...shttp.WSMessage) {
+	r.lock.Lock()
+	r.messages = append(r.messages, msgs...)
+	r.lock.Unlock()
+
+	r.cond.Signal()
+}
+
+func (r *Replicator) run() {
+	for {
+		r.lock.Lock()
+		for r.running && len(r.messages) == 0 {
+			r.cond.Wait()
+		}
+		if !r.running {
+			r.lock.Unlock()
+			return
+		}
+		msgs := r.messages
+		r.messages = nil
+		r.lock.Unlock()
+
+		for _, msg := range msgs {
+			r.Client.SendWSMessage(msg)
+		}
+	}
+}
+
+func (r *Replicator) send(msgType string, raw *json.RawMessage) {
+	r.enqueue(shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      msgType,
+		Obj:       silentRawMessage(r.Graph, raw),
+	})
+}
+
+// tracked returns whether the element has been created locally
+func (r *Replicator) tracked(id Identifier) bool {
+	_, ok := r.revisions.elements[id]
+	return ok
+}
+
+// OnConnected sends to the peer a full sync of the elements created locally,
+// in chunks. The peer applies it as a diff, the elements of the same hosts
+// missing from the sync being deleted, so that the changes made while
+// disconnected are reconciled. The chunks are queued before the following
+// mutations.
+func (r *Replicator) OnConnected() {
+	r.Graph.RLock()
+	defer r.Graph.RUnlock()
+
+	logging.GetLogger().Infof("Replicate the graph to %s:%d", r.Client.Addr, r.Client.Port)
+
+	accept := func(i interface{}) bool {
+		switch i.(type) {
+		case *Node:
+			return r.tracked(i.(*Node).ID)
+		case *Edge:
+			return r.tracked(i.(*Edge).ID)
+		}
+		return false
+	}
+
+	size := config.GetConfig().GetInt("graph.sync.chunk_size")
+
+	var msgs []shttp.WSMessage
+	for _, chunk := range r.revisions.sync(r.Graph, nil, size, accept, 0) {
+		b, _ := json.Marshal(chunk)
+		raw := json.RawMessage(b)
+
+		msgs = append(msgs, shttp.WSMessage{
+			Namespace: Namespace,
+			Type:      "SyncChunk",
+			Obj:       &raw,
+		})
+	}
+	r.enqueue(msgs...)
+}
+
+func (r *Replicator) OnNodeUpdated(n *Node) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	if r.tracked(n.ID) {
+		r.revisions.update(n.ID, n.host)
+	}
+	r.send("NodeUpdated", n.JsonRawMessage())
+}
+
+func (r *Replicator) OnNodeAdded(n *Node) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	r.revisions.update(n.ID, n.host)
+	r.send("NodeAdded", n.JsonRawMessage())
+}
+
+func (r *Replicator) OnNodeDeleted(n *Node) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	if r.tracked(n.ID) {
+		r.revisions.delete(n.ID, n.host, false)
+	}
+	r.send("NodeDeleted", n.JsonRawMessage())
+}
+
+func (r *Replicator) OnEdgeUpdated(e *Edge) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	if r.tracked(e.ID) {
+		r.revisions.update(e.ID, e.host)
+	}
+	r.send("EdgeUpdated", e.JsonRawMessage())
+}
+
+func (r *Replicator) OnEdgeAdded(e *Edge) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	r.revisions.update(e.ID, e.host)
+	r.send("EdgeAdded", e.JsonRawMessage())
+}
+
+func (r *Replicator) OnEdgeDeleted(e *Edge) {
+	if r.Graph.IsReplicating() {
+		return
+	}
+	if r.tracked(e.ID) {
+		r.revisions.delete(e.ID, e.host, true)
+	}
+	r.send("EdgeDeleted", e.JsonRawMessage())
+}
+
+func (r *Replicator) Start() {
+	r.lock.Lock()
+	r.running = true
+	r.lock.Unlock()
+
+	go r.run()
+
+	r.Graph.AddEventListenerWithPriority(r, ListenerPriorityFromConfig("replicator", DefaultListenerPriority))
+
+	r.Client.Connect()
+}
+
+func (r *Replicator) Stop() {
+	r.Graph.RemoveEventListener(r)
+
+	r.lock.Lock()
+	r.running = false
+	r.lock.Unlock()
+	r.cond.Broadcast()
+
+	r.Client.Disconnect()
+}
+
+func NewReplicator(c *shttp.WSAsyncClient, g *Graph) *Replicator {
+	r := &Replicator{
+		Client:    c,
+		Graph:     g,
+		revisions: newRevisionTracker(),
+	}
+	r.cond = sync.NewCond(&r.lock)
+	c.AddEventHandler(r)
+
+	return r
+}
+
+// NewReplicatorsFromConfig returns a replicator for each of the peers of
+// the analyzer
+func NewReplicatorsFromConfig(g *Graph) ([]*Replicator, error) {
+	peers, err := config.GetServiceAddresses("analyzer.peers")
+	if err != nil {
+		return nil, err
+	}
+
+	authOptions := &shttp.AuthenticationOpts{
+		Username: config.GetConfig().GetString("analyzer.peer_username"),
+		Password: config.GetConfig().GetString("analyzer.peer_password"),
+	}
+
+	tlsConfig, err := shttp.TLSConfigFromConfig("analyzer")
+	if err != nil {
+		return nil, err
+	}
+
+	var replicators []*Replicator
+	for _, peer := range peers {
+		authClient := shttp.NewAuthenticationClient(peer.Addr, peer.Port, authOptions)
+		authClient.TLSConfig = tlsConfig
+		c, err := shttp.NewWSAsyncClient(peer.Addr, peer.Port, "/ws", authClient)
+		if err != nil {
+			return nil, err
+		}
+		c.TLSConfig = tlsConfig
+		c.Type = shttp.AnalyzerClient
+		replicators = append(replicators, NewReplicator(c, g))
+	}
+
+	return replicators, nil
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+)
+
+// MetadataType is the expected kind of a metadata value
+type MetadataType string
+
+const (
+	StringMetadata MetadataType = "string"
+	NumberMetadata MetadataType = "number"
+	BoolMetadata   MetadataType = "bool"
+	MapMetadata    MetadataType = "map"
+	ListMetadata   MetadataType = "list"
+)
+
+// MetadataSchema gives the types of the metadata fields of the elements of
+// a Type, the fields not listed being free
+type MetadataSchema map[string]MetadataType
+
+// SchemaPolicy defines how the metadata updates not matching the schemas
+// are handled.
+type SchemaPolicy int
+
+const (
+	// IgnoreSchemas doesn't validate the metadata
+	IgnoreSchemas SchemaPolicy = iota
+	// LogSchemaViolations applies the updates, logging the inconsistencies
+	LogSchemaViolations
+	// RejectSchemaViolations refuses the updates
+	RejectSchemaViolations
+)
+
+type schemaRegistry struct {
+	sync.RWMutex
+	nodes map[string]MetadataSchema
+	edges map[string]MetadataSchema
+}
+
+var schemas = &schemaRegistry{
+	nodes: make(map[string]MetadataSchema),
+	edges: make(map[string]MetadataSchema),
+}
+
+func (r *schemaRegistry) register(schemas map[string]MetadataSchema, t string, s MetadataSchema) {
+	r.Lock()
+	defer r.Unlock()
+
+	merged, ok := schemas[t]
+	if !ok {
+		merged = make(MetadataSchema)
+		schemas[t] = merged
+	}
+	for k, v := range s {
+		merged[k] = v
+	}
+}
+
+// RegisterNodeSchema adds the fields of the schema to the ones expected for
+// the nodes of the Type, usually called by the probes creating them
+func RegisterNodeSchema(t string, s MetadataSchema) {
+	schemas.register(schemas.nodes, t, s)
+}
+
+// RegisterEdgeSchema adds the fields of the schema to the ones expected for
+// the edges of the RelationType
+func RegisterEdgeSchema(relationType string, s MetadataSchema) {
+	schemas.register(schemas.edges, relationType, s)
+}
+
+// NodeSchema returns the schema registered for the nodes of the Type
+func NodeSchema(t string) MetadataSchema {
+	schemas.RLock()
+	defer schemas.RUnlock()
+	return schemas.nodes[t]
+}
+
+// EdgeSchema returns the schema registered for the edges of the RelationType
+func EdgeSchema(relationType string) MetadataSchema {
+	schemas.RLock()
+	defer schemas.RUnlock()
+	return schemas.edges[relationType]
+}
+
+func (t MetadataType) matches(v interface{}) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.String:
+		return t == StringMetadata
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return t == NumberMetadata
+	case reflect.Bool:
+		return t == BoolMetadata
+	case reflect.Map:
+		return t == MapMetadata
+	case reflect.Slice, reflect.Array:
+		return t == ListMetadata
+	}
+	return false
+}
+
+// Validate returns an error if a value of the metadata doesn't have the
+// type expected by the schema
+func (s MetadataSchema) Validate(m Metadata) error {
+	for k, v := range m {
+		if t, ok := s[k]; ok && v != nil && !t.matches(v) {
+			return fmt.Errorf("%s should be a %s, got: %v", k, t, v)
+		}
+	}
+	return nil
+}
+
+func SchemaPolicyFromConfig() (SchemaPolicy, error) {
+	policy := config.GetConfig().GetString("graph.schema_policy")
+	switch policy {
+	case "", "ignore":
+		return IgnoreSchemas, nil
+	case "log":
+		return LogSchemaViolations, nil
+	case "reject":
+		return RejectSchemaViolations, nil
+	default:
+		return IgnoreSchemas, errors.New("Config file is misconfigured, schema policy unknown: " + policy)
+	}
+}
+
+func (g *Graph) SetSchemaPolicy(p SchemaPolicy) {
+	g.schemaPolicy = p
+}
+
+// schemaType returns the Type of a node or the RelationType of an edge, the
+// one of the metadata being applied or else the current one
+func schemaType(key string, current Metadata, m Metadata) string {
+	if t, ok := m[key].(string); ok {
+		return t
+	}
+	t, _ := current[key].(string)
+	return t
+}
+
+// validateMetadata returns whether the metadata of the element can be updated
+// with the values of m according to the schema of the element type
+func (g *Graph) validateMetadata(e interface{}, m Metadata) bool {
+	if g.schemaPolicy == IgnoreSchemas {
+		return true
+	}
+
+	var schema MetadataSchema
+	var id Identifier
+	switch e.(type) {
+	case *Node:
+		n := e.(*Node)
+		schema, id = NodeSchema(schemaType("Type", n.metadata, m)), n.ID
+	case *Edge:
+		edge := e.(*Edge)
+		schema, id = EdgeSchema(schemaType("RelationType", edge.metadata, m)), edge.ID
+	}
+
+	err := schema.Validate(m)
+	if err == nil {
+		return true
+	}
+
+	schemaViolations.Inc()
+	if g.schemaPolicy == RejectSchemaViolations {
+		logging.GetLogger().Warningf("Metadata update of %s rejected: %s", id, err.Error())
+		return false
+	}
+	logging.GetLogger().Warningf("Inconsistent metadata of %s: %s", id, err.Error())
+	return true
+}
@@ -24,7 +24,15 @@ package graph
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/redhat-cip/skydive/common"
+	"github.com/redhat-cip/skydive/config"
 	shttp "github.com/redhat-cip/skydive/http"
 	"github.com/redhat-cip/skydive/logging"
 )
@@ -35,12 +43,89 @@ const (
 
 type GraphServer struct {
 	shttp.DefaultWSServerEventHandler
-	WSServer *shttp.WSServer
-	Graph    *Graph
+	WSServer    *shttp.WSServer
+	Graph       *Graph
+	ChangeRates *ChangeRateTracker
+	queries     *continuousQueries
+	acks        *ackBatcher
+	Stats       *StatsDownsampler
+	revisions   *revisionTracker
+	disconnects *disconnectTracker
+	// the graph restored from a snapshot is replayed, the modifications
+	// of the clients being refused
+	replay bool
+	// events broadcasted at once while applying a batch
+	batch []batchedMessage
+	// updates merged during the coalescing window, nil if disabled
+	coalescer *updateCoalescer
+	// metadata values larger than this size, in bytes, are sent as
+	// references, 0 disables it
+	LazyThreshold int
+	// elements flagged as hidden, to detect the changes of the flag
+	hidden map[Identifier]bool
+	// elements received so far from the peer analyzers sending a full sync
+	peerSyncs map[*shttp.WSClient]map[Identifier]bool
+}
+
+// Subscriber describes a client receiving the events of a node, either
+// broadcasted or as part of continuous query results.
+type Subscriber struct {
+	Host              string
+	RemoteAddr        string
+	Params            url.Values
+	Events            bool
+	ContinuousQueries []string `json:",omitempty"`
+}
+
+// BatchOperationResult is the result of an operation of a BestEffortBatch
+type BatchOperationResult struct {
+	Type    string
+	ID      Identifier `json:",omitempty"`
+	Success bool
+	Error   string `json:",omitempty"`
+}
+
+var (
+	errElementNotFound     = errors.New("Element not found")
+	errElementExists       = errors.New("Element already exists")
+	errConditionNotMatched = errors.New("Condition not matched")
+)
+
+// properties of a graph event used to select the clients to notify
+type graphEvent struct {
+	hidden bool
+	// sent only to the clients not getting the hidden elements, when the
+	// hidden flag of an element changes
+	visibleOnly bool
+	rate        float64
+	host        string
+	// nodes on which the metadata filters of the clients apply, the node
+	// itself or the nodes of an edge
+	nodes []*Node
+}
+
+func nodeEvent(n *Node, rate float64) graphEvent {
+	return graphEvent{hidden: n.Hidden(), rate: rate, host: n.host, nodes: []*Node{n}}
+}
+
+func (s *GraphServer) edgeEvent(e *Edge) graphEvent {
+	ev := graphEvent{hidden: s.Graph.IsEdgeHidden(e), host: e.host}
+	for _, id := range []Identifier{e.parent, e.child} {
+		if n := s.Graph.GetNode(id); n != nil {
+			ev.nodes = append(ev.nodes, n)
+		}
+	}
+	return ev
+}
+
+type batchedMessage struct {
+	message shttp.WSMessage
+	event   graphEvent
 }
 
 func UnmarshalWSMessage(msg shttp.WSMessage) (string, interface{}, error) {
-	if msg.Type == "SyncRequest" {
+	switch msg.Type {
+	case "SyncRequest", "SyncChunk", "ContinuousQuery", "ContinuousQueryStop", "GetMetadataValue", "BestEffortBatch", "EdgeStats":
 		return msg.Type, msg, nil
 	}
 
@@ -75,126 +160,824 @@ func UnmarshalWSMessage(msg shttp.WSMessage) (string, interface{}, error) {
 	return "", msg, nil
 }
 
-func (s *GraphServer) OnMessage(c *shttp.WSClient, msg shttp.WSMessage) {
-	if msg.Namespace != Namespace {
-		return
+// privileged clients have to connect with the hidden parameter set to get
+// the hidden nodes and edges, ex: /ws?hidden=true
+func withHidden(c *shttp.WSClient) bool {
+	return c.Param("hidden") == "true" && c.Role().Allows(shttp.AdminRole)
+}
+
+// visibleTo returns whether the element of the event is visible to the
+// clients getting the hidden elements or not
+func (ev graphEvent) visibleTo(withHidden bool) bool {
+	if withHidden {
+		return !ev.visibleOnly
 	}
+	return !ev.hidden
+}
 
-	s.Graph.Lock()
-	defer s.Graph.Unlock()
+// clients can request to be notified only of the nodes updated more than
+// a number of times per second, ex: /ws?changerate=5
+func minChangeRate(c *shttp.WSClient) float64 {
+	if r, err := strconv.ParseFloat(c.Param("changerate"), 64); err == nil {
+		return r
+	}
+	return 0
+}
 
-	msgType, obj, err := UnmarshalWSMessage(msg)
-	if err != nil {
-		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+// clients can subscribe only to the events of some elements, filtering on
+// the host which created them, on the namespace or on metadata values, ex:
+// /ws?host=node1&namespace=default&filter=Type:ovsbridge. The filters on
+// metadata apply to the nodes, the events of an edge being sent if both its
+// nodes match.
+func subscriptionFilter(c *shttp.WSClient) Metadata {
+	params := c.Params()
+
+	var filter Metadata
+	if ns := params.Get("namespace"); ns != "" {
+		filter = Metadata{"Namespace": ns}
+	}
+
+	for _, f := range params["filter"] {
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		if filter == nil {
+			filter = Metadata{}
+		}
+		filter[kv[0]] = kv[1]
+	}
+
+	return filter
+}
+
+// acceptElement returns whether the client is subscribed to the element of
+// the event, the change rate apart
+func acceptElement(c *shttp.WSClient, ev graphEvent) bool {
+	if isReplicationPeer(c) {
+		return false
+	}
+	if !ev.visibleTo(withHidden(c)) {
+		return false
+	}
+	if host := c.Param("host"); host != "" && ev.host != host {
+		return false
+	}
+	if filter := subscriptionFilter(c); filter != nil {
+		for _, n := range ev.nodes {
+			if !n.matchMetadata(filter) {
+				return false
+			}
+		}
+		return len(ev.nodes) > 0
+	}
+	return true
+}
+
+func acceptEvent(c *shttp.WSClient, ev graphEvent) bool {
+	if min := minChangeRate(c); min > 0 && ev.rate < min {
+		return false
+	}
+	return acceptElement(c, ev)
+}
+
+// acceptingClients returns the clients accepting the event. The filters on
+// metadata are matched while the graph lock is held, against the nodes as
+// they were when the event occurred, not in the WebSocket server goroutine.
+func (s *GraphServer) acceptingClients(ev graphEvent) map[*shttp.WSClient]bool {
+	clients := make(map[*shttp.WSClient]bool)
+	for _, c := range s.WSServer.GetClients() {
+		if acceptEvent(c, ev) {
+			clients[c] = true
+		}
+	}
+	return clients
+}
+
+// broadcastWSMessage broadcasts the message of an event to the clients
+// accepting it. Must be called with the graph lock held.
+func (s *GraphServer) broadcastWSMessage(msg shttp.WSMessage, ev graphEvent) {
+	if s.batch != nil {
+		s.batch = append(s.batch, batchedMessage{message: msg, event: ev})
 		return
 	}
 
-	switch msgType {
-	case "SyncRequest":
-		r, _ := json.Marshal(s.Graph)
-		raw := json.RawMessage(r)
+	clients := s.acceptingClients(ev)
+	s.WSServer.BroadcastFilteredWSMessage(msg, func(c *shttp.WSClient) bool {
+		return clients[c]
+	})
+}
 
-		reply := shttp.WSMessage{
-			Namespace: Namespace,
-			Type:      "SyncReply",
-			Obj:       &raw,
+// Subscribers returns the clients which would currently receive the events
+// of the node according to their filters and continuous queries. Must be
+// called with the graph lock held.
+func (s *GraphServer) Subscribers(n *Node) []Subscriber {
+	ev := nodeEvent(n, s.ChangeRates.Rate(n.ID, time.Now()))
+
+	subscribers := []Subscriber{}
+	for _, c := range s.WSServer.GetClients() {
+		events := acceptEvent(c, ev)
+		queries := s.queries.containing(c, n)
+		if !events && len(queries) == 0 {
+			continue
 		}
 
-		c.SendWSMessage(reply)
+		subscribers = append(subscribers, Subscriber{
+			Host:              c.Host(),
+			RemoteAddr:        c.RemoteAddr(),
+			Params:            c.Params(),
+			Events:            events,
+			ContinuousQueries: queries,
+		})
+	}
+
+	return subscribers
+}
+
+func (s *GraphServer) marshalGraph(c *shttp.WSClient) ([]byte, error) {
+	nodes := []*Node{}
+	for _, n := range s.Graph.GetNodes() {
+		if acceptElement(c, nodeEvent(n, 0)) {
+			nodes = append(nodes, lazyNode(n, s.LazyThreshold))
+		}
+	}
+
+	edges := []*Edge{}
+	for _, e := range s.Graph.GetEdges() {
+		if acceptElement(c, s.edgeEvent(e)) {
+			edges = append(edges, lazyEdge(e, s.LazyThreshold))
+		}
+	}
+
+	return json.Marshal(&struct {
+		Nodes []*Node
+		Edges []*Edge
+	}{
+		Nodes: nodes,
+		Edges: edges,
+	})
+}
+
+// a deletion message can carry a condition, the element is then deleted
+// only if its current metadata match the condition. An Ack or a Nack
+// message, containing the current element, is sent back with the UUID of
+// the request.
+func unmarshalCondition(msg shttp.WSMessage) Metadata {
+	var obj struct {
+		Condition Metadata
+	}
+
+	if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &obj) != nil {
+		return nil
+	}
+
+	return obj.Condition
+}
+
+// a mutation can be flagged as silent, it is then applied and notified as
+// usual but not recorded by the history recorders.
+func unmarshalSilent(msg shttp.WSMessage) bool {
+	var obj struct {
+		Silent bool
+	}
+
+	if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &obj) != nil {
+		return false
+	}
+
+	return obj.Silent
+}
+
+// clients streaming mutations can ask for batched acknowledgements, every
+// mutation carrying an UUID is then acknowledged in an AckBatch message,
+// ex: /ws?ackbatch=true
+func withAckBatch(c *shttp.WSClient) bool {
+	return c.Param("ackbatch") == "true"
+}
+
+// ack records the result of a mutation for the clients using batched acks,
+// others are only replied for conditional deletions.
+func (s *GraphServer) ack(c *shttp.WSClient, msg shttp.WSMessage, ack bool) {
+	if msg.UUID != "" && withAckBatch(c) {
+		s.acks.add(c, msg.UUID, ack)
+	}
+}
+
+func (s *GraphServer) reply(c *shttp.WSClient, msg shttp.WSMessage, ack bool, obj *json.RawMessage) {
+	if msg.UUID != "" && withAckBatch(c) {
+		s.acks.add(c, msg.UUID, ack)
+		return
+	}
+
+	t := "Nack"
+	if ack {
+		t = "Ack"
+	}
+
+	c.SendWSMessage(shttp.WSMessage{
+		Namespace: Namespace,
+		Type:      t,
+		UUID:      msg.UUID,
+		Obj:       obj,
+	})
+}
 
+// applyMutation applies a mutation message to the graph, the element of the
+// graph is returned if found. Deletions are only applied if the element
+// matches the optional condition.
+func (s *GraphServer) applyMutation(msgType string, obj interface{}, condition Metadata) (interface{}, error) {
+	switch msgType {
 	case "SubGraphDeleted":
 		n := obj.(*Node)
 
 		logging.GetLogger().Debugf("Got SubGraphDeleted event from the node %s", n.ID)
 
 		node := s.Graph.GetNode(n.ID)
-		if node != nil {
-			s.Graph.DelSubGraph(node)
+		if node == nil {
+			return nil, errElementNotFound
 		}
+		s.Graph.DelSubGraph(node)
+		return node, nil
 	case "NodeUpdated":
 		n := obj.(*Node)
 		node := s.Graph.GetNode(n.ID)
-		if node != nil {
-			s.Graph.SetMetadata(node, n.metadata)
+		if node == nil {
+			return nil, errElementNotFound
 		}
+		s.Graph.SetMetadata(node, n.metadata)
+		return node, nil
 	case "NodeDeleted":
-		s.Graph.DelNode(obj.(*Node))
+		n := obj.(*Node)
+		node := s.Graph.GetNode(n.ID)
+		if node == nil {
+			return nil, errElementNotFound
+		}
+		if condition != nil && !node.matchMetadata(condition) {
+			return node, errConditionNotMatched
+		}
+		s.Graph.DelNode(node)
+		return node, nil
 	case "NodeAdded":
 		n := obj.(*Node)
-		if s.Graph.GetNode(n.ID) == nil {
-			s.Graph.AddNode(n)
+		if node := s.Graph.GetNode(n.ID); node != nil {
+			return node, errElementExists
 		}
+		s.Graph.AddNode(n)
+		return n, nil
 	case "EdgeUpdated":
 		e := obj.(*Edge)
 		edge := s.Graph.GetEdge(e.ID)
-		if edge != nil {
-			s.Graph.SetMetadata(edge, e.metadata)
+		if edge == nil {
+			return nil, errElementNotFound
 		}
+		s.Graph.SetMetadata(edge, e.metadata)
+		return edge, nil
 	case "EdgeDeleted":
-		s.Graph.DelEdge(obj.(*Edge))
+		e := obj.(*Edge)
+		edge := s.Graph.GetEdge(e.ID)
+		if edge == nil {
+			return nil, errElementNotFound
+		}
+		if condition != nil && !edge.matchMetadata(condition) {
+			return edge, errConditionNotMatched
+		}
+		s.Graph.DelEdge(edge)
+		return edge, nil
 	case "EdgeAdded":
 		e := obj.(*Edge)
-		if s.Graph.GetEdge(e.ID) == nil {
-			s.Graph.AddEdge(e)
+		if edge := s.Graph.GetEdge(e.ID); edge != nil {
+			return edge, errElementExists
 		}
+		s.Graph.AddEdge(e)
+		return e, nil
 	}
+
+	return nil, fmt.Errorf("Unknown operation type: %s", msgType)
 }
 
-func (s *GraphServer) OnNodeUpdated(n *Node) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
+// applyBatch applies the operations of a BestEffortBatch message one by one,
+// a failing operation doesn't prevent the next ones to be applied. The
+// events of the successful operations are broadcasted in a single Batch
+// message and the result of each operation is sent back in a
+// BestEffortBatchReply message.
+func (s *GraphServer) applyBatch(c *shttp.WSClient, msg shttp.WSMessage) {
+	var batch struct {
+		Operations []shttp.WSMessage
+	}
+	if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &batch) != nil {
+		s.reply(c, msg, false, nil)
+		return
+	}
+
+	s.batch = []batchedMessage{}
+	defer s.flushBatch()
+
+	results := make([]BatchOperationResult, len(batch.Operations))
+	for i, op := range batch.Operations {
+		results[i].Type = op.Type
+
+		if op.Obj == nil {
+			results[i].Error = "Operation without object"
+			continue
+		}
+
+		msgType, obj, err := UnmarshalWSMessage(op)
+		if err == nil {
+			obj, err = s.applyMutation(msgType, obj, unmarshalCondition(op))
+		}
+
+		switch obj.(type) {
+		case *Node:
+			results[i].ID = obj.(*Node).ID
+		case *Edge:
+			results[i].ID = obj.(*Edge).ID
+		}
+
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Success = true
+	}
+
+	b, _ := json.Marshal(results)
+	raw := json.RawMessage(b)
+
+	c.SendWSMessage(shttp.WSMessage{
 		Namespace: Namespace,
-		Type:      "NodeUpdated",
-		Obj:       n.JsonRawMessage(),
+		Type:      "BestEffortBatchReply",
+		UUID:      msg.UUID,
+		Obj:       &raw,
 	})
 }
 
-func (s *GraphServer) OnNodeAdded(n *Node) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
-		Namespace: Namespace,
-		Type:      "NodeAdded",
-		Obj:       n.JsonRawMessage(),
+// applyEdgeStats handles the lightweight stats path. The latest values of the
+// statistics are applied as silent metadata updates so that history
+// recorders only record, once per bucket, the summaries of the values
+// received during the buckets as a StatsSummary metadata.
+func (s *GraphServer) applyEdgeStats(msg shttp.WSMessage) bool {
+	var stats struct {
+		ID    Identifier
+		Stats map[string]interface{}
+	}
+	if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &stats) != nil {
+		return false
+	}
+
+	edge := s.Graph.GetEdge(stats.ID)
+	if edge == nil {
+		return false
+	}
+
+	now := time.Now()
+
+	m := make(Metadata)
+	for k, v := range edge.metadata {
+		m[k] = v
+	}
+
+	summaries := make(map[string]*StatsSummary)
+	for k, v := range stats.Stats {
+		m[k] = v
+
+		if f, err := common.ToFloat64(v); err == nil {
+			if summary := s.Stats.Add(edge.ID, k, f, now); summary != nil {
+				summaries[k] = summary
+			}
+		}
+	}
+
+	if len(summaries) == 0 {
+		s.Graph.SetSilent(true)
+		defer s.Graph.SetSilent(false)
+	} else {
+		m["StatsSummary"] = summaries
+	}
+	s.Graph.SetMetadata(edge, m)
+
+	return true
+}
+
+func (s *GraphServer) flushBatch() {
+	batch := s.batch
+	s.batch = nil
+
+	if len(batch) == 0 {
+		return
+	}
+
+	// the messages accepted by each client, see acceptingClients
+	accepted := make(map[*shttp.WSClient][]shttp.WSMessage)
+	for _, b := range batch {
+		for c := range s.acceptingClients(b.event) {
+			accepted[c] = append(accepted[c], b.message)
+		}
+	}
+
+	s.WSServer.BroadcastMappedWSMessage(func(c *shttp.WSClient) *shttp.WSMessage {
+		msgs := accepted[c]
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		data, _ := json.Marshal(msgs)
+		raw := json.RawMessage(data)
+
+		return &shttp.WSMessage{
+			Namespace: Namespace,
+			Type:      "Batch",
+			Obj:       &raw,
+		}
 	})
 }
 
+func (s *GraphServer) OnMessage(c *shttp.WSClient, msg shttp.WSMessage) {
+	s.Graph.Lock()
+	defer s.Graph.Unlock()
+
+	msgType, obj, err := UnmarshalWSMessage(msg)
+	if err != nil {
+		logging.GetLogger().Errorf("Graph: Unable to parse the event %v: %s", msg, err.Error())
+		return
+	}
+
+	if unmarshalSilent(msg) {
+		s.Graph.SetSilent(true)
+		defer s.Graph.SetSilent(false)
+	}
+
+	if isReplicationPeer(c) {
+		s.Graph.SetReplicating(true)
+		defer s.Graph.SetReplicating(false)
+	}
+
+	if isAgent(c) && !s.replay {
+		s.disconnects.reconnected(c.Host())
+	}
+
+	// only the agents and the peer analyzers authenticated as admins can
+	// modify the graph, the UIs being read-only
+	switch msgType {
+	case "BestEffortBatch", "EdgeStats", "SyncChunk", "SubGraphDeleted", "NodeUpdated", "NodeDeleted", "NodeAdded", "EdgeUpdated", "EdgeDeleted", "EdgeAdded":
+		if c.ClientType() == shttp.UIClient || !c.Role().Allows(shttp.AdminRole) {
+			logging.GetLogger().Warningf("Graph: %s refused to the client %s", msgType, c.RemoteAddr())
+			s.reply(c, msg, false, nil)
+			return
+		}
+
+		if s.replay {
+			logging.GetLogger().Debugf("Graph: %s of the client %s ignored while replaying a snapshot", msgType, c.RemoteAddr())
+			s.reply(c, msg, false, nil)
+			return
+		}
+	}
+
+	switch msgType {
+	case "SyncRequest":
+		// clients sending a request object get the graph in chunks
+		if msg.Obj != nil {
+			var req SyncRequest
+			if err := json.Unmarshal([]byte(*msg.Obj), &req); err != nil {
+				s.reply(c, msg, false, nil)
+				return
+			}
+			s.sendSyncChunks(c, msg, &req)
+			return
+		}
+
+		r, _ := s.marshalGraph(c)
+		raw := json.RawMessage(r)
+
+		reply := shttp.WSMessage{
+			Namespace: Namespace,
+			Type:      "SyncReply",
+			Obj:       &raw,
+		}
+
+		c.SendWSMessage(reply)
+
+	case "ContinuousQuery":
+		var q ContinuousQuery
+		if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &q) != nil || q.GremlinQuery == "" {
+			s.reply(c, msg, false, nil)
+			return
+		}
+
+		if err := s.queries.register(c, msg.UUID, q.GremlinQuery); err != nil {
+			logging.GetLogger().Errorf("Graph: Unable to register the continuous query %s: %s", q.GremlinQuery, err.Error())
+			s.reply(c, msg, false, nil)
+		}
+		return
+	case "ContinuousQueryStop":
+		s.queries.unregister(c, msg.UUID)
+		return
+	case "GetMetadataValue":
+		var v MetadataValue
+		if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &v) != nil {
+			s.reply(c, msg, false, nil)
+			return
+		}
+
+		value, ok := s.Graph.lookupMetadataValue(v.ID, v.Key)
+		if !ok {
+			s.reply(c, msg, false, nil)
+			return
+		}
+		v.Value = value
+
+		b, _ := json.Marshal(&v)
+		raw := json.RawMessage(b)
+
+		c.SendWSMessage(shttp.WSMessage{
+			Namespace: Namespace,
+			Type:      "GetMetadataValueReply",
+			UUID:      msg.UUID,
+			Obj:       &raw,
+		})
+		return
+	case "BestEffortBatch":
+		s.applyBatch(c, msg)
+	case "EdgeStats":
+		s.ack(c, msg, s.applyEdgeStats(msg))
+	case "SyncChunk":
+		if !isReplicationPeer(c) || !s.applyPeerSyncChunk(c, msg) {
+			logging.GetLogger().Errorf("Graph: Unable to apply the sync chunk of the client %s", c.RemoteAddr())
+		}
+	case "SubGraphDeleted", "NodeUpdated", "NodeDeleted", "NodeAdded", "EdgeUpdated", "EdgeDeleted", "EdgeAdded":
+		condition := unmarshalCondition(msg)
+
+		element, err := s.applyMutation(msgType, obj, condition)
+		if condition == nil {
+			s.ack(c, msg, err == nil)
+			return
+		}
+
+		var raw *json.RawMessage
+		switch element.(type) {
+		case *Node:
+			raw = element.(*Node).JsonRawMessage()
+		case *Edge:
+			raw = element.(*Edge).JsonRawMessage()
+		}
+		s.reply(c, msg, err == nil, raw)
+	}
+}
+
+func (s *GraphServer) broadcastNodeUpdated(n *Node, rate float64) {
+	s.broadcastWSMessage(lazyNode(n, s.LazyThreshold).wsMessage("NodeUpdated"), nodeEvent(n, rate))
+}
+
+func (s *GraphServer) broadcastEdgeUpdated(e *Edge) {
+	s.broadcastWSMessage(lazyEdge(e, s.LazyThreshold).wsMessage("EdgeUpdated"), s.edgeEvent(e))
+}
+
+// flushUpdates broadcasts the latest state of the elements updated during
+// the coalescing window
+func (s *GraphServer) flushUpdates() {
+	s.Graph.Lock()
+	defer s.Graph.Unlock()
+
+	now := time.Now()
+	for _, id := range s.coalescer.take() {
+		if n := s.Graph.GetNode(id); n != nil {
+			s.broadcastNodeUpdated(n, s.ChangeRates.Rate(n.ID, now))
+		} else if e := s.Graph.GetEdge(id); e != nil {
+			s.broadcastEdgeUpdated(e)
+		}
+	}
+}
+
+// setHidden records the hidden flag of an element and returns whether it
+// changed
+func (s *GraphServer) setHidden(id Identifier, hidden bool) bool {
+	if s.hidden[id] == hidden {
+		return false
+	}
+
+	if hidden {
+		s.hidden[id] = true
+	} else {
+		delete(s.hidden, id)
+	}
+	return true
+}
+
+// broadcastVisibility sends to the clients not getting the hidden elements
+// the deletion of a node which has been hidden, or the addition of a node
+// which is no longer, with its edges
+func (s *GraphServer) broadcastVisibility(n *Node) {
+	var edges []*Edge
+	for _, e := range s.Graph.GetNodeEdges(n, nil) {
+		if !e.Hidden() && s.nodesVisible(e, n) {
+			edges = append(edges, e)
+		}
+	}
+
+	if n.Hidden() {
+		for _, e := range edges {
+			s.broadcastEdgeVisibility(e, "EdgeDeleted")
+		}
+		s.broadcastNodeVisibility(n, "NodeDeleted")
+		return
+	}
+
+	s.broadcastNodeVisibility(n, "NodeAdded")
+	for _, e := range edges {
+		s.broadcastEdgeVisibility(e, "EdgeAdded")
+	}
+}
+
+// nodesVisible returns whether the nodes of an edge, the given one apart,
+// are not hidden
+func (s *GraphServer) nodesVisible(e *Edge, except *Node) bool {
+	for _, id := range []Identifier{e.parent, e.child} {
+		if except != nil && id == except.ID {
+			continue
+		}
+		if n := s.Graph.GetNode(id); n == nil || n.Hidden() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *GraphServer) broadcastNodeVisibility(n *Node, msgType string) {
+	ev := nodeEvent(n, s.ChangeRates.Rate(n.ID, time.Now()))
+	ev.hidden, ev.visibleOnly = false, true
+
+	s.broadcastWSMessage(lazyNode(n, s.LazyThreshold).wsMessage(msgType), ev)
+}
+
+func (s *GraphServer) broadcastEdgeVisibility(e *Edge, msgType string) {
+	ev := s.edgeEvent(e)
+	ev.hidden, ev.visibleOnly = false, true
+
+	s.broadcastWSMessage(lazyEdge(e, s.LazyThreshold).wsMessage(msgType), ev)
+}
+
+func (s *GraphServer) OnNodeUpdated(n *Node) {
+	s.revisions.update(n.ID, n.host)
+
+	if s.setHidden(n.ID, n.Hidden()) {
+		s.broadcastVisibility(n)
+	}
+
+	rate := s.ChangeRates.Update(n.ID, time.Now())
+
+	if s.coalescer != nil {
+		s.coalescer.add(n.ID, s.flushUpdates)
+	} else {
+		s.broadcastNodeUpdated(n, rate)
+	}
+
+	s.queries.touch(n.ID, true)
+}
+
+func (s *GraphServer) OnNodeAdded(n *Node) {
+	s.revisions.update(n.ID, n.host)
+	s.setHidden(n.ID, n.Hidden())
+
+	s.broadcastWSMessage(lazyNode(n, s.LazyThreshold).wsMessage("NodeAdded"), nodeEvent(n, s.ChangeRates.Rate(n.ID, time.Now())))
+
+	s.queries.touch(n.ID, true)
+}
+
 func (s *GraphServer) OnNodeDeleted(n *Node) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
-		Namespace: Namespace,
-		Type:      "NodeDeleted",
-		Obj:       n.JsonRawMessage(),
-	})
+	s.revisions.delete(n.ID, n.host, false)
+	s.setHidden(n.ID, false)
+	if s.coalescer != nil {
+		s.coalescer.drop(n.ID)
+	}
+	rate := s.ChangeRates.Rate(n.ID, time.Now())
+	s.ChangeRates.Delete(n.ID)
+
+	s.broadcastWSMessage(lazyNode(n, s.LazyThreshold).wsMessage("NodeDeleted"), nodeEvent(n, rate))
+
+	s.queries.touch(n.ID, true)
 }
 
 func (s *GraphServer) OnEdgeUpdated(e *Edge) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
-		Namespace: Namespace,
-		Type:      "EdgeUpdated",
-		Obj:       e.JsonRawMessage(),
-	})
+	s.revisions.update(e.ID, e.host)
+
+	if s.setHidden(e.ID, e.Hidden()) && s.nodesVisible(e, nil) {
+		if e.Hidden() {
+			s.broadcastEdgeVisibility(e, "EdgeDeleted")
+		} else {
+			s.broadcastEdgeVisibility(e, "EdgeAdded")
+		}
+	}
+
+	if s.coalescer != nil {
+		s.coalescer.add(e.ID, s.flushUpdates)
+	} else {
+		s.broadcastEdgeUpdated(e)
+	}
+
+	s.queries.touch(e.ID, false)
 }
 
 func (s *GraphServer) OnEdgeAdded(e *Edge) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
-		Namespace: Namespace,
-		Type:      "EdgeAdded",
-		Obj:       e.JsonRawMessage(),
-	})
+	s.revisions.update(e.ID, e.host)
+	s.setHidden(e.ID, e.Hidden())
+
+	s.broadcastWSMessage(lazyEdge(e, s.LazyThreshold).wsMessage("EdgeAdded"), s.edgeEvent(e))
+
+	s.queries.touch(e.ID, false)
 }
 
 func (s *GraphServer) OnEdgeDeleted(e *Edge) {
-	s.WSServer.BroadcastWSMessage(shttp.WSMessage{
-		Namespace: Namespace,
-		Type:      "EdgeDeleted",
-		Obj:       e.JsonRawMessage(),
-	})
+	s.revisions.delete(e.ID, e.host, true)
+	s.setHidden(e.ID, false)
+	s.Stats.Delete(e.ID)
+	if s.coalescer != nil {
+		s.coalescer.drop(e.ID)
+	}
+
+	s.broadcastWSMessage(lazyEdge(e, s.LazyThreshold).wsMessage("EdgeDeleted"), s.edgeEvent(e))
+
+	s.queries.touch(e.ID, false)
+}
+
+func (s *GraphServer) OnUnregisterClient(c *shttp.WSClient) {
+	s.queries.unregister(c, "")
+	s.acks.drop(c)
+
+	if isReplicationPeer(c) {
+		go func() {
+			s.Graph.Lock()
+			delete(s.peerSyncs, c)
+			s.Graph.Unlock()
+		}()
+	}
+
+	if !isAgent(c) {
+		return
+	}
+
+	// not blocking the WebSocket server while waiting for the graph lock
+	go func() {
+		s.Graph.Lock()
+		defer s.Graph.Unlock()
+
+		// the agent may already be connected again
+		for _, other := range s.WSServer.GetClients() {
+			if other != c && other.Host() == c.Host() && isAgent(other) {
+				return
+			}
+		}
+
+		if s.replay {
+			return
+		}
+
+		s.disconnects.disconnected(c.Host())
+	}()
+}
+
+// SetReplay enables or disables the replay mode, in which the graph is not
+// modified by the clients. Must be called with the graph lock held.
+func (s *GraphServer) SetReplay(replay bool) {
+	s.replay = replay
+}
+
+func (s *GraphServer) Replaying() bool {
+	return s.replay
 }
 
 func NewServer(g *Graph, server *shttp.WSServer) *GraphServer {
 	s := &GraphServer{
-		Graph:    g,
-		WSServer: server,
+		Graph:         g,
+		WSServer:      server,
+		ChangeRates:   NewChangeRateTracker(),
+		queries:       newContinuousQueries(g),
+		acks:          newAckBatcher(),
+		Stats:         StatsDownsamplerFromConfig(),
+		revisions:     newRevisionTracker(),
+		disconnects:   disconnectTrackerFromConfig(g),
+		LazyThreshold: config.GetConfig().GetInt("graph.lazy_metadata_threshold"),
+	}
+
+	g.RLock()
+	s.hidden = make(map[Identifier]bool)
+	s.peerSyncs = make(map[*shttp.WSClient]map[Identifier]bool)
+	for _, n := range g.GetNodes() {
+		if n.Hidden() {
+			s.hidden[n.ID] = true
+		}
+	}
+	for _, e := range g.GetEdges() {
+		if e.Hidden() {
+			s.hidden[e.ID] = true
+		}
+	}
+	g.RUnlock()
+
+	if window := config.GetConfig().GetInt("graph.coalesce_window"); window > 0 {
+		s.coalescer = newUpdateCoalescer(time.Duration(window) * time.Millisecond)
 	}
-	s.Graph.AddEventListener(s)
-	server.AddEventHandler(s)
+	s.Graph.AddEventListenerWithPriority(s, ListenerPriorityFromConfig("server", DefaultListenerPriority))
+	server.AddEventHandler(s, Namespace)
 
 	return s
 }
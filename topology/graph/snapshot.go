@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Snapshot is a copy of the whole graph, saved to be restored later
+type Snapshot struct {
+	Time  time.Time
+	Nodes []*Node
+	Edges []*Edge
+}
+
+// Snapshot writes the graph as JSON. Must be called with the graph lock
+// held.
+func (g *Graph) Snapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(&Snapshot{
+		Time:  time.Now().UTC(),
+		Nodes: g.GetNodes(),
+		Edges: g.GetEdges(),
+	})
+}
+
+// checkFields returns an error if the decoded element lacks one of the
+// string fields, the decoding of the elements expecting them
+func checkFields(obj interface{}, fields ...string) error {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid element: %v", obj)
+	}
+
+	for _, field := range fields {
+		if _, ok := m[field].(string); !ok {
+			return fmt.Errorf("Invalid element, %s missing: %v", field, obj)
+		}
+	}
+
+	if metadata, ok := m["Metadata"]; ok {
+		if _, ok := metadata.(map[string]interface{}); !ok {
+			return fmt.Errorf("Invalid element metadata: %v", obj)
+		}
+	}
+
+	return nil
+}
+
+// Restore replaces the elements of the graph by the ones of a snapshot,
+// the deletions and the additions being notified to the listeners. Must be
+// called with the graph lock held.
+func (g *Graph) Restore(r io.Reader) error {
+	var snapshot struct {
+		Nodes []interface{}
+		Edges []interface{}
+	}
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	nodes := make([]*Node, len(snapshot.Nodes))
+	for i, obj := range snapshot.Nodes {
+		nodes[i] = new(Node)
+		if err := checkFields(obj, "ID", "Host"); err != nil {
+			return err
+		}
+		if err := nodes[i].Decode(obj); err != nil {
+			return err
+		}
+	}
+
+	edges := make([]*Edge, len(snapshot.Edges))
+	for i, obj := range snapshot.Edges {
+		edges[i] = new(Edge)
+		if err := checkFields(obj, "ID", "Host", "Parent", "Child"); err != nil {
+			return err
+		}
+		if err := edges[i].Decode(obj); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range g.GetNodes() {
+		g.DelNode(n)
+	}
+
+	for _, n := range nodes {
+		g.AddNode(n)
+	}
+
+	for _, e := range edges {
+		if g.GetNode(e.parent) != nil && g.GetNode(e.child) != nil {
+			g.AddEdge(e)
+		}
+	}
+
+	return nil
+}
+
+// LoadSnapshot returns a graph made of the elements of a snapshot
+func LoadSnapshot(r io.Reader) (*Graph, error) {
+	b, err := NewMemoryBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := NewGraph(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.Restore(r); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+)
+
+const (
+	defaultStatsBucketSize = time.Minute
+)
+
+// StatsSummary aggregates the values of a statistic received during a time
+// bucket, Start and End are unix timestamps.
+type StatsSummary struct {
+	Start int64
+	End   int64
+	Min   float64
+	Max   float64
+	Avg   float64
+	Count int
+}
+
+type statsBucket struct {
+	start time.Time
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+// StatsDownsampler aggregates the statistics of the edges in time buckets.
+// The size of the buckets can be configured per statistic, the keys of
+// BucketSizes being in lower case. A bucket is
+// closed, and its summary returned, when a value of a following bucket is
+// added.
+type StatsDownsampler struct {
+	sync.Mutex
+	DefaultBucketSize time.Duration
+	BucketSizes       map[string]time.Duration
+	buckets           map[Identifier]map[string]*statsBucket
+}
+
+func (b *statsBucket) summary(size time.Duration) *StatsSummary {
+	return &StatsSummary{
+		Start: b.start.Unix(),
+		End:   b.start.Add(size).Unix(),
+		Min:   b.min,
+		Max:   b.max,
+		Avg:   b.sum / float64(b.count),
+		Count: b.count,
+	}
+}
+
+func (d *StatsDownsampler) bucketSize(key string) time.Duration {
+	if size, ok := d.BucketSizes[strings.ToLower(key)]; ok {
+		return size
+	}
+	return d.DefaultBucketSize
+}
+
+// Add records a value of a statistic of an element and returns the summary
+// of the previous bucket if the value starts a new one.
+func (d *StatsDownsampler) Add(i Identifier, key string, v float64, now time.Time) *StatsSummary {
+	d.Lock()
+	defer d.Unlock()
+
+	size := d.bucketSize(key)
+	start := now.Truncate(size)
+
+	stats, ok := d.buckets[i]
+	if !ok {
+		stats = make(map[string]*statsBucket)
+		d.buckets[i] = stats
+	}
+
+	var summary *StatsSummary
+
+	b, ok := stats[key]
+	if ok && !b.start.Equal(start) {
+		summary = b.summary(size)
+		ok = false
+	}
+
+	if !ok {
+		b = &statsBucket{start: start, min: v, max: v}
+		stats[key] = b
+	}
+
+	if v < b.min {
+		b.min = v
+	}
+	if v > b.max {
+		b.max = v
+	}
+	b.sum += v
+	b.count++
+
+	return summary
+}
+
+func (d *StatsDownsampler) Delete(i Identifier) {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.buckets, i)
+}
+
+func NewStatsDownsampler(size time.Duration, sizes map[string]time.Duration) *StatsDownsampler {
+	return &StatsDownsampler{
+		DefaultBucketSize: size,
+		BucketSizes:       sizes,
+		buckets:           make(map[Identifier]map[string]*statsBucket),
+	}
+}
+
+// StatsDownsamplerFromConfig reads the bucket sizes, in seconds, from the
+// graph.stats_buckets section, the default key giving the size of the
+// statistics not listed.
+func StatsDownsamplerFromConfig() *StatsDownsampler {
+	size := defaultStatsBucketSize
+	sizes := make(map[string]time.Duration)
+
+	for k := range config.GetConfig().GetStringMap("graph.stats_buckets") {
+		s := time.Duration(config.GetConfig().GetInt("graph.stats_buckets."+k)) * time.Second
+		if s <= 0 {
+			continue
+		}
+
+		if k == "default" {
+			size = s
+		} else {
+			sizes[k] = s
+		}
+	}
+
+	return NewStatsDownsampler(size, sizes)
+}
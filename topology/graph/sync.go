@@ -0,0 +1,327 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/redhat-cip/skydive/config"
+	shttp "github.com/redhat-cip/skydive/http"
+)
+
+// SyncRequest is the optional object of a SyncRequest message. The graph is
+// then sent in SyncChunk messages of at most ChunkSize elements. A client
+// reconnecting sends the revisions of the last sync it received so that
+// only the elements modified or deleted since are sent.
+type SyncRequest struct {
+	ChunkSize int
+	Revisions *SyncRevisions `json:",omitempty"`
+}
+
+// SyncRevisions identifies the state of the graph of a server, the epoch
+// changing when the server restarts
+type SyncRevisions struct {
+	Epoch string
+	Hosts map[string]int64
+}
+
+// SyncChunk is a part of a sync, the client having to reset its graph with
+// the first chunk when Delta is false. The revisions are sent with the last
+// chunk.
+type SyncChunk struct {
+	Delta        bool
+	Nodes        []*Node
+	Edges        []*Edge
+	DeletedNodes []Identifier   `json:",omitempty"`
+	DeletedEdges []Identifier   `json:",omitempty"`
+	Last         bool           `json:",omitempty"`
+	Revisions    *SyncRevisions `json:",omitempty"`
+}
+
+type tombstone struct {
+	id   Identifier
+	host string
+	rev  int64
+	edge bool
+}
+
+// revisionTracker keeps, per host having created the elements, a revision
+// incremented on each change and the revision of the last change of each
+// element. The deletions are kept, up to maxTombstones, so that they can be
+// sent in delta syncs.
+type revisionTracker struct {
+	epoch         string
+	hosts         map[string]int64
+	elements      map[Identifier]int64
+	tombstones    []tombstone
+	maxTombstones int
+	// per host, revision up to which tombstones have been dropped
+	truncated map[string]int64
+}
+
+func (r *revisionTracker) update(id Identifier, host string) {
+	r.hosts[host]++
+	r.elements[id] = r.hosts[host]
+}
+
+func (r *revisionTracker) delete(id Identifier, host string, edge bool) {
+	r.hosts[host]++
+	delete(r.elements, id)
+
+	r.tombstones = append(r.tombstones, tombstone{id: id, host: host, rev: r.hosts[host], edge: edge})
+	if len(r.tombstones) > r.maxTombstones {
+		dropped := r.tombstones[0]
+		r.tombstones = r.tombstones[1:]
+		r.truncated[dropped.host] = dropped.rev
+	}
+}
+
+func (r *revisionTracker) revisions() *SyncRevisions {
+	hosts := make(map[string]int64, len(r.hosts))
+	for host, rev := range r.hosts {
+		hosts[host] = rev
+	}
+	return &SyncRevisions{Epoch: r.epoch, Hosts: hosts}
+}
+
+// canDelta returns whether the changes since the given revisions are all
+// known
+func (r *revisionTracker) canDelta(since *SyncRevisions) bool {
+	if since == nil || since.Epoch != r.epoch {
+		return false
+	}
+	for host, rev := range since.Hosts {
+		if r.truncated[host] > rev || r.hosts[host] < rev {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *revisionTracker) changedSince(id Identifier, host string, since *SyncRevisions) bool {
+	return since == nil || r.elements[id] > since.Hosts[host]
+}
+
+// sync returns the chunks of a full sync, or of a delta one when possible,
+// of the elements accepted by the filter
+func (r *revisionTracker) sync(g *Graph, since *SyncRevisions, size int, accept func(interface{}) bool, lazyThreshold int) []*SyncChunk {
+	if !r.canDelta(since) {
+		since = nil
+	}
+
+	chunk := &SyncChunk{Delta: since != nil, Nodes: []*Node{}, Edges: []*Edge{}}
+	chunks := []*SyncChunk{chunk}
+	count := 0
+	next := func() {
+		if count++; size > 0 && count > size {
+			chunk = &SyncChunk{Delta: chunk.Delta, Nodes: []*Node{}, Edges: []*Edge{}}
+			chunks = append(chunks, chunk)
+			count = 1
+		}
+	}
+
+	// in a delta sync, the elements modified since the last sync and no more
+	// accepted, filtered or hidden, are deleted from the client
+	for _, n := range g.GetNodes() {
+		if !r.changedSince(n.ID, n.host, since) {
+			continue
+		}
+
+		if accept(n) {
+			next()
+			chunk.Nodes = append(chunk.Nodes, lazyNode(n, lazyThreshold))
+		} else if since != nil {
+			next()
+			chunk.DeletedNodes = append(chunk.DeletedNodes, n.ID)
+		}
+	}
+
+	for _, e := range g.GetEdges() {
+		if !r.changedSince(e.ID, e.host, since) {
+			continue
+		}
+
+		if accept(e) {
+			next()
+			chunk.Edges = append(chunk.Edges, lazyEdge(e, lazyThreshold))
+		} else if since != nil {
+			next()
+			chunk.DeletedEdges = append(chunk.DeletedEdges, e.ID)
+		}
+	}
+
+	if since != nil {
+		for _, t := range r.tombstones {
+			if t.rev <= since.Hosts[t.host] {
+				continue
+			}
+
+			next()
+			if t.edge {
+				chunk.DeletedEdges = append(chunk.DeletedEdges, t.id)
+			} else {
+				chunk.DeletedNodes = append(chunk.DeletedNodes, t.id)
+			}
+		}
+	}
+
+	chunk.Last = true
+	chunk.Revisions = r.revisions()
+
+	return chunks
+}
+
+func newRevisionTracker() *revisionTracker {
+	return &revisionTracker{
+		epoch:         string(GenID()),
+		hosts:         make(map[string]int64),
+		elements:      make(map[Identifier]int64),
+		maxTombstones: config.GetConfig().GetInt("graph.sync.tombstones"),
+		truncated:     make(map[string]int64),
+	}
+}
+
+// sendSyncChunks replies to a SyncRequest carrying an object
+func (s *GraphServer) sendSyncChunks(c *shttp.WSClient, msg shttp.WSMessage, req *SyncRequest) {
+	size := req.ChunkSize
+	if size <= 0 {
+		size = config.GetConfig().GetInt("graph.sync.chunk_size")
+	}
+
+	accept := func(i interface{}) bool {
+		switch i.(type) {
+		case *Node:
+			return acceptElement(c, nodeEvent(i.(*Node), 0))
+		case *Edge:
+			return acceptElement(c, s.edgeEvent(i.(*Edge)))
+		}
+		return false
+	}
+
+	for _, chunk := range s.revisions.sync(s.Graph, req.Revisions, size, accept, s.LazyThreshold) {
+		b, _ := json.Marshal(chunk)
+		raw := json.RawMessage(b)
+
+		c.SendWSMessage(shttp.WSMessage{
+			Namespace: Namespace,
+			Type:      "SyncChunk",
+			UUID:      msg.UUID,
+			Obj:       &raw,
+		})
+	}
+}
+
+// peerSyncChunk is a SyncChunk whose elements are decoded afterwards
+type peerSyncChunk struct {
+	Delta        bool
+	Nodes        []interface{}
+	Edges        []interface{}
+	DeletedNodes []Identifier
+	DeletedEdges []Identifier
+	Last         bool
+	Revisions    *SyncRevisions
+}
+
+// applyPeerSyncChunk applies a chunk of the sync sent by a peer analyzer
+// when connecting. The elements are added or updated, the ones created by
+// the hosts of the peer but missing from a full sync being deleted once the
+// last chunk is received. Must be called with the graph lock held.
+func (s *GraphServer) applyPeerSyncChunk(c *shttp.WSClient, msg shttp.WSMessage) bool {
+	var chunk peerSyncChunk
+	if msg.Obj == nil || json.Unmarshal([]byte(*msg.Obj), &chunk) != nil {
+		return false
+	}
+
+	synced := s.peerSyncs[c]
+	if !chunk.Delta && synced == nil {
+		synced = make(map[Identifier]bool)
+		s.peerSyncs[c] = synced
+	}
+
+	for _, obj := range chunk.Nodes {
+		var n Node
+		if err := n.Decode(obj); err != nil {
+			return false
+		}
+
+		if node := s.Graph.GetNode(n.ID); node == nil {
+			s.Graph.AddNode(&n)
+		} else if !reflect.DeepEqual(node.metadata, n.metadata) {
+			s.Graph.SetMetadata(node, n.metadata)
+		}
+		if synced != nil {
+			synced[n.ID] = true
+		}
+	}
+
+	for _, obj := range chunk.Edges {
+		var e Edge
+		if err := e.Decode(obj); err != nil {
+			return false
+		}
+
+		if edge := s.Graph.GetEdge(e.ID); edge == nil {
+			s.Graph.AddEdge(&e)
+		} else if !reflect.DeepEqual(edge.metadata, e.metadata) {
+			s.Graph.SetMetadata(edge, e.metadata)
+		}
+		if synced != nil {
+			synced[e.ID] = true
+		}
+	}
+
+	for _, id := range chunk.DeletedEdges {
+		if e := s.Graph.GetEdge(id); e != nil {
+			s.Graph.DelEdge(e)
+		}
+	}
+
+	for _, id := range chunk.DeletedNodes {
+		if n := s.Graph.GetNode(id); n != nil {
+			s.Graph.DelNode(n)
+		}
+	}
+
+	if !chunk.Last || synced == nil {
+		return true
+	}
+	delete(s.peerSyncs, c)
+
+	if chunk.Revisions == nil {
+		return true
+	}
+
+	for _, e := range s.Graph.GetEdges() {
+		if _, ok := chunk.Revisions.Hosts[e.host]; ok && !synced[e.ID] {
+			s.Graph.DelEdge(e)
+		}
+	}
+	for _, n := range s.Graph.GetNodes() {
+		if _, ok := chunk.Revisions.Hosts[n.host]; ok && !synced[n.ID] {
+			s.Graph.DelNode(n)
+		}
+	}
+
+	return true
+}
@@ -56,6 +56,12 @@ type GraphTraversalShortestPath struct {
 	error          error
 }
 
+type GraphTraversalSubGraph struct {
+	GraphTraversal *GraphTraversal
+	subgraph       *SubGraph
+	error          error
+}
+
 type WithinMetadataMatcher struct {
 	list []interface{}
 }
@@ -191,6 +197,14 @@ func (sp *GraphTraversalShortestPath) Error() error {
 	return sp.error
 }
 
+func (sg *GraphTraversalSubGraph) Values() []interface{} {
+	return []interface{}{sg.subgraph}
+}
+
+func (sg *GraphTraversalSubGraph) Error() error {
+	return sg.error
+}
+
 func (tv *GraphTraversalV) ShortestPathTo(m Metadata, e ...Metadata) *GraphTraversalShortestPath {
 	if tv.error != nil {
 		return &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*Node{}, error: tv.error}
@@ -209,6 +223,124 @@ func (tv *GraphTraversalV) ShortestPathTo(m Metadata, e ...Metadata) *GraphTrave
 	return sp
 }
 
+// KShortestPathsTo returns the k shortest paths from each node to the nodes
+// matching m, weight is the edge metadata used as cost, can be empty.
+func (tv *GraphTraversalV) KShortestPathsTo(m Metadata, k int, weight string, e ...Metadata) *GraphTraversalShortestPath {
+	if tv.error != nil {
+		return &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*Node{}, error: tv.error}
+	}
+	sp := &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*Node{}}
+
+	for _, n := range tv.nodes {
+		sp.paths = append(sp.paths, tv.GraphTraversal.Graph.LookupKShortestPaths(n, m, k, weight, e...)...)
+	}
+	return sp
+}
+
+func (tv *GraphTraversalV) ArticulationPoints(s ...interface{}) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	metadata, err := sliceToMetadata(s...)
+	if err != nil {
+		return &GraphTraversalV{GraphTraversal: tv.GraphTraversal, error: err}
+	}
+
+	nodes, _ := tv.GraphTraversal.Graph.LookupArticulationPoints(tv.nodes, metadata)
+	return &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: nodes}
+}
+
+func (tv *GraphTraversalV) Bridges(s ...interface{}) *GraphTraversalE {
+	if tv.error != nil {
+		return &GraphTraversalE{GraphTraversal: tv.GraphTraversal, error: tv.error}
+	}
+
+	metadata, err := sliceToMetadata(s...)
+	if err != nil {
+		return &GraphTraversalE{GraphTraversal: tv.GraphTraversal, error: err}
+	}
+
+	_, edges := tv.GraphTraversal.Graph.LookupArticulationPoints(tv.nodes, metadata)
+	return &GraphTraversalE{GraphTraversal: tv.GraphTraversal, edges: edges}
+}
+
+func (tv *GraphTraversalV) reachableFrom(reachable bool, m Metadata, em ...Metadata) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	g := tv.GraphTraversal.Graph
+	nodes := g.LookupReachableNodes(g.LookupNodes(m), em...)
+
+	ntv := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*Node{}}
+	for _, n := range tv.nodes {
+		if nodes[n.ID] == reachable {
+			ntv.nodes = append(ntv.nodes, n)
+		}
+	}
+	return ntv
+}
+
+// ReachableFrom keeps the nodes reachable from the nodes matching m, see
+// LookupReachableNodes for the edges followed.
+func (tv *GraphTraversalV) ReachableFrom(m Metadata, em ...Metadata) *GraphTraversalV {
+	return tv.reachableFrom(true, m, em...)
+}
+
+// NotReachableFrom keeps the nodes not reachable from the nodes matching m.
+func (tv *GraphTraversalV) NotReachableFrom(m Metadata, em ...Metadata) *GraphTraversalV {
+	return tv.reachableFrom(false, m, em...)
+}
+
+// ShortestPath returns the shortest path from each node to the nearest node
+// matching m, see Graph.ShortestPath for the edges followed.
+func (tv *GraphTraversalV) ShortestPath(m Metadata, d Direction, em ...Metadata) *GraphTraversalShortestPath {
+	if tv.error != nil {
+		return &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*Node{}, error: tv.error}
+	}
+	sp := &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*Node{}}
+
+	for _, n := range tv.nodes {
+		if path := tv.GraphTraversal.Graph.ShortestPathToMatch(n, m, d, em...); len(path) > 0 {
+			sp.paths = append(sp.paths, path)
+		}
+	}
+	return sp
+}
+
+// Reachable returns the nodes reachable from the nodes, see Graph.Reachable.
+func (tv *GraphTraversalV) Reachable(d Direction, em ...Metadata) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	ntv := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*Node{}}
+	seen := make(map[Identifier]bool)
+	for _, n := range tv.nodes {
+		for _, r := range tv.GraphTraversal.Graph.Reachable(n, d, em...) {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				ntv.nodes = append(ntv.nodes, r)
+			}
+		}
+	}
+	return ntv
+}
+
+// Neighborhood returns the subgraph of the nodes at most depth hops away
+// from the nodes, see Graph.Neighborhood.
+func (tv *GraphTraversalV) Neighborhood(depth int, em ...Metadata) *GraphTraversalSubGraph {
+	if tv.error != nil {
+		return &GraphTraversalSubGraph{GraphTraversal: tv.GraphTraversal, subgraph: &SubGraph{}, error: tv.error}
+	}
+
+	return &GraphTraversalSubGraph{
+		GraphTraversal: tv.GraphTraversal,
+		subgraph:       tv.GraphTraversal.Graph.Neighborhood(tv.nodes, depth, em...),
+	}
+}
+
 func (tv *GraphTraversalV) hasKey(k string) *GraphTraversalV {
 	if tv.error != nil {
 		return tv
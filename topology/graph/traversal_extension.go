@@ -26,3 +26,13 @@ type GremlinTraversalExtension interface {
 	ScanIdent(s string) (Token, bool)
 	ParseStep(t Token, p GremlinTraversalStepParams) (GremlinTraversalStep, error)
 }
+
+// HasTraversalStep and DedupTraversalStep allow the steps of the extensions
+// to be filtered and deduplicated by the built in Has and Dedup steps
+type HasTraversalStep interface {
+	Has(s ...interface{}) (GraphTraversalStep, error)
+}
+
+type DedupTraversalStep interface {
+	Dedup() (GraphTraversalStep, error)
+}
@@ -55,8 +55,39 @@ type (
 	gremlinTraversalStepHas            struct{ params GremlinTraversalStepParams }
 	gremlinTraversalStepShortestPathTo struct{ params GremlinTraversalStepParams }
 	gremlinTraversalStepBoth           struct{ params GremlinTraversalStepParams }
+	gremlinTraversalStepArticulation   struct{ params GremlinTraversalStepParams }
+	gremlinTraversalStepBridges        struct{ params GremlinTraversalStepParams }
 )
 
+type gremlinTraversalStepReachableFrom struct {
+	reachable bool
+	metadata  Metadata
+	edges     []Metadata
+}
+
+type gremlinTraversalStepShortestPath struct {
+	metadata  Metadata
+	direction Direction
+	edges     []Metadata
+}
+
+type gremlinTraversalStepReachable struct {
+	direction Direction
+	edges     []Metadata
+}
+
+type gremlinTraversalStepNeighborhood struct {
+	depth int
+	edges []Metadata
+}
+
+type gremlinTraversalStepKShortestPathsTo struct {
+	metadata Metadata
+	k        int
+	weight   string
+	edges    []Metadata
+}
+
 var (
 	ExecutionError error = errors.New("Error while executing the query")
 )
@@ -102,6 +133,8 @@ func (s *gremlinTraversalStepHas) Exec(last GraphTraversalStep) (GraphTraversalS
 		return last.(*GraphTraversalV).Has(s.params...), nil
 	case *GraphTraversalE:
 		return last.(*GraphTraversalE).Has(s.params...), nil
+	case HasTraversalStep:
+		return last.(HasTraversalStep).Has(s.params...)
 	}
 
 	return nil, ExecutionError
@@ -113,6 +146,8 @@ func (s *gremlinTraversalStepDedup) Exec(last GraphTraversalStep) (GraphTraversa
 		return last.(*GraphTraversalV).Dedup(), nil
 	case *GraphTraversalE:
 		return last.(*GraphTraversalE).Dedup(), nil
+	case DedupTraversalStep:
+		return last.(DedupTraversalStep).Dedup()
 	}
 
 	return nil, ExecutionError
@@ -184,6 +219,15 @@ func (s *gremlinTraversalStepShortestPathTo) Exec(last GraphTraversalStep) (Grap
 	return nil, ExecutionError
 }
 
+func (s *gremlinTraversalStepKShortestPathsTo) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).KShortestPathsTo(s.metadata, s.k, s.weight, s.edges...), nil
+	}
+
+	return nil, ExecutionError
+}
+
 func (s *gremlinTraversalStepBoth) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
 	switch last.(type) {
 	case *GraphTraversalV:
@@ -193,6 +237,84 @@ func (s *gremlinTraversalStepBoth) Exec(last GraphTraversalStep) (GraphTraversal
 	return nil, ExecutionError
 }
 
+func (s *gremlinTraversalStepArticulation) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).ArticulationPoints(s.params...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+func (s *gremlinTraversalStepBridges) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Bridges(s.params...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+func (s *gremlinTraversalStepReachableFrom) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		if s.reachable {
+			return last.(*GraphTraversalV).ReachableFrom(s.metadata, s.edges...), nil
+		}
+		return last.(*GraphTraversalV).NotReachableFrom(s.metadata, s.edges...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+func (s *gremlinTraversalStepShortestPath) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).ShortestPath(s.metadata, s.direction, s.edges...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+func (s *gremlinTraversalStepNeighborhood) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Neighborhood(s.depth, s.edges...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+func (s *gremlinTraversalStepReachable) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Reachable(s.direction, s.edges...), nil
+	}
+
+	return nil, ExecutionError
+}
+
+// parseDirectionAndEdges parses the optional direction and edge Metadata
+// parameters of the path steps
+func parseDirectionAndEdges(step string, params GremlinTraversalStepParams) (Direction, []Metadata, error) {
+	direction, edges := DirectionBoth, []Metadata{}
+	for _, param := range params {
+		switch param.(type) {
+		case string:
+			d, err := ParseDirection(param.(string))
+			if err != nil {
+				return direction, nil, err
+			}
+			direction = d
+		case Metadata:
+			edges = []Metadata{param.(Metadata)}
+		default:
+			return direction, nil, fmt.Errorf("%s accept only a direction and an edge Metadata as optional parameters", step)
+		}
+	}
+	return direction, edges, nil
+}
+
 func (s *GremlinTraversalSequence) nextStepToExec(i int) (GremlinTraversalStep, int) {
 	step := s.steps[i]
 
@@ -277,6 +399,64 @@ func (s *GremlinTraversalSequence) Exec() (GraphTraversalStep, error) {
 	return res, nil
 }
 
+// filterLength returns the number of the first steps only filtering the
+// nodes of the graph, V followed by Has or Dedup steps, 0 if none
+func (s *GremlinTraversalSequence) filterLength() int {
+	if len(s.steps) == 0 {
+		return 0
+	}
+
+	v, ok := s.steps[0].(*gremlinTraversalStepV)
+	if !ok || len(v.params) > 1 {
+		return 0
+	}
+	if len(v.params) == 1 {
+		if _, ok := v.params[0].(string); !ok {
+			return 0
+		}
+	}
+
+	i := 1
+	for ; i < len(s.steps); i++ {
+		switch s.steps[i].(type) {
+		case *gremlinTraversalStepHas, *gremlinTraversalStepDedup:
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// NodeFilter returns whether the sequence only filters the nodes of the
+// graph, ex: G.V().Has('Type', 'netns'), a node being then part of its
+// result if MatchNode returns true
+func (s *GremlinTraversalSequence) NodeFilter() bool {
+	return len(s.steps) > 0 && s.filterLength() == len(s.steps)
+}
+
+// MatchNode returns whether the node is selected by the first steps of the
+// sequence filtering the nodes, ex: by G.V().Has('Name', 'br-int') for
+// G.V().Has('Name', 'br-int').Out(), without evaluating the whole sequence
+func (s *GremlinTraversalSequence) MatchNode(n *Node) bool {
+	l := s.filterLength()
+	if l == 0 {
+		return false
+	}
+
+	if v := s.steps[0].(*gremlinTraversalStepV); len(v.params) == 1 && Identifier(v.params[0].(string)) != n.ID {
+		return false
+	}
+
+	var last GraphTraversalStep = &GraphTraversalV{GraphTraversal: s.GraphTraversal, nodes: []*Node{n}}
+	for _, step := range s.steps[1:l] {
+		var err error
+		if last, err = step.Exec(last); err != nil {
+			return false
+		}
+	}
+	return len(last.Values()) == 1
+}
+
 func (p *GremlinTraversalParser) AddTraversalExtension(e GremlinTraversalExtension) {
 	p.extensions = append(p.extensions, e)
 }
@@ -392,8 +572,106 @@ func (p *GremlinTraversalParser) parserStep() (GremlinTraversalStep, error) {
 			return nil, fmt.Errorf("ShortestPathTo predicate accept only 1 or 2 parameters")
 		}
 		return &gremlinTraversalStepShortestPathTo{params: params}, nil
+	case KSHORTESTPATHSTO:
+		// KShortestPathsTo(Metadata, k, [weight], [edge Metadata])
+		if len(params) < 2 || len(params) > 4 {
+			return nil, fmt.Errorf("KShortestPathsTo predicate accept only 2 to 4 parameters")
+		}
+
+		m, ok := params[0].(Metadata)
+		if !ok {
+			return nil, fmt.Errorf("KShortestPathsTo first parameter has to be a Metadata")
+		}
+		k, ok := params[1].(int64)
+		if !ok || k <= 0 {
+			return nil, fmt.Errorf("KShortestPathsTo second parameter has to be a positive integer")
+		}
+
+		step := &gremlinTraversalStepKShortestPathsTo{metadata: m, k: int(k)}
+		for _, param := range params[2:] {
+			switch param.(type) {
+			case string:
+				step.weight = param.(string)
+			case Metadata:
+				step.edges = []Metadata{param.(Metadata)}
+			default:
+				return nil, fmt.Errorf("KShortestPathsTo accept only a weight and an edge Metadata as optional parameters")
+			}
+		}
+		return step, nil
 	case BOTH:
 		return &gremlinTraversalStepBoth{params: params}, nil
+	case ARTICULATIONPOINTS:
+		return &gremlinTraversalStepArticulation{params: params}, nil
+	case BRIDGES:
+		return &gremlinTraversalStepBridges{params: params}, nil
+	case SHORTESTPATH:
+		// ShortestPath(Metadata, [direction], [edge Metadata])
+		if len(params) == 0 || len(params) > 3 {
+			return nil, fmt.Errorf("ShortestPath predicate accept only 1 to 3 parameters")
+		}
+
+		m, ok := params[0].(Metadata)
+		if !ok {
+			return nil, fmt.Errorf("ShortestPath first parameter has to be a Metadata")
+		}
+
+		direction, edges, err := parseDirectionAndEdges(lit, params[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &gremlinTraversalStepShortestPath{metadata: m, direction: direction, edges: edges}, nil
+	case REACHABLE:
+		// Reachable([direction], [edge Metadata])
+		if len(params) > 2 {
+			return nil, fmt.Errorf("Reachable predicate accept only 0 to 2 parameters")
+		}
+
+		direction, edges, err := parseDirectionAndEdges(lit, params)
+		if err != nil {
+			return nil, err
+		}
+		return &gremlinTraversalStepReachable{direction: direction, edges: edges}, nil
+	case NEIGHBORHOOD:
+		// Neighborhood(depth, [edge Metadata])
+		if len(params) == 0 || len(params) > 2 {
+			return nil, fmt.Errorf("Neighborhood predicate accept only 1 or 2 parameters")
+		}
+
+		depth, ok := params[0].(int64)
+		if !ok || depth < 0 {
+			return nil, fmt.Errorf("Neighborhood first parameter has to be a depth")
+		}
+
+		step := &gremlinTraversalStepNeighborhood{depth: int(depth)}
+		if len(params) == 2 {
+			m, ok := params[1].(Metadata)
+			if !ok {
+				return nil, fmt.Errorf("Neighborhood second parameter has to be Metadata")
+			}
+			step.edges = []Metadata{m}
+		}
+		return step, nil
+	case REACHABLEFROM, NOTREACHABLEFROM:
+		// ReachableFrom(Metadata, [edge Metadata])
+		if len(params) == 0 || len(params) > 2 {
+			return nil, fmt.Errorf("%s predicate accept only 1 or 2 parameters", lit)
+		}
+
+		step := &gremlinTraversalStepReachableFrom{reachable: tok == REACHABLEFROM}
+		for i, param := range params {
+			m, ok := param.(Metadata)
+			if !ok {
+				return nil, fmt.Errorf("%s parameters have to be Metadata", lit)
+			}
+
+			if i == 0 {
+				step.metadata = m
+			} else {
+				step.edges = []Metadata{m}
+			}
+		}
+		return step, nil
 	}
 
 	// extensions
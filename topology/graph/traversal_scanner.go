@@ -64,8 +64,16 @@ const (
 	WITHOUT
 	METADATA
 	SHORTESTPATHTO
+	KSHORTESTPATHSTO
 	NE
 	BOTH
+	ARTICULATIONPOINTS
+	BRIDGES
+	REACHABLEFROM
+	NOTREACHABLEFROM
+	SHORTESTPATH
+	REACHABLE
+	NEIGHBORHOOD
 
 	// extensions token have to start after 1000
 )
@@ -211,10 +219,26 @@ func (s *GremlinTraversalScanner) scanIdent() (tok Token, lit string) {
 		return METADATA, buf.String()
 	case "SHORTESTPATHTO":
 		return SHORTESTPATHTO, buf.String()
+	case "KSHORTESTPATHSTO":
+		return KSHORTESTPATHSTO, buf.String()
 	case "NE":
 		return NE, buf.String()
 	case "BOTH":
 		return BOTH, buf.String()
+	case "ARTICULATIONPOINTS":
+		return ARTICULATIONPOINTS, buf.String()
+	case "BRIDGES":
+		return BRIDGES, buf.String()
+	case "REACHABLEFROM":
+		return REACHABLEFROM, buf.String()
+	case "NOTREACHABLEFROM":
+		return NOTREACHABLEFROM, buf.String()
+	case "SHORTESTPATH":
+		return SHORTESTPATH, buf.String()
+	case "REACHABLE":
+		return REACHABLE, buf.String()
+	case "NEIGHBORHOOD":
+		return NEIGHBORHOOD, buf.String()
 	}
 
 	for _, e := range s.extensions {
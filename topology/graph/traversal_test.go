@@ -25,6 +25,7 @@ package graph
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func newTrasversalGraph(t *testing.T) *Graph {
@@ -318,3 +319,361 @@ func TestTraversalParser(t *testing.T) {
 		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
 	}
 }
+
+func TestArticulationPoints(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3})
+	n4 := g.NewNode(GenID(), Metadata{"Value": 4})
+	n5 := g.NewNode(GenID(), Metadata{"Value": 5})
+	n6 := g.NewNode(GenID(), Metadata{"Value": 6})
+
+	e1 := g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+	g.NewEdge(GenID(), n2, n3, Metadata{"RelationType": "layer2"})
+	g.NewEdge(GenID(), n3, n4, Metadata{"RelationType": "layer2"})
+	g.NewEdge(GenID(), n4, n2, Metadata{"RelationType": "layer2"})
+	e2 := g.NewEdge(GenID(), n4, n5, Metadata{"RelationType": "layer2"})
+
+	// parallel edges are not bridges
+	g.NewEdge(GenID(), n5, n6, Metadata{"RelationType": "layer2"})
+	g.NewEdge(GenID(), n5, n6, Metadata{"RelationType": "layer2"})
+
+	// this edge is not followed when filtering on layer2
+	g.NewEdge(GenID(), n1, n5, Metadata{"RelationType": "ownership"})
+
+	nodes, edges := g.LookupArticulationPoints(g.GetNodes(), Metadata{"RelationType": "layer2"})
+	if len(nodes) != 3 {
+		t.Fatalf("Should return 3 articulation points, returned: %v", nodes)
+	}
+	for _, n := range nodes {
+		if n.ID != n2.ID && n.ID != n4.ID && n.ID != n5.ID {
+			t.Errorf("Wrong articulation point returned: %v", n)
+		}
+	}
+
+	if len(edges) != 2 {
+		t.Fatalf("Should return 2 bridges, returned: %v", edges)
+	}
+	for _, e := range edges {
+		if e.ID != e1.ID && e.ID != e2.ID {
+			t.Errorf("Wrong bridge returned: %v", e)
+		}
+	}
+
+	// all the edges followed, a cycle is created through n1 and n5
+	nodes, _ = g.LookupArticulationPoints(g.GetNodes())
+	if len(nodes) != 1 || nodes[0].ID != n5.ID {
+		t.Fatalf("Should return 1 articulation point, returned: %v", nodes)
+	}
+
+	res := execTraversalQuery(t, g, `G.V().ArticulationPoints("RelationType", "layer2")`)
+	if len(res.Values()) != 3 {
+		t.Fatalf("Should return 3 nodes, returned: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Bridges("RelationType", "layer2")`)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 edges, returned: %v", res.Values())
+	}
+
+	g.MarkArticulationPoints(Metadata{"RelationType": "layer2"})
+	if n2.Metadata()["ArticulationPoint"] != true || e1.Metadata()["Bridge"] != true {
+		t.Error("Articulation metadata not set")
+	}
+	if _, ok := n1.Metadata()["ArticulationPoint"]; ok {
+		t.Error("Articulation metadata shouldn't be set")
+	}
+
+	g.MarkArticulationPoints()
+	if _, ok := n2.Metadata()["ArticulationPoint"]; ok {
+		t.Error("Articulation metadata should have been removed")
+	}
+}
+
+func TestArticulationMarker(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3})
+	g.NewEdge(GenID(), n1, n2, Metadata{"RelationType": "layer2"})
+	g.NewEdge(GenID(), n2, n3, Metadata{"RelationType": "layer2"})
+
+	marker := NewArticulationMarker(g, 10*time.Millisecond, Metadata{"RelationType": "layer2"})
+	marker.Start()
+	defer marker.Stop()
+
+	for i := 0; i < 100; i++ {
+		g.RLock()
+		marked := n2.Metadata()["ArticulationPoint"] == true
+		g.RUnlock()
+		if marked {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Articulation point not marked")
+}
+
+func TestContinuousQuery(t *testing.T) {
+	g := newTrasversalGraph(t)
+
+	seq, err := NewGremlinTraversalParser(strings.NewReader(`G.V().Has("Type", "intf")`), g).Parse()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	q := &ContinuousQuery{sequence: seq, members: make(map[string]interface{})}
+
+	delta, err := q.eval()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(delta.Added) != 2 || len(delta.Removed) != 0 {
+		t.Fatalf("Initial result should contain 2 nodes, got: %v", delta)
+	}
+
+	n5 := g.NewNode(GenID(), Metadata{"Value": 5, "Type": "intf"})
+	delta, _ = q.eval()
+	if len(delta.Added) != 1 || delta.Added[0].(*Node).ID != n5.ID || len(delta.Removed) != 0 {
+		t.Fatalf("Only the new node should be added, got: %v", delta)
+	}
+
+	g.DelNode(n5)
+	delta, _ = q.eval()
+	if len(delta.Added) != 0 || len(delta.Removed) != 1 || delta.Removed[0].(*Node).ID != n5.ID {
+		t.Fatalf("Only the deleted node should be removed, got: %v", delta)
+	}
+
+	delta, _ = q.eval()
+	if len(delta.Added) != 0 || len(delta.Removed) != 0 {
+		t.Fatalf("Result set should not have changed, got: %v", delta)
+	}
+}
+
+func TestContinuousQueryNodeFilter(t *testing.T) {
+	g := newTrasversalGraph(t)
+
+	seq, err := NewGremlinTraversalParser(strings.NewReader(`G.V().Has("Type", "intf")`), g.Visible()).Parse()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	q := &ContinuousQuery{sequence: seq, members: make(map[string]interface{})}
+
+	if !q.sequence.NodeFilter() {
+		t.Fatal("Query should be evaluated as a node filter")
+	}
+
+	if delta, _ := q.eval(); len(delta.Added) != 2 {
+		t.Fatalf("Initial result should contain 2 nodes, got: %v", delta)
+	}
+
+	// only the touched nodes are evaluated
+	n5 := g.NewNode(GenID(), Metadata{"Value": 5, "Type": "intf"})
+	n6 := g.NewNode(GenID(), Metadata{"Value": 6, "Type": "intf"})
+	delta := q.evalNodes(map[Identifier]bool{n5.ID: true})
+	if len(delta.Added) != 1 || delta.Added[0].(*Node).ID != n5.ID || len(delta.Removed) != 0 {
+		t.Fatalf("Only the touched node should be added, got: %v", delta)
+	}
+
+	// a node leaving the visible graph is removed from the result set
+	g.AddMetadata(n5, "Hidden", true)
+	delta = q.evalNodes(map[Identifier]bool{n5.ID: true, n6.ID: true})
+	if len(delta.Added) != 1 || delta.Added[0].(*Node).ID != n6.ID || len(delta.Removed) != 1 || delta.Removed[0].(*Node).ID != n5.ID {
+		t.Fatalf("Hidden node should be removed, got: %v", delta)
+	}
+
+	g.AddMetadata(n6, "Type", "device")
+	delta = q.evalNodes(map[Identifier]bool{n6.ID: true})
+	if len(delta.Added) != 0 || len(delta.Removed) != 1 || delta.Removed[0].(*Node).ID != n6.ID {
+		t.Fatalf("Node not matching anymore should be removed, got: %v", delta)
+	}
+
+	seq, _ = NewGremlinTraversalParser(strings.NewReader(`G.V().Has("Type", "intf").Out()`), g).Parse()
+	if seq.NodeFilter() {
+		t.Error("Traversal query shouldn't be evaluated as a node filter")
+	}
+	if !seq.MatchNode(n5) || seq.MatchNode(n6) {
+		t.Error("Only the nodes matching the first steps should match")
+	}
+}
+
+func TestKShortestPaths(t *testing.T) {
+	g := newGraph(t)
+
+	n1 := g.NewNode(GenID(), Metadata{"Name": "n1"})
+	n2 := g.NewNode(GenID(), Metadata{"Name": "n2"})
+	n3 := g.NewNode(GenID(), Metadata{"Name": "n3"})
+	n4 := g.NewNode(GenID(), Metadata{"Name": "n4"})
+	g.NewNode(GenID(), Metadata{"Name": "n5"})
+
+	// n1 - n2 - n4 cost 2, n1 - n3 - n4 cost 5, n1 - n4 cost 10,
+	// n1 - n2 - n3 - n4 cost 5
+	g.NewEdge(GenID(), n1, n2, Metadata{"Weight": 1})
+	g.NewEdge(GenID(), n2, n4, Metadata{"Weight": 1})
+	g.NewEdge(GenID(), n1, n3, Metadata{"Weight": 2})
+	g.NewEdge(GenID(), n3, n4, Metadata{"Weight": 3})
+	g.NewEdge(GenID(), n1, n4, Metadata{"Weight": 10})
+	g.NewEdge(GenID(), n2, n3, Metadata{"Weight": 1})
+
+	paths := g.LookupKShortestPaths(n1, Metadata{"Name": "n4"}, 10, "Weight")
+	if len(paths) != 5 {
+		t.Fatalf("Should return 5 paths, returned: %v", paths)
+	}
+
+	if len(paths[0]) != 3 || paths[0][1].ID != n2.ID {
+		t.Errorf("First path should go through n2: %v", paths[0])
+	}
+
+	if len(paths[4]) != 2 {
+		t.Errorf("Last path should be the direct one: %v", paths[4])
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if p[0].ID != n1.ID || p[len(p)-1].ID != n4.ID {
+			t.Errorf("Wrong path returned: %v", p)
+		}
+
+		key := ""
+		for _, n := range p {
+			key += string(n.ID) + "/"
+		}
+		if seen[key] {
+			t.Errorf("Path returned twice: %v", p)
+		}
+		seen[key] = true
+	}
+
+	// without weight the direct link is the shortest
+	paths = g.LookupKShortestPaths(n1, Metadata{"Name": "n4"}, 1, "")
+	if len(paths) != 1 || len(paths[0]) != 2 {
+		t.Errorf("Should return the direct path: %v", paths)
+	}
+
+	if paths = g.LookupKShortestPaths(n1, Metadata{"Name": "n5"}, 3, "Weight"); len(paths) != 0 {
+		t.Errorf("Should not return any path: %v", paths)
+	}
+
+	res := execTraversalQuery(t, g, `G.V().Has("Name", "n1").KShortestPathsTo(Metadata("Name", "n4"), 2, "Weight")`)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+}
+
+func TestReachability(t *testing.T) {
+	g := newGraph(t)
+
+	gw := g.NewNode(GenID(), Metadata{"Name": "gw", "Type": "gateway"})
+	sw := g.NewNode(GenID(), Metadata{"Name": "switch"})
+	db := g.NewNode(GenID(), Metadata{"Name": "db", "Zone": "internal"})
+	cache := g.NewNode(GenID(), Metadata{"Name": "cache", "Zone": "internal"})
+	web := g.NewNode(GenID(), Metadata{"Name": "web", "Zone": "public"})
+	host := g.NewNode(GenID(), Metadata{"Name": "host", "Type": "host"})
+
+	g.Link(gw, sw, Metadata{"RelationType": "layer2"})
+	g.Link(sw, web, Metadata{"RelationType": "layer2"})
+	g.Link(sw, db, Metadata{"RelationType": "layer2"})
+	g.Link(host, cache, Metadata{"RelationType": "ownership"})
+	g.Link(host, gw, Metadata{"RelationType": "ownership"})
+
+	res := execTraversalQuery(t, g, `G.V().Has("Zone", "internal").ReachableFrom(Metadata("Type", "gateway"))`)
+	if len(res.Values()) != 1 || res.Values()[0].(*Node).ID != db.ID {
+		t.Fatalf("Only db should be exposed, got: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Has("Zone", "internal").NotReachableFrom(Metadata("Type", "gateway"))`)
+	if len(res.Values()) != 1 || res.Values()[0].(*Node).ID != cache.ID {
+		t.Fatalf("Only cache should not be reachable, got: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Has("Zone", "internal").ReachableFrom(Metadata("Type", "gateway"), Metadata("RelationType", Within("layer2", "ownership")))`)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Both internal nodes should be reachable following ownership, got: %v", res.Values())
+	}
+}
+
+func TestTraversalShortestPathDirection(t *testing.T) {
+	g := newGraph(t)
+
+	vm1 := g.NewNode(GenID(), Metadata{"Name": "vm1"})
+	tap := g.NewNode(GenID(), Metadata{"Name": "tap"})
+	br := g.NewNode(GenID(), Metadata{"Name": "br"})
+	vm2 := g.NewNode(GenID(), Metadata{"Name": "vm2"})
+	host := g.NewNode(GenID(), Metadata{"Name": "host"})
+
+	g.Link(vm1, tap, Metadata{"RelationType": "layer2"})
+	g.Link(tap, br, Metadata{"RelationType": "layer2"})
+	g.Link(vm2, br, Metadata{"RelationType": "layer2"})
+	g.Link(host, vm1, Metadata{"RelationType": "ownership"})
+	g.Link(host, vm2, Metadata{"RelationType": "ownership"})
+
+	// the ownership edges are not followed by default
+	if path := g.ShortestPath(vm1, vm2, DirectionBoth); len(path) != 4 || path[2].ID != br.ID {
+		t.Errorf("Wrong path returned: %v", path)
+	}
+
+	if path := g.ShortestPath(vm1, vm2, DirectionOut); len(path) != 0 {
+		t.Errorf("vm2 shouldn't be reachable following the outgoing edges: %v", path)
+	}
+
+	if path := g.ShortestPath(vm1, vm2, DirectionBoth, Metadata{}); len(path) != 3 || path[1].ID != host.ID {
+		t.Errorf("Wrong path returned: %v", path)
+	}
+
+	if nodes := g.Reachable(vm1, DirectionOut); len(nodes) != 2 || nodes[0].ID != tap.ID {
+		t.Errorf("Wrong reachable nodes returned: %v", nodes)
+	}
+
+	res := execTraversalQuery(t, g, `G.V().Has("Name", "vm1").ShortestPath(Metadata("Name", "vm2"), "both")`)
+	if len(res.Values()) != 1 || len(res.Values()[0].([]*Node)) != 4 {
+		t.Errorf("Wrong paths returned: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Has("Name", "host").Reachable("out", Metadata("RelationType", "ownership"))`)
+	if len(res.Values()) != 2 {
+		t.Errorf("Wrong reachable nodes returned: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Has("Name", "br").Reachable("in")`)
+	if len(res.Values()) != 3 {
+		t.Errorf("Wrong reachable nodes returned: %v", res.Values())
+	}
+}
+
+func TestNeighborhood(t *testing.T) {
+	g := newGraph(t)
+
+	host := g.NewNode(GenID(), Metadata{"Name": "host"})
+	br := g.NewNode(GenID(), Metadata{"Name": "br"})
+	tap := g.NewNode(GenID(), Metadata{"Name": "tap"})
+	vm := g.NewNode(GenID(), Metadata{"Name": "vm"})
+
+	g.Link(host, br, Metadata{"RelationType": "ownership"})
+	g.Link(host, tap, Metadata{"RelationType": "ownership"})
+	g.Link(br, tap, Metadata{"RelationType": "layer2"})
+	g.Link(tap, vm, Metadata{"RelationType": "layer2"})
+
+	sg := g.Neighborhood([]*Node{br}, 1)
+	if len(sg.Nodes) != 3 || len(sg.Edges) != 3 {
+		t.Errorf("Wrong neighborhood returned: %v", sg)
+	}
+
+	sg = g.Neighborhood([]*Node{br}, 2, Metadata{"RelationType": "layer2"})
+	if len(sg.Nodes) != 3 || len(sg.Edges) != 2 {
+		t.Errorf("Wrong neighborhood returned: %v", sg)
+	}
+
+	if sg = g.Neighborhood([]*Node{br}, 0); len(sg.Nodes) != 1 || len(sg.Edges) != 0 {
+		t.Errorf("Wrong neighborhood returned: %v", sg)
+	}
+
+	res := execTraversalQuery(t, g, `G.V().Has("Name", "host").Neighborhood(2, Metadata("RelationType", Within("ownership", "layer2")))`)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Wrong neighborhood returned: %v", res.Values())
+	}
+	if sg = res.Values()[0].(*SubGraph); len(sg.Nodes) != 4 || len(sg.Edges) != 4 {
+		t.Errorf("Wrong neighborhood returned: %v", sg)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+// visibleBackend is a read-only view of a backend without its hidden nodes
+// and the edges hidden or linked to a hidden node
+type visibleBackend struct {
+	GraphBackend
+}
+
+func (b visibleBackend) visibleNode(n *Node) *Node {
+	if n == nil || n.Hidden() {
+		return nil
+	}
+	return n
+}
+
+func (b visibleBackend) visibleEdge(e *Edge) bool {
+	if e == nil || e.Hidden() {
+		return false
+	}
+	return b.visibleNode(b.GraphBackend.GetNode(e.parent)) != nil &&
+		b.visibleNode(b.GraphBackend.GetNode(e.child)) != nil
+}
+
+func (b visibleBackend) GetNode(i Identifier) *Node {
+	return b.visibleNode(b.GraphBackend.GetNode(i))
+}
+
+func (b visibleBackend) GetNodes() []*Node {
+	nodes := []*Node{}
+	for _, n := range b.GraphBackend.GetNodes() {
+		if !n.Hidden() {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (b visibleBackend) GetNodeEdges(n *Node) []*Edge {
+	edges := []*Edge{}
+	for _, e := range b.GraphBackend.GetNodeEdges(n) {
+		if b.visibleEdge(e) {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+func (b visibleBackend) GetEdge(i Identifier) *Edge {
+	if e := b.GraphBackend.GetEdge(i); b.visibleEdge(e) {
+		return e
+	}
+	return nil
+}
+
+func (b visibleBackend) GetEdgeNodes(e *Edge) (*Node, *Node) {
+	parent, child := b.GraphBackend.GetEdgeNodes(e)
+	return b.visibleNode(parent), b.visibleNode(child)
+}
+
+func (b visibleBackend) GetEdges() []*Edge {
+	edges := []*Edge{}
+	for _, e := range b.GraphBackend.GetEdges() {
+		if b.visibleEdge(e) {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+func (b visibleBackend) AddNode(n *Node) bool {
+	return false
+}
+
+func (b visibleBackend) DelNode(n *Node) bool {
+	return false
+}
+
+func (b visibleBackend) AddEdge(e *Edge) bool {
+	return false
+}
+
+func (b visibleBackend) DelEdge(e *Edge) bool {
+	return false
+}
+
+func (b visibleBackend) AddMetadata(i interface{}, k string, v interface{}) bool {
+	return false
+}
+
+func (b visibleBackend) SetMetadata(i interface{}, m Metadata) bool {
+	return false
+}
+
+// Visible returns a read-only view of the graph without its hidden nodes
+// and edges, given to the users not allowed to get them. The view shares
+// the elements of the graph, the graph lock has to be held while using it.
+func (g *Graph) Visible() *Graph {
+	return &Graph{
+		backend:     visibleBackend{GraphBackend: g.backend},
+		host:        g.host,
+		idGenerator: g.idGenerator,
+	}
+}
@@ -64,6 +64,36 @@ func (probe *DockerProbe) containerNamespace(pid int) string {
 	return fmt.Sprintf("/proc/%d/ns/net", pid)
 }
 
+func networkMode(info *dockerclient.ContainerInfo) string {
+	if info.HostConfig != nil && info.HostConfig.NetworkMode != "" {
+		return info.HostConfig.NetworkMode
+	}
+	return "default"
+}
+
+func containerMetadata(info *dockerclient.ContainerInfo) graph.Metadata {
+	metadata := graph.Metadata{
+		"Type":                 "container",
+		"Name":                 info.Name[1:],
+		"Docker.ContainerID":   info.Id,
+		"Docker.ContainerName": info.Name,
+		"Docker.ContainerPID":  info.State.Pid,
+		"Docker.Image":         info.Config.Image,
+		"Docker.NetworkMode":   networkMode(info),
+		"Docker.State":         "running",
+	}
+
+	if len(info.Config.Labels) > 0 {
+		labels := make(map[string]interface{})
+		for k, v := range info.Config.Labels {
+			labels[k] = v
+		}
+		metadata["Docker.Labels"] = labels
+	}
+
+	return metadata
+}
+
 func (probe *DockerProbe) registerContainer(id string) {
 	probe.Lock()
 	defer probe.Unlock()
@@ -90,19 +120,18 @@ func (probe *DockerProbe) registerContainer(id string) {
 		// The container is in net=host mode
 		n = probe.Root
 	} else {
-		n = probe.Register(namespace, graph.Metadata{"Name": info.Name[1:], "Manager": "docker"})
-
+		n = probe.Register(namespace, graph.Metadata{
+			"Name":                 info.Name[1:],
+			"Manager":              "docker",
+			"Docker.ContainerName": info.Name,
+			"Docker.Image":         info.Config.Image,
+			"Docker.NetworkMode":   networkMode(info),
+		})
 	}
 
 	probe.Graph.Lock()
-	metadata := graph.Metadata{
-		"Type":                 "container",
-		"Name":                 info.Name[1:],
-		"Docker.ContainerID":   info.Id,
-		"Docker.ContainerName": info.Name,
-		"Docker.ContainerPID":  info.State.Pid,
-	}
-	containerNode := probe.Graph.NewNode(graph.GenID(), metadata)
+	metadata := containerMetadata(info)
+	containerNode := probe.Graph.NewNode(probe.Graph.GenID(metadata), metadata)
 	probe.Graph.Link(n, containerNode, graph.Metadata{"RelationType": "membership"})
 	probe.Graph.Unlock()
 
@@ -131,11 +160,32 @@ func (probe *DockerProbe) unregisterContainer(id string) {
 	delete(probe.containerMap, id)
 }
 
+func (probe *DockerProbe) updateContainerState(id string, state string) {
+	probe.RLock()
+	defer probe.RUnlock()
+
+	infos, ok := probe.containerMap[id]
+	if !ok {
+		return
+	}
+
+	probe.Graph.Lock()
+	probe.Graph.AddMetadata(infos.Node, "Docker.State", state)
+	probe.Graph.Unlock()
+}
+
+// a restarted container is unregistered when it dies and registered again
+// with its new process when it starts
 func (probe *DockerProbe) handleDockerEvent(event *dockerclient.Event) {
-	if event.Status == "start" {
+	switch event.Status {
+	case "start":
 		probe.registerContainer(event.ID)
-	} else if event.Status == "die" {
+	case "die":
 		probe.unregisterContainer(event.ID)
+	case "pause":
+		probe.updateContainerState(event.ID, "paused")
+	case "unpause":
+		probe.updateContainerState(event.ID, "running")
 	}
 }
 
@@ -155,7 +205,7 @@ func (probe *DockerProbe) connect() error {
 
 	eventsOptions := &dockerclient.MonitorEventsOptions{
 		Filters: &dockerclient.MonitorEventsFilters{
-			Events: []string{"start", "die"},
+			Events: []string{"start", "die", "pause", "unpause"},
 		},
 	}
 
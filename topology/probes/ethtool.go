@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// ethtool commands missing from the ethtool package
+const (
+	siocEthtool     = 0x8946
+	ethtoolGSet     = 0x00000001
+	ethtoolGDrvInfo = 0x00000003
+	ethtoolGRxCsum  = 0x00000014
+	ethtoolGTxCsum  = 0x00000016
+	ethtoolGSG      = 0x00000018
+	ethtoolGTSO     = 0x0000001e
+	ethtoolGGSO     = 0x00000023
+	ethtoolGFlags   = 0x00000025
+	ethtoolGGRO     = 0x0000002b
+
+	ethFlagLRO = 1 << 15
+
+	duplexHalf = 0x00
+	duplexFull = 0x01
+)
+
+type ethtoolIfreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data uintptr
+}
+
+type ethtoolCmd struct {
+	cmd           uint32
+	supported     uint32
+	advertising   uint32
+	speed         uint16
+	duplex        uint8
+	port          uint8
+	phyAddress    uint8
+	transceiver   uint8
+	autoneg       uint8
+	mdioSupport   uint8
+	maxtxpkt      uint32
+	maxrxpkt      uint32
+	speedHi       uint16
+	ethTpMdix     uint8
+	ethTpMdixCtrl uint8
+	lpAdvertising uint32
+	reserved      [2]uint32
+}
+
+type ethtoolDrvInfo struct {
+	cmd         uint32
+	driver      [32]byte
+	version     [32]byte
+	fwVersion   [32]byte
+	busInfo     [32]byte
+	eromVersion [32]byte
+	reserved2   [12]byte
+	nPrivFlags  uint32
+	nStats      uint32
+	testinfoLen uint32
+	eedumpLen   uint32
+	regdumpLen  uint32
+}
+
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+func ethtoolIoctl(fd int, intf string, data unsafe.Pointer) error {
+	ifr := ethtoolIfreq{data: uintptr(data)}
+	copy(ifr.name[:syscall.IFNAMSIZ-1], intf)
+
+	if _, _, ep := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&ifr))); ep != 0 {
+		return syscall.Errno(ep)
+	}
+	return nil
+}
+
+func ethtoolString(b []byte) string {
+	return string(bytes.Trim(b, "\x00"))
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// ethtoolMetadata returns the link settings, the driver information and the
+// offload features of an interface, the ones not supported by its driver
+// being omitted
+func ethtoolMetadata(intf string) graph.Metadata {
+	metadata := graph.Metadata{}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_IP)
+	if err != nil {
+		return metadata
+	}
+	defer syscall.Close(fd)
+
+	settings := ethtoolCmd{cmd: ethtoolGSet}
+	if ethtoolIoctl(fd, intf, unsafe.Pointer(&settings)) == nil {
+		// speed in Mb/s, unknown when the link is down
+		if speed := uint32(settings.speedHi)<<16 | uint32(settings.speed); speed != 0 && speed != 0xffff && speed != 0xffffffff {
+			metadata["Ethtool.Speed"] = int64(speed)
+		}
+		switch settings.duplex {
+		case duplexHalf:
+			metadata["Ethtool.Duplex"] = "half"
+		case duplexFull:
+			metadata["Ethtool.Duplex"] = "full"
+		}
+		metadata["Ethtool.Autoneg"] = onOff(settings.autoneg != 0)
+	}
+
+	drvinfo := ethtoolDrvInfo{cmd: ethtoolGDrvInfo}
+	if ethtoolIoctl(fd, intf, unsafe.Pointer(&drvinfo)) == nil {
+		if version := ethtoolString(drvinfo.version[:]); version != "" {
+			metadata["Ethtool.DriverVersion"] = version
+		}
+		if fwVersion := ethtoolString(drvinfo.fwVersion[:]); fwVersion != "" && fwVersion != "N/A" {
+			metadata["Ethtool.FirmwareVersion"] = fwVersion
+		}
+		if busInfo := ethtoolString(drvinfo.busInfo[:]); busInfo != "" && busInfo != "N/A" {
+			metadata["Ethtool.BusInfo"] = busInfo
+		}
+	}
+
+	for _, feature := range []struct {
+		key string
+		cmd uint32
+	}{
+		{"Ethtool.RxChecksumming", ethtoolGRxCsum},
+		{"Ethtool.TxChecksumming", ethtoolGTxCsum},
+		{"Ethtool.ScatterGather", ethtoolGSG},
+		{"Ethtool.TSO", ethtoolGTSO},
+		{"Ethtool.GSO", ethtoolGGSO},
+		{"Ethtool.GRO", ethtoolGGRO},
+	} {
+		value := ethtoolValue{cmd: feature.cmd}
+		if ethtoolIoctl(fd, intf, unsafe.Pointer(&value)) == nil {
+			metadata[feature.key] = onOff(value.data != 0)
+		}
+	}
+
+	flags := ethtoolValue{cmd: ethtoolGFlags}
+	if ethtoolIoctl(fd, intf, unsafe.Pointer(&flags)) == nil {
+		metadata["Ethtool.LRO"] = onOff(flags.data&ethFlagLRO != 0)
+	}
+
+	return metadata
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+type fdbEntry struct {
+	port     *graph.Node
+	target   *graph.Node
+	metadata graph.Metadata
+	lastSeen time.Time
+}
+
+// FDBProbe reads periodically the forwarding databases of the Linux bridges
+// and the ARP/NDP neighbor tables of the host, linking the interfaces to the
+// MAC addresses known behind them: fdb edges for the bridge ports, neighbor
+// edges for the interfaces having resolved an IP. The node having the MAC
+// address is the target of the edge, an endpoint node being created when
+// the graph doesn't have it. The entries not seen for the aging delay are
+// removed.
+type FDBProbe struct {
+	Graph    *graph.Graph
+	Root     *graph.Node
+	interval time.Duration
+	aging    time.Duration
+	state    int64
+	quit     chan bool
+	wg       sync.WaitGroup
+	// protected by the graph lock
+	entries   map[string]*fdbEntry
+	endpoints map[string]*graph.Node
+}
+
+// readNeighbors returns the bridge FDB entries and the IPv4 and IPv6
+// neighbors
+func readNeighbors() ([]netlink.Neigh, error) {
+	var neighs []netlink.Neigh
+	for _, family := range []int{syscall.AF_BRIDGE, syscall.AF_INET, syscall.AF_INET6} {
+		n, err := netlink.NeighList(0, family)
+		if err != nil {
+			return nil, err
+		}
+		neighs = append(neighs, n...)
+	}
+	return neighs, nil
+}
+
+// target returns the node having the MAC address, an endpoint node created
+// by the probe if none
+func (probe *FDBProbe) target(mac string, port *graph.Node) *graph.Node {
+	for _, n := range probe.Graph.LookupNodes(graph.Metadata{"MAC": mac}) {
+		if n.ID != port.ID {
+			return n
+		}
+	}
+
+	if n, ok := probe.endpoints[mac]; ok {
+		return n
+	}
+
+	m := graph.Metadata{"Type": "endpoint", "Name": mac, "MAC": mac}
+	n := probe.Graph.NewNode(probe.Graph.GenID(m), m)
+	probe.endpoints[mac] = n
+	probe.Graph.Link(probe.Root, n, graph.Metadata{"RelationType": "ownership"})
+	return n
+}
+
+func (probe *FDBProbe) update(neighs []netlink.Neigh, now time.Time) {
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	for _, neigh := range neighs {
+		if len(neigh.HardwareAddr) == 0 {
+			continue
+		}
+		mac := neigh.HardwareAddr.String()
+
+		var key string
+		var m graph.Metadata
+		if neigh.Family == syscall.AF_BRIDGE {
+			// the permanent entries are the addresses of the bridge ports
+			if neigh.State&netlink.NUD_PERMANENT != 0 {
+				continue
+			}
+			key = fmt.Sprintf("fdb/%d/%s", neigh.LinkIndex, mac)
+			m = graph.Metadata{"RelationType": "fdb", "MAC": mac}
+		} else {
+			if neigh.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED|netlink.NUD_NOARP) != 0 || neigh.IP == nil {
+				continue
+			}
+			key = fmt.Sprintf("neighbor/%d/%s/%s", neigh.LinkIndex, mac, neigh.IP)
+			m = graph.Metadata{"RelationType": "neighbor", "MAC": mac, "IP": neigh.IP.String()}
+		}
+
+		if entry, ok := probe.entries[key]; ok {
+			entry.lastSeen = now
+			continue
+		}
+
+		port := probe.Graph.LookupFirstChild(probe.Root, graph.Metadata{"IfIndex": int64(neigh.LinkIndex)})
+		if port == nil {
+			continue
+		}
+
+		target := probe.target(mac, port)
+		if probe.Graph.GetFirstLink(port, target, m) == nil {
+			probe.Graph.Link(port, target, m)
+		}
+		probe.entries[key] = &fdbEntry{port: port, target: target, metadata: m, lastSeen: now}
+	}
+}
+
+func (probe *FDBProbe) expire(now time.Time) {
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	for key, entry := range probe.entries {
+		if now.Sub(entry.lastSeen) < probe.aging {
+			continue
+		}
+		delete(probe.entries, key)
+
+		if e := probe.Graph.GetFirstLink(entry.port, entry.target, entry.metadata); e != nil {
+			probe.Graph.DelEdge(e)
+		}
+	}
+
+	for mac, n := range probe.endpoints {
+		if len(probe.Graph.GetNodeEdges(n, graph.Metadata{})) <= 1 {
+			probe.Graph.DelNode(n)
+			delete(probe.endpoints, mac)
+		}
+	}
+}
+
+func (probe *FDBProbe) poll() {
+	neighs, err := readNeighbors()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read the FDB and neighbor tables: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	probe.update(neighs, now)
+	probe.expire(now)
+}
+
+func (probe *FDBProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, StoppedState, RunningState) {
+		return
+	}
+
+	probe.quit = make(chan bool)
+
+	probe.wg.Add(1)
+	go func() {
+		defer probe.wg.Done()
+
+		ticker := time.NewTicker(probe.interval)
+		defer ticker.Stop()
+
+		probe.poll()
+		for {
+			select {
+			case <-ticker.C:
+				probe.poll()
+			case <-probe.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (probe *FDBProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, RunningState, StoppingState) {
+		return
+	}
+
+	close(probe.quit)
+	probe.wg.Wait()
+
+	atomic.StoreInt64(&probe.state, StoppedState)
+}
+
+// NewFDBProbe returns a probe reading the tables every interval, the
+// entries being removed after not being seen for the aging delay
+func NewFDBProbe(g *graph.Graph, n *graph.Node, interval time.Duration, aging time.Duration) *FDBProbe {
+	return &FDBProbe{
+		Graph:     g,
+		Root:      n,
+		interval:  interval,
+		aging:     aging,
+		state:     StoppedState,
+		entries:   make(map[string]*fdbEntry),
+		endpoints: make(map[string]*graph.Node),
+	}
+}
+
+func NewFDBProbeFromConfig(g *graph.Graph, n *graph.Node) *FDBProbe {
+	interval := time.Duration(config.GetConfig().GetInt("fdb.poll_interval")) * time.Second
+	aging := time.Duration(config.GetConfig().GetInt("fdb.aging")) * time.Second
+	return NewFDBProbe(g, n, interval, aging)
+}
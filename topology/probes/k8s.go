@@ -0,0 +1,447 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+type k8sObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	UID       string            `json:"uid"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type k8sObject struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName    string            `json:"nodeName"`
+		Selector    map[string]string `json:"selector"`
+		ClusterIP   string            `json:"clusterIP"`
+		PodSelector struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"podSelector"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// k8sResource is a kind of Kubernetes objects watched by the probe
+type k8sResource struct {
+	kind    string
+	path    string
+	nodes   map[string]*graph.Node
+	objects map[string]*k8sObject
+	// selector returns the labels of the pods linked to an object, nil if
+	// the object is not linked to pods
+	selector func(o *k8sObject) map[string]string
+}
+
+// K8sProbe watches the Kubernetes API and adds the namespaces, the pods of
+// the agent host, the services and the network policies to the graph. The
+// pods are linked to their containers discovered by the docker probe and
+// their network namespace is named after them.
+type K8sProbe struct {
+	graph.DefaultGraphListener
+	Graph     *graph.Graph
+	url       string
+	token     string
+	nodeName  string
+	client    *http.Client
+	state     int64
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	resources []*k8sResource
+	pods      *k8sResource
+}
+
+func k8sSelectorMatch(selector map[string]string, labels map[string]string) bool {
+	if selector == nil {
+		return false
+	}
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// k8sContainerPod returns the namespace and the name of the pod of a
+// container started by the kubelet, named k8s_<container>_<pod>_<namespace>_...
+func k8sContainerPod(n *graph.Node) (string, string, bool) {
+	name, _ := n.Metadata()["Docker.ContainerName"].(string)
+	fields := strings.Split(strings.TrimPrefix(name, "/"), "_")
+	if len(fields) < 4 || fields[0] != "k8s" {
+		return "", "", false
+	}
+	return fields[3], fields[2], true
+}
+
+func (probe *K8sProbe) lookupPod(namespace, name string) *graph.Node {
+	for uid, o := range probe.pods.objects {
+		if o.Metadata.Namespace == namespace && o.Metadata.Name == name {
+			return probe.pods.nodes[uid]
+		}
+	}
+	return nil
+}
+
+func (probe *K8sProbe) linkContainer(pod *graph.Node, c *graph.Node) {
+	if !probe.Graph.AreLinked(pod, c) {
+		probe.Graph.Link(pod, c, graph.Metadata{"RelationType": "membership"})
+	}
+
+	for _, ns := range probe.Graph.LookupParentNodes(c, graph.Metadata{"Type": "netns"}) {
+		if ns.Metadata()["K8s.PodName"] == nil {
+			tr := probe.Graph.StartMetadataTransaction(ns)
+			tr.AddMetadata("K8s.PodName", pod.Metadata()["Name"])
+			tr.AddMetadata("K8s.Namespace", pod.Metadata()["K8s.Namespace"])
+			tr.Commit()
+		}
+	}
+}
+
+// OnNodeAdded links the containers created after their pod
+func (probe *K8sProbe) OnNodeAdded(n *graph.Node) {
+	if n.Metadata()["Type"] != "container" {
+		return
+	}
+
+	if namespace, name, ok := k8sContainerPod(n); ok {
+		if pod := probe.lookupPod(namespace, name); pod != nil {
+			probe.linkContainer(pod, n)
+		}
+	}
+}
+
+// OnEdgeAdded names the network namespaces linked to a pod container after
+// the container
+func (probe *K8sProbe) OnEdgeAdded(e *graph.Edge) {
+	c := probe.Graph.GetNode(e.Child())
+	if c == nil || c.Metadata()["Type"] != "container" {
+		return
+	}
+
+	for _, pod := range probe.Graph.LookupParentNodes(c, graph.Metadata{"Manager": "k8s", "Type": "pod"}) {
+		probe.linkContainer(pod, c)
+	}
+}
+
+// syncLinks links the pods to the services and the network policies
+// selecting them, unlinks the others
+func (probe *K8sProbe) syncLinks(r *k8sResource, uid string, pod *k8sObject, podNode *graph.Node) {
+	node, o := r.nodes[uid], r.objects[uid]
+	if node == nil || podNode == nil || o.Metadata.Namespace != pod.Metadata.Namespace {
+		return
+	}
+
+	linked := probe.Graph.AreLinked(node, podNode)
+	if match := k8sSelectorMatch(r.selector(o), pod.Metadata.Labels); match && !linked {
+		probe.Graph.Link(node, podNode, graph.Metadata{"RelationType": "membership"})
+	} else if !match && linked {
+		probe.Graph.Unlink(node, podNode)
+	}
+}
+
+func (probe *K8sProbe) linkObject(r *k8sResource, uid string, o *k8sObject) {
+	if r == probe.pods {
+		for _, sr := range probe.resources {
+			if sr.selector == nil {
+				continue
+			}
+			for suid := range sr.objects {
+				probe.syncLinks(sr, suid, o, r.nodes[uid])
+			}
+		}
+
+		for _, c := range probe.Graph.LookupNodes(graph.Metadata{"Type": "container"}) {
+			if namespace, name, ok := k8sContainerPod(c); ok && namespace == o.Metadata.Namespace && name == o.Metadata.Name {
+				probe.linkContainer(r.nodes[uid], c)
+			}
+		}
+	} else if r.selector != nil {
+		for puid, pod := range probe.pods.objects {
+			probe.syncLinks(r, uid, pod, probe.pods.nodes[puid])
+		}
+	}
+
+	// the objects are linked to their namespace whatever the order in
+	// which they are received
+	namespaces := probe.resources[0]
+	if r == namespaces {
+		for _, or := range probe.resources[1:] {
+			for ouid, oo := range or.objects {
+				if oo.Metadata.Namespace == o.Metadata.Name {
+					probe.linkOwnership(r.nodes[uid], or.nodes[ouid])
+				}
+			}
+		}
+	} else {
+		for nuid, ns := range namespaces.objects {
+			if ns.Metadata.Name == o.Metadata.Namespace {
+				probe.linkOwnership(namespaces.nodes[nuid], r.nodes[uid])
+			}
+		}
+	}
+}
+
+func (probe *K8sProbe) linkOwnership(parent *graph.Node, child *graph.Node) {
+	if !probe.Graph.AreLinked(parent, child) {
+		probe.Graph.Link(parent, child, graph.Metadata{"RelationType": "ownership"})
+	}
+}
+
+func (probe *K8sProbe) metadata(r *k8sResource, o *k8sObject) graph.Metadata {
+	m := graph.Metadata{
+		"Type":    r.kind,
+		"Manager": "k8s",
+		"Name":    o.Metadata.Name,
+		"K8s.UID": o.Metadata.UID,
+	}
+
+	if o.Metadata.Namespace != "" {
+		m["K8s.Namespace"] = o.Metadata.Namespace
+		m["Namespace"] = o.Metadata.Namespace
+	} else if r.kind == "namespace" {
+		m["Namespace"] = o.Metadata.Name
+	}
+
+	if len(o.Metadata.Labels) > 0 {
+		labels := make(map[string]interface{})
+		for k, v := range o.Metadata.Labels {
+			labels[k] = v
+		}
+		m["K8s.Labels"] = labels
+	}
+
+	switch r.kind {
+	case "pod":
+		m["K8s.NodeName"] = o.Spec.NodeName
+		m["K8s.Phase"] = o.Status.Phase
+		if o.Status.PodIP != "" {
+			m["K8s.PodIP"] = o.Status.PodIP
+		}
+	case "service":
+		if o.Spec.ClusterIP != "" {
+			m["K8s.ClusterIP"] = o.Spec.ClusterIP
+		}
+	}
+
+	return m
+}
+
+func (probe *K8sProbe) handleEvent(r *k8sResource, ev *k8sWatchEvent) {
+	var o k8sObject
+	if err := json.Unmarshal(ev.Object, &o); err != nil {
+		logging.GetLogger().Errorf("Unable to decode Kubernetes %s: %s", r.kind, err.Error())
+		return
+	}
+	uid := o.Metadata.UID
+
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	switch ev.Type {
+	case "ADDED", "MODIFIED":
+		m := probe.metadata(r, &o)
+		if n, ok := r.nodes[uid]; ok {
+			probe.Graph.SetMetadata(n, m)
+		} else {
+			r.nodes[uid] = probe.Graph.NewNode(probe.Graph.GenID(m), m)
+		}
+		r.objects[uid] = &o
+
+		probe.linkObject(r, uid, &o)
+	case "DELETED":
+		if n, ok := r.nodes[uid]; ok {
+			probe.Graph.DelNode(n)
+		}
+		delete(r.nodes, uid)
+		delete(r.objects, uid)
+	}
+}
+
+func (probe *K8sProbe) watch(r *k8sResource) error {
+	u := probe.url + r.path + "?watch=true"
+	if r == probe.pods && probe.nodeName != "" {
+		u += "&fieldSelector=" + url.QueryEscape("spec.nodeName="+probe.nodeName)
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Cancel = probe.quit
+	if probe.token != "" {
+		req.Header.Set("Authorization", "Bearer "+probe.token)
+	}
+
+	resp, err := probe.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var ev k8sWatchEvent
+		if err := decoder.Decode(&ev); err != nil {
+			return err
+		}
+		probe.handleEvent(r, &ev)
+	}
+}
+
+func (probe *K8sProbe) run(r *k8sResource) {
+	defer probe.wg.Done()
+
+	for atomic.LoadInt64(&probe.state) == RunningState {
+		err := probe.watch(r)
+		if atomic.LoadInt64(&probe.state) != RunningState {
+			return
+		}
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to watch Kubernetes %s: %s", r.kind, err.Error())
+		}
+
+		select {
+		case <-probe.quit:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (probe *K8sProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, StoppedState, RunningState) {
+		return
+	}
+
+	probe.quit = make(chan struct{})
+
+	probe.Graph.AddEventListenerWithPriority(probe, graph.ListenerPriorityFromConfig("k8s", graph.DerivationListenerPriority))
+
+	for _, r := range probe.resources {
+		probe.wg.Add(1)
+		go probe.run(r)
+	}
+}
+
+func (probe *K8sProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, RunningState, StoppingState) {
+		return
+	}
+
+	close(probe.quit)
+	probe.wg.Wait()
+
+	probe.Graph.RemoveEventListener(probe)
+
+	atomic.StoreInt64(&probe.state, StoppedState)
+}
+
+func newK8sResource(kind string, path string, selector func(o *k8sObject) map[string]string) *k8sResource {
+	return &k8sResource{
+		kind:     kind,
+		path:     path,
+		selector: selector,
+		nodes:    make(map[string]*graph.Node),
+		objects:  make(map[string]*k8sObject),
+	}
+}
+
+// NewK8sProbe returns a probe watching the API server at the given URL,
+// only the pods scheduled on nodeName are added, all of them if empty.
+func NewK8sProbe(g *graph.Graph, apiURL string, token string, nodeName string) *K8sProbe {
+	probe := &K8sProbe{
+		Graph:    g,
+		url:      strings.TrimSuffix(apiURL, "/"),
+		token:    token,
+		nodeName: nodeName,
+		client:   &http.Client{},
+		state:    StoppedState,
+		pods:     newK8sResource("pod", "/api/v1/pods", nil),
+	}
+
+	// namespaces first as the other objects are linked to them
+	probe.resources = []*k8sResource{
+		newK8sResource("namespace", "/api/v1/namespaces", nil),
+		probe.pods,
+		newK8sResource("service", "/api/v1/services", func(o *k8sObject) map[string]string {
+			return o.Spec.Selector
+		}),
+		newK8sResource("networkpolicy", "/apis/networking.k8s.io/v1/networkpolicies", func(o *k8sObject) map[string]string {
+			// an empty selector selects all the pods of the namespace
+			if o.Spec.PodSelector.MatchLabels == nil {
+				return map[string]string{}
+			}
+			return o.Spec.PodSelector.MatchLabels
+		}),
+	}
+
+	return probe
+}
+
+func NewK8sProbeFromConfig(g *graph.Graph) *K8sProbe {
+	var token string
+	if path := config.GetConfig().GetString("k8s.token_file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			logging.GetLogger().Errorf("Unable to read the Kubernetes token: %s", err.Error())
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	nodeName := config.GetConfig().GetString("k8s.node_name")
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+
+	return NewK8sProbe(g, config.GetConfig().GetString("k8s.url"), token, nodeName)
+}
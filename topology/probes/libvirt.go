@@ -0,0 +1,277 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/inotify"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+type libvirtInterface struct {
+	Type string `xml:"type,attr"`
+	MAC  struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"target"`
+	Source struct {
+		Path   string `xml:"path,attr"`
+		Bridge string `xml:"bridge,attr"`
+	} `xml:"source"`
+}
+
+type libvirtDomain struct {
+	Name       string             `xml:"name"`
+	UUID       string             `xml:"uuid"`
+	Interfaces []libvirtInterface `xml:"devices>interface"`
+}
+
+// the status files of the running domains embed the domain definition
+type libvirtDomainStatus struct {
+	Domain libvirtDomain `xml:"domain"`
+}
+
+// device returns the name of the host interface of a vNIC, the tap device
+// or the vhost-user port named after its socket
+func (i *libvirtInterface) device() string {
+	if i.Type == "vhostuser" {
+		return filepath.Base(i.Source.Path)
+	}
+	return i.Target.Dev
+}
+
+// isVF returns whether the node is the SR-IOV virtual function assigned to
+// the vNIC
+func (i *libvirtInterface) isVF(n *graph.Node) bool {
+	return i.Type == "hostdev" && i.MAC.Address != "" && n.Metadata()["Type"] == "vf" && n.Metadata()["MAC"] == i.MAC.Address
+}
+
+type libvirtVM struct {
+	node       *graph.Node
+	interfaces []libvirtInterface
+}
+
+// LibvirtProbe watches the status files of the libvirt QEMU driver and adds
+// the running virtual machines to the graph, linked to the host tap or
+// vhost-user interfaces of their vNICs.
+type LibvirtProbe struct {
+	graph.DefaultGraphListener
+	Graph   *graph.Graph
+	Root    *graph.Node
+	runPath string
+	// protected by the graph lock
+	vms     map[string]*libvirtVM
+	watcher *inotify.Watcher
+}
+
+func parseLibvirtDomain(path string) (*libvirtDomain, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var status libvirtDomainStatus
+	if err := xml.Unmarshal(data, &status); err == nil && status.Domain.Name != "" {
+		return &status.Domain, nil
+	}
+
+	var domain libvirtDomain
+	if err := xml.Unmarshal(data, &domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+func (probe *LibvirtProbe) linkInterface(vm *graph.Node, i *libvirtInterface, n *graph.Node) {
+	if !probe.Graph.AreLinked(vm, n) {
+		probe.Graph.Link(vm, n, graph.Metadata{"RelationType": "layer2", "MAC": i.MAC.Address})
+	}
+}
+
+// OnNodeAdded links the interfaces created after their virtual machine
+func (probe *LibvirtProbe) OnNodeAdded(n *graph.Node) {
+	name, _ := n.Metadata()["Name"].(string)
+	if name == "" {
+		return
+	}
+
+	for _, vm := range probe.vms {
+		for _, i := range vm.interfaces {
+			if i.device() == name || i.isVF(n) {
+				probe.linkInterface(vm.node, &i, n)
+			}
+		}
+	}
+}
+
+// OnNodeUpdated links the SR-IOV virtual functions once their MAC address
+// set by libvirt
+func (probe *LibvirtProbe) OnNodeUpdated(n *graph.Node) {
+	if n.Metadata()["Type"] == "vf" {
+		probe.OnNodeAdded(n)
+	}
+}
+
+func (probe *LibvirtProbe) registerDomain(path string) {
+	domain, err := parseLibvirtDomain(path)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to parse the libvirt domain %s: %s", path, err.Error())
+		return
+	}
+
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	metadata := graph.Metadata{
+		"Type":         "libvirt",
+		"Name":         domain.Name,
+		"Manager":      "libvirt",
+		"Libvirt.UUID": domain.UUID,
+	}
+
+	vm, ok := probe.vms[path]
+	if ok {
+		probe.Graph.SetMetadata(vm.node, metadata)
+	} else {
+		n := probe.Graph.NewNode(probe.Graph.GenID(metadata), metadata)
+		probe.Graph.Link(probe.Root, n, graph.Metadata{"RelationType": "ownership"})
+
+		vm = &libvirtVM{node: n}
+		probe.vms[path] = vm
+	}
+	vm.interfaces = domain.Interfaces
+
+	for _, i := range vm.interfaces {
+		if dev := i.device(); dev != "" {
+			for _, n := range probe.Graph.LookupNodes(graph.Metadata{"Name": dev}) {
+				probe.linkInterface(vm.node, &i, n)
+			}
+		}
+
+		// the SR-IOV virtual functions assigned to the domain
+		if i.Type == "hostdev" && i.MAC.Address != "" {
+			for _, n := range probe.Graph.LookupNodes(graph.Metadata{"Type": "vf", "MAC": i.MAC.Address}) {
+				probe.linkInterface(vm.node, &i, n)
+			}
+		}
+	}
+}
+
+func (probe *LibvirtProbe) unregisterDomain(path string) {
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	if vm, ok := probe.vms[path]; ok {
+		probe.Graph.DelNode(vm.node)
+		delete(probe.vms, path)
+	}
+}
+
+func (probe *LibvirtProbe) start() {
+	// wait for the path creation
+	for {
+		if _, err := os.Stat(probe.runPath); err == nil {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	if err := probe.watcher.AddWatch(probe.runPath, inotify.IN_CLOSE_WRITE|inotify.IN_MOVED_TO|inotify.IN_DELETE); err != nil {
+		logging.GetLogger().Errorf("Unable to Watch %s: %s", probe.runPath, err.Error())
+		return
+	}
+
+	files, _ := filepath.Glob(filepath.Join(probe.runPath, "*.xml"))
+	for _, f := range files {
+		probe.registerDomain(f)
+	}
+
+	for {
+		select {
+		case ev, ok := <-probe.watcher.Event:
+			if !ok {
+				return
+			}
+			if !strings.HasSuffix(ev.Name, ".xml") {
+				continue
+			}
+			if ev.Mask&inotify.IN_DELETE > 0 {
+				probe.unregisterDomain(ev.Name)
+			} else {
+				probe.registerDomain(ev.Name)
+			}
+		case err, ok := <-probe.watcher.Error:
+			if !ok {
+				return
+			}
+			logging.GetLogger().Errorf("Error while watching libvirt domains: %s", err.Error())
+		}
+	}
+}
+
+func (probe *LibvirtProbe) Start() {
+	watcher, err := inotify.NewWatcher()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to create a new Watcher: %s", err.Error())
+		return
+	}
+	probe.watcher = watcher
+
+	probe.Graph.AddEventListenerWithPriority(probe, graph.ListenerPriorityFromConfig("libvirt", graph.DerivationListenerPriority))
+
+	go probe.start()
+}
+
+func (probe *LibvirtProbe) Stop() {
+	if probe.watcher == nil {
+		return
+	}
+
+	probe.Graph.RemoveEventListener(probe)
+
+	probe.watcher.Close()
+}
+
+func NewLibvirtProbe(g *graph.Graph, n *graph.Node, runPath string) *LibvirtProbe {
+	return &LibvirtProbe{
+		Graph:   g,
+		Root:    n,
+		runPath: runPath,
+		vms:     make(map[string]*libvirtVM),
+	}
+}
+
+func NewLibvirtProbeFromConfig(g *graph.Graph, n *graph.Node) *LibvirtProbe {
+	return NewLibvirtProbe(g, n, config.GetConfig().GetString("libvirt.run_path"))
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const (
+	lldpEtherType     = 0x88cc
+	lldpReadTimeout   = time.Second
+	lldpExpirePeriod  = 5 * time.Second
+	lldpMaxFrameSize  = 1518
+	packetMRMulticast = 0
+)
+
+var lldpMulticastAddr = [8]byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// packetMreq is the struct packet_mreq of the PACKET_ADD_MEMBERSHIP option
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+type lldpPort struct {
+	node    *graph.Node
+	chassis string
+	expire  time.Time
+}
+
+// LLDPProbe listens for the LLDP frames received on the physical interfaces
+// of the host and adds the neighbor switches and their ports to the graph,
+// the ports being linked to the interfaces they are connected to. The ports
+// are removed when their LLDP information expires.
+type LLDPProbe struct {
+	Graph      *graph.Graph
+	Root       *graph.Node
+	interfaces []string
+	state      int64
+	quit       chan bool
+	wg         sync.WaitGroup
+	// protected by the graph lock
+	switches map[string]*graph.Node
+	ports    map[string]*lldpPort
+}
+
+func htons(i uint16) uint16 {
+	return (i<<8)&0xff00 | i>>8
+}
+
+// physicalInterfaces returns the interfaces backed by a device
+func physicalInterfaces() []string {
+	var intfs []string
+
+	files, _ := ioutil.ReadDir("/sys/class/net")
+	for _, f := range files {
+		if _, err := os.Stat("/sys/class/net/" + f.Name() + "/device"); err == nil {
+			intfs = append(intfs, f.Name())
+		}
+	}
+
+	return intfs
+}
+
+func lldpSocket(intf *net.Interface) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(lldpEtherType)))
+	if err != nil {
+		return -1, err
+	}
+
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(lldpEtherType), Ifindex: intf.Index}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	mreq := packetMreq{ifindex: int32(intf.Index), mrType: packetMRMulticast, alen: 6, address: lldpMulticastAddr}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+		uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0); errno != 0 {
+		syscall.Close(fd)
+		return -1, errno
+	}
+
+	tv := syscall.NsecToTimeval(lldpReadTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+func lldpID(id []byte, mac bool) string {
+	if mac && len(id) == 6 {
+		return net.HardwareAddr(id).String()
+	}
+	return string(id)
+}
+
+func (probe *LLDPProbe) handleFrame(ifName string, data []byte) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+
+	layer := packet.Layer(layers.LayerTypeLinkLayerDiscovery)
+	if layer == nil {
+		return
+	}
+	lldp := layer.(*layers.LinkLayerDiscovery)
+
+	chassisID := lldpID(lldp.ChassisID.ID, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr)
+	portID := lldpID(lldp.PortID.ID, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr)
+
+	switchMetadata := graph.Metadata{
+		"Type":           "switch",
+		"Name":           chassisID,
+		"LLDP.ChassisID": chassisID,
+	}
+	portMetadata := graph.Metadata{
+		"Type":           "switchport",
+		"Name":           portID,
+		"LLDP.ChassisID": chassisID,
+		"LLDP.PortID":    portID,
+	}
+
+	if layer := packet.Layer(layers.LayerTypeLinkLayerDiscoveryInfo); layer != nil {
+		info := layer.(*layers.LinkLayerDiscoveryInfo)
+		if info.SysName != "" {
+			switchMetadata["Name"] = info.SysName
+			switchMetadata["LLDP.SysName"] = info.SysName
+		}
+		if info.SysDescription != "" {
+			switchMetadata["LLDP.SysDescription"] = info.SysDescription
+		}
+		if len(info.MgmtAddress.Address) == 4 || len(info.MgmtAddress.Address) == 16 {
+			switchMetadata["LLDP.MgmtAddress"] = net.IP(info.MgmtAddress.Address).String()
+		}
+		if info.PortDescription != "" {
+			portMetadata["LLDP.PortDescription"] = info.PortDescription
+		}
+	}
+
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	sw, ok := probe.switches[chassisID]
+	if !ok {
+		sw = probe.Graph.NewNode(probe.Graph.GenID(switchMetadata), switchMetadata)
+		probe.switches[chassisID] = sw
+	} else if !reflect.DeepEqual(sw.Metadata(), switchMetadata) {
+		probe.Graph.SetMetadata(sw, switchMetadata)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", ifName, chassisID, portID)
+	port, ok := probe.ports[key]
+	if !ok {
+		port = &lldpPort{
+			node:    probe.Graph.NewNode(probe.Graph.GenID(portMetadata), portMetadata),
+			chassis: chassisID,
+		}
+		probe.Graph.Link(sw, port.node, graph.Metadata{"RelationType": "ownership"})
+
+		if intf := probe.Graph.LookupFirstChild(probe.Root, graph.Metadata{"Name": ifName}); intf != nil {
+			probe.Graph.Link(port.node, intf, graph.Metadata{"RelationType": "layer2", "Type": "lldp"})
+		}
+		probe.ports[key] = port
+	}
+	port.expire = time.Now().Add(time.Duration(lldp.TTL) * time.Second)
+}
+
+func (probe *LLDPProbe) expire(now time.Time) {
+	probe.Graph.Lock()
+	defer probe.Graph.Unlock()
+
+	for key, port := range probe.ports {
+		if now.Before(port.expire) {
+			continue
+		}
+
+		probe.Graph.DelNode(port.node)
+		delete(probe.ports, key)
+
+		if sw := probe.switches[port.chassis]; sw != nil && len(probe.Graph.LookupChildren(sw, graph.Metadata{})) == 0 {
+			probe.Graph.DelNode(sw)
+			delete(probe.switches, port.chassis)
+		}
+	}
+}
+
+func (probe *LLDPProbe) listen(ifName string) {
+	defer probe.wg.Done()
+
+	intf, err := net.InterfaceByName(ifName)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to listen for LLDP frames on %s: %s", ifName, err.Error())
+		return
+	}
+
+	fd, err := lldpSocket(intf)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to listen for LLDP frames on %s: %s", ifName, err.Error())
+		return
+	}
+	defer syscall.Close(fd)
+
+	data := make([]byte, lldpMaxFrameSize)
+	for atomic.LoadInt64(&probe.state) == RunningState {
+		n, _, err := syscall.Recvfrom(fd, data, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			logging.GetLogger().Errorf("Error while reading LLDP frames on %s: %s", ifName, err.Error())
+			return
+		}
+		probe.handleFrame(ifName, data[:n])
+	}
+}
+
+func (probe *LLDPProbe) Start() {
+	if !atomic.CompareAndSwapInt64(&probe.state, StoppedState, RunningState) {
+		return
+	}
+
+	probe.quit = make(chan bool)
+
+	interfaces := probe.interfaces
+	if len(interfaces) == 0 {
+		interfaces = physicalInterfaces()
+	}
+
+	for _, intf := range interfaces {
+		probe.wg.Add(1)
+		go probe.listen(intf)
+	}
+
+	probe.wg.Add(1)
+	go func() {
+		defer probe.wg.Done()
+
+		ticker := time.NewTicker(lldpExpirePeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				probe.expire(now)
+			case <-probe.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (probe *LLDPProbe) Stop() {
+	if !atomic.CompareAndSwapInt64(&probe.state, RunningState, StoppingState) {
+		return
+	}
+
+	close(probe.quit)
+	probe.wg.Wait()
+
+	atomic.StoreInt64(&probe.state, StoppedState)
+}
+
+// NewLLDPProbe returns a probe listening on the given interfaces, on all the
+// physical interfaces if none
+func NewLLDPProbe(g *graph.Graph, n *graph.Node, interfaces []string) *LLDPProbe {
+	return &LLDPProbe{
+		Graph:      g,
+		Root:       n,
+		interfaces: interfaces,
+		state:      StoppedState,
+		switches:   make(map[string]*graph.Node),
+		ports:      make(map[string]*lldpPort),
+	}
+}
+
+func NewLLDPProbeFromConfig(g *graph.Graph, n *graph.Node) *LLDPProbe {
+	return NewLLDPProbe(g, n, config.GetConfig().GetStringSlice("lldp.interfaces"))
+}
@@ -23,6 +23,7 @@
 package probes
 
 import (
+	"math"
 	"net"
 	"strings"
 	"sync"
@@ -35,65 +36,247 @@ import (
 
 	"github.com/safchain/ethtool"
 
+	"github.com/redhat-cip/skydive/config"
 	"github.com/redhat-cip/skydive/logging"
 	"github.com/redhat-cip/skydive/topology/graph"
 )
 
 const (
 	maxEpollEvents = 32
+	iflaStats64    = 23
+
+	// attributes of the slave info of the interfaces enslaved to a bridge
+	// or a bond, missing from the netlink package
+	iflaInfoSlaveKind      = 4
+	iflaInfoSlaveData      = 5
+	iflaBondSlaveState     = 1
+	iflaBondSlaveMiiStatus = 2
 )
 
+// states of the bridge ports, of the bond slaves and of their links, as
+// defined by if_bridge.h and if_bonding.h
+var (
+	stpStates       = []string{"disabled", "listening", "learning", "forwarding", "blocking"}
+	bondSlaveStates = []string{"active", "backup"}
+	bondMiiStatus   = []string{"up", "fail", "down", "back"}
+)
+
+// masterTypes are the types of the interfaces to which others can be
+// enslaved
+var masterTypes = graph.Within("bridge", "bond", "vrf")
+
+// metadata keys of the counters of rtnl_link_stats64, in the order of the
+// structure, the rates being stored with the Rate suffix
+var linkStatsKeys = []string{"RxPackets", "TxPackets", "RxBytes", "TxBytes", "RxErrors", "TxErrors", "RxDropped", "TxDropped"}
+
+type linkStats struct {
+	time     time.Time
+	counters []uint64
+}
+
+// pendingLink is a link to an interface not yet added, the metadata of the
+// edge being computed once added if not given
+type pendingLink struct {
+	id       graph.Identifier
+	index    int64
+	metadata graph.Metadata
+}
+
 type NetLinkProbe struct {
 	Graph                *graph.Graph
 	Root                 *graph.Node
 	nlSocket             *nl.NetlinkSocket
 	state                int64
-	indexToChildrenQueue map[int64][]graph.Identifier
+	indexToChildrenQueue map[int64][]pendingLink
 	wg                   sync.WaitGroup
+	// interval between the samplings of the interface statistics, and
+	// relative change of a rate required to update the metadata
+	statsInterval time.Duration
+	statsDelta    float64
+	linkStats     map[int64]*linkStats
+}
+
+// slaveMetadata returns the state of an interface enslaved to a bridge or
+// a bond, read from the slave info of the link
+func slaveMetadata(index int64) graph.Metadata {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil || len(msgs) == 0 {
+		return nil
+	}
+
+	attrs, err := nl.ParseRouteAttr(msgs[0][msg.Len():])
+	if err != nil {
+		return nil
+	}
+
+	var kind string
+	var data []syscall.NetlinkRouteAttr
+	for _, attr := range attrs {
+		if attr.Attr.Type != syscall.IFLA_LINKINFO {
+			continue
+		}
+
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil
+		}
+
+		for _, info := range infos {
+			switch info.Attr.Type {
+			case iflaInfoSlaveKind:
+				kind = strings.TrimRight(string(info.Value), "\x00")
+			case iflaInfoSlaveData:
+				data, _ = nl.ParseRouteAttr(info.Value)
+			}
+		}
+	}
+
+	state := func(states []string, value []byte) string {
+		if len(value) > 0 && int(value[0]) < len(states) {
+			return states[value[0]]
+		}
+		return "unknown"
+	}
+
+	m := graph.Metadata{}
+	for _, attr := range data {
+		switch {
+		case kind == "bridge" && attr.Attr.Type == nl.IFLA_BRPORT_STATE:
+			m["StpState"] = state(stpStates, attr.Value)
+		case kind == "bond" && attr.Attr.Type == iflaBondSlaveState:
+			m["BondSlaveState"] = state(bondSlaveStates, attr.Value)
+		case kind == "bond" && attr.Attr.Type == iflaBondSlaveMiiStatus:
+			m["MiiStatus"] = state(bondMiiStatus, attr.Value)
+		}
+	}
+
+	return m
+}
+
+// masterEdgeMetadata returns the metadata of the edge between a bridge, a
+// bond or a vrf and an interface enslaved to it. The vrf enslavement
+// doesn't imply any layer2 connectivity.
+func masterEdgeMetadata(master *graph.Node, index int64) graph.Metadata {
+	m := graph.Metadata{"RelationType": "layer2"}
+
+	switch t := master.Metadata()["Type"]; t {
+	case "vrf":
+		m["RelationType"] = "membership"
+		m["Type"] = t
+	case "bond":
+		m["Type"] = t
+		if mode, ok := master.Metadata()["BondMode"]; ok {
+			m["BondMode"] = mode
+		}
+	case "bridge":
+		m["Type"] = t
+	}
+
+	for k, v := range slaveMetadata(index) {
+		m[k] = v
+	}
+
+	return m
+}
+
+// linkChild links the interface to one of its children, updating the
+// metadata of the edge if already linked
+func (u *NetLinkProbe) linkChild(intf *graph.Node, child *graph.Node, m graph.Metadata) {
+	e := u.Graph.GetFirstLink(intf, child, graph.Metadata{"RelationType": m["RelationType"]})
+	if e == nil {
+		u.Graph.Link(intf, child, m)
+		return
+	}
+
+	tr := u.Graph.StartMetadataTransaction(e)
+	for k, v := range m {
+		tr.AddMetadata(k, v)
+	}
+	tr.Commit()
 }
 
 func (u *NetLinkProbe) linkMasterChildren(intf *graph.Node, index int64) {
 	// add children of this interface that haven previously added
 	if children, ok := u.indexToChildrenQueue[index]; ok {
-		for _, id := range children {
-			child := u.Graph.GetNode(id)
-			if child != nil {
-				u.Graph.Link(intf, child, graph.Metadata{"RelationType": "layer2"})
+		for _, pending := range children {
+			child := u.Graph.GetNode(pending.id)
+			if child == nil {
+				continue
 			}
+
+			m := pending.metadata
+			if m == nil {
+				m = masterEdgeMetadata(intf, pending.index)
+			}
+			u.linkChild(intf, child, m)
 		}
 		delete(u.indexToChildrenQueue, index)
 	}
 }
 
+// linkParent links the interface to its parent, or enqueues the link until
+// the parent is added. The metadata of the edge are computed according to
+// the type of the parent if not given.
+func (u *NetLinkProbe) linkParent(intf *graph.Node, link netlink.Link, index int64, m graph.Metadata) {
+	// assuming we have only one parent with this index
+	parent := u.Graph.LookupFirstChild(u.Root, graph.Metadata{"IfIndex": index})
+	if parent == nil {
+		pending := pendingLink{id: intf.ID, index: int64(link.Attrs().Index), metadata: m}
+		u.indexToChildrenQueue[index] = append(u.indexToChildrenQueue[index], pending)
+		return
+	}
+
+	// ignore ovs-system interface as it doesn't make any sense according to
+	// the following thread:
+	// http://openvswitch.org/pipermail/discuss/2013-October/011657.html
+	if parent.Metadata()["Name"] == "ovs-system" {
+		return
+	}
+
+	if m == nil {
+		m = masterEdgeMetadata(parent, int64(link.Attrs().Index))
+	}
+	u.linkChild(parent, intf, m)
+}
+
 func (u *NetLinkProbe) handleIntfIsChild(intf *graph.Node, link netlink.Link) {
 	u.linkMasterChildren(intf, int64(link.Attrs().Index))
 
-	// interface being a part of a bridge
-	if link.Attrs().MasterIndex != 0 {
-		index := int64(link.Attrs().MasterIndex)
-
-		// assuming we have only one parent with this index
-		parent := u.Graph.LookupFirstChild(u.Root, graph.Metadata{"IfIndex": index})
-		if parent == nil {
-			return
+	// release the interface from its previous masters
+	master := int64(link.Attrs().MasterIndex)
+	for _, parent := range u.Graph.LookupParentNodes(intf, nil) {
+		if parent.Metadata()["IfIndex"] == master {
+			continue
 		}
-
-		// ignore ovs-system interface as it doesn't make any sense according to
-		// the following thread:
-		// http://openvswitch.org/pipermail/discuss/2013-October/011657.html
-		if parent.Metadata()["Name"] == "ovs-system" {
-			return
+		if e := u.Graph.GetFirstLink(parent, intf, graph.Metadata{"Type": masterTypes}); e != nil {
+			u.Graph.DelEdge(e)
 		}
+	}
 
-		if parent != nil && !u.Graph.AreLinked(parent, intf) {
-			u.Graph.Link(parent, intf, graph.Metadata{"RelationType": "layer2"})
-		} else {
-			// not yet the bridge so, enqueue for a later add
-			u.indexToChildrenQueue[index] = append(u.indexToChildrenQueue[index], intf.ID)
-		}
+	// interface being a part of a bridge, a bond or a vrf
+	if master != 0 {
+		u.linkParent(intf, link, master, nil)
 	}
 }
 
+func (u *NetLinkProbe) handleIntfIsVlan(intf *graph.Node, link netlink.Link) {
+	vlan, ok := link.(*netlink.Vlan)
+	if !ok || link.Attrs().ParentIndex == 0 {
+		return
+	}
+
+	u.linkParent(intf, link, int64(link.Attrs().ParentIndex), graph.Metadata{
+		"RelationType": "layer2",
+		"Type":         "vlan",
+		"Vlan":         vlan.VlanId,
+	})
+}
+
 func (u *NetLinkProbe) handleIntfIsVeth(intf *graph.Node, link netlink.Link) {
 	if link.Type() != "veth" {
 		return
@@ -180,7 +363,7 @@ func (u *NetLinkProbe) addGenericLinkToTopology(link netlink.Link, m graph.Metad
 	}
 
 	if intf == nil {
-		intf = u.Graph.NewNode(graph.GenID(), m)
+		intf = u.Graph.NewNode(u.Graph.GenID(m), m)
 	}
 
 	if intf == nil {
@@ -195,12 +378,14 @@ func (u *NetLinkProbe) addGenericLinkToTopology(link netlink.Link, m graph.Metad
 	// the following thread:
 	// http://openvswitch.org/pipermail/discuss/2013-October/011657.html
 	if name == "ovs-system" {
+		delete(u.indexToChildrenQueue, index)
 		return intf
 	}
 
+	u.handleIntfIsBond(intf, link)
 	u.handleIntfIsChild(intf, link)
+	u.handleIntfIsVlan(intf, link)
 	u.handleIntfIsVeth(intf, link)
-	u.handleIntfIsBond(intf, link)
 
 	return intf
 }
@@ -215,7 +400,7 @@ func (u *NetLinkProbe) addBridgeLinkToTopology(link netlink.Link, m graph.Metada
 	})
 
 	if intf == nil {
-		intf = u.Graph.NewNode(graph.GenID(), m)
+		intf = u.Graph.NewNode(u.Graph.GenID(m), m)
 	}
 
 	if !u.Graph.AreLinked(u.Root, intf) {
@@ -232,7 +417,7 @@ func (u *NetLinkProbe) addOvsLinkToTopology(link netlink.Link, m graph.Metadata)
 
 	intf := u.Graph.LookupFirstNode(graph.Metadata{"Name": name, "Driver": "openvswitch"})
 	if intf == nil {
-		intf = u.Graph.NewNode(graph.GenID(), m)
+		intf = u.Graph.NewNode(u.Graph.GenID(m), m)
 	}
 
 	if !u.Graph.AreLinked(u.Root, intf) {
@@ -242,19 +427,19 @@ func (u *NetLinkProbe) addOvsLinkToTopology(link netlink.Link, m graph.Metadata)
 	return intf
 }
 
-func (u *NetLinkProbe) getLinkIPV4Addr(link netlink.Link) string {
-	var ipv4 []string
+func (u *NetLinkProbe) getLinkAddrs(link netlink.Link, family int) string {
+	var ips []string
 
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	addrs, err := netlink.AddrList(link, family)
 	if err != nil {
 		return ""
 	}
 
 	for _, addr := range addrs {
-		ipv4 = append(ipv4, addr.IPNet.String())
+		ips = append(ips, addr.IPNet.String())
 	}
 
-	return strings.Join(ipv4, ", ")
+	return strings.Join(ips, ", ")
 }
 
 func (u *NetLinkProbe) addLinkToTopology(link netlink.Link) {
@@ -277,11 +462,21 @@ func (u *NetLinkProbe) addLinkToTopology(link netlink.Link) {
 		"Driver":  driver,
 	}
 
-	ipv4 := u.getLinkIPV4Addr(link)
+	// the offload settings often explain the capture anomalies
+	for k, v := range ethtoolMetadata(link.Attrs().Name) {
+		metadata[k] = v
+	}
+
+	ipv4 := u.getLinkAddrs(link, netlink.FAMILY_V4)
 	if len(ipv4) > 0 {
 		metadata["IPV4"] = ipv4
 	}
 
+	ipv6 := u.getLinkAddrs(link, netlink.FAMILY_V6)
+	if len(ipv6) > 0 {
+		metadata["IPV6"] = ipv6
+	}
+
 	if vlan, ok := link.(*netlink.Vlan); ok {
 		metadata["Vlan"] = vlan.VlanId
 	}
@@ -321,6 +516,9 @@ func (u *NetLinkProbe) addLinkToTopology(link netlink.Link) {
 		if updated {
 			u.Graph.SetMetadata(intf, m)
 		}
+
+		u.handleIntfIsPF(intf, link)
+		u.handleIntfIsVF(intf)
 	}
 }
 
@@ -334,30 +532,33 @@ func (u *NetLinkProbe) onLinkAdded(index int) {
 	u.addLinkToTopology(link)
 }
 
-func (u *NetLinkProbe) onLinkDeleted(index int) {
-	logging.GetLogger().Debugf("Link %d deleted", index)
-
-	u.Graph.Lock()
-	defer u.Graph.Unlock()
-
-	var intf *graph.Node
-
-	intfs := u.Graph.LookupNodes(graph.Metadata{"IfIndex": int64(index)})
+// intfByIndex returns the interface node of an index, preferring the one
+// attached to the root node
+func (u *NetLinkProbe) intfByIndex(index int64) *graph.Node {
+	intfs := u.Graph.LookupNodes(graph.Metadata{"IfIndex": index})
 	switch l := len(intfs); {
 	case l == 1:
-		intf = intfs[0]
+		return intfs[0]
 	case l > 1:
-	Loop:
 		for _, i := range intfs {
 			parents := u.Graph.LookupParentNodes(i, nil)
 			for _, parent := range parents {
 				if parent.ID == u.Root.ID {
-					intf = i
-					break Loop
+					return i
 				}
 			}
 		}
 	}
+	return nil
+}
+
+func (u *NetLinkProbe) onLinkDeleted(index int) {
+	logging.GetLogger().Debugf("Link %d deleted", index)
+
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	intf := u.intfByIndex(int64(index))
 
 	// case of removing the interface from a bridge
 	if intf != nil {
@@ -375,6 +576,10 @@ func (u *NetLinkProbe) onLinkDeleted(index int) {
 		if intf.Metadata()["Driver"] == "openvswitch" {
 			u.Graph.Unlink(u.Root, intf)
 		} else {
+			// the virtual functions of a physical function
+			for _, vf := range u.Graph.LookupChildren(intf, graph.Metadata{"Type": "vf"}) {
+				u.Graph.DelNode(vf)
+			}
 			u.Graph.DelNode(intf)
 		}
 	}
@@ -382,6 +587,99 @@ func (u *NetLinkProbe) onLinkDeleted(index int) {
 	delete(u.indexToChildrenQueue, int64(index))
 }
 
+// dumpLinkStats returns the counters of the interfaces of the namespace
+// of the current thread, by index
+func dumpLinkStats() (map[int64][]uint64, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[int64][]uint64)
+	for _, m := range msgs {
+		ifmsg := nl.DeserializeIfInfomsg(m)
+		attrs, err := nl.ParseRouteAttr(m[ifmsg.Len():])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type != iflaStats64 || len(attr.Value) < 8*len(linkStatsKeys) {
+				continue
+			}
+
+			counters := make([]uint64, len(linkStatsKeys))
+			for i := range counters {
+				counters[i] = nl.NativeEndian().Uint64(attr.Value[i*8:])
+			}
+			stats[int64(ifmsg.Index)] = counters
+		}
+	}
+
+	return stats, nil
+}
+
+// updateStats samples the statistics of the interfaces and stores the
+// counters and the rates per second in the metadata when a rate changed
+// by more than the configured delta
+func (u *NetLinkProbe) updateStats() {
+	stats, err := dumpLinkStats()
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to get the interfaces statistics: %s", err.Error())
+		return
+	}
+	now := time.Now()
+
+	u.Graph.Lock()
+	defer u.Graph.Unlock()
+
+	previous := u.linkStats
+	u.linkStats = make(map[int64]*linkStats)
+	for index, counters := range stats {
+		u.linkStats[index] = &linkStats{time: now, counters: counters}
+
+		prev, ok := previous[index]
+		if !ok {
+			continue
+		}
+		elapsed := now.Sub(prev.time).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		intf := u.intfByIndex(index)
+		if intf == nil {
+			continue
+		}
+		m := intf.Metadata()
+
+		updated := false
+		rates := make([]float64, len(counters))
+		for i, key := range linkStatsKeys {
+			// a counter going backward has been reset
+			if counters[i] >= prev.counters[i] {
+				rates[i] = float64(counters[i]-prev.counters[i]) / elapsed
+			}
+
+			old, ok := m[key+"Rate"].(float64)
+			if !ok || math.Abs(rates[i]-old) > u.statsDelta*old {
+				updated = true
+			}
+		}
+
+		if updated {
+			for i, key := range linkStatsKeys {
+				m[key] = int64(counters[i])
+				m[key+"Rate"] = rates[i]
+			}
+			u.Graph.SetMetadata(intf, m)
+		}
+	}
+}
+
 func (u *NetLinkProbe) initialize() {
 	links, err := netlink.LinkList()
 	if err != nil {
@@ -431,8 +729,17 @@ func (u *NetLinkProbe) start() {
 	u.wg.Add(1)
 	defer u.wg.Done()
 
+	var lastStats time.Time
+
 	atomic.StoreInt64(&u.state, RunningState)
 	for atomic.LoadInt64(&u.state) == RunningState {
+		// sampled here as the probe may run in a namespace, locked to the
+		// thread
+		if u.statsInterval > 0 && time.Since(lastStats) >= u.statsInterval {
+			u.updateStats()
+			lastStats = time.Now()
+		}
+
 		n, err := syscall.EpollWait(epfd, events[:], 1000)
 		if err != nil {
 			errno, ok := err.(syscall.Errno)
@@ -484,8 +791,11 @@ func NewNetLinkProbe(g *graph.Graph, n *graph.Node) *NetLinkProbe {
 	np := &NetLinkProbe{
 		Graph:                g,
 		Root:                 n,
-		indexToChildrenQueue: make(map[int64][]graph.Identifier),
+		indexToChildrenQueue: make(map[int64][]pendingLink),
 		state:                StoppedState,
+		statsInterval:        time.Duration(config.GetConfig().GetInt("netlink.stats_interval")) * time.Second,
+		statsDelta:           config.GetConfig().GetFloat64("netlink.stats_delta"),
+		linkStats:            make(map[int64]*linkStats),
 	}
 	return np
 }
@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -48,6 +49,11 @@ type NetNSProbe struct {
 	nsnlProbes  map[string]*NetNsNetLinkTopoUpdater
 	pathToNetNS map[string]*NetNs
 	runPath     string
+
+	// namespaces found by the scans, nested in other namespaces, and the
+	// path they were registered with
+	nested       map[string]string
+	scanInterval time.Duration
 }
 
 type NetNs struct {
@@ -73,6 +79,14 @@ func (ns *NetNs) String() string {
 	return fmt.Sprintf("%d,%d", ns.dev, ns.ino)
 }
 
+func getNetNs(path string) (*NetNs, error) {
+	var s syscall.Stat_t
+	if err := syscall.Stat(path, &s); err != nil {
+		return nil, err
+	}
+	return &NetNs{path: path, dev: s.Dev, ino: s.Ino}, nil
+}
+
 func (nu *NetNsNetLinkTopoUpdater) Start(ns *NetNs) {
 	logging.GetLogger().Debugf("Starting NetLinkTopoUpdater for NetNS: %s", ns.path)
 
@@ -137,6 +151,12 @@ func NewNetNsNetLinkTopoUpdater(g *graph.Graph, n *graph.Node) *NetNsNetLinkTopo
 }
 
 func (u *NetNSProbe) Register(path string, extraMetadata graph.Metadata) *graph.Node {
+	return u.register(path, u.Root, extraMetadata)
+}
+
+// register a namespace owned by parent, either the host or the namespace
+// it is nested in
+func (u *NetNSProbe) register(path string, parent *graph.Node, extraMetadata graph.Metadata) *graph.Node {
 	ns, ok := u.pathToNetNS[path]
 	if !ok {
 		var s syscall.Stat_t
@@ -169,14 +189,14 @@ func (u *NetNSProbe) Register(path string, extraMetadata graph.Metadata) *graph.
 	defer u.Graph.Unlock()
 
 	logging.GetLogger().Debugf("Network Namespace added: %s", nsString)
-	metadata := graph.Metadata{"Name": getNetNSName(path), "Type": "netns", "Path": path}
+	metadata := graph.Metadata{"Name": getNetNSName(path), "Type": "netns", "Path": path, "Inode": int64(ns.ino)}
 	if extraMetadata != nil {
 		for k, v := range extraMetadata {
 			metadata[k] = v
 		}
 	}
-	n := u.Graph.NewNode(graph.GenID(), metadata)
-	u.Graph.Link(u.Root, n, graph.Metadata{"RelationType": "ownership"})
+	n := u.Graph.NewNode(u.Graph.GenID(metadata), metadata)
+	u.Graph.Link(parent, n, graph.Metadata{"RelationType": "ownership"})
 
 	nu := NewNetNsNetLinkTopoUpdater(u.Graph, n)
 	go nu.Start(ns)
@@ -219,6 +239,10 @@ func (u *NetNSProbe) Unregister(path string) {
 
 	children := nu.Graph.LookupChildren(nu.Root, graph.Metadata{})
 	for _, child := range children {
+		// the nested namespaces are removed by the scans once gone
+		if t, _ := child.Metadata()["Type"]; t == "netns" {
+			continue
+		}
 		u.Graph.DelNode(child)
 	}
 	u.Graph.DelNode(nu.Root)
@@ -226,6 +250,205 @@ func (u *NetNSProbe) Unregister(path string) {
 	delete(u.nsnlProbes, nsString)
 }
 
+type nsProcess struct {
+	pid  int
+	ppid int
+	ns   *NetNs
+	mnt  *NetNs
+}
+
+type nestedNetNs struct {
+	ns       *NetNs
+	parent   string
+	metadata graph.Metadata
+}
+
+func getPPid(pid int) (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "PPid:") {
+			return strconv.Atoi(strings.TrimSpace(line[5:]))
+		}
+	}
+	return 0, fmt.Errorf("No parent found for process %d", pid)
+}
+
+func getProcesses() map[int]*nsProcess {
+	processes := make(map[int]*nsProcess)
+
+	files, _ := ioutil.ReadDir("/proc")
+	for _, f := range files {
+		pid, err := strconv.Atoi(f.Name())
+		if err != nil {
+			continue
+		}
+
+		// the process may be already gone
+		ns, err := getNetNs(fmt.Sprintf("/proc/%d/ns/net", pid))
+		if err != nil {
+			continue
+		}
+		mnt, err := getNetNs(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+		if err != nil {
+			continue
+		}
+		ppid, err := getPPid(pid)
+		if err != nil {
+			continue
+		}
+
+		processes[pid] = &nsProcess{pid: pid, ppid: ppid, ns: ns, mnt: mnt}
+	}
+
+	return processes
+}
+
+// lookupNetNs returns the node of a namespace whatever the probe, docker,
+// netns, ... which registered it
+func (u *NetNSProbe) lookupNetNs(ns string) *graph.Node {
+	var dev, ino uint64
+	if _, err := fmt.Sscanf(ns, "%d,%d", &dev, &ino); err != nil {
+		return nil
+	}
+
+	u.Graph.RLock()
+	defer u.Graph.RUnlock()
+
+	return u.Graph.LookupFirstNode(graph.Metadata{"Type": "netns", "Inode": int64(ino)})
+}
+
+// nestedNamespaces returns the namespaces created inside other namespaces
+// than the host one, by the processes of the containers or bound in their
+// own run path, indexed by namespace
+func (u *NetNSProbe) nestedNamespaces() map[string]*nestedNetNs {
+	nested := make(map[string]*nestedNetNs)
+
+	// the agent lives in the host namespaces
+	processes := getProcesses()
+	host, ok := processes[os.Getpid()]
+	if !ok {
+		logging.GetLogger().Errorf("Unable to find the host network namespace")
+		return nested
+	}
+
+	// the first process of a namespace is the one whose parent lives in
+	// another namespace, the namespace is nested in the one of this parent
+	first := make(map[string]*nsProcess)
+	for _, p := range processes {
+		if p.ns.String() == host.ns.String() {
+			continue
+		}
+		if f, ok := first[p.ns.String()]; !ok || p.pid < f.pid {
+			first[p.ns.String()] = p
+		}
+
+		parent, ok := processes[p.ppid]
+		if !ok || parent.ns.String() == host.ns.String() || parent.ns.String() == p.ns.String() {
+			continue
+		}
+
+		if n, ok := nested[p.ns.String()]; ok && n.metadata["PID"].(int) < p.pid {
+			continue
+		}
+
+		comm, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", p.pid))
+		nested[p.ns.String()] = &nestedNetNs{
+			ns:     p.ns,
+			parent: parent.ns.String(),
+			metadata: graph.Metadata{
+				"Name": fmt.Sprintf("%s-%d", strings.TrimSpace(string(comm)), p.pid),
+				"PID":  p.pid,
+			},
+		}
+	}
+
+	// namespaces created with "ip netns" inside a container are bound in
+	// its own mount namespace, out of the reach of the watcher
+	for ns, p := range first {
+		if p.mnt.String() == host.mnt.String() {
+			continue
+		}
+
+		runPath := fmt.Sprintf("/proc/%d/root%s", p.pid, u.runPath)
+		files, _ := ioutil.ReadDir(runPath)
+		for _, f := range files {
+			bound, err := getNetNs(runPath + "/" + f.Name())
+			if err != nil || bound.String() == ns || bound.String() == host.ns.String() {
+				continue
+			}
+
+			if _, ok := nested[bound.String()]; !ok {
+				nested[bound.String()] = &nestedNetNs{ns: bound, parent: ns}
+			}
+		}
+	}
+
+	return nested
+}
+
+// scan registers the namespaces of the run path which were missed by the
+// watcher and the nested namespaces, unregisters the vanished ones
+func (u *NetNSProbe) scan() {
+	files, _ := ioutil.ReadDir(u.runPath)
+	paths := make(map[string]bool)
+	for _, f := range files {
+		path := u.runPath + "/" + f.Name()
+		paths[path] = true
+
+		if _, ok := u.pathToNetNS[path]; !ok {
+			u.Register(path, nil)
+		}
+	}
+
+	for path := range u.pathToNetNS {
+		if strings.HasPrefix(path, u.runPath+"/") && !paths[path] {
+			u.Unregister(path)
+		}
+	}
+
+	nested := u.nestedNamespaces()
+
+	for ns, path := range u.nested {
+		if _, ok := nested[ns]; !ok {
+			logging.GetLogger().Debugf("Nested network namespace %s vanished", path)
+			u.Unregister(path)
+			delete(u.nested, ns)
+		}
+	}
+
+	// register the parents before their children, a namespace nested in
+	// a not yet registered one is skipped until the next round
+	for len(nested) > 0 {
+		registered := 0
+		for ns, n := range nested {
+			if u.lookupNetNs(ns) != nil {
+				delete(nested, ns)
+				continue
+			}
+
+			parent := u.lookupNetNs(n.parent)
+			if parent == nil {
+				continue
+			}
+
+			logging.GetLogger().Debugf("Nested network namespace %s found in %s", n.ns.path, n.parent)
+			if u.register(n.ns.path, parent, n.metadata) != nil {
+				u.nested[ns] = n.ns.path
+			}
+			delete(nested, ns)
+			registered++
+		}
+
+		if registered == 0 {
+			break
+		}
+	}
+}
+
 func (u *NetNSProbe) initialize() {
 	files, _ := ioutil.ReadDir(u.runPath)
 	for _, f := range files {
@@ -259,8 +482,19 @@ func (u *NetNSProbe) start() {
 
 	u.initialize()
 
+	var scan <-chan time.Time
+	if u.scanInterval > 0 {
+		ticker := time.NewTicker(u.scanInterval)
+		defer ticker.Stop()
+
+		u.scan()
+		scan = ticker.C
+	}
+
 	for {
 		select {
+		case <-scan:
+			u.scan()
 		case ev := <-watcher.Event:
 			if ev.Mask&inotify.IN_CREATE > 0 {
 				u.Register(ev.Name, nil)
@@ -304,10 +538,13 @@ func NewNetNSProbe(g *graph.Graph, n *graph.Node, runPath ...string) *NetNSProbe
 		nsnlProbes:  make(map[string]*NetNsNetLinkTopoUpdater),
 		pathToNetNS: make(map[string]*NetNs),
 		runPath:     path,
+		nested:      make(map[string]string),
 	}
 }
 
 func NewNetNSProbeFromConfig(g *graph.Graph, n *graph.Node) *NetNSProbe {
 	path := config.GetConfig().GetString("netns.run_path")
-	return NewNetNSProbe(g, n, path)
+	probe := NewNetNSProbe(g, n, path)
+	probe.scanInterval = time.Duration(config.GetConfig().GetInt("netns.scan_interval")) * time.Second
+	return probe
 }
@@ -25,6 +25,7 @@ package probes
 import (
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rackspace/gophercloud"
@@ -34,6 +35,7 @@ import (
 	"github.com/rackspace/gophercloud/openstack/networking/v2/ports"
 	"github.com/rackspace/gophercloud/pagination"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/pmylund/go-cache"
 
 	"github.com/redhat-cip/skydive/config"
@@ -50,10 +52,30 @@ type NeutronMapper struct {
 }
 
 type Attributes struct {
-	NetworkID   string
-	NetworkName string
-	TenantID    string
-	VNI         string
+	PortID             string
+	NetworkID          string
+	NetworkName        string
+	TenantID           string
+	VNI                string
+	IPs                []string
+	SubnetIDs          []string
+	SubnetNames        []string
+	SubnetCIDRs        []string
+	SecurityGroupIDs   []string
+	SecurityGroupNames []string
+}
+
+// the vendored gophercloud doesn't provide the subnets and the security
+// groups APIs, only the fields used by the mapper are decoded
+type subnet struct {
+	ID   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
+	CIDR string `mapstructure:"cidr"`
+}
+
+type securityGroup struct {
+	ID   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
 }
 
 func (mapper *NeutronMapper) retrievePort(metadata graph.Metadata) (port ports.Port, err error) {
@@ -102,6 +124,56 @@ func (mapper *NeutronMapper) retrievePort(metadata graph.Metadata) (port ports.P
 	return port, err
 }
 
+func (mapper *NeutronMapper) retrieveSubnet(id string) (*subnet, error) {
+	if s, f := mapper.cache.Get("subnet/" + id); f {
+		return s.(*subnet), nil
+	}
+
+	var body interface{}
+	if _, err := mapper.client.Get(mapper.client.ServiceURL("subnets", id), &body, nil); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Subnet *subnet `mapstructure:"subnet"`
+	}
+	if err := mapstructure.WeakDecode(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Subnet == nil {
+		return nil, errors.New("Unable to find subnet: " + id)
+	}
+
+	mapper.cache.Set("subnet/"+id, res.Subnet, cache.DefaultExpiration)
+
+	return res.Subnet, nil
+}
+
+func (mapper *NeutronMapper) retrieveSecurityGroup(id string) (*securityGroup, error) {
+	if sg, f := mapper.cache.Get("secgroup/" + id); f {
+		return sg.(*securityGroup), nil
+	}
+
+	var body interface{}
+	if _, err := mapper.client.Get(mapper.client.ServiceURL("security-groups", id), &body, nil); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		SecurityGroup *securityGroup `mapstructure:"security_group"`
+	}
+	if err := mapstructure.WeakDecode(body, &res); err != nil {
+		return nil, err
+	}
+	if res.SecurityGroup == nil {
+		return nil, errors.New("Unable to find security group: " + id)
+	}
+
+	mapper.cache.Set("secgroup/"+id, res.SecurityGroup, cache.DefaultExpiration)
+
+	return res.SecurityGroup, nil
+}
+
 func (mapper *NeutronMapper) retrieveAttributes(metadata graph.Metadata) (*Attributes, error) {
 	port, err := mapper.retrievePort(metadata)
 	if err != nil {
@@ -115,12 +187,35 @@ func (mapper *NeutronMapper) retrieveAttributes(metadata graph.Metadata) (*Attri
 	}
 
 	a := &Attributes{
+		PortID:      port.ID,
 		NetworkID:   port.NetworkID,
 		NetworkName: network.Name,
 		TenantID:    port.TenantID,
 		VNI:         network.SegmentationID,
 	}
 
+	for _, ip := range port.FixedIPs {
+		s, err := mapper.retrieveSubnet(ip.SubnetID)
+		if err != nil {
+			return nil, err
+		}
+
+		a.IPs = append(a.IPs, ip.IPAddress)
+		a.SubnetIDs = append(a.SubnetIDs, s.ID)
+		a.SubnetNames = append(a.SubnetNames, s.Name)
+		a.SubnetCIDRs = append(a.SubnetCIDRs, s.CIDR)
+	}
+
+	for _, id := range port.SecurityGroups {
+		sg, err := mapper.retrieveSecurityGroup(id)
+		if err != nil {
+			return nil, err
+		}
+
+		a.SecurityGroupIDs = append(a.SecurityGroupIDs, sg.ID)
+		a.SecurityGroupNames = append(a.SecurityGroupNames, sg.Name)
+	}
+
 	return a, nil
 }
 
@@ -141,20 +236,32 @@ func (mapper *NeutronMapper) nodeUpdater() {
 			continue
 		}
 
+		mapper.cache.Set(string(node.ID), attrs, cache.DefaultExpiration)
+
+		mapper.graph.Lock()
 		mapper.updateNode(node, attrs)
+		mapper.graph.Unlock()
 	}
 	logging.GetLogger().Debugf("Stopping Neutron updater")
 }
 
-func (mapper *NeutronMapper) updateNode(node *graph.Node, attrs *Attributes) {
-	mapper.graph.Lock()
-	defer mapper.graph.Unlock()
+func addListMetadata(tr *graph.MetadataTransaction, k string, l []string) {
+	if len(l) > 0 {
+		tr.AddMetadata(k, strings.Join(l, ","))
+	}
+}
 
+// updateNode has to be called with the graph locked
+func (mapper *NeutronMapper) updateNode(node *graph.Node, attrs *Attributes) {
 	tr := mapper.graph.StartMetadataTransaction(node)
 	defer tr.Commit()
 
 	tr.AddMetadata("Manager", "neutron")
 
+	if attrs.PortID != "" {
+		tr.AddMetadata("Neutron.PortID", attrs.PortID)
+	}
+
 	if attrs.TenantID != "" {
 		tr.AddMetadata("Neutron.TenantID", attrs.TenantID)
 	}
@@ -167,23 +274,26 @@ func (mapper *NeutronMapper) updateNode(node *graph.Node, attrs *Attributes) {
 		tr.AddMetadata("Neutron.NetworkName", attrs.NetworkName)
 	}
 
-	if segID, err := strconv.Atoi(attrs.VNI); err != nil && segID > 0 {
+	if segID, err := strconv.Atoi(attrs.VNI); err == nil && segID > 0 {
 		tr.AddMetadata("Neutron.VNI", uint64(segID))
 	}
 
-	mapper.cache.Set(string(node.ID), attrs, cache.DefaultExpiration)
+	addListMetadata(tr, "Neutron.IPs", attrs.IPs)
+	addListMetadata(tr, "Neutron.SubnetIDs", attrs.SubnetIDs)
+	addListMetadata(tr, "Neutron.SubnetNames", attrs.SubnetNames)
+	addListMetadata(tr, "Neutron.SubnetCIDRs", attrs.SubnetCIDRs)
+	addListMetadata(tr, "Neutron.SecurityGroupIDs", attrs.SecurityGroupIDs)
+	addListMetadata(tr, "Neutron.SecurityGroupNames", attrs.SecurityGroupNames)
 }
 
 func (mapper *NeutronMapper) EnhanceNode(node *graph.Node) {
-	mac, ok := node.Metadata()["MAC"]
-	if !ok {
+	if _, ok := node.Metadata()["MAC"]; !ok {
 		return
 	}
 
-	a, f := mapper.cache.Get(mac.(string))
-	if f {
-		attrs := a.(Attributes)
-		mapper.updateNode(node, &attrs)
+	// the listeners are notified with the graph locked
+	if a, f := mapper.cache.Get(string(node.ID)); f {
+		mapper.updateNode(node, a.(*Attributes))
 		return
 	}
 
@@ -241,7 +351,7 @@ func NewNeutronMapper(g *graph.Graph, authURL string, username string, password
 	mapper.cache = cache.New(time.Duration(expire)*time.Second, time.Duration(cleanup)*time.Second)
 	mapper.nodeUpdaterChan = make(chan graph.Identifier, 500)
 
-	g.AddEventListener(mapper)
+	g.AddEventListenerWithPriority(mapper, graph.ListenerPriorityFromConfig("neutron", graph.DerivationListenerPriority))
 
 	return mapper, nil
 }
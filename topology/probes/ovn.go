@@ -0,0 +1,391 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/socketplane/libovsdb"
+
+	"github.com/redhat-cip/skydive/config"
+	"github.com/redhat-cip/skydive/logging"
+	"github.com/redhat-cip/skydive/ovs"
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+const ovnNorthbound = "OVN_Northbound"
+
+// tables of the OVN northbound database and the Type of the nodes
+// modeling their rows
+var ovnTypes = map[string]string{
+	"Logical_Switch":      "logical_switch",
+	"Logical_Switch_Port": "logical_switch_port",
+	"Logical_Router":      "logical_router",
+	"Logical_Router_Port": "logical_router_port",
+	"ACL":                 "acl",
+	"Load_Balancer":       "load_balancer",
+}
+
+// columns referencing the rows owned by the rows of a table
+var ovnOwnedColumns = map[string][]string{
+	"Logical_Switch": {"ports", "acls", "load_balancer"},
+	"Logical_Router": {"ports", "load_balancer"},
+}
+
+// OvnProbe models the logical network of the OVN northbound database. The
+// logical switch ports are linked to the OVS interfaces realizing them,
+// the ones having their name as iface-id external id.
+type OvnProbe struct {
+	sync.Mutex
+	graph.DefaultGraphListener
+	Graph   *graph.Graph
+	Monitor *ovsdb.DatabaseMonitor
+	nodes   map[string]*graph.Node
+}
+
+type ovnRow struct {
+	table  string
+	fields map[string]interface{}
+}
+
+// ovnSet returns the elements of a set column, the sets of one element
+// being sent as the element itself
+func ovnSet(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case libovsdb.OvsSet:
+		return v.GoSet
+	}
+	return []interface{}{v}
+}
+
+func ovnStrings(v interface{}) []string {
+	l := []string{}
+	for _, e := range ovnSet(v) {
+		if s, ok := e.(string); ok {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+// ovnString returns the value of a string column, optional or not
+func ovnString(v interface{}) string {
+	if l := ovnStrings(v); len(l) > 0 {
+		return l[0]
+	}
+	return ""
+}
+
+func ovnUUIDs(v interface{}) []string {
+	l := []string{}
+	for _, e := range ovnSet(v) {
+		if u, ok := e.(libovsdb.UUID); ok {
+			l = append(l, u.GoUUID)
+		}
+	}
+	return l
+}
+
+func ovnMap(v interface{}) map[string]string {
+	m := make(map[string]string)
+	if om, ok := v.(libovsdb.OvsMap); ok {
+		for k, v := range om.GoMap {
+			if ks, ok := k.(string); ok {
+				m[ks] = fmt.Sprintf("%v", v)
+			}
+		}
+	}
+	return m
+}
+
+func addOvnList(m graph.Metadata, k string, l []string) {
+	if len(l) > 0 {
+		sort.Strings(l)
+		m[k] = strings.Join(l, ",")
+	}
+}
+
+func ovnMetadata(table string, uuid string, fields map[string]interface{}) graph.Metadata {
+	m := graph.Metadata{
+		"Type":    ovnTypes[table],
+		"UUID":    uuid,
+		"Manager": "ovn",
+	}
+
+	if name := ovnString(fields["name"]); name != "" {
+		m["Name"] = name
+	}
+
+	for k, v := range ovnMap(fields["external_ids"]) {
+		m["ExtID."+k] = v
+	}
+
+	switch table {
+	case "Logical_Switch_Port":
+		if t := ovnString(fields["type"]); t != "" {
+			m["OVN.PortType"] = t
+		}
+		if rp, ok := ovnMap(fields["options"])["router-port"]; ok {
+			m["OVN.RouterPort"] = rp
+		}
+		addOvnList(m, "OVN.Addresses", ovnStrings(fields["addresses"]))
+		addOvnList(m, "OVN.PortSecurity", ovnStrings(fields["port_security"]))
+		if up := ovnSet(fields["up"]); len(up) == 1 {
+			if up[0] == true {
+				m["State"] = "UP"
+			} else {
+				m["State"] = "DOWN"
+			}
+		}
+	case "Logical_Router_Port":
+		if mac := ovnString(fields["mac"]); mac != "" {
+			m["MAC"] = mac
+		}
+		addOvnList(m, "OVN.Networks", ovnStrings(fields["networks"]))
+		if peer := ovnString(fields["peer"]); peer != "" {
+			m["OVN.Peer"] = peer
+		}
+	case "ACL":
+		m["OVN.Direction"] = ovnString(fields["direction"])
+		m["OVN.Action"] = ovnString(fields["action"])
+		m["OVN.Match"] = ovnString(fields["match"])
+		if priority, ok := fields["priority"].(float64); ok {
+			m["OVN.Priority"] = int64(priority)
+		}
+		if _, ok := m["Name"]; !ok {
+			m["Name"] = fmt.Sprintf("%s %s", m["OVN.Action"], m["OVN.Match"])
+		}
+	case "Load_Balancer":
+		if protocol := ovnString(fields["protocol"]); protocol != "" {
+			m["OVN.Protocol"] = protocol
+		}
+		var vips []string
+		for vip, backends := range ovnMap(fields["vips"]) {
+			vips = append(vips, vip+"="+backends)
+		}
+		if len(vips) > 0 {
+			sort.Strings(vips)
+			m["OVN.VIPs"] = strings.Join(vips, " ")
+		}
+	}
+
+	return m
+}
+
+func (p *OvnProbe) setRow(table string, uuid string, fields map[string]interface{}) {
+	m := ovnMetadata(table, uuid, fields)
+
+	if n, ok := p.nodes[uuid]; ok {
+		if !reflect.DeepEqual(n.Metadata(), m) {
+			p.Graph.SetMetadata(n, m)
+		}
+		return
+	}
+
+	if n := p.Graph.NewNode(p.Graph.GenID(m), m); n != nil {
+		p.nodes[uuid] = n
+	}
+}
+
+func (p *OvnProbe) delRow(uuid string) {
+	if n, ok := p.nodes[uuid]; ok {
+		p.Graph.DelNode(n)
+		delete(p.nodes, uuid)
+	}
+}
+
+func (p *OvnProbe) link(parent *graph.Node, child *graph.Node, relationType string) {
+	if !p.Graph.AreLinked(parent, child) {
+		p.Graph.Link(parent, child, graph.Metadata{"RelationType": relationType})
+	}
+}
+
+// linkRow links the node of a row to the ones of the rows it owns, to the
+// router ports connected to it and to the interfaces realizing it
+func (p *OvnProbe) linkRow(uuid string, row ovnRow) {
+	n, ok := p.nodes[uuid]
+	if !ok {
+		return
+	}
+
+	if columns, ok := ovnOwnedColumns[row.table]; ok {
+		owned := make(map[graph.Identifier]bool)
+		for _, column := range columns {
+			for _, child := range ovnUUIDs(row.fields[column]) {
+				if c, ok := p.nodes[child]; ok {
+					p.link(n, c, "ownership")
+					owned[c.ID] = true
+				}
+			}
+		}
+
+		// rows no longer referenced, the load balancers for instance
+		for _, c := range p.Graph.LookupChildren(n, graph.Metadata{"Manager": "ovn"}) {
+			if !owned[c.ID] {
+				p.Graph.Unlink(n, c)
+			}
+		}
+	}
+
+	name, _ := n.Metadata()["Name"].(string)
+
+	switch row.table {
+	case "Logical_Switch_Port":
+		if rp, ok := n.Metadata()["OVN.RouterPort"]; ok {
+			if lrp := p.Graph.LookupFirstNode(graph.Metadata{"Type": "logical_router_port", "Name": rp}); lrp != nil {
+				p.link(n, lrp, "layer2")
+			}
+		}
+		for _, intf := range p.Graph.LookupNodes(graph.Metadata{"ExtID.iface-id": name}) {
+			p.link(n, intf, "mapping")
+		}
+	case "Logical_Router_Port":
+		for _, lsp := range p.Graph.LookupNodes(graph.Metadata{"Type": "logical_switch_port", "OVN.RouterPort": name}) {
+			p.link(lsp, n, "layer2")
+		}
+	}
+}
+
+func (p *OvnProbe) onUpdates(updates *libovsdb.TableUpdates) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Graph.Lock()
+	defer p.Graph.Unlock()
+
+	updated := make(map[string]ovnRow)
+	for table, tableUpdate := range updates.Updates {
+		if _, ok := ovnTypes[table]; !ok {
+			continue
+		}
+
+		for uuid, row := range tableUpdate.Rows {
+			if len(row.New.Fields) == 0 {
+				p.delRow(uuid)
+				continue
+			}
+
+			p.setRow(table, uuid, row.New.Fields)
+			updated[uuid] = ovnRow{table: table, fields: row.New.Fields}
+		}
+	}
+
+	// the rows of a transaction referencing each other, they are linked
+	// once all of them are in the graph
+	for uuid, row := range updated {
+		p.linkRow(uuid, row)
+	}
+}
+
+// linkInterface links an OVS interface pushed by an agent to the logical
+// switch port it realizes. Called with the graph lock held.
+func (p *OvnProbe) linkInterface(n *graph.Node) {
+	ifaceID, ok := n.Metadata()["ExtID.iface-id"].(string)
+	if !ok || n.Metadata()["Manager"] == "ovn" {
+		return
+	}
+
+	if lsp := p.Graph.LookupFirstNode(graph.Metadata{"Type": "logical_switch_port", "Name": ifaceID}); lsp != nil {
+		p.link(lsp, n, "mapping")
+	}
+}
+
+func (p *OvnProbe) OnNodeAdded(n *graph.Node) {
+	p.linkInterface(n)
+}
+
+func (p *OvnProbe) OnNodeUpdated(n *graph.Node) {
+	p.linkInterface(n)
+}
+
+func (p *OvnProbe) Start() {
+	if err := p.Monitor.Start(); err != nil {
+		logging.GetLogger().Errorf("Unable to start OVN monitoring: %s", err.Error())
+	}
+}
+
+func (p *OvnProbe) Stop() {
+	p.Graph.RemoveEventListener(p)
+	p.Monitor.Stop()
+}
+
+func init() {
+	graph.RegisterNodeSchema("logical_switch_port", graph.MetadataSchema{
+		"Name":           graph.StringMetadata,
+		"UUID":           graph.StringMetadata,
+		"OVN.PortType":   graph.StringMetadata,
+		"OVN.RouterPort": graph.StringMetadata,
+	})
+	graph.RegisterNodeSchema("acl", graph.MetadataSchema{
+		"OVN.Priority": graph.NumberMetadata,
+		"OVN.Match":    graph.StringMetadata,
+	})
+}
+
+func NewOvnProbe(g *graph.Graph, protocol string, target string) *OvnProbe {
+	p := &OvnProbe{
+		Graph: g,
+		nodes: make(map[string]*graph.Node),
+	}
+
+	tables := make([]string, 0, len(ovnTypes))
+	for table := range ovnTypes {
+		tables = append(tables, table)
+	}
+	p.Monitor = ovsdb.NewDatabaseMonitor(protocol, target, ovnNorthbound, tables, p.onUpdates)
+
+	g.AddEventListenerWithPriority(p, graph.ListenerPriorityFromConfig("ovn", graph.DerivationListenerPriority))
+
+	return p
+}
+
+func NewOvnProbeFromConfig(g *graph.Graph) (*OvnProbe, error) {
+	address := config.GetConfig().GetString("ovn.northbound")
+
+	protocol, target := ovsdbAddress(address)
+	if protocol == "" {
+		return nil, errors.New("Unknown OVN northbound database address: " + address)
+	}
+
+	p := NewOvnProbe(g, protocol, target)
+
+	if protocol == "ssl" {
+		tlsConfig, err := ovsdb.NewTLSConfig(
+			config.GetConfig().GetString("ovn.ssl.private_key"),
+			config.GetConfig().GetString("ovn.ssl.certificate"),
+			config.GetConfig().GetString("ovn.ssl.ca_cert"))
+		if err != nil {
+			return nil, err
+		}
+		p.Monitor.TLSConfig = tlsConfig
+	}
+
+	return p, nil
+}
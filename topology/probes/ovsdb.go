@@ -61,7 +61,8 @@ func (o *OvsdbProbe) OnOvsBridgeAdd(monitor *ovsdb.OvsMonitor, uuid string, row
 
 	bridge := o.Graph.LookupFirstNode(graph.Metadata{"UUID": uuid})
 	if bridge == nil {
-		bridge = o.Graph.NewNode(graph.GenID(), graph.Metadata{"Name": name, "UUID": uuid, "Type": "ovsbridge"})
+		m := graph.Metadata{"Name": name, "UUID": uuid, "Type": "ovsbridge"}
+		bridge = o.Graph.NewNode(o.Graph.GenID(m), m)
 		o.Graph.Link(o.Root, bridge, graph.Metadata{"RelationType": "ownership"})
 	}
 
@@ -177,7 +178,8 @@ func (o *OvsdbProbe) OnOvsInterfaceAdd(monitor *ovsdb.OvsMonitor, uuid string, r
 	}
 
 	if intf == nil {
-		intf = o.Graph.NewNode(graph.GenID(), graph.Metadata{"Name": name, "UUID": uuid})
+		m := graph.Metadata{"Name": name, "UUID": uuid}
+		intf = o.Graph.NewNode(o.Graph.GenID(m), m)
 	} else if index > 0 {
 		// the index can be added after the interface creation, during an update so
 		// we need to check whether a interface with the same index exists at the first level
@@ -262,6 +264,13 @@ func (o *OvsdbProbe) OnOvsInterfaceAdd(monitor *ovsdb.OvsMonitor, uuid string, r
 				}
 			}
 		}
+
+	case "dpdk":
+		// the device of the port in the DPDK process of Open vSwitch
+		m := row.New.Fields["options"].(libovsdb.OvsMap)
+		if devargs, ok := m.GoMap["dpdk-devargs"]; ok {
+			tr.AddMetadata("DPDK.Devargs", devargs.(string))
+		}
 	}
 
 	/* set pending interface for a port */
@@ -304,11 +313,12 @@ func (o *OvsdbProbe) OnOvsPortAdd(monitor *ovsdb.OvsMonitor, uuid string, row *l
 
 	port, ok := o.uuidToPort[uuid]
 	if !ok {
-		port = o.Graph.NewNode(graph.GenID(), graph.Metadata{
+		m := graph.Metadata{
 			"UUID": uuid,
 			"Name": row.New.Fields["name"].(string),
 			"Type": "ovsport",
-		})
+		}
+		port = o.Graph.NewNode(o.Graph.GenID(m), m)
 		o.uuidToPort[uuid] = port
 	}
 
@@ -406,6 +416,19 @@ func (o *OvsdbProbe) Stop() {
 	o.OvsMon.StopMonitoring()
 }
 
+func init() {
+	graph.RegisterNodeSchema("ovsbridge", graph.MetadataSchema{
+		"Name": graph.StringMetadata,
+		"UUID": graph.StringMetadata,
+	})
+	graph.RegisterNodeSchema("ovsport", graph.MetadataSchema{
+		"Name":     graph.StringMetadata,
+		"UUID":     graph.StringMetadata,
+		"BondMode": graph.StringMetadata,
+		"LACP":     graph.StringMetadata,
+	})
+}
+
 func NewOvsdbProbe(g *graph.Graph, n *graph.Node, p string, t string) *OvsdbProbe {
 	o := &OvsdbProbe{
 		Graph:           g,
@@ -421,19 +444,27 @@ func NewOvsdbProbe(g *graph.Graph, n *graph.Node, p string, t string) *OvsdbProb
 	return o
 }
 
-func NewOvsdbProbeFromConfig(g *graph.Graph, n *graph.Node) *OvsdbProbe {
-	address := config.GetConfig().GetString("ovs.ovsdb")
-
-	var protocol string
-	var target string
+// ovsdbAddress returns the protocol and the target of an OVSDB server
+// address, ex: unix:///var/run/openvswitch/db.sock, or in the Open vSwitch
+// format, ex: ssl:192.168.0.1:6640. The protocol is empty if unknown.
+func ovsdbAddress(address string) (string, string) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "ssl://"):
+		return "ssl", strings.TrimPrefix(address, "ssl://")
+	case strings.HasPrefix(address, "unix:"), strings.HasPrefix(address, "tcp:"), strings.HasPrefix(address, "ssl:"):
+		fields := strings.SplitN(address, ":", 2)
+		return fields[0], fields[1]
+	}
+	return "", ""
+}
 
-	if strings.HasPrefix(address, "unix://") {
-		target = strings.TrimPrefix(address, "unix://")
-		protocol = "unix"
-	} else if strings.HasPrefix(address, "tcp://") {
-		target = strings.TrimPrefix(address, "tcp://")
-		protocol = "tcp"
-	} else {
+func NewOvsdbProbeFromConfig(g *graph.Graph, n *graph.Node) *OvsdbProbe {
+	protocol, target := ovsdbAddress(config.GetConfig().GetString("ovs.ovsdb"))
+	if protocol == "" {
 		// fallback to the original address format addr:port
 		addr, port, err := config.GetHostPortAttributes("ovs", "ovsdb")
 		if err != nil {
@@ -445,5 +476,19 @@ func NewOvsdbProbeFromConfig(g *graph.Graph, n *graph.Node) *OvsdbProbe {
 		target = fmt.Sprintf("%s:%d", addr, port)
 	}
 
-	return NewOvsdbProbe(g, n, protocol, target)
+	probe := NewOvsdbProbe(g, n, protocol, target)
+
+	if protocol == "ssl" {
+		tlsConfig, err := ovsdb.NewTLSConfig(
+			config.GetConfig().GetString("ovs.ssl.private_key"),
+			config.GetConfig().GetString("ovs.ssl.certificate"),
+			config.GetConfig().GetString("ovs.ssl.ca_cert"))
+		if err != nil {
+			logging.GetLogger().Errorf("Configuration error, OVSDB SSL: %s", err.Error())
+			return nil
+		}
+		probe.OvsMon.TLSConfig = tlsConfig
+	}
+
+	return probe
 }
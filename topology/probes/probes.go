@@ -59,6 +59,14 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node) *TopologyPr
 			probes[t] = NewOvsdbProbeFromConfig(g, n)
 		case "docker":
 			probes[t] = NewDockerProbeFromConfig(g, n)
+		case "k8s":
+			probes[t] = NewK8sProbeFromConfig(g)
+		case "libvirt":
+			probes[t] = NewLibvirtProbeFromConfig(g, n)
+		case "lldp":
+			probes[t] = NewLLDPProbeFromConfig(g, n)
+		case "fdb":
+			probes[t] = NewFDBProbeFromConfig(g, n)
 		case "neutron":
 			neutron, err := NewNeutronMapperFromConfig(g)
 			if err != nil {
@@ -76,3 +84,41 @@ func NewTopologyProbeBundleFromConfig(g *graph.Graph, n *graph.Node) *TopologyPr
 
 	return &TopologyProbeBundle{*p}
 }
+
+// NewAnalyzerTopologyProbeBundleFromConfig returns the probes running on the
+// analyzer, enhancing the nodes pushed by all the agents
+func NewAnalyzerTopologyProbeBundleFromConfig(g *graph.Graph) *TopologyProbeBundle {
+	list := config.GetConfig().GetStringSlice("analyzer.topology.probes")
+
+	logging.GetLogger().Infof("Analyzer topology probes: %v", list)
+
+	probes := make(map[string]probe.Probe)
+	for _, t := range list {
+		if _, ok := probes[t]; ok {
+			continue
+		}
+
+		switch t {
+		case "neutron":
+			neutron, err := NewNeutronMapperFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize Neutron probe: %s", err.Error())
+				continue
+			}
+			probes[t] = neutron
+		case "ovn":
+			ovn, err := NewOvnProbeFromConfig(g)
+			if err != nil {
+				logging.GetLogger().Errorf("Failed to initialize OVN probe: %s", err.Error())
+				continue
+			}
+			probes[t] = ovn
+		default:
+			logging.GetLogger().Errorf("unknown analyzer probe type %s", t)
+		}
+	}
+
+	p := probe.NewProbeBundle(probes)
+
+	return &TopologyProbeBundle{*p}
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	"github.com/redhat-cip/skydive/topology/graph"
+)
+
+// attributes of the virtual functions of the SR-IOV physical functions,
+// missing from the netlink package
+const (
+	iflaExtMask     = 29
+	rtextFilterVF   = 1
+	iflaVfMac       = 1
+	iflaVfVlan      = 2
+	iflaVfSpoofchk  = 4
+	iflaVfLinkState = 5
+	iflaVfTrust     = 9
+)
+
+var vfLinkStates = []string{"auto", "enable", "disable"}
+
+// sriovEdge is the metadata of the edges between a physical function and
+// its virtual functions, and between a virtual function and its interface
+var sriovEdge = graph.Metadata{"RelationType": "layer2", "Type": "sriov"}
+
+// vfsMetadata returns the settings of the virtual functions of a physical
+// function, read from the VF info of the link
+func vfsMetadata(index int64) []graph.Metadata {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(iflaExtMask, nl.Uint32Attr(rtextFilterVF)))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil || len(msgs) == 0 {
+		return nil
+	}
+
+	attrs, err := nl.ParseRouteAttr(msgs[0][msg.Len():])
+	if err != nil {
+		return nil
+	}
+
+	var vfs []graph.Metadata
+	for _, attr := range attrs {
+		if attr.Attr.Type != nl.IFLA_VFINFO_LIST {
+			continue
+		}
+
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil
+		}
+
+		for _, info := range infos {
+			if info.Attr.Type != nl.IFLA_VF_INFO {
+				continue
+			}
+
+			data, err := nl.ParseRouteAttr(info.Value)
+			if err != nil {
+				continue
+			}
+			vfs = append(vfs, vfMetadata(data))
+		}
+	}
+
+	return vfs
+}
+
+// vfMetadata decodes the settings of a virtual function, each attribute
+// starting with the number of the virtual function
+func vfMetadata(data []syscall.NetlinkRouteAttr) graph.Metadata {
+	m := graph.Metadata{"Type": "vf"}
+
+	for _, attr := range data {
+		v := attr.Value
+		if len(v) < 8 {
+			continue
+		}
+
+		switch attr.Attr.Type {
+		case iflaVfMac, iflaVfVlan, iflaVfSpoofchk, iflaVfLinkState, iflaVfTrust:
+			m["SRIOV.VF"] = int64(binary.LittleEndian.Uint32(v[0:4]))
+		default:
+			continue
+		}
+
+		switch attr.Attr.Type {
+		case iflaVfMac:
+			if len(v) >= 10 {
+				if mac := net.HardwareAddr(v[4:10]).String(); mac != "00:00:00:00:00:00" {
+					m["MAC"] = mac
+				}
+			}
+		case iflaVfVlan:
+			if vlan := binary.LittleEndian.Uint32(v[4:8]); vlan != 0 {
+				m["Vlan"] = int64(vlan)
+			}
+			if len(v) >= 12 {
+				if qos := binary.LittleEndian.Uint32(v[8:12]); qos != 0 {
+					m["SRIOV.Qos"] = int64(qos)
+				}
+			}
+		case iflaVfSpoofchk:
+			m["SRIOV.SpoofCheck"] = onOff(binary.LittleEndian.Uint32(v[4:8]) == 1)
+		case iflaVfLinkState:
+			if state := binary.LittleEndian.Uint32(v[4:8]); int(state) < len(vfLinkStates) {
+				m["SRIOV.LinkState"] = vfLinkStates[state]
+			}
+		case iflaVfTrust:
+			m["SRIOV.Trust"] = onOff(binary.LittleEndian.Uint32(v[4:8]) == 1)
+		}
+	}
+
+	return m
+}
+
+// vfPCIAddress returns the PCI address of a virtual function, the one
+// reported by ethtool as bus info for its interface
+func vfPCIAddress(pf string, vf int64) string {
+	target, err := os.Readlink(fmt.Sprintf("/sys/class/net/%s/device/virtfn%d", pf, vf))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// handleIntfIsPF adds the virtual functions of a physical function, linked
+// to it and to their interfaces, or removes them once disabled
+func (u *NetLinkProbe) handleIntfIsPF(intf *graph.Node, link netlink.Link) {
+	name := link.Attrs().Name
+	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/device/sriov_totalvfs", name)); err != nil {
+		return
+	}
+
+	current := make(map[int64]*graph.Node)
+	for _, vf := range u.Graph.LookupChildren(intf, graph.Metadata{"Type": "vf"}) {
+		if i, ok := vf.Metadata()["SRIOV.VF"].(int64); ok {
+			current[i] = vf
+		}
+	}
+
+	for _, m := range vfsMetadata(int64(link.Attrs().Index)) {
+		i, ok := m["SRIOV.VF"].(int64)
+		if !ok {
+			continue
+		}
+
+		m["Name"] = fmt.Sprintf("%s-vf%d", name, i)
+		m["SRIOV.PF"] = name
+		if pci := vfPCIAddress(name, i); pci != "" {
+			m["SRIOV.PCIAddress"] = pci
+		}
+
+		vf, ok := current[i]
+		if ok {
+			delete(current, i)
+			if !reflect.DeepEqual(vf.Metadata(), m) {
+				u.Graph.SetMetadata(vf, m)
+			}
+		} else {
+			vf = u.Graph.NewNode(u.Graph.GenID(m), m)
+			u.Graph.Link(u.Root, vf, graph.Metadata{"RelationType": "ownership"})
+			u.Graph.Link(intf, vf, sriovEdge)
+		}
+
+		// the interface of the virtual function, in any namespace
+		if pci, ok := m["SRIOV.PCIAddress"]; ok {
+			for _, n := range u.Graph.LookupNodes(graph.Metadata{"Ethtool.BusInfo": pci}) {
+				if !u.Graph.AreLinked(vf, n) {
+					u.Graph.Link(vf, n, sriovEdge)
+				}
+			}
+		}
+	}
+
+	for _, vf := range current {
+		u.Graph.DelNode(vf)
+	}
+}
+
+// handleIntfIsVF links the interface of a virtual function to it
+func (u *NetLinkProbe) handleIntfIsVF(intf *graph.Node) {
+	pci, ok := intf.Metadata()["Ethtool.BusInfo"]
+	if !ok {
+		return
+	}
+
+	for _, vf := range u.Graph.LookupNodes(graph.Metadata{"Type": "vf", "SRIOV.PCIAddress": pci}) {
+		if !u.Graph.AreLinked(vf, intf) {
+			u.Graph.Link(vf, intf, sriovEdge)
+		}
+	}
+}